@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ddworken/hishtory/backend/server/internal/database"
+	"github.com/ddworken/hishtory/shared"
+	"github.com/rodaine/table"
+)
+
+const adminUsage = `Usage: hishtory-server admin <command>
+
+Commands:
+  users [--limit N] [--offset N]           List users with installed devices, 1000 at a time by default
+  user <user_id>                           Show the devices and storage usage for a user
+  erase-user <user_id>                     Permanently delete all data for a user (e.g. for a GDPR erasure request)
+  expire-devices [--dry-run] <num_days>    Uninstall devices that haven't been used in the given number of days
+`
+
+// Runs `hishtory-server admin`, which manages per-user data directly against the DB configured via the same
+// environment variables as the server
+func runAdminCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Print(adminUsage)
+		return fmt.Errorf("missing admin command")
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "users":
+		flags := flag.NewFlagSet("users", flag.ExitOnError)
+		limit := flags.Int("limit", 1000, "The maximum number of users to list")
+		offset := flags.Int("offset", 0, "The number of users to skip, for listing the users after a previous page")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *limit <= 0 || *offset < 0 {
+			return fmt.Errorf("usage: hishtory-server admin users [--limit N] [--offset N]")
+		}
+		users, err := InitDB().ListUsers(ctx, *limit, *offset)
+		if err != nil {
+			return err
+		}
+		tbl := table.New("User ID", "Num Devices", "Registration Date", "Last Used")
+		for _, user := range users {
+			tbl.AddRow(user.UserId, user.NumDevices, user.RegistrationDate.Format(shared.DateOnly), user.LastUsed.Format(shared.DateOnly))
+		}
+		tbl.Print()
+		if len(users) == *limit {
+			fmt.Printf("Run with --offset %d to list the next page of users\n", *offset+*limit)
+		}
+		return nil
+	case "user":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: hishtory-server admin user <user_id>")
+		}
+		return printAdminUserInfo(ctx, InitDB(), args[1])
+	case "erase-user":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: hishtory-server admin erase-user <user_id>")
+		}
+		numDeleted, err := InitDB().EraseUser(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Erased user %s (deleted %d rows)\n", args[1], numDeleted)
+		return nil
+	case "expire-devices":
+		flags := flag.NewFlagSet("expire-devices", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "Only list the devices that would be expired")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: hishtory-server admin expire-devices [--dry-run] <num_days>")
+		}
+		inactiveDays, err := strconv.Atoi(flags.Arg(0))
+		if err != nil || inactiveDays <= 0 {
+			return fmt.Errorf("num_days must be a positive integer, got %#v", flags.Arg(0))
+		}
+		cutoff := time.Now().Add(-time.Duration(inactiveDays) * 24 * time.Hour)
+		expired, err := InitDB().ExpireStaleDevices(ctx, cutoff, *dryRun)
+		if err != nil {
+			return err
+		}
+		tbl := table.New("User ID", "Device ID", "Last Used")
+		for _, device := range expired {
+			tbl.AddRow(device.UserId, device.DeviceId, device.LastUsed.Format(shared.DateOnly))
+		}
+		tbl.Print()
+		if *dryRun {
+			fmt.Printf("Would expire %d devices\n", len(expired))
+		} else {
+			fmt.Printf("Expired %d devices\n", len(expired))
+		}
+		return nil
+	default:
+		fmt.Print(adminUsage)
+		return fmt.Errorf("unknown admin command %#v", args[0])
+	}
+}
+
+func printAdminUserInfo(ctx context.Context, db *database.DB, userId string) error {
+	devices, err := db.ListDevicesForUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	storage, err := db.StorageForUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	storageByDevice := make(map[string]database.DeviceStorage)
+	for _, s := range storage {
+		storageByDevice[s.DeviceId] = s
+	}
+	tbl := table.New("Device ID", "Registration Date", "Last Used", "Last IP", "Version", "Num Entries", "Storage Bytes")
+	for _, device := range devices {
+		s := storageByDevice[device.DeviceId]
+		tbl.AddRow(device.DeviceId, device.RegistrationDate.Format(shared.DateOnly), device.LastUsed.Format(shared.DateOnly), device.LastIp, device.Version, s.NumEntries, s.StorageBytes)
+	}
+	tbl.Print()
+	return nil
+}
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ddworken/hishtory/shared"
+)
+
+// A summary of a user and their installed devices, used by the admin API
+type UserSummary struct {
+	UserId           string    `json:"user_id"`
+	NumDevices       int       `json:"num_devices"`
+	RegistrationDate time.Time `json:"registration_date"`
+	LastUsed         time.Time `json:"last_used"`
+}
+
+// An installed device along with when it last contacted the backend, used by the admin API
+type DeviceSummary struct {
+	DeviceId         string    `json:"device_id"`
+	RegistrationDate time.Time `json:"registration_date"`
+	LastUsed         time.Time `json:"last_used"`
+	LastIp           string    `json:"last_ip"`
+	Version          string    `json:"version"`
+}
+
+// The installed devices along with when each was last used (or registered, if it has never been used). A device may
+// be registered multiple times (e.g. if it was re-initialized), in which case its registrations are grouped together.
+// The first parameter filters to a single user, or is the empty string for all users.
+const installedDeviceSummariesQuery = `
+	SELECT
+		d.user_id,
+		d.device_id,
+		MIN(d.registration_date) AS registration_date,
+		CASE WHEN MAX(u.last_used) > MIN(d.registration_date) THEN MAX(u.last_used) ELSE MIN(d.registration_date) END AS last_used,
+		MAX(u.last_ip) AS last_ip,
+		MAX(u.version) AS version
+	FROM devices d
+	LEFT JOIN usage_data u ON u.user_id = d.user_id AND u.device_id = d.device_id
+	WHERE (d.uninstall_date IS NULL OR d.uninstall_date < '1971-01-01') AND (@user_id = '' OR d.user_id = @user_id)
+	GROUP BY d.user_id, d.device_id`
+
+// Runs a query that selects the columns of installedDeviceSummariesQuery, and returns the device summaries along with
+// the user that each device belongs to
+func (db *DB) queryDeviceSummaries(ctx context.Context, query string, args ...any) ([]string, []DeviceSummary, error) {
+	rows, err := db.WithContext(ctx).Raw(query, args...).Rows()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query device summaries: %w", err)
+	}
+	defer rows.Close()
+	userIds := make([]string, 0)
+	summaries := make([]DeviceSummary, 0)
+	for rows.Next() {
+		var userId string
+		var summary DeviceSummary
+		var registrationDate, lastUsed aggregateTime
+		var lastIp, version sql.NullString
+		if err := rows.Scan(&userId, &summary.DeviceId, &registrationDate, &lastUsed, &lastIp, &version); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan device summary: %w", err)
+		}
+		summary.RegistrationDate = registrationDate.Time
+		summary.LastUsed = lastUsed.Time
+		summary.LastIp = lastIp.String
+		summary.Version = version.String
+		userIds = append(userIds, userId)
+		summaries = append(summaries, summary)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to iterate over device summaries: %w", err)
+	}
+	return userIds, summaries, nil
+}
+
+// Lists a page of the users with at least one installed device, ordered by registration date
+func (db *DB) ListUsers(ctx context.Context, limit, offset int) ([]UserSummary, error) {
+	rows, err := db.WithContext(ctx).Raw(`
+	SELECT user_id, COUNT(*) AS num_devices, MIN(registration_date) AS registration_date, MAX(last_used) AS last_used
+	FROM (`+installedDeviceSummariesQuery+`) device_summaries
+	GROUP BY user_id
+	ORDER BY registration_date, user_id
+	LIMIT @limit OFFSET @offset`, sql.Named("user_id", ""), sql.Named("limit", limit), sql.Named("offset", offset)).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+	users := make([]UserSummary, 0)
+	for rows.Next() {
+		var user UserSummary
+		var registrationDate, lastUsed aggregateTime
+		if err := rows.Scan(&user.UserId, &user.NumDevices, &registrationDate, &lastUsed); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		user.RegistrationDate = registrationDate.Time
+		user.LastUsed = lastUsed.Time
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over users: %w", err)
+	}
+	return users, nil
+}
+
+// Lists the installed devices for the given user
+func (db *DB) ListDevicesForUser(ctx context.Context, userId string) ([]DeviceSummary, error) {
+	_, devices, err := db.queryDeviceSummaries(ctx, installedDeviceSummariesQuery+" ORDER BY registration_date", sql.Named("user_id", userId))
+	return devices, err
+}
+
+// Permanently deletes everything stored for the given user, including their device registrations, usage data,
+// feedback, and shared entries. Unlike DeleteUser (which keeps the device registrations so that the user can re-upload
+// their history), this is intended for GDPR erasure requests.
+func (db *DB) EraseUser(ctx context.Context, userId string) (int64, error) {
+	numDeleted, err := db.DeleteUser(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	r1 := db.WithContext(ctx).Where("user_id = ?", userId).Delete(&Device{})
+	if r1.Error != nil {
+		return 0, fmt.Errorf("EraseUser: failed to delete devices: %w", r1.Error)
+	}
+	r2 := db.WithContext(ctx).Where("user_id = ?", userId).Delete(&UsageData{})
+	if r2.Error != nil {
+		return 0, fmt.Errorf("EraseUser: failed to delete usage data: %w", r2.Error)
+	}
+	r3 := db.WithContext(ctx).Where("user_id = ?", userId).Delete(&shared.Feedback{})
+	if r3.Error != nil {
+		return 0, fmt.Errorf("EraseUser: failed to delete feedback: %w", r3.Error)
+	}
+	r4 := db.WithContext(ctx).Where("user_id = ?", userId).Delete(&shared.SharedEntries{})
+	if r4.Error != nil {
+		return 0, fmt.Errorf("EraseUser: failed to delete shared entries: %w", r4.Error)
+	}
+	return numDeleted + r1.RowsAffected + r2.RowsAffected + r3.RowsAffected + r4.RowsAffected, nil
+}
+
+// An installed device that hasn't contacted the backend recently
+type StaleDevice struct {
+	UserId   string    `json:"user_id"`
+	DeviceId string    `json:"device_id"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Uninstalls every device that hasn't contacted the backend since the given cutoff, which deletes the entries and
+// deletion requests that were queued up for it. If dryRun is true, the stale devices are only returned.
+func (db *DB) ExpireStaleDevices(ctx context.Context, cutoff time.Time, dryRun bool) ([]StaleDevice, error) {
+	userIds, devices, err := db.queryDeviceSummaries(ctx, "SELECT * FROM ("+installedDeviceSummariesQuery+") device_summaries WHERE last_used < @cutoff ORDER BY last_used", sql.Named("user_id", ""), sql.Named("cutoff", cutoff))
+	if err != nil {
+		return nil, err
+	}
+	stale := make([]StaleDevice, 0, len(devices))
+	for i, device := range devices {
+		stale = append(stale, StaleDevice{UserId: userIds[i], DeviceId: device.DeviceId, LastUsed: device.LastUsed})
+	}
+	if dryRun {
+		return stale, nil
+	}
+	for _, device := range stale {
+		if _, err := db.UninstallDevice(ctx, device.UserId, device.DeviceId); err != nil {
+			return nil, fmt.Errorf("failed to expire device %s: %w", device.DeviceId, err)
+		}
+	}
+	return stale, nil
+}
+
+// A user who hasn't used any of their devices recently
+type InactiveUser struct {
+	UserId     string    `json:"user_id"`
+	NumDevices int       `json:"num_devices"`
+	LastActive time.Time `json:"last_active"`
+}
+
+// Returns every user whose devices (including uninstalled ones) have all been inactive since the given cutoff. A
+// device is active as of when it was registered or when it last contacted the backend, whichever is later.
+func (db *DB) InactiveUsers(ctx context.Context, cutoff time.Time) ([]InactiveUser, error) {
+	rows, err := db.WithContext(ctx).Raw(`
+	SELECT user_id, COUNT(DISTINCT device_id) AS num_devices, MAX(active_at) AS last_active
+	FROM (
+		SELECT user_id, device_id, registration_date AS active_at FROM devices
+		UNION ALL
+		SELECT user_id, NULL, last_used FROM usage_data WHERE user_id IN (SELECT user_id FROM devices)
+	) activity
+	GROUP BY user_id
+	HAVING MAX(active_at) < ?
+	ORDER BY last_active`, cutoff).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inactive users: %w", err)
+	}
+	defer rows.Close()
+	inactive := make([]InactiveUser, 0)
+	for rows.Next() {
+		var user InactiveUser
+		var lastActive aggregateTime
+		if err := rows.Scan(&user.UserId, &user.NumDevices, &lastActive); err != nil {
+			return nil, fmt.Errorf("failed to scan inactive user: %w", err)
+		}
+		user.LastActive = lastActive.Time
+		inactive = append(inactive, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over inactive users: %w", err)
+	}
+	return inactive, nil
+}
+
+// A timestamp computed by an aggregate function. Postgres returns these as a time.Time, but sqlite doesn't know the
+// type of an aggregate and so returns the string that the timestamp was stored as.
+type aggregateTime struct {
+	time.Time
+}
+
+func (t *aggregateTime) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		return t.Scan(string(v))
+	case string:
+		for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano, "2006-01-02 15:04:05.999999999"} {
+			if parsed, err := time.Parse(layout, v); err == nil {
+				t.Time = parsed
+				return nil
+			}
+		}
+		return fmt.Errorf("failed to parse timestamp %#v", v)
+	default:
+		return fmt.Errorf("unexpected type %T for timestamp", value)
+	}
+}
+
+// Erases all of the data for users that have been inactive since the given cutoff (see InactiveUsers), and returns
+// the users that were purged. If dryRun is true, the inactive users are only returned.
+func (db *DB) PurgeInactiveUsers(ctx context.Context, cutoff time.Time, dryRun bool) ([]InactiveUser, error) {
+	inactive, err := db.InactiveUsers(ctx, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return inactive, nil
+	}
+	for _, user := range inactive {
+		if _, err := db.EraseUser(ctx, user.UserId); err != nil {
+			return nil, fmt.Errorf("failed to purge inactive user %s: %w", user.UserId, err)
+		}
+	}
+	return inactive, nil
+}
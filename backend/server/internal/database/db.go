@@ -4,10 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/ddworken/hishtory/shared"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4/stdlib"
 	_ "github.com/lib/pq"
 	sqltrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql"
@@ -44,8 +44,11 @@ func OpenPostgres(dsn string, config *gorm.Config) (*DB, error) {
 	return &DB{db}, nil
 }
 
-func (db *DB) AddDatabaseTables() error {
-	models := []any{
+// The models that are stored in the DB, whose tables are created by the first migration. Note that adding a field to
+// one of these requires a new migration that calls AutoMigrate for the model, since servers that already applied the
+// first migration won't otherwise get the new column.
+func allModels() []any {
+	return []any{
 		&shared.EncHistoryEntry{},
 		&Device{},
 		&UsageData{},
@@ -53,47 +56,8 @@ func (db *DB) AddDatabaseTables() error {
 		&shared.DeletionRequest{},
 		&shared.Feedback{},
 		&ActiveUserStats{},
+		&AppliedSyncBatch{},
 	}
-
-	for _, model := range models {
-		if err := db.AutoMigrate(model); err != nil {
-			return fmt.Errorf("db.AutoMigrate: %w", err)
-		}
-	}
-
-	return nil
-}
-
-func (db *DB) CreateIndices() error {
-	// Note: If adding a new index here, consider manually running it on the prod DB using CONCURRENTLY to
-	// make server startup non-blocking. The benefit of this function is primarily for other people so they
-	// don't have to manually create these indexes.
-	// Example:
-	// CREATE INDEX CONCURRENTLY IF NOT EXISTS redact_idx ON enc_history_entries USING btree(user_id, device_id, date)
-	indices := []struct {
-		name    string
-		table   string
-		columns []string
-	}{
-		{"entry_id_idx", "enc_history_entries", []string{"encrypted_id"}},
-		{"device_id_idx", "enc_history_entries", []string{"device_id"}},
-		{"read_count_idx", "enc_history_entries", []string{"read_count"}},
-		{"redact_idx", "enc_history_entries", []string{"user_id", "device_id", "date"}},
-		{"del_user_idx", "deletion_requests", []string{"user_id"}},
-	}
-	for _, index := range indices {
-		sql := ""
-		if db.Name() == "sqlite" {
-			sql = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", index.name, index.table, strings.Join(index.columns, ","))
-		} else {
-			sql = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING btree(%s)", index.name, index.table, strings.Join(index.columns, ","))
-		}
-		r := db.Exec(sql)
-		if r.Error != nil {
-			return fmt.Errorf("failed to execute index creation sql=%#v: %w", index, r.Error)
-		}
-	}
-	return nil
 }
 
 func (db *DB) Close() error {
@@ -133,6 +97,28 @@ func (db *DB) SetMaxIdleConns(n int) error {
 	return nil
 }
 
+func (db *DB) SetMaxOpenConns(n int) error {
+	rawDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+
+	rawDB.SetMaxOpenConns(n)
+
+	return nil
+}
+
+func (db *DB) SetConnMaxLifetime(d time.Duration) error {
+	rawDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+
+	rawDB.SetConnMaxLifetime(d)
+
+	return nil
+}
+
 func (db *DB) Stats() (sql.DBStats, error) {
 	rawDB, err := db.DB.DB()
 	if err != nil {
@@ -236,8 +222,77 @@ func (db *DB) UninstallDevice(ctx context.Context, userId, deviceId string) (int
 	return r1.RowsAffected + r2.RowsAffected + r3.RowsAffected, nil
 }
 
+// Deletes all of the data stored for the given user and marks all of their devices as uninstalled. Used when a user
+// rotates their secret, since the old data is then re-uploaded under the new user ID.
+func (db *DB) DeleteUser(ctx context.Context, userId string) (int64, error) {
+	r1 := db.WithContext(ctx).Where("user_id = ?", userId).Delete(&shared.EncHistoryEntry{})
+	if r1.Error != nil {
+		return 0, fmt.Errorf("DeleteUser: failed to delete entries: %w", r1.Error)
+	}
+	r2 := db.WithContext(ctx).Where("user_id = ?", userId).Delete(&shared.DeletionRequest{})
+	if r2.Error != nil {
+		return 0, fmt.Errorf("DeleteUser: failed to delete deletion requests: %w", r2.Error)
+	}
+	r3 := db.WithContext(ctx).Where("user_id = ?", userId).Delete(&shared.DumpRequest{})
+	if r3.Error != nil {
+		return 0, fmt.Errorf("DeleteUser: failed to delete dump requests: %w", r3.Error)
+	}
+	r4 := db.WithContext(ctx).Where("user_id = ?", userId).Delete(&AppliedSyncBatch{})
+	if r4.Error != nil {
+		return 0, fmt.Errorf("DeleteUser: failed to delete applied batches: %w", r4.Error)
+	}
+	r := db.WithContext(ctx).Model(&Device{}).Where("user_id = ?", userId).Update("uninstall_date", time.Now().UTC())
+	if r.Error != nil {
+		return 0, fmt.Errorf("DeleteUser: failed to update uninstall_date: %w", r.Error)
+	}
+	return r1.RowsAffected + r2.RowsAffected + r3.RowsAffected, nil
+}
+
+// The number and total size of the history entries stored for a device
+type DeviceStorage struct {
+	DeviceId     string `json:"device_id"`
+	NumEntries   int64  `json:"num_entries"`
+	StorageBytes int64  `json:"storage_bytes"`
+}
+
+// The number of deletion requests that a device hasn't yet retrieved
+type DevicePendingDeletionRequests struct {
+	DeviceId    string
+	NumRequests int64
+}
+
+func (db *DB) StorageForUser(ctx context.Context, userId string) ([]DeviceStorage, error) {
+	var storage []DeviceStorage
+	tx := db.WithContext(ctx).Model(&shared.EncHistoryEntry{}).
+		Select("device_id, COUNT(*) AS num_entries, COALESCE(SUM(LENGTH(encrypted_data)), 0) AS storage_bytes").
+		Where("user_id = ?", userId).
+		Group("device_id").
+		Scan(&storage)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("tx.Error: %w", tx.Error)
+	}
+	return storage, nil
+}
+
+func (db *DB) PendingDeletionRequestsForUser(ctx context.Context, userId string) ([]DevicePendingDeletionRequests, error) {
+	var pending []DevicePendingDeletionRequests
+	tx := db.WithContext(ctx).Model(&shared.DeletionRequest{}).
+		Select("destination_device_id AS device_id, COUNT(*) AS num_requests").
+		Where("user_id = ? AND read_count = 0 AND NOT acked", userId).
+		Group("destination_device_id").
+		Scan(&pending)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("tx.Error: %w", tx.Error)
+	}
+	return pending, nil
+}
+
 func (db *DB) DeleteMessagesFromBackend(ctx context.Context, userId string, deletedMessages []shared.MessageIdentifier) (int64, error) {
-	tx := db.WithContext(ctx).Where("false")
+	return deleteMessagesFromBackend(db.WithContext(ctx), userId, deletedMessages)
+}
+
+func deleteMessagesFromBackend(db *gorm.DB, userId string, deletedMessages []shared.MessageIdentifier) (int64, error) {
+	tx := db.Where("false")
 	for _, message := range deletedMessages {
 		if userId == "" {
 			return 0, fmt.Errorf("failed to delete entry because userId is empty")
@@ -248,11 +303,11 @@ func (db *DB) DeleteMessagesFromBackend(ctx context.Context, userId string, dele
 		}
 		if message.EndTime != (time.Time{}) && message.EntryId != "" {
 			// Note that we do an OR with date or the ID matching since the ID is not always recorded for older history entries.
-			tx = tx.Or(db.WithContext(ctx).Where("user_id = ? AND (date = ? OR encrypted_id = ?)", userId, message.EndTime, message.EntryId))
+			tx = tx.Or(db.Session(&gorm.Session{NewDB: true}).Where("user_id = ? AND (date = ? OR encrypted_id = ?)", userId, message.EndTime, message.EntryId))
 		} else if message.EndTime != (time.Time{}) && message.EntryId == "" {
-			tx = tx.Or(db.WithContext(ctx).Where("user_id = ? AND (date = ?)", userId, message.EndTime))
+			tx = tx.Or(db.Session(&gorm.Session{NewDB: true}).Where("user_id = ? AND (date = ?)", userId, message.EndTime))
 		} else if message.EndTime == (time.Time{}) && message.EntryId != "" {
-			tx = tx.Or(db.WithContext(ctx).Where("user_id = ? AND (encrypted_id = ?)", userId, message.EntryId))
+			tx = tx.Or(db.Session(&gorm.Session{NewDB: true}).Where("user_id = ? AND (encrypted_id = ?)", userId, message.EntryId))
 		} else {
 			return 0, fmt.Errorf("failed to delete entry because message.EndTime=%#v and message.EntryId=%#v are both empty", message.EndTime, message.EntryId)
 		}
@@ -275,7 +330,7 @@ func (db *DB) DeletionRequestInc(ctx context.Context, userID, deviceID string) e
 
 func (db *DB) DeletionRequestsForUserAndDevice(ctx context.Context, userID, deviceID string) ([]*shared.DeletionRequest, error) {
 	var deletionRequests []*shared.DeletionRequest
-	tx := db.WithContext(ctx).Where("user_id = ? AND destination_device_id = ?", userID, deviceID).Find(&deletionRequests)
+	tx := db.WithContext(ctx).Where("user_id = ? AND destination_device_id = ? AND NOT acked", userID, deviceID).Find(&deletionRequests)
 	if tx.Error != nil {
 		return nil, fmt.Errorf("tx.Error: %w", tx.Error)
 	}
@@ -283,6 +338,21 @@ func (db *DB) DeletionRequestsForUserAndDevice(ctx context.Context, userID, devi
 	return deletionRequests, nil
 }
 
+// Records that the given device applied the deletion requests with the given IDs, so that they're no longer sent to it
+func (db *DB) AckDeletionRequests(ctx context.Context, userID, deviceID string, requestIds []string) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		r := tx.Model(&shared.DeletionRequest{}).Where("user_id = ? AND destination_device_id = ? AND request_id IN ?", userID, deviceID, requestIds).Update("acked", true)
+		if r.Error != nil {
+			return fmt.Errorf("failed to ack deletion requests: %w", r.Error)
+		}
+		r = tx.Model(&Device{}).Where("user_id = ? AND device_id = ?", userID, deviceID).Update("acks_deletion_requests", true)
+		if r.Error != nil {
+			return fmt.Errorf("failed to mark device as acking deletion requests: %w", r.Error)
+		}
+		return nil
+	})
+}
+
 func (db *DB) DeletionRequestCreate(ctx context.Context, request *shared.DeletionRequest) error {
 	userID := request.UserId
 
@@ -297,6 +367,8 @@ func (db *DB) DeletionRequestCreate(ctx context.Context, request *shared.Deletio
 
 	fmt.Printf("db.DeletionRequestCreate: Found %d devices\n", len(devices))
 
+	request.RequestId = uuid.Must(uuid.NewRandom()).String()
+	request.Acked = false
 	err = db.Transaction(func(tx *gorm.DB) error {
 		for _, device := range devices {
 			request.DestinationDeviceId = device.DeviceId
@@ -328,12 +400,60 @@ func (db *DB) FeedbackCreate(ctx context.Context, feedback *shared.Feedback) err
 	return nil
 }
 
+// How long the idempotency keys of applied batches are remembered for
+const APPLIED_SYNC_BATCH_RETENTION = 30 * 24 * time.Hour
+
+// How long a device can go without contacting the backend before deletion requests stop waiting for it to ack them
+const INACTIVE_DEVICE_CUTOFF = 90 * 24 * time.Hour
+
 func (db *DB) Clean(ctx context.Context) error {
 	r := db.WithContext(ctx).Exec("DELETE FROM enc_history_entries WHERE read_count > 10")
 	if r.Error != nil {
 		return r.Error
 	}
-	r = db.WithContext(ctx).Exec("DELETE FROM deletion_requests WHERE read_count > 100")
+	// Entries delivered via sync cursors don't have their read count incremented, instead they can be deleted once the
+	// device has acked them. The source device's own copies are kept since they're used to bootstrap new devices.
+	r = db.WithContext(ctx).Exec(`
+	DELETE FROM enc_history_entries
+	WHERE sequence_id > 0 AND NOT is_from_same_device AND sequence_id <= (
+		SELECT MAX(acked_sequence_id) FROM devices
+		WHERE devices.user_id = enc_history_entries.user_id AND devices.device_id = enc_history_entries.device_id
+	)`)
+	if r.Error != nil {
+		return r.Error
+	}
+	// Deletion requests from before acknowledgements were supported are cleaned up once they've been read many times
+	r = db.WithContext(ctx).Exec("DELETE FROM deletion_requests WHERE request_id = '' AND read_count > 100")
+	if r.Error != nil {
+		return r.Error
+	}
+	// Other deletion requests are only cleaned up once every active device they were sent to has acked them. Note that
+	// devices running versions of hishtory that can't ack requests fall back to the read count, and that devices that
+	// were uninstalled or haven't been used recently (e.g. a retired laptop) aren't waited on.
+	r = db.WithContext(ctx).Exec(`
+	DELETE FROM deletion_requests
+	WHERE request_id IN (
+		SELECT dr.request_id FROM deletion_requests dr
+		LEFT JOIN devices d ON d.user_id = dr.user_id AND d.device_id = dr.destination_device_id AND d.acks_deletion_requests
+		LEFT JOIN usage_data u ON u.user_id = dr.user_id AND u.device_id = dr.destination_device_id
+		WHERE dr.request_id != ''
+		GROUP BY dr.request_id
+		HAVING SUM(CASE
+			WHEN dr.acked THEN 0
+			WHEN d.device_id IS NULL THEN (CASE WHEN dr.read_count > 100 THEN 0 ELSE 1 END)
+			WHEN d.uninstall_date > '1971-01-01' OR COALESCE(u.last_used, d.registration_date) < ? THEN 0
+			ELSE 1
+		END) = 0
+	)`, time.Now().UTC().Add(-INACTIVE_DEVICE_CUTOFF))
+	if r.Error != nil {
+		return r.Error
+	}
+	// Clients only retry batches shortly after they fail, so there is no need to remember idempotency keys forever
+	r = db.WithContext(ctx).Where("applied_date < ?", time.Now().UTC().Add(-APPLIED_SYNC_BATCH_RETENTION)).Delete(&AppliedSyncBatch{})
+	if r.Error != nil {
+		return r.Error
+	}
+	r = db.WithContext(ctx).Where("expiration_date < ?", time.Now().UTC()).Delete(&shared.SharedEntries{})
 	if r.Error != nil {
 		return r.Error
 	}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"time"
+
+	"github.com/ddworken/hishtory/shared"
 )
 
 type Device struct {
@@ -19,6 +21,17 @@ type Device struct {
 	IsIntegrationTestDevice bool `json:"is_integration_test_device"`
 	// Whether this device was uninstalled
 	UninstallDate time.Time `json:"uninstall_date"`
+	// The friendly name of the device, encrypted by the client
+	EncryptedName []byte `json:"enc_name"`
+	NameNonce     []byte `json:"name_nonce"`
+	// Whether this device uploads and/or downloads history entries
+	SyncMode shared.SyncMode `json:"sync_mode"`
+	// The SequenceId of the most recent history entry stored for this device
+	LastSequenceId int64 `json:"last_sequence_id" gorm:"not null; default:0"`
+	// The SequenceId up to which this device has confirmed it received all history entries, via its sync cursor
+	AckedSequenceId int64 `json:"acked_sequence_id" gorm:"not null; default:0"`
+	// Whether this device acknowledges the deletion requests that it applies, which older clients don't do
+	AcksDeletionRequests bool `json:"acks_deletion_requests" gorm:"not null; default:false"`
 }
 
 func (db *DB) CountAllDevices(ctx context.Context) (int64, error) {
@@ -59,3 +72,21 @@ func (db *DB) DevicesForUser(ctx context.Context, userID string) ([]*Device, err
 
 	return devices, nil
 }
+
+func (db *DB) RenameDevice(ctx context.Context, userID, deviceID string, encryptedName, nameNonce []byte) (int64, error) {
+	tx := db.WithContext(ctx).Model(&Device{}).Where("user_id = ? AND device_id = ?", userID, deviceID).Updates(map[string]any{"encrypted_name": encryptedName, "name_nonce": nameNonce})
+	if tx.Error != nil {
+		return 0, fmt.Errorf("tx.Error: %w", tx.Error)
+	}
+
+	return tx.RowsAffected, nil
+}
+
+func (db *DB) SetDeviceSyncMode(ctx context.Context, userID, deviceID string, syncMode shared.SyncMode) (int64, error) {
+	tx := db.WithContext(ctx).Model(&Device{}).Where("user_id = ? AND device_id = ?", userID, deviceID).Update("sync_mode", syncMode)
+	if tx.Error != nil {
+		return 0, fmt.Errorf("tx.Error: %w", tx.Error)
+	}
+
+	return tx.RowsAffected, nil
+}
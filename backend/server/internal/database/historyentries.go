@@ -3,8 +3,10 @@ package database
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/ddworken/hishtory/shared"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -18,6 +20,18 @@ func (db *DB) CountApproximateHistoryEntries(ctx context.Context) (int64, error)
 	return numDbEntries, nil
 }
 
+// Returns the number of entries stored for the given user. Note that this counts each copy of an entry that is stored
+// for each of the user's devices.
+func (db *DB) CountHistoryEntriesForUser(ctx context.Context, userID string) (int64, error) {
+	var numEntries int64
+	tx := db.WithContext(ctx).Model(&shared.EncHistoryEntry{}).Where("user_id = ?", userID).Count(&numEntries)
+	if tx.Error != nil {
+		return 0, fmt.Errorf("tx.Error: %w", tx.Error)
+	}
+
+	return numEntries, nil
+}
+
 func (db *DB) AllHistoryEntriesForUser(ctx context.Context, userID string) ([]*shared.EncHistoryEntry, error) {
 	var historyEntries []*shared.EncHistoryEntry
 	tx := db.WithContext(ctx).Where("user_id = ?", userID).Find(&historyEntries)
@@ -29,6 +43,51 @@ func (db *DB) AllHistoryEntriesForUser(ctx context.Context, userID string) ([]*s
 	return historyEntries, nil
 }
 
+// Calls fn with each of the given user's entries in chronological order. The entries are read from the DB one row at
+// a time so that exporting a large history doesn't require loading it all into memory.
+func (db *DB) StreamHistoryEntriesForUser(ctx context.Context, userID string, fn func(*shared.EncHistoryEntry) error) error {
+	rows, err := db.WithContext(ctx).Model(&shared.EncHistoryEntry{}).Where("user_id = ?", userID).Order("date").Rows()
+	if err != nil {
+		return fmt.Errorf("failed to query entries: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry shared.EncHistoryEntry
+		if err := db.ScanRows(rows, &entry); err != nil {
+			return fmt.Errorf("failed to scan entry: %w", err)
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Returns the entries for the given device that were stored after the given sync cursor, ordered by their SequenceId.
+// Devices that switch from read count based delivery start from a cursor of zero, which also includes the entries
+// stored before sequence IDs were assigned that the device hasn't yet read.
+func (db *DB) HistoryEntriesForDeviceAfterCursor(ctx context.Context, userID, deviceID string, cursor int64) ([]*shared.EncHistoryEntry, error) {
+	var historyEntries []*shared.EncHistoryEntry
+	tx := db.WithContext(ctx).Where("user_id = ? AND device_id = ? AND NOT is_from_same_device", userID, deviceID)
+	if cursor == 0 {
+		tx = tx.Where("sequence_id > 0 OR read_count < ?", 5)
+	} else {
+		tx = tx.Where("sequence_id > ?", cursor)
+	}
+	tx = tx.Order("sequence_id").Find(&historyEntries)
+
+	if tx.Error != nil {
+		return nil, fmt.Errorf("tx.Error: %w", tx.Error)
+	}
+
+	return historyEntries, nil
+}
+
+// Records that the given device has received every entry up to the given sync cursor, so that they can be cleaned up
+func (db *DB) AckSequenceId(ctx context.Context, userID, deviceID string, cursor int64) error {
+	return db.WithContext(ctx).Model(&Device{}).Where("user_id = ? AND device_id = ? AND acked_sequence_id < ?", userID, deviceID, cursor).Update("acked_sequence_id", cursor).Error
+}
+
 func (db *DB) HistoryEntriesForDevice(ctx context.Context, deviceID string, limit int) ([]*shared.EncHistoryEntry, error) {
 	var historyEntries []*shared.EncHistoryEntry
 	tx := db.WithContext(ctx).Where("device_id = ? AND read_count < ? AND NOT is_from_same_device", deviceID, limit).Find(&historyEntries)
@@ -42,6 +101,20 @@ func (db *DB) HistoryEntriesForDevice(ctx context.Context, deviceID string, limi
 
 func (db *DB) AddHistoryEntries(ctx context.Context, entries ...*shared.EncHistoryEntry) error {
 	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		entries, err := filterStoredHistoryEntries(tx, entries)
+		if err != nil {
+			return err
+		}
+		entriesByDevice := make(map[[2]string][]*shared.EncHistoryEntry)
+		for _, entry := range entries {
+			key := [2]string{entry.UserId, entry.DeviceId}
+			entriesByDevice[key] = append(entriesByDevice[key], entry)
+		}
+		for key, deviceEntries := range entriesByDevice {
+			if err := assignSequenceIds(tx, key[0], key[1], deviceEntries); err != nil {
+				return err
+			}
+		}
 		for _, entry := range entries {
 			resp := tx.Create(&entry)
 			if resp.Error != nil {
@@ -53,23 +126,208 @@ func (db *DB) AddHistoryEntries(ctx context.Context, entries ...*shared.EncHisto
 }
 
 func (db *DB) AddHistoryEntriesForAllDevices(ctx context.Context, sourceDeviceId string, devices []*Device, entries []*shared.EncHistoryEntry) error {
-	chunkSize := 1000
 	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		for _, device := range devices {
-			for _, entry := range entries {
-				entry.DeviceId = device.DeviceId
-				entry.IsFromSameDevice = sourceDeviceId == device.DeviceId
+		return addHistoryEntriesForAllDevices(tx, sourceDeviceId, devices, entries)
+	})
+}
+
+func addHistoryEntriesForAllDevices(tx *gorm.DB, sourceDeviceId string, devices []*Device, entries []*shared.EncHistoryEntry) error {
+	chunkSize := 1000
+	for _, device := range devices {
+		if !device.SyncMode.CanDownload() {
+			// Write-only devices never retrieve entries, so there is no need to store a copy for them
+			continue
+		}
+		for _, entry := range entries {
+			entry.DeviceId = device.DeviceId
+			entry.IsFromSameDevice = sourceDeviceId == device.DeviceId
+		}
+		newEntries, err := filterStoredHistoryEntries(tx, entries)
+		if err != nil {
+			return err
+		}
+		if err := assignSequenceIds(tx, device.UserId, device.DeviceId, newEntries); err != nil {
+			return err
+		}
+		// Chunk the inserts to prevent the `extended protocol limited to 65535 parameters` error
+		for _, entriesChunk := range shared.Chunks(newEntries, chunkSize) {
+			resp := tx.Create(&entriesChunk)
+			if resp.Error != nil {
+				return fmt.Errorf("resp.Error: %w", resp.Error)
 			}
-			// Chunk the inserts to prevent the `extended protocol limited to 65535 parameters` error
-			for _, entriesChunk := range shared.Chunks(entries, chunkSize) {
-				resp := tx.Create(&entriesChunk)
-				if resp.Error != nil {
-					return fmt.Errorf("resp.Error: %w", resp.Error)
+		}
+	}
+	return nil
+}
+
+// Assigns the next SequenceIds for the given device to the given entries. The device's row stays locked by the update
+// until the transaction commits, so entries become visible in the same order as their SequenceIds and a device's sync
+// cursor never skips over an entry that is committed later.
+func assignSequenceIds(tx *gorm.DB, userId, deviceId string, entries []*shared.EncHistoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	r := tx.Model(&Device{}).Where("user_id = ? AND device_id = ?", userId, deviceId).Update("last_sequence_id", gorm.Expr("last_sequence_id + ?", len(entries)))
+	if r.Error != nil {
+		return fmt.Errorf("failed to allocate sequence IDs: %w", r.Error)
+	}
+	if r.RowsAffected == 0 {
+		// The device isn't registered, so nothing will ever sync these entries via a cursor
+		return nil
+	}
+	var lastSequenceId int64
+	r = tx.Model(&Device{}).Where("user_id = ? AND device_id = ?", userId, deviceId).Select("MAX(last_sequence_id)").Scan(&lastSequenceId)
+	if r.Error != nil {
+		return fmt.Errorf("failed to read allocated sequence IDs: %w", r.Error)
+	}
+	for i, entry := range entries {
+		entry.SequenceId = lastSequenceId - int64(len(entries)) + int64(i) + 1
+	}
+	return nil
+}
+
+// Returns the given entries, excluding any that are duplicates of each other or that are already stored for the same
+// user and device. Clients retry submissions that failed part-way through (and the dump flow re-sends entries), so
+// without this those retries would result in duplicate rows that each get synced to every device.
+func filterStoredHistoryEntries(tx *gorm.DB, entries []*shared.EncHistoryEntry) ([]*shared.EncHistoryEntry, error) {
+	entries = dedupPerDevice(entries)
+	type storedKey struct {
+		DeviceId    string
+		EncryptedId string
+		Nonce       []byte
+	}
+	stored := make(map[string]bool)
+	for _, chunk := range shared.Chunks(entries, 500) {
+		userIds := make(map[string]bool)
+		encryptedIds := make([]string, 0)
+		nonces := make([][]byte, 0)
+		for _, entry := range chunk {
+			userIds[entry.UserId] = true
+			if entry.EncryptedId != "" {
+				encryptedIds = append(encryptedIds, entry.EncryptedId)
+			} else {
+				nonces = append(nonces, entry.Nonce)
+			}
+		}
+		for userId := range userIds {
+			var keys []storedKey
+			q := tx.Model(&shared.EncHistoryEntry{}).Select("device_id, encrypted_id, nonce").Where("user_id = ?", userId)
+			switch {
+			case len(encryptedIds) > 0 && len(nonces) > 0:
+				q = q.Where("encrypted_id IN ? OR nonce IN ?", encryptedIds, nonces)
+			case len(encryptedIds) > 0:
+				q = q.Where("encrypted_id IN ?", encryptedIds)
+			default:
+				q = q.Where("nonce IN ?", nonces)
+			}
+			if err := q.Find(&keys).Error; err != nil {
+				return nil, fmt.Errorf("failed to check for already stored entries: %w", err)
+			}
+			for _, k := range keys {
+				e := shared.EncHistoryEntry{EncryptedId: k.EncryptedId, Nonce: k.Nonce}
+				stored[userId+"|"+k.DeviceId+"|"+e.DedupKey()] = true
+			}
+		}
+	}
+	filtered := make([]*shared.EncHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !stored[entry.UserId+"|"+entry.DeviceId+"|"+entry.DedupKey()] {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered, nil
+}
+
+// Removes duplicates within the given entries, treating copies of an entry destined for different devices as distinct
+func dedupPerDevice(entries []*shared.EncHistoryEntry) []*shared.EncHistoryEntry {
+	seen := make(map[string]bool)
+	deduped := make([]*shared.EncHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		key := entry.UserId + "|" + entry.DeviceId + "|" + entry.DedupKey()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, entry)
+	}
+	return deduped
+}
+
+// Records that a batch with a given idempotency key was applied, so that retries of it can be skipped
+type AppliedSyncBatch struct {
+	UserId         string    `gorm:"primaryKey"`
+	IdempotencyKey string    `gorm:"primaryKey"`
+	AppliedDate    time.Time `gorm:"index"`
+}
+
+// Applies the given batch in a single transaction. Returns whether the batch was skipped since a batch with the same
+// idempotency key was already applied.
+func (db *DB) ApplySyncBatch(ctx context.Context, sourceDeviceId string, devices []*Device, batch *shared.SyncBatch) (bool, error) {
+	alreadyApplied := false
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if batch.IdempotencyKey != "" {
+			var numApplied int64
+			err := tx.Model(&AppliedSyncBatch{}).Where("user_id = ? AND idempotency_key = ?", batch.UserId, batch.IdempotencyKey).Count(&numApplied).Error
+			if err != nil {
+				return fmt.Errorf("failed to check for an already applied batch: %w", err)
+			}
+			if numApplied > 0 {
+				alreadyApplied = true
+				return nil
+			}
+			// Note that if two copies of the same batch race, the primary key makes the second one fail here, so the
+			// client retries and then skips it
+			err = tx.Create(&AppliedSyncBatch{UserId: batch.UserId, IdempotencyKey: batch.IdempotencyKey, AppliedDate: time.Now().UTC()}).Error
+			if err != nil {
+				return fmt.Errorf("failed to record applied batch: %w", err)
+			}
+		}
+		if batch.DeletionRequest != nil {
+			requestId := uuid.Must(uuid.NewRandom()).String()
+			for _, device := range devices {
+				request := *batch.DeletionRequest
+				request.DestinationDeviceId = device.DeviceId
+				request.ReadCount = 0
+				request.RequestId = requestId
+				request.Acked = false
+				if err := tx.Create(&request).Error; err != nil {
+					return fmt.Errorf("failed to create deletion request: %w", err)
 				}
 			}
+			if _, err := deleteMessagesFromBackend(tx, batch.UserId, batch.DeletionRequest.Messages.Ids); err != nil {
+				return fmt.Errorf("failed to delete messages: %w", err)
+			}
+		}
+		return addHistoryEntriesForAllDevices(tx, sourceDeviceId, devices, batch.Entries)
+	})
+	return alreadyApplied, err
+}
+
+// Replaces the stored copies of the given entries with the re-encrypted versions, so that clients can gradually migrate
+// their history to a newer payload format. Copies that are already at least as new as the re-encrypted version are left
+// as is. Returns the number of stored copies that were updated.
+func (db *DB) ReencryptHistoryEntries(ctx context.Context, userID string, entries []*shared.EncHistoryEntry) (int64, error) {
+	var numUpdated int64
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, entry := range entries {
+			res := tx.Model(&shared.EncHistoryEntry{}).
+				Where("user_id = ? AND encrypted_id = ? AND payload_version < ?", userID, entry.EncryptedId, entry.PayloadVersion).
+				Updates(map[string]interface{}{
+					"encrypted_data":  entry.EncryptedData,
+					"nonce":           entry.Nonce,
+					"payload_version": entry.PayloadVersion,
+					"hashed_command":  entry.HashedCommand,
+					"hashed_cwd":      entry.HashedCwd,
+					"hashed_hostname": entry.HashedHostname,
+				})
+			if res.Error != nil {
+				return fmt.Errorf("failed to update entry: %w", res.Error)
+			}
+			numUpdated += res.RowsAffected
 		}
 		return nil
 	})
+	return numUpdated, err
 }
 
 func (db *DB) Unsafe_DeleteAllHistoryEntries(ctx context.Context) error {
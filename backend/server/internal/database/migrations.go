@@ -0,0 +1,236 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/shared"
+	"gorm.io/gorm"
+)
+
+// A versioned change to the DB schema. Migrations are applied in order of their version, and each applied migration
+// is recorded in the schema_migrations table so that it is only applied once. New migrations must be appended to
+// migrations with the next version, and must not be edited once they have been released since self-hosted servers
+// may have already applied them.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *gorm.DB) error
+	Down        func(tx *gorm.DB) error
+}
+
+// A record of an applied migration
+type SchemaMigration struct {
+	Version     int `gorm:"primaryKey;autoIncrement:false"`
+	Description string
+	AppliedAt   time.Time
+}
+
+// The status of a migration, where AppliedAt is zero if it hasn't been applied
+type MigrationStatus struct {
+	Version     int
+	Description string
+	AppliedAt   time.Time
+}
+
+type index struct {
+	name    string
+	table   string
+	columns []string
+}
+
+func (i index) create(tx *gorm.DB) error {
+	sql := ""
+	if tx.Name() == "sqlite" {
+		sql = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", i.name, i.table, strings.Join(i.columns, ","))
+	} else {
+		sql = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING btree(%s)", i.name, i.table, strings.Join(i.columns, ","))
+	}
+	if err := tx.Exec(sql).Error; err != nil {
+		return fmt.Errorf("failed to create index %s: %w", i.name, err)
+	}
+	return nil
+}
+
+func (i index) drop(tx *gorm.DB) error {
+	if err := tx.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", i.name)).Error; err != nil {
+		return fmt.Errorf("failed to drop index %s: %w", i.name, err)
+	}
+	return nil
+}
+
+// Returns a migration that creates the given indices. Note that for large tables on Postgres, it is worth manually
+// creating new indices with CONCURRENTLY before deploying so that the migration doesn't block server startup, e.g.
+// CREATE INDEX CONCURRENTLY IF NOT EXISTS redact_idx ON enc_history_entries USING btree(user_id, device_id, date)
+func createIndicesMigration(version int, description string, indices ...index) Migration {
+	return Migration{
+		Version:     version,
+		Description: description,
+		Up: func(tx *gorm.DB) error {
+			for _, i := range indices {
+				if err := i.create(tx); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, i := range indices {
+				if err := i.drop(tx); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create tables",
+		Up: func(tx *gorm.DB) error {
+			// Servers that predate versioned migrations already have these tables, in which case this only adds
+			// any missing columns
+			for _, model := range allModels() {
+				if err := tx.AutoMigrate(model); err != nil {
+					return fmt.Errorf("failed to migrate %T: %w", model, err)
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			models := allModels()
+			for i := len(models) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(models[i]); err != nil {
+					return fmt.Errorf("failed to drop table for %T: %w", models[i], err)
+				}
+			}
+			return nil
+		},
+	},
+	createIndicesMigration(2, "create indices",
+		index{"entry_id_idx", "enc_history_entries", []string{"encrypted_id"}},
+		index{"device_id_idx", "enc_history_entries", []string{"device_id"}},
+		index{"read_count_idx", "enc_history_entries", []string{"read_count"}},
+		index{"redact_idx", "enc_history_entries", []string{"user_id", "device_id", "date"}},
+		index{"sync_cursor_idx", "enc_history_entries", []string{"device_id", "sequence_id"}},
+		index{"del_user_idx", "deletion_requests", []string{"user_id"}},
+	),
+	// Composite indices for the queries run on every sync. Note that redact_idx already covers lookups by
+	// (user_id, device_id, date).
+	createIndicesMigration(3, "create composite indices for hot queries",
+		index{"device_read_count_idx", "enc_history_entries", []string{"device_id", "read_count"}},
+		index{"user_entry_id_idx", "enc_history_entries", []string{"user_id", "encrypted_id"}},
+		index{"del_user_device_idx", "deletion_requests", []string{"user_id", "destination_device_id"}},
+		index{"dump_user_idx", "dump_requests", []string{"user_id"}},
+		index{"devices_user_device_idx", "devices", []string{"user_id", "device_id"}},
+	),
+	{
+		Version:     4,
+		Description: "create shared_entries table",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&shared.SharedEntries{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&shared.SharedEntries{})
+		},
+	},
+}
+
+func (db *DB) appliedMigrations(ctx context.Context) (map[int]SchemaMigration, error) {
+	applied := make(map[int]SchemaMigration)
+	if !db.WithContext(ctx).Migrator().HasTable(&SchemaMigration{}) {
+		return applied, nil
+	}
+	var records []SchemaMigration
+	if err := db.WithContext(ctx).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// Returns the status of every migration, in order
+func (db *DB) MigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		statuses = append(statuses, MigrationStatus{Version: m.Version, Description: m.Description, AppliedAt: applied[m.Version].AppliedAt})
+	}
+	return statuses, nil
+}
+
+// Returns the number of migrations that haven't yet been applied
+func (db *DB) NumPendingMigrations(ctx context.Context) (int, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	numPending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			numPending += 1
+		}
+	}
+	return numPending, nil
+}
+
+// Applies all pending migrations in order. Each migration is applied in a transaction along with recording that it
+// was applied.
+func (db *DB) Migrate(ctx context.Context) error {
+	if err := db.WithContext(ctx).AutoMigrate(&SchemaMigration{}); err != nil {
+		return fmt.Errorf("failed to create the schema_migrations table: %w", err)
+	}
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Description: m.Description, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Description, err)
+		}
+	}
+	return nil
+}
+
+// Reverts the most recently applied migration, and returns it. Returns nil if no migrations have been applied.
+func (db *DB) RollbackMigration(ctx context.Context) (*Migration, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to roll back migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		return &m, nil
+	}
+	return nil, nil
+}
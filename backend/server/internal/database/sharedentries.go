@@ -0,0 +1,32 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ddworken/hishtory/shared"
+	"gorm.io/gorm"
+)
+
+func (db *DB) CreateSharedEntries(ctx context.Context, sharedEntries *shared.SharedEntries) error {
+	tx := db.WithContext(ctx).Create(sharedEntries)
+	if tx.Error != nil {
+		return fmt.Errorf("tx.Error: %w", tx.Error)
+	}
+	return nil
+}
+
+// Returns the shared entries with the given ID, or nil if they don't exist or have expired
+func (db *DB) GetSharedEntries(ctx context.Context, shareId string) (*shared.SharedEntries, error) {
+	var sharedEntries shared.SharedEntries
+	err := db.WithContext(ctx).Where("share_id = ? AND expiration_date > ?", shareId, time.Now().UTC()).First(&sharedEntries).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shared entries: %w", err)
+	}
+	return &sharedEntries, nil
+}
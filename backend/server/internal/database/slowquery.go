@@ -0,0 +1,56 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	slowQueryStartKey = "hishtory:slow_query_start"
+	slowQueryEndKey   = "hishtory:slow_query_end"
+)
+
+// Logs every query that takes at least the given threshold to out. Queries are logged with placeholders rather than
+// their parameters so that the log doesn't contain user IDs or encrypted history entries.
+func (db *DB) EnableSlowQueryLog(threshold time.Duration, out io.Writer) error {
+	start := func(tx *gorm.DB) {
+		tx.InstanceSet(slowQueryStartKey, time.Now())
+	}
+	end := func(tx *gorm.DB) {
+		v, ok := tx.InstanceGet(slowQueryStartKey)
+		if !ok {
+			return
+		}
+		startTime, ok := v.(time.Time)
+		if !ok {
+			return
+		}
+		if duration := time.Since(startTime); duration >= threshold {
+			fmt.Fprintf(out, "Slow query (%s, %d rows): %s\n", duration.Round(time.Millisecond), tx.Statement.RowsAffected, tx.Statement.SQL.String())
+		}
+	}
+
+	callbacks := db.Callback()
+	errs := []error{
+		callbacks.Create().Before("gorm:create").Register(slowQueryStartKey, start),
+		callbacks.Create().After("gorm:create").Register(slowQueryEndKey, end),
+		callbacks.Query().Before("gorm:query").Register(slowQueryStartKey, start),
+		callbacks.Query().After("gorm:query").Register(slowQueryEndKey, end),
+		callbacks.Update().Before("gorm:update").Register(slowQueryStartKey, start),
+		callbacks.Update().After("gorm:update").Register(slowQueryEndKey, end),
+		callbacks.Delete().Before("gorm:delete").Register(slowQueryStartKey, start),
+		callbacks.Delete().After("gorm:delete").Register(slowQueryEndKey, end),
+		callbacks.Row().Before("gorm:row").Register(slowQueryStartKey, start),
+		callbacks.Row().After("gorm:row").Register(slowQueryEndKey, end),
+		callbacks.Raw().Before("gorm:raw").Register(slowQueryStartKey, start),
+		callbacks.Raw().After("gorm:raw").Register(slowQueryEndKey, end),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to register slow query callbacks: %w", err)
+	}
+	return nil
+}
@@ -187,3 +187,12 @@ func (db *DB) DateOfLastRegistration(ctx context.Context) (string, error) {
 
 	return lastRegistration, nil
 }
+
+func (db *DB) UsageDataForUser(ctx context.Context, userId string) ([]UsageData, error) {
+	var usageData []UsageData
+	tx := db.WithContext(ctx).Where("user_id = ?", userId).Find(&usageData)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("tx.Error: %w", tx.Error)
+	}
+	return usageData, nil
+}
@@ -13,28 +13,48 @@ import (
 // TODO: Can we get rid of this bit of mutable state by changing UpdateReleaseVersion to return the latest version?
 var Version = "UNKNOWN"
 
+// The structured release notes for the latest release, if it has any
+var Notes *shared.ReleaseNotes
+
+// The latest beta (i.e. a GitHub pre-release) version, or empty if there is no valid beta that is newer than Version
+var BetaVersion = ""
+
 type releaseInfo struct {
-	Name string `json:"name"`
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	Prerelease bool   `json:"prerelease"`
 }
 
-const releaseURL = "https://api.github.com/repos/ddworken/hishtory/releases/latest"
+const (
+	releaseURL  = "https://api.github.com/repos/ddworken/hishtory/releases/latest"
+	releasesURL = "https://api.github.com/repos/ddworken/hishtory/releases"
+)
 
-func UpdateReleaseVersion() error {
-	resp, err := http.Get(releaseURL)
+// Calls the given GitHub API URL and returns the response body, or nil if the request was rate limited
+func getGithubApi(url string) ([]byte, error) {
+	resp, err := http.Get(url)
 	if err != nil {
-		return fmt.Errorf("failed to get latest release version: %w", err)
+		return nil, fmt.Errorf("failed to call github API: %w", err)
 	}
 	defer resp.Body.Close()
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read github API response body: %w", err)
+		return nil, fmt.Errorf("failed to read github API response body: %w", err)
 	}
 	if resp.StatusCode == 403 && strings.Contains(string(respBody), "API rate limit exceeded for ") {
 		fmt.Printf("skipping updating release version due to 403 rate limiting err, body=%#v\n", string(respBody))
-		return nil
+		return nil, nil
 	}
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("failed to call github API, status_code=%d, body=%#v", resp.StatusCode, string(respBody))
+		return nil, fmt.Errorf("failed to call github API, status_code=%d, body=%#v", resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
+
+func UpdateReleaseVersion() error {
+	respBody, err := getGithubApi(releaseURL)
+	if err != nil || respBody == nil {
+		return err
 	}
 	var info releaseInfo
 	err = json.Unmarshal(respBody, &info)
@@ -43,25 +63,121 @@ func UpdateReleaseVersion() error {
 	}
 	latestVersionTag := info.Name
 	Version = decrementVersionIfInvalid(latestVersionTag)
+	Notes = parseReleaseNotes(latestVersionTag, info.Body)
+	return nil
+}
+
+// Updates BetaVersion to the newest pre-release that is newer than Version and has valid binaries. Must be called
+// after UpdateReleaseVersion.
+func UpdateBetaReleaseVersion() error {
+	respBody, err := getGithubApi(releasesURL)
+	if err != nil || respBody == nil {
+		return err
+	}
+	var releases []releaseInfo
+	err = json.Unmarshal(respBody, &releases)
+	if err != nil {
+		return fmt.Errorf("failed to parse github API response: %w", err)
+	}
+	betaVersion := latestBetaVersion(releases, Version)
+	if betaVersion != "" {
+		if err := assertValidUpdate(BuildUpdateInfo(betaVersion)); err != nil {
+			fmt.Printf("Found beta %s to be an invalid version: %v\n", betaVersion, err)
+			betaVersion = ""
+		}
+	}
+	BetaVersion = betaVersion
 	return nil
 }
 
+// Returns the newest pre-release that is newer than the given stable version, or empty if there is none
+func latestBetaVersion(releases []releaseInfo, stableVersion string) string {
+	latest, err := shared.ParseVersionString(stableVersion)
+	if err != nil {
+		return ""
+	}
+	betaVersion := ""
+	for _, r := range releases {
+		if !r.Prerelease {
+			continue
+		}
+		pv, err := shared.ParseVersionString(r.Name)
+		if err != nil {
+			continue
+		}
+		if pv.GreaterThan(latest) {
+			latest = pv
+			betaVersion = r.Name
+		}
+	}
+	return betaVersion
+}
+
+// Parses the structured sections of a GitHub release's markdown description. Bullet points under a "New features"
+// heading and under a "Changed defaults" heading are included, and everything else is ignored. Returns nil if the
+// release has no structured release notes.
+func parseReleaseNotes(version, body string) *shared.ReleaseNotes {
+	notes := shared.ReleaseNotes{Version: version}
+	var section *[]string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			switch strings.ToLower(strings.TrimSpace(strings.TrimLeft(line, "#"))) {
+			case "new features":
+				section = &notes.NewFeatures
+			case "changed defaults":
+				section = &notes.ChangedDefaults
+			default:
+				section = nil
+			}
+			continue
+		}
+		if section == nil {
+			continue
+		}
+		if item, ok := strings.CutPrefix(line, "- "); ok {
+			*section = append(*section, strings.TrimSpace(item))
+		} else if item, ok := strings.CutPrefix(line, "* "); ok {
+			*section = append(*section, strings.TrimSpace(item))
+		}
+	}
+	if len(notes.NewFeatures) == 0 && len(notes.ChangedDefaults) == 0 {
+		return nil
+	}
+	return &notes
+}
+
 func BuildUpdateInfo(version string) shared.UpdateInfo {
 	return shared.UpdateInfo{
-		LinuxAmd64Url:             fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-amd64", version),
-		LinuxAmd64AttestationUrl:  fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-amd64.intoto.jsonl", version),
-		LinuxArm64Url:             fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-arm64", version),
-		LinuxArm64AttestationUrl:  fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-arm64.intoto.jsonl", version),
-		LinuxArm7Url:              fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-arm", version),
-		LinuxArm7AttestationUrl:   fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-arm.intoto.jsonl", version),
-		DarwinAmd64Url:            fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-amd64", version),
-		DarwinAmd64UnsignedUrl:    fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-amd64-unsigned", version),
-		DarwinAmd64AttestationUrl: fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-amd64.intoto.jsonl", version),
-		DarwinArm64Url:            fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-arm64", version),
-		DarwinArm64UnsignedUrl:    fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-arm64-unsigned", version),
-		DarwinArm64AttestationUrl: fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-arm64.intoto.jsonl", version),
-		Version:                   version,
+		LinuxAmd64Url:              fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-amd64", version),
+		LinuxAmd64AttestationUrl:   fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-amd64.intoto.jsonl", version),
+		LinuxArm64Url:              fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-arm64", version),
+		LinuxArm64AttestationUrl:   fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-arm64.intoto.jsonl", version),
+		LinuxArm7Url:               fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-arm", version),
+		LinuxArm7AttestationUrl:    fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-linux-arm.intoto.jsonl", version),
+		FreebsdAmd64Url:            fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-freebsd-amd64", version),
+		FreebsdAmd64AttestationUrl: fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-freebsd-amd64.intoto.jsonl", version),
+		DarwinAmd64Url:             fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-amd64", version),
+		DarwinAmd64UnsignedUrl:     fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-amd64-unsigned", version),
+		DarwinAmd64AttestationUrl:  fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-amd64.intoto.jsonl", version),
+		DarwinArm64Url:             fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-arm64", version),
+		DarwinArm64UnsignedUrl:     fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-arm64-unsigned", version),
+		DarwinArm64AttestationUrl:  fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-darwin-arm64.intoto.jsonl", version),
+		WindowsAmd64Url:            fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-windows-amd64.exe", version),
+		WindowsAmd64AttestationUrl: fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-windows-amd64.exe.intoto.jsonl", version),
+		WindowsArm64Url:            fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-windows-arm64.exe", version),
+		WindowsArm64AttestationUrl: fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-windows-arm64.exe.intoto.jsonl", version),
+		Version:                    version,
+		ReleaseNotes:               releaseNotesForVersion(version),
+	}
+}
+
+func releaseNotesForVersion(version string) *shared.ReleaseNotes {
+	if Notes == nil || Notes.Version != version {
+		// The latest release was skipped since it didn't have valid binaries yet, so its notes don't apply
+		return nil
 	}
+	return Notes
 }
 
 func decrementVersionIfInvalid(initialVersion string) string {
@@ -93,12 +209,18 @@ func assertValidUpdate(updateInfo shared.UpdateInfo) error {
 		updateInfo.LinuxArm64AttestationUrl,
 		updateInfo.LinuxArm7Url,
 		updateInfo.LinuxArm7AttestationUrl,
+		updateInfo.FreebsdAmd64Url,
+		updateInfo.FreebsdAmd64AttestationUrl,
 		updateInfo.DarwinAmd64Url,
 		updateInfo.DarwinAmd64UnsignedUrl,
 		updateInfo.DarwinAmd64AttestationUrl,
 		updateInfo.DarwinArm64Url,
 		updateInfo.DarwinArm64UnsignedUrl,
 		updateInfo.DarwinArm64AttestationUrl,
+		updateInfo.WindowsAmd64Url,
+		updateInfo.WindowsAmd64AttestationUrl,
+		updateInfo.WindowsArm64Url,
+		updateInfo.WindowsArm64AttestationUrl,
 		fmt.Sprintf("https://github.com/ddworken/hishtory/releases/download/%s/hishtory-release-validation-completed", updateInfo.Version),
 	}
 	for _, url := range urls {
@@ -34,8 +34,47 @@ func TestUpdateReleaseVersion(t *testing.T) {
 	}
 }
 
+func TestParseReleaseNotes(t *testing.T) {
+	body := `Some introductory text
+
+## New features
+- Added a command palette
+* Added pinned commands
+
+## Changed defaults
+- Duplicate commands are now filtered by default
+
+## Bug fixes
+- Fixed a crash
+`
+	notes := parseReleaseNotes("v0.300", body)
+	require.NotNil(t, notes)
+	require.Equal(t, "v0.300", notes.Version)
+	require.Equal(t, []string{"Added a command palette", "Added pinned commands"}, notes.NewFeatures)
+	require.Equal(t, []string{"Duplicate commands are now filtered by default"}, notes.ChangedDefaults)
+
+	// Releases without structured notes have none
+	require.Nil(t, parseReleaseNotes("v0.300", "## Bug fixes\n- Fixed a crash\n"))
+	require.Nil(t, parseReleaseNotes("v0.300", ""))
+}
+
 func TestDecrement(t *testing.T) {
 	pv, err := decrementVersion("v0.100")
 	require.NoError(t, err)
 	require.Equal(t, "v0.99", pv)
 }
+
+func TestLatestBetaVersion(t *testing.T) {
+	releases := []releaseInfo{
+		{Name: "v0.302", Prerelease: true},
+		{Name: "v0.301", Prerelease: false},
+		{Name: "v0.303", Prerelease: true},
+		{Name: "v0.300", Prerelease: false},
+		{Name: "not-a-version", Prerelease: true},
+	}
+	require.Equal(t, "v0.303", latestBetaVersion(releases, "v0.301"))
+	require.Equal(t, "", latestBetaVersion(releases, "v0.303"))
+	require.Equal(t, "", latestBetaVersion(releases, "v0.310"))
+	require.Equal(t, "", latestBetaVersion(releases, "UNKNOWN"))
+	require.Equal(t, "", latestBetaVersion(nil, "v0.301"))
+}
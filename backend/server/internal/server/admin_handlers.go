@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ddworken/hishtory/backend/server/internal/database"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// The response from /api/v1/admin/user
+type AdminUserInfo struct {
+	UserId            string                   `json:"user_id"`
+	Devices           []database.DeviceSummary `json:"devices"`
+	Storage           []database.DeviceStorage `json:"storage"`
+	TotalStorageBytes int64                    `json:"total_storage_bytes"`
+}
+
+// The response from /api/v1/admin/erase-user
+type AdminEraseUserResponse struct {
+	NumDeleted int64 `json:"num_deleted"`
+}
+
+func writeAdminResponse(w http.ResponseWriter, resp any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		panic(fmt.Errorf("failed to JSON marshall the admin response: %w", err))
+	}
+}
+
+func requirePost(r *http.Request) {
+	if r.Method != http.MethodPost {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "%s must be called with a POST request", r.URL.Path))
+	}
+}
+
+// The maximum number of users returned by a single request to /api/v1/admin/users
+const ADMIN_USERS_PAGE_SIZE = 1000
+
+func (s *Server) adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	limit := ADMIN_USERS_PAGE_SIZE
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 || parsed > ADMIN_USERS_PAGE_SIZE {
+			panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "limit must be an integer between 1 and %d", ADMIN_USERS_PAGE_SIZE))
+		}
+		limit = parsed
+	}
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		parsed, err := strconv.Atoi(offsetStr)
+		if err != nil || parsed < 0 {
+			panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "offset must be a non-negative integer"))
+		}
+		offset = parsed
+	}
+	users, err := s.db.ListUsers(r.Context(), limit, offset)
+	checkGormError(err)
+	writeAdminResponse(w, users)
+}
+
+func (s *Server) adminUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId := getRequiredQueryParam(r, "user_id")
+	devices, err := s.db.ListDevicesForUser(r.Context(), userId)
+	checkGormError(err)
+	storage, err := s.db.StorageForUser(r.Context(), userId)
+	checkGormError(err)
+	if len(devices) == 0 && len(storage) == 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "found no data for user_id=%s", userId))
+	}
+	info := AdminUserInfo{UserId: userId, Devices: devices, Storage: storage}
+	if info.Storage == nil {
+		info.Storage = make([]database.DeviceStorage, 0)
+	}
+	for _, ds := range storage {
+		info.TotalStorageBytes += ds.StorageBytes
+	}
+	writeAdminResponse(w, info)
+}
+
+func (s *Server) adminEraseUserHandler(w http.ResponseWriter, r *http.Request) {
+	requirePost(r)
+	userId := getRequiredQueryParam(r, "user_id")
+	numDeleted, err := s.db.EraseUser(r.Context(), userId)
+	if err != nil {
+		panic(fmt.Errorf("failed to EraseUser(user_id=%s): %w", userId, err))
+	}
+	fmt.Printf("adminEraseUserHandler: Deleted %d items from the DB\n", numDeleted)
+	writeAdminResponse(w, AdminEraseUserResponse{NumDeleted: numDeleted})
+}
+
+func (s *Server) adminExpireDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	requirePost(r)
+	inactiveDays, err := strconv.Atoi(getRequiredQueryParam(r, "inactive_days"))
+	if err != nil || inactiveDays <= 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "inactive_days must be a positive integer"))
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	cutoff := time.Now().Add(-time.Duration(inactiveDays) * 24 * time.Hour)
+	expired, err := s.db.ExpireStaleDevices(r.Context(), cutoff, dryRun)
+	if err != nil {
+		panic(fmt.Errorf("failed to ExpireStaleDevices: %w", err))
+	}
+	fmt.Printf("adminExpireDevicesHandler: Expired %d devices (dry_run=%v)\n", len(expired), dryRun)
+	writeAdminResponse(w, expired)
+}
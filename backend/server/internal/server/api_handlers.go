@@ -7,20 +7,22 @@ import (
 	"html"
 	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/ddworken/hishtory/backend/server/internal/database"
 	"github.com/ddworken/hishtory/shared"
 	"github.com/ddworken/hishtory/shared/ai"
+	"github.com/google/uuid"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
 func (s *Server) apiSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	s.limitRequestBody(w, r)
 	var entries []*shared.EncHistoryEntry
 	err := json.NewDecoder(r.Body).Decode(&entries)
-	if err != nil {
-		panic(fmt.Errorf("failed to decode: %w", err))
-	}
+	checkDecodeError(err)
 	fmt.Printf("apiSubmitHandler: received request containg %d EncHistoryEntry\n", len(entries))
 	if len(entries) == 0 {
 		return
@@ -35,11 +37,17 @@ func (s *Server) apiSubmitHandler(w http.ResponseWriter, r *http.Request) {
 	checkGormError(err)
 
 	if len(devices) == 0 {
-		panic(fmt.Errorf("found no devices associated with user_id=%s, can't save history entry", entries[0].UserId))
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "found no devices associated with user_id=%s, can't save history entry", entries[0].UserId))
 	}
 	fmt.Printf("apiSubmitHandler: Found %d devices\n", len(devices))
+	s.checkEntryQuotas(r.Context(), userId, len(entries), len(devices))
 
 	sourceDeviceId := getOptionalQueryParam(r, "source_device_id", s.isTestEnvironment)
+	for _, device := range devices {
+		if device.DeviceId == sourceDeviceId && !device.SyncMode.CanUpload() {
+			panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "device_id=%s is read-only, so it can't submit history entries", sourceDeviceId))
+		}
+	}
 	err = s.db.AddHistoryEntriesForAllDevices(r.Context(), sourceDeviceId, devices, entries)
 	if err != nil {
 		panic(fmt.Errorf("failed to execute transaction to add entries to DB: %w", err))
@@ -72,6 +80,63 @@ func (s *Server) apiSubmitHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) apiSubmitBatchHandler(w http.ResponseWriter, r *http.Request) {
+	s.limitRequestBody(w, r)
+	var batch shared.SyncBatch
+	checkDecodeError(json.NewDecoder(r.Body).Decode(&batch))
+	fmt.Printf("apiSubmitBatchHandler: received batch containing %d EncHistoryEntry\n", len(batch.Entries))
+	if batch.UserId == "" {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "batch is missing a user_id"))
+	}
+	for _, entry := range batch.Entries {
+		if entry.UserId != batch.UserId {
+			panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "batch contains an entry with UserId=%#v, when the batch is for user_id=%#v", entry.UserId, batch.UserId))
+		}
+	}
+	if batch.DeletionRequest != nil {
+		if batch.DeletionRequest.UserId != batch.UserId {
+			panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "batch contains a deletion request for UserId=%#v, when the batch is for user_id=%#v", batch.DeletionRequest.UserId, batch.UserId))
+		}
+		if len(batch.Entries) > 0 {
+			for _, message := range batch.DeletionRequest.Messages.Ids {
+				// Deletion requests are re-applied every time they're read, and matching on the end time would also
+				// match a replacement entry that kept the same end time
+				if message.EntryId == "" || message.EndTime != (time.Time{}) {
+					panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "deletion requests in batches that also insert entries must identify entries only by their entry ID"))
+				}
+			}
+		}
+	}
+
+	devices, err := s.db.DevicesForUser(r.Context(), batch.UserId)
+	checkGormError(err)
+	if len(devices) == 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "found no devices associated with user_id=%s, can't apply batch", batch.UserId))
+	}
+	s.checkEntryQuotas(r.Context(), batch.UserId, len(batch.Entries), len(devices))
+	sourceDeviceId := getOptionalQueryParam(r, "source_device_id", s.isTestEnvironment)
+	for _, device := range devices {
+		if device.DeviceId == sourceDeviceId && !device.SyncMode.CanUpload() {
+			panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "device_id=%s is read-only, so it can't submit history entries", sourceDeviceId))
+		}
+	}
+	s.handleNonCriticalError(s.updateUsageData(r.Context(), getHishtoryVersion(r), getRemoteAddr(r), batch.UserId, sourceDeviceId, len(batch.Entries), false))
+
+	alreadyApplied, err := s.db.ApplySyncBatch(r.Context(), sourceDeviceId, devices, &batch)
+	if err != nil {
+		panic(fmt.Errorf("failed to execute transaction to apply batch: %w", err))
+	}
+	if alreadyApplied {
+		fmt.Printf("apiSubmitBatchHandler: skipped already applied batch %#v\n", batch.IdempotencyKey)
+	}
+	if s.statsd != nil {
+		s.statsd.Count("hishtory.submit", int64(len(devices)), []string{}, 1.0)
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
+
 func (s *Server) apiBootstrapHandler(w http.ResponseWriter, r *http.Request) {
 	// TODO: Update this to filter out duplicate entries
 	userId := getRequiredQueryParam(r, "user_id")
@@ -83,7 +148,24 @@ func (s *Server) apiBootstrapHandler(w http.ResponseWriter, r *http.Request) {
 	historyEntries, err := s.db.AllHistoryEntriesForUser(r.Context(), userId)
 	checkGormError(err)
 	fmt.Printf("apiBootstrapHandler: Found %d entries\n", len(historyEntries))
-	if err := json.NewEncoder(w).Encode(historyEntries); err != nil {
+	writeEncHistoryEntries(w, r, historyEntries)
+}
+
+// Writes the given entries as protobuf if the client's Accept header allows it, and otherwise as JSON
+func writeEncHistoryEntries(w http.ResponseWriter, r *http.Request, entries []*shared.EncHistoryEntry) {
+	w.Header().Add("Vary", "Accept")
+	if !shared.AcceptsProtobuf(r.Header.Get("Accept")) {
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			panic(err)
+		}
+		return
+	}
+	resp, err := shared.MarshalEncHistoryEntriesProto(entries)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", shared.ContentTypeProtobuf)
+	if _, err := w.Write(resp); err != nil {
 		panic(err)
 	}
 }
@@ -107,13 +189,29 @@ func (s *Server) apiQueryHandler(w http.ResponseWriter, r *http.Request) {
 	_, err = s.db.ApplyDeletionRequestsToBackend(r.Context(), deletionRequests)
 	checkGormError(err)
 
-	// Then retrieve
+	// Clients that track a sync cursor receive every entry after it exactly once, no matter how often they query
+	if cursorParam := r.URL.Query().Get("cursor"); cursorParam != "" {
+		cursor, err := strconv.ParseInt(cursorParam, 10, 64)
+		if err != nil || cursor < 0 {
+			panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "invalid cursor=%#v", cursorParam))
+		}
+		// The cursor is only advanced once the client has stored everything before it, so it doubles as an ack
+		checkGormError(s.db.AckSequenceId(ctx, userId, deviceId, cursor))
+		historyEntries, err := s.db.HistoryEntriesForDeviceAfterCursor(ctx, userId, deviceId, cursor)
+		checkGormError(err)
+		fmt.Printf("apiQueryHandler: Found %d entries for %s\n", len(historyEntries), r.URL)
+		writeEncHistoryEntries(w, r, historyEntries)
+		if s.statsd != nil {
+			s.statsd.Incr("hishtory.query", []string{"query_reason:" + queryReason}, 1.0)
+		}
+		return
+	}
+
+	// Otherwise, fall back to delivering entries until they have been read a few times
 	historyEntries, err := s.db.HistoryEntriesForDevice(r.Context(), deviceId, 5)
 	checkGormError(err)
 	fmt.Printf("apiQueryHandler: Found %d entries for %s\n", len(historyEntries), r.URL)
-	if err := json.NewEncoder(w).Encode(historyEntries); err != nil {
-		panic(err)
-	}
+	writeEncHistoryEntries(w, r, historyEntries)
 
 	// And finally, kick off a background goroutine that will increment the read count. Doing it in the background avoids
 	// blocking the entire response. This does have a potential race condition, but that is fine.
@@ -142,21 +240,21 @@ func (s *Server) apiSubmitDumpHandler(w http.ResponseWriter, r *http.Request) {
 	userId := getRequiredQueryParam(r, "user_id")
 	srcDeviceId := getRequiredQueryParam(r, "source_device_id")
 	requestingDeviceId := getRequiredQueryParam(r, "requesting_device_id")
+	s.limitRequestBody(w, r)
 	var entries []*shared.EncHistoryEntry
 	err := json.NewDecoder(r.Body).Decode(&entries)
-	if err != nil {
-		panic(fmt.Errorf("failed to decode: %w", err))
-	}
+	checkDecodeError(err)
 	fmt.Printf("apiSubmitDumpHandler: received request containg %d EncHistoryEntry\n", len(entries))
 
 	// sanity check
 	for _, entry := range entries {
 		entry.DeviceId = requestingDeviceId
 		if entry.UserId != userId {
-			panic(fmt.Errorf("batch contains an entry with UserId=%#v, when the query param contained the user_id=%#v", entry.UserId, userId))
+			panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "batch contains an entry with UserId=%#v, when the query param contained the user_id=%#v", entry.UserId, userId))
 		}
 	}
 
+	s.checkEntryQuotas(r.Context(), userId, len(entries), 1)
 	err = s.db.AddHistoryEntries(r.Context(), entries...)
 	checkGormError(err)
 	err = s.db.DumpRequestDeleteForUserAndDevice(r.Context(), userId, requestingDeviceId)
@@ -175,11 +273,36 @@ func (s *Server) apiBannerHandler(w http.ResponseWriter, r *http.Request) {
 	deviceId := getRequiredQueryParam(r, "device_id")
 	forcedBanner := r.URL.Query().Get("forced_banner")
 	fmt.Printf("apiBannerHandler: commit_hash=%#v, device_id=%#v, forced_banner=%#v\n", commitHash, deviceId, forcedBanner)
+	w.Write([]byte(s.getBanner(r, forcedBanner)))
+}
+
+// Returns the (HTML-escaped) banner that should be displayed to the client that sent the given request
+func (s *Server) getBanner(r *http.Request, forcedBanner string) string {
+	if s.minClientVersion != nil && isClientTooOld(r, *s.minClientVersion) {
+		return fmt.Sprintf("Warning: hiSHtory %s is no longer supported and can't sync your history! Please run `hishtory update` to upgrade hiSHtory.", html.EscapeString(getHishtoryVersion(r)))
+	}
 	if getHishtoryVersion(r) == "v0.160" {
-		w.Write([]byte("Warning: hiSHtory v0.160 has a bug that slows down your shell! Please run `hishtory update` to upgrade hiSHtory."))
-		return
+		return "Warning: hiSHtory v0.160 has a bug that slows down your shell! Please run `hishtory update` to upgrade hiSHtory."
+	}
+	return html.EscapeString(forcedBanner)
+}
+
+func (s *Server) apiRemoteConfigHandler(w http.ResponseWriter, r *http.Request) {
+	deviceId := getRequiredQueryParam(r, "device_id")
+	forcedBanner := r.URL.Query().Get("forced_banner")
+	fmt.Printf("apiRemoteConfigHandler: device_id=%#v, forced_banner=%#v\n", deviceId, forcedBanner)
+	remoteConfig := shared.RemoteConfig{
+		Banner:           s.getBanner(r, forcedBanner),
+		DisabledFeatures: s.disabledFeatures,
+		Rollouts:         s.featureRollouts,
+	}
+	if s.minClientVersion != nil {
+		remoteConfig.MinClientVersion = s.minClientVersion.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(remoteConfig); err != nil {
+		panic(fmt.Errorf("failed to JSON marshall the remote config: %w", err))
 	}
-	w.Write([]byte(html.EscapeString(forcedBanner)))
 }
 
 func (s *Server) apiGetPendingDumpRequestsHandler(w http.ResponseWriter, r *http.Request) {
@@ -194,7 +317,18 @@ func (s *Server) apiGetPendingDumpRequestsHandler(w http.ResponseWriter, r *http
 }
 
 func (s *Server) apiDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	err := json.NewEncoder(w).Encode(s.updateInfo)
+	updateInfo := s.updateInfo
+	switch channel := r.URL.Query().Get("channel"); channel {
+	case "", shared.UpdateChannelStable:
+	case shared.UpdateChannelBeta:
+		// If there is no beta that is newer than the stable release, then beta users should get the stable release
+		if s.betaUpdateInfo != nil {
+			updateInfo = *s.betaUpdateInfo
+		}
+	default:
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "unknown update channel %#v", channel))
+	}
+	err := json.NewEncoder(w).Encode(updateInfo)
 
 	if err != nil {
 		panic(fmt.Errorf("failed to JSON marshall the update info: %w", err))
@@ -218,7 +352,7 @@ func (s *Server) apiRegisterHandler(w http.ResponseWriter, r *http.Request) {
 				panic(fmt.Errorf("db.DistinctUsers: %w", err))
 			}
 			if numDistinctUsers >= int64(getMaximumNumberOfAllowedUsers()) {
-				panic(fmt.Sprintf("Refusing to allow registration of new device since there are currently %d users and this server allows a max of %d users", numDistinctUsers, getMaximumNumberOfAllowedUsers()))
+				panic(shared.NewErrorResponse(shared.ErrorCodeTooManyUsers, "Refusing to allow registration of new device since there are currently %d users and this server allows a max of %d users", numDistinctUsers, getMaximumNumberOfAllowedUsers()))
 			}
 		}
 	}
@@ -263,10 +397,25 @@ func (s *Server) getDeletionRequestsHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+func (s *Server) ackDeletionRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	userId := getRequiredQueryParam(r, "user_id")
+	deviceId := getRequiredQueryParam(r, "device_id")
+	var requestIds []string
+	if err := json.NewDecoder(r.Body).Decode(&requestIds); err != nil {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decode: %v", err))
+	}
+	fmt.Printf("ackDeletionRequestsHandler: acking %d deletion requests for device_id=%#v\n", len(requestIds), deviceId)
+	if len(requestIds) > 0 {
+		checkGormError(s.db.AckDeletionRequests(r.Context(), userId, deviceId, requestIds))
+	}
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
+
 func (s *Server) addDeletionRequestHandler(w http.ResponseWriter, r *http.Request) {
 	var request shared.DeletionRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		panic(fmt.Errorf("failed to decode: %w", err))
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decode: %v", err))
 	}
 	request.ReadCount = 0
 	fmt.Printf("addDeletionRequestHandler: received request containg %d messages to be deleted\n", len(request.Messages.Ids))
@@ -278,6 +427,29 @@ func (s *Server) addDeletionRequestHandler(w http.ResponseWriter, r *http.Reques
 	w.WriteHeader(http.StatusOK)
 }
 
+func (s *Server) apiReencryptHandler(w http.ResponseWriter, r *http.Request) {
+	userId := getRequiredQueryParam(r, "user_id")
+	var entries []*shared.EncHistoryEntry
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decode: %v", err))
+	}
+	for _, entry := range entries {
+		if entry.UserId != userId {
+			panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "can't re-encrypt an entry for user_id=%s in a request for user_id=%s", entry.UserId, userId))
+		}
+		if entry.EncryptedId == "" {
+			panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "can't re-encrypt an entry without an encrypted_id"))
+		}
+	}
+	fmt.Printf("apiReencryptHandler: re-encrypting %d entries\n", len(entries))
+	numUpdated, err := s.db.ReencryptHistoryEntries(r.Context(), userId, entries)
+	checkGormError(err)
+	fmt.Printf("apiReencryptHandler: updated %d stored entries\n", numUpdated)
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
+
 func (s *Server) slsaStatusHandler(w http.ResponseWriter, r *http.Request) {
 	// returns "OK" unless there is a current SLSA bug
 	v := getHishtoryVersion(r)
@@ -301,7 +473,7 @@ func (s *Server) feedbackHandler(w http.ResponseWriter, r *http.Request) {
 	var feedback shared.Feedback
 	err := json.NewDecoder(r.Body).Decode(&feedback)
 	if err != nil {
-		panic(fmt.Errorf("failed to decode: %w", err))
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decode: %v", err))
 	}
 	fmt.Printf("feedbackHandler: received request containg feedback %#v\n", feedback)
 	err = s.db.FeedbackCreate(r.Context(), &feedback)
@@ -320,21 +492,27 @@ func (s *Server) aiSuggestionHandler(w http.ResponseWriter, r *http.Request) {
 	var req ai.AiSuggestionRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		panic(fmt.Errorf("failed to decode AiSuggestionRequest: %w", err))
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decode AiSuggestionRequest: %v", err))
 	}
 	if req.NumberCompletions > 10 {
-		panic(fmt.Errorf("request for %d completions is greater than max allowed", req.NumberCompletions))
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "request for %d completions is greater than max allowed", req.NumberCompletions))
+	}
+	if len(req.History) > ai.MaxAiChatTurns {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "request with %d previous turns is longer than the max allowed", len(req.History)))
+	}
+	if req.Context != nil && len(req.Context.RecentCommands) > ai.MaxAiContextCommands {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "request with %d recent commands is longer than the max allowed", len(req.Context.RecentCommands)))
 	}
 	numDevices, err := s.db.CountDevicesForUser(ctx, req.UserId)
 	if err != nil {
 		panic(fmt.Errorf("failed to count devices for user: %w", err))
 	}
 	if numDevices == 0 {
-		panic(fmt.Errorf("rejecting OpenAI request for user_id=%#v since it does not exist", req.UserId))
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "rejecting OpenAI request for user_id=%#v since it does not exist", req.UserId))
 	}
-	suggestions, usage, err := ai.GetAiSuggestionsViaOpenAiApi(ai.DefaultOpenAiEndpoint, req.Query, req.ShellName, req.OsName, req.NumberCompletions)
+	suggestions, usage, err := ai.GetAiChatSuggestionsViaOpenAiApi(ai.DefaultOpenAiEndpoint, req.History, req.Query, req.ShellName, req.OsName, req.Context, req.NumberCompletions)
 	if err != nil {
-		panic(fmt.Errorf("failed to query OpenAI API: %w", err))
+		panic(shared.NewErrorResponse(shared.ErrorCodeUpstreamFailure, "failed to query OpenAI API: %v", err))
 	}
 	s.statsd.Incr("hishtory.openai.query", []string{}, float64(req.NumberCompletions))
 	s.statsd.Incr("hishtory.openai.tokens", []string{}, float64(usage.TotalTokens))
@@ -345,11 +523,68 @@ func (s *Server) aiSuggestionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) aiExplanationHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req ai.AiExplanationRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decode AiExplanationRequest: %v", err))
+	}
+	if len(req.Command) > ai.MaxAiExplanationCommandLength {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "request for a command of length %d is longer than the max allowed", len(req.Command)))
+	}
+	numDevices, err := s.db.CountDevicesForUser(ctx, req.UserId)
+	if err != nil {
+		panic(fmt.Errorf("failed to count devices for user: %w", err))
+	}
+	if numDevices == 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "rejecting OpenAI request for user_id=%#v since it does not exist", req.UserId))
+	}
+	explanation, usage, err := ai.GetExplanationViaOpenAiApi(ai.DefaultOpenAiEndpoint, req.Command, req.ShellName, req.OsName)
+	if err != nil {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUpstreamFailure, "failed to query OpenAI API: %v", err))
+	}
+	s.statsd.Incr("hishtory.openai.explain", []string{}, 1.0)
+	s.statsd.Incr("hishtory.openai.tokens", []string{}, float64(usage.TotalTokens))
+	var resp ai.AiExplanationResponse
+	resp.Explanation = explanation
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		panic(fmt.Errorf("failed to JSON marshall the API response: %w", err))
+	}
+}
+
+func (s *Server) aiFeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req ai.AiFeedbackReport
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decode AiFeedbackReport: %v", err))
+	}
+	if req.NumAccepted < 0 || req.NumRejected < 0 || req.NumAccepted > ai.MaxAiFeedbackCount || req.NumRejected > ai.MaxAiFeedbackCount {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "feedback report with %d accepted and %d rejected suggestions is outside the allowed range", req.NumAccepted, req.NumRejected))
+	}
+	if !slices.Contains(ai.ProviderNames(), req.Provider) {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "unknown AI provider %#v", req.Provider))
+	}
+	numDevices, err := s.db.CountDevicesForUser(ctx, req.UserId)
+	if err != nil {
+		panic(fmt.Errorf("failed to count devices for user: %w", err))
+	}
+	if numDevices == 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "rejecting AI feedback for user_id=%#v since it does not exist", req.UserId))
+	}
+	tags := []string{"provider:" + req.Provider}
+	s.statsd.Incr("hishtory.ai.feedback.accepted", tags, float64(req.NumAccepted))
+	s.statsd.Incr("hishtory.ai.feedback.rejected", tags, float64(req.NumRejected))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
+
 func (s *Server) testOnlyOverrideAiSuggestions(w http.ResponseWriter, r *http.Request) {
 	var req ai.TestOnlyOverrideAiSuggestionRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		panic(fmt.Errorf("failed to decode TestOnlyOverrideAiSuggestionRequest: %w", err))
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decode TestOnlyOverrideAiSuggestionRequest: %v", err))
 	}
 	ai.TestOnlyOverrideAiSuggestions[req.Query] = req.Suggestions
 	w.Header().Set("Content-Length", "0")
@@ -360,6 +595,10 @@ func (s *Server) pingHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+func (s *Server) serverVersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(s.releaseVersion))
+}
+
 func (s *Server) apiUninstallHandler(w http.ResponseWriter, r *http.Request) {
 	userId := getRequiredQueryParam(r, "user_id")
 	deviceId := getRequiredQueryParam(r, "device_id")
@@ -371,3 +610,246 @@ func (s *Server) apiUninstallHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Length", "0")
 	w.WriteHeader(http.StatusOK)
 }
+
+func (s *Server) apiDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId := getRequiredQueryParam(r, "user_id")
+	numDeleted, err := s.db.DeleteUser(r.Context(), userId)
+	if err != nil {
+		panic(fmt.Errorf("failed to DeleteUser(user_id=%s): %w", userId, err))
+	}
+	fmt.Printf("apiDeleteUserHandler: Deleted %d items from the DB\n", numDeleted)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) apiDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	userId := getRequiredQueryParam(r, "user_id")
+	devices, err := s.db.DevicesForUser(r.Context(), userId)
+	checkGormError(err)
+
+	// Note that a device may be registered multiple times (e.g. if it was re-initialized), so dedupe by device ID
+	deviceInfos := make([]shared.DeviceInfo, 0)
+	seenDeviceIds := make(map[string]bool)
+	for _, device := range devices {
+		if seenDeviceIds[device.DeviceId] {
+			continue
+		}
+		seenDeviceIds[device.DeviceId] = true
+		deviceInfos = append(deviceInfos, shared.DeviceInfo{
+			DeviceId:         device.DeviceId,
+			RegistrationDate: device.RegistrationDate,
+			EncryptedName:    device.EncryptedName,
+			NameNonce:        device.NameNonce,
+			SyncMode:         device.SyncMode,
+		})
+	}
+	if err := json.NewEncoder(w).Encode(deviceInfos); err != nil {
+		panic(fmt.Errorf("failed to JSON marshall the devices: %w", err))
+	}
+}
+
+// Streams all of the user's (still encrypted) entries as a takeout archive, see shared.ContentTypeTakeout
+func (s *Server) apiExportHandler(w http.ResponseWriter, r *http.Request) {
+	userId := getRequiredQueryParam(r, "user_id")
+	devices, err := s.db.DevicesForUser(r.Context(), userId)
+	checkGormError(err)
+	if len(devices) == 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "found no devices for user_id=%s", userId))
+	}
+
+	w.Header().Set("Content-Type", shared.ContentTypeTakeout)
+	w.Header().Set("Content-Disposition", `attachment; filename="hishtory-takeout.jsonl.gz"`)
+	tw := shared.NewTakeoutWriter(w)
+	// Each entry is stored once per device that it is sent to, so only export one copy of each
+	seenEntries := make(map[string]bool)
+	numEntries := 0
+	err = s.db.StreamHistoryEntriesForUser(r.Context(), userId, func(entry *shared.EncHistoryEntry) error {
+		key := entry.EncryptedId
+		if key == "" {
+			key = string(entry.Nonce)
+		}
+		if seenEntries[key] {
+			return nil
+		}
+		seenEntries[key] = true
+		numEntries += 1
+		return tw.Write(entry)
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		// The response has already started, so the client will instead see a truncated archive
+		fmt.Printf("apiExportHandler: failed to export entries for user_id=%s: %v\n", userId, err)
+		return
+	}
+	fmt.Printf("apiExportHandler: Exported %d entries\n", numEntries)
+}
+
+// How long a link created by `hishtory share` remains viewable
+const SHARE_RETENTION = 30 * 24 * time.Hour
+
+// Stores a set of (encrypted) history entries so that they can be viewed via a share link, see shared.SharedEntries
+func (s *Server) apiShareHandler(w http.ResponseWriter, r *http.Request) {
+	requirePost(r)
+	userId := getRequiredQueryParam(r, "user_id")
+	devices, err := s.db.DevicesForUser(r.Context(), userId)
+	checkGormError(err)
+	if len(devices) == 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "found no devices for user_id=%s", userId))
+	}
+	s.limitRequestBody(w, r)
+	var sharedEntries shared.SharedEntries
+	checkDecodeError(json.NewDecoder(r.Body).Decode(&sharedEntries))
+	if len(sharedEntries.EncryptedData) == 0 || len(sharedEntries.Nonce) == 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "shared entries must contain enc_data and a nonce"))
+	}
+	sharedEntries.ShareId = uuid.Must(uuid.NewRandom()).String()
+	sharedEntries.UserId = userId
+	sharedEntries.ExpirationDate = time.Now().UTC().Add(SHARE_RETENTION)
+	checkGormError(s.db.CreateSharedEntries(r.Context(), &sharedEntries))
+	fmt.Printf("apiShareHandler: Shared %d bytes of entries as share_id=%s\n", len(sharedEntries.EncryptedData), sharedEntries.ShareId)
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := shared.ShareResponse{ShareId: sharedEntries.ShareId, ExpirationDate: sharedEntries.ExpirationDate}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		panic(fmt.Errorf("failed to JSON marshall the share response: %w", err))
+	}
+}
+
+// Returns the (still encrypted) entries for a share link. This doesn't require a user_id since share links are
+// intended to be viewed by anyone that they're sent to.
+func (s *Server) apiSharedHandler(w http.ResponseWriter, r *http.Request) {
+	shareId := getRequiredQueryParam(r, "share_id")
+	sharedEntries, err := s.db.GetSharedEntries(r.Context(), shareId)
+	checkGormError(err)
+	if sharedEntries == nil {
+		panic(shared.NewErrorResponse(shared.ErrorCodeNotFound, "share_id=%s doesn't exist or has expired", shareId))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sharedEntries); err != nil {
+		panic(fmt.Errorf("failed to JSON marshall the shared entries: %w", err))
+	}
+}
+
+func (s *Server) apiAccountInfoHandler(w http.ResponseWriter, r *http.Request) {
+	userId := getRequiredQueryParam(r, "user_id")
+	devices, err := s.db.DevicesForUser(r.Context(), userId)
+	checkGormError(err)
+	if len(devices) == 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "found no devices for user_id=%s", userId))
+	}
+	storage, err := s.db.StorageForUser(r.Context(), userId)
+	checkGormError(err)
+	pendingDeletionRequests, err := s.db.PendingDeletionRequestsForUser(r.Context(), userId)
+	checkGormError(err)
+	usageData, err := s.db.UsageDataForUser(r.Context(), userId)
+	checkGormError(err)
+
+	storageByDevice := make(map[string]database.DeviceStorage)
+	for _, deviceStorage := range storage {
+		storageByDevice[deviceStorage.DeviceId] = deviceStorage
+	}
+	pendingByDevice := make(map[string]int64)
+	for _, pending := range pendingDeletionRequests {
+		pendingByDevice[pending.DeviceId] = pending.NumRequests
+	}
+	usageByDevice := make(map[string]database.UsageData)
+	for _, usage := range usageData {
+		usageByDevice[usage.DeviceId] = usage
+	}
+
+	accountInfo := shared.AccountInfo{Devices: make([]shared.DeviceAccountInfo, 0)}
+	seenDeviceIds := make(map[string]bool)
+	for _, device := range devices {
+		if seenDeviceIds[device.DeviceId] {
+			continue
+		}
+		seenDeviceIds[device.DeviceId] = true
+		deviceStorage := storageByDevice[device.DeviceId]
+		accountInfo.Devices = append(accountInfo.Devices, shared.DeviceAccountInfo{
+			DeviceInfo: shared.DeviceInfo{
+				DeviceId:         device.DeviceId,
+				RegistrationDate: device.RegistrationDate,
+				EncryptedName:    device.EncryptedName,
+				NameNonce:        device.NameNonce,
+				SyncMode:         device.SyncMode,
+			},
+			LastSeen:                   usageByDevice[device.DeviceId].LastUsed,
+			Version:                    usageByDevice[device.DeviceId].Version,
+			NumEntries:                 deviceStorage.NumEntries,
+			StorageBytes:               deviceStorage.StorageBytes,
+			NumPendingDeletionRequests: pendingByDevice[device.DeviceId],
+		})
+	}
+	// Sum over all stored rows rather than just the listed devices, so that data queued for since-revoked devices is
+	// still accounted for
+	for _, deviceStorage := range storage {
+		accountInfo.NumEntries += deviceStorage.NumEntries
+		accountInfo.StorageBytes += deviceStorage.StorageBytes
+	}
+	for _, pending := range pendingDeletionRequests {
+		accountInfo.NumPendingDeletionRequests += pending.NumRequests
+	}
+	if err := json.NewEncoder(w).Encode(accountInfo); err != nil {
+		panic(fmt.Errorf("failed to JSON marshall the account info: %w", err))
+	}
+}
+
+func (s *Server) apiRenameDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	var request shared.RenameDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decode: %v", err))
+	}
+	numUpdated, err := s.db.RenameDevice(r.Context(), request.UserId, request.DeviceId, request.EncryptedName, request.NameNonce)
+	checkGormError(err)
+	if numUpdated == 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "found no device with device_id=%s for user_id=%s", request.DeviceId, request.UserId))
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) apiSetDeviceSyncModeHandler(w http.ResponseWriter, r *http.Request) {
+	userId := getRequiredQueryParam(r, "user_id")
+	deviceId := getRequiredQueryParam(r, "device_id")
+	syncMode, err := shared.ParseSyncMode(getRequiredQueryParam(r, "sync_mode"))
+	if err != nil {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "%v", err))
+	}
+	numUpdated, err := s.db.SetDeviceSyncMode(r.Context(), userId, deviceId, syncMode)
+	checkGormError(err)
+	if numUpdated == 0 {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "found no device with device_id=%s for user_id=%s", deviceId, userId))
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) apiRevokeDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	userId := getRequiredQueryParam(r, "user_id")
+	deviceId := getRequiredQueryParam(r, "device_id")
+	devices, err := s.db.DevicesForUser(r.Context(), userId)
+	checkGormError(err)
+	isActiveDevice := false
+	for _, device := range devices {
+		if device.DeviceId == deviceId {
+			isActiveDevice = true
+		}
+	}
+	if !isActiveDevice {
+		panic(shared.NewErrorResponse(shared.ErrorCodeUnknownUser, "found no active device with device_id=%s for user_id=%s", deviceId, userId))
+	}
+
+	// Revoking a device is the same as uninstalling it, except that it is initiated by a different device. This
+	// ensures that it will no longer receive new history entries or deletion requests.
+	numDeleted, err := s.db.UninstallDevice(r.Context(), userId, deviceId)
+	if err != nil {
+		panic(fmt.Errorf("failed to UninstallDevice(user_id=%s, device_id=%s): %w", userId, deviceId, err))
+	}
+	fmt.Printf("apiRevokeDeviceHandler: Deleted %d items from the DB\n", numDeleted)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
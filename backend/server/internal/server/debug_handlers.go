@@ -44,6 +44,29 @@ func (s *Server) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// Liveness probe, which succeeds as long as the process is able to serve requests
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+// Readiness probe, which only succeeds once the DB is reachable and all migrations have been applied
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.Ping(); err != nil {
+		http.Error(w, fmt.Sprintf("DB is unreachable: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	numPending, err := s.db.NumPendingMigrations(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to check DB migrations: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	if numPending > 0 {
+		http.Error(w, fmt.Sprintf("DB is not migrated: %d pending migrations", numPending), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
 func (s *Server) triggerCronHandler(w http.ResponseWriter, r *http.Request) {
 	err := s.cronFn(r.Context(), s.db, s.statsd)
 	if err != nil {
@@ -1,6 +1,10 @@
 package server
 
 import (
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +14,7 @@ import (
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
+	"github.com/ddworken/hishtory/shared"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
@@ -33,6 +38,44 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.ResponseWriter.WriteHeader(statusCode)
 }
 
+// gzipResponseWriter compresses everything written to it. Whether to compress is only decided once the handler starts
+// writing its response, so that empty responses (which set a Content-Length of 0) and error responses written after a
+// panic are left uncompressed.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.Header().Get("Content-Length") == "" && w.Header().Get("Content-Encoding") == "" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Add("Vary", "Accept-Encoding")
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
 func getFunctionName(temp any) string {
 	strs := strings.Split((runtime.FuncForPC(reflect.ValueOf(temp).Pointer()).Name()), ".")
 	return strs[len(strs)-1]
@@ -117,11 +160,112 @@ func withPanicGuard(s *statsd.Client) Middleware {
 					if s != nil {
 						s.Incr("hishtory.error", []string{"handler:" + getFunctionName(h)}, 1.0)
 					}
-					// Note that we need to return a 503 error code since that is the error handled by the client in lib.IsOfflineError
-					rw.WriteHeader(http.StatusServiceUnavailable)
+					writeErrorResponse(rw, errorResponseFromPanic(r))
 				}
 			}()
 			h.ServeHTTP(rw, r)
 		})
 	}
 }
+
+// withMinClientVersion rejects requests from clients older than minVersion with ErrorCodeClientTooOld. Requests without
+// a parseable version (e.g. from dev builds) are always allowed, as are all requests if minVersion is nil.
+func withMinClientVersion(minVersion *shared.ParsedVersion) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if minVersion != nil && isClientTooOld(r, *minVersion) {
+				writeErrorResponse(rw, shared.NewErrorResponse(shared.ErrorCodeClientTooOld, "hishtory %s is no longer supported, the minimum supported version is %s", getHishtoryVersion(r), minVersion))
+				return
+			}
+			h.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// withAdminToken rejects requests that don't send the given admin token via an `Authorization: Bearer` header. If the
+// token is empty, all requests are rejected so that the admin API is disabled by default.
+func withAdminToken(token string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			providedToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" || !ok || subtle.ConstantTimeCompare([]byte(providedToken), []byte(token)) != 1 {
+				writeErrorResponse(rw, shared.NewErrorResponse(shared.ErrorCodeUnauthorized, "request to %s requires a valid admin token", r.URL.Path))
+				return
+			}
+			h.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// withCompression transparently decompresses gzipped request bodies, and gzips responses for clients that send an
+// Accept-Encoding of gzip. This is used for the endpoints that transfer large batches of history entries.
+func withCompression() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			switch r.Header.Get("Content-Encoding") {
+			case "", "identity":
+			case "gzip":
+				gz, err := gzip.NewReader(r.Body)
+				if err != nil {
+					writeErrorResponse(rw, shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decompress request body: %v", err))
+					return
+				}
+				defer gz.Close()
+				r.Body = gz
+				r.Header.Del("Content-Encoding")
+				r.ContentLength = -1
+			default:
+				rw.Header().Set("Accept-Encoding", "gzip")
+				writeErrorResponse(rw, shared.NewErrorResponse(shared.ErrorCodeUnsupportedEncoding, "unsupported Content-Encoding %#v, only gzip is supported", r.Header.Get("Content-Encoding")))
+				return
+			}
+			if !acceptsGzip(r) {
+				h.ServeHTTP(rw, r)
+				return
+			}
+			grw := &gzipResponseWriter{ResponseWriter: rw}
+			h.ServeHTTP(grw, r)
+			if err := grw.Close(); err != nil {
+				fmt.Printf("failed to flush compressed response: %v\n", err)
+			}
+		})
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.Split(encoding, ";")[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+func isClientTooOld(r *http.Request, minVersion shared.ParsedVersion) bool {
+	version, err := shared.ParseVersionString(getHishtoryVersion(r))
+	if err != nil {
+		return false
+	}
+	return version.LessThan(minVersion)
+}
+
+// errorResponseFromPanic converts a recovered panic into a structured error response. Handlers panic with a
+// *shared.ErrorResponse to signal a specific error code, and all other panics are treated as internal errors. Note
+// that the details of internal errors are only logged and are not returned to the client.
+func errorResponseFromPanic(r any) *shared.ErrorResponse {
+	if err, ok := r.(error); ok {
+		var errResp *shared.ErrorResponse
+		if errors.As(err, &errResp) {
+			return errResp
+		}
+	}
+	return shared.NewErrorResponse(shared.ErrorCodeInternal, "internal server error")
+}
+
+func writeErrorResponse(rw http.ResponseWriter, errResp *shared.ErrorResponse) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(errResp.Code.HttpStatusCode())
+	if err := json.NewEncoder(rw).Encode(errResp); err != nil {
+		fmt.Printf("failed to write error response: %v\n", err)
+	}
+}
@@ -1,11 +1,17 @@
 package server
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/ddworken/hishtory/shared"
 )
 
 func TestLoggerMiddleware(t *testing.T) {
@@ -105,6 +111,40 @@ func TestPanicGuard(t *testing.T) {
 	if w.Code != http.StatusServiceUnavailable {
 		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
 	}
+	var errResp shared.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+		t.Fatalf("failed to parse error response: %v", err)
+	}
+	if errResp.Code != shared.ErrorCodeInternal || !errResp.Retryable {
+		t.Errorf("expected a retryable internal error, got %#v", errResp)
+	}
+	if strings.Contains(errResp.Message, "synthetic panic for tests") {
+		t.Errorf("expected the details of the internal error to not be returned, got %#v", errResp)
+	}
+}
+
+func TestPanicGuardWithErrorResponse(t *testing.T) {
+	fmt.Println("Output prefix to avoid breaking gotestsum with panics")
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "missing param=%#v", "user_id"))
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Add("X-Real-Ip", "127.0.0.1")
+	withPanicGuard(nil)(handler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	var errResp shared.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+		t.Fatalf("failed to parse error response: %v", err)
+	}
+	expected := shared.ErrorResponse{Code: shared.ErrorCodeBadRequest, Message: `missing param="user_id"`, Retryable: false}
+	if errResp != expected {
+		t.Errorf("expected error response %#v, got %#v", expected, errResp)
+	}
 }
 
 func TestPanicGuardNoPanic(t *testing.T) {
@@ -211,3 +251,132 @@ func TestMergeMiddlewares(t *testing.T) {
 		})
 	}
 }
+
+func TestWithMinClientVersion(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	minVersion := shared.ParsedVersion{MajorVersion: 0, MinorVersion: 300}
+
+	testcases := []struct {
+		minVersion   *shared.ParsedVersion
+		version      string
+		expectedCode int
+	}{
+		{nil, "v0.200", http.StatusOK},
+		{&minVersion, "v0.299", http.StatusUpgradeRequired},
+		{&minVersion, "v0.300", http.StatusOK},
+		{&minVersion, "v0.301", http.StatusOK},
+		{&minVersion, "v1.0", http.StatusOK},
+		// Unparseable versions (e.g. dev builds) are always allowed
+		{&minVersion, "v0.Unknown", http.StatusOK},
+		{&minVersion, "", http.StatusOK},
+	}
+	for _, tc := range testcases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Add("X-Hishtory-Version", tc.version)
+		withMinClientVersion(tc.minVersion)(handler).ServeHTTP(w, req)
+		if w.Code != tc.expectedCode {
+			t.Errorf("expected status %d for version %#v, got %d", tc.expectedCode, tc.version, w.Code)
+		}
+		if tc.expectedCode == http.StatusUpgradeRequired {
+			var errResp shared.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("failed to parse error response: %v", err)
+			}
+			if errResp.Code != shared.ErrorCodeClientTooOld || errResp.Retryable {
+				t.Errorf("unexpected error response %#v", errResp)
+			}
+		}
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	handler := withCompression()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			panic(err)
+		}
+		w.Write([]byte("echo:" + string(body)))
+	}))
+
+	// A gzipped request body is decompressed, and the response is gzipped since the client accepts it
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("hello"))
+	gz.Close()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", &compressed)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzipped response, got Content-Encoding=%#v", w.Header().Get("Content-Encoding"))
+	}
+	gzr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("failed to read gzipped response: %v", err)
+	}
+	respBody, err := io.ReadAll(gzr)
+	if err != nil {
+		t.Fatalf("failed to decompress response: %v", err)
+	}
+	if string(respBody) != "echo:hello" {
+		t.Errorf("expected %q, got %q", "echo:hello", string(respBody))
+	}
+
+	// Uncompressed requests from clients that don't accept gzip are passed through unchanged
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "echo:hello" {
+		t.Errorf("expected an uncompressed response, got Content-Encoding=%#v body=%q", w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+
+	// Unsupported encodings are rejected
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	req.Header.Set("Content-Encoding", "zstd")
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status %d, got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+	var errResp shared.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil || errResp.Code != shared.ErrorCodeUnsupportedEncoding {
+		t.Errorf("expected an unsupported_encoding error, got %q", w.Body.String())
+	}
+}
+
+func TestWithAdminToken(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	testcases := []struct {
+		adminToken    string
+		authorization string
+		expectedCode  int
+	}{
+		{"secret", "Bearer secret", http.StatusOK},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		{"secret", "", http.StatusUnauthorized},
+		// The admin API is disabled if no token is configured
+		{"", "Bearer ", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+	for _, tc := range testcases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/users", nil)
+		if tc.authorization != "" {
+			req.Header.Add("Authorization", tc.authorization)
+		}
+		withAdminToken(tc.adminToken)(handler).ServeHTTP(w, req)
+		if w.Code != tc.expectedCode {
+			t.Errorf("expected status %d for token=%#v and authorization=%#v, got %d", tc.expectedCode, tc.adminToken, tc.authorization, w.Code)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -45,7 +46,7 @@ func TestMain(m *testing.M) {
 	}
 	underlyingDb.SetMaxOpenConns(1)
 	db.Exec("PRAGMA journal_mode = WAL")
-	err = db.AddDatabaseTables()
+	err = db.Migrate(context.Background())
 	if err != nil {
 		panic(fmt.Errorf("failed to add database tables: %w", err))
 	}
@@ -204,7 +205,7 @@ func TestDumpRequestAndResponse(t *testing.T) {
 	entry1, err := data.EncryptHistoryEntry("dkey", entry1Dec)
 	require.NoError(t, err)
 	entry2Dec := testutils.MakeFakeHistoryEntry("aaaaaaáaaa")
-	entry2, err := data.EncryptHistoryEntry("dkey", entry1Dec)
+	entry2, err := data.EncryptHistoryEntry("dkey", entry2Dec)
 	require.NoError(t, err)
 	reqBody, err := json.Marshal([]shared.EncHistoryEntry{entry1, entry2})
 	require.NoError(t, err)
@@ -424,11 +425,13 @@ func TestDeletionRequests(t *testing.T) {
 	require.NoError(t, json.Unmarshal(respBody, &deletionRequests))
 	require.Len(t, deletionRequests, 1)
 	deletionRequest := deletionRequests[0]
+	require.NotEmpty(t, deletionRequest.RequestId)
 	expected := shared.DeletionRequest{
 		UserId:              data.UserId("dkey"),
 		DestinationDeviceId: devId1,
 		SendTime:            delReqTime,
 		ReadCount:           1,
+		RequestId:           deletionRequest.RequestId,
 		Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{
 			{DeviceId: devId1, EndTime: entry1.EndTime},
 		}},
@@ -441,6 +444,89 @@ func TestDeletionRequests(t *testing.T) {
 	assertNoLeakedConnections(t, DB)
 }
 
+func TestDeletionRequestAcks(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("deletion-ack-key")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{devId1, devId2} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+	getDeletionRequests := func(devId string) []*shared.DeletionRequest {
+		w := httptest.NewRecorder()
+		s.getDeletionRequestsHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+		require.Equal(t, 200, w.Code)
+		var deletionRequests []*shared.DeletionRequest
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deletionRequests))
+		return deletionRequests
+	}
+	ack := func(devId string, requestIds ...string) {
+		reqBody, err := json.Marshal(requestIds)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		s.ackDeletionRequestsHandler(w, httptest.NewRequest(http.MethodPost, "/?device_id="+devId+"&user_id="+userId, bytes.NewReader(reqBody)))
+		require.Equal(t, 200, w.Code)
+	}
+	countStoredRequests := func() int64 {
+		var count int64
+		require.NoError(t, DB.Model(&shared.DeletionRequest{}).Where("user_id = ?", userId).Count(&count).Error)
+		return count
+	}
+
+	// Submit a deletion request, which is sent to both devices with the same ID
+	reqBody, err := json.Marshal(shared.DeletionRequest{
+		UserId:   userId,
+		SendTime: time.Now(),
+		Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{DeviceId: devId1, EntryId: "entry-id"}}},
+	})
+	require.NoError(t, err)
+	s.addDeletionRequestHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqBody)))
+	requests1 := getDeletionRequests(devId1)
+	require.Len(t, requests1, 1)
+	requests2 := getDeletionRequests(devId2)
+	require.Len(t, requests2, 1)
+	require.NotEmpty(t, requests1[0].RequestId)
+	require.Equal(t, requests1[0].RequestId, requests2[0].RequestId)
+
+	// Requests are sent until they're acked, no matter how many times they're read
+	for i := 0; i < 5; i++ {
+		require.Len(t, getDeletionRequests(devId1), 1)
+	}
+	ack(devId1, requests1[0].RequestId)
+	require.Empty(t, getDeletionRequests(devId1))
+	require.Len(t, getDeletionRequests(devId2), 1)
+
+	// And they aren't cleaned up until every device has acked them, even if they've been read many times by a device
+	// that is known to ack requests
+	require.NoError(t, DB.Exec("UPDATE deletion_requests SET read_count = 1000 WHERE user_id = ?", userId).Error)
+	ack(devId2, "some-other-request-id")
+	require.NoError(t, DB.Clean(context.Background()))
+	require.Equal(t, int64(2), countStoredRequests())
+	ack(devId2, requests2[0].RequestId)
+	require.NoError(t, DB.Clean(context.Background()))
+	require.Equal(t, int64(0), countStoredRequests())
+
+	// Devices that are still in use are waited on
+	s.addDeletionRequestHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqBody)))
+	requests1 = getDeletionRequests(devId1)
+	require.Len(t, requests1, 1)
+	ack(devId1, requests1[0].RequestId)
+	longAgo := time.Now().UTC().Add(-2 * database.INACTIVE_DEVICE_CUTOFF)
+	require.NoError(t, DB.Exec("UPDATE devices SET registration_date = ? WHERE device_id = ?", longAgo, devId2).Error)
+	require.NoError(t, DB.CreateUsageData(context.Background(), &database.UsageData{UserId: userId, DeviceId: devId2, LastUsed: time.Now().UTC()}))
+	require.NoError(t, DB.Clean(context.Background()))
+	require.Equal(t, int64(2), countStoredRequests())
+
+	// But devices that haven't been used recently (e.g. a retired laptop) aren't, so that requests aren't kept forever
+	require.NoError(t, DB.Exec("UPDATE usage_data SET last_used = ? WHERE device_id = ?", longAgo, devId2).Error)
+	require.NoError(t, DB.Clean(context.Background()))
+	require.Equal(t, int64(0), countStoredRequests())
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
 func TestHealthcheck(t *testing.T) {
 	s := NewServer(DB, TrackUsageData(true))
 	w := httptest.NewRecorder()
@@ -456,6 +542,809 @@ func TestHealthcheck(t *testing.T) {
 	assertNoLeakedConnections(t, DB)
 }
 
+func listDevices(t *testing.T, s *Server, userId string) []shared.DeviceInfo {
+	w := httptest.NewRecorder()
+	s.apiDevicesHandler(w, httptest.NewRequest(http.MethodGet, "/?user_id="+userId, nil))
+	require.Equal(t, 200, w.Code)
+	var devices []shared.DeviceInfo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &devices))
+	return devices
+}
+
+func TestDeviceManagement(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("device-management-key")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	otherUser := data.UserId("device-management-otherkey")
+	otherDev := uuid.Must(uuid.NewRandom()).String()
+	deviceReq := httptest.NewRequest(http.MethodGet, "/?device_id="+devId1+"&user_id="+userId, nil)
+	s.apiRegisterHandler(httptest.NewRecorder(), deviceReq)
+	deviceReq = httptest.NewRequest(http.MethodGet, "/?device_id="+devId2+"&user_id="+userId, nil)
+	s.apiRegisterHandler(httptest.NewRecorder(), deviceReq)
+	deviceReq = httptest.NewRequest(http.MethodGet, "/?device_id="+otherDev+"&user_id="+otherUser, nil)
+	s.apiRegisterHandler(httptest.NewRecorder(), deviceReq)
+
+	// List the devices
+	devices := listDevices(t, s, userId)
+	require.Len(t, devices, 2)
+	require.ElementsMatch(t, []string{devId1, devId2}, []string{devices[0].DeviceId, devices[1].DeviceId})
+	require.Empty(t, devices[0].EncryptedName)
+
+	// Rename a device
+	encName, nonce, err := data.EncryptDeviceName("device-management-key", devId1, "laptop")
+	require.NoError(t, err)
+	reqBody, err := json.Marshal(shared.RenameDeviceRequest{UserId: userId, DeviceId: devId1, EncryptedName: encName, NameNonce: nonce})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	s.apiRenameDeviceHandler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqBody)))
+	require.Equal(t, 200, w.Code)
+	devices = listDevices(t, s, userId)
+	require.Len(t, devices, 2)
+	for _, device := range devices {
+		name, err := data.DecryptDeviceName("device-management-key", device)
+		require.NoError(t, err)
+		if device.DeviceId == devId1 {
+			require.Equal(t, "laptop", name)
+		} else {
+			require.Equal(t, "", name)
+		}
+	}
+
+	// Revoke a device, and then it no longer receives entries
+	w = httptest.NewRecorder()
+	s.apiRevokeDeviceHandler(w, httptest.NewRequest(http.MethodPost, "/?user_id="+userId+"&device_id="+devId2, nil))
+	require.Equal(t, 200, w.Code)
+	devices = listDevices(t, s, userId)
+	require.Len(t, devices, 1)
+	require.Equal(t, devId1, devices[0].DeviceId)
+	encEntry, err := data.EncryptHistoryEntry("device-management-key", testutils.MakeFakeHistoryEntry("ls"))
+	require.NoError(t, err)
+	reqBody, err = json.Marshal([]shared.EncHistoryEntry{encEntry})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	s.apiSubmitHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId1, bytes.NewReader(reqBody)))
+	require.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	s.apiQueryHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+devId2+"&user_id="+userId, nil))
+	var retrievedEntries []*shared.EncHistoryEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &retrievedEntries))
+	require.Empty(t, retrievedEntries)
+
+	// Other users' devices can't be revoked
+	func() {
+		defer func() {
+			errResp, ok := recover().(*shared.ErrorResponse)
+			require.True(t, ok)
+			require.Equal(t, shared.ErrorCodeUnknownUser, errResp.Code)
+		}()
+		s.apiRevokeDeviceHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/?user_id="+userId+"&device_id="+otherDev, nil))
+	}()
+	require.Len(t, listDevices(t, s, otherUser), 1)
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestDeleteUser(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("delete-user-key")
+	devId := uuid.Must(uuid.NewRandom()).String()
+	otherUser := data.UserId("delete-user-otherkey")
+	otherDev := uuid.Must(uuid.NewRandom()).String()
+	s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+otherDev+"&user_id="+otherUser, nil))
+
+	// Submit an entry for each user
+	for _, secret := range []string{"delete-user-key", "delete-user-otherkey"} {
+		encEntry, err := data.EncryptHistoryEntry(secret, testutils.MakeFakeHistoryEntry("ls"))
+		require.NoError(t, err)
+		reqBody, err := json.Marshal([]shared.EncHistoryEntry{encEntry})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		s.apiSubmitHandler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqBody)))
+		require.Equal(t, 200, w.Code)
+	}
+
+	// Delete the first user
+	w := httptest.NewRecorder()
+	s.apiDeleteUserHandler(w, httptest.NewRequest(http.MethodPost, "/?user_id="+userId, nil))
+	require.Equal(t, 200, w.Code)
+
+	// Their devices and entries are gone
+	require.Empty(t, listDevices(t, s, userId))
+	var numEntries int64
+	require.NoError(t, DB.Model(&shared.EncHistoryEntry{}).Where("user_id = ?", userId).Count(&numEntries).Error)
+	require.Equal(t, int64(0), numEntries)
+
+	// But the other user is unaffected
+	require.Len(t, listDevices(t, s, otherUser), 1)
+	w = httptest.NewRecorder()
+	s.apiQueryHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+otherDev+"&user_id="+otherUser, nil))
+	var retrievedEntries []*shared.EncHistoryEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &retrievedEntries))
+	require.Len(t, retrievedEntries, 1)
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestAccountInfo(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("account-info-key")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	otherUser := data.UserId("account-info-otherkey")
+	otherDev := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{devId1, devId2} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+	s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+otherDev+"&user_id="+otherUser, nil))
+
+	// Submit an entry, which is stored once for each device
+	entry := testutils.MakeFakeHistoryEntry("ls")
+	entry.DeviceId = devId1
+	encEntry, err := data.EncryptHistoryEntry("account-info-key", entry)
+	require.NoError(t, err)
+	reqBody, err := json.Marshal([]shared.EncHistoryEntry{encEntry})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	s.apiSubmitHandler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqBody)))
+	require.Equal(t, 200, w.Code)
+
+	// Retrieve the account info
+	w = httptest.NewRecorder()
+	s.apiAccountInfoHandler(w, httptest.NewRequest(http.MethodGet, "/?user_id="+userId, nil))
+	require.Equal(t, 200, w.Code)
+	var accountInfo shared.AccountInfo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accountInfo))
+	require.Equal(t, int64(2), accountInfo.NumEntries)
+	require.Equal(t, int64(2*len(encEntry.EncryptedData)), accountInfo.StorageBytes)
+	require.Equal(t, int64(0), accountInfo.NumPendingDeletionRequests)
+	require.Len(t, accountInfo.Devices, 2)
+	require.ElementsMatch(t, []string{devId1, devId2}, []string{accountInfo.Devices[0].DeviceId, accountInfo.Devices[1].DeviceId})
+	for _, device := range accountInfo.Devices {
+		require.Equal(t, int64(1), device.NumEntries)
+		require.Equal(t, int64(len(encEntry.EncryptedData)), device.StorageBytes)
+	}
+
+	// Delete the entry, which removes it from the backend and queues a deletion request for each device
+	delReq := shared.DeletionRequest{
+		UserId:   userId,
+		SendTime: time.Now(),
+		Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{
+			{DeviceId: devId1, EndTime: entry.EndTime},
+		}},
+	}
+	reqBody, err = json.Marshal(delReq)
+	require.NoError(t, err)
+	s.addDeletionRequestHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqBody)))
+	w = httptest.NewRecorder()
+	s.apiAccountInfoHandler(w, httptest.NewRequest(http.MethodGet, "/?user_id="+userId, nil))
+	accountInfo = shared.AccountInfo{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accountInfo))
+	require.Equal(t, int64(0), accountInfo.NumEntries)
+	require.Equal(t, int64(0), accountInfo.StorageBytes)
+	require.Equal(t, int64(2), accountInfo.NumPendingDeletionRequests)
+	for _, device := range accountInfo.Devices {
+		require.Equal(t, int64(1), device.NumPendingDeletionRequests)
+	}
+
+	// Reading the deletion requests marks them as no longer pending
+	s.getDeletionRequestsHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId1+"&user_id="+userId, nil))
+	w = httptest.NewRecorder()
+	s.apiAccountInfoHandler(w, httptest.NewRequest(http.MethodGet, "/?user_id="+userId, nil))
+	accountInfo = shared.AccountInfo{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accountInfo))
+	require.Equal(t, int64(1), accountInfo.NumPendingDeletionRequests)
+
+	// The other user's report is unaffected
+	w = httptest.NewRecorder()
+	s.apiAccountInfoHandler(w, httptest.NewRequest(http.MethodGet, "/?user_id="+otherUser, nil))
+	accountInfo = shared.AccountInfo{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accountInfo))
+	require.Equal(t, int64(0), accountInfo.NumEntries)
+	require.Equal(t, int64(0), accountInfo.NumPendingDeletionRequests)
+	require.Len(t, accountInfo.Devices, 1)
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestSubmitBatch(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("submit-batch-key")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{devId1, devId2} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+	queryDevice2 := func() []string {
+		w := httptest.NewRecorder()
+		s.apiQueryHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+devId2+"&user_id="+userId+"&queryReason=test", nil))
+		require.Equal(t, 200, w.Code)
+		var retrievedEntries []*shared.EncHistoryEntry
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &retrievedEntries))
+		entryIds := make([]string, 0)
+		for _, entry := range retrievedEntries {
+			entryIds = append(entryIds, entry.EncryptedId)
+		}
+		return entryIds
+	}
+	submitBatch := func(batch shared.SyncBatch) {
+		reqBody, err := json.Marshal(batch)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		s.apiSubmitBatchHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId1, bytes.NewReader(reqBody)))
+		require.Equal(t, 200, w.Code)
+	}
+
+	// Submit a batch of entries
+	original := testutils.MakeFakeHistoryEntry("ls")
+	original.DeviceId = devId1
+	encOriginal, err := data.EncryptHistoryEntry("submit-batch-key", original)
+	require.NoError(t, err)
+	submitBatch(shared.SyncBatch{UserId: userId, Entries: []*shared.EncHistoryEntry{&encOriginal}})
+	require.Equal(t, []string{original.EntryId}, queryDevice2())
+
+	// Then edit it by deleting the original and inserting a replacement in a single batch
+	replacement := original
+	replacement.Command = "ls -l"
+	replacement.EntryId = uuid.Must(uuid.NewRandom()).String()
+	encReplacement, err := data.EncryptHistoryEntry("submit-batch-key", replacement)
+	require.NoError(t, err)
+	submitBatch(shared.SyncBatch{
+		UserId:  userId,
+		Entries: []*shared.EncHistoryEntry{&encReplacement},
+		DeletionRequest: &shared.DeletionRequest{
+			UserId:   userId,
+			SendTime: time.Now(),
+			Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{DeviceId: devId1, EntryId: original.EntryId}}},
+		},
+	})
+	require.Equal(t, []string{replacement.EntryId}, queryDevice2())
+
+	// Every device was sent the deletion request
+	for _, devId := range []string{devId1, devId2} {
+		w := httptest.NewRecorder()
+		s.getDeletionRequestsHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+		var deletionRequests []*shared.DeletionRequest
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deletionRequests))
+		require.Len(t, deletionRequests, 1)
+		require.Equal(t, original.EntryId, deletionRequests[0].Messages.Ids[0].EntryId)
+	}
+
+	// And deletions that match on the end time are rejected when the batch also inserts entries, since they would also
+	// match the replacement entry
+	reqBody, err := json.Marshal(shared.SyncBatch{
+		UserId:  userId,
+		Entries: []*shared.EncHistoryEntry{&encReplacement},
+		DeletionRequest: &shared.DeletionRequest{
+			UserId:   userId,
+			Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{DeviceId: devId1, EndTime: original.EndTime}}},
+		},
+	})
+	require.NoError(t, err)
+	require.Panics(t, func() {
+		s.apiSubmitBatchHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId1, bytes.NewReader(reqBody)))
+	})
+	require.Equal(t, []string{replacement.EntryId}, queryDevice2())
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestSubmitBatchIdempotency(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("submit-batch-idempotency-key")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{devId1, devId2} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+	submitBatch := func(key string, commands ...string) {
+		batch := shared.SyncBatch{UserId: userId, IdempotencyKey: key}
+		for _, command := range commands {
+			encEntry, err := data.EncryptHistoryEntry("submit-batch-idempotency-key", testutils.MakeFakeHistoryEntry(command))
+			require.NoError(t, err)
+			batch.Entries = append(batch.Entries, &encEntry)
+		}
+		reqBody, err := json.Marshal(batch)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		s.apiSubmitBatchHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId1, bytes.NewReader(reqBody)))
+		require.Equal(t, 200, w.Code)
+	}
+	countEntries := func() int {
+		entries, err := DB.AllHistoryEntriesForUser(context.Background(), userId)
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	// Submit a batch, which is stored once per device
+	submitBatch("upload-1-chunk-0", "ls", "cd /")
+	require.Equal(t, 4, countEntries())
+
+	// Retrying the same batch is a no-op
+	submitBatch("upload-1-chunk-0", "ls", "cd /")
+	require.Equal(t, 4, countEntries())
+
+	// But batches with a different key or no key are applied
+	submitBatch("upload-1-chunk-1", "echo foo")
+	require.Equal(t, 6, countEntries())
+	submitBatch("", "echo bar")
+	submitBatch("", "echo bar")
+	require.Equal(t, 10, countEntries())
+
+	// And keys are scoped to the user
+	otherUserId := data.UserId("submit-batch-idempotency-other-key")
+	otherDevId := uuid.Must(uuid.NewRandom()).String()
+	s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+otherDevId+"&user_id="+otherUserId, nil))
+	encEntry, err := data.EncryptHistoryEntry("submit-batch-idempotency-other-key", testutils.MakeFakeHistoryEntry("ls"))
+	require.NoError(t, err)
+	reqBody, err := json.Marshal(shared.SyncBatch{UserId: otherUserId, IdempotencyKey: "upload-1-chunk-0", Entries: []*shared.EncHistoryEntry{&encEntry}})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	s.apiSubmitBatchHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+otherDevId, bytes.NewReader(reqBody)))
+	require.Equal(t, 200, w.Code)
+	otherEntries, err := DB.AllHistoryEntriesForUser(context.Background(), otherUserId)
+	require.NoError(t, err)
+	require.Len(t, otherEntries, 1)
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestSubmitDeduplication(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("submit-dedup-key")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{devId1, devId2} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+	entry := testutils.MakeFakeHistoryEntry("ls")
+	submit := func(entries ...shared.EncHistoryEntry) {
+		reqBody, err := json.Marshal(entries)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		s.apiSubmitHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId1, bytes.NewReader(reqBody)))
+		require.Equal(t, 200, w.Code)
+	}
+	countEntries := func() int {
+		entries, err := DB.AllHistoryEntriesForUser(context.Background(), userId)
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	// Submit an entry, which is stored once per device
+	encEntry, err := data.EncryptHistoryEntry("submit-dedup-key", entry)
+	require.NoError(t, err)
+	submit(encEntry)
+	require.Equal(t, 2, countEntries())
+
+	// Retrying the submission doesn't store it again, even if it was re-encrypted or duplicated within the request
+	submit(encEntry)
+	reEncEntry, err := data.EncryptHistoryEntry("submit-dedup-key", entry)
+	require.NoError(t, err)
+	submit(reEncEntry, reEncEntry)
+	require.Equal(t, 2, countEntries())
+
+	// But a new entry is stored
+	otherEntry, err := data.EncryptHistoryEntry("submit-dedup-key", testutils.MakeFakeHistoryEntry("ls"))
+	require.NoError(t, err)
+	submit(otherEntry)
+	require.Equal(t, 4, countEntries())
+
+	// Dumps that contain entries that are already stored for the requesting device also don't duplicate them
+	newEntry, err := data.EncryptHistoryEntry("submit-dedup-key", testutils.MakeFakeHistoryEntry("echo foo"))
+	require.NoError(t, err)
+	reqBody, err := json.Marshal([]shared.EncHistoryEntry{reEncEntry, otherEntry, newEntry})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	s.apiSubmitDumpHandler(w, httptest.NewRequest(http.MethodPost, "/?user_id="+userId+"&requesting_device_id="+devId2+"&source_device_id="+devId1, bytes.NewReader(reqBody)))
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, 5, countEntries())
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestReencrypt(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("reencrypt-key")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{devId1, devId2} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+	reencrypt := func(queryUserId string, entries ...shared.EncHistoryEntry) {
+		reqBody, err := json.Marshal(entries)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		s.apiReencryptHandler(w, httptest.NewRequest(http.MethodPost, "/?user_id="+queryUserId, bytes.NewReader(reqBody)))
+		require.Equal(t, 200, w.Code)
+	}
+
+	// Submit an entry in an older payload format, which is stored once per device
+	entry := testutils.MakeFakeHistoryEntry("ls")
+	oldEncEntry, err := data.EncryptHistoryEntry("reencrypt-key", entry)
+	require.NoError(t, err)
+	oldEncEntry.PayloadVersion = 0
+	reqBody, err := json.Marshal([]shared.EncHistoryEntry{oldEncEntry})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	s.apiSubmitHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId1, bytes.NewReader(reqBody)))
+	require.Equal(t, 200, w.Code)
+
+	// Re-encrypting it replaces every stored copy
+	newEncEntry, err := data.EncryptHistoryEntry("reencrypt-key", entry)
+	require.NoError(t, err)
+	reencrypt(userId, newEncEntry)
+	storedEntries, err := DB.AllHistoryEntriesForUser(context.Background(), userId)
+	require.NoError(t, err)
+	require.Len(t, storedEntries, 2)
+	for _, stored := range storedEntries {
+		require.Equal(t, data.CurrentPayloadVersion, stored.PayloadVersion)
+		require.Equal(t, newEncEntry.Nonce, stored.Nonce)
+		decrypted, err := data.DecryptHistoryEntry("reencrypt-key", *stored)
+		require.NoError(t, err)
+		require.Equal(t, entry, decrypted)
+	}
+
+	// Re-encrypting it again with the same payload version is a no-op
+	otherEncEntry, err := data.EncryptHistoryEntry("reencrypt-key", entry)
+	require.NoError(t, err)
+	reencrypt(userId, otherEncEntry)
+	storedEntries, err = DB.AllHistoryEntriesForUser(context.Background(), userId)
+	require.NoError(t, err)
+	for _, stored := range storedEntries {
+		require.Equal(t, newEncEntry.Nonce, stored.Nonce)
+	}
+
+	// Entries for other users and entries without IDs are rejected
+	require.Panics(t, func() { reencrypt(data.UserId("other-reencrypt-key"), newEncEntry) })
+	newEncEntry.EncryptedId = ""
+	require.Panics(t, func() { reencrypt(userId, newEncEntry) })
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestSyncCursor(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("sync-cursor-key")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{devId1, devId2} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+	submit := func(commands ...string) {
+		entries := make([]shared.EncHistoryEntry, 0)
+		for _, command := range commands {
+			encEntry, err := data.EncryptHistoryEntry("sync-cursor-key", testutils.MakeFakeHistoryEntry(command))
+			require.NoError(t, err)
+			entries = append(entries, encEntry)
+		}
+		reqBody, err := json.Marshal(entries)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		s.apiSubmitHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId1, bytes.NewReader(reqBody)))
+		require.Equal(t, 200, w.Code)
+	}
+	query := func(cursor string) []*shared.EncHistoryEntry {
+		w := httptest.NewRecorder()
+		s.apiQueryHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+devId2+"&user_id="+userId+"&cursor="+cursor, nil))
+		require.Equal(t, 200, w.Code)
+		var entries []*shared.EncHistoryEntry
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+		return entries
+	}
+
+	// Entries are assigned increasing sequence IDs
+	submit("ls", "cd /")
+	entries := query("0")
+	require.Len(t, entries, 2)
+	require.Equal(t, int64(1), entries[0].SequenceId)
+	require.Equal(t, int64(2), entries[1].SequenceId)
+
+	// And are returned no matter how many times the device polls until its cursor moves past them
+	for i := 0; i < 10; i++ {
+		require.Len(t, query("0"), 2)
+	}
+	require.Len(t, query("1"), 1)
+	require.Empty(t, query("2"))
+
+	// New entries are returned after the cursor
+	submit("echo foo")
+	entries = query("2")
+	require.Len(t, entries, 1)
+	require.Equal(t, int64(3), entries[0].SequenceId)
+
+	// Entries stored before sequence IDs were assigned are returned for a cursor of zero if they haven't been read
+	require.NoError(t, DB.Exec("UPDATE enc_history_entries SET sequence_id = 0 WHERE device_id = ? AND sequence_id = 3", devId2).Error)
+	require.Len(t, query("0"), 3)
+	require.Empty(t, query("2"))
+
+	// Acked entries are cleaned up, while unacked ones are kept
+	w := httptest.NewRecorder()
+	s.apiQueryHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+devId1+"&user_id="+userId+"&cursor=3", nil))
+	require.Equal(t, 200, w.Code)
+	require.NoError(t, DB.Clean(context.Background()))
+	require.Len(t, query("0"), 1)
+
+	// But the source device's own copies are kept, so that new devices still receive the full history
+	devId3 := uuid.Must(uuid.NewRandom()).String()
+	s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId3+"&user_id="+userId, nil))
+	w = httptest.NewRecorder()
+	s.apiBootstrapHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+devId3+"&user_id="+userId, nil))
+	require.Equal(t, 200, w.Code)
+	var bootstrapped []*shared.EncHistoryEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &bootstrapped))
+	commands := make(map[string]bool)
+	for _, encEntry := range bootstrapped {
+		entry, err := data.DecryptHistoryEntry("sync-cursor-key", *encEntry)
+		require.NoError(t, err)
+		commands[entry.Command] = true
+	}
+	require.Equal(t, map[string]bool{"ls": true, "cd /": true, "echo foo": true}, commands)
+
+	// Invalid cursors are rejected
+	w = httptest.NewRecorder()
+	require.Panics(t, func() {
+		s.apiQueryHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+devId2+"&user_id="+userId+"&cursor=foo", nil))
+	})
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestProtobufEntries(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("protobuf-key")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{devId1, devId2} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+
+	// Submit an entry from device 1
+	encEntry, err := data.EncryptHistoryEntry("protobuf-key", testutils.MakeFakeHistoryEntry("ls ~/"))
+	require.NoError(t, err)
+	reqBody, err := json.Marshal([]shared.EncHistoryEntry{encEntry})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	s.apiSubmitHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId1, bytes.NewReader(reqBody)))
+	require.Equal(t, 200, w.Code)
+
+	// Bootstrapping without asking for protobuf returns JSON
+	w = httptest.NewRecorder()
+	s.apiBootstrapHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+devId2+"&user_id="+userId, nil))
+	require.Equal(t, 200, w.Code)
+	require.NotEqual(t, shared.ContentTypeProtobuf, w.Header().Get("Content-Type"))
+	var jsonEntries []*shared.EncHistoryEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jsonEntries))
+	require.Len(t, jsonEntries, 2)
+
+	// And asking for protobuf returns the same entries as protobuf
+	req := httptest.NewRequest(http.MethodGet, "/?device_id="+devId2+"&user_id="+userId, nil)
+	req.Header.Set("Accept", shared.ContentTypeProtobuf+", application/json;q=0.9")
+	w = httptest.NewRecorder()
+	s.apiBootstrapHandler(w, req)
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, shared.ContentTypeProtobuf, w.Header().Get("Content-Type"))
+	protoEntries, err := shared.UnmarshalEncHistoryEntriesProto(w.Body.Bytes())
+	require.NoError(t, err)
+	require.ElementsMatch(t, jsonEntries, protoEntries)
+
+	// Querying from device 2 also supports protobuf
+	req = httptest.NewRequest(http.MethodGet, "/?device_id="+devId2+"&user_id="+userId, nil)
+	req.Header.Set("Accept", shared.ContentTypeProtobuf)
+	w = httptest.NewRecorder()
+	s.apiQueryHandler(w, req)
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, shared.ContentTypeProtobuf, w.Header().Get("Content-Type"))
+	protoEntries, err = shared.UnmarshalEncHistoryEntriesProto(w.Body.Bytes())
+	require.NoError(t, err)
+	require.Len(t, protoEntries, 1)
+	require.Equal(t, devId2, protoEntries[0].DeviceId)
+	decEntry, err := data.DecryptHistoryEntry("protobuf-key", *protoEntries[0])
+	require.NoError(t, err)
+	require.Equal(t, "ls ~/", decEntry.Command)
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestServerVersion(t *testing.T) {
+	s := NewServer(DB, TrackUsageData(false), WithReleaseVersion("v0.123"))
+	w := httptest.NewRecorder()
+	s.serverVersionHandler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "v0.123", w.Body.String())
+}
+
+func TestHealthProbes(t *testing.T) {
+	s := NewServer(DB, TrackUsageData(false))
+	w := httptest.NewRecorder()
+	s.healthzHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	s.readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "OK", w.Body.String())
+
+	// A server whose DB hasn't been migrated is alive but not ready
+	emptyDb, err := database.OpenSQLite("file:readyz-test?mode=memory&cache=shared", &gorm.Config{})
+	require.NoError(t, err)
+	s = NewServer(emptyDb, TrackUsageData(false))
+	w = httptest.NewRecorder()
+	s.healthzHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	s.readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, 503, w.Code)
+	require.Contains(t, w.Body.String(), "not migrated")
+}
+
+func TestRunShutsDownWhenCancelled(t *testing.T) {
+	s := NewServer(DB, TrackUsageData(false))
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- s.Run(ctx, "127.0.0.1:0")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-runErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server didn't shut down after its context was cancelled")
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.OpenSQLite("file:migrations-test?mode=memory&cache=shared", &gorm.Config{})
+	require.NoError(t, err)
+	numPending, err := db.NumPendingMigrations(ctx)
+	require.NoError(t, err)
+	require.Greater(t, numPending, 0)
+
+	// Migrating applies everything, and is idempotent
+	require.NoError(t, db.Migrate(ctx))
+	require.NoError(t, db.Migrate(ctx))
+	numPending, err = db.NumPendingMigrations(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, numPending)
+	statuses, err := db.MigrationStatuses(ctx)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		require.False(t, status.AppliedAt.IsZero(), "migration %d wasn't applied", status.Version)
+	}
+	require.True(t, db.Migrator().HasIndex("enc_history_entries", "device_read_count_idx"))
+	require.True(t, db.Migrator().HasIndex("enc_history_entries", "redact_idx"))
+	require.True(t, db.Migrator().HasIndex("deletion_requests", "del_user_device_idx"))
+
+	// Rolling back reverts only the latest migration, which can then be re-applied
+	m, err := db.RollbackMigration(ctx)
+	require.NoError(t, err)
+	require.Equal(t, statuses[len(statuses)-1].Version, m.Version)
+	require.False(t, db.Migrator().HasTable(&shared.SharedEntries{}))
+	numPending, err = db.NumPendingMigrations(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, numPending)
+	require.NoError(t, db.Migrate(ctx))
+	numPending, err = db.NumPendingMigrations(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, numPending)
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	db, err := database.OpenSQLite("file:slow-query-test?mode=memory&cache=shared", &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Migrate(context.Background()))
+	var log bytes.Buffer
+	require.NoError(t, db.EnableSlowQueryLog(time.Nanosecond, &log))
+
+	_, err = db.DevicesForUser(context.Background(), "secret-user-id")
+	require.NoError(t, err)
+	require.Contains(t, log.String(), "Slow query")
+	require.Contains(t, log.String(), "SELECT * FROM `devices`")
+	// Parameters are elided
+	require.NotContains(t, log.String(), "secret-user-id")
+
+	// Queries faster than the threshold aren't logged
+	db, err = database.OpenSQLite("file:fast-query-test?mode=memory&cache=shared", &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Migrate(context.Background()))
+	log.Reset()
+	require.NoError(t, db.EnableSlowQueryLog(time.Hour, &log))
+	_, err = db.DevicesForUser(context.Background(), "secret-user-id")
+	require.NoError(t, err)
+	require.Empty(t, log.String())
+}
+
+func TestDeviceSyncModes(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("sync-modes-key")
+	readWriteDev := uuid.Must(uuid.NewRandom()).String()
+	readOnlyDev := uuid.Must(uuid.NewRandom()).String()
+	writeOnlyDev := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{readWriteDev, readOnlyDev, writeOnlyDev} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+	w := httptest.NewRecorder()
+	s.apiSetDeviceSyncModeHandler(w, httptest.NewRequest(http.MethodPost, "/?user_id="+userId+"&device_id="+readOnlyDev+"&sync_mode=read-only", nil))
+	require.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	s.apiSetDeviceSyncModeHandler(w, httptest.NewRequest(http.MethodPost, "/?user_id="+userId+"&device_id="+writeOnlyDev+"&sync_mode=write-only", nil))
+	require.Equal(t, 200, w.Code)
+	syncModes := make(map[string]shared.SyncMode)
+	for _, device := range listDevices(t, s, userId) {
+		syncModes[device.DeviceId] = device.SyncMode
+	}
+	require.Equal(t, map[string]shared.SyncMode{readWriteDev: "", readOnlyDev: shared.SyncModeReadOnly, writeOnlyDev: shared.SyncModeWriteOnly}, syncModes)
+
+	submit := func(sourceDeviceId, cmd string) {
+		encEntry, err := data.EncryptHistoryEntry("sync-modes-key", testutils.MakeFakeHistoryEntry(cmd))
+		require.NoError(t, err)
+		reqBody, err := json.Marshal([]shared.EncHistoryEntry{encEntry})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		s.apiSubmitHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+sourceDeviceId, bytes.NewReader(reqBody)))
+		require.Equal(t, 200, w.Code)
+	}
+	numEntries := func(deviceId string) int {
+		w := httptest.NewRecorder()
+		s.apiQueryHandler(w, httptest.NewRequest(http.MethodGet, "/?device_id="+deviceId+"&user_id="+userId, nil))
+		var retrievedEntries []*shared.EncHistoryEntry
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &retrievedEntries))
+		return len(retrievedEntries)
+	}
+
+	// Entries are not stored for write-only devices. Note that devices don't retrieve their own entries.
+	submit(readWriteDev, "ls")
+	submit(writeOnlyDev, "echo foo")
+	require.Equal(t, 1, numEntries(readWriteDev))
+	require.Equal(t, 2, numEntries(readOnlyDev))
+	require.Equal(t, 0, numEntries(writeOnlyDev))
+
+	// Read-only devices can't submit entries
+	func() {
+		defer func() {
+			errResp, ok := recover().(*shared.ErrorResponse)
+			require.True(t, ok)
+			require.Equal(t, shared.ErrorCodeBadRequest, errResp.Code)
+		}()
+		submit(readOnlyDev, "echo secret")
+	}()
+
+	// Invalid sync modes are rejected
+	func() {
+		defer func() {
+			errResp, ok := recover().(*shared.ErrorResponse)
+			require.True(t, ok)
+			require.Equal(t, shared.ErrorCodeBadRequest, errResp.Code)
+		}()
+		s.apiSetDeviceSyncModeHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/?user_id="+userId+"&device_id="+readWriteDev+"&sync_mode=foo", nil))
+	}()
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
 func TestLimitRegistrations(t *testing.T) {
 	// Set up
 	s := NewServer(DB, TrackUsageData(false))
@@ -527,3 +1416,380 @@ func deserializeSubmitResponse(t *testing.T, w *httptest.ResponseRecorder) share
 	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &submitResponse))
 	return submitResponse
 }
+
+func TestAdminApi(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("admin-api-key")
+	activeDev := uuid.Must(uuid.NewRandom()).String()
+	staleDev := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{activeDev, staleDev} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+	require.NoError(t, DB.Model(&database.Device{}).Where("device_id = ?", staleDev).Update("registration_date", time.Now().UTC().Add(-60*24*time.Hour)).Error)
+	staleLastUsed := time.Now().UTC().Add(-45 * 24 * time.Hour)
+	require.NoError(t, DB.CreateUsageData(context.Background(), &database.UsageData{UserId: userId, DeviceId: staleDev, LastUsed: staleLastUsed, LastIp: "1.2.3.4", Version: "v0.300"}))
+	encEntry, err := data.EncryptHistoryEntry("admin-api-key", testutils.MakeFakeHistoryEntry("ls"))
+	require.NoError(t, err)
+	reqBody, err := json.Marshal([]shared.EncHistoryEntry{encEntry})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	s.apiSubmitHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+activeDev, bytes.NewReader(reqBody)))
+	require.Equal(t, 200, w.Code)
+
+	// The user is listed along with their devices
+	w = httptest.NewRecorder()
+	s.adminUsersHandler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, 200, w.Code)
+	var users []database.UserSummary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+	numDevices := -1
+	for _, user := range users {
+		if user.UserId == userId {
+			numDevices = user.NumDevices
+		}
+	}
+	require.Equal(t, 2, numDevices)
+
+	// Users can also be listed a page at a time
+	numListed := 0
+	for offset := 0; ; offset++ {
+		w = httptest.NewRecorder()
+		s.adminUsersHandler(w, httptest.NewRequest(http.MethodGet, "/?limit=1&offset="+strconv.Itoa(offset), nil))
+		require.Equal(t, 200, w.Code)
+		var page []database.UserSummary
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		if len(page) == 0 {
+			break
+		}
+		require.Len(t, page, 1)
+		if page[0].UserId == userId {
+			numListed += 1
+		}
+	}
+	require.Equal(t, 1, numListed)
+
+	// And their storage usage is reported per-device
+	w = httptest.NewRecorder()
+	s.adminUserHandler(w, httptest.NewRequest(http.MethodGet, "/?user_id="+userId, nil))
+	require.Equal(t, 200, w.Code)
+	var userInfo AdminUserInfo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &userInfo))
+	require.Len(t, userInfo.Devices, 2)
+	for _, device := range userInfo.Devices {
+		if device.DeviceId == staleDev {
+			require.WithinDuration(t, staleLastUsed, device.LastUsed, time.Second)
+			require.Equal(t, "1.2.3.4", device.LastIp)
+			require.Equal(t, "v0.300", device.Version)
+		}
+	}
+	require.Len(t, userInfo.Storage, 1)
+	require.Equal(t, activeDev, userInfo.Storage[0].DeviceId)
+	require.Equal(t, int64(1), userInfo.Storage[0].NumEntries)
+	require.Equal(t, userInfo.Storage[0].StorageBytes, userInfo.TotalStorageBytes)
+
+	// Expiring devices only uninstalls the stale device, and a dry run doesn't uninstall anything
+	expireDevices := func(query string) []string {
+		w := httptest.NewRecorder()
+		s.adminExpireDevicesHandler(w, httptest.NewRequest(http.MethodPost, "/?inactive_days=30"+query, nil))
+		require.Equal(t, 200, w.Code)
+		var expired []database.StaleDevice
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &expired))
+		deviceIds := make([]string, 0)
+		for _, device := range expired {
+			if device.UserId == userId {
+				deviceIds = append(deviceIds, device.DeviceId)
+			}
+		}
+		return deviceIds
+	}
+	require.Equal(t, []string{staleDev}, expireDevices("&dry_run=true"))
+	require.Len(t, listDevices(t, s, userId), 2)
+	require.Equal(t, []string{staleDev}, expireDevices(""))
+	devices := listDevices(t, s, userId)
+	require.Len(t, devices, 1)
+	require.Equal(t, activeDev, devices[0].DeviceId)
+
+	// Erasing the user deletes everything, including their device registrations
+	w = httptest.NewRecorder()
+	s.adminEraseUserHandler(w, httptest.NewRequest(http.MethodPost, "/?user_id="+userId, nil))
+	require.Equal(t, 200, w.Code)
+	var numDevicesStored int64
+	require.NoError(t, DB.Model(&database.Device{}).Where("user_id = ?", userId).Count(&numDevicesStored).Error)
+	require.Equal(t, int64(0), numDevicesStored)
+	storage, err := DB.StorageForUser(context.Background(), userId)
+	require.NoError(t, err)
+	require.Empty(t, storage)
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestExportHandler(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("export-key")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{devId1, devId2} {
+		s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	}
+	for _, cmd := range []string{"ls", "echo foo"} {
+		encEntry, err := data.EncryptHistoryEntry("export-key", testutils.MakeFakeHistoryEntry(cmd))
+		require.NoError(t, err)
+		reqBody, err := json.Marshal([]shared.EncHistoryEntry{encEntry})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		s.apiSubmitHandler(w, httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId1, bytes.NewReader(reqBody)))
+		require.Equal(t, 200, w.Code)
+	}
+
+	// Each entry is exported once even though it is stored for both devices
+	w := httptest.NewRecorder()
+	s.apiExportHandler(w, httptest.NewRequest(http.MethodGet, "/?user_id="+userId+"&device_id="+devId1, nil))
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, shared.ContentTypeTakeout, w.Header().Get("Content-Type"))
+	commands := make([]string, 0)
+	err := shared.ReadTakeout(w.Body, func(encEntry *shared.EncHistoryEntry) error {
+		entry, err := data.DecryptHistoryEntry("export-key", *encEntry)
+		if err != nil {
+			return err
+		}
+		commands = append(commands, entry.Command)
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"ls", "echo foo"}, commands)
+
+	// Unknown users are rejected
+	func() {
+		defer func() {
+			errResp, ok := recover().(*shared.ErrorResponse)
+			require.True(t, ok)
+			require.Equal(t, shared.ErrorCodeUnknownUser, errResp.Code)
+		}()
+		s.apiExportHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?user_id="+data.UserId("export-unknown-key")+"&device_id="+devId1, nil))
+	}()
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestShareHandler(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("share-key")
+	devId := uuid.Must(uuid.NewRandom()).String()
+	s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+
+	// Share some entries
+	reqBody, err := json.Marshal(shared.SharedEntries{EncryptedData: []byte("ciphertext"), Nonce: []byte("nonce")})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	s.apiShareHandler(w, httptest.NewRequest(http.MethodPost, "/?user_id="+userId, bytes.NewReader(reqBody)))
+	require.Equal(t, 200, w.Code)
+	var shareResp shared.ShareResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shareResp))
+	require.NotEmpty(t, shareResp.ShareId)
+	require.True(t, shareResp.ExpirationDate.After(time.Now().Add(SHARE_RETENTION-time.Hour)))
+
+	// And they can be retrieved without a user_id, which isn't leaked
+	w = httptest.NewRecorder()
+	s.apiSharedHandler(w, httptest.NewRequest(http.MethodGet, "/?share_id="+shareResp.ShareId, nil))
+	require.Equal(t, 200, w.Code)
+	require.NotContains(t, w.Body.String(), userId)
+	var sharedEntries shared.SharedEntries
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sharedEntries))
+	require.Equal(t, []byte("ciphertext"), sharedEntries.EncryptedData)
+	require.Equal(t, []byte("nonce"), sharedEntries.Nonce)
+
+	// Unknown users can't share entries
+	func() {
+		defer func() {
+			errResp, ok := recover().(*shared.ErrorResponse)
+			require.True(t, ok)
+			require.Equal(t, shared.ErrorCodeUnknownUser, errResp.Code)
+		}()
+		s.apiShareHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/?user_id="+data.UserId("share-unknown-key"), bytes.NewReader(reqBody)))
+	}()
+
+	// Unknown and erased shares aren't found
+	_, err = DB.EraseUser(context.Background(), userId)
+	require.NoError(t, err)
+	for _, shareId := range []string{shareResp.ShareId, "unknown"} {
+		func() {
+			defer func() {
+				errResp, ok := recover().(*shared.ErrorResponse)
+				require.True(t, ok)
+				require.Equal(t, shared.ErrorCodeNotFound, errResp.Code)
+			}()
+			s.apiSharedHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?share_id="+shareId, nil))
+		}()
+	}
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
+func TestPurgeInactiveUsers(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	ctx := context.Background()
+	activeUser := data.UserId("purge-active-key")
+	inactiveUser := data.UserId("purge-inactive-key")
+	inactiveDev := uuid.Must(uuid.NewRandom()).String()
+	s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+uuid.Must(uuid.NewRandom()).String()+"&user_id="+activeUser, nil))
+	s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+inactiveDev+"&user_id="+inactiveUser, nil))
+	require.NoError(t, DB.Model(&database.Device{}).Where("user_id = ?", inactiveUser).Update("registration_date", time.Now().Add(-400*24*time.Hour)).Error)
+	lastUsed := time.Now().UTC().Add(-380 * 24 * time.Hour)
+	require.NoError(t, DB.CreateUsageData(ctx, &database.UsageData{UserId: inactiveUser, DeviceId: inactiveDev, LastUsed: lastUsed}))
+	purgedUserIds := func(purged []database.InactiveUser) []string {
+		userIds := make([]string, 0)
+		for _, user := range purged {
+			if user.UserId == activeUser || user.UserId == inactiveUser {
+				userIds = append(userIds, user.UserId)
+			}
+		}
+		return userIds
+	}
+
+	// A dry run only lists the inactive user
+	cutoff := time.Now().Add(-365 * 24 * time.Hour)
+	purged, err := DB.PurgeInactiveUsers(ctx, cutoff, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{inactiveUser}, purgedUserIds(purged))
+	for _, user := range purged {
+		if user.UserId == inactiveUser {
+			// The user was last active when their device was last used, rather than when it was registered
+			require.Equal(t, 1, user.NumDevices)
+			require.WithinDuration(t, lastUsed, user.LastActive, time.Second)
+		}
+	}
+	numDevices, err := DB.CountDevicesForUser(ctx, inactiveUser)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), numDevices)
+
+	// And then purging deletes all of their data
+	purged, err = DB.PurgeInactiveUsers(ctx, cutoff, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{inactiveUser}, purgedUserIds(purged))
+	numDevices, err = DB.CountDevicesForUser(ctx, inactiveUser)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), numDevices)
+	numDevices, err = DB.CountDevicesForUser(ctx, activeUser)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), numDevices)
+}
+
+func TestQuotas(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false), WithQuotas(Quotas{MaxEntriesPerUser: 5, MaxRequestBodyBytes: 10_000, MaxEntriesPerSubmit: 2}))
+	userId := data.UserId("quotas-key")
+	devId := uuid.Must(uuid.NewRandom()).String()
+	s.apiRegisterHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil))
+	submit := func(cmds ...string) *shared.ErrorResponse {
+		var encEntries []shared.EncHistoryEntry
+		for _, cmd := range cmds {
+			encEntry, err := data.EncryptHistoryEntry("quotas-key", testutils.MakeFakeHistoryEntry(cmd))
+			require.NoError(t, err)
+			encEntries = append(encEntries, encEntry)
+		}
+		reqBody, err := json.Marshal(encEntries)
+		require.NoError(t, err)
+		var errResp *shared.ErrorResponse
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					var ok bool
+					errResp, ok = r.(*shared.ErrorResponse)
+					require.True(t, ok, "unexpected panic: %v", r)
+				}
+			}()
+			s.apiSubmitHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId, bytes.NewReader(reqBody)))
+		}()
+		return errResp
+	}
+
+	// Submissions within the limits succeed
+	require.Nil(t, submit("ls", "pwd"))
+
+	// Too many entries in a single request
+	errResp := submit("a", "b", "c")
+	require.NotNil(t, errResp)
+	require.Equal(t, shared.ErrorCodeRequestTooLarge, errResp.Code)
+
+	// Too large of a request body
+	errResp = submit(strings.Repeat("x", 20_000))
+	require.NotNil(t, errResp)
+	require.Equal(t, shared.ErrorCodeRequestTooLarge, errResp.Code)
+	require.Equal(t, 413, errResp.Code.HttpStatusCode())
+
+	// Exceeding the number of stored entries for the user
+	require.Nil(t, submit("echo 1", "echo 2"))
+	errResp = submit("echo 3", "echo 4")
+	require.NotNil(t, errResp)
+	require.Equal(t, shared.ErrorCodeQuotaExceeded, errResp.Code)
+	require.Equal(t, 429, errResp.Code.HttpStatusCode())
+}
+
+func TestRemoteConfig(t *testing.T) {
+	s := NewServer(DB, TrackUsageData(false), WithMinClientVersion("v0.300"), WithFeatureFlags([]string{shared.FeatureAiSuggestions}, map[string]int{shared.FeatureAiExplain: 10}))
+
+	// An up to date client receives the feature flags and the forced banner
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/remote-config?device_id=dev&forced_banner=hello", nil)
+	req.Header.Set("X-Hishtory-Version", "v0.301")
+	w := httptest.NewRecorder()
+	s.apiRemoteConfigHandler(w, req)
+	require.Equal(t, 200, w.Code)
+	var remoteConfig shared.RemoteConfig
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &remoteConfig))
+	require.Equal(t, shared.RemoteConfig{
+		Banner:           "hello",
+		MinClientVersion: "v0.300",
+		DisabledFeatures: []string{shared.FeatureAiSuggestions},
+		Rollouts:         map[string]int{shared.FeatureAiExplain: 10},
+	}, remoteConfig)
+
+	// An outdated client is told to update via the banner, the same as with /api/v1/banner
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/remote-config?device_id=dev&forced_banner=hello", nil)
+	req.Header.Set("X-Hishtory-Version", "v0.299")
+	w = httptest.NewRecorder()
+	s.apiRemoteConfigHandler(w, req)
+	require.Equal(t, 200, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &remoteConfig))
+	require.Contains(t, remoteConfig.Banner, "hiSHtory v0.299 is no longer supported")
+	bannerReq := httptest.NewRequest(http.MethodGet, "/api/v1/banner?commit_hash=abc&device_id=dev", nil)
+	bannerReq.Header.Set("X-Hishtory-Version", "v0.299")
+	w = httptest.NewRecorder()
+	s.apiBannerHandler(w, bannerReq)
+	require.Equal(t, remoteConfig.Banner, w.Body.String())
+}
+
+func TestDownloadHandlerChannels(t *testing.T) {
+	s := NewServer(DB, TrackUsageData(false), WithUpdateInfo(shared.UpdateInfo{Version: "v0.300"}))
+	getVersion := func(url string) (int, string) {
+		w := httptest.NewRecorder()
+		s.apiDownloadHandler(w, httptest.NewRequest(http.MethodGet, url, nil))
+		var updateInfo shared.UpdateInfo
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updateInfo))
+		return w.Code, updateInfo.Version
+	}
+
+	// Without a beta, every channel gets the stable release
+	for _, url := range []string{"/api/v1/download", "/api/v1/download?channel=stable", "/api/v1/download?channel=beta"} {
+		code, version := getVersion(url)
+		require.Equal(t, 200, code)
+		require.Equal(t, "v0.300", version)
+	}
+
+	// With a beta, only the beta channel gets it
+	s.UpdateBetaReleaseVersion(&shared.UpdateInfo{Version: "v0.301"})
+	code, version := getVersion("/api/v1/download")
+	require.Equal(t, 200, code)
+	require.Equal(t, "v0.300", version)
+	code, version = getVersion("/api/v1/download?channel=beta")
+	require.Equal(t, 200, code)
+	require.Equal(t, "v0.301", version)
+
+	require.Panics(t, func() { getVersion("/api/v1/download?channel=nightly") })
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/ddworken/hishtory/backend/server/internal/database"
 	"github.com/ddworken/hishtory/shared"
+	"golang.org/x/crypto/acme/autocert"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 )
 
@@ -25,8 +26,32 @@ type Server struct {
 	releaseVersion          string
 	cronFn                  CronFn
 	updateInfo              shared.UpdateInfo
+	betaUpdateInfo          *shared.UpdateInfo
+	webAppDir               string
+	minClientVersion        *shared.ParsedVersion
+	tlsCertFile             string
+	tlsKeyFile              string
+	autocertDomains         []string
+	autocertCacheDir        string
+	adminToken              string
+	quotas                  Quotas
+	disabledFeatures        []string
+	featureRollouts         map[string]int
 }
 
+// Limits that protect the DB from runaway clients. Zero values mean unlimited.
+type Quotas struct {
+	// The maximum number of entries stored for a user, counting each copy that is stored for each of their devices
+	MaxEntriesPerUser int64
+	// The maximum size of the (decompressed) body of a request that submits entries
+	MaxRequestBodyBytes int64
+	// The maximum number of entries in a single request that submits entries
+	MaxEntriesPerSubmit int
+}
+
+// How long Run waits for in-flight requests to finish once its context is cancelled
+const SHUTDOWN_TIMEOUT = 30 * time.Second
+
 type CronFn func(ctx context.Context, db *database.DB, stats *statsd.Client) error
 type Option func(*Server)
 
@@ -54,6 +79,70 @@ func WithUpdateInfo(updateInfo shared.UpdateInfo) Option {
 	}
 }
 
+// WithWebAppDir serves the web UI (see backend/web/app/) from the given directory under /web/. If empty, the web UI
+// is disabled.
+func WithWebAppDir(dir string) Option {
+	return func(s *Server) {
+		s.webAppDir = dir
+	}
+}
+
+// WithMinClientVersion rejects requests from clients older than the given version (e.g. "v0.300") so that old protocol
+// behaviors can be cleanly deprecated. If empty, all client versions are supported.
+func WithMinClientVersion(minClientVersion string) Option {
+	return func(s *Server) {
+		if minClientVersion == "" {
+			return
+		}
+		pv, err := shared.ParseVersionString(minClientVersion)
+		if err != nil {
+			panic(fmt.Errorf("invalid minimum client version: %w", err))
+		}
+		s.minClientVersion = &pv
+	}
+}
+
+// WithTLS serves over HTTPS using the given certificate and private key files
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.tlsCertFile = certFile
+		s.tlsKeyFile = keyFile
+	}
+}
+
+// WithAutocert serves over HTTPS using certificates that are automatically requested from Let's Encrypt for the given
+// domains (via the TLS-ALPN-01 challenge, so the server must be reachable on port 443) and cached in cacheDir
+func WithAutocert(domains []string, cacheDir string) Option {
+	return func(s *Server) {
+		s.autocertDomains = domains
+		s.autocertCacheDir = cacheDir
+	}
+}
+
+// WithAdminToken enables the admin API under /api/v1/admin/ for requests that send the given token via an
+// `Authorization: Bearer` header. If empty, the admin API is disabled.
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.adminToken = token
+	}
+}
+
+// WithQuotas limits how much data clients can submit, see Quotas
+func WithQuotas(quotas Quotas) Option {
+	return func(s *Server) {
+		s.quotas = quotas
+	}
+}
+
+// WithFeatureFlags configures the client features that /api/v1/remote-config disables for all clients, and the
+// features that are only enabled for a percentage (0-100) of users
+func WithFeatureFlags(disabledFeatures []string, rollouts map[string]int) Option {
+	return func(s *Server) {
+		s.disabledFeatures = disabledFeatures
+		s.featureRollouts = rollouts
+	}
+}
+
 func IsProductionEnvironment(v bool) Option {
 	return func(s *Server) {
 		s.isProductionEnvironment = v
@@ -83,6 +172,7 @@ func NewServer(db *database.DB, options ...Option) *Server {
 	return &srv
 }
 
+// Serves requests on the given address until ctx is cancelled, at which point in-flight requests are drained
 func (s *Server) Run(ctx context.Context, addr string) error {
 	mux := httptrace.NewServeMux()
 
@@ -99,25 +189,58 @@ func (s *Server) Run(ctx context.Context, addr string) error {
 		withLogging(s.statsd, os.Stdout),
 	)
 
-	mux.Handle("/api/v1/submit", middlewares(http.HandlerFunc(s.apiSubmitHandler)))
-	mux.Handle("/api/v1/get-dump-requests", middlewares(http.HandlerFunc(s.apiGetPendingDumpRequestsHandler)))
-	mux.Handle("/api/v1/submit-dump", middlewares(http.HandlerFunc(s.apiSubmitDumpHandler)))
-	mux.Handle("/api/v1/query", middlewares(http.HandlerFunc(s.apiQueryHandler)))
-	mux.Handle("/api/v1/bootstrap", middlewares(http.HandlerFunc(s.apiBootstrapHandler)))
-	mux.Handle("/api/v1/register", middlewares(http.HandlerFunc(s.apiRegisterHandler)))
+	// Endpoints that outdated clients need in order to learn that they should update (banner and remote-config), to update (download and
+	// slsa-status), or to uninstall (uninstall and feedback) don't enforce the minimum client version
+	versionedMiddlewares := mergeMiddlewares(middlewares, withMinClientVersion(s.minClientVersion))
+	// Endpoints that transfer large batches of entries additionally support gzip compression
+	compressedMiddlewares := mergeMiddlewares(versionedMiddlewares, withCompression())
+	adminMiddlewares := mergeMiddlewares(middlewares, withAdminToken(s.adminToken))
+
+	mux.Handle("/api/v1/submit", compressedMiddlewares(http.HandlerFunc(s.apiSubmitHandler)))
+	mux.Handle("/api/v1/submit-batch", compressedMiddlewares(http.HandlerFunc(s.apiSubmitBatchHandler)))
+	mux.Handle("/api/v1/get-dump-requests", versionedMiddlewares(http.HandlerFunc(s.apiGetPendingDumpRequestsHandler)))
+	mux.Handle("/api/v1/submit-dump", versionedMiddlewares(http.HandlerFunc(s.apiSubmitDumpHandler)))
+	mux.Handle("/api/v1/reencrypt", compressedMiddlewares(http.HandlerFunc(s.apiReencryptHandler)))
+	mux.Handle("/api/v1/query", compressedMiddlewares(http.HandlerFunc(s.apiQueryHandler)))
+	mux.Handle("/api/v1/bootstrap", compressedMiddlewares(http.HandlerFunc(s.apiBootstrapHandler)))
+	mux.Handle("/api/v1/register", versionedMiddlewares(http.HandlerFunc(s.apiRegisterHandler)))
 	mux.Handle("/api/v1/banner", middlewares(http.HandlerFunc(s.apiBannerHandler)))
+	mux.Handle("/api/v1/remote-config", middlewares(http.HandlerFunc(s.apiRemoteConfigHandler)))
 	mux.Handle("/api/v1/download", middlewares(http.HandlerFunc(s.apiDownloadHandler)))
 	mux.Handle("/api/v1/trigger-cron", middlewares(http.HandlerFunc(s.triggerCronHandler)))
-	mux.Handle("/api/v1/get-deletion-requests", middlewares(http.HandlerFunc(s.getDeletionRequestsHandler)))
-	mux.Handle("/api/v1/add-deletion-request", middlewares(http.HandlerFunc(s.addDeletionRequestHandler)))
+	mux.Handle("/api/v1/get-deletion-requests", versionedMiddlewares(http.HandlerFunc(s.getDeletionRequestsHandler)))
+	mux.Handle("/api/v1/add-deletion-request", versionedMiddlewares(http.HandlerFunc(s.addDeletionRequestHandler)))
+	mux.Handle("/api/v1/ack-deletion-requests", versionedMiddlewares(http.HandlerFunc(s.ackDeletionRequestsHandler)))
 	mux.Handle("/api/v1/slsa-status", middlewares(http.HandlerFunc(s.slsaStatusHandler)))
 	mux.Handle("/api/v1/feedback", middlewares(http.HandlerFunc(s.feedbackHandler)))
 	mux.Handle("/api/v1/uninstall", middlewares(http.HandlerFunc(s.apiUninstallHandler)))
-	mux.Handle("/api/v1/ai-suggest", middlewares(http.HandlerFunc(s.aiSuggestionHandler)))
+	mux.Handle("/api/v1/delete-user", versionedMiddlewares(http.HandlerFunc(s.apiDeleteUserHandler)))
+	mux.Handle("/api/v1/devices", versionedMiddlewares(http.HandlerFunc(s.apiDevicesHandler)))
+	mux.Handle("/api/v1/export", versionedMiddlewares(http.HandlerFunc(s.apiExportHandler)))
+	mux.Handle("/api/v1/share", versionedMiddlewares(http.HandlerFunc(s.apiShareHandler)))
+	mux.Handle("/api/v1/shared", middlewares(http.HandlerFunc(s.apiSharedHandler)))
+	mux.Handle("/api/v1/account-info", versionedMiddlewares(http.HandlerFunc(s.apiAccountInfoHandler)))
+	mux.Handle("/api/v1/rename-device", versionedMiddlewares(http.HandlerFunc(s.apiRenameDeviceHandler)))
+	mux.Handle("/api/v1/revoke-device", versionedMiddlewares(http.HandlerFunc(s.apiRevokeDeviceHandler)))
+	mux.Handle("/api/v1/set-device-sync-mode", versionedMiddlewares(http.HandlerFunc(s.apiSetDeviceSyncModeHandler)))
+	mux.Handle("/api/v1/ai-suggest", versionedMiddlewares(http.HandlerFunc(s.aiSuggestionHandler)))
+	mux.Handle("/api/v1/ai-explain", versionedMiddlewares(http.HandlerFunc(s.aiExplanationHandler)))
+	mux.Handle("/api/v1/ai-feedback", versionedMiddlewares(http.HandlerFunc(s.aiFeedbackHandler)))
 	mux.Handle("/api/v1/ping", middlewares(http.HandlerFunc(s.pingHandler)))
+	mux.Handle("/api/v1/server-version", middlewares(http.HandlerFunc(s.serverVersionHandler)))
 	mux.Handle("/healthcheck", middlewares(http.HandlerFunc(s.healthCheckHandler)))
+	// Probes are called frequently by load balancers and Kubernetes, so they aren't logged
+	mux.Handle("/healthz", withPanicGuard(s.statsd)(http.HandlerFunc(s.healthzHandler)))
+	mux.Handle("/readyz", withPanicGuard(s.statsd)(http.HandlerFunc(s.readyzHandler)))
 	mux.Handle("/internal/api/v1/usage-stats", middlewares(http.HandlerFunc(s.usageStatsHandler)))
 	mux.Handle("/internal/api/v1/stats", middlewares(http.HandlerFunc(s.statsHandler)))
+	mux.Handle("/api/v1/admin/users", adminMiddlewares(http.HandlerFunc(s.adminUsersHandler)))
+	mux.Handle("/api/v1/admin/user", adminMiddlewares(http.HandlerFunc(s.adminUserHandler)))
+	mux.Handle("/api/v1/admin/erase-user", adminMiddlewares(http.HandlerFunc(s.adminEraseUserHandler)))
+	mux.Handle("/api/v1/admin/expire-devices", adminMiddlewares(http.HandlerFunc(s.adminExpireDevicesHandler)))
+	if s.webAppDir != "" {
+		mux.Handle("/web/", middlewares(http.StripPrefix("/web/", http.FileServer(http.Dir(s.webAppDir)))))
+	}
 	if s.isTestEnvironment {
 		mux.Handle("/api/v1/ai-suggest-override", middlewares(http.HandlerFunc(s.testOnlyOverrideAiSuggestions)))
 		mux.Handle("/api/v1/wipe-db-entries", middlewares(http.HandlerFunc(s.wipeDbEntriesHandler)))
@@ -129,21 +252,58 @@ func (s *Server) Run(ctx context.Context, addr string) error {
 		Handler: mux,
 	}
 
-	fmt.Printf("Listening on %s\n", addr)
-	if err := httpServer.ListenAndServe(); err != nil {
-		if !errors.Is(err, http.ErrServerClosed) {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.listenAndServe(httpServer)
+	}()
+	select {
+	case err := <-serveErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return fmt.Errorf("http.ListenAndServe: %w", err)
 		}
+		return nil
+	case <-ctx.Done():
 	}
 
+	// Stop accepting new connections and wait for in-flight requests (e.g. submissions) to finish so that rolling
+	// deployments don't drop them
+	fmt.Printf("Shutting down, waiting up to %s for in-flight requests to finish\n", SHUTDOWN_TIMEOUT)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to gracefully shut down: %w", err)
+	}
 	return nil
 }
 
+func (s *Server) listenAndServe(httpServer *http.Server) error {
+	if len(s.autocertDomains) > 0 {
+		certManager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(s.autocertDomains...),
+			Cache:      autocert.DirCache(s.autocertCacheDir),
+		}
+		httpServer.TLSConfig = certManager.TLSConfig()
+		fmt.Printf("Listening on %s with TLS certificates for %s\n", httpServer.Addr, strings.Join(s.autocertDomains, ", "))
+		return httpServer.ListenAndServeTLS("", "")
+	} else if s.tlsCertFile != "" {
+		fmt.Printf("Listening on %s with TLS\n", httpServer.Addr)
+		return httpServer.ListenAndServeTLS(s.tlsCertFile, s.tlsKeyFile)
+	}
+	fmt.Printf("Listening on %s\n", httpServer.Addr)
+	return httpServer.ListenAndServe()
+}
+
 func (s *Server) UpdateReleaseVersion(v string, updateInfo shared.UpdateInfo) {
 	s.releaseVersion = v
 	s.updateInfo = updateInfo
 }
 
+// Sets the update info served for the beta channel, or nil if there is no beta that is newer than the stable release
+func (s *Server) UpdateBetaReleaseVersion(updateInfo *shared.UpdateInfo) {
+	s.betaUpdateInfo = updateInfo
+}
+
 func (s *Server) handleNonCriticalError(err error) {
 	if err != nil {
 		if s.isProductionEnvironment {
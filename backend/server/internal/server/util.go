@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
@@ -9,6 +11,7 @@ import (
 	"runtime"
 	"strconv"
 
+	"github.com/ddworken/hishtory/shared"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
@@ -77,7 +80,7 @@ func getRemoteAddr(r *http.Request) string {
 func getRequiredQueryParam(r *http.Request, queryParam string) string {
 	val := r.URL.Query().Get(queryParam)
 	if val == "" {
-		panic(fmt.Sprintf("request to %s is missing required query param=%#v", r.URL, queryParam))
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "request to %s is missing required query param=%#v", r.URL.Path, queryParam))
 	}
 	return val
 }
@@ -85,7 +88,7 @@ func getRequiredQueryParam(r *http.Request, queryParam string) string {
 func getOptionalQueryParam(r *http.Request, queryParam string, isRequiredInTestEnvironment bool) string {
 	val := r.URL.Query().Get(queryParam)
 	if val == "" && isRequiredInTestEnvironment {
-		panic(fmt.Sprintf("request to %s is missing optional query param=%#v that is required in test environments", r.URL, queryParam))
+		panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "request to %s is missing optional query param=%#v that is required in test environments", r.URL.Path, queryParam))
 	}
 	return val
 }
@@ -98,3 +101,38 @@ func checkGormError(err error) {
 	_, filename, line, _ := runtime.Caller(1)
 	panic(fmt.Sprintf("DB error at %s:%d: %v", filename, line, err))
 }
+
+// Limits the size of the request body to the configured quota. Requests that exceed it will fail to decode, which is
+// then reported via checkDecodeError.
+func (s *Server) limitRequestBody(w http.ResponseWriter, r *http.Request) {
+	if s.quotas.MaxRequestBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.quotas.MaxRequestBodyBytes)
+	}
+}
+
+// Panics with an appropriate error response if decoding the request body failed
+func checkDecodeError(err error) {
+	if err == nil {
+		return
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		panic(shared.NewErrorResponse(shared.ErrorCodeRequestTooLarge, "request body is larger than the maximum of %d bytes", maxBytesErr.Limit))
+	}
+	panic(shared.NewErrorResponse(shared.ErrorCodeBadRequest, "failed to decode: %v", err))
+}
+
+// Panics with an appropriate error response if storing the given number of new entries for the user would exceed the
+// configured quotas. numCopies is the number of devices that each entry is stored for.
+func (s *Server) checkEntryQuotas(ctx context.Context, userId string, numEntries, numCopies int) {
+	if s.quotas.MaxEntriesPerSubmit > 0 && numEntries > s.quotas.MaxEntriesPerSubmit {
+		panic(shared.NewErrorResponse(shared.ErrorCodeRequestTooLarge, "request contains %d entries, but the maximum per request is %d", numEntries, s.quotas.MaxEntriesPerSubmit))
+	}
+	if s.quotas.MaxEntriesPerUser > 0 && numEntries > 0 {
+		numStored, err := s.db.CountHistoryEntriesForUser(ctx, userId)
+		checkGormError(err)
+		if numStored+int64(numEntries*numCopies) > s.quotas.MaxEntriesPerUser {
+			panic(shared.NewErrorResponse(shared.ErrorCodeQuotaExceeded, "user_id=%s has %d stored entries, and storing %d more would exceed the maximum of %d", userId, numStored, numEntries*numCopies, s.quotas.MaxEntriesPerUser))
+		}
+	}
+}
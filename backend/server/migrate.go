@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rodaine/table"
+)
+
+// Runs `hishtory-server migrate`, which manages the DB schema migrations that the server otherwise applies at startup
+func runMigrateCommand(args []string) error {
+	ctx := context.Background()
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "status":
+		statuses, err := InitDB().MigrationStatuses(ctx)
+		if err != nil {
+			return err
+		}
+		tbl := table.New("Version", "Description", "Applied At")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if !status.AppliedAt.IsZero() {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			tbl.AddRow(status.Version, status.Description, appliedAt)
+		}
+		tbl.Print()
+		return nil
+	case "up":
+		if err := InitDB().Migrate(ctx); err != nil {
+			return err
+		}
+		fmt.Println("Applied all pending migrations")
+		return nil
+	case "down":
+		m, err := InitDB().RollbackMigration(ctx)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			fmt.Println("No migrations have been applied")
+		} else {
+			fmt.Printf("Rolled back migration %d (%s)\n", m.Version, m.Description)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %#v, expected one of status, up, or down", command)
+	}
+}
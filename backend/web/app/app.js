@@ -0,0 +1,105 @@
+// The web UI for browsing your history. All decryption happens client-side via the WASM module built from
+// client/wasm/, so the server only ever sees the user ID and encrypted history entries.
+
+const MAX_DISPLAYED_RESULTS = 500;
+
+let historyEntries = [];
+
+function setStatus(message) {
+  document.getElementById("status").textContent = message;
+}
+
+async function loadWasm() {
+  const go = new Go();
+  const result = await WebAssembly.instantiateStreaming(
+    fetch("hishtory.wasm"),
+    go.importObject,
+  );
+  go.run(result.instance);
+}
+
+async function loadHistory(userSecret) {
+  setStatus("Downloading encrypted history...");
+  const userId = hishtoryUserId(userSecret);
+  // Download a takeout archive (rather than bootstrapping like a new device) so that the web UI isn't recorded as
+  // a device and each entry is only downloaded once
+  const resp = await fetch("/api/v1/export?user_id=" + encodeURIComponent(userId));
+  if (!resp.ok) {
+    throw new Error("failed to download history: status_code=" + resp.status);
+  }
+  const archive = new Uint8Array(await resp.arrayBuffer());
+  setStatus("Decrypting history...");
+  const decrypted = hishtoryDecryptTakeout(userSecret, archive);
+  if (decrypted.error) {
+    throw new Error(decrypted.error);
+  }
+  historyEntries = JSON.parse(decrypted);
+  historyEntries.sort((a, b) => new Date(b.end_time) - new Date(a.end_time));
+  setStatus("Loaded " + historyEntries.length + " history entries");
+}
+
+// Matches the free-text semantics of `hishtory query`: every term must appear in the command, hostname, or CWD
+function matchesQuery(entry, terms) {
+  return terms.every((term) => {
+    const negated = term.startsWith("-") && term.length > 1;
+    const t = negated ? term.substring(1) : term;
+    const matches =
+      entry.command.includes(t) ||
+      entry.hostname.includes(t) ||
+      entry.current_working_directory.includes(t);
+    return negated ? !matches : matches;
+  });
+}
+
+function renderResults(query) {
+  const terms = query.split(/\s+/).filter((t) => t !== "");
+  const tbody = document.getElementById("search-results");
+  tbody.replaceChildren();
+  let numResults = 0;
+  for (const entry of historyEntries) {
+    if (!matchesQuery(entry, terms)) {
+      continue;
+    }
+    const row = document.createElement("tr");
+    row.className = "table-light";
+    const cells = [
+      entry.hostname,
+      entry.current_working_directory,
+      new Date(entry.start_time).toLocaleString(),
+      String(entry.exit_code),
+      entry.command,
+    ];
+    for (const value of cells) {
+      const cell = document.createElement("td");
+      // Note: Always use textContent so that commands can't inject HTML
+      cell.textContent = value;
+      row.appendChild(cell);
+    }
+    tbody.appendChild(row);
+    numResults += 1;
+    if (numResults >= MAX_DISPLAYED_RESULTS) {
+      break;
+    }
+  }
+}
+
+window.addEventListener("DOMContentLoaded", () => {
+  const wasmLoaded = loadWasm();
+  document.getElementById("login-form").addEventListener("submit", async (e) => {
+    e.preventDefault();
+    try {
+      await wasmLoaded;
+      await loadHistory(document.getElementById("secret-input").value.trim());
+    } catch (err) {
+      setStatus("Error: " + err.message);
+      return;
+    }
+    document.getElementById("login-form").style.display = "none";
+    document.getElementById("search-form").style.display = "flex";
+    renderResults("");
+  });
+  document.getElementById("search-form").addEventListener("submit", (e) => e.preventDefault());
+  document.getElementById("search-input").addEventListener("input", (e) => {
+    renderResults(e.target.value);
+  });
+});
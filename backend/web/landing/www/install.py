@@ -9,6 +9,7 @@ import urllib.request
 import platform
 import sys
 import os
+import shlex
 
 def get_executable_tmpdir():
     specified_dir = os.environ.get('TMPDIR', '') 
@@ -29,10 +30,12 @@ download_options = json.loads(resp_body)
 
 if platform.system() == 'Linux' and platform.machine() == "x86_64":
     download_url = download_options['linux_amd_64_url']
-elif platform.system() == 'Linux' and platform.machine() == "aarch64":
+elif platform.system() == 'Linux' and platform.machine() in ("aarch64", "arm64"):
     download_url = download_options['linux_arm_64_url']
 elif platform.system() == 'Linux' and platform.machine() == "armv7l":
     download_url = download_options['linux_arm_7_url']
+elif platform.system() == 'FreeBSD' and platform.machine() == "amd64":
+    download_url = download_options['freebsd_amd_64_url']
 elif platform.system() == 'Darwin' and platform.machine() == 'arm64':
     download_url = download_options['darwin_arm_64_url']
 elif platform.system() == 'Darwin' and platform.machine() == 'x86_64':
@@ -53,6 +56,10 @@ os.system('chmod +x ' + tmpFilePath)
 cmd = tmpFilePath + ' install'
 if os.environ.get('HISHTORY_OFFLINE'):
     cmd += " --offline"
+if os.environ.get('HISHTORY_EPHEMERAL'):
+    cmd += " --ephemeral"
+if os.environ.get('HISHTORY_DB_PATH'):
+    cmd += " --db-path " + shlex.quote(os.environ['HISHTORY_DB_PATH'])
 exitCode = os.system(cmd)
 os.remove(tmpFilePath)
 if exitCode != 0:
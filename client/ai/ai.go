@@ -26,13 +26,90 @@ func DebouncedGetAiSuggestions(ctx context.Context, shellName, query string, num
 	return nil, nil
 }
 
+// Cancel any pending call to DebouncedGetAiSuggestions that hasn't yet been sent
+func CancelDebouncedAiSuggestions() {
+	mostRecentQuery = ""
+}
+
 func GetAiSuggestions(ctx context.Context, shellName, query string, numberCompletions int) ([]string, error) {
-	if os.Getenv("OPENAI_API_KEY") == "" && hctx.GetConf(ctx).AiCompletionEndpoint == ai.DefaultOpenAiEndpoint {
-		return GetAiSuggestionsViaHishtoryApi(ctx, shellName, query, numberCompletions)
-	} else {
-		suggestions, _, err := ai.GetAiSuggestionsViaOpenAiApi(hctx.GetConf(ctx).AiCompletionEndpoint, query, shellName, getOsName(), numberCompletions)
-		return suggestions, err
+	return GetAiChatSuggestions(ctx, shellName, nil, query, numberCompletions)
+}
+
+// Gets suggestions for query, which refines the suggestions from the given previous turns of a conversation. Suggestions
+// are cached for AI_CACHE_TTL so that repeated queries don't re-query the AI. If the AI endpoint is unreachable, this
+// returns any cached suggestions (regardless of their age) along with an *AiUnavailableError.
+func GetAiChatSuggestions(ctx context.Context, shellName string, history []ai.AiChatTurn, query string, numberCompletions int) ([]string, error) {
+	cacheKey := getAiCacheKey(ctx, shellName, history, query)
+	cached, err := lib.GetCachedAiSuggestions(ctx, cacheKey, AI_CACHE_TTL)
+	if err != nil {
+		hctx.GetLogger().Infof("Failed to look up cached AI suggestions: %v", err)
+	}
+	if len(cached) > 0 {
+		hctx.GetLogger().Infof("Using cached AI suggestions for query=%#v ==> %#v", query, cached)
+		return cached, nil
+	}
+	suggestions, err := queryAiChatSuggestions(ctx, shellName, history, query, numberCompletions)
+	if err != nil {
+		if !lib.IsOfflineError(ctx, err) {
+			return nil, err
+		}
+		stale, cacheErr := lib.GetCachedAiSuggestions(ctx, cacheKey, 0)
+		if cacheErr != nil {
+			hctx.GetLogger().Infof("Failed to look up cached AI suggestions: %v", cacheErr)
+		}
+		return stale, &AiUnavailableError{Err: err, HasCachedSuggestions: len(stale) > 0}
+	}
+	if err := lib.CacheAiSuggestions(ctx, cacheKey, suggestions); err != nil {
+		hctx.GetLogger().Infof("Failed to cache AI suggestions: %v", err)
+	}
+	return suggestions, nil
+}
+
+func queryAiChatSuggestions(ctx context.Context, shellName string, history []ai.AiChatTurn, query string, numberCompletions int) ([]string, error) {
+	config := hctx.GetConf(ctx)
+	var aiContext *ai.AiContext
+	if config.AiIncludeContext {
+		aiContext = GetAiContext(ctx)
+	}
+	if shouldUseHishtoryApi(ctx) {
+		return GetAiSuggestionsViaHishtoryApi(ctx, shellName, history, aiContext, query, numberCompletions)
+	}
+	provider, err := getConfiguredProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ai.GetChatSuggestionsViaProvider(provider, history, query, shellName, getOsName(), aiContext, numberCompletions)
+}
+
+// Gets an explanation of what the given command does (including its flags and any risks of running it)
+func ExplainCommand(ctx context.Context, shellName, command string) (string, error) {
+	if shouldUseHishtoryApi(ctx) {
+		return explainCommandViaHishtoryApi(ctx, shellName, command)
+	}
+	provider, err := getConfiguredProvider(ctx)
+	if err != nil {
+		return "", err
+	}
+	return ai.ExplainCommandViaProvider(provider, command, shellName, getOsName())
+}
+
+// Whether AI queries should be proxied through the hishtory API, which is the case when the user hasn't configured
+// their own OpenAI API key, endpoint, or provider
+func shouldUseHishtoryApi(ctx context.Context) bool {
+	config := hctx.GetConf(ctx)
+	isOpenAi := config.AiProvider == "" || config.AiProvider == ai.ProviderOpenAi
+	return isOpenAi && os.Getenv("OPENAI_API_KEY") == "" && config.AiCompletionEndpoint == ai.DefaultOpenAiEndpoint
+}
+
+func getConfiguredProvider(ctx context.Context) (ai.Provider, error) {
+	config := hctx.GetConf(ctx)
+	isOpenAi := config.AiProvider == "" || config.AiProvider == ai.ProviderOpenAi
+	endpoint := config.AiCompletionEndpoint
+	if !isOpenAi && endpoint == ai.DefaultOpenAiEndpoint {
+		// The endpoint defaults to OpenAI's, so use the provider's default endpoint instead
+		endpoint = ""
 	}
+	return ai.GetProvider(config.AiProvider, config.AiModel, endpoint)
 }
 
 func getOsName() string {
@@ -50,12 +127,14 @@ func getOsName() string {
 		return "Linux"
 	case "darwin":
 		return "MacOS"
+	case "windows":
+		return "Windows"
 	default:
 		return runtime.GOOS
 	}
 }
 
-func GetAiSuggestionsViaHishtoryApi(ctx context.Context, shellName, query string, numberCompletions int) ([]string, error) {
+func GetAiSuggestionsViaHishtoryApi(ctx context.Context, shellName string, history []ai.AiChatTurn, aiContext *ai.AiContext, query string, numberCompletions int) ([]string, error) {
 	hctx.GetLogger().Infof("Running OpenAI query for %#v", query)
 	req := ai.AiSuggestionRequest{
 		DeviceId:          hctx.GetConf(ctx).DeviceId,
@@ -64,6 +143,8 @@ func GetAiSuggestionsViaHishtoryApi(ctx context.Context, shellName, query string
 		NumberCompletions: numberCompletions,
 		OsName:            getOsName(),
 		ShellName:         shellName,
+		History:           history,
+		Context:           aiContext,
 	}
 	reqData, err := json.Marshal(req)
 	if err != nil {
@@ -81,3 +162,28 @@ func GetAiSuggestionsViaHishtoryApi(ctx context.Context, shellName, query string
 	hctx.GetLogger().Infof("For OpenAI query=%#v ==> %#v", query, resp.Suggestions)
 	return resp.Suggestions, nil
 }
+
+func explainCommandViaHishtoryApi(ctx context.Context, shellName, command string) (string, error) {
+	hctx.GetLogger().Infof("Running OpenAI explanation query for %#v", command)
+	req := ai.AiExplanationRequest{
+		DeviceId:  hctx.GetConf(ctx).DeviceId,
+		UserId:    data.UserId(hctx.GetConf(ctx).UserSecret),
+		Command:   command,
+		OsName:    getOsName(),
+		ShellName: shellName,
+	}
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal AiExplanationRequest: %w", err)
+	}
+	respData, err := lib.ApiPost(ctx, "/api/v1/ai-explain", "application/json", reqData)
+	if err != nil {
+		return "", fmt.Errorf("failed to query /api/v1/ai-explain: %w", err)
+	}
+	var resp ai.AiExplanationResponse
+	err = json.Unmarshal(respData, &resp)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse /api/v1/ai-explain response: %w", err)
+	}
+	return resp.Explanation, nil
+}
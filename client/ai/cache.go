@@ -0,0 +1,62 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared/ai"
+)
+
+// How long AI suggestions are cached for before the AI is queried again
+const AI_CACHE_TTL = 7 * 24 * time.Hour
+
+// Returned when the AI endpoint is unreachable (e.g. because the user is offline), in which case any cached suggestions
+// for the query are returned instead
+type AiUnavailableError struct {
+	Err error
+	// Whether cached suggestions were returned along with this error
+	HasCachedSuggestions bool
+}
+
+func (e *AiUnavailableError) Error() string {
+	if e.HasCachedSuggestions {
+		return fmt.Sprintf("the AI endpoint is unreachable, so these are previously cached suggestions: %v", e.Err)
+	}
+	return fmt.Sprintf("the AI endpoint is unreachable and there are no cached suggestions for this query: %v", e.Err)
+}
+
+func (e *AiUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the key that AI suggestions are cached under. This covers everything that affects the suggestions except
+// for the optional environment context (see GetAiContext), since that changes with every command that is run.
+func getAiCacheKey(ctx context.Context, shellName string, history []ai.AiChatTurn, query string) string {
+	config := hctx.GetConf(ctx)
+	key := struct {
+		Provider string
+		Model    string
+		Endpoint string
+		Shell    string
+		History  []ai.AiChatTurn
+		Query    string
+	}{config.AiProvider, config.AiModel, config.AiCompletionEndpoint, shellName, history, normalizeAiQuery(query)}
+	serialized, err := json.Marshal(key)
+	if err != nil {
+		// This can't happen since the key only contains strings
+		panic(fmt.Errorf("failed to serialize AI cache key: %w", err))
+	}
+	hash := sha256.Sum256(serialized)
+	return hex.EncodeToString(hash[:])
+}
+
+// Normalizes an AI query so that trivially different queries (e.g. "List  files" and "list files") share a cache entry
+func normalizeAiQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
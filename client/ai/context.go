@@ -0,0 +1,105 @@
+package ai
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/shared/ai"
+)
+
+// The tools whose versions are included in the AI context (if they're installed), along with the arguments that make
+// them print their version
+var AI_CONTEXT_TOOLS = [][]string{
+	{"git", "--version"},
+	{"docker", "--version"},
+	{"kubectl", "version", "--client"},
+	{"python3", "--version"},
+	{"node", "--version"},
+	{"go", "version"},
+}
+
+// The maximum amount of time to wait for a tool to print its version
+const TOOL_VERSION_TIMEOUT = time.Second
+
+var (
+	toolVersionsOnce sync.Once
+	toolVersions     []string
+)
+
+// Returns the context about the user's environment that is sent along with AI queries when enabled via
+// `hishtory config-set ai-include-context true`: The current directory, the CPU architecture, the most recently run
+// commands, and the versions of the tools in AI_CONTEXT_TOOLS.
+func GetAiContext(ctx context.Context) *ai.AiContext {
+	aiContext := &ai.AiContext{Arch: runtime.GOARCH, ToolVersions: getToolVersions()}
+	if cwd, err := os.Getwd(); err == nil {
+		aiContext.Cwd = cwd
+	}
+	recentCommands, err := getRecentCommands(ctx)
+	if err != nil {
+		hctx.GetLogger().Infof("Failed to retrieve recent commands for the AI context: %v", err)
+	}
+	aiContext.RecentCommands = recentCommands
+	return aiContext
+}
+
+// Returns the most recent distinct commands, most recent first
+func getRecentCommands(ctx context.Context) ([]string, error) {
+	entries, err := lib.Search(ctx, hctx.GetDb(ctx), "", ai.MaxAiContextCommands*3)
+	if err != nil {
+		return nil, err
+	}
+	commands := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if len(commands) >= ai.MaxAiContextCommands {
+			break
+		}
+		if seen[entry.Command] {
+			continue
+		}
+		seen[entry.Command] = true
+		commands = append(commands, entry.Command)
+	}
+	return commands, nil
+}
+
+// Returns the versions of the installed tools in AI_CONTEXT_TOOLS. These are only detected once per process since
+// AI suggestions are requested as the user types.
+func getToolVersions() []string {
+	toolVersionsOnce.Do(func() {
+		toolVersions = make([]string, 0)
+		for _, tool := range AI_CONTEXT_TOOLS {
+			if version := getToolVersion(tool[0], tool[1:]...); version != "" {
+				toolVersions = append(toolVersions, version)
+			}
+		}
+	})
+	return toolVersions
+}
+
+// Returns the first line of the tool's version output, or an empty string if the tool isn't installed
+func getToolVersion(name string, args ...string) string {
+	if _, err := exec.LookPath(name); err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), TOOL_VERSION_TIMEOUT)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		hctx.GetLogger().Infof("Failed to get the version of %s for the AI context: %v", name, err)
+		return ""
+	}
+	version := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if !strings.Contains(strings.ToLower(version), strings.ToLower(name)) {
+		// Some tools (e.g. node) only print a version number
+		version = name + " " + version
+	}
+	return version
+}
@@ -1,3 +1,5 @@
+//go:build !windows
+
 package main
 
 import (
@@ -2104,11 +2106,20 @@ func testTui_general(t *testing.T, onlineStatus OnlineStatus) {
 	out = stripTuiCommandPrefix(t, out)
 	testutils.CompareGoldens(t, out, "TestTui-HelpPage")
 
-	// Test closing the help page
+	// Test opening the full-screen key binding reference from the expanded help page
 	out = captureTerminalOutput(t, tester, []string{
 		"hishtory SPACE tquery ENTER",
 		"C-h C-h",
 	})
+	require.Contains(t, out, "Key Bindings (ctrl+h to close)")
+	require.Contains(t, out, "Editing the query:")
+	require.Contains(t, out, "ctrl+z undo the last deletion")
+
+	// Test closing the help page
+	out = captureTerminalOutput(t, tester, []string{
+		"hishtory SPACE tquery ENTER",
+		"C-h C-h C-h",
+	})
 	out = stripTuiCommandPrefix(t, out)
 	testutils.CompareGoldens(t, out, "TestTui-HelpPageClosed")
 
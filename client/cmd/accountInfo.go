@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+)
+
+var accountInfoCmd = &cobra.Command{
+	Use:     "account-info",
+	Short:   "Show what the backend stores about your account",
+	Long:    "Shows a report of the (encrypted) data that the backend stores for your account: the number and size of stored history entries, pending deletion requests, and when each device was last seen.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		if config.IsOffline {
+			lib.CheckFatalError(fmt.Errorf("account info is not available for offline installs of hishtory since nothing is stored in the backend"))
+		}
+		accountInfo, err := lib.GetAccountInfo(ctx)
+		lib.CheckFatalError(err)
+		fmt.Printf("Stored history entries: %d (%s)\n", accountInfo.NumEntries, formatBytes(accountInfo.StorageBytes))
+		fmt.Printf("Pending deletion requests: %d\n", accountInfo.NumPendingDeletionRequests)
+		fmt.Println()
+		tbl := table.New("Device ID", "Name", "Registered", "Last Seen", "Version", "Stored Entries", "Pending Deletions")
+		tbl.WithHeaderFormatter(color.New(color.FgGreen, color.Underline).SprintfFunc())
+		for _, device := range accountInfo.Devices {
+			deviceId := device.DeviceId
+			if deviceId == config.DeviceId {
+				deviceId += " (this device)"
+			}
+			lastSeen := "unknown"
+			if !device.LastSeen.IsZero() {
+				lastSeen = device.LastSeen.Local().Format(config.TimestampFormat)
+			}
+			version := device.Version
+			if version == "" {
+				version = "unknown"
+			}
+			tbl.AddRow(
+				deviceId,
+				accountInfo.DeviceNames[device.DeviceId],
+				device.RegistrationDate.Local().Format(config.TimestampFormat),
+				lastSeen,
+				version,
+				fmt.Sprintf("%d (%s)", device.NumEntries, formatBytes(device.StorageBytes)),
+				device.NumPendingDeletionRequests,
+			)
+		}
+		tbl.Print()
+	},
+}
+
+// Formats a number of bytes for display, e.g. 1536 -> "1.5 KiB"
+func formatBytes(numBytes int64) string {
+	const unit = 1024
+	if numBytes < unit {
+		return fmt.Sprintf("%d B", numBytes)
+	}
+	div, exp := int64(unit), 0
+	for n := numBytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(numBytes)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	rootCmd.AddCommand(accountInfoCmd)
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var channelCmd = &cobra.Command{
+	Use:     "channel",
+	Short:   "Share commands with your team via shared history channels",
+	Long:    "Channels are shared team histories, which work as a knowledge base of commands. Commands are published to a channel explicitly (via `hishtory channel publish` or via the TUI) or automatically when they match one of the channel's publish rules. Entries in a channel are end-to-end encrypted with the channel's secret, which is shared with your teammates so that they can join the channel. Search a channel via the channel: atom, e.g. `channel:infra deploy`.",
+	GroupID: GROUP_ID_MANAGEMENT,
+}
+
+var channelCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a new channel and subscribe to it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		secret, err := lib.CreateChannel(hctx.MakeContext(), args[0])
+		lib.CheckFatalError(err)
+		fmt.Printf("Created the channel %s. Your teammates can join it by running:\n\n\thishtory channel join %s %s\n\nAnyone with this command can read and publish to the channel, so only share it with your team.\n", args[0], args[0], secret)
+	},
+}
+
+var channelJoinCmd = &cobra.Command{
+	Use:   "join <name> <secret>",
+	Short: "Subscribe to an existing channel, using the secret printed when it was created",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		numRetrieved, err := lib.JoinChannel(hctx.MakeContext(), args[0], args[1])
+		lib.CheckFatalError(err)
+		fmt.Printf("Joined the channel %s, which has %d entries (search it via `hishtory query channel:%s`)\n", args[0], numRetrieved, args[0])
+	},
+}
+
+var channelLeaveCmd = &cobra.Command{
+	Use:   "leave <name>",
+	Short: "Unsubscribe from a channel and delete the local copy of its entries",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(lib.LeaveChannel(hctx.MakeContext(), args[0]))
+	},
+}
+
+var channelListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the channels that you're subscribed to",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		channels := lib.ListChannels(ctx)
+		if len(channels) == 0 {
+			fmt.Println("You aren't subscribed to any channels, run `hishtory channel create` or `hishtory channel join` to subscribe to one")
+			return
+		}
+		config := hctx.GetConf(ctx)
+		for _, name := range channels {
+			fmt.Printf("%s\tpublish rules: %s\n", name, strings.Join(config.Channels[name].PublishRules, ", "))
+		}
+	},
+}
+
+var channelPublishCmd = &cobra.Command{
+	Use:   "publish <name> [query]",
+	Short: "Publish the most recent command matching the query (or the last command) to a channel",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		entry, err := findEntryToPublish(ctx, strings.Join(args[1:], " "))
+		lib.CheckFatalError(err)
+		lib.CheckFatalError(lib.PublishToChannel(ctx, args[0], entry))
+		fmt.Printf("Published %#v to the channel %s\n", entry.Command, args[0])
+	},
+}
+
+var channelAddRuleCmd = &cobra.Command{
+	Use:   "add-rule <name> <regex>",
+	Short: "Automatically publish commands matching the given regex to a channel when they're run",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(lib.AddChannelPublishRule(hctx.MakeContext(), args[0], args[1]))
+	},
+}
+
+var channelSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Retrieve the latest entries published to your channels",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		numPulled, err := lib.PullChannels(hctx.MakeContext())
+		lib.CheckFatalError(err)
+		fmt.Printf("Retrieved %d new entries\n", numPulled)
+	},
+}
+
+// Returns the most recent entry matching the query, skipping `hishtory channel` itself so that an empty query
+// publishes the command run before it
+func findEntryToPublish(ctx context.Context, query string) (*data.HistoryEntry, error) {
+	entries, err := lib.Search(ctx, hctx.GetDb(ctx), query, 10)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(strings.TrimSpace(entry.Command), "hishtory channel ") {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("no command found matching the query %#v", query)
+}
+
+func init() {
+	rootCmd.AddCommand(channelCmd)
+	channelCmd.AddCommand(channelCreateCmd)
+	channelCmd.AddCommand(channelJoinCmd)
+	channelCmd.AddCommand(channelLeaveCmd)
+	channelCmd.AddCommand(channelListCmd)
+	channelCmd.AddCommand(channelPublishCmd)
+	channelCmd.AddCommand(channelAddRuleCmd)
+	channelCmd.AddCommand(channelSyncCmd)
+}
@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/ddworken/hishtory/client/data"
 	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
 	"github.com/spf13/cobra"
@@ -43,6 +48,32 @@ var addCustomColumnsCmd = &cobra.Command{
 	},
 }
 
+var addServerEnvironmentCmd = &cobra.Command{
+	Use:     "server-environments",
+	Aliases: []string{"server-environment"},
+	Short:   "Add a named server environment, optionally with its own user secret",
+	Long:    "Add a named server environment (e.g. a self-hosted staging server) that can then be selected via `--server NAME` or `export HISHTORY_SERVER_ENV=NAME`. If a user secret is specified, it is used instead of your main user secret when this environment is selected.",
+	Args:    cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		hostname := strings.TrimSuffix(args[1], "/")
+		userSecret := ""
+		if len(args) == 3 {
+			userSecret = args[2]
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		if config.ServerEnvironments == nil {
+			config.ServerEnvironments = make(map[string]hctx.ServerEnvironment)
+		}
+		if _, ok := config.ServerEnvironments[name]; ok {
+			lib.CheckFatalError(fmt.Errorf("cannot create a server environment named %#v since there is already one with that name", name))
+		}
+		config.ServerEnvironments[name] = hctx.ServerEnvironment{Hostname: hostname, UserSecret: userSecret}
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 var addDisplayedColumnsCmd = &cobra.Command{
 	Use:     "displayed-columns",
 	Aliases: []string{"displayed-column"},
@@ -57,8 +88,135 @@ var addDisplayedColumnsCmd = &cobra.Command{
 	},
 }
 
+var dropRedactPattern *bool
+
+var addRedactPatternCmd = &cobra.Command{
+	Use:     "redact-patterns",
+	Aliases: []string{"redact-pattern"},
+	Short:   "Add a regex for secrets that should be redacted from commands before they are recorded",
+	Long:    "Add a regex for secrets that should be redacted from commands before they are stored or synced. If the regex contains capture groups, only the captured text is redacted (e.g. `-p\\s*(\\S+)` redacts only the password after -p). With --drop, matching commands aren't recorded at all.",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pattern := args[0]
+		_, err := regexp.Compile(pattern)
+		lib.CheckFatalError(err)
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, rule := range config.RedactionRules {
+			if rule.Pattern == pattern {
+				lib.CheckFatalError(fmt.Errorf("the redaction pattern %#v already exists", pattern))
+			}
+		}
+		config.RedactionRules = append(config.RedactionRules, hctx.RedactionRule{Pattern: pattern, Drop: *dropRedactPattern})
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var guardMessage *string
+
+var addGuardRuleCmd = &cobra.Command{
+	Use:     "guard",
+	Aliases: []string{"guards"},
+	Short:   "Add a regex for dangerous commands that require confirmation before they are run (e.g. '^rm -rf /')",
+	Long:    "Add a regex for dangerous commands (e.g. 'git push (-f|--force).* main') that you'll be asked to confirm before bash, zsh, fish, or PowerShell runs them. Guard rules take effect in new shell sessions.",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pattern := args[0]
+		_, err := regexp.Compile(pattern)
+		lib.CheckFatalError(err)
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, rule := range config.GuardRules {
+			if rule.Pattern == pattern {
+				lib.CheckFatalError(fmt.Errorf("the guard rule %#v already exists", pattern))
+			}
+		}
+		config.GuardRules = append(config.GuardRules, hctx.GuardRule{Pattern: pattern, Message: *guardMessage})
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var addExcludedCwdCmd = &cobra.Command{
+	Use:     "exclude-cwd",
+	Aliases: []string{"exclude-cwds"},
+	Short:   "Add a directory glob that commands won't be recorded in (e.g. ~/secret-project or ~/clients/*)",
+	Long:    "Add a directory glob that commands won't be recorded in. Commands run in subdirectories of a matching directory are also not recorded. Globs starting with ~/ are relative to your home directory.",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		glob := args[0]
+		_, err := filepath.Match(glob, "")
+		lib.CheckFatalError(err)
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		if slices.Contains(config.ExcludedCwdGlobs, glob) {
+			lib.CheckFatalError(fmt.Errorf("the excluded directory %#v already exists", glob))
+		}
+		config.ExcludedCwdGlobs = append(config.ExcludedCwdGlobs, glob)
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var addExcludedCommandCmd = &cobra.Command{
+	Use:     "exclude-command",
+	Aliases: []string{"exclude-commands"},
+	Short:   "Add a regex for commands that won't be recorded (e.g. '^vault login')",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pattern := args[0]
+		_, err := regexp.Compile(pattern)
+		lib.CheckFatalError(err)
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		if slices.Contains(config.ExcludedCommandPatterns, pattern) {
+			lib.CheckFatalError(fmt.Errorf("the excluded command pattern %#v already exists", pattern))
+		}
+		config.ExcludedCommandPatterns = append(config.ExcludedCommandPatterns, pattern)
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var skipHashedFieldConfirmation *bool
+
+var addHashedFieldCmd = &cobra.Command{
+	Use:       "hashed-fields",
+	Aliases:   []string{"hashed-field"},
+	Short:     "Store a field of newly uploaded history entries hashed server-side, in addition to encrypted",
+	Long:      "By default, every field of your history entries is only stored encrypted, so the hishtory backend learns nothing about them. Adding a field here additionally stores a keyed hash of it alongside newly uploaded entries, which enables opt-in server-side features (e.g. server-assisted dedupe). The backend can't recover the value of a hashed field, but it can tell whether two of your entries have the same value for it (e.g. that you ran the same command twice).",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: data.HashableFields,
+	Run: func(cmd *cobra.Command, args []string) {
+		field := args[0]
+		if !slices.Contains(data.HashableFields, field) {
+			lib.CheckFatalError(fmt.Errorf("cannot hash unknown field %#v, must be one of %v", field, data.HashableFields))
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		if slices.Contains(config.HashedFields, field) {
+			lib.CheckFatalError(fmt.Errorf("the field %#v is already hashed", field))
+		}
+		if !*skipHashedFieldConfirmation {
+			fmt.Printf("Hashing the %s field lets the hishtory backend tell when two of your history entries have the same %s, though it still can't see the %s itself. Would you like to continue? [y/N] ", field, field, field)
+			if !readConfirmation(bufio.NewReader(os.Stdin)) {
+				fmt.Println("Leaving the field encrypted only")
+				return
+			}
+		}
+		config.HashedFields = append(config.HashedFields, field)
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(configAddCmd)
 	configAddCmd.AddCommand(addCustomColumnsCmd)
 	configAddCmd.AddCommand(addDisplayedColumnsCmd)
+	configAddCmd.AddCommand(addServerEnvironmentCmd)
+	configAddCmd.AddCommand(addRedactPatternCmd)
+	configAddCmd.AddCommand(addGuardRuleCmd)
+	configAddCmd.AddCommand(addExcludedCwdCmd)
+	configAddCmd.AddCommand(addExcludedCommandCmd)
+	configAddCmd.AddCommand(addHashedFieldCmd)
+	skipHashedFieldConfirmation = addHashedFieldCmd.Flags().Bool("yes", false, "Skip confirming the privacy tradeoff of hashing the field")
+	dropRedactPattern = addRedactPatternCmd.Flags().Bool("drop", false, "Don't record matching commands at all, rather than redacting the matching text")
+	guardMessage = addGuardRuleCmd.Flags().String("message", "", "An explanation to display when asking for confirmation, e.g. 'this deletes the prod database'")
 }
@@ -3,6 +3,7 @@ package cmd
 import (
 	"log"
 	"os"
+	"slices"
 
 	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
@@ -83,8 +84,149 @@ var deleteDisplayedColumnCommand = &cobra.Command{
 	},
 }
 
+var deleteServerEnvironmentCmd = &cobra.Command{
+	Use:     "server-environments",
+	Aliases: []string{"server-environment"},
+	Short:   "Delete a named server environment",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if name == hctx.GetServerEnvironmentName() {
+			log.Fatalf("Cannot delete the server environment %#v while it is selected", name)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		if _, ok := config.ServerEnvironments[name]; !ok {
+			log.Fatalf("Did not find a server environment with name %#v to delete", name)
+		}
+		delete(config.ServerEnvironments, name)
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var deleteRedactPatternCmd = &cobra.Command{
+	Use:     "redact-patterns",
+	Aliases: []string{"redact-pattern"},
+	Short:   "Delete a redaction pattern",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		pattern := args[0]
+		newRules := make([]hctx.RedactionRule, 0)
+		for _, rule := range config.RedactionRules {
+			if rule.Pattern != pattern {
+				newRules = append(newRules, rule)
+			}
+		}
+		if len(newRules) == len(config.RedactionRules) {
+			log.Fatalf("Did not find a redaction pattern %#v to delete", pattern)
+		}
+		config.RedactionRules = newRules
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var deleteGuardRuleCmd = &cobra.Command{
+	Use:     "guard",
+	Aliases: []string{"guards"},
+	Short:   "Delete a guard rule",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		pattern := args[0]
+		newRules := make([]hctx.GuardRule, 0)
+		for _, rule := range config.GuardRules {
+			if rule.Pattern != pattern {
+				newRules = append(newRules, rule)
+			}
+		}
+		if len(newRules) == len(config.GuardRules) {
+			log.Fatalf("Did not find a guard rule %#v to delete", pattern)
+		}
+		config.GuardRules = newRules
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var deletePinnedEntryCmd = &cobra.Command{
+	Use:     "pinned-entries",
+	Aliases: []string{"pinned-entry"},
+	Short:   "Unpin the command pinned under the given trigger",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		trigger := args[0]
+		if _, ok := config.PinnedEntries[trigger]; !ok {
+			log.Fatalf("Did not find a pinned command with the trigger %#v to delete", trigger)
+		}
+		delete(config.PinnedEntries, trigger)
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var deleteExcludedCwdCmd = &cobra.Command{
+	Use:     "exclude-cwd",
+	Aliases: []string{"exclude-cwds"},
+	Short:   "Delete an excluded directory glob",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		glob := args[0]
+		if !slices.Contains(config.ExcludedCwdGlobs, glob) {
+			log.Fatalf("Did not find an excluded directory %#v to delete", glob)
+		}
+		config.ExcludedCwdGlobs = slices.DeleteFunc(config.ExcludedCwdGlobs, func(g string) bool { return g == glob })
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var deleteExcludedCommandCmd = &cobra.Command{
+	Use:     "exclude-command",
+	Aliases: []string{"exclude-commands"},
+	Short:   "Delete an excluded command pattern",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		pattern := args[0]
+		if !slices.Contains(config.ExcludedCommandPatterns, pattern) {
+			log.Fatalf("Did not find an excluded command pattern %#v to delete", pattern)
+		}
+		config.ExcludedCommandPatterns = slices.DeleteFunc(config.ExcludedCommandPatterns, func(p string) bool { return p == pattern })
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var deleteHashedFieldCmd = &cobra.Command{
+	Use:     "hashed-fields",
+	Aliases: []string{"hashed-field"},
+	Short:   "Stop storing a field hashed server-side for newly uploaded history entries",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		field := args[0]
+		if !slices.Contains(config.HashedFields, field) {
+			log.Fatalf("Did not find a hashed field %#v to delete", field)
+		}
+		config.HashedFields = slices.DeleteFunc(config.HashedFields, func(f string) bool { return f == field })
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(configDeleteCmd)
 	configDeleteCmd.AddCommand(deleteCustomColumnsCmd)
 	configDeleteCmd.AddCommand(deleteDisplayedColumnCommand)
+	configDeleteCmd.AddCommand(deleteServerEnvironmentCmd)
+	configDeleteCmd.AddCommand(deleteRedactPatternCmd)
+	configDeleteCmd.AddCommand(deleteGuardRuleCmd)
+	configDeleteCmd.AddCommand(deleteExcludedCwdCmd)
+	configDeleteCmd.AddCommand(deleteExcludedCommandCmd)
+	configDeleteCmd.AddCommand(deleteHashedFieldCmd)
+	configDeleteCmd.AddCommand(deletePinnedEntryCmd)
 }
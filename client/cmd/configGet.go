@@ -3,10 +3,14 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/client/tui"
+	"github.com/ddworken/hishtory/shared"
+	"github.com/ddworken/hishtory/shared/ai"
 	"github.com/spf13/cobra"
 )
 
@@ -41,13 +45,29 @@ var getHighlightMatchesCmd = &cobra.Command{
 		fmt.Println(config.HighlightMatches)
 	},
 }
+
+var getMultiLineCommandsCmd = &cobra.Command{
+	Use:   "multi-line-commands",
+	Short: "Whether hishtory displays multi-line commands across multiple lines in the TUI",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.MultiLineCommands)
+	},
+}
+
 var getDefaultFilterCmd = &cobra.Command{
 	Use:   "default-filter",
-	Short: "The default filter that is applied to all search queries",
+	Short: "The default filters that can be cycled between in the TUI, the first of which is applied to all search queries",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
 		config := hctx.GetConf(ctx)
-		fmt.Printf("%#v", config.DefaultFilter)
+		if len(config.DefaultFilters) == 0 {
+			fmt.Printf("%#v\n", "")
+		}
+		for _, filter := range config.DefaultFilters {
+			fmt.Printf("%#v\n", filter)
+		}
 	},
 }
 
@@ -61,6 +81,241 @@ var getFilterDuplicateCommandsCmd = &cobra.Command{
 	},
 }
 
+var getNormalizeCommandsCmd = &cobra.Command{
+	Use:   "normalize-commands",
+	Short: "Whether hishtory normalizes commands (e.g. collapsing whitespace) when filtering out duplicate commands",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.NormalizeCommands)
+	},
+}
+
+var getRecordGitInfoCmd = &cobra.Command{
+	Use:   "record-git-info",
+	Short: "Whether hishtory records the git repository and branch that each command was run in",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.RecordGitInfo)
+	},
+}
+
+var getRecordTmuxInfoCmd = &cobra.Command{
+	Use:   "record-tmux-info",
+	Short: "Whether hishtory records the tmux session, window, and pane that each command was run in",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.RecordTmuxInfo)
+	},
+}
+
+var getRecordSshHostCmd = &cobra.Command{
+	Use:   "record-ssh-host",
+	Short: "Whether hishtory records the remote host that ssh commands connected to",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.RecordSshHost)
+	},
+}
+
+var getLargeHistoryModeCmd = &cobra.Command{
+	Use:   "large-history-mode",
+	Short: "Whether the TUI should load results on demand as you scroll, for very large histories",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.LargeHistoryMode)
+	},
+}
+
+var getEncryptLocalDbCmd = &cobra.Command{
+	Use:   "encrypt-local-db",
+	Short: "Whether the local DB is encrypted at rest with a key derived from your secret",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.EncryptLocalDb)
+	},
+}
+
+var getSecretStorageCmd = &cobra.Command{
+	Use:   "secret-storage",
+	Short: "Where your secret key is stored",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		switch config.SecretStorage {
+		case "":
+			fmt.Println(hctx.SecretStorageConfig)
+		case hctx.SecretStorageCommand:
+			fmt.Printf("%s %s\n", config.SecretStorage, config.SecretCommand)
+		default:
+			fmt.Println(config.SecretStorage)
+		}
+	},
+}
+
+var getShowWhatsNewCmd = &cobra.Command{
+	Use:   "show-whats-new",
+	Short: "Whether the TUI should show what's new the first time it is opened after hishtory is updated",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.ShowWhatsNew)
+	},
+}
+
+var getResultSamplingCmd = &cobra.Command{
+	Use:   "result-sampling",
+	Short: "Whether the TUI should display a sample of the results for queries that match a huge number of entries",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.ResultSampling)
+	},
+}
+
+var getPinExpansionCmd = &cobra.Command{
+	Use:   "pin-expansion",
+	Short: "Whether typing the trigger of a pinned command at the zsh prompt expands it to the pinned command",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.PinExpansion)
+	},
+}
+
+var getPinnedEntriesCmd = &cobra.Command{
+	Use:     "pinned-entries",
+	Aliases: []string{"pinned-entry"},
+	Short:   "The commands pinned via `hishtory pin`, along with their triggers",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		triggers := make([]string, 0, len(config.PinnedEntries))
+		for trigger := range config.PinnedEntries {
+			triggers = append(triggers, trigger)
+		}
+		sort.Strings(triggers)
+		for _, trigger := range triggers {
+			fmt.Println(trigger + "\t" + config.PinnedEntries[trigger])
+		}
+	},
+}
+
+var getTrashRetentionDaysCmd = &cobra.Command{
+	Use:   "trash-retention-days",
+	Short: "The number of days that entries deleted in the TUI are kept in the trash before being permanently deleted",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.TrashRetentionDays)
+	},
+}
+
+var getRankBySuccessInCwdCmd = &cobra.Command{
+	Use:   "rank-by-success-in-cwd",
+	Short: "Whether search results should favor commands that previously succeeded in the current directory",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.RankBySuccessInCwd)
+	},
+}
+
+var getRankFavoritesFirstCmd = &cobra.Command{
+	Use:   "rank-favorites-first",
+	Short: "Whether search results should always list favorite commands first",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.RankFavoritesFirst)
+	},
+}
+
+var getRankerCmd = &cobra.Command{
+	Use:   "ranker",
+	Short: "The strategy used to rank search results in the default sort order",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		fmt.Println(lib.GetConfiguredRanker(ctx).Name())
+	},
+}
+
+var getSessionSummaryCmd = &cobra.Command{
+	Use:   "session-summary",
+	Short: "Whether to print a summary of the commands run in a shell session when it ends",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.ShowSessionSummary)
+	},
+}
+
+var getNotifyAfterCmd = &cobra.Command{
+	Use:   "notify-after",
+	Short: "The number of seconds that a command must run for to send a notification when it finishes (0 if disabled)",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.NotifyThresholdSeconds)
+	},
+}
+
+var getNotifyMethodCmd = &cobra.Command{
+	Use:   "notify-method",
+	Short: "How to notify about long-running commands finishing",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		if config.NotifyMethod == "" {
+			fmt.Println(lib.NotifyMethodDesktop)
+		} else {
+			fmt.Println(config.NotifyMethod)
+		}
+	},
+}
+
+var getNotifyWebhookUrlCmd = &cobra.Command{
+	Use:   "notify-webhook-url",
+	Short: "The URL that long-running commands are POSTed to when notify-method is webhook",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.NotifyWebhookUrl)
+	},
+}
+
+var getSyncModeCmd = &cobra.Command{
+	Use:   "sync-mode",
+	Short: "Whether this device uploads its history entries and/or downloads the history entries of your other devices",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		if config.SyncMode == "" {
+			fmt.Println(shared.SyncModeReadWrite)
+		} else {
+			fmt.Println(config.SyncMode)
+		}
+	},
+}
+
+var getDimmingThresholdsCmd = &cobra.Command{
+	Use:   "dimming-thresholds",
+	Short: "The number of days after which entries are displayed in progressively dimmer colors in the TUI",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, days := range config.DimmingThresholdDays {
+			fmt.Printf("%d ", days)
+		}
+		fmt.Print("\n")
+	},
+}
+
 var getEnableAiCompletion = &cobra.Command{
 	Use:   "ai-completion",
 	Short: "Enable AI completion for searches starting with '?'",
@@ -121,6 +376,16 @@ var getTimestampFormatCmd = &cobra.Command{
 	},
 }
 
+var getIgnoredCommandPrefixCmd = &cobra.Command{
+	Use:   "ignored-command-prefix",
+	Short: "Commands starting with this prefix won't be recorded",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.IgnoredCommandPrefix)
+	},
+}
+
 var getCustomColumnsCmd = &cobra.Command{
 	Use:     "custom-columns",
 	Aliases: []string{"custom-column"},
@@ -134,6 +399,97 @@ var getCustomColumnsCmd = &cobra.Command{
 	},
 }
 
+var getExcludedCwdsCmd = &cobra.Command{
+	Use:     "exclude-cwd",
+	Aliases: []string{"exclude-cwds"},
+	Short:   "The list of directory globs that commands aren't recorded in",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, glob := range config.ExcludedCwdGlobs {
+			fmt.Println(glob)
+		}
+	},
+}
+
+var getExcludedCommandsCmd = &cobra.Command{
+	Use:     "exclude-command",
+	Aliases: []string{"exclude-commands"},
+	Short:   "The list of regexes for commands that aren't recorded",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, pattern := range config.ExcludedCommandPatterns {
+			fmt.Println(pattern)
+		}
+	},
+}
+
+var getHashedFieldsCmd = &cobra.Command{
+	Use:     "hashed-fields",
+	Aliases: []string{"hashed-field"},
+	Short:   "The list of fields that are stored hashed server-side in addition to encrypted",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, field := range config.HashedFields {
+			fmt.Println(field)
+		}
+	},
+}
+
+var getRedactPatternsCmd = &cobra.Command{
+	Use:     "redact-patterns",
+	Aliases: []string{"redact-pattern"},
+	Short:   "The list of regexes for secrets that are redacted from commands before they are recorded",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, rule := range config.RedactionRules {
+			if rule.Drop {
+				fmt.Println(rule.Pattern + "   (drop)")
+			} else {
+				fmt.Println(rule.Pattern)
+			}
+		}
+	},
+}
+
+var getGuardRulesCmd = &cobra.Command{
+	Use:     "guard",
+	Aliases: []string{"guards"},
+	Short:   "The list of regexes for dangerous commands that require confirmation before they are run",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, rule := range config.GuardRules {
+			if rule.Message != "" {
+				fmt.Println(rule.Pattern + "   (" + rule.Message + ")")
+			} else {
+				fmt.Println(rule.Pattern)
+			}
+		}
+	},
+}
+
+var getServerEnvironmentsCmd = &cobra.Command{
+	Use:     "server-environments",
+	Aliases: []string{"server-environment"},
+	Short:   "The list of named server environments",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		names := make([]string, 0, len(config.ServerEnvironments))
+		for name := range config.ServerEnvironments {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name + ":   " + config.ServerEnvironments[name].Hostname)
+		}
+	},
+}
+
 var getColorScheme = &cobra.Command{
 	Use:   "color-scheme",
 	Short: "Get the currently configured color scheme for selected text in the TUI",
@@ -143,12 +499,32 @@ var getColorScheme = &cobra.Command{
 		fmt.Println("selected-text: " + config.ColorScheme.SelectedText)
 		fmt.Println("selected-background: " + config.ColorScheme.SelectedBackground)
 		fmt.Println("border-color: " + config.ColorScheme.BorderColor)
+		fmt.Println("header-text: " + config.ColorScheme.HeaderText)
+		fmt.Println("match-highlight: " + config.ColorScheme.MatchHighlight)
+		fmt.Println("warning-text: " + config.ColorScheme.WarningText)
+		fmt.Println("error-text: " + config.ColorScheme.ErrorText)
+		fmt.Println("help-key: " + config.ColorScheme.HelpKey)
+		fmt.Println("help-description: " + config.ColorScheme.HelpDescription)
+		fmt.Println("match-highlight-style: " + config.ColorScheme.MatchHighlightStyle)
+	},
+}
+
+var getColorSchemePresets = &cobra.Command{
+	Use:   "color-scheme-presets",
+	Short: "Preview the built-in color schemes, which can be selected via `hishtory config-set color-scheme preset`",
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, name := range hctx.ColorSchemePresetNames() {
+			fmt.Println(name + ":")
+			fmt.Println(tui.RenderColorSchemePreview(hctx.ColorSchemePresets[name]))
+			fmt.Println()
+		}
 	},
 }
 
 var getAiCompletionEndpoint = &cobra.Command{
-	Use:   "ai-completion-endpoint",
-	Short: "The AI endpoint to use for AI completions",
+	Use:     "ai-completion-endpoint",
+	Aliases: []string{"ai-endpoint"},
+	Short:   "The AI endpoint to use for AI completions",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
 		config := hctx.GetConf(ctx)
@@ -156,18 +532,100 @@ var getAiCompletionEndpoint = &cobra.Command{
 	},
 }
 
+var getAiProviderCmd = &cobra.Command{
+	Use:   "ai-provider",
+	Short: "The provider to use for AI completions",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		if config.AiProvider == "" {
+			fmt.Println(ai.ProviderOpenAi)
+		} else {
+			fmt.Println(config.AiProvider)
+		}
+	},
+}
+
+var getAiModelCmd = &cobra.Command{
+	Use:   "ai-model",
+	Short: "The model to use for AI completions (empty if the provider's default model is used)",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.AiModel)
+	},
+}
+
+var getAiIncludeContextCmd = &cobra.Command{
+	Use:   "ai-include-context",
+	Short: "Whether AI queries include context about your environment for more accurate suggestions",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.AiIncludeContext)
+	},
+}
+
+var getAiFeedbackCmd = &cobra.Command{
+	Use:   "ai-feedback",
+	Short: "Whether to record which AI suggestions you accept or reject, to improve how suggestions are ranked",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		if config.AiFeedback == "" {
+			fmt.Println(lib.AI_FEEDBACK_OFF)
+		} else {
+			fmt.Println(config.AiFeedback)
+		}
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(configGetCmd)
 	configGetCmd.AddCommand(getEnableControlRCmd)
 	configGetCmd.AddCommand(getFilterDuplicateCommandsCmd)
+	configGetCmd.AddCommand(getNormalizeCommandsCmd)
 	configGetCmd.AddCommand(getDisplayedColumnsCmd)
 	configGetCmd.AddCommand(getTimestampFormatCmd)
+	configGetCmd.AddCommand(getIgnoredCommandPrefixCmd)
 	configGetCmd.AddCommand(getCustomColumnsCmd)
+	configGetCmd.AddCommand(getRedactPatternsCmd)
+	configGetCmd.AddCommand(getGuardRulesCmd)
+	configGetCmd.AddCommand(getExcludedCwdsCmd)
+	configGetCmd.AddCommand(getExcludedCommandsCmd)
+	configGetCmd.AddCommand(getHashedFieldsCmd)
 	configGetCmd.AddCommand(getBetaModeCmd)
 	configGetCmd.AddCommand(getHighlightMatchesCmd)
+	configGetCmd.AddCommand(getMultiLineCommandsCmd)
 	configGetCmd.AddCommand(getEnableAiCompletion)
 	configGetCmd.AddCommand(getPresavingCmd)
 	configGetCmd.AddCommand(getColorScheme)
+	configGetCmd.AddCommand(getColorSchemePresets)
 	configGetCmd.AddCommand(getDefaultFilterCmd)
 	configGetCmd.AddCommand(getAiCompletionEndpoint)
+	configGetCmd.AddCommand(getAiProviderCmd)
+	configGetCmd.AddCommand(getAiModelCmd)
+	configGetCmd.AddCommand(getAiIncludeContextCmd)
+	configGetCmd.AddCommand(getAiFeedbackCmd)
+	configGetCmd.AddCommand(getRecordGitInfoCmd)
+	configGetCmd.AddCommand(getRecordTmuxInfoCmd)
+	configGetCmd.AddCommand(getRecordSshHostCmd)
+	configGetCmd.AddCommand(getLargeHistoryModeCmd)
+	configGetCmd.AddCommand(getResultSamplingCmd)
+	configGetCmd.AddCommand(getShowWhatsNewCmd)
+	configGetCmd.AddCommand(getSecretStorageCmd)
+	configGetCmd.AddCommand(getEncryptLocalDbCmd)
+	configGetCmd.AddCommand(getTrashRetentionDaysCmd)
+	configGetCmd.AddCommand(getPinExpansionCmd)
+	configGetCmd.AddCommand(getPinnedEntriesCmd)
+	configGetCmd.AddCommand(getRankBySuccessInCwdCmd)
+	configGetCmd.AddCommand(getRankerCmd)
+	configGetCmd.AddCommand(getRankFavoritesFirstCmd)
+	configGetCmd.AddCommand(getSessionSummaryCmd)
+	configGetCmd.AddCommand(getNotifyAfterCmd)
+	configGetCmd.AddCommand(getNotifyMethodCmd)
+	configGetCmd.AddCommand(getNotifyWebhookUrlCmd)
+	configGetCmd.AddCommand(getSyncModeCmd)
+	configGetCmd.AddCommand(getServerEnvironmentsCmd)
+	configGetCmd.AddCommand(getDimmingThresholdsCmd)
 }
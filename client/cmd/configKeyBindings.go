@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/ddworken/hishtory/client/hctx"
@@ -25,6 +26,8 @@ var getKeyBindingsCmd = &cobra.Command{
 		fmt.Println("right: \t\t\t" + strings.Join(config.KeyBindings.Right, " "))
 		fmt.Println("table-left: \t\t" + strings.Join(config.KeyBindings.TableLeft, " "))
 		fmt.Println("table-right: \t\t" + strings.Join(config.KeyBindings.TableRight, " "))
+		fmt.Println("scroll-selected-left: \t" + strings.Join(config.KeyBindings.ScrollSelectedLeft, " "))
+		fmt.Println("scroll-selected-right: \t" + strings.Join(config.KeyBindings.ScrollSelectedRight, " "))
 		fmt.Println("delete-entry: \t\t" + strings.Join(config.KeyBindings.DeleteEntry, " "))
 		fmt.Println("help: \t\t\t" + strings.Join(config.KeyBindings.Help, " "))
 		fmt.Println("quit: \t\t\t" + strings.Join(config.KeyBindings.Quit, " "))
@@ -32,53 +35,45 @@ var getKeyBindingsCmd = &cobra.Command{
 		fmt.Println("jump-end-of-input: \t" + strings.Join(config.KeyBindings.JumpEndOfInput, " "))
 		fmt.Println("word-left: \t\t" + strings.Join(config.KeyBindings.WordLeft, " "))
 		fmt.Println("word-right: \t\t" + strings.Join(config.KeyBindings.WordRight, " "))
+		fmt.Println("toggle-current-session: \t" + strings.Join(config.KeyBindings.ToggleCurrentSession, " "))
+		fmt.Println("cycle-sort-order: \t" + strings.Join(config.KeyBindings.CycleSortOrder, " "))
+		fmt.Println("cycle-search-scope: \t" + strings.Join(config.KeyBindings.CycleSearchScope, " "))
+		fmt.Println("cycle-ranker: \t\t" + strings.Join(config.KeyBindings.CycleRanker, " "))
+		fmt.Println("clear-query: \t\t" + strings.Join(config.KeyBindings.ClearQuery, " "))
+		fmt.Println("command-palette: \t" + strings.Join(config.KeyBindings.OpenCommandPalette, " "))
+		fmt.Println("ai-chat: \t\t" + strings.Join(config.KeyBindings.OpenAiChat, " "))
+		fmt.Println("result-sampling: \t" + strings.Join(config.KeyBindings.ToggleSampling, " "))
+		fmt.Println("undo-delete: \t\t" + strings.Join(config.KeyBindings.UndoDelete, " "))
+		fmt.Println("previous-query: \t" + strings.Join(config.KeyBindings.PreviousQuery, " "))
+		fmt.Println("next-query: \t\t" + strings.Join(config.KeyBindings.NextQuery, " "))
+		fmt.Println("cycle-default-filter: \t" + strings.Join(config.KeyBindings.CycleDefaultFilter, " "))
+		fmt.Println("toggle-favorite: \t" + strings.Join(config.KeyBindings.ToggleFavorite, " "))
+		fmt.Println("edit-tags: \t\t" + strings.Join(config.KeyBindings.EditTags, " "))
+		fmt.Println("save-snippet: \t\t" + strings.Join(config.KeyBindings.SaveSnippet, " "))
+		fmt.Println("browse-snippets: \t" + strings.Join(config.KeyBindings.BrowseSnippets, " "))
+		fmt.Println("explain-command: \t" + strings.Join(config.KeyBindings.ExplainCommand, " "))
+		fmt.Println("toggle-mark: \t\t" + strings.Join(config.KeyBindings.ToggleMark, " "))
+		fmt.Println("publish-to-channel: \t" + strings.Join(config.KeyBindings.PublishToChannel, " "))
 	},
 }
 
 var setKeyBindingsCmd = &cobra.Command{
 	Use:   "key-bindings",
 	Short: "Set custom key bindings for the TUI",
+	Long:  "Sets the keys for the given action, e.g. `hishtory config-set key-bindings delete-entry ctrl+k`. Multiple keys can be passed to bind each of them. A key binding containing spaces (e.g. `'ctrl+x ctrl+k'`) is a chord that is triggered by pressing each key in order.",
 	Args:  cobra.MinimumNArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
 		config := hctx.GetConf(ctx)
-		switch args[0] {
-		case "up":
-			config.KeyBindings.Up = args[1:]
-		case "down":
-			config.KeyBindings.Down = args[1:]
-		case "page-up":
-			config.KeyBindings.PageUp = args[1:]
-		case "page-down":
-			config.KeyBindings.PageDown = args[1:]
-		case "select-entry":
-			config.KeyBindings.SelectEntry = args[1:]
-		case "select-entry-and-cd":
-			config.KeyBindings.SelectEntryAndChangeDir = args[1:]
-		case "left":
-			config.KeyBindings.Left = args[1:]
-		case "right":
-			config.KeyBindings.Right = args[1:]
-		case "table-left":
-			config.KeyBindings.TableLeft = args[1:]
-		case "table-right":
-			config.KeyBindings.TableRight = args[1:]
-		case "delete-entry":
-			config.KeyBindings.DeleteEntry = args[1:]
-		case "help":
-			config.KeyBindings.Help = args[1:]
-		case "quit":
-			config.KeyBindings.Quit = args[1:]
-		case "jump-start-of-input":
-			config.KeyBindings.JumpStartOfInput = args[1:]
-		case "jump-end-of-input":
-			config.KeyBindings.JumpEndOfInput = args[1:]
-		case "word-left":
-			config.KeyBindings.WordLeft = args[1:]
-		case "word-right":
-			config.KeyBindings.WordRight = args[1:]
-		default:
-			lib.CheckFatalError(fmt.Errorf("unknown action %q, run `hishtory config-get keybindings` to see the list of currently configured key bindings", args[0]))
+		keys, ok := config.KeyBindings.ActionKeys(args[0])
+		if !ok {
+			lib.CheckFatalError(fmt.Errorf("unknown action %q, run `hishtory config-get key-bindings` to see the list of currently configured key bindings", args[0]))
+		}
+		*keys = args[1:]
+		warnings, err := config.KeyBindings.Validate()
+		lib.CheckFatalError(err)
+		for _, warning := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
 		}
 		lib.CheckFatalError(hctx.SetConfig(config))
 	},
@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/shared"
+	"github.com/ddworken/hishtory/shared/ai"
 	"github.com/spf13/cobra"
 )
 
@@ -56,6 +59,250 @@ var setFilterDuplicateCommandsCmd = &cobra.Command{
 	},
 }
 
+var setNormalizeCommandsCmd = &cobra.Command{
+	Use:       "normalize-commands",
+	Short:     "Whether hishtory normalizes commands (e.g. collapsing whitespace) when filtering out duplicate commands",
+	Long:      "Normalization only affects how commands are compared, the original command is always what is recorded and displayed.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.NormalizeCommands = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setRecordGitInfoCmd = &cobra.Command{
+	Use:       "record-git-info",
+	Short:     "Whether hishtory records the git repository and branch that each command was run in",
+	Long:      "When enabled, the git repository and branch are available as the 'Git Repo' and 'Git Branch' columns and can be searched for via the repo: and branch: atoms.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.RecordGitInfo = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setRecordTmuxInfoCmd = &cobra.Command{
+	Use:       "record-tmux-info",
+	Short:     "Whether hishtory records the tmux session, window, and pane that each command was run in",
+	Long:      "When enabled, the tmux session, window, and pane are available as the 'Tmux Session', 'Tmux Window', and 'Tmux Pane' columns and can be searched for via the tmux_session:, tmux_window:, and tmux_pane: atoms.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.RecordTmuxInfo = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setRecordSshHostCmd = &cobra.Command{
+	Use:       "record-ssh-host",
+	Short:     "Whether hishtory records the remote host that ssh commands connected to",
+	Long:      "When enabled, the host that each ssh command connected to (after resolving aliases from ~/.ssh/config) is available as the 'Remote Host' column and can be searched for via the remote_host: atom.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.RecordSshHost = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setLargeHistoryModeCmd = &cobra.Command{
+	Use:       "large-history-mode",
+	Short:     "Whether the TUI should load results on demand as you scroll, for very large histories",
+	Long:      "When enabled, the TUI only loads the results around the cursor so that you can scroll through your entire history while keeping memory usage flat. Note that duplicate filtering only applies within the loaded results.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.LargeHistoryMode = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setResultSamplingCmd = &cobra.Command{
+	Use:       "result-sampling",
+	Short:     "Whether the TUI should display a sample of the results for queries that match a huge number of entries",
+	Long:      "When enabled, queries that match a huge number of entries (e.g. single letters) display a sample of the matches spread evenly over time, which keeps the TUI responsive. The sampling can be toggled off for a query to page through every match.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ResultSampling = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setEncryptLocalDbCmd = &cobra.Command{
+	Use:       "encrypt-local-db",
+	Short:     "Whether the local DB is encrypted at rest with a key derived from your secret",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		lib.CheckFatalError(hctx.SetLocalDbEncryption(ctx, val == "true"))
+	},
+}
+
+var setSecretStorageCmd = &cobra.Command{
+	Use:       "secret-storage",
+	Short:     "Where your secret key is stored: config (the default), keychain, or command followed by a command that prints it",
+	Args:      cobra.RangeArgs(1, 2),
+	ValidArgs: []string{hctx.SecretStorageConfig, hctx.SecretStorageKeychain, hctx.SecretStorageCommand},
+	Run: func(cmd *cobra.Command, args []string) {
+		storage := args[0]
+		command := ""
+		if len(args) == 2 {
+			command = args[1]
+		}
+		if (storage == hctx.SecretStorageCommand) != (command != "") {
+			log.Fatalf("A command must be specified if and only if the secret storage is %s, e.g. `hishtory config-set secret-storage command 'pass show hishtory'`", hctx.SecretStorageCommand)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		lib.CheckFatalError(hctx.SetSecretStorage(config, storage, command))
+	},
+}
+
+var setShowWhatsNewCmd = &cobra.Command{
+	Use:       "show-whats-new",
+	Short:     "Whether the TUI should show what's new the first time it is opened after hishtory is updated",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ShowWhatsNew = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setRankBySuccessInCwdCmd = &cobra.Command{
+	Use:       "rank-by-success-in-cwd",
+	Short:     "Whether search results should favor commands that previously succeeded in the current directory",
+	Long:      "When enabled, commands that previously exited 0 in the current directory are ranked first and commands that have only ever failed there are ranked last. This only applies to the default sort order.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.RankBySuccessInCwd = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setRankFavoritesFirstCmd = &cobra.Command{
+	Use:       "rank-favorites-first",
+	Short:     "Whether search results should always list favorite commands first",
+	Long:      "When enabled, entries that were marked as favorites in the TUI are ranked before all other entries. This only applies to the default sort order.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.RankFavoritesFirst = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setRankerCmd = &cobra.Command{
+	Use:       "ranker",
+	Short:     "Set the strategy used to rank search results in the default sort order",
+	Long:      "One of: recency (most recent first), frecency (frequently and recently run commands first), cwd-affinity (commands run most often in the current directory first), or success-weighted (commands that previously succeeded in the current directory first). Takes precedence over rank-by-success-in-cwd.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: lib.RankerNames(),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, err := lib.GetRanker(args[0])
+		lib.CheckFatalError(err)
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.Ranker = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setSessionSummaryCmd = &cobra.Command{
+	Use:       "session-summary",
+	Short:     "Whether to print a summary of the commands run in a shell session when it ends",
+	Long:      "When enabled, exiting a shell prints the number of commands run in it, how many of them failed, and the time spent in long-running commands. This is useful for reconstructing what you worked on, e.g. for timesheets or standups.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ShowSessionSummary = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setSyncModeCmd = &cobra.Command{
+	Use:       "sync-mode",
+	Short:     "Whether this device uploads its history entries and/or downloads the history entries of your other devices",
+	Long:      "Set to read-only for devices (e.g. shared servers) where you want to search your history without uploading the commands run there, or to write-only for devices that should upload their history without downloading the history of your other devices.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{string(shared.SyncModeReadWrite), string(shared.SyncModeReadOnly), string(shared.SyncModeWriteOnly)},
+	Run: func(cmd *cobra.Command, args []string) {
+		syncMode, err := shared.ParseSyncMode(args[0])
+		lib.CheckFatalError(err)
+		ctx := hctx.MakeContext()
+		lib.CheckFatalError(lib.SetSyncMode(ctx, syncMode))
+	},
+}
+
 var setBetaModeCommand = &cobra.Command{
 	Use:       "beta-mode",
 	Short:     "Enable beta-mode to opt-in to unreleased features",
@@ -76,15 +323,121 @@ var setBetaModeCommand = &cobra.Command{
 var setDefaultFilterCommand = &cobra.Command{
 	Use:   "default-filter",
 	Short: "Add a default filter that will be applied to all search queries (e.g. `exit_code:0` to filter to only commands that executed successfully)",
-	Args:  cobra.ExactArgs(1),
+	Long:  "If multiple filters are given (e.g. `hishtory config-set default-filter exit_code:0 hostname:$(hostname)`), the first is applied by default and the others can be cycled between in the TUI. No filter is always one of the options, and an empty string can be passed to control where it appears in the cycle.",
+	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
 		config := hctx.GetConf(ctx)
+		config.DefaultFilters = args
+		if len(args) == 1 && args[0] == "" {
+			config.DefaultFilters = []string{}
+		}
+		// Also set the deprecated single default filter so that older versions apply the same default
 		config.DefaultFilter = args[0]
 		lib.CheckFatalError(hctx.SetConfig(config))
 	},
 }
 
+var setDimmingThresholdsCmd = &cobra.Command{
+	Use:   "dimming-thresholds",
+	Short: "Display entries older than each of the given number of days in progressively dimmer colors in the TUI",
+	Long:  "For example, `hishtory config-set dimming-thresholds 7 30 365` will dim entries older than a week, and further dim entries older than a month and a year. Run with no arguments to disable dimming.",
+	Args:  cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		thresholds := make([]int, 0)
+		for _, arg := range args {
+			days, err := strconv.Atoi(arg)
+			if err != nil || days <= 0 {
+				log.Fatalf("Unexpected config value %s, must be a positive number of days", arg)
+			}
+			thresholds = append(thresholds, days)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.DimmingThresholdDays = thresholds
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setPinExpansionCmd = &cobra.Command{
+	Use:       "pin-expansion",
+	Short:     "Whether typing the trigger of a pinned command at the zsh prompt expands it to the pinned command",
+	Long:      "When enabled, typing a trigger configured via `hishtory pin` (e.g. `,deploy`) at the zsh prompt and then pressing space or enter expands it inline to the pinned command. Takes effect in new shells.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.PinExpansion = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setTrashRetentionDaysCmd = &cobra.Command{
+	Use:   "trash-retention-days",
+	Short: "The number of days that entries deleted in the TUI are kept in the trash before being permanently deleted",
+	Long:  "Entries deleted in the TUI can be restored via `hishtory trash restore` until they are permanently deleted. Once that happens, they are also deleted on all of your other devices.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		days, err := strconv.Atoi(args[0])
+		if err != nil || days <= 0 {
+			log.Fatalf("Unexpected config value %s, must be a positive number of days", args[0])
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.TrashRetentionDays = days
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setNotifyAfterCmd = &cobra.Command{
+	Use:   "notify-after",
+	Short: "Send a notification when commands that run for at least this many seconds finish (0 to disable)",
+	Long:  "The notification is sent via notify-method, which defaults to a desktop notification.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil || seconds < 0 {
+			log.Fatalf("Unexpected config value %s, must be a non-negative number of seconds", args[0])
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.NotifyThresholdSeconds = seconds
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setNotifyMethodCmd = &cobra.Command{
+	Use:       "notify-method",
+	Short:     "How to notify about long-running commands finishing",
+	Long:      "One of: desktop (via notify-send on Linux or Notification Center on MacOS, falling back to the terminal bell if neither is available), bell (rings the terminal bell), or webhook (POSTs a JSON description of the command to notify-webhook-url).",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: lib.NotifyMethods(),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.NotifyMethod = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setNotifyWebhookUrlCmd = &cobra.Command{
+	Use:   "notify-webhook-url",
+	Short: "The URL that long-running commands are POSTed to when notify-method is webhook",
+	Long:  "Note that the full command (after applying redact-patterns) is sent to this URL, so only use a webhook that you trust.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.NotifyWebhookUrl = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 var setEnableAiCompletionCmd = &cobra.Command{
 	Use:       "ai-completion",
 	Short:     "Enable AI completion for searches starting with '?'",
@@ -137,6 +490,23 @@ var setHighlightMatchesCmd = &cobra.Command{
 	},
 }
 
+var setMultiLineCommandsCmd = &cobra.Command{
+	Use:       "multi-line-commands",
+	Short:     "Enable multi-line-commands to display multi-line commands (e.g. heredocs) across multiple lines in the TUI, rather than escaped into a single line",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.MultiLineCommands = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 var setDisplayedColumnsCmd = &cobra.Command{
 	Use:     "displayed-columns",
 	Aliases: []string{"displayed-column"},
@@ -162,6 +532,18 @@ var setTimestampFormatCmd = &cobra.Command{
 	},
 }
 
+var setIgnoredCommandPrefixCmd = &cobra.Command{
+	Use:   "ignored-command-prefix",
+	Short: "Commands starting with this prefix won't be recorded (commands starting with a space are never recorded), pass an empty string to unset",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.IgnoredCommandPrefix = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 var setColorSchemeCmd = &cobra.Command{
 	Use:   "color-scheme",
 	Short: "Set a custom color scheme",
@@ -171,6 +553,19 @@ var setColorSchemeCmd = &cobra.Command{
 	},
 }
 
+var setColorSchemePreset = &cobra.Command{
+	Use:       "preset",
+	Short:     "Use one of the built-in color schemes, which can be previewed via `hishtory config-get color-scheme-presets`",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: hctx.ColorSchemePresetNames(),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ColorScheme = hctx.ColorSchemePresets[args[0]]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 var setColorSchemeSelectedText = &cobra.Command{
 	Use:   "selected-text",
 	Short: "Set the color of the selected text to the given hexadecimal color",
@@ -210,6 +605,99 @@ var setColorSchemeBorderColor = &cobra.Command{
 	},
 }
 
+var setColorSchemeHeaderText = &cobra.Command{
+	Use:   "header-text",
+	Short: "Set the color of the table header to the given hexadecimal color",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(validateColor(args[0]))
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ColorScheme.HeaderText = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setColorSchemeMatchHighlight = &cobra.Command{
+	Use:   "match-highlight",
+	Short: "Set the color of the parts of results that match the query to the given hexadecimal color",
+	Long:  "Note that matches are only highlighted if highlight-matches is enabled.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(validateColor(args[0]))
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ColorScheme.MatchHighlight = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setColorSchemeWarningText = &cobra.Command{
+	Use:   "warning-text",
+	Short: "Set the color of warnings to the given hexadecimal color",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(validateColor(args[0]))
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ColorScheme.WarningText = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setColorSchemeErrorText = &cobra.Command{
+	Use:   "error-text",
+	Short: "Set the color of errors to the given hexadecimal color",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(validateColor(args[0]))
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ColorScheme.ErrorText = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setColorSchemeHelpKey = &cobra.Command{
+	Use:   "help-key",
+	Short: "Set the color of the keys in the help bar to the given hexadecimal color",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(validateColor(args[0]))
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ColorScheme.HelpKey = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setColorSchemeHelpDescription = &cobra.Command{
+	Use:   "help-description",
+	Short: "Set the color of the key descriptions in the help bar to the given hexadecimal color",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(validateColor(args[0]))
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ColorScheme.HelpDescription = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setColorSchemeMatchHighlightStyle = &cobra.Command{
+	Use:       "match-highlight-style",
+	Short:     "Set the text style of the parts of results that match the query",
+	Long:      "Note that matches are only highlighted if highlight-matches is enabled.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: hctx.MATCH_HIGHLIGHT_STYLES,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.ColorScheme.MatchHighlightStyle = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 func validateColor(color string) error {
 	if !strings.HasPrefix(color, "#") || len(color) != 7 {
 		return fmt.Errorf("color %q is invalid, it should be a hexadecimal color like #663399", color)
@@ -218,9 +706,11 @@ func validateColor(color string) error {
 }
 
 var setAiCompletionEndpoint = &cobra.Command{
-	Use:   "ai-completion-endpoint",
-	Short: "The AI endpoint to use for AI completions",
-	Args:  cobra.ExactArgs(1),
+	Use:     "ai-completion-endpoint",
+	Aliases: []string{"ai-endpoint"},
+	Short:   "The AI endpoint to use for AI completions",
+	Long:    "The endpoint for the configured ai-provider, e.g. the URL of a self-hosted OpenAI-compatible server or of Ollama running on another machine.",
+	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
 		config := hctx.GetConf(ctx)
@@ -229,20 +719,112 @@ var setAiCompletionEndpoint = &cobra.Command{
 	},
 }
 
+var setAiProviderCmd = &cobra.Command{
+	Use:       "ai-provider",
+	Short:     "The provider to use for AI completions",
+	Long:      "One of: openai (OpenAI or any OpenAI-compatible endpoint), ollama (a local model served by Ollama), or anthropic (requires ANTHROPIC_API_KEY to be set). With ollama, queries are never sent to a remote server.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: ai.ProviderNames(),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.AiProvider = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setAiModelCmd = &cobra.Command{
+	Use:   "ai-model",
+	Short: "The model to use for AI completions (e.g. llama3 for ollama), or \"\" to use the provider's default model",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.AiModel = strings.TrimSpace(args[0])
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setAiFeedbackCmd = &cobra.Command{
+	Use:       "ai-feedback",
+	Short:     "Whether to record which AI suggestions you accept or reject, to improve how suggestions are ranked",
+	Long:      "One of: off (the default), local (feedback is only stored locally, and can be viewed via `hishtory stats --ai`), or report (aggregate acceptance counts are also reported to the backend, but your queries and the suggestions themselves never are).",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: lib.AiFeedbackModes(),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.AiFeedback = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setAiIncludeContextCmd = &cobra.Command{
+	Use:       "ai-include-context",
+	Short:     "Whether AI queries include context about your environment for more accurate suggestions",
+	Long:      "When enabled, AI queries also send: your current working directory, your CPU architecture, your 10 most recently run commands, and the versions of git, docker, kubectl, python3, node, and go (for the ones that are installed).",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.AiIncludeContext = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(configSetCmd)
 	configSetCmd.AddCommand(setEnableControlRCmd)
 	configSetCmd.AddCommand(setFilterDuplicateCommandsCmd)
+	configSetCmd.AddCommand(setNormalizeCommandsCmd)
 	configSetCmd.AddCommand(setDisplayedColumnsCmd)
 	configSetCmd.AddCommand(setTimestampFormatCmd)
+	configSetCmd.AddCommand(setIgnoredCommandPrefixCmd)
 	configSetCmd.AddCommand(setBetaModeCommand)
 	configSetCmd.AddCommand(setHighlightMatchesCmd)
+	configSetCmd.AddCommand(setMultiLineCommandsCmd)
 	configSetCmd.AddCommand(setEnableAiCompletionCmd)
 	configSetCmd.AddCommand(setPresavingCmd)
 	configSetCmd.AddCommand(setColorSchemeCmd)
 	configSetCmd.AddCommand(setDefaultFilterCommand)
 	configSetCmd.AddCommand(setAiCompletionEndpoint)
+	configSetCmd.AddCommand(setAiProviderCmd)
+	configSetCmd.AddCommand(setAiModelCmd)
+	configSetCmd.AddCommand(setAiIncludeContextCmd)
+	configSetCmd.AddCommand(setAiFeedbackCmd)
+	configSetCmd.AddCommand(setRecordGitInfoCmd)
+	configSetCmd.AddCommand(setRecordTmuxInfoCmd)
+	configSetCmd.AddCommand(setRecordSshHostCmd)
+	configSetCmd.AddCommand(setLargeHistoryModeCmd)
+	configSetCmd.AddCommand(setResultSamplingCmd)
+	configSetCmd.AddCommand(setShowWhatsNewCmd)
+	configSetCmd.AddCommand(setSecretStorageCmd)
+	configSetCmd.AddCommand(setEncryptLocalDbCmd)
+	configSetCmd.AddCommand(setTrashRetentionDaysCmd)
+	configSetCmd.AddCommand(setPinExpansionCmd)
+	configSetCmd.AddCommand(setRankBySuccessInCwdCmd)
+	configSetCmd.AddCommand(setRankerCmd)
+	configSetCmd.AddCommand(setRankFavoritesFirstCmd)
+	configSetCmd.AddCommand(setSessionSummaryCmd)
+	configSetCmd.AddCommand(setNotifyAfterCmd)
+	configSetCmd.AddCommand(setNotifyMethodCmd)
+	configSetCmd.AddCommand(setNotifyWebhookUrlCmd)
+	configSetCmd.AddCommand(setSyncModeCmd)
+	configSetCmd.AddCommand(setDimmingThresholdsCmd)
 	setColorSchemeCmd.AddCommand(setColorSchemeSelectedText)
 	setColorSchemeCmd.AddCommand(setColorSchemeSelectedBackground)
 	setColorSchemeCmd.AddCommand(setColorSchemeBorderColor)
+	setColorSchemeCmd.AddCommand(setColorSchemeHeaderText)
+	setColorSchemeCmd.AddCommand(setColorSchemeMatchHighlight)
+	setColorSchemeCmd.AddCommand(setColorSchemeWarningText)
+	setColorSchemeCmd.AddCommand(setColorSchemeErrorText)
+	setColorSchemeCmd.AddCommand(setColorSchemeHelpKey)
+	setColorSchemeCmd.AddCommand(setColorSchemeHelpDescription)
+	setColorSchemeCmd.AddCommand(setColorSchemeMatchHighlightStyle)
+	setColorSchemeCmd.AddCommand(setColorSchemePreset)
 }
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+const (
+	SYSTEMD_UNIT_NAME   = "hishtory-daemon.service"
+	LAUNCHD_AGENT_LABEL = "dev.hishtory.daemon"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:     "daemon",
+	Short:   "Run a background process that keeps your history in sync",
+	Long:    "Runs a persistent process that syncs new history entries and deletion requests every 30 seconds, so that the TUI doesn't need to sync with the backend when it is opened. Run `hishtory daemon install` to run it automatically via systemd or launchd.",
+	GroupID: GROUP_ID_CONFIG,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(lib.RunDaemon(hctx.MakeContext()))
+	},
+}
+
+var daemonInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Run the daemon automatically in the background via a systemd user unit (Linux) or a launchd agent (MacOS)",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(installDaemon(hctx.MakeContext()))
+	},
+}
+
+var daemonUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Stop running the daemon automatically in the background",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(uninstallDaemon(hctx.GetHome(hctx.MakeContext())))
+	},
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Check whether the daemon is running",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if lib.IsDaemonRunning(hctx.MakeContext()) {
+			fmt.Println("The hishtory daemon is running and keeping your history in sync")
+		} else {
+			fmt.Println("The hishtory daemon is not running")
+		}
+	},
+}
+
+func getSystemdUnitPath(homedir string) string {
+	return path.Join(homedir, ".config/systemd/user", SYSTEMD_UNIT_NAME)
+}
+
+func getLaunchdPlistPath(homedir string) string {
+	return path.Join(homedir, "Library/LaunchAgents", LAUNCHD_AGENT_LABEL+".plist")
+}
+
+func getSystemdUnit(binaryPath string) string {
+	return `[Unit]
+Description=hiSHtory background sync
+After=network-online.target
+
+[Service]
+ExecStart="` + binaryPath + `" daemon
+Restart=on-failure
+RestartSec=60
+
+[Install]
+WantedBy=default.target
+`
+}
+
+func getLaunchdPlist(homedir, binaryPath string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + LAUNCHD_AGENT_LABEL + `</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>` + binaryPath + `</string>
+		<string>daemon</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardErrorPath</key>
+	<string>` + path.Join(homedir, data.GetHishtoryPath(), "daemon.log") + `</string>
+</dict>
+</plist>
+`
+}
+
+func runServiceManager(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("failed to run `%s %s` (output=%#v): %w", name, strings.Join(args, " "), output.String(), err)
+	}
+	return nil
+}
+
+func installDaemon(ctx context.Context) error {
+	if hctx.GetConf(ctx).IsOffline {
+		return fmt.Errorf("the daemon isn't needed in offline mode since there is nothing to sync")
+	}
+	homedir := hctx.GetHome(ctx)
+	binaryPath := path.Join(homedir, data.GetHishtoryPath(), lib.GetHishtoryBinaryName())
+	switch runtime.GOOS {
+	case "linux":
+		unitPath := getSystemdUnitPath(homedir)
+		err := os.MkdirAll(path.Dir(unitPath), 0o755)
+		if err != nil {
+			return fmt.Errorf("failed to create the systemd user unit directory: %w", err)
+		}
+		err = os.WriteFile(unitPath, []byte(getSystemdUnit(binaryPath)), 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to write the systemd unit: %w", err)
+		}
+		err = runServiceManager("systemctl", "--user", "daemon-reload")
+		if err != nil {
+			return err
+		}
+		err = runServiceManager("systemctl", "--user", "enable", "--now", SYSTEMD_UNIT_NAME)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed the hishtory daemon as the systemd user unit %s\n", SYSTEMD_UNIT_NAME)
+		return nil
+	case "darwin":
+		plistPath := getLaunchdPlistPath(homedir)
+		err := os.MkdirAll(path.Dir(plistPath), 0o755)
+		if err != nil {
+			return fmt.Errorf("failed to create the LaunchAgents directory: %w", err)
+		}
+		// Unload any existing agent so that the updated plist is picked up
+		_ = runServiceManager("launchctl", "unload", plistPath)
+		err = os.WriteFile(plistPath, []byte(getLaunchdPlist(homedir, binaryPath)), 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to write the launchd plist: %w", err)
+		}
+		err = runServiceManager("launchctl", "load", "-w", plistPath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed the hishtory daemon as the launchd agent %s\n", LAUNCHD_AGENT_LABEL)
+		return nil
+	default:
+		return fmt.Errorf("installing the daemon isn't supported on %s, instead run `hishtory daemon` in the background", runtime.GOOS)
+	}
+}
+
+// Stops and removes the daemon if it was installed. This is a no-op if it wasn't installed so that it can be run as
+// part of uninstalling hishtory.
+func uninstallDaemon(homedir string) error {
+	switch runtime.GOOS {
+	case "linux":
+		unitPath := getSystemdUnitPath(homedir)
+		if _, err := os.Stat(unitPath); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		err := runServiceManager("systemctl", "--user", "disable", "--now", SYSTEMD_UNIT_NAME)
+		if err != nil {
+			return err
+		}
+		return os.Remove(unitPath)
+	case "darwin":
+		plistPath := getLaunchdPlistPath(homedir)
+		if _, err := os.Stat(plistPath); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		err := runServiceManager("launchctl", "unload", "-w", plistPath)
+		if err != nil {
+			return err
+		}
+		return os.Remove(plistPath)
+	default:
+		return nil
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.AddCommand(daemonInstallCmd)
+	daemonCmd.AddCommand(daemonUninstallCmd)
+	daemonCmd.AddCommand(daemonStatusCmd)
+}
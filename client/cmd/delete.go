@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	deleteQuery  *string
+	deleteDryRun *bool
+	deleteForce  *bool
+)
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete all entries matching a search query, on this device and on all of your other devices",
+	Long: "Deletes every entry matching the query, which supports the same format as 'hishtory query'. For example, `hishtory delete --query 'cwd:~/old-project'` deletes everything run in ~/old-project. " +
+		"Pass --dry-run to only preview the matching entries. The query can also be passed as arguments (e.g. `hishtory delete psql`), but queries containing terms starting with `-` must be passed via --query.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		query := strings.Join(args, " ")
+		if cmd.Flags().Changed("query") {
+			if len(args) > 0 {
+				lib.CheckFatalError(fmt.Errorf("the query must be passed either via --query or as arguments, not both"))
+			}
+			query = *deleteQuery
+		}
+		if strings.TrimSpace(query) == "" {
+			lib.CheckFatalError(fmt.Errorf("refusing to delete every entry, pass a query via --query"))
+		}
+		if *deleteDryRun {
+			lib.CheckFatalError(previewDeletion(ctx, query))
+			return
+		}
+		skipOnlineDeletion := false
+		if !lib.CanReachHishtoryServer(ctx) {
+			fmt.Printf("Cannot reach hishtory backend (is this device offline?) so the deletion will only apply to this device and not other synced devices. Would you like to continue with a local-only deletion anyways? [y/N] ")
+			reader := bufio.NewReader(os.Stdin)
+			resp, err := reader.ReadString('\n')
+			lib.CheckFatalError(err)
+			if strings.TrimSpace(resp) != "y" {
+				fmt.Printf("Aborting delete per user response of %#v\n", strings.TrimSpace(resp))
+				return
+			}
+			skipOnlineDeletion = true
+		}
+		lib.CheckFatalError(lib.RetrieveAdditionalEntriesFromRemote(ctx, "delete"))
+		lib.CheckFatalError(lib.ProcessDeletionRequests(ctx))
+		lib.CheckFatalError(redact(ctx, query, *deleteForce, skipOnlineDeletion))
+	},
+}
+
+// Displays how many entries match the query and a preview of them, without deleting anything
+func previewDeletion(ctx context.Context, query string) error {
+	tx, err := lib.MakeWhereQueryFromSearch(ctx, hctx.GetDb(ctx), query)
+	if err != nil {
+		return err
+	}
+	var historyEntries []*data.HistoryEntry
+	err = tx.Find(&historyEntries).Error
+	if err != nil {
+		return err
+	}
+	if err := previewEntries(ctx, historyEntries); err != nil {
+		return err
+	}
+	fmt.Printf("Dry run: this would permanently delete %d entries\n", len(historyEntries))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(deleteCmd)
+	deleteQuery = deleteCmd.Flags().String("query", "", "The search query for the entries to delete")
+	deleteDryRun = deleteCmd.Flags().Bool("dry-run", false, "Only preview the entries that would be deleted")
+	deleteForce = deleteCmd.Flags().Bool("force", false, "Don't prompt for confirmation before deleting")
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+)
+
+var deviceCmd = &cobra.Command{
+	Use:     "device",
+	Short:   "Manage the devices that are syncing your shell history",
+	GroupID: GROUP_ID_MANAGEMENT,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		cmd.Root().PersistentPreRun(cmd, args)
+		if hctx.GetConf(hctx.MakeContext()).IsOffline {
+			lib.CheckFatalError(fmt.Errorf("device management is not supported for offline installs of hishtory"))
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(cmd.Help())
+		os.Exit(1)
+	},
+}
+
+var deviceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all devices that are syncing your shell history",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		devices, err := lib.GetDevices(ctx)
+		lib.CheckFatalError(err)
+		tbl := table.New("Device ID", "Name", "Registered", "Sync Mode")
+		tbl.WithHeaderFormatter(color.New(color.FgGreen, color.Underline).SprintfFunc())
+		for _, device := range devices {
+			deviceId := device.DeviceId
+			if deviceId == hctx.GetConf(ctx).DeviceId {
+				deviceId += " (this device)"
+			}
+			tbl.AddRow(deviceId, device.Name, device.RegistrationDate.Local().Format(hctx.GetConf(ctx).TimestampFormat), device.SyncMode)
+		}
+		tbl.Print()
+	},
+}
+
+var deviceRenameCmd = &cobra.Command{
+	Use:   "rename [device] <name>",
+	Short: "Set the name of a device (defaults to the current device), which is displayed in the Device column",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		deviceId := hctx.GetConf(ctx).DeviceId
+		name := args[0]
+		if len(args) == 2 {
+			var err error
+			deviceId, err = resolveDevice(ctx, args[0])
+			lib.CheckFatalError(err)
+			name = args[1]
+		}
+		lib.CheckFatalError(lib.RenameDevice(ctx, deviceId, name))
+		fmt.Printf("Renamed device %s to %#v\n", deviceId, name)
+	},
+}
+
+var deviceRevokeCmd = &cobra.Command{
+	Use:   "revoke <device>",
+	Short: "Revoke a device (e.g. a lost laptop) so that it no longer receives new history entries",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		deviceId, err := resolveDevice(ctx, args[0])
+		lib.CheckFatalError(err)
+		if deviceId == hctx.GetConf(ctx).DeviceId {
+			lib.CheckFatalError(fmt.Errorf("refusing to revoke the current device, use `hishtory uninstall` instead"))
+		}
+		fmt.Printf("Are you sure you want to revoke device %s? It will stop receiving new history entries from your other devices [y/N]", deviceId)
+		reader := bufio.NewReader(os.Stdin)
+		resp, err := reader.ReadString('\n')
+		lib.CheckFatalError(err)
+		if strings.TrimSpace(resp) != "y" {
+			fmt.Printf("Aborting revoke per user response of %#v\n", strings.TrimSpace(resp))
+			return
+		}
+		lib.CheckFatalError(lib.RevokeDevice(ctx, deviceId))
+		fmt.Printf("Revoked device %s\n", deviceId)
+	},
+}
+
+// Resolves a device specified by either its ID or its name into a device ID
+func resolveDevice(ctx context.Context, device string) (string, error) {
+	devices, err := lib.GetDevices(ctx)
+	if err != nil {
+		return "", err
+	}
+	matchingDeviceIds := make([]string, 0)
+	for _, d := range devices {
+		if d.DeviceId == device {
+			return d.DeviceId, nil
+		}
+		if d.Name == device {
+			matchingDeviceIds = append(matchingDeviceIds, d.DeviceId)
+		}
+	}
+	if len(matchingDeviceIds) == 0 {
+		return "", fmt.Errorf("no device found with the ID or name %#v, run `hishtory device list` to see all devices", device)
+	}
+	if len(matchingDeviceIds) > 1 {
+		return "", fmt.Errorf("multiple devices are named %#v, please specify the device ID instead (one of %s)", device, strings.Join(matchingDeviceIds, ", "))
+	}
+	return matchingDeviceIds[0], nil
+}
+
+func init() {
+	rootCmd.AddCommand(deviceCmd)
+	deviceCmd.AddCommand(deviceListCmd)
+	deviceCmd.AddCommand(deviceRenameCmd)
+	deviceCmd.AddCommand(deviceRevokeCmd)
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var exportDeviceBundleCmd = &cobra.Command{
+	Use:     "export-device-bundle [file]",
+	Short:   "Export this device's history to an encrypted bundle that can be imported on another device",
+	Long:    "Writes all of this device's history to an encrypted bundle (or to stdout if no file is given), so that it can be imported via `hishtory import-device-bundle` on another device with the same secret without going through the backend. For example, `hishtory export-device-bundle | ssh other-host hishtory import-device-bundle`. The bundle can only be decrypted with your secret, so it is safe to copy over untrusted channels such as a USB drive.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		var w io.Writer = os.Stdout
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+			lib.CheckFatalError(err)
+			defer f.Close()
+			w = f
+		}
+		numEntries, err := lib.ExportDeviceBundle(ctx, w)
+		lib.CheckFatalError(err)
+		// Print to stderr since stdout may be the bundle itself
+		fmt.Fprintf(os.Stderr, "Exported %d history entries\n", numEntries)
+	},
+}
+
+var importDeviceBundleCmd = &cobra.Command{
+	Use:     "import-device-bundle [file]",
+	Short:   "Import history from a bundle created via `hishtory export-device-bundle`",
+	Long:    "Imports the history from a bundle created via `hishtory export-device-bundle` on another device with the same secret (or from stdin if no file is given). Entries that are already stored on this device are skipped.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		var r io.Reader = os.Stdin
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			lib.CheckFatalError(err)
+			defer f.Close()
+			r = f
+		}
+		numImported, err := lib.ImportDeviceBundle(ctx, r)
+		lib.CheckFatalError(err)
+		fmt.Printf("Imported %d new history entries\n", numImported)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportDeviceBundleCmd)
+	rootCmd.AddCommand(importDeviceBundleCmd)
+}
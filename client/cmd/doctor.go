@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var runMaintenance *bool
+
+var doctorCmd = &cobra.Command{
+	Use:     "doctor",
+	Short:   "Check the health of the local hishtory DB",
+	Long:    "Shows the results of the weekly maintenance that keeps the local DB fast (refreshing query planner statistics, reclaiming space from deleted entries, and checking the DB and its indexes for corruption). Pass --run-maintenance to run it immediately.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Printf("Database: %s (%s)\n", hctx.GetDbPath(hctx.GetHome(ctx)), formatBytes(lib.GetDbSize(ctx)))
+		if *runMaintenance {
+			fmt.Println("Running maintenance...")
+			_, err := lib.RunDbMaintenance(ctx)
+			if err != nil {
+				fmt.Printf("Warning: maintenance failed: %v\n", err)
+			}
+		}
+		result := config.DbMaintenance
+		if result == nil || (result.IntegrityCheck == "" && result.Error == "") {
+			fmt.Println("Last Maintenance: never")
+			if result != nil {
+				nextRun := time.Unix(result.Timestamp, 0).Add(lib.DB_MAINTENANCE_INTERVAL)
+				fmt.Printf("Next Maintenance: %s\n", nextRun.Format(config.TimestampFormat))
+			}
+			return
+		}
+		lastRun := time.Unix(result.Timestamp, 0)
+		fmt.Printf("Last Maintenance: %s (%s ago, took %s)\n", lastRun.Format(config.TimestampFormat), time.Since(lastRun).Round(time.Second), time.Duration(result.DurationMs)*time.Millisecond)
+		fmt.Printf("  Size: %s -> %s\n", formatBytes(result.SizeBefore), formatBytes(result.SizeAfter))
+		fmt.Printf("  Integrity Check: %s\n", result.IntegrityCheck)
+		if result.Reindexed {
+			fmt.Println("  Indexes were rebuilt since the integrity check initially failed")
+		}
+		if result.Error != "" {
+			fmt.Printf("  Error: %s\n", result.Error)
+		}
+		fmt.Printf("Next Maintenance: %s\n", lastRun.Add(lib.DB_MAINTENANCE_INTERVAL).Format(config.TimestampFormat))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	runMaintenance = doctorCmd.Flags().Bool("run-maintenance", false, "Run the DB maintenance now rather than waiting for it to run automatically")
+}
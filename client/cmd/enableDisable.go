@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"slices"
 
 	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
@@ -28,6 +31,57 @@ var disableCmd = &cobra.Command{
 	},
 }
 
+var offCmd = &cobra.Command{
+	Use:     "off",
+	Short:   "Stop recording commands run in the current shell session",
+	GroupID: GROUP_ID_CONFIG,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		lib.CheckFatalError(SetIncognito(ctx, os.Getenv("HISHTORY_SESSION_ID"), true))
+	},
+}
+
+var onCmd = &cobra.Command{
+	Use:     "on",
+	Short:   "Resume recording commands run in the current shell session after `hishtory off`",
+	GroupID: GROUP_ID_CONFIG,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		lib.CheckFatalError(SetIncognito(ctx, os.Getenv("HISHTORY_SESSION_ID"), false))
+	},
+}
+
+// The maximum number of incognito sessions that are remembered, so that sessions that were closed without running
+// `hishtory on` don't accumulate in the config forever
+const MAX_INCOGNITO_SESSIONS = 100
+
+func SetIncognito(ctx context.Context, sessionId string, isIncognito bool) error {
+	if sessionId == "" {
+		return fmt.Errorf("failed to find the current session ID, this is only supported when running from a shell with hishtory's shell integration enabled")
+	}
+	config := hctx.GetConf(ctx)
+	sessionIds := make([]string, 0)
+	for _, id := range config.IncognitoSessionIds {
+		if id != sessionId {
+			sessionIds = append(sessionIds, id)
+		}
+	}
+	if isIncognito {
+		sessionIds = append(sessionIds, sessionId)
+		if len(sessionIds) > MAX_INCOGNITO_SESSIONS {
+			sessionIds = sessionIds[len(sessionIds)-MAX_INCOGNITO_SESSIONS:]
+		}
+	}
+	config.IncognitoSessionIds = sessionIds
+	return hctx.SetConfig(config)
+}
+
+func isIncognitoSession(config *hctx.ClientConfig, sessionId string) bool {
+	return sessionId != "" && slices.Contains(config.IncognitoSessionIds, sessionId)
+}
+
 func Enable(ctx context.Context) error {
 	config := hctx.GetConf(ctx)
 	config.IsEnabled = true
@@ -43,4 +97,6 @@ func Disable(ctx context.Context) error {
 func init() {
 	rootCmd.AddCommand(enableCmd)
 	rootCmd.AddCommand(disableCmd)
+	rootCmd.AddCommand(offCmd)
+	rootCmd.AddCommand(onCmd)
 }
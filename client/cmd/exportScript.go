@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportScriptOutput *string
+	exportScriptLimit  *int
+)
+
+var exportScriptCmd = &cobra.Command{
+	Use:     "export-script [query]",
+	Short:   "Turn the commands matching the query into an executable shell script",
+	Long:    "Writes the commands matching the query to a shell script in the order that they were run, with comments containing when each command was run and its exit code. This is useful for turning an exploratory session into an automation script, e.g. `hishtory export-script session:current -o setup.sh`. Commands can also be marked in the TUI and then saved as a script via the command palette.",
+	GroupID: GROUP_ID_QUERYING,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		query := strings.Join(args, " ")
+		entries, err := lib.GetEntriesInRunOrder(ctx, query, *exportScriptLimit)
+		lib.CheckFatalError(err)
+		if len(entries) == 0 {
+			lib.CheckFatalError(fmt.Errorf("no commands found matching the query %#v", query))
+		}
+		script := lib.BuildShellScript(entries, true)
+		if *exportScriptOutput == "" {
+			fmt.Print(script)
+			return
+		}
+		lib.CheckFatalError(os.WriteFile(*exportScriptOutput, []byte(script), 0o755))
+		fmt.Fprintf(os.Stderr, "Wrote %d commands to %s\n", len(entries), *exportScriptOutput)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportScriptCmd)
+	exportScriptOutput = exportScriptCmd.Flags().StringP("output", "o", "", "The file to write the script to, rather than printing it")
+	exportScriptLimit = exportScriptCmd.Flags().Int("limit", 100, "The maximum number of commands to include, counting back from the most recent matching command")
+}
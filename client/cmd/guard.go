@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var checkGuardCmd = &cobra.Command{
+	Use:    "checkGuard",
+	Hidden: true,
+	Short:  "[Internal-only] Ask for confirmation if the given command matches a guard rule, and exit with an error if it shouldn't be run",
+	Args:   cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		command := args[1]
+		if args[0] == "bash" {
+			// bash passes the output of `history 1`, which includes the history number and possibly a timestamp
+			lastCommand, err := getLastCommand(command)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "hishtory: failed to check guard rules: %v\n", err)
+				return
+			}
+			command, err = maybeSkipBashHistTimePrefix(lastCommand)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "hishtory: failed to check guard rules: %v\n", err)
+				return
+			}
+		}
+		rule, err := lib.MatchGuardRule(ctx, command)
+		if err != nil {
+			// Don't block every command because of an invalid rule
+			fmt.Fprintf(os.Stderr, "hishtory: failed to check guard rules: %v\n", err)
+			return
+		}
+		if rule != nil && !confirmGuardedCommand(rule, os.Stdin, os.Stderr) {
+			os.Exit(1)
+		}
+	},
+}
+
+// Asks whether a command that matched the given guard rule should be run anyways. Anything other than an explicit
+// yes is treated as a no.
+func confirmGuardedCommand(rule *hctx.GuardRule, in io.Reader, out io.Writer) bool {
+	fmt.Fprintf(out, "hishtory: this command matches the guard rule %#v", rule.Pattern)
+	if rule.Message != "" {
+		fmt.Fprintf(out, " (%s)", rule.Message)
+	}
+	fmt.Fprint(out, "\nRun it anyways? [y/N] ")
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(out)
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func init() {
+	rootCmd.AddCommand(checkGuardCmd)
+}
@@ -2,22 +2,52 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
 	"github.com/spf13/cobra"
 )
 
+var (
+	importFormat *string
+	importSsh    *string
+)
+
 var importCmd = &cobra.Command{
 	Use:    "import",
 	Hidden: true,
 	Short:  "Re-import history entries from your existing shell history",
-	Long:   "Note that you must pipe commands to be imported in via stdin. For example `history | hishtory import`.",
+	Long:   "Note that you must pipe commands to be imported in via stdin. For example `history | hishtory import`. To import entries exported via `hishtory export --format jsonl -`, run `hishtory import --format jsonl -`.",
+	Args:   cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
-		numImported, err := lib.ImportHistory(ctx, true, true)
+		if len(args) == 1 && args[0] != "-" {
+			lib.CheckFatalError(fmt.Errorf("unexpected argument %#v, history entries can only be imported from stdin via `-`", args[0]))
+		}
+		var numImported int
+		var err error
+		if *importSsh != "" {
+			if len(args) > 0 || *importFormat != "" {
+				lib.CheckFatalError(fmt.Errorf("--ssh imports the history stored on the remote host, so it can't be combined with importing from stdin"))
+			}
+			numImported, err = lib.ImportHistoryOverSsh(ctx, *importSsh)
+			lib.CheckFatalError(err)
+			fmt.Printf("Imported %v history entries from %s\n", numImported, *importSsh)
+			return
+		}
+		switch *importFormat {
+		case "":
+			numImported, err = lib.ImportHistory(ctx, true, true)
+		case "jsonl":
+			numImported, err = lib.ImportJsonlHistory(ctx, os.Stdin)
+		default:
+			err = fmt.Errorf("unsupported import format %#v, must be jsonl", *importFormat)
+		}
 		lib.CheckFatalError(err)
-		if numImported > 0 {
+		if numImported > 0 && *importFormat == "jsonl" {
+			fmt.Printf("Imported %v history entries\n", numImported)
+		} else if numImported > 0 {
 			fmt.Printf("Imported %v history entries from your existing shell history\n", numImported)
 		}
 	},
@@ -25,4 +55,6 @@ var importCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(importCmd)
+	importFormat = importCmd.Flags().String("format", "", "The format of the history entries piped in via stdin (jsonl), defaults to raw shell commands")
+	importSsh = importCmd.Flags().String("ssh", "", "Import the shell history stored on a remote host (e.g. user@host) by connecting to it over SSH")
 }
@@ -12,7 +12,6 @@ import (
 	"path"
 	"runtime"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/ddworken/hishtory/client/data"
@@ -26,7 +25,13 @@ import (
 
 var offlineInit *bool
 var forceInit *bool
+var ephemeralInit *bool
+var dbPathInit *string
+var secretStorageInit *string
+var secretCommandInit *string
 var offlineInstall *bool
+var ephemeralInstall *bool
+var dbPathInstall *string
 
 var installCmd = &cobra.Command{
 	Use:    "install",
@@ -38,7 +43,7 @@ var installCmd = &cobra.Command{
 		if len(args) > 0 {
 			secretKey = args[0]
 		}
-		lib.CheckFatalError(install(secretKey, *offlineInstall))
+		lib.CheckFatalError(install(secretKey, *offlineInstall, getEphemeralDbPath(*ephemeralInstall, *dbPathInstall)))
 		if os.Getenv("HISHTORY_SKIP_INIT_IMPORT") == "" {
 			db, err := hctx.OpenLocalSqliteDb()
 			lib.CheckFatalError(err)
@@ -78,11 +83,31 @@ var initCmd = &cobra.Command{
 				return
 			}
 		}
-		secretKey := ""
+		// The secret can also be passed via an environment variable so that it isn't visible in the process list (e.g.
+		// when initializing containers)
+		secretKey := os.Getenv("HISHTORY_SECRET")
 		if len(args) > 0 {
 			secretKey = args[0]
 		}
-		lib.CheckFatalError(setup(secretKey, *offlineInit))
+		if *secretStorageInit == hctx.SecretStorageCommand && secretKey == "" {
+			// Initialize with the secret that is already stored (e.g. in a password manager shared with other devices)
+			secretKey, err = hctx.ReadSecretFromCommand(*secretCommandInit)
+			lib.CheckFatalError(err)
+		}
+		dbPath := *dbPathInit
+		if *ephemeralInit && dbPath == "" {
+			// Keep the DB path of an existing ephemeral install (e.g. one that was installed while building a container
+			// image, and is initialized with the user's secret once the container starts)
+			if existingConfig, err := hctx.GetConfig(); err == nil {
+				dbPath = existingConfig.DbPath
+			}
+		}
+		lib.CheckFatalError(setupWithEphemeralDb(secretKey, *offlineInit, getEphemeralDbPath(*ephemeralInit, dbPath)))
+		if *secretStorageInit != "" {
+			config, err := hctx.GetConfig()
+			lib.CheckFatalError(err)
+			lib.CheckFatalError(hctx.SetSecretStorage(&config, *secretStorageInit, *secretCommandInit))
+		}
 		if os.Getenv("HISHTORY_SKIP_INIT_IMPORT") == "" {
 			fmt.Println("Importing existing shell history...")
 			ctx := hctx.MakeContext()
@@ -153,7 +178,21 @@ func warnIfUnsupportedBashVersion() error {
 	return nil
 }
 
-func install(secretKey string, offline bool) error {
+// Returns the path that the DB should be stored at for ephemeral installs, or an empty string for normal installs
+func getEphemeralDbPath(isEphemeral bool, dbPath string) string {
+	if !isEphemeral {
+		if dbPath != "" {
+			lib.CheckFatalError(fmt.Errorf("--db-path is only supported for --ephemeral installs"))
+		}
+		return ""
+	}
+	if dbPath != "" {
+		return dbPath
+	}
+	return lib.GetDefaultEphemeralDbPath()
+}
+
+func install(secretKey string, offline bool, ephemeralDbPath string) error {
 	homedir, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("failed to get user's home directory: %w", err)
@@ -178,6 +217,14 @@ func install(secretKey string, offline bool) error {
 	if err != nil {
 		return err
 	}
+	err = configurePowerShell(homedir)
+	if err != nil {
+		return err
+	}
+	err = configureNushell(homedir)
+	if err != nil {
+		return err
+	}
 	err = handleUpgradedFeatures()
 	if err != nil {
 		return err
@@ -185,13 +232,18 @@ func install(secretKey string, offline bool) error {
 	_, err = hctx.GetConfig()
 	if err != nil {
 		// No config, so set up a new installation
-		return setup(secretKey, offline)
+		if secretKey == "" {
+			secretKey = os.Getenv("HISHTORY_SECRET")
+		}
+		return setupWithEphemeralDb(secretKey, offline, ephemeralDbPath)
 	}
+	ctx := hctx.MakeContext()
 	// TODO: Only trigger this if the version is old enough
-	err = handleDbUpgrades(hctx.MakeContext())
+	err = handleDbUpgrades(ctx)
 	if err != nil {
 		return err
 	}
+	cacheReleaseNotes(ctx)
 	return nil
 }
 
@@ -229,6 +281,10 @@ func handleUpgradedFeatures() error {
 		// Presaving is not yet configured, so enable it
 		config.EnablePresaving = true
 	}
+	if !strings.Contains(string(configContents), "show_whats_new") {
+		// Showing release notes is not yet configured, so enable it
+		config.ShowWhatsNew = true
+	}
 	if !strings.Contains(string(configContents), "ai_completion") {
 		// AI completion is not yet configured, disable it for upgrades since this is a new feature
 		config.AiCompletion = false
@@ -239,10 +295,10 @@ func handleUpgradedFeatures() error {
 func installBinary(homedir string) (string, error) {
 	clientPath, err := exec.LookPath("hishtory")
 	if err != nil {
-		clientPath = path.Join(homedir, data.GetHishtoryPath(), "hishtory")
+		clientPath = path.Join(homedir, data.GetHishtoryPath(), lib.GetHishtoryBinaryName())
 	}
 	if _, err := os.Stat(clientPath); err == nil {
-		err = syscall.Unlink(clientPath)
+		err = lib.RemoveBinaryForReplacement(clientPath)
 		if err != nil {
 			return "", fmt.Errorf("failed to unlink %s for install: %w", clientPath, err)
 		}
@@ -313,6 +369,116 @@ func isFishConfigured(homedir string) (bool, error) {
 	return strings.Contains(string(fishConfig), getFishConfigFragment(homedir)), nil
 }
 
+func getPowerShellConfigPath(homedir string) string {
+	return path.Join(homedir, data.GetHishtoryPath(), "config.ps1")
+}
+
+// Returns the path of the PowerShell profile for the current user, which is equivalent to
+// $PROFILE.CurrentUserCurrentHost in pwsh
+func getPowerShellProfilePath(homedir string) string {
+	if runtime.GOOS == "windows" {
+		return path.Join(homedir, "Documents/PowerShell/Microsoft.PowerShell_profile.ps1")
+	}
+	return path.Join(homedir, ".config/powershell/Microsoft.PowerShell_profile.ps1")
+}
+
+func configurePowerShell(homedir string) error {
+	// Check if PowerShell is installed
+	_, err := exec.LookPath("pwsh")
+	if err != nil {
+		return nil
+	}
+	// Create the file we're going to source. Do this no matter what in case there are updates to it.
+	configContents := lib.ConfigPowerShellContents
+	if os.Getenv("HISHTORY_TEST") != "" {
+		testConfig, err := tweakConfigForTests(configContents)
+		if err != nil {
+			return err
+		}
+		configContents = testConfig
+	}
+	err = os.WriteFile(getPowerShellConfigPath(homedir), []byte(configContents), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write config.ps1 file: %w", err)
+	}
+	// Check if we need to configure the PowerShell profile
+	profilePath := getPowerShellProfilePath(homedir)
+	profile, err := os.ReadFile(profilePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", profilePath, err)
+	}
+	if strings.Contains(string(profile), getPowerShellConfigFragment(homedir)) {
+		return nil
+	}
+	err = os.MkdirAll(path.Dir(profilePath), 0o744)
+	if err != nil {
+		return fmt.Errorf("failed to create PowerShell profile directory: %w", err)
+	}
+	return addToShellConfig(profilePath, getPowerShellConfigFragment(homedir))
+}
+
+func getPowerShellConfigFragment(homedir string) string {
+	return "\n# Hishtory Config:\n$env:PATH += [IO.Path]::PathSeparator + \"" + path.Join(homedir, data.GetHishtoryPath()) + "\"\n. \"" + getPowerShellConfigPath(homedir) + "\"\n"
+}
+
+func getNushellConfigPath(homedir string) string {
+	return path.Join(homedir, data.GetHishtoryPath(), "config.nu")
+}
+
+// Returns the path of Nushell's config.nu for the current user, which is equivalent to $nu.config-path in nu
+func getNushellProfilePath(homedir string) string {
+	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
+		return path.Join(xdgConfigHome, "nushell/config.nu")
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return path.Join(homedir, "Library/Application Support/nushell/config.nu")
+	case "windows":
+		return path.Join(homedir, "AppData/Roaming/nushell/config.nu")
+	default:
+		return path.Join(homedir, ".config/nushell/config.nu")
+	}
+}
+
+func configureNushell(homedir string) error {
+	// Check if Nushell is installed
+	_, err := exec.LookPath("nu")
+	if err != nil {
+		return nil
+	}
+	// Create the file we're going to source. Do this no matter what in case there are updates to it.
+	configContents := lib.ConfigNushellContents
+	if os.Getenv("HISHTORY_TEST") != "" {
+		testConfig, err := tweakConfigForTests(configContents)
+		if err != nil {
+			return err
+		}
+		configContents = testConfig
+	}
+	err = os.WriteFile(getNushellConfigPath(homedir), []byte(configContents), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write config.nu file: %w", err)
+	}
+	// Check if we need to configure Nushell's config.nu
+	profilePath := getNushellProfilePath(homedir)
+	profile, err := os.ReadFile(profilePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", profilePath, err)
+	}
+	if strings.Contains(string(profile), getNushellConfigFragment(homedir)) {
+		return nil
+	}
+	err = os.MkdirAll(path.Dir(profilePath), 0o744)
+	if err != nil {
+		return fmt.Errorf("failed to create Nushell config directory: %w", err)
+	}
+	return addToShellConfig(profilePath, getNushellConfigFragment(homedir))
+}
+
+func getNushellConfigFragment(homedir string) string {
+	return "\n# Hishtory Config:\n$env.PATH = ($env.PATH | append \"" + path.Join(homedir, data.GetHishtoryPath()) + "\")\nsource \"" + getNushellConfigPath(homedir) + "\"\n"
+}
+
 func getZshConfigPath(homedir string) string {
 	return path.Join(homedir, data.GetHishtoryPath(), "config.zsh")
 }
@@ -532,6 +698,26 @@ func uninstall(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	err = stripLines(getPowerShellProfilePath(homedir), getPowerShellConfigFragment(homedir))
+	if err != nil {
+		return err
+	}
+	err = stripLines(getNushellProfilePath(homedir), getNushellConfigFragment(homedir))
+	if err != nil {
+		return err
+	}
+	err = uninstallDaemon(homedir)
+	if err != nil {
+		return err
+	}
+	if hctx.GetConf(ctx).EncryptLocalDb {
+		if decryptedPath, err := hctx.GetDecryptedDbPath(); err == nil {
+			err = os.RemoveAll(path.Dir(decryptedPath))
+			if err != nil {
+				return err
+			}
+		}
+	}
 	err = os.RemoveAll(path.Join(homedir, data.GetHishtoryPath()))
 	if err != nil {
 		return err
@@ -565,6 +751,11 @@ func stripLines(filePath, lines string) error {
 }
 
 func setup(userSecret string, isOffline bool) error {
+	return setupWithEphemeralDb(userSecret, isOffline, "")
+}
+
+// Sets up a new install, which is ephemeral and stores its DB at ephemeralDbPath if it is non-empty
+func setupWithEphemeralDb(userSecret string, isOffline bool, ephemeralDbPath string) error {
 	if userSecret == "" {
 		userSecret = uuid.Must(uuid.NewRandom()).String()
 	}
@@ -580,6 +771,11 @@ func setup(userSecret string, isOffline bool) error {
 	config.AiCompletion = true
 	config.IsOffline = isOffline
 	config.EnablePresaving = true
+	config.ShowWhatsNew = true
+	// New installs only ever upload entries in the current payload format, so there is nothing to re-encrypt
+	config.Reencryption = &hctx.ReencryptionProgress{PayloadVersion: data.CurrentPayloadVersion, Done: true}
+	config.IsEphemeral = ephemeralDbPath != ""
+	config.DbPath = ephemeralDbPath
 	err := hctx.SetConfig(&config)
 	if err != nil {
 		return fmt.Errorf("failed to persist config to disk: %w", err)
@@ -599,10 +795,15 @@ func setup(userSecret string, isOffline bool) error {
 	if config.IsOffline {
 		return nil
 	}
+	if config.IsEphemeral {
+		// Ephemeral installs only need to upload the commands run in the container, so skip downloading the existing
+		// history since the local DB will be thrown away with the container
+		return registerDevice(hctx.MakeContext(), &config, userSecret)
+	}
 	return registerAndBootstrapDevice(hctx.MakeContext(), &config, db, userSecret)
 }
 
-func registerAndBootstrapDevice(ctx context.Context, config *hctx.ClientConfig, db *gorm.DB, userSecret string) error {
+func registerDevice(ctx context.Context, config *hctx.ClientConfig, userSecret string) error {
 	registerPath := "/api/v1/register?user_id=" + data.UserId(userSecret) + "&device_id=" + config.DeviceId
 	if isIntegrationTestDevice() {
 		registerPath += "&is_integration_test_device=true"
@@ -611,16 +812,23 @@ func registerAndBootstrapDevice(ctx context.Context, config *hctx.ClientConfig,
 	if err != nil {
 		return fmt.Errorf("failed to register device with backend: %w", err)
 	}
+	// Sequence IDs are tracked separately for each registration, so a newly registered device starts from scratch
+	config.SyncCursor = 0
+	return nil
+}
 
-	respBody, err := lib.ApiGet(ctx, "/api/v1/bootstrap?user_id="+data.UserId(userSecret)+"&device_id="+config.DeviceId)
+func registerAndBootstrapDevice(ctx context.Context, config *hctx.ClientConfig, db *gorm.DB, userSecret string) error {
+	err := registerDevice(ctx, config, userSecret)
 	if err != nil {
-		return fmt.Errorf("failed to bootstrap device from the backend: %w", err)
+		return err
 	}
-	var retrievedEntries []*shared.EncHistoryEntry
-	err = json.Unmarshal(respBody, &retrievedEntries)
+
+	retrievedEntries, err := lib.ApiGetEncHistoryEntries(ctx, "/api/v1/bootstrap?user_id="+data.UserId(userSecret)+"&device_id="+config.DeviceId)
 	if err != nil {
-		return fmt.Errorf("failed to load JSON response: %w", err)
+		return fmt.Errorf("failed to bootstrap device from the backend: %w", err)
 	}
+	// Older servers return a copy of each entry for every device, so skip decrypting the duplicates
+	retrievedEntries = shared.DedupEncHistoryEntries(retrievedEntries)
 	hctx.GetLogger().Infof("Bootstrapping new device: Found %d entries", len(retrievedEntries))
 	for _, entry := range retrievedEntries {
 		decEntry, err := data.DecryptHistoryEntry(userSecret, *entry)
@@ -650,5 +858,11 @@ func init() {
 
 	offlineInit = initCmd.Flags().Bool("offline", false, "Install hiSHtory in offline mode wiht all syncing capabilities disabled")
 	forceInit = initCmd.Flags().Bool("force", false, "Force re-init without any prompts")
+	ephemeralInit = initCmd.Flags().Bool("ephemeral", false, "Initialize hiSHtory for an ephemeral environment (e.g. a container), where the local DB is stored in memory and commands are tagged with the container ID")
+	dbPathInit = initCmd.Flags().String("db-path", "", "The path to store the local DB at for --ephemeral installs (e.g. in a bind-mounted directory), defaults to an in-memory path")
+	secretStorageInit = initCmd.Flags().String("secret-storage", "", "Where to store the secret key: config (in plaintext in the config file, the default), keychain (in the macOS Keychain or the Linux Secret Service), or command (retrieved via --secret-command)")
+	secretCommandInit = initCmd.Flags().String("secret-command", "", "The shell command that prints the secret key for --secret-storage=command (e.g. `pass show hishtory`)")
 	offlineInstall = installCmd.Flags().Bool("offline", false, "Install hiSHtory in offline mode wiht all syncing capabilities disabled")
+	ephemeralInstall = installCmd.Flags().Bool("ephemeral", false, "Install hiSHtory for an ephemeral environment (e.g. a container), where the local DB is stored in memory and commands are tagged with the container ID")
+	dbPathInstall = installCmd.Flags().String("db-path", "", "The path to store the local DB at for --ephemeral installs (e.g. in a bind-mounted directory), defaults to an in-memory path")
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/ddworken/hishtory/client/data"
 	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
 	"github.com/ddworken/hishtory/shared/testutils"
 	"github.com/stretchr/testify/require"
 )
@@ -58,3 +59,13 @@ func TestSetupOffline(t *testing.T) {
 		t.Fatalf("hishtory config should have been offline, actual=%#v", string(data))
 	}
 }
+
+func TestTweakConfigForTests(t *testing.T) {
+	for _, configContents := range []string{lib.ConfigShContents, lib.ConfigZshContents, lib.ConfigFishContents, lib.ConfigPowerShellContents, lib.ConfigNushellContents} {
+		tweaked, err := tweakConfigForTests(configContents)
+		require.NoError(t, err)
+		require.NotContains(t, tweaked, "# Background Run")
+		require.NotContains(t, tweaked, "# Foreground Run")
+		require.Contains(t, tweaked, "saveHistoryEntry")
+	}
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+// Triggers must start with this prefix so that the shell integration only needs to look up words that could be
+// triggers, rather than every word typed at the prompt
+const PIN_TRIGGER_PREFIX = ","
+
+var pinCmd = &cobra.Command{
+	Use:     "pin <trigger> [query]",
+	Short:   "Pin the most recent command matching the query (or the last command) under a short trigger like ,deploy",
+	Long:    "Pins the most recent command matching the query under the given trigger. With `hishtory config-set pin-expansion true`, typing the trigger at the zsh prompt expands it to the pinned command. Pinned commands can be listed and removed via `hishtory config-get pinned-entries` and `hishtory config-delete pinned-entries`.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		trigger := args[0]
+		lib.CheckFatalError(validatePinTrigger(trigger))
+		command, err := findCommandToPin(ctx, strings.Join(args[1:], " "))
+		lib.CheckFatalError(err)
+		config := hctx.GetConf(ctx)
+		if config.PinnedEntries == nil {
+			config.PinnedEntries = make(map[string]string)
+		}
+		config.PinnedEntries[trigger] = command
+		lib.CheckFatalError(hctx.SetConfig(config))
+		fmt.Printf("Pinned %#v as %s\n", command, trigger)
+	},
+}
+
+var expandPinCmd = &cobra.Command{
+	Use:    "expandPin",
+	Hidden: true,
+	Short:  "[Internal-only] Print the command pinned under the given trigger, or exit with an error if there is none",
+	Args:   cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		command, ok := hctx.GetConf(hctx.MakeContext()).PinnedEntries[args[0]]
+		if !ok {
+			os.Exit(1)
+		}
+		fmt.Print(command)
+	},
+}
+
+func validatePinTrigger(trigger string) error {
+	if !strings.HasPrefix(trigger, PIN_TRIGGER_PREFIX) || len(trigger) == len(PIN_TRIGGER_PREFIX) {
+		return fmt.Errorf("pin triggers must start with %#v (e.g. %#v), got %#v", PIN_TRIGGER_PREFIX, PIN_TRIGGER_PREFIX+"deploy", trigger)
+	}
+	if strings.ContainsAny(trigger, " \t\n") {
+		return fmt.Errorf("pin triggers can't contain whitespace, got %#v", trigger)
+	}
+	return nil
+}
+
+// Returns the most recent command matching the query, skipping `hishtory pin` itself so that an empty query pins the
+// command run before it
+func findCommandToPin(ctx context.Context, query string) (string, error) {
+	entries, err := lib.Search(ctx, hctx.GetDb(ctx), query, 10)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(strings.TrimSpace(entry.Command), "hishtory pin ") {
+			return entry.Command, nil
+		}
+	}
+	return "", fmt.Errorf("no command found matching the query %#v", query)
+}
+
+func init() {
+	rootCmd.AddCommand(pinCmd)
+	rootCmd.AddCommand(expandPinCmd)
+}
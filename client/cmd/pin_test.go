@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePinTrigger(t *testing.T) {
+	require.NoError(t, validatePinTrigger(",deploy"))
+	require.NoError(t, validatePinTrigger(",k"))
+	require.Error(t, validatePinTrigger("deploy"))
+	require.Error(t, validatePinTrigger(","))
+	require.Error(t, validatePinTrigger(",de ploy"))
+}
+
+func TestFindCommandToPin(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, setup("", true))
+	ctx := hctx.MakeContext()
+	db := hctx.GetDb(ctx)
+	require.NoError(t, db.Create(testutils.MakeFakeHistoryEntry("kubectl apply -f deploy.yaml")).Error)
+	require.NoError(t, db.Create(testutils.MakeFakeHistoryEntry("ls /tmp")).Error)
+	require.NoError(t, db.Create(testutils.MakeFakeHistoryEntry("hishtory pin ,ls")).Error)
+
+	// Without a query, the command before `hishtory pin` is pinned
+	command, err := findCommandToPin(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, "ls /tmp", command)
+
+	// And otherwise the most recent matching command is pinned
+	command, err = findCommandToPin(ctx, "kubectl")
+	require.NoError(t, err)
+	require.Equal(t, "kubectl apply -f deploy.yaml", command)
+	_, err = findCommandToPin(ctx, "terraform")
+	require.Error(t, err)
+}
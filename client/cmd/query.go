@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/ddworken/hishtory/client/data"
@@ -25,6 +29,9 @@ var EXAMPLE_QUERIES string = `Example queries:
 'hishtory SUBCOMMAND curl host:x1'		# Find shell commands containing 'curl' run on 'x1'
 'hishtory SUBCOMMAND exit_code:1'		# Find shell commands that exited with status code 1
 'hishtory SUBCOMMAND before:2022-02-01'	# Find shell commands run before 2022-02-01
+'hishtory SUBCOMMAND session:current'	# Find shell commands run in the current terminal session
+'hishtory SUBCOMMAND tag:deploy'		# Find shell commands tagged with 'deploy'
+'hishtory SUBCOMMAND --json curl'		# Find shell commands containing 'curl' and output them as JSON (one object per line)
 `
 
 var GROUP_ID_QUERYING string = "group_id:querying"
@@ -38,7 +45,8 @@ var queryCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
 		lib.CheckFatalError(lib.ProcessDeletionRequests(ctx))
-		query(ctx, strings.Join(args, " "))
+		args, outputJson := extractJsonFlag(args)
+		query(ctx, strings.Join(args, " "), outputJson)
 	},
 }
 
@@ -67,7 +75,14 @@ var exportCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
 		lib.CheckFatalError(lib.ProcessDeletionRequests(ctx))
-		export(ctx, strings.Join(args, " "))
+		args, outputJson := extractJsonFlag(args)
+		args, format, err := extractFormatFlag(args)
+		lib.CheckFatalError(err)
+		if format == "jsonl" {
+			lib.CheckFatalError(exportJsonl(ctx, strings.Join(args, " ")))
+			return
+		}
+		export(ctx, strings.Join(args, " "), outputJson)
 	},
 }
 
@@ -103,7 +118,8 @@ var updateLocalDbFromRemoteCmd = &cobra.Command{
 		// are always reasonably complete and fast (even when offline).
 		ctx := hctx.MakeContext()
 		config := hctx.GetConf(ctx)
-		if config.IsOffline {
+		if config.IsOffline || lib.IsDaemonRunning(ctx) {
+			// Nothing to do, since the daemon already keeps the local DB up to date
 			return
 		}
 		// Do it a random percent of the time, which should be approximately often enough.
@@ -120,16 +136,87 @@ var updateLocalDbFromRemoteCmd = &cobra.Command{
 			} else if err != nil {
 				hctx.GetLogger().Infof("updateLocalDbFromRemote: Failed to ProcessDeletionRequests: %v", err)
 			}
+			if slices.ContainsFunc(config.DisplayedColumns, func(c string) bool { return strings.EqualFold(c, "device") }) {
+				// Refresh the cached device names so that renames on other devices are reflected in the Device column
+				_, err = lib.GetDevices(ctx)
+				if config.BetaMode {
+					lib.CheckFatalError(err)
+				} else if err != nil {
+					hctx.GetLogger().Infof("updateLocalDbFromRemote: Failed to GetDevices: %v", err)
+				}
+			}
 		}
 	},
 }
 
-func export(ctx context.Context, query string) {
+// Since query commands disable flag parsing so that queries can contain arbitrary text, the --json flag is extracted
+// manually. Returns the remaining args and whether --json was specified.
+func extractJsonFlag(args []string) ([]string, bool) {
+	remainingArgs := make([]string, 0, len(args))
+	outputJson := false
+	for _, arg := range args {
+		if arg == "--json" {
+			outputJson = true
+			continue
+		}
+		remainingArgs = append(remainingArgs, arg)
+	}
+	return remainingArgs, outputJson
+}
+
+// Extracts the --format flag (and the optional `-` argument for stdout) for `hishtory export`, since flag parsing is
+// disabled so that queries can contain arbitrary arguments
+func extractFormatFlag(args []string) ([]string, string, error) {
+	remainingArgs := make([]string, 0, len(args))
+	format := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--format":
+			if i+1 >= len(args) {
+				return nil, "", fmt.Errorf("--format requires a value")
+			}
+			format = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case arg == "-":
+			// Exports are always written to stdout
+		default:
+			remainingArgs = append(remainingArgs, arg)
+		}
+	}
+	if format != "" && format != "jsonl" {
+		return nil, "", fmt.Errorf("unsupported export format %#v, must be jsonl", format)
+	}
+	return remainingArgs, format, nil
+}
+
+func printJsonEntry(entry *data.HistoryEntry) error {
+	jsonEntry, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history entry to JSON: %w", err)
+	}
+	fmt.Println(string(jsonEntry))
+	return nil
+}
+
+func printOfflineWarning(outputJson bool) {
+	warning := "Warning: hishtory is offline so this may be missing recent results from your other machines!"
+	if outputJson {
+		// Print to stderr so that the JSON output on stdout is still parseable
+		fmt.Fprintln(os.Stderr, warning)
+	} else {
+		fmt.Println(warning)
+	}
+}
+
+func export(ctx context.Context, query string, outputJson bool) {
 	db := hctx.GetDb(ctx)
 	err := lib.RetrieveAdditionalEntriesFromRemote(ctx, "export")
 	if err != nil {
 		if lib.IsOfflineError(ctx, err) {
-			fmt.Println("Warning: hishtory is offline so this may be missing recent results from your other machines!")
+			printOfflineWarning(outputJson)
 		} else {
 			lib.CheckFatalError(err)
 		}
@@ -137,25 +224,95 @@ func export(ctx context.Context, query string) {
 	data, err := lib.Search(ctx, db, query, 0)
 	lib.CheckFatalError(err)
 	for i := len(data) - 1; i >= 0; i-- {
-		fmt.Println(data[i].Command)
+		if outputJson {
+			lib.CheckFatalError(printJsonEntry(data[i]))
+		} else {
+			fmt.Println(data[i].Command)
+		}
+	}
+}
+
+// Streams the matching entries to stdout as one JSON object per line, oldest first, without loading the entire
+// history into memory
+func exportJsonl(ctx context.Context, query string) error {
+	err := lib.RetrieveAdditionalEntriesFromRemote(ctx, "export")
+	if err != nil {
+		if lib.IsOfflineError(ctx, err) {
+			printOfflineWarning(true)
+		} else {
+			return err
+		}
+	}
+	return writeJsonlHistory(ctx, os.Stdout, query)
+}
+
+func writeJsonlHistory(ctx context.Context, out io.Writer, query string) error {
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	err := lib.StreamSearch(ctx, hctx.GetDb(ctx), query, lib.SearchOrder{Column: lib.SORT_BY_TIME, Ascending: true}, func(entry *data.HistoryEntry) error {
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("failed to marshal history entry to JSON: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+	return w.Flush()
 }
 
-func query(ctx context.Context, query string) {
+func query(ctx context.Context, query string, outputJson bool) {
 	db := hctx.GetDb(ctx)
 	err := lib.RetrieveAdditionalEntriesFromRemote(ctx, "query")
 	if err != nil {
 		if lib.IsOfflineError(ctx, err) {
-			fmt.Println("Warning: hishtory is offline so this may be missing recent results from your other machines!")
+			printOfflineWarning(outputJson)
 		} else {
 			lib.CheckFatalError(err)
 		}
 	}
-	lib.CheckFatalError(displayBannerIfSet(ctx))
+	if !outputJson {
+		lib.CheckFatalError(displayBannerIfSet(ctx))
+	}
 	numResults := 25
 	data, err := lib.Search(ctx, db, query, numResults*5)
 	lib.CheckFatalError(err)
-	lib.CheckFatalError(DisplayResults(ctx, data, numResults))
+	if outputJson {
+		lib.CheckFatalError(DisplayJsonResults(ctx, data, numResults))
+	} else {
+		lib.CheckFatalError(DisplayResults(ctx, data, numResults))
+	}
+}
+
+// Returns the entries that should be displayed, filtering out duplicates if configured
+func filterResultsForDisplay(ctx context.Context, results []*data.HistoryEntry, numResults int) []*data.HistoryEntry {
+	config := hctx.GetConf(ctx)
+	var seenCommands = make(map[string]bool)
+	filteredResults := make([]*data.HistoryEntry, 0)
+	for _, entry := range results {
+		if config.FilterDuplicateCommands && entry != nil {
+			cmd := lib.DuplicateCommandKey(ctx, entry.Command)
+			if seenCommands[cmd] {
+				continue
+			}
+			seenCommands[cmd] = true
+		}
+		filteredResults = append(filteredResults, entry)
+		if len(filteredResults) >= numResults {
+			break
+		}
+	}
+	return filteredResults
+}
+
+func DisplayJsonResults(ctx context.Context, results []*data.HistoryEntry, numResults int) error {
+	for _, entry := range filterResultsForDisplay(ctx, results, numResults) {
+		err := printJsonEntry(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func DisplayResults(ctx context.Context, results []*data.HistoryEntry, numResults int) error {
@@ -169,28 +326,12 @@ func DisplayResults(ctx context.Context, results []*data.HistoryEntry, numResult
 	tbl := table.New(columns...)
 	tbl.WithHeaderFormatter(headerFmt)
 
-	numRows := 0
-
-	var seenCommands = make(map[string]bool)
-
-	for _, entry := range results {
-		if config.FilterDuplicateCommands && entry != nil {
-			cmd := strings.TrimSpace(entry.Command)
-			if seenCommands[cmd] {
-				continue
-			}
-			seenCommands[cmd] = true
-		}
-
+	for _, entry := range filterResultsForDisplay(ctx, results, numResults) {
 		row, err := lib.BuildTableRow(ctx, config.DisplayedColumns, *entry, func(s string) string { return s })
 		if err != nil {
 			return err
 		}
 		tbl.AddRow(stringArrayToAnyArray(row)...)
-		numRows += 1
-		if numRows >= numResults {
-			break
-		}
 	}
 
 	tbl.Print()
@@ -206,15 +347,19 @@ func stringArrayToAnyArray(arr []string) []any {
 }
 
 func displayBannerIfSet(ctx context.Context) error {
-	respBody, err := lib.GetBanner(ctx)
+	remoteConfig, err := lib.FetchRemoteConfig(ctx)
 	if lib.IsOfflineError(ctx, err) {
 		return nil
 	}
 	if err != nil {
 		return err
 	}
-	if len(respBody) > 0 {
-		fmt.Println(string(respBody))
+	if !remoteConfig.IsClientVersionSupported("v0." + lib.Version) {
+		fmt.Printf("Warning: hishtory v0.%s is no longer supported by the hishtory backend (the minimum supported version is %s), so your history isn't syncing. Run `hishtory update` to upgrade.\n", lib.Version, remoteConfig.MinClientVersion)
+		return nil
+	}
+	if len(remoteConfig.Banner) > 0 {
+		fmt.Println(remoteConfig.Banner)
 	}
 	return nil
 }
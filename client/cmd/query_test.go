@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractJsonFlag(t *testing.T) {
+	args, outputJson := extractJsonFlag([]string{"ls", "cwd:/tmp/"})
+	require.Equal(t, []string{"ls", "cwd:/tmp/"}, args)
+	require.False(t, outputJson)
+
+	args, outputJson = extractJsonFlag([]string{"--json", "ls", "cwd:/tmp/"})
+	require.Equal(t, []string{"ls", "cwd:/tmp/"}, args)
+	require.True(t, outputJson)
+
+	args, outputJson = extractJsonFlag([]string{"ls", "--json"})
+	require.Equal(t, []string{"ls"}, args)
+	require.True(t, outputJson)
+
+	args, outputJson = extractJsonFlag([]string{"--json=foo"})
+	require.Equal(t, []string{"--json=foo"}, args)
+	require.False(t, outputJson)
+}
+
+func TestExtractFormatFlag(t *testing.T) {
+	args, format, err := extractFormatFlag([]string{"ls", "cwd:/tmp/"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ls", "cwd:/tmp/"}, args)
+	require.Equal(t, "", format)
+
+	args, format, err = extractFormatFlag([]string{"--format", "jsonl", "-"})
+	require.NoError(t, err)
+	require.Equal(t, []string{}, args)
+	require.Equal(t, "jsonl", format)
+
+	args, format, err = extractFormatFlag([]string{"ls", "--format=jsonl"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ls"}, args)
+	require.Equal(t, "jsonl", format)
+
+	_, _, err = extractFormatFlag([]string{"--format", "csv"})
+	require.Error(t, err)
+	_, _, err = extractFormatFlag([]string{"--format"})
+	require.Error(t, err)
+}
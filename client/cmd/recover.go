@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var recoverCmd = &cobra.Command{
+	Use:     "recover",
+	Short:   "Walk through recovering your history if you lost (or leaked) your secret key",
+	Long:    "Your secret key is only stored on your devices, so if you lose it you can only recover your history from a device that is still running hishtory. Run this on such a device to back up its history, and optionally move it to a new secret and delete the data stored under the old one.",
+	GroupID: GROUP_ID_CONFIG,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		reader := bufio.NewReader(os.Stdin)
+		config := hctx.GetConf(ctx)
+		fmt.Println("This device is still set up with your secret key: " + config.UserSecret)
+		fmt.Println("If you only need to set up another device, you can run `hishtory init " + config.UserSecret + "` there.")
+		fmt.Println()
+
+		// Step 1: Back up the plaintext history stored on this device
+		backupPath, err := backupLocalHistory(ctx)
+		lib.CheckFatalError(err)
+		fmt.Println("Backed up the history stored on this device to " + backupPath)
+		fmt.Println()
+
+		// Step 2: Optionally move to a new secret
+		fmt.Printf("Would you like to generate a new secret key (e.g. because your old one was lost or leaked)? This will re-upload your history under the new secret and delete the data stored under the old one. [y/N] ")
+		if !readConfirmation(reader) {
+			fmt.Println("Keeping your existing secret key")
+			return
+		}
+		newSecret, err := rotateSecret(ctx)
+		if err != nil {
+			// This device may no longer be able to reach the old account (e.g. if it was revoked), so fall back to
+			// starting from scratch with a new secret and re-importing the backup
+			fmt.Printf("Failed to move your history to a new secret: %v\n", err)
+			fmt.Printf("Would you like to instead re-initialize this device with a new secret and re-import the backup? [y/N] ")
+			if !readConfirmation(reader) {
+				fmt.Println("Aborting recovery, your backup is still available at " + backupPath)
+				return
+			}
+			newSecret, err = reinitFromBackup(config.IsOffline, backupPath)
+			lib.CheckFatalError(err)
+		}
+		fmt.Println()
+		fmt.Println("Your new secret key is: " + newSecret)
+		fmt.Println("Run `hishtory init " + newSecret + "` on your other devices to continue syncing with them")
+	},
+}
+
+func readConfirmation(reader *bufio.Reader) bool {
+	resp, err := reader.ReadString('\n')
+	lib.CheckFatalError(err)
+	return strings.TrimSpace(resp) == "y"
+}
+
+func backupLocalHistory(ctx context.Context) (string, error) {
+	backupPath := path.Join(hctx.GetHome(ctx), data.GetHishtoryPath(), "recovery-backup-"+time.Now().Format("2006-01-02T15-04-05")+".jsonl")
+	f, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer f.Close()
+	err = writeJsonlHistory(ctx, f, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to back up history entries: %w", err)
+	}
+	return backupPath, f.Close()
+}
+
+func reinitFromBackup(isOffline bool, backupPath string) (string, error) {
+	err := setup("", isOffline)
+	if err != nil {
+		return "", fmt.Errorf("failed to re-initialize hishtory: %w", err)
+	}
+	f, err := os.Open(backupPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer f.Close()
+	ctx := hctx.MakeContext()
+	numImported, err := lib.ImportJsonlHistory(ctx, f)
+	if err != nil {
+		return "", fmt.Errorf("failed to re-import backup from %s: %w", backupPath, err)
+	}
+	fmt.Printf("Re-imported %d history entries\n", numImported)
+	return hctx.GetConf(ctx).UserSecret, nil
+}
+
+func init() {
+	rootCmd.AddCommand(recoverCmd)
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -12,20 +13,26 @@ import (
 	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
 	"github.com/ddworken/hishtory/shared"
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 )
 
 var GROUP_ID_MANAGEMENT string = "group_id_management"
 
 var redactCmd = &cobra.Command{
-	Use:                "redact",
-	Aliases:            []string{"delete"},
-	Short:              "Query for matching commands and remove them from your shell history",
-	Long:               "This removes history entries on the current machine and on all remote machines. Supports the same query format as 'hishtory query'.",
+	Use:   "redact",
+	Short: "Query for matching commands and remove them from your shell history",
+	Long: "This removes history entries on the current machine and on all remote machines. Supports the same query format as 'hishtory query'.\n\n" +
+		"Pass --regex to instead match commands against a regex, and --rewrite (along with --regex) to replace the matching text with " + lib.REDACTED_TEXT + " rather than deleting the entire entry. " +
+		"For example, `hishtory redact --regex --rewrite 'AKIA[0-9A-Z]{16}'` purges an accidentally pasted AWS key from every device.",
 	GroupID:            GROUP_ID_MANAGEMENT,
 	DisableFlagParsing: true,
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
+		args, useRegex, rewrite := extractRedactFlags(args)
+		if rewrite && !useRegex {
+			lib.CheckFatalError(fmt.Errorf("--rewrite is only supported along with --regex"))
+		}
 		skipOnlineRedaction := false
 		if !lib.CanReachHishtoryServer(ctx) {
 			fmt.Printf("Cannot reach hishtory backend (is this device offline?) so redaction will only apply to this device and not other synced devices. Would you like to continue with a local-only redaction anyways? [y/N] ")
@@ -41,10 +48,60 @@ var redactCmd = &cobra.Command{
 		lib.CheckFatalError(lib.RetrieveAdditionalEntriesFromRemote(ctx, "redact"))
 		lib.CheckFatalError(lib.ProcessDeletionRequests(ctx))
 		query := strings.Join(args, " ")
-		lib.CheckFatalError(redact(ctx, query, os.Getenv("HISHTORY_REDACT_FORCE") != "", skipOnlineRedaction))
+		skipUserConfirmation := os.Getenv("HISHTORY_REDACT_FORCE") != ""
+		if useRegex {
+			lib.CheckFatalError(redactRegex(ctx, query, rewrite, skipUserConfirmation, skipOnlineRedaction))
+		} else {
+			lib.CheckFatalError(redact(ctx, query, skipUserConfirmation, skipOnlineRedaction))
+		}
 	},
 }
 
+func extractRedactFlags(args []string) ([]string, bool, bool) {
+	remainingArgs := make([]string, 0, len(args))
+	useRegex := false
+	rewrite := false
+	for _, arg := range args {
+		switch arg {
+		case "--regex":
+			useRegex = true
+		case "--rewrite":
+			rewrite = true
+		default:
+			remainingArgs = append(remainingArgs, arg)
+		}
+	}
+	return remainingArgs, useRegex, rewrite
+}
+
+// Displays a preview of the first few of the given entries
+func previewEntries(ctx context.Context, historyEntries []*data.HistoryEntry) error {
+	if len(historyEntries) == 0 {
+		return nil
+	}
+	numPreviewed := min(len(historyEntries), 10)
+	fmt.Printf("Preview of %d of the matching entries:\n", numPreviewed)
+	return DisplayResults(ctx, historyEntries, numPreviewed)
+}
+
+// Displays a preview of the entries that are about to be redacted and asks the user to confirm
+func confirmRedaction(ctx context.Context, historyEntries []*data.HistoryEntry, action string) (bool, error) {
+	if err := previewEntries(ctx, historyEntries); err != nil {
+		return false, err
+	}
+	fmt.Printf("This will permanently %s %d entries, are you sure? [y/N] ", action, len(historyEntries))
+	reader := bufio.NewReader(os.Stdin)
+	resp, err := reader.ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("failed to read response: %w", err)
+	}
+	if strings.TrimSpace(resp) != "y" {
+		fmt.Printf("Aborting redact per user response of %#v\n", strings.TrimSpace(resp))
+		return false, nil
+	}
+	return true, nil
+}
+
 func redact(ctx context.Context, query string, skipUserConfirmation, skipOnlineRedaction bool) error {
 	tx, err := lib.MakeWhereQueryFromSearch(ctx, hctx.GetDb(ctx), query)
 	if err != nil {
@@ -58,15 +115,9 @@ func redact(ctx context.Context, query string, skipUserConfirmation, skipOnlineR
 	if skipUserConfirmation {
 		fmt.Printf("Permanently deleting %d entries\n", len(historyEntries))
 	} else {
-		fmt.Printf("This will permanently delete %d entries, are you sure? [y/N] ", len(historyEntries))
-		reader := bufio.NewReader(os.Stdin)
-		resp, err := reader.ReadString('\n')
-		if err != nil {
-			return fmt.Errorf("failed to read response: %w", err)
-		}
-		if strings.TrimSpace(resp) != "y" {
-			fmt.Printf("Aborting redact per user response of %#v\n", strings.TrimSpace(resp))
-			return nil
+		confirmed, err := confirmRedaction(ctx, historyEntries, "delete")
+		if err != nil || !confirmed {
+			return err
 		}
 	}
 	tx, err = lib.MakeWhereQueryFromSearch(ctx, hctx.GetDb(ctx), query)
@@ -87,22 +138,104 @@ func redact(ctx context.Context, query string, skipUserConfirmation, skipOnlineR
 	return nil
 }
 
+// Redacts all entries whose command matches the given regex. If rewrite is set, the matching text is replaced with
+// lib.REDACTED_TEXT rather than deleting the entire entry.
+func redactRegex(ctx context.Context, pattern string, rewrite, skipUserConfirmation, skipOnlineRedaction bool) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("failed to compile regex %#v: %w", pattern, err)
+	}
+	db := hctx.GetDb(ctx)
+	historyEntries := make([]*data.HistoryEntry, 0)
+	err = lib.StreamSearch(ctx, db, "", lib.DefaultSearchOrder, func(entry *data.HistoryEntry) error {
+		if re.MatchString(entry.Command) {
+			historyEntries = append(historyEntries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	action, progressiveAction := "delete", "deleting"
+	if rewrite {
+		action, progressiveAction = "rewrite", "rewriting"
+	}
+	if skipUserConfirmation {
+		fmt.Printf("Permanently %s %d entries\n", progressiveAction, len(historyEntries))
+	} else {
+		confirmed, err := confirmRedaction(ctx, historyEntries, action)
+		if err != nil || !confirmed {
+			return err
+		}
+	}
+
+	// Rewritten entries are stored as new entries (with new entry IDs) so that other devices receive them, while the
+	// originals are deleted everywhere
+	rewrittenEntries := make([]*data.HistoryEntry, 0)
+	for _, entry := range historyEntries {
+		err := db.Where("entry_id = ?", entry.EntryId).Delete(&data.HistoryEntry{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to delete entry: %w", err)
+		}
+		if rewrite {
+			rewrittenEntry := *entry
+			rewrittenEntry.Command = re.ReplaceAllLiteralString(entry.Command, lib.REDACTED_TEXT)
+			rewrittenEntry.EntryId = uuid.Must(uuid.NewRandom()).String()
+			// Deletion requests match on either the entry ID or the end time, so shift the end time so that the
+			// rewritten entry isn't deleted by the deletion request for the original entry
+			rewrittenEntry.EndTime = entry.EndTime.Add(time.Millisecond)
+			if err := lib.ReliableDbCreate(db, rewrittenEntry); err != nil {
+				return fmt.Errorf("failed to save rewritten entry: %w", err)
+			}
+			rewrittenEntries = append(rewrittenEntries, &rewrittenEntry)
+		}
+	}
+	err = deleteOnRemoteInstances(ctx, historyEntries)
+	if err != nil && !skipOnlineRedaction {
+		return err
+	}
+	config := hctx.GetConf(ctx)
+	if len(rewrittenEntries) > 0 && !config.IsOffline && !skipOnlineRedaction && config.SyncMode.CanUpload() {
+		jsonValue, err := lib.EncryptAndMarshal(config, rewrittenEntries)
+		if err != nil {
+			return err
+		}
+		_, err = lib.ApiPost(ctx, "/api/v1/submit?source_device_id="+config.DeviceId, "application/json", jsonValue)
+		if err != nil {
+			return fmt.Errorf("failed to upload rewritten entries: %w", err)
+		}
+	}
+	return nil
+}
+
+// The maximum number of entries deleted by a single deletion request, so that deleting a huge number of entries doesn't
+// produce a single request that is too large for the backend to accept
+const DELETION_REQUEST_BATCH_SIZE = 1000
+
 func deleteOnRemoteInstances(ctx context.Context, historyEntries []*data.HistoryEntry) error {
 	config := hctx.GetConf(ctx)
 	if config.IsOffline {
 		return nil
 	}
+	for start := 0; start < len(historyEntries); start += DELETION_REQUEST_BATCH_SIZE {
+		err := lib.SendDeletionRequest(ctx, makeDeletionRequest(config, historyEntries[start:min(start+DELETION_REQUEST_BATCH_SIZE, len(historyEntries))]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func makeDeletionRequest(config *hctx.ClientConfig, historyEntries []*data.HistoryEntry) shared.DeletionRequest {
 	var deletionRequest shared.DeletionRequest
 	deletionRequest.SendTime = time.Now()
 	deletionRequest.UserId = data.UserId(config.UserSecret)
-
 	for _, entry := range historyEntries {
 		deletionRequest.Messages.Ids = append(deletionRequest.Messages.Ids,
 			shared.MessageIdentifier{DeviceId: entry.DeviceId, EndTime: entry.EndTime, EntryId: entry.EntryId},
 		)
 	}
-	return lib.SendDeletionRequest(ctx, deletionRequest)
+	return deletionRequest
 }
 
 func init() {
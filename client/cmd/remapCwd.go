@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/shared"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+var remapFrom *string
+var remapTo *string
+
+var remapCwdCmd = &cobra.Command{
+	Use:     "remap-cwd",
+	Short:   "Rewrite the CWD of history entries after moving or renaming a directory",
+	Long:    "Updates the CWD of all history entries that were run in --from (or a subdirectory of it) to instead be in --to. This applies to the current machine and to all remote machines.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if *remapFrom == "" || *remapTo == "" {
+			lib.CheckFatalError(fmt.Errorf("both --from and --to must be specified"))
+		}
+		ctx := hctx.MakeContext()
+		lib.CheckFatalError(lib.RetrieveAdditionalEntriesFromRemote(ctx, "remap-cwd"))
+		numRemapped, err := remapCwd(ctx, *remapFrom, *remapTo)
+		lib.CheckFatalError(err)
+		fmt.Printf("Updated the CWD of %d history entries\n", numRemapped)
+	},
+}
+
+// Normalize a user-provided directory into the format used for HistoryEntry.CurrentWorkingDirectory
+func normalizeCwdArg(ctx context.Context, dir string) string {
+	dir = filepath.Clean(dir)
+	homedir := hctx.GetHome(ctx)
+	if dir == homedir || dir == "~" {
+		return "~"
+	}
+	if strings.HasPrefix(dir, homedir+"/") {
+		return strings.Replace(dir, homedir, "~", 1)
+	}
+	return dir
+}
+
+// Returns the remapped CWD and whether cwd is inside of the from directory
+func remapSingleCwd(cwd, from, to string) (string, bool) {
+	trimmedCwd := strings.TrimSuffix(cwd, "/")
+	if trimmedCwd == "" {
+		// The root directory
+		trimmedCwd = "/"
+	}
+	if trimmedCwd == from {
+		if strings.HasSuffix(cwd, "/") && !strings.HasSuffix(to, "/") {
+			return to + "/", true
+		}
+		return to, true
+	}
+	if strings.HasPrefix(trimmedCwd, strings.TrimSuffix(from, "/")+"/") {
+		return strings.TrimSuffix(to, "/") + strings.TrimPrefix(cwd, strings.TrimSuffix(from, "/")), true
+	}
+	return cwd, false
+}
+
+func remapCwd(ctx context.Context, from, to string) (int, error) {
+	from = normalizeCwdArg(ctx, from)
+	to = normalizeCwdArg(ctx, to)
+	db := hctx.GetDb(ctx)
+	var historyEntries []*data.HistoryEntry
+	res := db.Where("current_working_directory = ? OR current_working_directory = ? OR current_working_directory LIKE ?", from, from+"/", strings.TrimSuffix(from, "/")+"/%").Find(&historyEntries)
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to query for entries to remap: %w", res.Error)
+	}
+
+	// Build the updated entries. Note that these are given new entry IDs so that they can be synced to other devices as
+	// new entries, while the original entries are deleted via a deletion request.
+	var oldEntries []*data.HistoryEntry
+	var newEntries []*data.HistoryEntry
+	for _, entry := range historyEntries {
+		newCwd, matches := remapSingleCwd(entry.CurrentWorkingDirectory, from, to)
+		if !matches {
+			continue
+		}
+		newEntry := *entry
+		newEntry.CurrentWorkingDirectory = newCwd
+		newEntry.EntryId = uuid.Must(uuid.NewRandom()).String()
+		oldEntries = append(oldEntries, entry)
+		newEntries = append(newEntries, &newEntry)
+	}
+	if len(newEntries) == 0 {
+		return 0, nil
+	}
+
+	// Update the entries on remote instances first, so that if we fail to reach the backend nothing is changed
+	err := remapOnRemoteInstances(ctx, oldEntries, newEntries)
+	if err != nil {
+		return 0, err
+	}
+
+	// And then update them locally
+	for i := range newEntries {
+		oldEntry := oldEntries[i]
+		err := lib.RetryingDbFunction(func() error {
+			return db.Where("device_id = ? AND entry_id = ? AND end_time = ?", oldEntry.DeviceId, oldEntry.EntryId, oldEntry.EndTime).Delete(&data.HistoryEntry{}).Error
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete entry with the old CWD: %w", err)
+		}
+		err = lib.ReliableDbCreate(db, *newEntries[i])
+		if err != nil {
+			return 0, fmt.Errorf("failed to persist entry with the new CWD: %w", err)
+		}
+	}
+	return len(newEntries), nil
+}
+
+func remapOnRemoteInstances(ctx context.Context, oldEntries, newEntries []*data.HistoryEntry) error {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline || !config.SyncMode.CanUpload() {
+		return nil
+	}
+
+	// Upload the new entries
+	err := shared.ForEach(shared.Chunks(newEntries, 500), 10, func(chunk []*data.HistoryEntry) error {
+		jsonValue, err := lib.EncryptAndMarshal(config, chunk)
+		if err != nil {
+			return err
+		}
+		_, err = lib.ApiPost(ctx, "/api/v1/submit?source_device_id="+config.DeviceId, "application/json", jsonValue)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload remapped entries: %w", err)
+	}
+
+	// And delete the old ones. Note that we purposefully identify these entries only via their entry ID since the new
+	// entries share the same EndTime.
+	var deletionRequest shared.DeletionRequest
+	deletionRequest.SendTime = time.Now()
+	deletionRequest.UserId = data.UserId(config.UserSecret)
+	for _, entry := range oldEntries {
+		if entry.EntryId == "" {
+			// Entries recorded by very old versions of hishtory don't have an entry ID, so we can't safely delete
+			// these without also deleting the remapped entry. So these are only remapped locally.
+			hctx.GetLogger().Infof("Skipping remote remapping of entry with no entry ID: %#v", entry)
+			continue
+		}
+		deletionRequest.Messages.Ids = append(deletionRequest.Messages.Ids,
+			shared.MessageIdentifier{DeviceId: entry.DeviceId, EntryId: entry.EntryId},
+		)
+	}
+	return lib.SendDeletionRequest(ctx, deletionRequest)
+}
+
+func init() {
+	rootCmd.AddCommand(remapCwdCmd)
+	remapFrom = remapCwdCmd.Flags().String("from", "", "The directory that was moved or renamed")
+	remapTo = remapCwdCmd.Flags().String("to", "", "The new location of the directory")
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemapSingleCwd(t *testing.T) {
+	testcases := []struct {
+		cwd, from, to, expectedCwd string
+		expectedMatch              bool
+	}{
+		{"~/code/foo", "~/code/foo", "~/projects/foo", "~/projects/foo", true},
+		{"~/code/foo/", "~/code/foo", "~/projects/foo", "~/projects/foo/", true},
+		{"~/code/foo/bar", "~/code/foo", "~/projects/foo", "~/projects/foo/bar", true},
+		{"~/code/foo/bar/baz", "~/code/foo", "/tmp/foo", "/tmp/foo/bar/baz", true},
+		{"~/code/foobar", "~/code/foo", "~/projects/foo", "~/code/foobar", false},
+		{"~/code", "~/code/foo", "~/projects/foo", "~/code", false},
+		{"/var/log", "/", "/mnt", "/mnt/var/log", true},
+	}
+	for _, tc := range testcases {
+		actualCwd, actualMatch := remapSingleCwd(tc.cwd, tc.from, tc.to)
+		require.Equal(t, tc.expectedMatch, actualMatch, tc)
+		require.Equal(t, tc.expectedCwd, actualCwd, tc)
+	}
+}
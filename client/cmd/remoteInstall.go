@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var remoteInstallCmd = &cobra.Command{
+	Use:     "remote-install DESTINATION",
+	Short:   "Install hishtory on a remote host over SSH, configured to sync with this device",
+	Long:    "Installs hishtory on the given SSH destination (e.g. user@host, or a host alias from ~/.ssh/config) and initializes it with the same secret key as this device so that your history is synced between them.",
+	GroupID: GROUP_ID_CONFIG,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		lib.CheckFatalError(lib.RemoteInstall(ctx, args[0]))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(remoteInstallCmd)
+}
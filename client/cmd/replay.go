@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replayScript *bool
+	replayLimit  *int
+)
+
+var replayCmd = &cobra.Command{
+	Use:     "replay [query]",
+	Short:   "Re-run the commands matching the query one at a time, or print them as a shell script",
+	Long:    "Replays the commands matching the query in the order that they were originally run, asking for confirmation before running each of them in the directory it was originally run in. To replay a past session, filter to it via e.g. `hishtory replay session:<session ID> after:2024-01-01`. With --script, the commands are printed as a shell script instead of being run.",
+	GroupID: GROUP_ID_QUERYING,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		entries, err := lib.GetEntriesInRunOrder(ctx, strings.Join(args, " "), *replayLimit)
+		lib.CheckFatalError(err)
+		if len(entries) == 0 {
+			lib.CheckFatalError(fmt.Errorf("no commands found matching the query %#v", strings.Join(args, " ")))
+		}
+		if *replayScript {
+			fmt.Print(lib.BuildShellScript(entries, false))
+			return
+		}
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "bash"
+		}
+		in := bufio.NewReader(os.Stdin)
+		homedir := hctx.GetHome(ctx)
+		for i, entry := range entries {
+			fmt.Printf("[%d/%d] %s $ %s\n", i+1, len(entries), entry.CurrentWorkingDirectory, entry.Command)
+			fmt.Print("Run this command? [Y/n/q] ")
+			answer, err := in.ReadString('\n')
+			if err != nil && answer == "" {
+				fmt.Println()
+				return
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "", "y", "yes":
+			case "q", "quit":
+				return
+			default:
+				continue
+			}
+			c := exec.Command(shell, "-c", entry.Command)
+			c.Dir = lib.ResolveEntryCwd(entry, homedir)
+			if _, err := os.Stat(c.Dir); err != nil {
+				fmt.Printf("Warning: %s doesn't exist on this device, running in the current directory instead\n", c.Dir)
+				c.Dir = ""
+			}
+			c.Stdin = os.Stdin
+			c.Stdout = os.Stdout
+			c.Stderr = os.Stderr
+			err = c.Run()
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				fmt.Printf("The command failed with exit code %d\n", exitErr.ExitCode())
+			} else {
+				lib.CheckFatalError(err)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	replayScript = replayCmd.Flags().Bool("script", false, "Print the commands as a shell script rather than running them")
+	replayLimit = replayCmd.Flags().Int("limit", 100, "The maximum number of commands to replay, counting back from the most recent matching command")
+}
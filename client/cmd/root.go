@@ -4,8 +4,10 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
 	"github.com/spf13/cobra"
 )
@@ -14,8 +16,25 @@ import (
 var rootCmd = &cobra.Command{
 	Use:   "hishtory",
 	Short: "hiSHtory: Better shell history",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if serverEnvironment != "" {
+			// Selecting a server environment is implemented via the env var so that it also applies to any hishtory
+			// subprocesses
+			lib.CheckFatalError(os.Setenv("HISHTORY_SERVER_ENV", serverEnvironment))
+		}
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		// Commands that exit early skip this, but the snapshot is then updated after the next command. Newly saved
+		// history entries are always written through to the snapshot so that they're never lost on a reboot.
+		writeThrough := cmd == saveHistoryEntryCmd || cmd == presaveHistoryEntryCmd
+		if err := hctx.UpdateEncryptedDbSnapshot(writeThrough); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update the encrypted local DB: %v\n", err)
+		}
+	},
 }
 
+var serverEnvironment string
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -26,6 +45,7 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&serverEnvironment, "server", "", "The name of the server environment to use (see `hishtory config-add server-environments`), overrides HISHTORY_SERVER_ENV")
 	rootCmd.AddGroup(&cobra.Group{ID: GROUP_ID_QUERYING, Title: "History Searching"})
 	rootCmd.AddGroup(&cobra.Group{ID: GROUP_ID_MANAGEMENT, Title: "History Management"})
 	rootCmd.AddGroup(&cobra.Group{ID: GROUP_ID_CONFIG, Title: "Configuration"})
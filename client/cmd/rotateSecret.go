@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+var rotateSecretCmd = &cobra.Command{
+	Use:   "rotate-secret",
+	Short: "Generate a new secret key and re-upload your history under it",
+	Long: "Generates a new secret key, re-encrypts and re-uploads all of your history entries under it, and deletes the data stored under the old secret from the backend. " +
+		"Your other devices will stop syncing and need to be re-initialized with `hishtory init $NEW_SECRET`.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		newSecret, err := rotateSecret(ctx)
+		lib.CheckFatalError(err)
+		fmt.Println("Rotated your secret hishtory key to " + newSecret)
+		fmt.Println("Run `hishtory init " + newSecret + "` on your other devices to continue syncing with them")
+	},
+}
+
+func rotateSecret(ctx context.Context) (string, error) {
+	config := hctx.GetConf(ctx)
+	oldSecret := config.UserSecret
+	newSecret := uuid.Must(uuid.NewRandom()).String()
+	config.UserSecret = newSecret
+	if config.IsOffline {
+		// There is nothing stored remotely, so we only need to update the secret locally
+		return newSecret, hctx.SetConfig(config)
+	}
+
+	// Upload everything under the new secret before persisting it, so that a failure part-way through leaves the
+	// device using the old secret
+	err := registerAndBootstrapDevice(ctx, config, hctx.GetDb(ctx), newSecret)
+	if err != nil {
+		config.UserSecret = oldSecret
+		return "", fmt.Errorf("failed to register device under the new secret: %w", err)
+	}
+	err = lib.Reupload(ctx)
+	if err != nil {
+		config.UserSecret = oldSecret
+		return "", fmt.Errorf("failed to re-upload history entries under the new secret: %w", err)
+	}
+	err = hctx.SetConfig(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to persist the new secret: %w", err)
+	}
+
+	// And then delete the data stored under the old secret
+	_, err = lib.ApiPost(ctx, "/api/v1/delete-user?user_id="+data.UserId(oldSecret), "application/json", []byte{})
+	if err != nil {
+		return "", fmt.Errorf("rotated to the new secret %s, but failed to delete the data stored under the old secret: %w", newSecret, err)
+	}
+	return newSecret, nil
+}
+
+func init() {
+	rootCmd.AddCommand(rotateSecretCmd)
+}
@@ -12,7 +12,6 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/ddworken/hishtory/client/data"
@@ -41,8 +40,23 @@ var saveHistoryEntryCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
 		lib.CheckFatalError(maybeUploadSkippedHistoryEntries(ctx))
+		_, err := lib.FlushOutbox(ctx, false)
+		lib.CheckFatalError(err)
 		lib.CheckFatalError(maybeSubmitPendingDeletionRequests(ctx))
 		saveHistoryEntry(ctx)
+		// This runs in the background, so it is a good time to occasionally optimize the local DB
+		if err := lib.MaybeRunDbMaintenance(ctx); err != nil {
+			hctx.GetLogger().Warnf("failed to run DB maintenance: %v", err)
+		}
+		if err := lib.MaybeEmptyTrash(ctx); err != nil {
+			hctx.GetLogger().Warnf("failed to empty the trash: %v", err)
+		}
+		if err := lib.MaybeReportAiFeedback(ctx); err != nil {
+			hctx.GetLogger().Warnf("failed to report AI feedback: %v", err)
+		}
+		if err := lib.MaybeReencryptHistory(ctx); err != nil {
+			hctx.GetLogger().Warnf("failed to re-encrypt history entries: %v", err)
+		}
 	},
 }
 
@@ -83,12 +97,14 @@ func maybeSubmitPendingDeletionRequests(ctx context.Context) error {
 	return hctx.SetConfig(config)
 }
 
+// Uploads entries that were missed by older versions of hishtory, which tracked missed uploads via a timestamp rather
+// than the outbox
 func maybeUploadSkippedHistoryEntries(ctx context.Context) error {
 	config := hctx.GetConf(ctx)
 	if !config.HaveMissedUploads {
 		return nil
 	}
-	if config.IsOffline {
+	if config.IsOffline || !config.SyncMode.CanUpload() {
 		return nil
 	}
 
@@ -120,17 +136,16 @@ func maybeUploadSkippedHistoryEntries(ctx context.Context) error {
 	return nil
 }
 
-func handlePotentialUploadFailure(ctx context.Context, err error, config *hctx.ClientConfig, entryTimestamp time.Time) {
+func handlePotentialUploadFailure(ctx context.Context, err error, entry *data.HistoryEntry) {
 	if err != nil {
 		if lib.IsOfflineError(ctx, err) {
 			hctx.GetLogger().Infof("Failed to remotely persist hishtory entry because we failed to connect to the remote server! This is likely because the device is offline, but also could be because the remote server is having reliability issues. Original error: %v", err)
-			if !config.HaveMissedUploads {
-				config.HaveMissedUploads = true
-				// Set MissedUploadTimestamp to `entry timestamp - 1` so that the current entry will get
-				// uploaded once network access is regained.
-				config.MissedUploadTimestamp = entryTimestamp.UTC().Unix() - 1
-				lib.CheckFatalError(hctx.SetConfig(config))
-			}
+			// Queue it so that it will get uploaded once network access is regained
+			lib.CheckFatalError(lib.EnqueueForUpload(ctx, []*data.HistoryEntry{entry}))
+		} else if lib.IsClientTooOldError(err) {
+			// Queue it so that it will get uploaded once hishtory is updated, and then surface the error
+			lib.CheckFatalError(lib.EnqueueForUpload(ctx, []*data.HistoryEntry{entry}))
+			lib.CheckFatalError(err)
 		} else {
 			lib.CheckFatalError(err)
 		}
@@ -145,6 +160,9 @@ func presaveHistoryEntry(ctx context.Context) {
 	if !config.EnablePresaving {
 		return
 	}
+	if isIncognitoSession(config, os.Getenv("HISHTORY_SESSION_ID")) {
+		return
+	}
 
 	// Build the basic entry with metadata retrieved from runtime
 	entry, err := buildPreArgsHistoryEntry(ctx)
@@ -162,6 +180,20 @@ func presaveHistoryEntry(ctx context.Context) {
 		// Don't save commands that start with a space
 		return
 	}
+	isExcluded, err := lib.IsExcludedFromRecording(ctx, entry)
+	lib.CheckFatalError(err)
+	if isExcluded {
+		return
+	}
+	redactedCmd, shouldDrop, err := lib.ApplyRedactionRules(ctx, entry.Command)
+	lib.CheckFatalError(err)
+	if shouldDrop {
+		return
+	}
+	entry.Command = redactedCmd
+	if config.RecordSshHost {
+		entry.RemoteHost = lib.GetSshRemoteHost(entry.Command)
+	}
 	entry.StartTime = parseCrossPlatformTime(os.Args[4])
 	entry.EndTime = time.Unix(0, 0).UTC()
 
@@ -172,11 +204,11 @@ func presaveHistoryEntry(ctx context.Context) {
 	db.Commit()
 
 	// And persist it remotely
-	if !config.IsOffline {
+	if !config.IsOffline && config.SyncMode.CanUpload() {
 		jsonValue, err := lib.EncryptAndMarshal(config, []*data.HistoryEntry{entry})
 		lib.CheckFatalError(err)
 		_, err = lib.ApiPost(ctx, "/api/v1/submit?source_device_id="+config.DeviceId, "application/json", jsonValue)
-		handlePotentialUploadFailure(ctx, err, config, entry.StartTime)
+		handlePotentialUploadFailure(ctx, err, entry)
 	}
 }
 
@@ -186,6 +218,10 @@ func saveHistoryEntry(ctx context.Context) {
 		hctx.GetLogger().Infof("Skipping saving a history entry because hishtory is disabled\n")
 		return
 	}
+	if isIncognitoSession(config, os.Getenv("HISHTORY_SESSION_ID")) {
+		hctx.GetLogger().Infof("Skipping saving a history entry because recording is off for this shell session\n")
+		return
+	}
 	entry, err := buildHistoryEntry(ctx, os.Args)
 	lib.CheckFatalError(err)
 	if entry == nil {
@@ -203,12 +239,19 @@ func saveHistoryEntry(ctx context.Context) {
 	err = lib.ReliableDbCreate(db, *entry)
 	lib.CheckFatalError(err)
 
+	if lib.ShouldNotify(config, entry) {
+		err = lib.SendCommandNotification(ctx, entry)
+		if err != nil {
+			hctx.GetLogger().Infof("Failed to send a notification for a long-running command: %v", err)
+		}
+	}
+
 	// Persist it remotely
-	if !config.IsOffline {
+	if !config.IsOffline && config.SyncMode.CanUpload() {
 		jsonValue, err := lib.EncryptAndMarshal(config, []*data.HistoryEntry{entry})
 		lib.CheckFatalError(err)
 		w, err := lib.ApiPost(ctx, "/api/v1/submit?source_device_id="+config.DeviceId, "application/json", jsonValue)
-		handlePotentialUploadFailure(ctx, err, config, entry.StartTime)
+		handlePotentialUploadFailure(ctx, err, entry)
 		if err == nil {
 			submitResponse := shared.SubmitResponse{}
 			err := json.Unmarshal(w, &submitResponse)
@@ -217,6 +260,19 @@ func saveHistoryEntry(ctx context.Context) {
 			}
 			lib.CheckFatalError(lib.HandleDeletionRequests(ctx, submitResponse.DeletionRequests))
 			lib.CheckFatalError(handleDumpRequests(ctx, submitResponse.DumpRequests))
+
+			// We're online, so also upload anything that was queued while we were offline
+			_, err = lib.FlushOutbox(ctx, true)
+			lib.CheckFatalError(err)
+			lib.CheckFatalError(lib.RecordSuccessfulSync(ctx))
+		}
+	}
+
+	// Publish it to any channels with a matching publish rule
+	if !config.IsOffline && len(config.Channels) > 0 {
+		err = lib.PublishToMatchingChannels(ctx, entry)
+		if err != nil {
+			hctx.GetLogger().Infof("Failed to publish the entry to channels: %v", err)
 		}
 	}
 
@@ -305,13 +361,13 @@ func deletePresavedEntries(ctx context.Context, entry *data.HistoryEntry, isRetr
 func handleDumpRequests(ctx context.Context, dumpRequests []*shared.DumpRequest) error {
 	db := hctx.GetDb(ctx)
 	config := hctx.GetConf(ctx)
-	if len(dumpRequests) > 0 {
+	if len(dumpRequests) > 0 && config.SyncMode.CanUpload() {
 		lib.CheckFatalError(lib.RetrieveAdditionalEntriesFromRemote(ctx, "newclient"))
 		entries, err := lib.Search(ctx, db, "", 0)
 		lib.CheckFatalError(err)
 		var encEntries []*shared.EncHistoryEntry
 		for _, entry := range entries {
-			enc, err := data.EncryptHistoryEntry(config.UserSecret, *entry)
+			enc, err := data.EncryptHistoryEntryWithHashedFields(config.UserSecret, *entry, config.HashedFields)
 			lib.CheckFatalError(err)
 			encEntries = append(encEntries, &enc)
 		}
@@ -339,7 +395,7 @@ func buildPreArgsHistoryEntry(ctx context.Context) (*data.HistoryEntry, error) {
 	entry.LocalUsername = user.Username
 
 	// cwd and homedir
-	cwd, homedir, err := getCwd(ctx)
+	cwd, homedir, err := lib.GetCwd(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build history entry: %w", err)
 	}
@@ -360,6 +416,24 @@ func buildPreArgsHistoryEntry(ctx context.Context) (*data.HistoryEntry, error) {
 	// entry ID
 	entry.EntryId = uuid.Must(uuid.NewRandom()).String()
 
+	// session ID
+	entry.SessionId = os.Getenv("HISHTORY_SESSION_ID")
+
+	// git repo and branch
+	if config.RecordGitInfo {
+		entry.GitRepo, entry.GitBranch = getGitInfo(ctx)
+	}
+
+	// tmux session, window, and pane
+	if config.RecordTmuxInfo {
+		entry.TmuxSession, entry.TmuxWindow, entry.TmuxPane = lib.GetTmuxInfo()
+	}
+
+	// container ID
+	if config.IsEphemeral {
+		entry.ContainerId = lib.GetContainerId()
+	}
+
 	// custom columns
 	cc, err := buildCustomColumns(ctx)
 	if err != nil {
@@ -406,6 +480,30 @@ func buildHistoryEntry(ctx context.Context, args []string) (*data.HistoryEntry,
 		return nil, nil
 	}
 
+	// Skip commands that the user excluded from being recorded
+	isExcluded, err := lib.IsExcludedFromRecording(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	if isExcluded {
+		return nil, nil
+	}
+
+	// Redact secrets before the entry is stored or synced
+	redactedCmd, shouldDrop, err := lib.ApplyRedactionRules(ctx, entry.Command)
+	if err != nil {
+		return nil, err
+	}
+	if shouldDrop {
+		return nil, nil
+	}
+	entry.Command = redactedCmd
+
+	// The remote host for ssh commands
+	if hctx.GetConf(ctx).RecordSshHost {
+		entry.RemoteHost = lib.GetSshRemoteHost(entry.Command)
+	}
+
 	return entry, nil
 }
 
@@ -430,10 +528,13 @@ func extractCommandFromArg(ctx context.Context, shell, arg string, isPresave boo
 		if err != nil {
 			return "", err
 		}
+		if hasIgnoredPrefix(ctx, cmd) {
+			return "", nil
+		}
 		return cmd, nil
-	} else if shell == "zsh" || shell == "fish" {
+	} else if shell == "zsh" || shell == "fish" || shell == "pwsh" || shell == "nu" {
 		cmd := trimTrailingWhitespace(arg)
-		if strings.HasPrefix(cmd, " ") {
+		if strings.HasPrefix(cmd, " ") || hasIgnoredPrefix(ctx, cmd) {
 			// Don't save commands that start with a space
 			return "", nil
 		}
@@ -444,6 +545,12 @@ func extractCommandFromArg(ctx context.Context, shell, arg string, isPresave boo
 
 }
 
+// Whether the command starts with the user's configured prefix for commands that shouldn't be recorded
+func hasIgnoredPrefix(ctx context.Context, cmd string) bool {
+	prefix := hctx.GetConf(ctx).IgnoredCommandPrefix
+	return prefix != "" && strings.HasPrefix(cmd, prefix)
+}
+
 func trimTrailingWhitespace(s string) string {
 	return strings.TrimSuffix(strings.TrimSuffix(s, "\n"), " ")
 }
@@ -452,7 +559,7 @@ func buildCustomColumns(ctx context.Context) (data.CustomColumns, error) {
 	ccs := data.CustomColumns{}
 	config := hctx.GetConf(ctx)
 	for _, cc := range config.CustomColumns {
-		cmd := exec.Command("bash", "-c", cc.ColumnCommand)
+		cmd := lib.MakeShellCommand(cc.ColumnCommand)
 		var stdout bytes.Buffer
 		cmd.Stdout = &stdout
 		var stderr bytes.Buffer
@@ -475,6 +582,35 @@ func buildCustomColumns(ctx context.Context) (data.CustomColumns, error) {
 	return ccs, nil
 }
 
+// Returns the root of the git repository and the current branch, or empty strings if the current directory is not
+// inside of a git repository
+func getGitInfo(ctx context.Context) (string, string) {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		// Not in a git repo (or git isn't installed), so there is nothing to record
+		return "", ""
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 {
+		hctx.GetLogger().Warnf("unexpected output from git rev-parse: %#v", string(out))
+		return "", ""
+	}
+	repo := abbreviateHomeDir(strings.TrimSpace(lines[0]), hctx.GetHome(ctx))
+	return repo, strings.TrimSpace(lines[1])
+}
+
+// Replaces the home directory at the start of the given path with ~, but only if the path is the home directory or is
+// inside of it (so e.g. /home/user2 isn't abbreviated for /home/user)
+func abbreviateHomeDir(p, homedir string) string {
+	if p == homedir {
+		return "~"
+	}
+	if strings.HasPrefix(p, homedir+"/") {
+		return "~" + strings.TrimPrefix(p, homedir)
+	}
+	return p
+}
+
 func buildRegexFromTimeFormat(timeFormat string) string {
 	expectedRegex := ""
 	lastCharWasPercent := false
@@ -620,37 +756,6 @@ func shouldSkipHiddenCommand(ctx context.Context, historyLine string, isPresave
 	return false, nil
 }
 
-func getCwd(ctx context.Context) (string, string, error) {
-	cwd, err := getCwdWithoutSubstitution()
-	if err != nil {
-		return "", "", fmt.Errorf("failed to get cwd for last command: %w", err)
-	}
-	homedir := hctx.GetHome(ctx)
-	if cwd == homedir {
-		return "~/", homedir, nil
-	}
-	if strings.HasPrefix(cwd, homedir) {
-		return strings.Replace(cwd, homedir, "~", 1), homedir, nil
-	}
-	return cwd, homedir, nil
-}
-
-func getCwdWithoutSubstitution() (string, error) {
-	cwd, err := os.Getwd()
-	if err == nil {
-		return cwd, nil
-	}
-	// Fall back to the syscall to see if that works, as an attempt to
-	// fix github.com/ddworken/hishtory/issues/69
-	if syscall.ImplementsGetwd {
-		cwd, err = syscall.Getwd()
-		if err == nil {
-			return cwd, nil
-		}
-	}
-	return "", err
-}
-
 func init() {
 	rootCmd.AddCommand(saveHistoryEntryCmd)
 	rootCmd.AddCommand(presaveHistoryEntryCmd)
@@ -148,6 +148,22 @@ func TestParseCrossPlatformTime(t *testing.T) {
 	require.Equal(t, time.Unix(0, 1696715218277655463).UTC(), res)
 }
 
+func TestAbbreviateHomeDir(t *testing.T) {
+	testcases := []struct {
+		path     string
+		expected string
+	}{
+		{"/home/user", "~"},
+		{"/home/user/code/hishtory", "~/code/hishtory"},
+		{"/home/user2/code/hishtory", "/home/user2/code/hishtory"},
+		{"/home/username", "/home/username"},
+		{"/tmp/repo", "/tmp/repo"},
+	}
+	for _, tc := range testcases {
+		require.Equal(t, tc.expected, abbreviateHomeDir(tc.path, "/home/user"), tc.path)
+	}
+}
+
 func TestBuildRegexFromTimeFormat(t *testing.T) {
 	testcases := []struct {
 		formatString, regex string
@@ -226,3 +242,43 @@ func TestMaybeSkipBashHistTimePrefix(t *testing.T) {
 		}
 	}
 }
+
+func TestIgnoredCommandPrefix(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, setup("", true))
+	ctx := hctx.MakeContext()
+	config := hctx.GetConf(ctx)
+	config.IgnoredCommandPrefix = "pass "
+	require.NoError(t, hctx.SetConfig(config))
+
+	for _, shell := range []string{"zsh", "fish"} {
+		cmd, err := extractCommandFromArg(ctx, shell, "pass show foo\n", false)
+		require.NoError(t, err)
+		require.Equal(t, "", cmd, shell)
+		cmd, err = extractCommandFromArg(ctx, shell, "passwd\n", false)
+		require.NoError(t, err)
+		require.Equal(t, "passwd", cmd, shell)
+	}
+	require.True(t, hasIgnoredPrefix(ctx, "pass show foo"))
+	require.False(t, hasIgnoredPrefix(ctx, " pass show foo"))
+}
+
+func TestIncognitoSession(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, setup("", true))
+	ctx := hctx.MakeContext()
+	config := hctx.GetConf(ctx)
+
+	require.Error(t, SetIncognito(ctx, "", true))
+	require.NoError(t, SetIncognito(ctx, "session-1", true))
+	require.NoError(t, SetIncognito(ctx, "session-2", true))
+	require.NoError(t, SetIncognito(ctx, "session-1", true))
+	require.Equal(t, []string{"session-2", "session-1"}, config.IncognitoSessionIds)
+	require.True(t, isIncognitoSession(config, "session-1"))
+	require.False(t, isIncognitoSession(config, "session-3"))
+	require.False(t, isIncognitoSession(config, ""))
+
+	require.NoError(t, SetIncognito(ctx, "session-1", false))
+	require.False(t, isIncognitoSession(config, "session-1"))
+	require.True(t, isIncognitoSession(config, "session-2"))
+}
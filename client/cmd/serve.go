@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/client/localapi"
+	"github.com/spf13/cobra"
+)
+
+var serveLocal *bool
+var servePort *int
+var serveToken *string
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve a read-only JSON API for querying your shell history",
+	Long: "Serves a read-only JSON API on localhost that can be used by editors, launchers, and scripts to query your history. " +
+		"Requests must include an `Authorization: Bearer <token>` header. Supported endpoints:\n\n" +
+		"  GET /api/v1/search?q=<query>&limit=<limit>\n" +
+		"  GET /api/v1/entry?entry_id=<entry_id>\n" +
+		"  GET /api/v1/stats?cmd=<command>",
+	GroupID: GROUP_ID_QUERYING,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !*serveLocal {
+			lib.CheckFatalError(fmt.Errorf("only --local mode is currently supported"))
+		}
+		lib.CheckFatalError(localapi.StartLocalApiServer(hctx.MakeContext(), *servePort, *serveToken))
+		os.Exit(1)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveLocal = serveCmd.Flags().Bool("local", false, "Serve the API on localhost")
+	servePort = serveCmd.Flags().Int("port", 8001, "The port for the API server to listen on")
+	serveToken = serveCmd.Flags().String("token", "", "Specify the bearer token used to authenticate requests, rather than generating a random one")
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+// Commands that run for at least this long are counted as long-running in session summaries
+const LONG_RUNNING_COMMAND_THRESHOLD = time.Minute
+
+// The maximum number of long-running commands that are listed in a session summary
+const MAX_SUMMARIZED_LONG_RUNNING_COMMANDS = 3
+
+var sessionSummaryCmd = &cobra.Command{
+	Use:    "sessionSummary",
+	Hidden: true,
+	Short:  "[Internal-only] Print a summary of the current shell session when it ends",
+	Args:   cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		if !hctx.GetConf(ctx).ShowSessionSummary {
+			return
+		}
+		sessionId := os.Getenv("HISHTORY_SESSION_ID")
+		if sessionId == "" {
+			return
+		}
+		summary, err := summarizeSession(ctx, sessionId)
+		lib.CheckFatalError(err)
+		fmt.Print(summary)
+	},
+}
+
+func summarizeSession(ctx context.Context, sessionId string) (string, error) {
+	var entries []*data.HistoryEntry
+	err := hctx.GetDb(ctx).Where("session_id = ?", sessionId).Order("start_time ASC").Find(&entries).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to query entries for session %#v: %w", sessionId, err)
+	}
+	return formatSessionSummary(entries, time.Now()), nil
+}
+
+func formatSessionSummary(entries []*data.HistoryEntry, now time.Time) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	numFailed := 0
+	longRunningTime := time.Duration(0)
+	longRunningEntries := make([]*data.HistoryEntry, 0)
+	for _, entry := range entries {
+		if entry.EndTime.UnixMilli() == 0 {
+			// A pre-saved entry that never finished, so there is no exit code or runtime to summarize
+			continue
+		}
+		if entry.ExitCode != 0 {
+			numFailed += 1
+		}
+		runtime := entry.EndTime.Sub(entry.StartTime)
+		if runtime >= LONG_RUNNING_COMMAND_THRESHOLD {
+			longRunningTime += runtime
+			longRunningEntries = append(longRunningEntries, entry)
+		}
+	}
+	sessionLength := now.Sub(entries[0].StartTime).Round(time.Second)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("hishtory session summary: %d commands run over %s (%d failed)\n", len(entries), sessionLength, numFailed))
+	if len(longRunningEntries) > 0 {
+		sb.WriteString(fmt.Sprintf("Time spent in commands that ran for over %s: %s\n", LONG_RUNNING_COMMAND_THRESHOLD, longRunningTime.Round(time.Second)))
+		sort.SliceStable(longRunningEntries, func(i, j int) bool {
+			return longRunningEntries[i].EndTime.Sub(longRunningEntries[i].StartTime) > longRunningEntries[j].EndTime.Sub(longRunningEntries[j].StartTime)
+		})
+		for i, entry := range longRunningEntries {
+			if i >= MAX_SUMMARIZED_LONG_RUNNING_COMMANDS {
+				break
+			}
+			sb.WriteString(fmt.Sprintf("  %-10s %s\n", entry.EndTime.Sub(entry.StartTime).Round(time.Second), entry.Command))
+		}
+	}
+	return sb.String()
+}
+
+func init() {
+	rootCmd.AddCommand(sessionSummaryCmd)
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatSessionSummary(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	makeEntry := func(command string, offset, runtime time.Duration, exitCode int) *data.HistoryEntry {
+		return &data.HistoryEntry{
+			Command:   command,
+			StartTime: start.Add(offset),
+			EndTime:   start.Add(offset + runtime),
+			ExitCode:  exitCode,
+		}
+	}
+	require.Equal(t, "", formatSessionSummary(nil, start))
+
+	entries := []*data.HistoryEntry{
+		makeEntry("ls", 0, time.Second, 0),
+		makeEntry("make test", time.Minute, 5*time.Minute, 2),
+		makeEntry("cat missing.txt", 10*time.Minute, time.Second, 1),
+		makeEntry("docker build .", 20*time.Minute, 12*time.Minute, 0),
+		// A pre-saved entry that is still running
+		{Command: "vim", StartTime: start.Add(40 * time.Minute), EndTime: time.Unix(0, 0).UTC()},
+	}
+	require.Equal(t, "hishtory session summary: 5 commands run over 1h0m0s (2 failed)\n"+
+		"Time spent in commands that ran for over 1m0s: 17m0s\n"+
+		"  12m0s      docker build .\n"+
+		"  5m0s       make test\n",
+		formatSessionSummary(entries, start.Add(time.Hour)))
+}
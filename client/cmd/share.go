@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/shared"
+	"github.com/spf13/cobra"
+)
+
+var shareLimit *int
+
+var shareCmd = &cobra.Command{
+	Use:     "share [query]",
+	Short:   "Share the commands matching the query via a read-only link",
+	Long:    "Encrypts the history entries matching the query with a new random key, uploads them to the backend, and prints a link that can be viewed with `hishtory view-shared`. The key is only included in the link (after the '#'), so the backend can't read the shared entries, and whoever you send the link to can only view the shared entries rather than the rest of your history. Links expire after 30 days.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		query := strings.Join(args, " ")
+		entries, err := lib.Search(ctx, hctx.GetDb(ctx), query, *shareLimit)
+		lib.CheckFatalError(err)
+		if len(entries) == 0 {
+			lib.CheckFatalError(fmt.Errorf("no commands found matching the query %#v", query))
+		}
+		link, resp, err := lib.ShareEntries(ctx, entries)
+		lib.CheckFatalError(err)
+		fmt.Printf("Shared %d history entries, view them by running:\n\n\thishtory view-shared '%s'\n\nThis link expires on %s\n", len(entries), link, resp.ExpirationDate.Local().Format(shared.DateOnly))
+	},
+}
+
+var viewSharedCmd = &cobra.Command{
+	Use:     "view-shared <link>",
+	Short:   "View the commands shared via `hishtory share`",
+	GroupID: GROUP_ID_QUERYING,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		entries, err := lib.ViewSharedEntries(ctx, args[0])
+		lib.CheckFatalError(err)
+		lib.CheckFatalError(DisplayResults(ctx, entries, len(entries)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(shareCmd)
+	rootCmd.AddCommand(viewSharedCmd)
+	shareLimit = shareCmd.Flags().Int("limit", 100, "The maximum number of matching history entries to share")
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var snippetExportFormat *string
+
+var snippetCmd = &cobra.Command{
+	Use:     "snippet",
+	Short:   "Save commands from your history as named snippets, as a lightweight personal runbook",
+	Long:    "Snippets are commands saved under a short name via `hishtory snippet save` (or via the TUI). They can be listed, run, and exported as shell aliases. Snippets are only stored on this device.",
+	GroupID: GROUP_ID_MANAGEMENT,
+}
+
+var snippetSaveCmd = &cobra.Command{
+	Use:   "save <name> [query]",
+	Short: "Save the most recent command matching the query (or the last command) as a snippet",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		name := args[0]
+		lib.CheckFatalError(lib.ValidateSnippetName(name))
+		command, err := findCommandForSnippet(ctx, strings.Join(args[1:], " "))
+		lib.CheckFatalError(err)
+		lib.CheckFatalError(lib.SaveSnippet(ctx, name, command))
+		fmt.Printf("Saved %#v as the snippet %s\n", command, name)
+	},
+}
+
+var snippetListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your snippets",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		snippets, err := lib.ListSnippets(hctx.MakeContext())
+		lib.CheckFatalError(err)
+		if len(snippets) == 0 {
+			fmt.Println("You don't have any snippets, run `hishtory snippet save` to save one")
+			return
+		}
+		for _, s := range snippets {
+			fmt.Printf("%s\t%s\n", s.Name, s.Command)
+		}
+	},
+}
+
+var snippetRunCmd = &cobra.Command{
+	Use:   "run <name>",
+	Short: "Run the snippet with the given name in your shell",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		snippet, err := lib.GetSnippet(hctx.MakeContext(), args[0])
+		lib.CheckFatalError(err)
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "bash"
+		}
+		c := exec.Command(shell, "-c", snippet.Command)
+		c.Stdin = os.Stdin
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		err = c.Run()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		lib.CheckFatalError(err)
+	},
+}
+
+var snippetExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export your snippets as shell aliases (e.g. to add to your .bashrc) or as JSON",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		snippets, err := lib.ListSnippets(hctx.MakeContext())
+		lib.CheckFatalError(err)
+		switch *snippetExportFormat {
+		case "aliases":
+			for _, s := range snippets {
+				fmt.Printf("alias %s=%s\n", s.Name, lib.ShellQuote(s.Command))
+			}
+		case "json":
+			out, err := json.MarshalIndent(snippets, "", "  ")
+			lib.CheckFatalError(err)
+			fmt.Println(string(out))
+		default:
+			lib.CheckFatalError(fmt.Errorf("unknown export format %#v, must be one of: aliases, json", *snippetExportFormat))
+		}
+	},
+}
+
+var snippetDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete the snippet with the given name",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(lib.DeleteSnippet(hctx.MakeContext(), args[0]))
+	},
+}
+
+// Returns the most recent command matching the query, skipping `hishtory snippet` itself so that an empty query saves
+// the command run before it
+func findCommandForSnippet(ctx context.Context, query string) (string, error) {
+	entries, err := lib.Search(ctx, hctx.GetDb(ctx), query, 10)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(strings.TrimSpace(entry.Command), "hishtory snippet ") {
+			return entry.Command, nil
+		}
+	}
+	return "", fmt.Errorf("no command found matching the query %#v", query)
+}
+
+func init() {
+	rootCmd.AddCommand(snippetCmd)
+	snippetCmd.AddCommand(snippetSaveCmd)
+	snippetCmd.AddCommand(snippetListCmd)
+	snippetCmd.AddCommand(snippetRunCmd)
+	snippetCmd.AddCommand(snippetExportCmd)
+	snippetCmd.AddCommand(snippetDeleteCmd)
+	snippetExportFormat = snippetExportCmd.Flags().String("format", "aliases", "The format to export snippets in, one of: aliases, json")
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+// The number of most frequently run commands that are listed by `hishtory stats`
+const NUM_TOP_COMMANDS = 10
+
+var statsAi *bool
+
+var statsCmd = &cobra.Command{
+	Use:     "stats",
+	Short:   "View statistics about your shell history",
+	GroupID: GROUP_ID_QUERYING,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		if *statsAi {
+			printAiStats(ctx)
+			return
+		}
+		printHistoryStats(ctx)
+	},
+}
+
+func printHistoryStats(ctx context.Context) {
+	db := hctx.GetDb(ctx)
+	// Effectively identical commands are counted together if normalization is enabled, but the original text of the
+	// most recent variant is what is displayed
+	groupingColumn := lib.CommandGroupingColumn(ctx)
+	if groupingColumn != "command" {
+		lib.CheckFatalError(lib.BackfillNormalizedCommands(db))
+	}
+	var numEntries, numCommands int64
+	lib.CheckFatalError(db.Model(&data.HistoryEntry{}).Count(&numEntries).Error)
+	lib.CheckFatalError(db.Model(&data.HistoryEntry{}).Distinct(groupingColumn).Count(&numCommands).Error)
+	fmt.Printf("Commands run: %d\n", numEntries)
+	fmt.Printf("Unique commands: %d\n", numCommands)
+	var topCommands []struct {
+		Command string
+		Count   int
+	}
+	err := db.Model(&data.HistoryEntry{}).Select("command, MAX(end_time), COUNT(*) AS count").Group(groupingColumn).Order("count DESC").Limit(NUM_TOP_COMMANDS).Scan(&topCommands).Error
+	lib.CheckFatalError(err)
+	if len(topCommands) > 0 {
+		fmt.Println("Most frequently run commands:")
+		for _, c := range topCommands {
+			fmt.Printf("  %d\t%s\n", c.Count, c.Command)
+		}
+	}
+}
+
+func printAiStats(ctx context.Context) {
+	if !lib.IsAiFeedbackEnabled(ctx) {
+		fmt.Println("Note: Feedback on AI suggestions isn't currently being recorded, run `hishtory config-set ai-feedback local` to enable it")
+	}
+	stats, err := lib.GetAiFeedbackStats(ctx)
+	lib.CheckFatalError(err)
+	if len(stats) == 0 {
+		fmt.Println("No feedback on AI suggestions has been recorded yet")
+		return
+	}
+	totalAccepted, totalRejected := 0, 0
+	for _, s := range stats {
+		fmt.Printf("%s: %s\n", s.Provider, formatAcceptanceRate(s.NumAccepted, s.NumRejected))
+		totalAccepted += s.NumAccepted
+		totalRejected += s.NumRejected
+	}
+	if len(stats) > 1 {
+		fmt.Printf("Total: %s\n", formatAcceptanceRate(totalAccepted, totalRejected))
+	}
+}
+
+func formatAcceptanceRate(numAccepted, numRejected int) string {
+	total := numAccepted + numRejected
+	return fmt.Sprintf("%d of %d suggestions accepted (%d%%)", numAccepted, total, 100*numAccepted/max(total, 1))
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsAi = statsCmd.Flags().Bool("ai", false, "View how often AI suggestions were accepted, as recorded via `hishtory config-set ai-feedback`")
+}
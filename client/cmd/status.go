@@ -1,15 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/ddworken/hishtory/client/data"
 	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/shared"
 	"github.com/spf13/cobra"
 )
 
-var verbose *bool
+var (
+	verbose    *bool
+	syncStatus *bool
+)
 
 var statusCmd = &cobra.Command{
 	Use:   "status",
@@ -17,35 +23,82 @@ var statusCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := hctx.MakeContext()
 		config := hctx.GetConf(ctx)
+		if *syncStatus {
+			printSyncStatus(ctx, config)
+			return
+		}
 		fmt.Printf("hiSHtory: v0.%s\nEnabled: %v\n", lib.Version, config.IsEnabled)
 		fmt.Printf("Secret Key: %s\n", config.UserSecret)
 		if *verbose {
 			fmt.Printf("User ID: %s\n", data.UserId(config.UserSecret))
 			fmt.Printf("Device ID: %s\n", config.DeviceId)
-			printOnlineStatus(config)
+			printOnlineStatus(ctx, config)
 		}
 		fmt.Printf("Commit Hash: %s\n", lib.GitCommit)
 	},
 }
 
-func printOnlineStatus(config *hctx.ClientConfig) {
+func printOnlineStatus(ctx context.Context, config *hctx.ClientConfig) {
 	if config.IsOffline {
 		fmt.Println("Sync Mode: Disabled")
 	} else {
 		fmt.Println("Sync Mode: Enabled")
-		if lib.GetServerHostname() != lib.DefaultServerHostname {
-			fmt.Println("Sync Server: " + lib.GetServerHostname())
+		if envName := hctx.GetServerEnvironmentName(); envName != "" {
+			fmt.Println("Server Environment: " + envName)
 		}
-		if config.HaveMissedUploads || len(config.PendingDeletionRequests) > 0 {
+		if lib.GetServerHostname(ctx) != lib.DefaultServerHostname {
+			fmt.Println("Sync Server: " + lib.GetServerHostname(ctx))
+		}
+		numQueued, err := lib.CountOutbox(ctx)
+		lib.CheckFatalError(err)
+		if config.HaveMissedUploads || len(config.PendingDeletionRequests) > 0 || numQueued > 0 {
 			fmt.Println("Sync Status: Unsynced (device is offline?)")
-			fmt.Printf("  HaveMissedUploads=%v MissedUploadTimestamp=%v len(PendingDeletionRequests)=%v\n", config.HaveMissedUploads, config.MissedUploadTimestamp, len(config.PendingDeletionRequests))
+			fmt.Printf("  HaveMissedUploads=%v MissedUploadTimestamp=%v len(PendingDeletionRequests)=%v QueuedUploads=%v\n", config.HaveMissedUploads, config.MissedUploadTimestamp, len(config.PendingDeletionRequests), numQueued)
 		} else {
 			fmt.Println("Sync Status: Synced")
 		}
 	}
 }
 
+// Prints a diagnostic report for figuring out why history isn't showing up on other devices
+func printSyncStatus(ctx context.Context, config *hctx.ClientConfig) {
+	if config.IsOffline {
+		fmt.Println("Sync is disabled since this is an offline install of hishtory")
+		return
+	}
+	syncMode := config.SyncMode
+	if syncMode == "" {
+		syncMode = shared.SyncModeReadWrite
+	}
+	fmt.Printf("Sync Mode: %s\n", syncMode)
+	if config.LastSuccessfulSyncTimestamp == 0 {
+		fmt.Println("Last Successful Sync: never")
+	} else {
+		lastSync := time.Unix(config.LastSuccessfulSyncTimestamp, 0)
+		fmt.Printf("Last Successful Sync: %s (%s ago)\n", lastSync.Format(config.TimestampFormat), time.Since(lastSync).Round(time.Second))
+	}
+	numQueued, err := lib.CountOutbox(ctx)
+	lib.CheckFatalError(err)
+	fmt.Printf("Unsynced Entries: %d\n", numQueued)
+	if config.HaveMissedUploads {
+		fmt.Printf("  Plus entries since %s that were skipped by an older version of hishtory\n", time.Unix(config.MissedUploadTimestamp, 0).Format(config.TimestampFormat))
+	}
+	fmt.Printf("Pending Deletion Requests: %d\n", len(config.PendingDeletionRequests))
+	fmt.Printf("Backend: %s\n", lib.GetServerHostname(ctx))
+	backendVersion, err := lib.GetBackendVersion(ctx)
+	if err != nil {
+		fmt.Printf("Backend Reachable: false (%v)\n", err)
+		return
+	}
+	fmt.Println("Backend Reachable: true")
+	if backendVersion == "" {
+		backendVersion = "unknown"
+	}
+	fmt.Printf("Backend Version: %s\n", backendVersion)
+}
+
 func init() {
 	rootCmd.AddCommand(statusCmd)
 	verbose = statusCmd.Flags().BoolP("verbose", "v", false, "Display verbose hiSHtory information")
+	syncStatus = statusCmd.Flags().Bool("sync", false, "Display diagnostic information about syncing with the backend")
 }
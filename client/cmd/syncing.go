@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/ddworken/hishtory/client/data"
 	"github.com/ddworken/hishtory/client/hctx"
@@ -45,6 +46,45 @@ var syncingCmd = &cobra.Command{
 	},
 }
 
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Run a full sync with the hishtory backend and report what changed",
+	Long:  "Pushes any history entries and deletion requests that were queued while this device was offline, then pulls new history entries and deletion requests from your other devices. Syncing also happens implicitly when querying, this just forces it to happen now.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		if hctx.GetConf(ctx).IsOffline {
+			lib.CheckFatalError(fmt.Errorf("syncing is disabled for this device, run `hishtory syncing enable` to enable it"))
+		}
+		startTime := time.Now()
+
+		// Push
+		fmt.Println("Pushing queued changes...")
+		lib.CheckFatalError(maybeUploadSkippedHistoryEntries(ctx))
+		lib.CheckFatalError(maybeSubmitPendingDeletionRequests(ctx))
+		numPushed, err := lib.FlushOutbox(ctx, true)
+		lib.CheckFatalError(err)
+		numQueued, err := lib.CountOutbox(ctx)
+		lib.CheckFatalError(err)
+		if numQueued > 0 {
+			lib.CheckFatalError(fmt.Errorf("pushed %d history entries, but failed to push the remaining %d (is this device offline?)", numPushed, numQueued))
+		}
+		fmt.Printf("  Pushed %d history entries\n", numPushed)
+
+		// Pull
+		fmt.Println("Pulling changes from your other devices...")
+		result, err := lib.PullFromRemote(ctx, "sync")
+		if lib.IsOfflineError(ctx, err) {
+			lib.CheckFatalError(fmt.Errorf("failed to reach the hishtory backend (is this device offline?): %w", err))
+		}
+		lib.CheckFatalError(err)
+		fmt.Printf("  Pulled %d history entries (skipped %d conflicting with existing entries)\n", result.NumPulled, result.NumConflicts)
+		fmt.Printf("  Deleted %d history entries per deletion requests\n", result.NumDeleted)
+
+		fmt.Printf("Synced in %s\n", time.Since(startTime).Round(time.Millisecond))
+	},
+}
+
 func switchToOnline(ctx context.Context) error {
 	config := hctx.GetConf(ctx)
 	config.IsOffline = false
@@ -79,4 +119,5 @@ func switchToOffline(ctx context.Context) error {
 
 func init() {
 	rootCmd.AddCommand(syncingCmd)
+	rootCmd.AddCommand(syncCmd)
 }
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var tagCmd = &cobra.Command{
+	Use:     "tag",
+	Short:   "Add, remove, and list user-defined tags on history entries (e.g. deploy or incident-2024-05)",
+	Long:    "Tags are synced to your other devices and can be searched for via the tag: atom, so `hishtory query tag:deploy` lists every command tagged with deploy and `hishtory delete tag:deploy` deletes all of them.",
+	GroupID: GROUP_ID_MANAGEMENT,
+}
+
+var tagAddCmd = &cobra.Command{
+	Use:   "add <tag> [query]",
+	Short: "Tag every entry matching the query (or the last command)",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		tag, err := lib.NormalizeTag(args[0])
+		lib.CheckFatalError(err)
+		entries, err := findEntriesToTag(ctx, strings.Join(args[1:], " "))
+		lib.CheckFatalError(err)
+		_, numChanged, err := lib.UpdateTags(ctx, entries, []string{tag}, nil)
+		lib.CheckFatalError(err)
+		fmt.Printf("Tagged %d entries with %s\n", numChanged, tag)
+	},
+}
+
+var tagRemoveCmd = &cobra.Command{
+	Use:   "remove <tag> [query]",
+	Short: "Remove the tag from every entry matching the query (or from every entry if no query is given)",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		tag, err := lib.NormalizeTag(args[0])
+		lib.CheckFatalError(err)
+		entries, err := lib.Search(ctx, hctx.GetDb(ctx), strings.TrimSpace("tag:"+tag+" "+strings.Join(args[1:], " ")), 0)
+		lib.CheckFatalError(err)
+		_, numChanged, err := lib.UpdateTags(ctx, entries, nil, []string{tag})
+		lib.CheckFatalError(err)
+		fmt.Printf("Removed the tag %s from %d entries\n", tag, numChanged)
+	},
+}
+
+var tagListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List every tag along with the number of entries it is on",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		counts, err := lib.ListTags(hctx.MakeContext())
+		lib.CheckFatalError(err)
+		if len(counts) == 0 {
+			fmt.Println("No entries are tagged, run `hishtory tag add` to tag entries")
+			return
+		}
+		for _, c := range counts {
+			fmt.Printf("%s\t%d\n", c.Tag, c.Count)
+		}
+	},
+}
+
+// Returns every entry matching the query, or the most recent command (skipping `hishtory tag` itself) if the query is
+// empty
+func findEntriesToTag(ctx context.Context, query string) ([]*data.HistoryEntry, error) {
+	if strings.TrimSpace(query) != "" {
+		entries, err := lib.Search(ctx, hctx.GetDb(ctx), query, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("no entries found matching the query %#v", query)
+		}
+		return entries, nil
+	}
+	entries, err := lib.Search(ctx, hctx.GetDb(ctx), "", 10)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(strings.TrimSpace(entry.Command), "hishtory tag ") {
+			return []*data.HistoryEntry{entry}, nil
+		}
+	}
+	return nil, fmt.Errorf("no command found to tag")
+}
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+	tagCmd.AddCommand(tagAddCmd)
+	tagCmd.AddCommand(tagRemoveCmd)
+	tagCmd.AddCommand(tagListCmd)
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+const (
+	TAIL_LOCAL_POLL_INTERVAL  = time.Second
+	TAIL_REMOTE_POLL_INTERVAL = 5 * time.Second
+)
+
+var tailCmd = &cobra.Command{
+	Use:                "tail",
+	Short:              "Stream new history entries matching a query as they are recorded on this or other devices",
+	GroupID:            GROUP_ID_QUERYING,
+	Long:               strings.ReplaceAll(EXAMPLE_QUERIES, "SUBCOMMAND", "tail"),
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		args, outputJson := extractJsonFlag(args)
+		lib.CheckFatalError(tail(ctx, strings.Join(args, " "), outputJson))
+	},
+}
+
+func getMaxRowId(ctx context.Context) (int64, error) {
+	var maxRowId int64
+	err := lib.RetryingDbFunction(func() error {
+		return hctx.GetDb(ctx).Raw("SELECT COALESCE(MAX(rowid), 0) FROM history_entries").Scan(&maxRowId).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the latest history entry: %w", err)
+	}
+	return maxRowId, nil
+}
+
+func tail(ctx context.Context, query string, outputJson bool) error {
+	config := hctx.GetConf(ctx)
+	// Validate the query before we start polling, so that typos are reported immediately
+	_, err := lib.MakeWhereQueryFromSearch(ctx, hctx.GetDb(ctx), query)
+	if err != nil {
+		return err
+	}
+	// Note that we track entries by their rowid rather than their timestamp, so that entries from other devices are
+	// displayed when they're synced to this device even if they were run a while ago.
+	lastRowId, err := getMaxRowId(ctx)
+	if err != nil {
+		return err
+	}
+	lastRemoteRetrieval := time.Time{}
+	for {
+		if !config.IsOffline && time.Since(lastRemoteRetrieval) > TAIL_REMOTE_POLL_INTERVAL {
+			err := lib.RetrieveAdditionalEntriesFromRemote(ctx, "tail")
+			if err != nil && !lib.IsOfflineError(ctx, err) {
+				return err
+			}
+			lastRemoteRetrieval = time.Now()
+		}
+		maxRowId, err := getMaxRowId(ctx)
+		if err != nil {
+			return err
+		}
+		if maxRowId > lastRowId {
+			entries, err := getNewEntries(ctx, query, lastRowId, maxRowId)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if outputJson {
+					err := printJsonEntry(entry)
+					if err != nil {
+						return err
+					}
+					continue
+				}
+				row, err := lib.BuildTableRow(ctx, config.DisplayedColumns, *entry, commandEscaper)
+				if err != nil {
+					return err
+				}
+				fmt.Println(strings.Join(row, "\t"))
+			}
+			lastRowId = maxRowId
+		}
+		time.Sleep(TAIL_LOCAL_POLL_INTERVAL)
+	}
+}
+
+func getNewEntries(ctx context.Context, query string, afterRowId, maxRowId int64) ([]*data.HistoryEntry, error) {
+	tx, err := lib.MakeWhereQueryFromSearch(ctx, hctx.GetDb(ctx), query)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*data.HistoryEntry
+	err = lib.RetryingDbFunction(func() error {
+		return tx.Where("rowid > ? AND rowid <= ?", afterRowId, maxRowId).Order("rowid ASC").Find(&entries).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query for new history entries: %w", err)
+	}
+	// Skip pre-saved entries for commands that are still running, they'll be displayed once they finish
+	finishedEntries := make([]*data.HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.EndTime.UnixMilli() != 0 {
+			finishedEntries = append(finishedEntries, entry)
+		}
+	}
+	return finishedEntries, nil
+}
+
+// Escape multi-line commands so that each entry is printed on a single line
+func commandEscaper(cmd string) string {
+	if !strings.Contains(cmd, "\n") {
+		return cmd
+	}
+	return fmt.Sprintf("%#v", cmd)
+}
+
+func init() {
+	rootCmd.AddCommand(tailCmd)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var takeoutOutput *string
+
+var requestTakeoutCmd = &cobra.Command{
+	Use:     "request-takeout",
+	Short:   "Download and decrypt everything that the backend stores for your account",
+	Long:    "Downloads all of the encrypted history entries that the backend stores for your account, decrypts them locally, and writes them to a file with one JSON-encoded entry per line. Unlike `hishtory export`, this includes exactly what is stored in the backend rather than what is stored on this device.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		entries, err := lib.RequestTakeout(ctx)
+		lib.CheckFatalError(err)
+		f, err := os.OpenFile(*takeoutOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		lib.CheckFatalError(err)
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		enc := json.NewEncoder(w)
+		for _, entry := range entries {
+			lib.CheckFatalError(enc.Encode(entry))
+		}
+		lib.CheckFatalError(w.Flush())
+		fmt.Printf("Wrote %d history entries to %s\n", len(entries), *takeoutOutput)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(requestTakeoutCmd)
+	takeoutOutput = requestTakeoutCmd.Flags().StringP("output", "o", "hishtory-takeout.jsonl", "The file to write the decrypted entries to")
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/spf13/cobra"
+)
+
+var transferPort *int
+
+var transferCmd = &cobra.Command{
+	Use:     "transfer",
+	Short:   "Sync history directly with another device on the same network, without going through any server",
+	Long:    "Syncs history directly between two devices over an encrypted connection, which is useful for offline installs that still want history from multiple machines. Run `hishtory transfer listen` on one device, and then run the printed `hishtory transfer connect` command on the other device. Both devices end up with the history of both devices.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(cmd.Help())
+		os.Exit(1)
+	},
+}
+
+var transferListenCmd = &cobra.Command{
+	Use:   "listen",
+	Short: "Wait for another device to connect and transfer history with it",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		code, err := lib.NewTransferCode()
+		lib.CheckFatalError(err)
+		stats, err := lib.ListenForTransfer(ctx, *transferPort, code, func(addr net.Addr) {
+			port := strconv.Itoa(addr.(*net.TCPAddr).Port)
+			fmt.Println("Waiting for the other device to connect. On the other device, run one of:")
+			for _, ip := range getLocalIps() {
+				fmt.Printf("  hishtory transfer connect %s %s\n", net.JoinHostPort(ip, port), code)
+			}
+		})
+		lib.CheckFatalError(err)
+		fmt.Printf("Sent %d entries and received %d new entries\n", stats.NumSent, stats.NumReceived)
+	},
+}
+
+var transferConnectCmd = &cobra.Command{
+	Use:   "connect <address> <code>",
+	Short: "Connect to a device running `hishtory transfer listen` and transfer history with it",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		stats, err := lib.ConnectForTransfer(ctx, args[0], args[1])
+		lib.CheckFatalError(err)
+		fmt.Printf("Sent %d entries and received %d new entries\n", stats.NumSent, stats.NumReceived)
+	},
+}
+
+// Returns the non-loopback IP addresses of this device, which are the addresses that the other device may be able to
+// connect to
+func getLocalIps() []string {
+	ips := make([]string, 0)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		hctx.GetLogger().Infof("failed to list network interfaces: %v", err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP.String())
+	}
+	if len(ips) == 0 {
+		// Fall back to a placeholder so that the user at least sees the command to run
+		ips = append(ips, "<this-device's-ip>")
+	}
+	return ips
+}
+
+func init() {
+	rootCmd.AddCommand(transferCmd)
+	transferCmd.AddCommand(transferListenCmd)
+	transferCmd.AddCommand(transferConnectCmd)
+	transferPort = transferListenCmd.Flags().Int("port", 0, "The port to listen on (defaults to a random port)")
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+)
+
+var trashCmd = &cobra.Command{
+	Use:     "trash",
+	Short:   "Manage the entries deleted in the TUI, which can be restored until they are permanently deleted",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(cmd.Help())
+		os.Exit(1)
+	},
+}
+
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the entries in the trash, most recently deleted first",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		trashed, err := lib.ListTrashedEntries(ctx)
+		lib.CheckFatalError(err)
+		tbl := table.New("ID", "Deleted", "Permanently Deleted", "Command")
+		tbl.WithHeaderFormatter(color.New(color.FgGreen, color.Underline).SprintfFunc())
+		retention := time.Duration(config.TrashRetentionDays) * 24 * time.Hour
+		for _, t := range trashed {
+			tbl.AddRow(t.TrashId, t.TrashedAt.Local().Format(config.TimestampFormat), t.TrashedAt.Add(retention).Local().Format(config.TimestampFormat), t.Entry.Command)
+		}
+		tbl.Print()
+	},
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore [id...]",
+	Short: "Restore the given entries from the trash (defaults to the most recently deleted entry)",
+	Args:  cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		if len(args) == 0 {
+			restored, err := lib.RestoreMostRecentlyTrashedEntry(ctx)
+			lib.CheckFatalError(err)
+			if restored == nil {
+				fmt.Println("The trash is empty")
+				return
+			}
+			fmt.Printf("Restored %#v\n", restored.Entry.Command)
+			return
+		}
+		restored, err := lib.RestoreTrashedEntries(ctx, args)
+		lib.CheckFatalError(err)
+		fmt.Printf("Restored %d entries\n", len(restored))
+	},
+}
+
+var trashEmptyCmd = &cobra.Command{
+	Use:   "empty",
+	Short: "Permanently delete every entry in the trash, including on all of your other devices",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		trashed, err := lib.ListTrashedEntries(ctx)
+		lib.CheckFatalError(err)
+		if len(trashed) == 0 {
+			fmt.Println("The trash is empty")
+			return
+		}
+		if !*forceEmptyTrash {
+			fmt.Printf("This will permanently delete %d entries from the trash on all of your devices, are you sure? [y/N]", len(trashed))
+			reader := bufio.NewReader(os.Stdin)
+			resp, err := reader.ReadString('\n')
+			lib.CheckFatalError(err)
+			if strings.TrimSpace(resp) != "y" {
+				fmt.Printf("Aborting emptying the trash per user response of %#v\n", strings.TrimSpace(resp))
+				return
+			}
+		}
+		numDeleted, err := lib.EmptyTrash(ctx, time.Now())
+		lib.CheckFatalError(err)
+		fmt.Printf("Permanently deleted %d entries\n", numDeleted)
+	},
+}
+
+var forceEmptyTrash *bool
+
+func init() {
+	rootCmd.AddCommand(trashCmd)
+	trashCmd.AddCommand(trashListCmd)
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashCmd.AddCommand(trashEmptyCmd)
+	forceEmptyTrash = trashEmptyCmd.Flags().Bool("force", false, "Don't prompt for confirmation before emptying the trash")
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/client/tui"
+	"github.com/spf13/cobra"
+)
+
+var tuiTmuxPopup *bool
+
+var tuiCmd = &cobra.Command{
+	Use:     "tui [query]",
+	Short:   "Interactively query your shell history in a TUI interface, optionally in a tmux popup",
+	Long:    "With --tmux-popup, the TUI is opened in a tmux popup and the selected command is pasted into the pane that it was opened from. For example, bind it to prefix+r with:\n\n    bind-key r run-shell -b '~/.hishtory/hishtory tui --tmux-popup'",
+	GroupID: GROUP_ID_QUERYING,
+	Run: func(cmd *cobra.Command, args []string) {
+		query := strings.Join(args, " ")
+		if *tuiTmuxPopup {
+			lib.CheckFatalError(runTuiInTmuxPopup(query))
+			return
+		}
+		ctx := hctx.MakeContext()
+		shellName := "bash"
+		if os.Getenv("HISHTORY_SHELL_NAME") != "" {
+			shellName = os.Getenv("HISHTORY_SHELL_NAME")
+		}
+		lib.CheckFatalError(tui.TuiQuery(ctx, shellName, query))
+	},
+}
+
+// The shells that hishtory integrates with, used to detect which shell a tmux pane is running
+var supportedShells = []string{"bash", "zsh", "fish", "pwsh", "nu"}
+
+func runTuiInTmuxPopup(query string) error {
+	pane, err := lib.GetActiveTmuxPane()
+	if err != nil {
+		return fmt.Errorf("--tmux-popup requires running inside of tmux: %w", err)
+	}
+	// The popup doesn't inherit the pane's environment, so detect the shell from the command running in the pane
+	shellName := "bash"
+	for _, s := range supportedShells {
+		if strings.TrimPrefix(pane.Command, "-") == s {
+			shellName = s
+		}
+	}
+	outputFile, err := os.CreateTemp("", "hishtory-tmux-popup")
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary file for the selected command: %w", err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+	binaryPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the hishtory binary: %w", err)
+	}
+	// The TUI is rendered on stderr, so only the selected command is written to the output file
+	popupCommand := fmt.Sprintf("HISHTORY_SHELL_NAME=%s %s tui -- %s > %s", shellName, lib.ShellQuote(binaryPath), lib.ShellQuote(query), lib.ShellQuote(outputFile.Name()))
+	out, err := exec.Command("tmux", "display-popup", "-E", "-w", "90%", "-h", "80%", "-d", pane.Cwd, popupCommand).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to open tmux popup (output=%#v): %w", string(out), err)
+	}
+	selected, err := os.ReadFile(outputFile.Name())
+	if err != nil {
+		return fmt.Errorf("failed to read the selected command: %w", err)
+	}
+	selectedCommand := strings.TrimSuffix(string(selected), "\n")
+	if selectedCommand == "" {
+		// The TUI was closed without selecting a command
+		return nil
+	}
+	return lib.PasteIntoTmuxPane(pane.Id, selectedCommand)
+}
+
+func init() {
+	rootCmd.AddCommand(tuiCmd)
+	tuiTmuxPopup = tuiCmd.Flags().Bool("tmux-popup", false, "Open the TUI in a tmux popup and paste the selected command into the current tmux pane")
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,7 +13,6 @@ import (
 	"path"
 	"runtime"
 	"strings"
-	"syscall"
 
 	"github.com/ddworken/hishtory/client/data"
 	"github.com/ddworken/hishtory/client/hctx"
@@ -21,11 +21,21 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	updateChannel  *string
+	updateRollback *bool
+)
+
 var updateCmd = &cobra.Command{
 	Use:   "update",
 	Short: "Securely update hishtory to the latest version",
 	Run: func(cmd *cobra.Command, args []string) {
-		lib.CheckFatalError(update(hctx.MakeContext()))
+		ctx := hctx.MakeContext()
+		if *updateRollback {
+			lib.CheckFatalError(rollback(ctx))
+			return
+		}
+		lib.CheckFatalError(update(ctx, *updateChannel))
 	},
 }
 
@@ -51,7 +61,19 @@ var validateBinaryCmd = &cobra.Command{
 }
 
 func GetDownloadData(ctx context.Context) (shared.UpdateInfo, error) {
-	respBody, err := lib.ApiGet(ctx, "/api/v1/download")
+	return getDownloadDataForChannel(ctx, shared.UpdateChannelStable)
+}
+
+func getDownloadDataForChannel(ctx context.Context, channel string) (shared.UpdateInfo, error) {
+	url := "/api/v1/download"
+	switch channel {
+	case shared.UpdateChannelStable:
+	case shared.UpdateChannelBeta:
+		url += "?channel=" + channel
+	default:
+		return shared.UpdateInfo{}, fmt.Errorf("unknown update channel %#v, must be either %#v or %#v", channel, shared.UpdateChannelStable, shared.UpdateChannelBeta)
+	}
+	respBody, err := lib.ApiGet(ctx, url)
 	if err != nil {
 		return shared.UpdateInfo{}, fmt.Errorf("failed to download update info: %w", err)
 	}
@@ -63,9 +85,25 @@ func GetDownloadData(ctx context.Context) (shared.UpdateInfo, error) {
 	return downloadData, nil
 }
 
-func update(ctx context.Context) error {
-	// Download the binary
+// Fetches and caches the release notes for the installed version so that the TUI can show what's new. Failures are
+// only logged since they shouldn't block installing an update.
+func cacheReleaseNotes(ctx context.Context) {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline || !config.ShowWhatsNew {
+		return
+	}
 	downloadData, err := GetDownloadData(ctx)
+	if err == nil {
+		err = lib.CacheReleaseNotes(ctx, downloadData)
+	}
+	if err != nil {
+		hctx.GetLogger().Infof("failed to cache release notes: %v", err)
+	}
+}
+
+func update(ctx context.Context, channel string) error {
+	// Download the binary
+	downloadData, err := getDownloadDataForChannel(ctx, channel)
 	if err != nil {
 		return err
 	}
@@ -73,6 +111,12 @@ func update(ctx context.Context) error {
 		fmt.Printf("Latest version (v0.%s) is already installed\n", lib.Version)
 		return nil
 	}
+	if os.Getenv("HISHTORY_FORCE_CLIENT_VERSION") == "" && isOlderVersion(downloadData.Version, "v0."+lib.Version) {
+		// e.g. switching from the beta channel back to the stable channel, which happens once the next stable release
+		// catches up
+		fmt.Printf("The latest %s version (%s) is older than the installed version (v0.%s), so there is nothing to update\n", channel, downloadData.Version, lib.Version)
+		return nil
+	}
 	err = downloadFiles(downloadData)
 	if err != nil {
 		return err
@@ -92,37 +136,116 @@ func update(ctx context.Context) error {
 		}
 	}
 
+	// Install the new one
+	err = installTmpClient(ctx)
+	if err != nil {
+		return err
+	}
+	newVersion := getPossiblyOverriddenVersion(downloadData)
+	fmt.Printf("Successfully updated hishtory from v0.%s to %s\n", lib.Version, newVersion)
+	if notes := downloadData.ReleaseNotes; notes != nil && notes.Version == newVersion {
+		printReleaseNotes(notes)
+	}
+	fmt.Println("If you run into any issues with the new version, you can run `hishtory update --rollback` to go back to the previous version")
+	return nil
+}
+
+// Reinstalls the version of hishtory that was installed before the last update. The currently installed version is
+// saved in its place, so a rollback can itself be undone by running it again.
+func rollback(ctx context.Context) error {
+	previousBinaryPath := getPreviousBinaryPath(ctx)
+	if _, err := os.Stat(previousBinaryPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("there is no previous version of hishtory to roll back to, since hishtory hasn't been updated via `hishtory update`")
+		}
+		return fmt.Errorf("failed to stat %s: %w", previousBinaryPath, err)
+	}
+	// Delete the file if it already exists, see downloadFile
+	if err := os.Remove(getTmpClientPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", getTmpClientPath(), err)
+	}
+	if err := copyFile(previousBinaryPath, getTmpClientPath()); err != nil {
+		return fmt.Errorf("failed to copy the previous version of hishtory to %s: %w", getTmpClientPath(), err)
+	}
+	if err := installTmpClient(ctx); err != nil {
+		return err
+	}
+	fmt.Printf("Successfully rolled back hishtory from v0.%s to the previously installed version\n", lib.Version)
+	return nil
+}
+
+// The path where the previously installed binary is saved so that updates can be rolled back
+func getPreviousBinaryPath(ctx context.Context) string {
+	return path.Join(hctx.GetHome(ctx), data.GetHishtoryPath(), "previous-"+lib.GetHishtoryBinaryName())
+}
+
+// Saves the installed binary so that it can be rolled back to, and then installs the binary at getTmpClientPath()
+func installTmpClient(ctx context.Context) error {
+	binaryPath := path.Join(hctx.GetHome(ctx), data.GetHishtoryPath(), lib.GetHishtoryBinaryName())
+	if err := copyFile(binaryPath, getPreviousBinaryPath(ctx)); err != nil {
+		// Not being able to roll back shouldn't block installing the update
+		hctx.GetLogger().Infof("failed to save %s for rollbacks: %v", binaryPath, err)
+	}
+
 	// Unlink the existing binary so we can overwrite it even though it is still running
-	if runtime.GOOS == "linux" {
-		homedir := hctx.GetHome(ctx)
-		err = syscall.Unlink(path.Join(homedir, data.GetHishtoryPath(), "hishtory"))
+	if runtime.GOOS == "linux" || runtime.GOOS == "windows" {
+		err := lib.RemoveBinaryForReplacement(binaryPath)
 		if err != nil {
-			return fmt.Errorf("failed to unlink %s for update: %w", path.Join(homedir, data.GetHishtoryPath(), "hishtory"), err)
+			return fmt.Errorf("failed to unlink %s for update: %w", binaryPath, err)
 		}
 	}
 
-	// Install the new one
-	cmd := exec.Command("chmod", "+x", getTmpClientPath())
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
 	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	err = cmd.Run()
-	if err != nil {
-		return fmt.Errorf("failed to chmod +x the update (stdout=%#v, stderr=%#v): %w", stdout.String(), stderr.String(), err)
+	if runtime.GOOS != "windows" {
+		cmd := exec.Command("chmod", "+x", getTmpClientPath())
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("failed to chmod +x the update (stdout=%#v, stderr=%#v): %w", stdout.String(), stderr.String(), err)
+		}
 	}
-	cmd = exec.Command(getTmpClientPath(), "install")
+	cmd := exec.Command(getTmpClientPath(), "install")
 	cmd.Stdout = os.Stdout
 	stderr = bytes.Buffer{}
 	cmd.Stdin = os.Stdin
-	err = cmd.Run()
+	err := cmd.Run()
 	if err != nil {
 		return fmt.Errorf("failed to install update (stderr=%#v), is %s in a noexec directory? (if so, set the TMPDIR environment variable): %w", stderr.String(), getTmpClientPath(), err)
 	}
-	fmt.Printf("Successfully updated hishtory from v0.%s to %s\n", lib.Version, getPossiblyOverriddenVersion(downloadData))
 	return nil
 }
 
+// Whether version is older than otherVersion. Versions that can't be parsed (e.g. dev builds) aren't considered older.
+func isOlderVersion(version, otherVersion string) bool {
+	pv, err := shared.ParseVersionString(version)
+	if err != nil {
+		return false
+	}
+	other, err := shared.ParseVersionString(otherVersion)
+	if err != nil {
+		return false
+	}
+	return pv.LessThan(other)
+}
+
+func printReleaseNotes(notes *shared.ReleaseNotes) {
+	if len(notes.NewFeatures) > 0 {
+		fmt.Println("\nNew features:")
+		for _, feature := range notes.NewFeatures {
+			fmt.Printf("  - %s\n", feature)
+		}
+	}
+	if len(notes.ChangedDefaults) > 0 {
+		fmt.Println("\nChanged defaults:")
+		for _, changed := range notes.ChangedDefaults {
+			fmt.Printf("  - %s\n", changed)
+		}
+	}
+	fmt.Println()
+}
+
 func verifyBinaryMac(ctx context.Context, binaryPath string, downloadData shared.UpdateInfo) error {
 	// On Mac, binary verification is a bit more complicated since mac binaries are code
 	// signed. To verify a signed binary, we:
@@ -237,12 +360,21 @@ func downloadFiles(updateInfo shared.UpdateInfo) error {
 	} else if runtime.GOOS == "linux" && runtime.GOARCH == "arm" {
 		clientUrl = updateInfo.LinuxArm7Url
 		clientProvenanceUrl = updateInfo.LinuxArm7AttestationUrl
+	} else if runtime.GOOS == "freebsd" && runtime.GOARCH == "amd64" {
+		clientUrl = updateInfo.FreebsdAmd64Url
+		clientProvenanceUrl = updateInfo.FreebsdAmd64AttestationUrl
 	} else if runtime.GOOS == "darwin" && runtime.GOARCH == "amd64" {
 		clientUrl = updateInfo.DarwinAmd64Url
 		clientProvenanceUrl = updateInfo.DarwinAmd64AttestationUrl
 	} else if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
 		clientUrl = updateInfo.DarwinArm64Url
 		clientProvenanceUrl = updateInfo.DarwinArm64AttestationUrl
+	} else if runtime.GOOS == "windows" && runtime.GOARCH == "amd64" {
+		clientUrl = updateInfo.WindowsAmd64Url
+		clientProvenanceUrl = updateInfo.WindowsAmd64AttestationUrl
+	} else if runtime.GOOS == "windows" && runtime.GOARCH == "arm64" {
+		clientUrl = updateInfo.WindowsArm64Url
+		clientProvenanceUrl = updateInfo.WindowsArm64AttestationUrl
 	} else {
 		return fmt.Errorf("no update info found for GOOS=%s, GOARCH=%s", runtime.GOOS, runtime.GOARCH)
 	}
@@ -270,6 +402,10 @@ func getPossiblyOverriddenVersion(updateInfo shared.UpdateInfo) string {
 
 func getTmpClientPath() string {
 	tmpDir := "/tmp/"
+	if runtime.GOOS == "windows" {
+		// Windows has no /tmp/, and executables must have the .exe extension
+		return path.Join(os.TempDir(), "hishtory-client.exe")
+	}
 	if os.Getenv("TMPDIR") != "" {
 		tmpDir = os.Getenv("TMPDIR")
 	}
@@ -314,6 +450,8 @@ func downloadFile(filename, url string) error {
 
 func init() {
 	rootCmd.AddCommand(updateCmd)
+	updateChannel = updateCmd.Flags().String("channel", shared.UpdateChannelStable, "The release channel to update from, either stable or beta")
+	updateRollback = updateCmd.Flags().Bool("rollback", false, "Reinstall the version of hishtory that was installed before the last update")
 	rootCmd.AddCommand(validateBinaryCmd)
 	validateBinaryCmd.PersistentFlags().Bool("is_macos", false, "Whether the binary we are validating is for MacOS")
 	validateBinaryCmd.PersistentFlags().String("macos_unsigned_binary", "", "The path to the unsigned MacOS binary, if is_macos=true")
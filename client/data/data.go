@@ -20,6 +20,7 @@ import (
 const (
 	KdfUserID        = "user_id"
 	KdfEncryptionKey = "encryption_key"
+	KdfFieldHashKey  = "field_hash_key"
 	CONFIG_PATH      = ".hishtory.config"
 	DB_PATH          = ".hishtory.db"
 )
@@ -28,6 +29,18 @@ const (
 	defaultHishtoryPath = ".hishtory"
 )
 
+// The version of the format of encrypted history entries produced by this client. Entries with a newer payload version
+// are rejected rather than potentially being misinterpreted.
+//
+//   - Version 0: Every field is only stored encrypted
+//   - Version 1: Fields may additionally be stored as keyed hashes, per the user's configured HashedFields
+const CurrentPayloadVersion = 1
+
+// The fields of a history entry that the user can opt into storing hashed server-side. Hashed fields are still
+// encrypted so that other devices can read them, the hash only lets the backend tell when two entries have the same
+// value for the field.
+var HashableFields = []string{"command", "cwd", "hostname"}
+
 type HistoryEntry struct {
 	LocalUsername           string        `json:"local_username" gorm:"uniqueIndex:compositeindex"`
 	Hostname                string        `json:"hostname" gorm:"uniqueIndex:compositeindex"`
@@ -39,7 +52,74 @@ type HistoryEntry struct {
 	EndTime                 time.Time     `json:"end_time" gorm:"uniqueIndex:compositeindex,index:end_time_index"`
 	DeviceId                string        `json:"device_id" gorm:"uniqueIndex:compositeindex"`
 	EntryId                 string        `json:"entry_id" gorm:"uniqueIndex:compositeindex,uniqueIndex:entry_id_index"`
+	SessionId               string        `json:"session_id"`
+	GitRepo                 string        `json:"git_repo"`
+	GitBranch               string        `json:"git_branch"`
+	TmuxSession             string        `json:"tmux_session"`
+	TmuxWindow              string        `json:"tmux_window"`
+	TmuxPane                string        `json:"tmux_pane"`
+	RemoteHost              string        `json:"remote_host"`
+	ContainerId             string        `json:"container_id"`
 	CustomColumns           CustomColumns `json:"custom_columns"`
+	Favorite                bool          `json:"favorite"`
+	Tags                    Tags          `json:"tags"`
+	// The command as normalized by lib.NormalizeCommand, so that effectively identical commands can be grouped together
+	// when ranking. This is only stored locally, and is filled in lazily (see lib.BackfillNormalizedCommands).
+	NormalizedCommand string `json:"-" gorm:"index:normalized_command_index"`
+}
+
+// A history entry that failed to upload (e.g. because the device was offline) and is queued to be uploaded later. The
+// entry itself is looked up from the history_entries table when the outbox is flushed.
+type OutboxEntry struct {
+	EntryId         string    `gorm:"primaryKey"`
+	Attempts        int       `json:"attempts"`
+	NextAttemptTime time.Time `json:"next_attempt_time"`
+}
+
+// A history entry that was deleted in the TUI. Deleted entries are moved to the trash (and out of history_entries) so
+// that the deletion can be undone, and the deletion is only sent to other devices once the entry is removed from the
+// trash. TrashId is the entry's ID (or a random ID for very old entries that don't have one).
+type TrashedEntry struct {
+	TrashId   string       `gorm:"primaryKey"`
+	Entry     HistoryEntry `gorm:"serializer:json"`
+	TrashedAt time.Time    `json:"trashed_at"`
+}
+
+// A search query that was run in the TUI, so that previous queries can be cycled through. Search queries are only
+// stored locally and are never synced to other devices.
+type SearchQuery struct {
+	Query    string    `gorm:"primaryKey"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// A command saved under a name via `hishtory snippet save`, as a lightweight personal runbook. Snippets are only stored
+// locally and are never synced to other devices.
+type Snippet struct {
+	Name      string    `json:"name" gorm:"primaryKey"`
+	Command   string    `json:"command"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// The AI suggestions that were previously returned for a query, so that repeated queries don't re-query the AI and so
+// that suggestions are still available when the AI endpoint is unreachable. Only stored locally.
+type CachedAiSuggestions struct {
+	// A hash of the normalized query and everything else that affects the suggestions (e.g. the shell and the model)
+	Key         string    `gorm:"primaryKey"`
+	Suggestions []string  `gorm:"serializer:json"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Feedback on whether an AI suggestion was accepted (i.e. selected in the TUI) or rejected, recorded when enabled via
+// `hishtory config-set ai-feedback`. Only stored locally, although aggregate counts may be reported to the backend.
+type AiFeedback struct {
+	Id         uint      `json:"id" gorm:"primaryKey"`
+	Provider   string    `json:"provider"`
+	Query      string    `json:"query"`
+	Suggestion string    `json:"suggestion"`
+	Accepted   bool      `json:"accepted"`
+	Timestamp  time.Time `json:"timestamp"`
+	// Whether this feedback has been included in an aggregate report sent to the backend
+	Reported bool `json:"reported"`
 }
 
 type CustomColumns []CustomColumn
@@ -62,6 +142,29 @@ func (c CustomColumns) Value() (driver.Value, error) {
 	return json.Marshal(c)
 }
 
+// User-defined tags on a history entry (e.g. "deploy"), stored as a JSON list so that they can be searched via json_each
+type Tags []string
+
+func (t *Tags) Scan(value any) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	default:
+		return fmt.Errorf("failed to unmarshal Tags value %#v", value)
+	}
+}
+
+func (t Tags) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	// Stored as text rather than a blob since SQLite's JSON functions don't accept blobs
+	bytes, err := json.Marshal(t)
+	return string(bytes), err
+}
+
 func (h *HistoryEntry) GoString() string {
 	return fmt.Sprintf("%#v", *h)
 }
@@ -122,7 +225,21 @@ func Decrypt(userSecret string, data, additionalData, nonce []byte) ([]byte, err
 	return plaintext, nil
 }
 
+// Returns a keyed hash of the given field value. Since it is keyed by the user secret, the backend can compare hashes
+// but can't brute force the values behind them.
+func HashField(userSecret, field, value string) string {
+	h := hmac.New(sha256.New, sha256hmac(userSecret, KdfFieldHashKey))
+	h.Write([]byte(field + ":" + value))
+	return base64.URLEncoding.EncodeToString(h.Sum(nil))
+}
+
 func EncryptHistoryEntry(userSecret string, entry HistoryEntry) (shared.EncHistoryEntry, error) {
+	return EncryptHistoryEntryWithHashedFields(userSecret, entry, nil)
+}
+
+// Encrypts the given entry, and additionally stores keyed hashes of the given fields (see HashableFields) so that they
+// can be queried by the backend
+func EncryptHistoryEntryWithHashedFields(userSecret string, entry HistoryEntry, hashedFields []string) (shared.EncHistoryEntry, error) {
 	data, err := json.Marshal(entry)
 	if err != nil {
 		return shared.EncHistoryEntry{}, err
@@ -131,20 +248,37 @@ func EncryptHistoryEntry(userSecret string, entry HistoryEntry) (shared.EncHisto
 	if err != nil {
 		return shared.EncHistoryEntry{}, err
 	}
-	return shared.EncHistoryEntry{
-		EncryptedData: ciphertext,
-		Nonce:         nonce,
-		UserId:        UserId(userSecret),
-		Date:          entry.EndTime,
-		EncryptedId:   entry.EntryId,
-		ReadCount:     0,
-	}, nil
+	encEntry := shared.EncHistoryEntry{
+		EncryptedData:  ciphertext,
+		Nonce:          nonce,
+		UserId:         UserId(userSecret),
+		Date:           entry.EndTime,
+		EncryptedId:    entry.EntryId,
+		ReadCount:      0,
+		PayloadVersion: CurrentPayloadVersion,
+	}
+	for _, field := range hashedFields {
+		switch field {
+		case "command":
+			encEntry.HashedCommand = HashField(userSecret, field, entry.Command)
+		case "cwd":
+			encEntry.HashedCwd = HashField(userSecret, field, entry.CurrentWorkingDirectory)
+		case "hostname":
+			encEntry.HashedHostname = HashField(userSecret, field, entry.Hostname)
+		default:
+			return shared.EncHistoryEntry{}, fmt.Errorf("cannot hash unknown field %#v, must be one of %v", field, HashableFields)
+		}
+	}
+	return encEntry, nil
 }
 
 func DecryptHistoryEntry(userSecret string, entry shared.EncHistoryEntry) (HistoryEntry, error) {
 	if entry.UserId != UserId(userSecret) {
 		return HistoryEntry{}, fmt.Errorf("refusing to decrypt history entry with mismatching UserId")
 	}
+	if entry.PayloadVersion > CurrentPayloadVersion {
+		return HistoryEntry{}, fmt.Errorf("refusing to decrypt history entry with unsupported payload version %d, please update hishtory via `hishtory update`", entry.PayloadVersion)
+	}
 	plaintext, err := Decrypt(userSecret, entry.EncryptedData, []byte(UserId(userSecret)), entry.Nonce)
 	if err != nil {
 		return HistoryEntry{}, nil
@@ -160,6 +294,26 @@ func DecryptHistoryEntry(userSecret string, entry shared.EncHistoryEntry) (Histo
 	return decryptedEntry, nil
 }
 
+// Device names are bound to the device ID so that the backend can't swap the names of two devices
+func deviceNameAdditionalData(userSecret, deviceId string) []byte {
+	return []byte(UserId(userSecret) + ":" + deviceId)
+}
+
+func EncryptDeviceName(userSecret, deviceId, name string) ([]byte, []byte, error) {
+	return Encrypt(userSecret, []byte(name), deviceNameAdditionalData(userSecret, deviceId))
+}
+
+func DecryptDeviceName(userSecret string, device shared.DeviceInfo) (string, error) {
+	if len(device.EncryptedName) == 0 {
+		return "", nil
+	}
+	name, err := Decrypt(userSecret, device.EncryptedName, deviceNameAdditionalData(userSecret, device.DeviceId), device.NameNonce)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt the name of device %s: %w", device.DeviceId, err)
+	}
+	return string(name), nil
+}
+
 func GetHishtoryPath() string {
 	hishtoryPath := os.Getenv("HISHTORY_PATH")
 	if hishtoryPath != "" {
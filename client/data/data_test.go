@@ -59,3 +59,42 @@ func TestCustomColumnSerialization(t *testing.T) {
 	}
 
 }
+
+func TestEncryptHistoryEntryWithHashedFields(t *testing.T) {
+	entry := HistoryEntry{Command: "ls", CurrentWorkingDirectory: "/tmp/", Hostname: "server", EntryId: "id1"}
+
+	// By default, nothing is hashed
+	encEntry, err := EncryptHistoryEntry("key", entry)
+	checkError(t, err)
+	if encEntry.PayloadVersion != CurrentPayloadVersion || encEntry.HashedCommand != "" || encEntry.HashedCwd != "" || encEntry.HashedHostname != "" {
+		t.Fatalf("unexpected default encrypted entry: %#v", encEntry)
+	}
+
+	// Hashes are deterministic per user secret so that the backend can compare them
+	encEntry, err = EncryptHistoryEntryWithHashedFields("key", entry, []string{"command", "hostname"})
+	checkError(t, err)
+	if encEntry.HashedCommand != HashField("key", "command", "ls") || encEntry.HashedHostname != HashField("key", "hostname", "server") || encEntry.HashedCwd != "" {
+		t.Fatalf("unexpected hashed fields: %#v", encEntry)
+	}
+	if HashField("key", "command", "ls") == HashField("otherkey", "command", "ls") || HashField("key", "command", "ls") == HashField("key", "hostname", "ls") {
+		t.Fatalf("expected hashes to depend on the user secret and the field")
+	}
+
+	// Hashed fields are still encrypted
+	decEntry, err := DecryptHistoryEntry("key", encEntry)
+	checkError(t, err)
+	if decEntry.Command != "ls" || decEntry.Hostname != "server" {
+		t.Fatalf("unexpected decrypted entry: %#v", decEntry)
+	}
+
+	// Unknown fields and payload versions are rejected
+	_, err = EncryptHistoryEntryWithHashedFields("key", entry, []string{"exit_code"})
+	if err == nil {
+		t.Fatalf("expected an error when hashing an unknown field")
+	}
+	encEntry.PayloadVersion = CurrentPayloadVersion + 1
+	_, err = DecryptHistoryEntry("key", encEntry)
+	if err == nil {
+		t.Fatalf("expected an error when decrypting an unsupported payload version")
+	}
+}
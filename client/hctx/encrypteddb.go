@@ -0,0 +1,282 @@
+package hctx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	// Needed to use sqlite without CGO
+	"github.com/glebarez/sqlite"
+)
+
+// When the local DB is encrypted at rest (see ClientConfig.EncryptLocalDb), the DB that hishtory reads and writes is a
+// decrypted working copy that is stored in a memory-backed directory, so that the plaintext never touches the disk. The
+// only copy on disk is an encrypted snapshot of the working copy, which is updated whenever the working copy changes
+// and is decrypted again to recreate the working copy after a reboot.
+const encryptedDbSuffix = ".enc"
+
+// The additional data for the encrypted snapshot, so that it can't be confused with other ciphertexts derived from the
+// user secret
+var encryptedDbAdditionalData = []byte("hishtory-local-db")
+
+// The minimum time between updates of the encrypted snapshot, since each update copies and re-encrypts the whole DB.
+// Since changes made since the last snapshot are lost if the decrypted working copy is cleared (e.g. by a reboot or a
+// logout), this only applies to writes that aren't write-through (see UpdateEncryptedDbSnapshot).
+const encryptedDbSnapshotInterval = time.Minute
+
+// Returns the path of the encrypted snapshot of the local DB
+func GetEncryptedDbPath(homedir string) string {
+	return path.Join(homedir, data.GetHishtoryPath(), data.DB_PATH+encryptedDbSuffix)
+}
+
+// Returns the path of the decrypted working copy of the local DB, which is in the user's runtime directory (e.g.
+// /run/user/1000/) since it is memory-backed and only accessible by the user
+func GetDecryptedDbPath() (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", fmt.Errorf("encrypting the local DB is currently only supported on Linux, since it requires a memory-backed directory for the decrypted DB")
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = path.Join("/run/user", strconv.Itoa(os.Getuid()))
+		if _, err := os.Stat(runtimeDir); err != nil {
+			return "", fmt.Errorf("encrypting the local DB requires a memory-backed runtime directory, but XDG_RUNTIME_DIR isn't set and %s doesn't exist", runtimeDir)
+		}
+	}
+	dir := path.Join(runtimeDir, "hishtory")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create %s for the decrypted DB: %w", dir, err)
+	}
+	return path.Join(dir, data.DB_PATH), nil
+}
+
+// Returns the path of the decrypted working copy of the local DB, and first recreates it from the encrypted snapshot if
+// it doesn't exist (e.g. after a reboot)
+func prepareDecryptedDb(homedir, userSecret string) (string, error) {
+	decryptedPath, err := GetDecryptedDbPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(decryptedPath); err == nil {
+		return decryptedPath, nil
+	}
+	snapshot, err := os.ReadFile(GetEncryptedDbPath(homedir))
+	if errors.Is(err, os.ErrNotExist) {
+		// There is no snapshot yet, so start with an empty DB
+		return decryptedPath, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the encrypted DB: %w", err)
+	}
+	plaintext, err := decryptDbSnapshot(userSecret, snapshot)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(path.Dir(decryptedPath), "decrypting-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create a temporary file for the decrypted DB: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(plaintext); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write the decrypted DB: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write the decrypted DB: %w", err)
+	}
+	// Linking (rather than renaming) fails if another process concurrently created the working copy, in which case its
+	// copy (which it may have already written to) is kept
+	if err := os.Link(f.Name(), decryptedPath); err != nil && !errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("failed to create the decrypted DB: %w", err)
+	}
+	return decryptedPath, nil
+}
+
+func encryptDbSnapshot(userSecret string, plaintext []byte) ([]byte, error) {
+	ciphertext, nonce, err := data.Encrypt(userSecret, plaintext, encryptedDbAdditionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt the local DB: %w", err)
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+func decryptDbSnapshot(userSecret string, snapshot []byte) ([]byte, error) {
+	// The snapshot is the 12 byte nonce followed by the ciphertext, see data.Encrypt
+	if len(snapshot) < 12 {
+		return nil, fmt.Errorf("the encrypted DB is truncated")
+	}
+	plaintext, err := data.Decrypt(userSecret, snapshot[12:], encryptedDbAdditionalData, snapshot[:12])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the local DB (was your secret changed?): %w", err)
+	}
+	return plaintext, nil
+}
+
+// Returns the time that the DB at the given path was last modified, including changes that are only in its WAL
+func dbModTime(dbPath string) time.Time {
+	var modTime time.Time
+	for _, p := range []string{dbPath, dbPath + "-wal"} {
+		if stat, err := os.Stat(p); err == nil && stat.ModTime().After(modTime) {
+			modTime = stat.ModTime()
+		}
+	}
+	return modTime
+}
+
+// Writes an encrypted snapshot of the DB at dbPath, which must be the decrypted working copy unless the local DB is
+// being converted to or from being encrypted
+func WriteEncryptedDbSnapshot(homedir, userSecret, dbPath string) error {
+	tmpDir := path.Dir(dbPath)
+	snapshotPath := path.Join(tmpDir, "snapshot-"+strconv.Itoa(os.Getpid())+"-"+strconv.FormatInt(time.Now().UnixNano(), 10))
+	defer os.Remove(snapshotPath)
+	// VACUUM INTO creates a consistent copy even if other processes are concurrently writing to the DB
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=ro", filepath.ToSlash(dbPath))), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return fmt.Errorf("failed to open the local DB to snapshot it: %w", err)
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDb.Close()
+	if err := db.Exec("VACUUM INTO ?", snapshotPath).Error; err != nil {
+		return fmt.Errorf("failed to snapshot the local DB: %w", err)
+	}
+	plaintext, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to read the snapshot of the local DB: %w", err)
+	}
+	snapshot, err := encryptDbSnapshot(userSecret, plaintext)
+	if err != nil {
+		return err
+	}
+	encryptedPath := GetEncryptedDbPath(homedir)
+	tmpEncryptedPath := encryptedPath + ".tmp-" + strconv.Itoa(os.Getpid())
+	if err := os.WriteFile(tmpEncryptedPath, snapshot, 0o600); err != nil {
+		return fmt.Errorf("failed to write the encrypted DB: %w", err)
+	}
+	if err := os.Rename(tmpEncryptedPath, encryptedPath); err != nil {
+		os.Remove(tmpEncryptedPath)
+		return fmt.Errorf("failed to write the encrypted DB: %w", err)
+	}
+	return nil
+}
+
+// Updates the encrypted snapshot of the local DB if the local DB is encrypted and was modified since the last snapshot.
+// This is called after every command. If writeThrough is true (e.g. after saving a history entry, which can't be
+// recovered from anywhere else), the snapshot is always updated, and otherwise it is updated at most once per
+// encryptedDbSnapshotInterval.
+func UpdateEncryptedDbSnapshot(writeThrough bool) error {
+	config, err := GetConfig()
+	if err != nil || !config.EncryptLocalDb {
+		return nil
+	}
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get user's home directory: %w", err)
+	}
+	decryptedPath, err := GetDecryptedDbPath()
+	if err != nil {
+		return err
+	}
+	modTime := dbModTime(decryptedPath)
+	if modTime.IsZero() {
+		return nil
+	}
+	if stat, err := os.Stat(GetEncryptedDbPath(homedir)); err == nil {
+		if !modTime.After(stat.ModTime()) {
+			return nil
+		}
+		if !writeThrough && time.Since(stat.ModTime()) < encryptedDbSnapshotInterval {
+			return nil
+		}
+	}
+	return WriteEncryptedDbSnapshot(homedir, config.localDbSecret(), decryptedPath)
+}
+
+// The secret that the local DB is encrypted with, which is always the top-level user secret (rather than the secret
+// for the selected server environment) since the local DB is shared by all server environments
+func (c *ClientConfig) localDbSecret() string {
+	if c.activeServerEnvironment != "" {
+		return c.defaultUserSecret
+	}
+	return c.UserSecret
+}
+
+// Removes the DB at the given path along with its WAL and shared memory files
+func removeDb(dbPath string) error {
+	for _, p := range []string{dbPath, dbPath + "-wal", dbPath + "-shm"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Copies the DB in the given context to dstPath, which must not already exist
+func copyDb(ctx context.Context, dstPath string) error {
+	if err := removeDb(dstPath); err != nil {
+		return err
+	}
+	if err := GetDb(ctx).Exec("VACUUM INTO ?", dstPath).Error; err != nil {
+		return fmt.Errorf("failed to copy the local DB to %s: %w", dstPath, err)
+	}
+	return nil
+}
+
+// Converts the local DB to or from being encrypted at rest. Afterwards, the DB in the given context must no longer be
+// used since it was replaced.
+func SetLocalDbEncryption(ctx context.Context, enabled bool) error {
+	config := GetConf(ctx)
+	if config.EncryptLocalDb == enabled {
+		return nil
+	}
+	if config.DbPath != "" {
+		return fmt.Errorf("the local DB can't be encrypted since it is stored at the custom path %s", config.DbPath)
+	}
+	if enabled && !isExternalSecretStorage(config) {
+		// Otherwise the key is stored in plaintext right next to the DB that it encrypts
+		return fmt.Errorf("the local DB can't be encrypted while your secret is stored in plaintext in the config file, first store it elsewhere via e.g. `hishtory config-set secret-storage keychain`")
+	}
+	homedir := GetHome(ctx)
+	decryptedPath, err := GetDecryptedDbPath()
+	if err != nil {
+		return err
+	}
+	plaintextPath := path.Join(homedir, data.GetHishtoryPath(), data.DB_PATH)
+	if enabled {
+		if err := copyDb(ctx, decryptedPath); err != nil {
+			return err
+		}
+		if err := WriteEncryptedDbSnapshot(homedir, config.localDbSecret(), decryptedPath); err != nil {
+			return err
+		}
+	} else {
+		if err := copyDb(ctx, plaintextPath); err != nil {
+			return err
+		}
+	}
+	config.EncryptLocalDb = enabled
+	if err := SetConfig(config); err != nil {
+		return err
+	}
+	if sqlDb, err := GetDb(ctx).DB(); err == nil {
+		sqlDb.Close()
+	}
+	if enabled {
+		return removeDb(plaintextPath)
+	}
+	if err := os.Remove(GetEncryptedDbPath(homedir)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove the encrypted DB: %w", err)
+	}
+	return removeDb(decryptedPath)
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -88,8 +90,24 @@ func OpenLocalSqliteDb() (*gorm.DB, error) {
 			Colorful:                  false,
 		},
 	)
-	dbFilePath := path.Join(homedir, data.GetHishtoryPath(), data.DB_PATH)
-	dsn := fmt.Sprintf("file:%s?mode=rwc&_journal_mode=WAL", dbFilePath)
+	dbFilePath := GetDbPath(homedir)
+	if readConfigForDb().EncryptLocalDb {
+		// The full config is needed for the user secret, which may not be stored in the config file
+		config, err := GetConfig()
+		if err != nil {
+			return nil, err
+		}
+		dbFilePath, err = prepareDecryptedDb(homedir, config.localDbSecret())
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = os.MkdirAll(path.Dir(dbFilePath), 0o744)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the directory for the DB: %w", err)
+	}
+	// SQLite URIs require forward slashes, which also works for Windows paths (e.g. file:C:/Users/...)
+	dsn := fmt.Sprintf("file:%s?mode=rwc&_journal_mode=WAL", filepath.ToSlash(dbFilePath))
 	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{SkipDefaultTransaction: true, Logger: newLogger})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to the DB: %w", err)
@@ -103,6 +121,12 @@ func OpenLocalSqliteDb() (*gorm.DB, error) {
 		return nil, err
 	}
 	db.AutoMigrate(&data.HistoryEntry{})
+	db.AutoMigrate(&data.OutboxEntry{})
+	db.AutoMigrate(&data.TrashedEntry{})
+	db.AutoMigrate(&data.SearchQuery{})
+	db.AutoMigrate(&data.Snippet{})
+	db.AutoMigrate(&data.CachedAiSuggestions{})
+	db.AutoMigrate(&data.AiFeedback{})
 	db.Exec("PRAGMA journal_mode = WAL")
 	db.Exec("CREATE INDEX IF NOT EXISTS start_time_index ON history_entries(start_time)")
 	db.Exec("CREATE INDEX IF NOT EXISTS end_time_index ON history_entries(end_time)")
@@ -110,6 +134,58 @@ func OpenLocalSqliteDb() (*gorm.DB, error) {
 	return db, nil
 }
 
+// Returns the path of the local DB for the given channel, which only contains the entries that were published to it
+func GetChannelDbPath(homedir, channelName string) string {
+	return path.Join(homedir, data.GetHishtoryPath(), "channels", channelName+".db")
+}
+
+// Opens the local DB for the given channel, which has the same schema as the local DB so that it can be searched in
+// the same way
+func OpenChannelDb(homedir, channelName string) (*gorm.DB, error) {
+	dbFilePath := GetChannelDbPath(homedir, channelName)
+	err := os.MkdirAll(path.Dir(dbFilePath), 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the directory for the channel DB: %w", err)
+	}
+	dsn := fmt.Sprintf("file:%s?mode=rwc&_journal_mode=WAL", filepath.ToSlash(dbFilePath))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{SkipDefaultTransaction: true, Logger: logger.Discard})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the DB for channel %s: %w", channelName, err)
+	}
+	err = db.AutoMigrate(&data.HistoryEntry{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate the DB for channel %s: %w", channelName, err)
+	}
+	db.Exec("PRAGMA journal_mode = WAL")
+	return db, nil
+}
+
+// Returns the path of the local DB, which is in the hishtory directory unless a different path was configured for an
+// ephemeral install or the local DB is encrypted (in which case this is the decrypted working copy, see encrypteddb.go)
+func GetDbPath(homedir string) string {
+	config := readConfigForDb()
+	if config.DbPath != "" {
+		return config.DbPath
+	}
+	if config.EncryptLocalDb {
+		if decryptedPath, err := GetDecryptedDbPath(); err == nil {
+			return decryptedPath
+		}
+		return GetEncryptedDbPath(homedir)
+	}
+	return path.Join(homedir, data.GetHishtoryPath(), data.DB_PATH)
+}
+
+// Reads the config directly (rather than from a context) since this is needed to open the DB. Errors are ignored
+// since the config doesn't exist yet when hishtory is first installed.
+func readConfigForDb() ClientConfig {
+	var config ClientConfig
+	if dat, err := GetConfigContents(); err == nil {
+		_ = json.Unmarshal(dat, &config)
+	}
+	return config
+}
+
 type hishtoryContextKey string
 
 const (
@@ -146,6 +222,12 @@ func GetConf(ctx context.Context) *ClientConfig {
 	panic(fmt.Errorf("failed to find config in ctx"))
 }
 
+// Returns a copy of ctx that uses the given config. Used by long-lived processes (e.g. the TUI) to pick up config
+// changes without mutating the config that other goroutines may be reading.
+func WithConfig(ctx context.Context, config *ClientConfig) context.Context {
+	return context.WithValue(ctx, ConfigCtxKey, config)
+}
+
 func GetDb(ctx context.Context) *gorm.DB {
 	v := ctx.Value(DbCtxKey)
 	if v != nil {
@@ -173,9 +255,24 @@ type ClientConfig struct {
 	LastPreSavedHistoryLine string `json:"last_presaved_history_line"`
 	// Used for skipping history entries prefixed with a space in bash
 	LastSavedHistoryLine string `json:"last_saved_history_line"`
-	// Used for uploading history entries that we failed to upload due to a missing network connection
+	// Used by older versions for uploading history entries that we failed to upload due to a missing network connection.
+	// These are now queued in the outbox_entries table instead.
 	HaveMissedUploads     bool  `json:"have_missed_uploads"`
 	MissedUploadTimestamp int64 `json:"missed_upload_timestamp"`
+	// The unix timestamp of the last time this device successfully uploaded to or pulled from the backend
+	LastSuccessfulSyncTimestamp int64 `json:"last_successful_sync_timestamp"`
+	// The SequenceId of the last history entry pulled from the backend. Sent with each query so that the backend
+	// returns only the entries after it. Each server environment has its own cursor (see ServerEnvironment.SyncCursor)
+	// since sequence IDs are only meaningful to the backend that assigned them.
+	SyncCursor int64 `json:"sync_cursor"`
+	// The progress of an interrupted upload of all history entries (e.g. after a large import), so that it can be
+	// resumed without re-uploading the chunks that already succeeded. Nil if there is no interrupted upload.
+	ReuploadProgress *ReuploadProgress `json:"reupload_progress"`
+	// The outcome of the most recent periodic maintenance of the local DB, nil if it has never run
+	DbMaintenance *DbMaintenanceResult `json:"db_maintenance"`
+	// The progress of gradually re-encrypting this device's entries in the backend with the current payload format
+	// after it is upgraded. Nil for clients that have never started re-encrypting.
+	Reencryption *ReencryptionProgress `json:"reencryption"`
 	// Used for uploading deletion requests that we failed to upload due to a missed network connection
 	// Note that this is only applicable for deleting pre-saved entries. For interactive deletion, we just
 	// show the user an error message if they're offline.
@@ -192,6 +289,9 @@ type ClientConfig struct {
 	IsOffline bool `json:"is_offline"`
 	// Whether duplicate commands should be displayed
 	FilterDuplicateCommands bool `json:"filter_duplicate_commands"`
+	// Whether commands should be normalized (e.g. collapsing whitespace) before being compared for duplicate filtering.
+	// Note that this never changes the command that is stored or displayed.
+	NormalizeCommands bool `json:"normalize_commands"`
 	// A format string for the timestamp
 	TimestampFormat string `json:"timestamp_format"`
 	// Beta mode, enables unspecified additional beta features
@@ -199,24 +299,226 @@ type ClientConfig struct {
 	BetaMode bool `json:"beta_mode"`
 	// Whether to highlight matches in search results
 	HighlightMatches bool `json:"highlight_matches"`
+	// Whether multi-line commands are displayed across multiple lines in the TUI, rather than being escaped into a
+	// single line
+	MultiLineCommands bool `json:"multi_line_commands"`
 	// Whether to enable AI completion
 	AiCompletion bool `json:"ai_completion"`
 	// Whether to enable presaving
 	EnablePresaving bool `json:"enable_presaving"`
 	// The current color scheme for the TUI
 	ColorScheme ColorScheme `json:"color_scheme"`
-	// A default filter that will be applied to all search queries
+	// Deprecated: The default filter that was applied to all search queries before DefaultFilters was added. This
+	// is migrated into DefaultFilters, and is only still set so that older versions of hishtory keep working.
 	DefaultFilter string `json:"default_filter"`
+	// Default filters that can be cycled between in the TUI, the first of which is applied to all search queries by
+	// default. An empty string means no filter.
+	DefaultFilters []string `json:"default_filters"`
 	// The endpoint to use for AI suggestions
 	AiCompletionEndpoint string `json:"ai_completion_endpoint"`
+	// The provider used for AI suggestions (see ai.ProviderNames). Empty is equivalent to OpenAI.
+	AiProvider string `json:"ai_provider"`
+	// The model used for AI suggestions. Empty to use the provider's default model.
+	AiModel string `json:"ai_model"`
+	// Whether AI queries include context about the environment (the cwd, recent commands, and installed tool versions)
+	AiIncludeContext bool `json:"ai_include_context"`
+	// Whether feedback on AI suggestions is recorded locally ("local"), also reported to the backend as aggregate
+	// counts ("report"), or not recorded at all ("off" or empty)
+	AiFeedback string `json:"ai_feedback"`
 	// Custom key bindings for the TUI
 	KeyBindings keybindings.SerializableKeyMap `json:"key_bindings"`
+	// Whether search results should be ranked so that commands that previously succeeded in the current directory
+	// come first and commands that have only ever failed there come last
+	RankBySuccessInCwd bool `json:"rank_by_success_in_cwd"`
+	// The name of the lib.Ranker used to order search results in the default sort order. If empty, falls back to
+	// RankBySuccessInCwd.
+	Ranker string `json:"ranker"`
+	// Whether search results in the default sort order should always list entries marked as favorites first
+	RankFavoritesFirst bool `json:"rank_favorites_first"`
+	// Whether to print a summary of the commands run in a shell session when it ends
+	ShowSessionSummary bool `json:"show_session_summary"`
+	// Commands starting with this prefix are never recorded, in addition to commands starting with a space. Empty to
+	// only skip commands starting with a space.
+	IgnoredCommandPrefix string `json:"ignored_command_prefix"`
+	// The IDs of shell sessions that recording was suspended for via `hishtory off`
+	IncognitoSessionIds []string `json:"incognito_session_ids"`
+	// Globs for directories that commands are never recorded in (including in their subdirectories)
+	ExcludedCwdGlobs []string `json:"excluded_cwd_globs"`
+	// Regex patterns for commands that are never recorded
+	ExcludedCommandPatterns []string `json:"excluded_command_patterns"`
+	// The fields (see data.HashableFields) that are stored hashed server-side in addition to being encrypted. Empty by
+	// default so that the backend can't learn anything about your history entries.
+	HashedFields []string `json:"hashed_fields"`
+	// Regex patterns for secrets that should be redacted from commands (or cause the command to not be recorded at all)
+	// before they are stored or synced
+	RedactionRules []RedactionRule `json:"redaction_rules"`
+	// Regex patterns for dangerous commands (e.g. `rm -rf /`) that require confirmation before the shell runs them.
+	// Empty to disable the guard hooks.
+	GuardRules []GuardRule `json:"guard_rules"`
+	// Commands that run for at least this many seconds trigger a notification when they finish. 0 to disable.
+	NotifyThresholdSeconds int `json:"notify_threshold_seconds"`
+	// How to notify about long-running commands finishing: desktop (the default), bell, or webhook
+	NotifyMethod string `json:"notify_method"`
+	// The URL that a JSON description of each long-running command is POSTed to when NotifyMethod is webhook
+	NotifyWebhookUrl string `json:"notify_webhook_url"`
+	// Whether to record the git repository and branch that each command was run in
+	RecordGitInfo bool `json:"record_git_info"`
+	// Whether to record the tmux session, window, and pane that each command was run in
+	RecordTmuxInfo bool `json:"record_tmux_info"`
+	// Whether to record the remote host that ssh commands connected to
+	RecordSshHost bool `json:"record_ssh_host"`
+	// Whether this is an ephemeral install (e.g. in a container), which records the container ID of each command and
+	// doesn't bootstrap the device with the existing history
+	IsEphemeral bool `json:"is_ephemeral"`
+	// The path of the local DB if it isn't stored in the hishtory directory, used for ephemeral installs that store it
+	// in memory or in a bind-mounted directory
+	DbPath string `json:"db_path"`
+	// Whether the local DB is encrypted at rest with a key derived from the user secret, see encrypteddb.go
+	EncryptLocalDb bool `json:"encrypt_local_db"`
+	// Where the user secret is stored, one of the SecretStorage* constants. Empty is equivalent to
+	// SecretStorageConfig, see secretstorage.go.
+	SecretStorage string `json:"secret_storage"`
+	// The shell command that prints the user secret (e.g. `pass show hishtory`) if SecretStorage is
+	// SecretStorageCommand
+	SecretCommand string `json:"secret_command"`
+	// Entries older than each of these thresholds (in days) are displayed in progressively dimmer colors in the TUI.
+	// Empty to disable dimming.
+	DimmingThresholdDays []int `json:"dimming_threshold_days"`
+	// Named server environments (e.g. a self-hosted staging server) that can be selected between via
+	// HISHTORY_SERVER_ENV or the --server flag
+	ServerEnvironments map[string]ServerEnvironment `json:"server_environments"`
+	// Whether the TUI should only load the rows around the cursor (via windowed queries) rather than the top results,
+	// so that the entire history can be scrolled through with flat memory usage even for very large histories
+	LargeHistoryMode bool `json:"large_history_mode"`
+	// Whether the TUI should display a time-stratified sample of the results for queries that match a huge number of
+	// entries (rather than loading the top results), so that it stays responsive for queries like single letters
+	ResultSampling bool `json:"result_sampling"`
+	// The number of days that entries deleted in the TUI are kept in the trash (where they can be restored) before they
+	// are permanently deleted on all devices
+	TrashRetentionDays int `json:"trash_retention_days"`
+	// Commands pinned via `hishtory pin`, keyed by the short trigger (e.g. `,deploy`) that they're pinned under
+	PinnedEntries map[string]string `json:"pinned_entries"`
+	// Whether typing a pinned command's trigger at the zsh prompt expands it to the pinned command
+	PinExpansion bool `json:"pin_expansion"`
+	// Whether the TUI should show the release notes for a new version the first time it is opened after updating
+	ShowWhatsNew bool `json:"show_whats_new"`
+	// The release notes for the installed version, cached when it was installed via `hishtory update`. Nil once they
+	// have been displayed.
+	PendingReleaseNotes *shared.ReleaseNotes `json:"pending_release_notes"`
+	// The version whose release notes were last displayed, so that they're only ever displayed once
+	SeenReleaseNotesVersion string `json:"seen_release_notes_version"`
+	// Whether this device uploads its history entries and/or downloads the history entries of other devices. Empty
+	// is equivalent to shared.SyncModeReadWrite.
+	SyncMode shared.SyncMode `json:"sync_mode"`
+	// A cache of the friendly names of devices (keyed by device ID), used for the Device column. Refreshed via
+	// `hishtory device list`.
+	DeviceNames map[string]string `json:"device_names"`
+	// The shared team channels that this device is subscribed to, keyed by the channel name. See `hishtory channel`.
+	Channels map[string]Channel `json:"channels"`
+
+	// The name of the server environment that is currently selected, if any. Not persisted.
+	activeServerEnvironment string
+	// The top-level user secret, used to restore the persisted config when a server environment with its own secret
+	// is selected. Not persisted.
+	defaultUserSecret string
+	// The top-level sync cursor, used to restore the persisted config when a server environment is selected. Not
+	// persisted.
+	defaultSyncCursor int64
+}
+
+type ServerEnvironment struct {
+	// The origin of the hishtory server, e.g. https://hishtory.example.com
+	Hostname string `json:"hostname"`
+	// The user secret to use with this server. If empty, the top-level user secret is used.
+	UserSecret string `json:"user_secret"`
+	// The sync cursor for this server, see ClientConfig.SyncCursor
+	SyncCursor int64 `json:"sync_cursor"`
+}
+
+// A shared team channel. Entries published to the channel are encrypted with the channel's secret (rather than the
+// user secret) and synced to every device that is subscribed to it.
+type Channel struct {
+	// The secret shared by all subscribers of the channel
+	Secret string `json:"secret"`
+	// The device ID that this device is registered under for the channel, which is separate from DeviceId so that the
+	// channel's members can't link it to this device's own history
+	DeviceId string `json:"device_id"`
+	// Regexes for commands that are automatically published to the channel when they're run
+	PublishRules []string `json:"publish_rules"`
+	// The sequence ID of the last entry that was retrieved from the channel
+	SyncCursor int64 `json:"sync_cursor"`
+}
+
+type RedactionRule struct {
+	// The regex to match. If it contains capture groups, only the captured text is redacted.
+	Pattern string `json:"pattern"`
+	// Whether a command matching this pattern should be dropped entirely rather than redacted
+	Drop bool `json:"drop"`
+}
+
+type GuardRule struct {
+	// The regex that commands are matched against
+	Pattern string `json:"pattern"`
+	// An optional explanation that is displayed in the confirmation prompt, e.g. "this deletes the prod database"
+	Message string `json:"message"`
 }
 
 type ColorScheme struct {
 	SelectedText       string
 	SelectedBackground string
 	BorderColor        string
+	// The colors of the table header, of the parts of results that match the query (when highlight-matches is
+	// enabled), of warnings and errors, and of the keys and descriptions in the help bar. Empty to use the terminal's
+	// default color.
+	HeaderText      string
+	MatchHighlight  string
+	WarningText     string
+	ErrorText       string
+	HelpKey         string
+	HelpDescription string
+	// The text style of the parts of results that match the query, one of MATCH_HIGHLIGHT_STYLES
+	MatchHighlightStyle string
+}
+
+// The text styles that can be used for highlighting the parts of results that match the query
+var MATCH_HIGHLIGHT_STYLES = []string{"bold", "underline", "italic", "reverse", "none"}
+
+type ReuploadProgress struct {
+	// A random ID for the upload, which is combined with a hash of each chunk to form the idempotency key that lets the
+	// backend skip chunks that were already applied
+	UploadId string `json:"upload_id"`
+	// The idempotency keys of the chunks that were successfully uploaded
+	CompletedChunks []string `json:"completed_chunks"`
+}
+
+type DbMaintenanceResult struct {
+	// The unix timestamp of when maintenance started
+	Timestamp int64 `json:"timestamp"`
+	// How long maintenance took to run
+	DurationMs int64 `json:"duration_ms"`
+	// The size of the DB file before and after maintenance, in bytes
+	SizeBefore int64 `json:"size_before"`
+	SizeAfter  int64 `json:"size_after"`
+	// The output of the DB's integrity check, which also checks that the indexes match the tables. "ok" if healthy.
+	IntegrityCheck string `json:"integrity_check"`
+	// Whether the indexes were rebuilt since the integrity check failed
+	Reindexed bool `json:"reindexed"`
+	// The error that maintenance failed with, if any
+	Error string `json:"error"`
+}
+
+type ReencryptionProgress struct {
+	// The payload version (see data.CurrentPayloadVersion) that entries are being re-encrypted with
+	PayloadVersion int `json:"payload_version"`
+	// The end time and entry ID of the last re-encrypted entry, so that re-encryption resumes where it left off
+	CursorTime    time.Time `json:"cursor_time"`
+	CursorEntryId string    `json:"cursor_entry_id"`
+	// The number of entries re-encrypted so far
+	NumReencrypted int `json:"num_reencrypted"`
+	// The unix timestamp of when the last batch of entries was re-encrypted, used to throttle re-encryption
+	LastBatchTimestamp int64 `json:"last_batch_timestamp"`
+	// Whether every entry has been re-encrypted with PayloadVersion
+	Done bool `json:"done"`
 }
 
 type CustomColumnDefinition struct {
@@ -247,12 +549,88 @@ func GetConfigContents() ([]byte, error) {
 
 func GetDefaultColorScheme() ColorScheme {
 	return ColorScheme{
-		SelectedBackground: "#3300ff",
-		SelectedText:       "#ffff99",
-		BorderColor:        "#585858",
+		SelectedBackground:  "#3300ff",
+		SelectedText:        "#ffff99",
+		BorderColor:         "#585858",
+		MatchHighlightStyle: "bold",
 	}
 }
 
+// The built-in color schemes that can be selected via `hishtory config-set color-scheme preset`
+var ColorSchemePresets = map[string]ColorScheme{
+	"default": GetDefaultColorScheme(),
+	"solarized-dark": {
+		SelectedText:        "#fdf6e3",
+		SelectedBackground:  "#268bd2",
+		BorderColor:         "#586e75",
+		HeaderText:          "#b58900",
+		MatchHighlight:      "#cb4b16",
+		WarningText:         "#dc322f",
+		ErrorText:           "#dc322f",
+		HelpKey:             "#268bd2",
+		HelpDescription:     "#93a1a1",
+		MatchHighlightStyle: "bold",
+	},
+	"gruvbox": {
+		SelectedText:        "#282828",
+		SelectedBackground:  "#fabd2f",
+		BorderColor:         "#665c54",
+		HeaderText:          "#83a598",
+		MatchHighlight:      "#fe8019",
+		WarningText:         "#fb4934",
+		ErrorText:           "#fb4934",
+		HelpKey:             "#fabd2f",
+		HelpDescription:     "#a89984",
+		MatchHighlightStyle: "bold",
+	},
+	"dracula": {
+		SelectedText:        "#f8f8f2",
+		SelectedBackground:  "#6272a4",
+		BorderColor:         "#44475a",
+		HeaderText:          "#bd93f9",
+		MatchHighlight:      "#50fa7b",
+		WarningText:         "#ff5555",
+		ErrorText:           "#ff5555",
+		HelpKey:             "#ff79c6",
+		HelpDescription:     "#6272a4",
+		MatchHighlightStyle: "bold",
+	},
+	"nord": {
+		SelectedText:        "#2e3440",
+		SelectedBackground:  "#88c0d0",
+		BorderColor:         "#4c566a",
+		HeaderText:          "#81a1c1",
+		MatchHighlight:      "#ebcb8b",
+		WarningText:         "#bf616a",
+		ErrorText:           "#bf616a",
+		HelpKey:             "#88c0d0",
+		HelpDescription:     "#d8dee9",
+		MatchHighlightStyle: "bold",
+	},
+	"high-contrast": {
+		SelectedText:        "#000000",
+		SelectedBackground:  "#ffff00",
+		BorderColor:         "#ffffff",
+		HeaderText:          "#ffffff",
+		MatchHighlight:      "#00ffff",
+		WarningText:         "#ff0000",
+		ErrorText:           "#ff0000",
+		HelpKey:             "#ffff00",
+		HelpDescription:     "#ffffff",
+		MatchHighlightStyle: "underline",
+	},
+}
+
+// Returns the names of the built-in color schemes in alphabetical order
+func ColorSchemePresetNames() []string {
+	names := make([]string, 0, len(ColorSchemePresets))
+	for name := range ColorSchemePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func GetConfig() (ClientConfig, error) {
 	data, err := GetConfigContents()
 	if err != nil {
@@ -263,6 +641,12 @@ func GetConfig() (ClientConfig, error) {
 	if err != nil {
 		return ClientConfig{}, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	if isExternalSecretStorage(&config) {
+		config.UserSecret, err = loadStoredSecret(&config)
+		if err != nil {
+			return ClientConfig{}, err
+		}
+	}
 	config.KeyBindings = config.KeyBindings.WithDefaults()
 	if config.DisplayedColumns == nil || len(config.DisplayedColumns) == 0 {
 		config.DisplayedColumns = []string{"Hostname", "CWD", "Timestamp", "Runtime", "Exit Code", "Command"}
@@ -279,14 +663,75 @@ func GetConfig() (ClientConfig, error) {
 	if config.ColorScheme.BorderColor == "" {
 		config.ColorScheme.BorderColor = GetDefaultColorScheme().BorderColor
 	}
+	if len(config.DefaultFilters) == 0 && config.DefaultFilter != "" {
+		config.DefaultFilters = []string{config.DefaultFilter}
+	}
+	if config.ColorScheme.MatchHighlightStyle == "" {
+		config.ColorScheme.MatchHighlightStyle = GetDefaultColorScheme().MatchHighlightStyle
+	}
+	if config.TrashRetentionDays == 0 {
+		config.TrashRetentionDays = 7
+	}
 	if config.AiCompletionEndpoint == "" {
 		config.AiCompletionEndpoint = "https://api.openai.com/v1/chat/completions"
 	}
+	if envName := GetServerEnvironmentName(); envName != "" {
+		env, ok := config.ServerEnvironments[envName]
+		if !ok {
+			return ClientConfig{}, fmt.Errorf("unknown server environment %#v, it can be configured via `hishtory config-add server-environments`", envName)
+		}
+		config.activeServerEnvironment = envName
+		config.defaultUserSecret = config.UserSecret
+		if env.UserSecret != "" {
+			config.UserSecret = env.UserSecret
+		}
+		config.defaultSyncCursor = config.SyncCursor
+		config.SyncCursor = env.SyncCursor
+	}
 	return config, nil
 }
 
+// The last modification time of the config file, used to detect when the config was changed by another process
+func GetConfigModTime() (time.Time, error) {
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to retrieve homedir: %w", err)
+	}
+	stat, err := os.Stat(path.Join(homedir, data.GetHishtoryPath(), data.CONFIG_PATH))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to stat config file: %w", err)
+	}
+	return stat.ModTime(), nil
+}
+
+// The name of the currently selected server environment, or the empty string if the default server is in use
+func GetServerEnvironmentName() string {
+	return os.Getenv("HISHTORY_SERVER_ENV")
+}
+
 func SetConfig(config *ClientConfig) error {
-	serializedConfig, err := json.Marshal(config)
+	configToPersist := *config
+	if config.activeServerEnvironment != "" {
+		// Persist the user secret and sync cursor for the selected server environment in that environment rather than
+		// at the top-level
+		serverEnvironments := make(map[string]ServerEnvironment)
+		for name, env := range config.ServerEnvironments {
+			serverEnvironments[name] = env
+		}
+		env := serverEnvironments[config.activeServerEnvironment]
+		if env.UserSecret != "" || config.UserSecret != config.defaultUserSecret {
+			env.UserSecret = config.UserSecret
+			configToPersist.UserSecret = config.defaultUserSecret
+		}
+		env.SyncCursor = config.SyncCursor
+		configToPersist.SyncCursor = config.defaultSyncCursor
+		serverEnvironments[config.activeServerEnvironment] = env
+		configToPersist.ServerEnvironments = serverEnvironments
+	}
+	if isExternalSecretStorage(&configToPersist) {
+		configToPersist.UserSecret = ""
+	}
+	serializedConfig, err := json.Marshal(configToPersist)
 	if err != nil {
 		return fmt.Errorf("failed to serialize config: %w", err)
 	}
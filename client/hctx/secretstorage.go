@@ -0,0 +1,197 @@
+package hctx
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Where the user secret is stored. By default it is stored in plaintext in the config file, but it can instead be
+// stored in the OS keychain or retrieved from an external command (e.g. a password manager CLI), in which case the
+// config file only records where to find it.
+const (
+	SecretStorageConfig   = "config"
+	SecretStorageKeychain = "keychain"
+	SecretStorageCommand  = "command"
+)
+
+// The service and account that the user secret is stored under in the OS keychain
+const (
+	keychainService = "hishtory"
+	keychainAccount = "user-secret"
+)
+
+// The secret is cached since retrieving it requires running a command, and the config is read many times by
+// long-lived processes (e.g. the TUI reloads it whenever it changes). Note that the cache only lasts for the lifetime
+// of the process, so the secret is retrieved again by every hishtory invocation (i.e. once for every command that is
+// run in the shell). It deliberately isn't persisted anywhere since that would defeat the point of storing it outside
+// of the config file, so slow commands (e.g. ones that prompt to unlock a password manager) will slow down the shell.
+var secretCache struct {
+	sync.Mutex
+	key    string
+	secret string
+}
+
+func isExternalSecretStorage(config *ClientConfig) bool {
+	return config.SecretStorage != "" && config.SecretStorage != SecretStorageConfig
+}
+
+// Retrieves the user secret from wherever the config says it is stored
+func loadStoredSecret(config *ClientConfig) (string, error) {
+	key := config.SecretStorage + "/" + config.SecretCommand
+	secretCache.Lock()
+	defer secretCache.Unlock()
+	if secretCache.key == key && secretCache.secret != "" {
+		return secretCache.secret, nil
+	}
+	var secret string
+	var err error
+	switch config.SecretStorage {
+	case SecretStorageKeychain:
+		secret, err = readSecretFromKeychain()
+	case SecretStorageCommand:
+		secret, err = ReadSecretFromCommand(config.SecretCommand)
+	default:
+		return "", fmt.Errorf("unknown secret storage %#v, must be one of: %s, %s, %s", config.SecretStorage, SecretStorageConfig, SecretStorageKeychain, SecretStorageCommand)
+	}
+	if err != nil {
+		return "", err
+	}
+	secretCache.key = key
+	secretCache.secret = secret
+	return secret, nil
+}
+
+// Runs the given shell command (e.g. `pass show hishtory`) and returns the secret that it prints
+func ReadSecretFromCommand(command string) (string, error) {
+	if command == "" {
+		return "", fmt.Errorf("no command is configured to retrieve the hishtory secret")
+	}
+	cmd := exec.Command("sh", "-c", command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve the hishtory secret via %#v: %w (stderr=%#v)", command, err, stderr.String())
+	}
+	// Password managers generally print the secret on the first line, followed by any metadata
+	secret, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return "", fmt.Errorf("the command %#v didn't print the hishtory secret", command)
+	}
+	return secret, nil
+}
+
+func runKeychainCommand(stdin string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s %s: %w (stderr=%#v)", name, strings.Join(args, " "), err, stderr.String())
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Reads the user secret from the macOS Keychain or the Linux Secret Service (via libsecret's secret-tool)
+func readSecretFromKeychain() (string, error) {
+	var secret string
+	var err error
+	switch runtime.GOOS {
+	case "darwin":
+		secret, err = runKeychainCommand("", "security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	case "linux":
+		secret, err = runKeychainCommand("", "secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+	default:
+		return "", fmt.Errorf("storing the hishtory secret in the OS keychain isn't supported on %s", runtime.GOOS)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the hishtory secret from the OS keychain: %w", err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("the hishtory secret wasn't found in the OS keychain")
+	}
+	return secret, nil
+}
+
+func writeSecretToKeychain(secret string) error {
+	var err error
+	switch runtime.GOOS {
+	case "darwin":
+		// -U updates the existing item (e.g. from a previous install) rather than failing. -w must be the last option so
+		// that security prompts for the secret (and then for it again to confirm it) on stdin, so that it isn't visible
+		// in the process list.
+		_, err = runKeychainCommand(secret+"\n"+secret+"\n", "security", "add-generic-password", "-U", "-s", keychainService, "-a", keychainAccount, "-l", "hiSHtory", "-w")
+	case "linux":
+		// secret-tool reads the secret from stdin so that it isn't visible in the process list
+		_, err = runKeychainCommand(secret, "secret-tool", "store", "--label=hiSHtory", "service", keychainService, "account", keychainAccount)
+	default:
+		return fmt.Errorf("storing the hishtory secret in the OS keychain isn't supported on %s", runtime.GOOS)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store the hishtory secret in the OS keychain: %w", err)
+	}
+	return nil
+}
+
+func deleteSecretFromKeychain() error {
+	var err error
+	switch runtime.GOOS {
+	case "darwin":
+		_, err = runKeychainCommand("", "security", "delete-generic-password", "-s", keychainService, "-a", keychainAccount)
+	case "linux":
+		_, err = runKeychainCommand("", "secret-tool", "clear", "service", keychainService, "account", keychainAccount)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete the hishtory secret from the OS keychain: %w", err)
+	}
+	return nil
+}
+
+// Moves the user secret to the given storage (one of the SecretStorage* constants) and persists the config. For
+// SecretStorageCommand, the user must have already stored the secret such that the command prints it.
+func SetSecretStorage(config *ClientConfig, storage, command string) error {
+	if config.activeServerEnvironment != "" {
+		return fmt.Errorf("the secret storage can't be changed while the server environment %#v is selected", config.activeServerEnvironment)
+	}
+	switch storage {
+	case SecretStorageConfig:
+		if config.EncryptLocalDb {
+			return fmt.Errorf("the secret can't be stored in the config file while the local DB is encrypted since the key would be stored in plaintext next to the DB, first run `hishtory config-set encrypt-local-db false`")
+		}
+	case SecretStorageKeychain:
+		if err := writeSecretToKeychain(config.UserSecret); err != nil {
+			return err
+		}
+	case SecretStorageCommand:
+		secret, err := ReadSecretFromCommand(command)
+		if err != nil {
+			return err
+		}
+		if secret != config.UserSecret {
+			return fmt.Errorf("the command %#v printed a different secret than the current hishtory secret, store your current secret (see `hishtory status -v`) so that the command prints it", command)
+		}
+	default:
+		return fmt.Errorf("unknown secret storage %#v, must be one of: %s, %s, %s", storage, SecretStorageConfig, SecretStorageKeychain, SecretStorageCommand)
+	}
+	if storage != SecretStorageCommand {
+		command = ""
+	}
+	previousStorage := config.SecretStorage
+	config.SecretStorage = storage
+	config.SecretCommand = command
+	if err := SetConfig(config); err != nil {
+		return err
+	}
+	if previousStorage == SecretStorageKeychain && storage != SecretStorageKeychain {
+		return deleteSecretFromKeychain()
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The maximum number of cached AI suggestions that are kept
+const MAX_CACHED_AI_SUGGESTIONS = 1000
+
+// Caches the AI suggestions for the given cache key. Only the most recent MAX_CACHED_AI_SUGGESTIONS are kept. Note that
+// expired suggestions are intentionally kept so that they can be used when the AI endpoint is unreachable.
+func CacheAiSuggestions(ctx context.Context, key string, suggestions []string) error {
+	if len(suggestions) == 0 {
+		return nil
+	}
+	db := hctx.GetDb(ctx)
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"suggestions", "created_at"}),
+	}).Create(&data.CachedAiSuggestions{Key: key, Suggestions: suggestions, CreatedAt: time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("failed to cache AI suggestions: %w", err)
+	}
+	mostRecent := db.Model(&data.CachedAiSuggestions{}).Select("key").Order("created_at DESC").Limit(MAX_CACHED_AI_SUGGESTIONS)
+	err = db.Where("key NOT IN (?)", mostRecent).Delete(&data.CachedAiSuggestions{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to prune old cached AI suggestions: %w", err)
+	}
+	return nil
+}
+
+// Returns the cached AI suggestions for the given cache key if they were cached within maxAge, or nil if there are none.
+// A maxAge of zero returns the cached suggestions regardless of how old they are.
+func GetCachedAiSuggestions(ctx context.Context, key string, maxAge time.Duration) ([]string, error) {
+	var cached data.CachedAiSuggestions
+	err := hctx.GetDb(ctx).Where("key = ?", key).First(&cached).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up cached AI suggestions: %w", err)
+	}
+	if maxAge > 0 && time.Since(cached.CreatedAt) > maxAge {
+		return nil, nil
+	}
+	return cached.Suggestions, nil
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared/ai"
+)
+
+const (
+	AI_FEEDBACK_OFF    = "off"
+	AI_FEEDBACK_LOCAL  = "local"
+	AI_FEEDBACK_REPORT = "report"
+)
+
+// Returns the valid values for `hishtory config-set ai-feedback`
+func AiFeedbackModes() []string {
+	return []string{AI_FEEDBACK_OFF, AI_FEEDBACK_LOCAL, AI_FEEDBACK_REPORT}
+}
+
+// Whether feedback on AI suggestions is recorded. This is opt-in via `hishtory config-set ai-feedback`.
+func IsAiFeedbackEnabled(ctx context.Context) bool {
+	mode := hctx.GetConf(ctx).AiFeedback
+	return mode == AI_FEEDBACK_LOCAL || mode == AI_FEEDBACK_REPORT
+}
+
+// Records feedback on the AI suggestions that were displayed for the given query: The accepted suggestion (if any) is
+// recorded as accepted, and every other suggestion is recorded as rejected.
+func RecordAiFeedback(ctx context.Context, provider, query string, suggestions []string, accepted string) error {
+	if !IsAiFeedbackEnabled(ctx) || len(suggestions) == 0 {
+		return nil
+	}
+	now := time.Now()
+	feedback := make([]*data.AiFeedback, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		feedback = append(feedback, &data.AiFeedback{Provider: provider, Query: query, Suggestion: suggestion, Accepted: suggestion == accepted, Timestamp: now})
+	}
+	err := hctx.GetDb(ctx).Create(feedback).Error
+	if err != nil {
+		return fmt.Errorf("failed to record AI feedback: %w", err)
+	}
+	return nil
+}
+
+type AiFeedbackStats struct {
+	Provider    string
+	NumAccepted int
+	NumRejected int
+}
+
+const aiFeedbackStatsSelect = "provider, SUM(CASE WHEN accepted THEN 1 ELSE 0 END) AS num_accepted, SUM(CASE WHEN accepted THEN 0 ELSE 1 END) AS num_rejected"
+
+// Returns how often suggestions from each provider were accepted and rejected, sorted by provider
+func GetAiFeedbackStats(ctx context.Context) ([]AiFeedbackStats, error) {
+	var stats []AiFeedbackStats
+	err := hctx.GetDb(ctx).Model(&data.AiFeedback{}).Select(aiFeedbackStatsSelect).Group("provider").Order("provider").Scan(&stats).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve AI feedback stats: %w", err)
+	}
+	return stats, nil
+}
+
+// Returns the net number of times (accepted minus rejected) that each of the given suggestions was accepted. Used to
+// rank suggestions that were previously accepted above ones that were previously rejected.
+func GetAiSuggestionScores(ctx context.Context, suggestions []string) (map[string]int, error) {
+	var rows []struct {
+		Suggestion string
+		Score      int
+	}
+	err := hctx.GetDb(ctx).Model(&data.AiFeedback{}).
+		Select("suggestion, SUM(CASE WHEN accepted THEN 1 ELSE -1 END) AS score").
+		Where("suggestion IN ?", suggestions).
+		Group("suggestion").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve AI suggestion scores: %w", err)
+	}
+	scores := make(map[string]int)
+	for _, row := range rows {
+		scores[row.Suggestion] = row.Score
+	}
+	return scores, nil
+}
+
+// Reports aggregate counts of any AI feedback that hasn't yet been reported to the backend, if enabled via
+// `hishtory config-set ai-feedback report`. This is called after saving history entries (which happens in the
+// background) so that it never slows down the TUI.
+func MaybeReportAiFeedback(ctx context.Context) error {
+	config := hctx.GetConf(ctx)
+	if config.AiFeedback != AI_FEEDBACK_REPORT || config.IsOffline {
+		return nil
+	}
+	db := hctx.GetDb(ctx)
+	var maxId uint
+	err := db.Model(&data.AiFeedback{}).Where("NOT reported").Select("COALESCE(MAX(id), 0)").Scan(&maxId).Error
+	if err != nil {
+		return fmt.Errorf("failed to check for unreported AI feedback: %w", err)
+	}
+	if maxId == 0 {
+		return nil
+	}
+	var stats []AiFeedbackStats
+	err = db.Model(&data.AiFeedback{}).Select(aiFeedbackStatsSelect).Where("NOT reported AND id <= ?", maxId).Group("provider").Scan(&stats).Error
+	if err != nil {
+		return fmt.Errorf("failed to retrieve unreported AI feedback: %w", err)
+	}
+	for _, s := range stats {
+		report := ai.AiFeedbackReport{
+			DeviceId:    config.DeviceId,
+			UserId:      data.UserId(config.UserSecret),
+			Provider:    s.Provider,
+			NumAccepted: min(s.NumAccepted, ai.MaxAiFeedbackCount),
+			NumRejected: min(s.NumRejected, ai.MaxAiFeedbackCount),
+		}
+		reqBody, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("failed to marshal AiFeedbackReport: %w", err)
+		}
+		_, err = ApiPost(ctx, "/api/v1/ai-feedback", "application/json", reqBody)
+		if IsOfflineError(ctx, err) {
+			// Try again the next time
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to report AI feedback: %w", err)
+		}
+		err = db.Model(&data.AiFeedback{}).Where("NOT reported AND id <= ? AND provider = ?", maxId, s.Provider).Update("reported", true).Error
+		if err != nil {
+			return fmt.Errorf("failed to mark AI feedback as reported: %w", err)
+		}
+	}
+	return nil
+}
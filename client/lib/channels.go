@@ -0,0 +1,297 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Channels are shared team histories, which teammates subscribe to by sharing the channel's secret. Under the hood, a
+// channel is synced exactly like a user's own history, with the channel's secret taking the place of the user secret
+// and each subscribed device registering under a separate device ID for the channel. This means that the backend can't
+// read the entries published to a channel, and doesn't need any knowledge of channels.
+
+var channelNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+func validateChannelName(name string) error {
+	if !channelNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid channel name %#v, channel names may only contain letters, numbers, dashes, and underscores", name)
+	}
+	return nil
+}
+
+// The open channel DBs, keyed by the channel name, so that each is only opened once per process
+var channelDbs = struct {
+	sync.Mutex
+	dbs map[string]*gorm.DB
+}{dbs: make(map[string]*gorm.DB)}
+
+func openChannelDb(ctx context.Context, name string) (*gorm.DB, error) {
+	channelDbs.Lock()
+	defer channelDbs.Unlock()
+	if db, ok := channelDbs.dbs[name]; ok {
+		return db, nil
+	}
+	db, err := hctx.OpenChannelDb(hctx.GetHome(ctx), name)
+	if err != nil {
+		return nil, err
+	}
+	channelDbs.dbs[name] = db
+	return db, nil
+}
+
+func closeChannelDb(name string) {
+	channelDbs.Lock()
+	defer channelDbs.Unlock()
+	if db, ok := channelDbs.dbs[name]; ok {
+		if sqlDb, err := db.DB(); err == nil {
+			sqlDb.Close()
+		}
+		delete(channelDbs.dbs, name)
+	}
+}
+
+// Returns the local DB containing the entries published to the given channel, which is searched for queries with a
+// channel: atom
+func GetChannelDb(ctx context.Context, name string) (*gorm.DB, error) {
+	if _, ok := hctx.GetConf(ctx).Channels[name]; !ok {
+		return nil, fmt.Errorf("not subscribed to the channel %#v (see `hishtory channel list`)", name)
+	}
+	return openChannelDb(ctx, name)
+}
+
+// Returns the names of the channels that this device is subscribed to, sorted alphabetically
+func ListChannels(ctx context.Context) []string {
+	names := make([]string, 0)
+	for name := range hctx.GetConf(ctx).Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Creates a new channel with a random secret and subscribes to it, returning the secret so that it can be shared with
+// teammates
+func CreateChannel(ctx context.Context, name string) (string, error) {
+	secret := uuid.Must(uuid.NewRandom()).String()
+	if _, err := JoinChannel(ctx, name, secret); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Subscribes to the channel with the given secret, and retrieves everything that was already published to it.
+// Returns the number of retrieved entries.
+func JoinChannel(ctx context.Context, name, secret string) (int, error) {
+	config := hctx.GetConf(ctx)
+	if err := validateChannelName(name); err != nil {
+		return 0, err
+	}
+	if _, ok := config.Channels[name]; ok {
+		return 0, fmt.Errorf("already subscribed to a channel named %#v", name)
+	}
+	if config.IsOffline {
+		return 0, fmt.Errorf("channels are synced via the hishtory backend, so they can't be used on offline installs")
+	}
+	channel := hctx.Channel{Secret: secret, DeviceId: uuid.Must(uuid.NewRandom()).String()}
+	channelUserId := data.UserId(channel.Secret)
+	_, err := ApiGet(ctx, "/api/v1/register?user_id="+channelUserId+"&device_id="+channel.DeviceId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register for the channel with the backend: %w", err)
+	}
+	encEntries, err := ApiGetEncHistoryEntries(ctx, "/api/v1/bootstrap?user_id="+channelUserId+"&device_id="+channel.DeviceId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve the entries in the channel: %w", err)
+	}
+	db, err := openChannelDb(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	numRetrieved := 0
+	for _, encEntry := range shared.DedupEncHistoryEntries(encEntries) {
+		entry, err := data.DecryptHistoryEntry(channel.Secret, *encEntry)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt an entry in the channel (is the channel secret correct?): %w", err)
+		}
+		if AddToDbIfNew(db, entry) {
+			numRetrieved += 1
+		}
+	}
+	if config.Channels == nil {
+		config.Channels = make(map[string]hctx.Channel)
+	}
+	config.Channels[name] = channel
+	return numRetrieved, hctx.SetConfig(config)
+}
+
+// Unsubscribes from the given channel and deletes the local copy of its entries. The entries stay available to the
+// channel's other subscribers.
+func LeaveChannel(ctx context.Context, name string) error {
+	config := hctx.GetConf(ctx)
+	channel, ok := config.Channels[name]
+	if !ok {
+		return fmt.Errorf("not subscribed to the channel %#v (see `hishtory channel list`)", name)
+	}
+	_, err := ApiPost(ctx, "/api/v1/uninstall?user_id="+data.UserId(channel.Secret)+"&device_id="+channel.DeviceId, "application/json", []byte{})
+	if err != nil && !IsOfflineError(ctx, err) {
+		return fmt.Errorf("failed to unsubscribe from the channel: %w", err)
+	}
+	closeChannelDb(name)
+	dbPath := hctx.GetChannelDbPath(hctx.GetHome(ctx), name)
+	for _, p := range []string{dbPath, dbPath + "-wal", dbPath + "-shm"} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete the local copy of the channel: %w", err)
+		}
+	}
+	delete(config.Channels, name)
+	return hctx.SetConfig(config)
+}
+
+// Adds a regex for commands that are automatically published to the given channel when they're run
+func AddChannelPublishRule(ctx context.Context, name, rule string) error {
+	config := hctx.GetConf(ctx)
+	channel, ok := config.Channels[name]
+	if !ok {
+		return fmt.Errorf("not subscribed to the channel %#v (see `hishtory channel list`)", name)
+	}
+	if _, err := regexp.Compile(rule); err != nil {
+		return fmt.Errorf("failed to compile the publish rule %#v: %w", rule, err)
+	}
+	channel.PublishRules = append(channel.PublishRules, rule)
+	config.Channels[name] = channel
+	return hctx.SetConfig(config)
+}
+
+// Publishes the given entries to the given channel
+func PublishToChannel(ctx context.Context, name string, entries ...*data.HistoryEntry) error {
+	channel, ok := hctx.GetConf(ctx).Channels[name]
+	if !ok {
+		return fmt.Errorf("not subscribed to the channel %#v (see `hishtory channel list`)", name)
+	}
+	encEntries := make([]*shared.EncHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		encEntry, err := data.EncryptHistoryEntry(channel.Secret, *entry)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt history entry: %w", err)
+		}
+		encEntry.DeviceId = channel.DeviceId
+		encEntries = append(encEntries, &encEntry)
+	}
+	jsonValue, err := json.Marshal(encEntries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal encrypted history entries: %w", err)
+	}
+	_, err = ApiPost(ctx, "/api/v1/submit?source_device_id="+channel.DeviceId, "application/json", jsonValue)
+	if err != nil {
+		return fmt.Errorf("failed to publish to the channel: %w", err)
+	}
+	// The backend doesn't send entries back to the device that submitted them, so store them locally too
+	db, err := openChannelDb(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		AddToDbIfNew(db, *entry)
+	}
+	return nil
+}
+
+// Returns the names of the channels with a publish rule that matches the given command
+func matchingChannels(config *hctx.ClientConfig, command string) ([]string, error) {
+	names := make([]string, 0)
+	for name, channel := range config.Channels {
+		for _, rule := range channel.PublishRules {
+			re, err := regexp.Compile(rule)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compile the publish rule %#v for channel %s: %w", rule, name, err)
+			}
+			if re.MatchString(command) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Publishes the given entry to every channel with a publish rule that matches it
+func PublishToMatchingChannels(ctx context.Context, entry *data.HistoryEntry) error {
+	names, err := matchingChannels(hctx.GetConf(ctx), entry.Command)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := PublishToChannel(ctx, name, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retrieves the entries that were published to each subscribed channel since it was last synced, and returns the
+// total number of new entries
+func PullChannels(ctx context.Context) (int, error) {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline || len(config.Channels) == 0 {
+		return 0, nil
+	}
+	numPulled := 0
+	for _, name := range ListChannels(ctx) {
+		channel := config.Channels[name]
+		encEntries, err := ApiGetEncHistoryEntries(ctx, "/api/v1/query?device_id="+channel.DeviceId+"&user_id="+data.UserId(channel.Secret)+"&queryReason=channel&cursor="+strconv.FormatInt(channel.SyncCursor, 10))
+		if err != nil {
+			return numPulled, fmt.Errorf("failed to retrieve entries for channel %s: %w", name, err)
+		}
+		db, err := openChannelDb(ctx, name)
+		if err != nil {
+			return numPulled, err
+		}
+		for _, encEntry := range encEntries {
+			entry, err := data.DecryptHistoryEntry(channel.Secret, *encEntry)
+			if err != nil {
+				return numPulled, fmt.Errorf("failed to decrypt history entry for channel %s: %w", name, err)
+			}
+			if AddToDbIfNew(db, entry) {
+				numPulled += 1
+			}
+			channel.SyncCursor = max(channel.SyncCursor, encEntry.SequenceId)
+		}
+		config.Channels[name] = channel
+	}
+	return numPulled, hctx.SetConfig(config)
+}
+
+// Extracts the channel: atom from the given tokens, returning the remaining tokens and the name of the channel whose
+// entries should be searched (or an empty string to search the user's own history)
+func extractChannel(tokens []string) ([]string, string, error) {
+	remainingTokens := make([]string, 0, len(tokens))
+	channel := ""
+	for _, token := range tokens {
+		splitToken := splitEscaped(strings.TrimPrefix(token, "-"), ':', 2)
+		if len(splitToken) != 2 || unescape(splitToken[0]) != "channel" {
+			remainingTokens = append(remainingTokens, token)
+			continue
+		}
+		if strings.HasPrefix(token, "-") {
+			return nil, "", fmt.Errorf("the channel: atom cannot be negated")
+		}
+		if channel != "" {
+			return nil, "", fmt.Errorf("only one channel can be searched at a time")
+		}
+		channel = unescape(splitToken[1])
+	}
+	return remainingTokens, channel, nil
+}
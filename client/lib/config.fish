@@ -2,6 +2,9 @@
 hishtory getColorSupport
 export _hishtory_tui_color=$status
 
+# A unique ID for this shell session, used to support filtering to only commands run in the current terminal
+set -gx HISHTORY_SESSION_ID (hishtory getTimestamp)-$fish_pid
+
 function _hishtory_post_exec --on-event fish_preexec 
     # Runs after <ENTER>, but before the command is executed
     set --global _hishtory_command $argv
@@ -26,6 +29,11 @@ function __hishtory_on_prompt --on-event fish_prompt
     end 
 end
 
+function __hishtory_on_exit --on-event fish_exit
+    # Runs when the shell exits in order to print a summary of this session (if enabled)
+    hishtory sessionSummary
+end
+
 function __hishtory_on_control_r
 	set -l tmp (mktemp -t fish.XXXXXX)
 	set -x init_query (commandline -b)
@@ -40,4 +48,15 @@ end
 
 [ (hishtory config-get enable-control-r) = true ] && bind \cr __hishtory_on_control_r
 
+function __hishtory_on_enter
+	# If the command matches a guard rule and the user declines to run it, leave it in the buffer so that it can be edited
+	if hishtory checkGuard fish (commandline -b | string collect) </dev/tty
+		commandline -f execute
+	else
+		commandline -f repaint
+	end
+end
+
+[ (count (hishtory config-get guard)) -gt 0 ] && bind \r __hishtory_on_enter
+
 hishtory completion fish | source
\ No newline at end of file
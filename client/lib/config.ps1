@@ -0,0 +1,107 @@
+# For detecting color rendering support for this terminal, see #134
+hishtory getColorSupport
+$env:_hishtory_tui_color = $LASTEXITCODE
+
+# A unique ID for this shell session, used to support filtering to only commands run in the current terminal
+$env:HISHTORY_SESSION_ID = "$(hishtory getTimestamp)-$PID"
+
+# Runs hishtory in the background with each argument passed through as-is (unlike Start-Process, which doesn't quote
+# arguments)
+function _hishtory_run_in_background {
+    $startInfo = [System.Diagnostics.ProcessStartInfo]::new((Get-Command hishtory -CommandType Application | Select-Object -First 1).Source)
+    foreach ($arg in $args) {
+        $startInfo.ArgumentList.Add([string]$arg)
+    }
+    $startInfo.UseShellExecute = $false
+    [void][System.Diagnostics.Process]::Start($startInfo)
+}
+
+$Global:_hishtory_first_prompt = $true
+$Global:_hishtory_command = $null
+
+# PSReadLine calls the AddToHistoryHandler after <ENTER>, but before the command is executed. Any existing handler is
+# still called so that it can decide whether the command is added to PowerShell's own history.
+$Global:_hishtory_original_history_handler = (Get-PSReadLineOption).AddToHistoryHandler
+Set-PSReadLineOption -AddToHistoryHandler {
+    param([string]$line)
+    $previousExitCode = $global:LASTEXITCODE
+    $Global:_hishtory_command = $line
+    $Global:_hishtory_start_time = hishtory getTimestamp
+    # Restore $LASTEXITCODE since running hishtory changed it
+    $global:LASTEXITCODE = $previousExitCode
+    _hishtory_run_in_background presaveHistoryEntry pwsh $Global:_hishtory_command $Global:_hishtory_start_time  # Background Run
+    # hishtory presaveHistoryEntry pwsh $Global:_hishtory_command $Global:_hishtory_start_time  # Foreground Run
+    if ($Global:_hishtory_original_history_handler) {
+        return & $Global:_hishtory_original_history_handler $line
+    }
+    return $true
+}
+
+# Runs after the command is executed in order to render the prompt
+$Global:_hishtory_original_prompt = $function:prompt
+function global:prompt {
+    # $? and $LASTEXITCODE must be read before anything else runs. PowerShell only sets $LASTEXITCODE for native
+    # commands, so failed cmdlets are recorded with the exit code of the last native command (or 1 if there is none).
+    $hishtorySucceeded = $?
+    $hishtoryExitCode = $global:LASTEXITCODE
+    if ($Global:_hishtory_first_prompt) {
+        $Global:_hishtory_first_prompt = $false
+    } elseif ($Global:_hishtory_command) {
+        if ($hishtorySucceeded) {
+            $hishtoryExitCode = 0
+        } elseif (-not $hishtoryExitCode) {
+            $hishtoryExitCode = 1
+        }
+        _hishtory_run_in_background saveHistoryEntry pwsh $hishtoryExitCode $Global:_hishtory_command $Global:_hishtory_start_time  # Background Run
+        # hishtory saveHistoryEntry pwsh $hishtoryExitCode $Global:_hishtory_command $Global:_hishtory_start_time  # Foreground Run
+        _hishtory_run_in_background updateLocalDbFromRemote
+        # Unset the command so we don't double-save entries when the prompt is re-rendered without running a command
+        $Global:_hishtory_command = $null
+    }
+    & $Global:_hishtory_original_prompt
+}
+
+# Runs when the shell exits in order to print a summary of this session (if enabled)
+Register-EngineEvent -SourceIdentifier PowerShell.Exiting -Action { hishtory sessionSummary } | Out-Null
+
+function _hishtory_on_control_r {
+    $line = $null
+    $cursor = $null
+    [Microsoft.PowerShell.PSConsoleReadLine]::GetBufferState([ref]$line, [ref]$cursor)
+    $env:HISHTORY_TERM_INTEGRATION = 1
+    $env:HISHTORY_SHELL_NAME = "pwsh"
+    try {
+        # The TUI is rendered on stderr, so only the selected command is written to stdout
+        $selected = (hishtory tquery $line) -join "`n"
+    } finally {
+        Remove-Item Env:HISHTORY_TERM_INTEGRATION, Env:HISHTORY_SHELL_NAME -ErrorAction SilentlyContinue
+    }
+    [Microsoft.PowerShell.PSConsoleReadLine]::Replace(0, $line.Length, $selected)
+    [Microsoft.PowerShell.PSConsoleReadLine]::InvokePrompt()
+}
+
+if ((hishtory config-get enable-control-r) -eq "true") {
+    Set-PSReadLineKeyHandler -Chord Ctrl+r -ScriptBlock { _hishtory_on_control_r }
+}
+
+# If the command matches a guard rule and the user declines to run it, leave it in the buffer so that it can be edited
+function _hishtory_on_enter {
+    $line = $null
+    $cursor = $null
+    [Microsoft.PowerShell.PSConsoleReadLine]::GetBufferState([ref]$line, [ref]$cursor)
+    $previousExitCode = $global:LASTEXITCODE
+    hishtory checkGuard pwsh $line
+    $allowed = $LASTEXITCODE -eq 0
+    $global:LASTEXITCODE = $previousExitCode
+    if ($allowed) {
+        [Microsoft.PowerShell.PSConsoleReadLine]::AcceptLine()
+    } else {
+        [Microsoft.PowerShell.PSConsoleReadLine]::InvokePrompt()
+    }
+}
+
+if (hishtory config-get guard) {
+    Set-PSReadLineKeyHandler -Key Enter -ScriptBlock { _hishtory_on_enter }
+}
+
+hishtory completion powershell | Out-String | Invoke-Expression
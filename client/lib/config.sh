@@ -9,8 +9,15 @@ __hishtory_bash_config_sourced=true
 hishtory getColorSupport
 export _hishtory_tui_color=$?
 
+# A unique ID for this shell session, used to support filtering to only commands run in the current terminal
+export HISHTORY_SESSION_ID="$(hishtory getTimestamp)-$$"
+
 # Implementation of running before/after every command based on https://jichu4n.com/posts/debug-trap-and-prompt_command-in-bash/
 function __hishtory_precommand() {
+  if [ -n "${__hishtory_guard_blocked:-}" ] && [[ "$BASH_COMMAND" != __hishtory_postcommand* ]]; then
+    # Skip the rest of a command line that was declined by a guard rule (e.g. the `bar` in `foo && bar`)
+    return 1
+  fi
   if [ -z "${HISHTORY_AT_PROMPT:-}" ]; then
     return
   fi
@@ -19,6 +26,11 @@ function __hishtory_precommand() {
   # Run before every command
   HISHTORY_START_TIME=`hishtory getTimestamp`
   CMD=`history 1`
+  if [ -n "${__hishtory_guard_enabled:-}" ] && ! hishtory checkGuard bash "$CMD" </dev/tty; then
+    # With extdebug, returning non-zero from the DEBUG trap skips running the command
+    __hishtory_guard_blocked=1
+    return 1
+  fi
   if ! [ -z "CMD " ] ; then
     if [[ "$CMD" != "$LAST_PRESAVED_COMMAND" ]] ; then 
       (hishtory presaveHistoryEntry bash "$CMD" $HISHTORY_START_TIME &) # Background Run
@@ -48,6 +60,11 @@ function __hishtory_postcommand() {
     unset HISHTORY_FIRST_PROMPT
     return
   fi
+  if [ -n "${__hishtory_guard_blocked:-}" ]; then
+    # Don't record commands that were declined by a guard rule
+    unset __hishtory_guard_blocked
+    return
+  fi
 
   # Run after every prompt
   (hishtory saveHistoryEntry bash $EXIT_CODE "`history 1`" $HISHTORY_START_TIME &) # Background Run
@@ -56,6 +73,14 @@ function __hishtory_postcommand() {
   (hishtory updateLocalDbFromRemote &)
 }
 PROMPT_COMMAND="__hishtory_postcommand; $PROMPT_COMMAND"
+
+# Print a summary of this session when it ends (if enabled). This doesn't override an existing EXIT trap.
+function __hishtory_session_end() {
+  hishtory sessionSummary
+}
+if [ -z "$(trap -p EXIT)" ]; then
+  trap "__hishtory_session_end" EXIT
+fi
 export HISTTIMEFORMAT=$HISTTIMEFORMAT
 
 __history_control_r() {
@@ -68,3 +93,10 @@ __hishtory_bind_control_r() {
 }
 
 [ "$(hishtory config-get enable-control-r)" = true ] && __hishtory_bind_control_r
+
+__hishtory_enable_guard() {
+  __hishtory_guard_enabled=1
+  shopt -s extdebug
+}
+
+[ -n "$(hishtory config-get guard)" ] && __hishtory_enable_guard
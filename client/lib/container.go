@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+)
+
+// Matches the 64 character container IDs used by Docker and Podman, e.g. in /var/lib/docker/containers/<id>/hostname
+var containerIdRegex = regexp.MustCompile(`containers/([0-9a-f]{64})/`)
+
+// Matches container IDs in cgroup v1 paths, e.g. /docker/<id>
+var cgroupContainerIdRegex = regexp.MustCompile(`[/-]([0-9a-f]{64})(\.scope)?$`)
+
+// Returns the short ID of the container that hishtory is running in, or an empty string if it isn't running in a
+// container (or the ID can't be determined)
+func GetContainerId() string {
+	// With cgroup v2, the container ID is only visible via the paths of the files that the runtime bind mounts
+	if mountinfo, err := os.ReadFile("/proc/self/mountinfo"); err == nil {
+		if id := parseContainerId(containerIdRegex, string(mountinfo)); id != "" {
+			return id
+		}
+	}
+	if cgroup, err := os.ReadFile("/proc/self/cgroup"); err == nil {
+		if id := parseContainerId(cgroupContainerIdRegex, string(cgroup)); id != "" {
+			return id
+		}
+	}
+	// Docker sets the hostname to the short container ID by default
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		if hostname, err := os.Hostname(); err == nil {
+			return hostname
+		}
+	}
+	return ""
+}
+
+func parseContainerId(r *regexp.Regexp, contents string) string {
+	for _, line := range regexp.MustCompile(`\r?\n`).Split(contents, -1) {
+		matches := r.FindStringSubmatch(line)
+		if len(matches) > 1 {
+			// Use the short form of the ID, which is what `docker ps` displays
+			return matches[1][:12]
+		}
+	}
+	return ""
+}
+
+// Returns the default path of the local DB for ephemeral installs. This is in /dev/shm when it is available so that
+// entries are only stored in memory, since containers are expected to be thrown away and the entries are synced to the
+// user's other devices anyways.
+func GetDefaultEphemeralDbPath() string {
+	if stat, err := os.Stat("/dev/shm"); err == nil && stat.IsDir() {
+		return fmt.Sprintf("/dev/shm/hishtory-%d.db", os.Getuid())
+	}
+	return path.Join(os.TempDir(), fmt.Sprintf("hishtory-%d.db", os.Getuid()))
+}
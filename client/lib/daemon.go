@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// How often `hishtory daemon` syncs with the backend
+const DAEMON_SYNC_INTERVAL = 30 * time.Second
+
+// The daemon is considered to be running if it has synced within this long, which leaves room for slow syncs
+const DAEMON_HEARTBEAT_TIMEOUT = 3 * DAEMON_SYNC_INTERVAL
+
+func getDaemonHeartbeatPath(homedir string) string {
+	return path.Join(homedir, data.GetHishtoryPath(), "daemon.heartbeat")
+}
+
+// Returns whether `hishtory daemon` is running and keeping the local DB in sync, in which case interactive commands
+// can skip syncing with the backend
+func IsDaemonRunning(ctx context.Context) bool {
+	stat, err := os.Stat(getDaemonHeartbeatPath(hctx.GetHome(ctx)))
+	if err != nil {
+		return false
+	}
+	return time.Since(stat.ModTime()) < DAEMON_HEARTBEAT_TIMEOUT
+}
+
+// Runs one iteration of the daemon, which retrieves new entries and deletion requests and uploads any entries that
+// failed to upload
+func RunDaemonSync(ctx context.Context) error {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline {
+		return nil
+	}
+	_, err := PullFromRemote(ctx, "daemon")
+	if err != nil {
+		return fmt.Errorf("failed to retrieve entries from the backend: %w", err)
+	}
+	_, err = PullChannels(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve entries from channels: %w", err)
+	}
+	_, err = FlushOutbox(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to upload pending entries: %w", err)
+	}
+	return nil
+}
+
+// Syncs with the backend every DAEMON_SYNC_INTERVAL until the process is killed. Failures (e.g. from being offline)
+// are logged and retried on the next iteration.
+func RunDaemon(ctx context.Context) error {
+	heartbeatPath := getDaemonHeartbeatPath(hctx.GetHome(ctx))
+	defer os.Remove(heartbeatPath)
+	for {
+		// Re-read the config each time, since it is updated by other hishtory processes (e.g. to disable syncing)
+		config, err := hctx.GetConfig()
+		if err != nil {
+			return fmt.Errorf("failed to read the config: %w", err)
+		}
+		err = RunDaemonSync(hctx.WithConfig(ctx, &config))
+		if err != nil {
+			hctx.GetLogger().Infof("daemon: failed to sync: %v", err)
+		} else if config.IsOffline {
+			// Don't claim to be keeping the DB in sync when syncing is disabled
+			os.Remove(heartbeatPath)
+		} else {
+			err = os.WriteFile(heartbeatPath, []byte(time.Now().Format(time.RFC3339)), 0o600)
+			if err != nil {
+				return fmt.Errorf("failed to write the daemon heartbeat: %w", err)
+			}
+		}
+		time.Sleep(DAEMON_SYNC_INTERVAL)
+	}
+}
@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// Writes every local history entry to w as a device bundle, which uses the same format as a takeout archive (see
+// shared.ContentTypeTakeout). The entries are encrypted with the user's secret so that the bundle can be copied over
+// untrusted channels (e.g. a USB drive) to bootstrap another device without going through the backend. Returns the
+// number of entries that were written.
+func ExportDeviceBundle(ctx context.Context, w io.Writer) (int, error) {
+	config := hctx.GetConf(ctx)
+	tw := shared.NewTakeoutWriter(w)
+	numEntries := 0
+	err := StreamSearch(ctx, hctx.GetDb(ctx), "", DefaultSearchOrder, func(entry *data.HistoryEntry) error {
+		encEntry, err := data.EncryptHistoryEntry(config.UserSecret, *entry)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt history entry: %w", err)
+		}
+		numEntries += 1
+		return tw.Write(&encEntry)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to export history entries: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write device bundle: %w", err)
+	}
+	return numEntries, nil
+}
+
+// Imports the entries from a device bundle that was written by ExportDeviceBundle on a device with the same secret, and
+// returns the number of entries that weren't already stored locally
+func ImportDeviceBundle(ctx context.Context, r io.Reader) (int, error) {
+	entries, err := DecryptTakeout(r, hctx.GetConf(ctx).UserSecret)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read device bundle (note that bundles can only be imported on devices with the same secret): %w", err)
+	}
+	db := hctx.GetDb(ctx)
+	numImported := 0
+	for _, entry := range entries {
+		if AddToDbIfNew(db, *entry) {
+			numImported += 1
+		}
+	}
+	return numImported, nil
+}
@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+type Device struct {
+	DeviceId         string
+	Name             string
+	RegistrationDate time.Time
+	SyncMode         shared.SyncMode
+}
+
+// Get all active devices registered to the current user. This also refreshes the cache of device names used for the
+// Device column.
+func GetDevices(ctx context.Context) ([]Device, error) {
+	config := hctx.GetConf(ctx)
+	respBody, err := ApiGet(ctx, "/api/v1/devices?user_id="+data.UserId(config.UserSecret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	var deviceInfos []shared.DeviceInfo
+	err = json.Unmarshal(respBody, &deviceInfos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse list of devices: %w", err)
+	}
+	devices := make([]Device, 0, len(deviceInfos))
+	deviceNames := make(map[string]string)
+	for _, deviceInfo := range deviceInfos {
+		name, err := data.DecryptDeviceName(config.UserSecret, deviceInfo)
+		if err != nil {
+			return nil, err
+		}
+		syncMode := deviceInfo.SyncMode
+		if syncMode == "" {
+			syncMode = shared.SyncModeReadWrite
+		}
+		devices = append(devices, Device{DeviceId: deviceInfo.DeviceId, Name: name, RegistrationDate: deviceInfo.RegistrationDate, SyncMode: syncMode})
+		if name != "" {
+			deviceNames[deviceInfo.DeviceId] = name
+		}
+	}
+	config.DeviceNames = deviceNames
+	err = hctx.SetConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to persist device names: %w", err)
+	}
+	return devices, nil
+}
+
+func RenameDevice(ctx context.Context, deviceId, name string) error {
+	config := hctx.GetConf(ctx)
+	encryptedName, nonce, err := data.EncryptDeviceName(config.UserSecret, deviceId, name)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt device name: %w", err)
+	}
+	reqBody, err := json.Marshal(shared.RenameDeviceRequest{
+		UserId:        data.UserId(config.UserSecret),
+		DeviceId:      deviceId,
+		EncryptedName: encryptedName,
+		NameNonce:     nonce,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ApiPost(ctx, "/api/v1/rename-device", "application/json", reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to rename device: %w", err)
+	}
+	if config.DeviceNames == nil {
+		config.DeviceNames = make(map[string]string)
+	}
+	config.DeviceNames[deviceId] = name
+	return hctx.SetConfig(config)
+}
+
+func RevokeDevice(ctx context.Context, deviceId string) error {
+	config := hctx.GetConf(ctx)
+	_, err := ApiPost(ctx, "/api/v1/revoke-device?user_id="+data.UserId(config.UserSecret)+"&device_id="+deviceId, "application/json", []byte{})
+	if err != nil {
+		return fmt.Errorf("failed to revoke device: %w", err)
+	}
+	return nil
+}
+
+// Set the sync mode of the current device, both locally and in the backend so that entries are no longer stored for
+// write-only devices and so that read-only devices can't submit entries
+func SetSyncMode(ctx context.Context, syncMode shared.SyncMode) error {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline {
+		return fmt.Errorf("sync modes are not supported for offline installs of hishtory since they never sync")
+	}
+	_, err := ApiPost(ctx, "/api/v1/set-device-sync-mode?user_id="+data.UserId(config.UserSecret)+"&device_id="+config.DeviceId+"&sync_mode="+string(syncMode), "application/json", []byte{})
+	if err != nil {
+		return fmt.Errorf("failed to set the sync mode: %w", err)
+	}
+	config.SyncMode = syncMode
+	return hctx.SetConfig(config)
+}
+
+type AccountInfo struct {
+	shared.AccountInfo
+	// The decrypted names of the devices in the report, keyed by device ID
+	DeviceNames map[string]string
+}
+
+// Get a report of everything the backend stores about the current user, so that users can audit it
+func GetAccountInfo(ctx context.Context) (*AccountInfo, error) {
+	config := hctx.GetConf(ctx)
+	respBody, err := ApiGet(ctx, "/api/v1/account-info?user_id="+data.UserId(config.UserSecret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve account info: %w", err)
+	}
+	var accountInfo shared.AccountInfo
+	err = json.Unmarshal(respBody, &accountInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse account info: %w", err)
+	}
+	deviceNames := make(map[string]string)
+	for _, device := range accountInfo.Devices {
+		name, err := data.DecryptDeviceName(config.UserSecret, device.DeviceInfo)
+		if err != nil {
+			return nil, err
+		}
+		if name != "" {
+			deviceNames[device.DeviceId] = name
+		}
+	}
+	return &AccountInfo{AccountInfo: accountInfo, DeviceNames: deviceNames}, nil
+}
+
+// The name to display for the given device, falling back to the device ID if the device hasn't been named
+func GetDeviceDisplayName(ctx context.Context, deviceId string) string {
+	if name, ok := hctx.GetConf(ctx).DeviceNames[deviceId]; ok {
+		return name
+	}
+	return deviceId
+}
@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Marks (or unmarks) the given entry as a favorite and returns the updated entry
+func SetFavorite(ctx context.Context, entry data.HistoryEntry, favorite bool) (*data.HistoryEntry, error) {
+	if entry.EntryId == "" {
+		// Entries recorded by very old versions of hishtory don't have an entry ID, so the original entry couldn't be
+		// deleted on other devices without also deleting the updated entry
+		return nil, fmt.Errorf("entries recorded by very old versions of hishtory can't be marked as favorites")
+	}
+	newEntry := entry
+	newEntry.Favorite = favorite
+	newEntry.EntryId = uuid.Must(uuid.NewRandom()).String()
+	err := replaceHistoryEntries(ctx, []*data.HistoryEntry{&entry}, []*data.HistoryEntry{&newEntry})
+	if err != nil {
+		return nil, err
+	}
+	return &newEntry, nil
+}
+
+// Replaces each of the old entries with the corresponding new entry, both locally and on all other devices. Similar to
+// `hishtory remap-cwd`, the new entries must have new entry IDs so that they are synced to other devices as new
+// entries, while the old entries are deleted via a deletion request.
+func replaceHistoryEntries(ctx context.Context, oldEntries, newEntries []*data.HistoryEntry) error {
+	if len(newEntries) == 0 {
+		return nil
+	}
+
+	// Update the entries locally
+	err := hctx.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		for i, oldEntry := range oldEntries {
+			err := tx.Where("device_id = ? AND entry_id = ? AND end_time = ?", oldEntry.DeviceId, oldEntry.EntryId, oldEntry.EndTime).Delete(&data.HistoryEntry{}).Error
+			if err != nil {
+				return fmt.Errorf("failed to delete the original entry: %w", err)
+			}
+			err = tx.Create(newEntries[i]).Error
+			if err != nil {
+				return fmt.Errorf("failed to persist the updated entry: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// And then on remote instances
+	config := hctx.GetConf(ctx)
+	if config.IsOffline || !config.SyncMode.CanUpload() {
+		return nil
+	}
+	err = shared.ForEach(shared.Chunks(newEntries, 500), 10, func(chunk []*data.HistoryEntry) error {
+		jsonValue, err := EncryptAndMarshal(config, chunk)
+		if err != nil {
+			return err
+		}
+		_, err = ApiPost(ctx, "/api/v1/submit?source_device_id="+config.DeviceId, "application/json", jsonValue)
+		return err
+	})
+	if err != nil {
+		if IsOfflineError(ctx, err) {
+			// Queue the updated entries so that they're uploaded once network access is regained. Note that the
+			// original entries aren't deleted on other devices in this case, so they will show both until this is
+			// retried.
+			return EnqueueForUpload(ctx, newEntries)
+		}
+		return fmt.Errorf("failed to upload the updated entries: %w", err)
+	}
+	// Note that we purposefully identify the original entries only via their entry IDs since the updated entries
+	// share the same EndTime.
+	dr := shared.DeletionRequest{
+		UserId:   data.UserId(config.UserSecret),
+		SendTime: time.Now(),
+	}
+	for _, entry := range oldEntries {
+		dr.Messages.Ids = append(dr.Messages.Ids, shared.MessageIdentifier{DeviceId: entry.DeviceId, EntryId: entry.EntryId})
+	}
+	err = SendDeletionRequest(ctx, dr)
+	if err != nil && !IsOfflineError(ctx, err) {
+		return err
+	}
+	return nil
+}
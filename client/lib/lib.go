@@ -3,8 +3,11 @@ package lib
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,14 +20,19 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode"
 
 	_ "embed" // for embedding config.sh
 
 	"golang.org/x/exp/slices"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/araddon/dateparse"
 	"github.com/google/uuid"
@@ -44,6 +52,12 @@ var ConfigZshContents string
 //go:embed config.fish
 var ConfigFishContents string
 
+//go:embed config.ps1
+var ConfigPowerShellContents string
+
+//go:embed config.nu
+var ConfigNushellContents string
+
 var Version string = "Unknown"
 var GitCommit string = "Unknown"
 
@@ -51,7 +65,8 @@ var GitCommit string = "Unknown"
 // Funnily enough, 256KB actually wasn't enough. See https://github.com/ddworken/hishtory/issues/93
 var maxSupportedLineLengthForImport = 512_000
 
-func AddToDbIfNew(db *gorm.DB, entry data.HistoryEntry) {
+// Adds the given entry to the DB unless an identical entry already exists, and returns whether it was added
+func AddToDbIfNew(db *gorm.DB, entry data.HistoryEntry) bool {
 	tx := db.Where("local_username = ?", entry.LocalUsername)
 	tx = tx.Where("hostname = ?", entry.Hostname)
 	tx = tx.Where("command = ?", entry.Command)
@@ -65,7 +80,9 @@ func AddToDbIfNew(db *gorm.DB, entry data.HistoryEntry) {
 	if len(results) == 0 {
 		db.Create(normalizeEntryTimezone(entry))
 		// TODO: check the error here and bubble it up
+		return true
 	}
+	return false
 }
 
 func getCustomColumnValue(ctx context.Context, header string, entry data.HistoryEntry) (string, error) {
@@ -110,6 +127,30 @@ func BuildTableRow(ctx context.Context, columnNames []string, entry data.History
 			row = append(row, commandRenderer(entry.Command))
 		case "User", "user":
 			row = append(row, entry.LocalUsername)
+		case "Git Repo", "Git_Repo", "GitRepo", "git_repo", "repo":
+			row = append(row, entry.GitRepo)
+		case "Git Branch", "Git_Branch", "GitBranch", "git_branch", "branch":
+			row = append(row, entry.GitBranch)
+		case "Tmux Session", "Tmux_Session", "TmuxSession", "tmux_session":
+			row = append(row, entry.TmuxSession)
+		case "Tmux Window", "Tmux_Window", "TmuxWindow", "tmux_window":
+			row = append(row, entry.TmuxWindow)
+		case "Tmux Pane", "Tmux_Pane", "TmuxPane", "tmux_pane":
+			row = append(row, entry.TmuxPane)
+		case "Remote Host", "Remote_Host", "RemoteHost", "remote_host":
+			row = append(row, entry.RemoteHost)
+		case "Container ID", "Container_ID", "ContainerId", "container_id", "container":
+			row = append(row, entry.ContainerId)
+		case "Device", "device":
+			row = append(row, GetDeviceDisplayName(ctx, entry.DeviceId))
+		case "Tags", "tags":
+			row = append(row, strings.Join(entry.Tags, ", "))
+		case "Favorite", "favorite":
+			if entry.Favorite {
+				row = append(row, "★")
+			} else {
+				row = append(row, "")
+			}
 		default:
 			customColumnValue, err := getCustomColumnValue(ctx, header, entry)
 			if err != nil {
@@ -136,7 +177,275 @@ func MakeRegexFromQuery(query string) string {
 	return r
 }
 
+var (
+	SUDO_PREFIX_REGEX     = regexp.MustCompile(`^sudo(\s+(-[EHSkn]+|--preserve-env|--))*\s+`)
+	TEMP_FILENAME_REGEX   = regexp.MustCompile(`(^|[\s'"=])(/tmp|/var/tmp|/private/var/folders)/[^\s'"]+`)
+	MKTEMP_FILENAME_REGEX = regexp.MustCompile(`\btmp\.[A-Za-z0-9]{6,}\b`)
+)
+
+// Normalize a command so that trivially different commands (e.g. `ls  -la;` and `ls -la`) compare as equal. This
+// is only used for comparing commands (e.g. for filtering duplicates), the original command is always what is
+// stored and displayed.
+func NormalizeCommand(cmd string) string {
+	cmd = collapseWhitespace(cmd)
+	for strings.HasSuffix(cmd, ";") {
+		cmd = strings.TrimSpace(strings.TrimSuffix(cmd, ";"))
+	}
+	cmd = SUDO_PREFIX_REGEX.ReplaceAllString(cmd, "sudo ")
+	cmd = TEMP_FILENAME_REGEX.ReplaceAllString(cmd, "$1$2/*")
+	cmd = MKTEMP_FILENAME_REGEX.ReplaceAllString(cmd, "tmp.*")
+	return cmd
+}
+
+// Collapses each run of whitespace into a single space and trims leading and trailing whitespace, but leaves whitespace
+// within quotes (or escaped via a backslash) alone since it changes the meaning of the command
+func collapseWhitespace(cmd string) string {
+	var sb strings.Builder
+	var quote rune
+	escaped := false
+	pendingSpace := false
+	for _, r := range cmd {
+		if quote == 0 && !escaped && unicode.IsSpace(r) {
+			pendingSpace = true
+			continue
+		}
+		if pendingSpace && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		pendingSpace = false
+		sb.WriteRune(r)
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+		case r == quote:
+			quote = 0
+		}
+	}
+	return sb.String()
+}
+
+// Returns the column that commands are grouped by when ranking and computing stats, which is the normalized command
+// if normalization is enabled. Note that the normalized commands must first be filled in via
+// BackfillNormalizedCommands.
+func CommandGroupingColumn(ctx context.Context) string {
+	if hctx.GetConf(ctx).NormalizeCommands {
+		return "normalized_command"
+	}
+	return "command"
+}
+
+// The number of entries whose normalized command is filled in per transaction
+const normalizedCommandBatchSize = 1000
+
+// Fills in the normalized command (see data.HistoryEntry.NormalizedCommand) for every entry that doesn't have it yet,
+// which is all entries that were inserted since the last time this ran
+func BackfillNormalizedCommands(db *gorm.DB) error {
+	for {
+		var rows []struct {
+			Rowid   int64
+			Command string
+		}
+		err := db.Raw("SELECT rowid, command FROM history_entries WHERE (normalized_command IS NULL OR normalized_command = '') AND command != '' LIMIT ?", normalizedCommandBatchSize).Scan(&rows).Error
+		if err != nil {
+			return fmt.Errorf("failed to query for entries without a normalized command: %w", err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				normalizedCmd := NormalizeCommand(row.Command)
+				if normalizedCmd == "" {
+					// Commands that are entirely whitespace are grouped as-is, so that they aren't backfilled again
+					normalizedCmd = row.Command
+				}
+				if err := tx.Exec("UPDATE history_entries SET normalized_command = ? WHERE rowid = ?", normalizedCmd, row.Rowid).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to backfill normalized commands: %w", err)
+		}
+		if len(rows) < normalizedCommandBatchSize {
+			return nil
+		}
+	}
+}
+
+// Returns the key that should be used when checking whether a command is a duplicate of a previous command
+func DuplicateCommandKey(ctx context.Context, cmd string) string {
+	if hctx.GetConf(ctx).NormalizeCommands {
+		return NormalizeCommand(cmd)
+	}
+	return strings.TrimSpace(cmd)
+}
+
+type CommandHistoryStats struct {
+	// The number of times the command was run
+	NumRuns int `json:"num_runs"`
+	// The number of times the command exited with a status code of 0
+	NumSuccessful int `json:"num_successful"`
+	// The most recent history entry for the command, or nil if it has never been run
+	LastRun *data.HistoryEntry `json:"last_run"`
+}
+
+// Get stats on how often a command (or an effectively identical command, per NormalizeCommand) has been run before
+func GetCommandHistoryStats(ctx context.Context, cmd string) (CommandHistoryStats, error) {
+	var stats CommandHistoryStats
+	normalizedCmd := NormalizeCommand(cmd)
+	if normalizedCmd == "" {
+		return stats, nil
+	}
+	db := hctx.GetDb(ctx)
+	err := RetryingDbFunction(func() error {
+		return BackfillNormalizedCommands(db)
+	})
+	if err != nil {
+		return stats, err
+	}
+	var counts struct {
+		NumRuns       int
+		NumSuccessful int
+	}
+	err = RetryingDbFunction(func() error {
+		return db.Model(&data.HistoryEntry{}).Select("COUNT(*) AS num_runs, COALESCE(SUM(exit_code = 0), 0) AS num_successful").Where("normalized_command = ?", normalizedCmd).Scan(&counts).Error
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to count previous runs of %#v: %w", cmd, err)
+	}
+	if counts.NumRuns == 0 {
+		return stats, nil
+	}
+	stats.NumRuns = counts.NumRuns
+	stats.NumSuccessful = counts.NumSuccessful
+	var lastRun data.HistoryEntry
+	err = RetryingDbFunction(func() error {
+		return db.Where("normalized_command = ?", normalizedCmd).Order("end_time DESC").Limit(1).Find(&lastRun).Error
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to query for the last run of %#v: %w", cmd, err)
+	}
+	stats.LastRun = &lastRun
+	return stats, nil
+}
+
+// Returns whether the given entry was run in an excluded directory or matches an excluded command pattern, and thus
+// shouldn't be recorded at all
+func IsExcludedFromRecording(ctx context.Context, entry *data.HistoryEntry) (bool, error) {
+	config := hctx.GetConf(ctx)
+	for _, pattern := range config.ExcludedCommandPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, fmt.Errorf("failed to compile excluded command pattern %#v: %w", pattern, err)
+		}
+		if re.MatchString(entry.Command) {
+			return true, nil
+		}
+	}
+	if len(config.ExcludedCwdGlobs) == 0 {
+		return false, nil
+	}
+	cwd := expandHomeDirectory(entry.CurrentWorkingDirectory, entry.HomeDirectory)
+	for _, glob := range config.ExcludedCwdGlobs {
+		glob = filepath.Clean(expandHomeDirectory(glob, entry.HomeDirectory))
+		// Check the cwd and all of its parents, so that excluding a directory also excludes everything under it
+		for dir := filepath.Clean(cwd); ; dir = filepath.Dir(dir) {
+			matched, err := filepath.Match(glob, dir)
+			if err != nil {
+				return false, fmt.Errorf("failed to match excluded directory glob %#v: %w", glob, err)
+			}
+			if matched {
+				return true, nil
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+	return false, nil
+}
+
+func expandHomeDirectory(path, homedir string) string {
+	if path == "~" {
+		return homedir
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homedir, path[2:])
+	}
+	return path
+}
+
+// Returns the first guard rule that the given command matches, or nil if it doesn't match any of them
+func MatchGuardRule(ctx context.Context, cmd string) (*hctx.GuardRule, error) {
+	for _, rule := range hctx.GetConf(ctx).GuardRules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile guard pattern %#v: %w", rule.Pattern, err)
+		}
+		if re.MatchString(cmd) {
+			matchedRule := rule
+			return &matchedRule, nil
+		}
+	}
+	return nil, nil
+}
+
+// The text that redacted secrets are replaced with
+const REDACTED_TEXT = "[REDACTED]"
+
+// Applies the configured redaction rules to the given command. Returns the redacted command, and whether the command
+// should be dropped entirely rather than recorded.
+func ApplyRedactionRules(ctx context.Context, cmd string) (string, bool, error) {
+	for _, rule := range hctx.GetConf(ctx).RedactionRules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to compile redaction pattern %#v: %w", rule.Pattern, err)
+		}
+		if !re.MatchString(cmd) {
+			continue
+		}
+		if rule.Drop {
+			return "", true, nil
+		}
+		cmd = redactMatches(re, cmd)
+	}
+	return cmd, false, nil
+}
+
+func redactMatches(re *regexp.Regexp, cmd string) string {
+	var sb strings.Builder
+	lastIdx := 0
+	for _, match := range re.FindAllStringSubmatchIndex(cmd, -1) {
+		// Redact each capture group if there are any, and otherwise the entire match
+		spans := match[2:]
+		if len(spans) == 0 {
+			spans = match[:2]
+		}
+		for i := 0; i < len(spans); i += 2 {
+			start, end := spans[i], spans[i+1]
+			if start < lastIdx || start == end {
+				// An unmatched or empty group, or a nested group within one that was already redacted
+				continue
+			}
+			sb.WriteString(cmd[lastIdx:start])
+			sb.WriteString(REDACTED_TEXT)
+			lastIdx = end
+		}
+	}
+	sb.WriteString(cmd[lastIdx:])
+	return sb.String()
+}
+
 func CheckFatalError(err error) {
+	if IsClientTooOldError(err) {
+		log.Fatalf("hishtory v0.%s is no longer supported by the hishtory backend! Please run `hishtory update` to upgrade. Original error: %v", Version, err)
+	}
 	if err != nil {
 		_, filename, line, _ := runtime.Caller(1)
 		log.Fatalf("hishtory v0.%s fatal error at %s:%d: %v", Version, filename, line, err)
@@ -246,7 +555,6 @@ func ImportHistory(ctx context.Context, shouldReadStdin, force bool) (int, error
 		return 0, fmt.Errorf("failed to count fish history lines during hishtory import: %w", err)
 	}
 	totalNumEntries += fishLines
-	db := hctx.GetDb(ctx)
 	currentUser, err := user.Current()
 	if err != nil {
 		return 0, err
@@ -255,6 +563,27 @@ func ImportHistory(ctx context.Context, shouldReadStdin, force bool) (int, error
 	if err != nil {
 		return 0, err
 	}
+	numEntriesImported, err := importCommands(ctx, entriesIter, totalNumEntries, currentUser.Name, hostname, homedir)
+	if err != nil {
+		return 0, err
+	}
+	err = Reupload(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload hishtory import: %w", err)
+	}
+	config.HaveCompletedInitialImport = true
+	err = hctx.SetConfig(config)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark initial import as completed, this may lead to duplicate history entries: %w", err)
+	}
+	return numEntriesImported, nil
+}
+
+// Stores the given commands as history entries that ran as the given user on the given host, skipping any that shells
+// wouldn't have recorded. Note that this only stores the entries locally, so callers are responsible for uploading them.
+func importCommands(ctx context.Context, entriesIter Seq2[string, error], totalNumEntries int, username, hostname, homedir string) (int, error) {
+	config := hctx.GetConf(ctx)
+	db := hctx.GetDb(ctx)
 	numEntriesImported := 0
 	var iteratorError error = nil
 	var batch []data.HistoryEntry
@@ -283,7 +612,7 @@ func ImportHistory(ctx context.Context, shouldReadStdin, force bool) (int, error
 		// quite slow, so this makes imports considerably faster
 		entryId := importEntryId + fmt.Sprintf("%d", numEntriesImported)
 		entry := normalizeEntryTimezone(data.HistoryEntry{
-			LocalUsername:           currentUser.Name,
+			LocalUsername:           username,
 			Hostname:                hostname,
 			Command:                 cmd,
 			CurrentWorkingDirectory: "Unknown",
@@ -322,7 +651,7 @@ func ImportHistory(ctx context.Context, shouldReadStdin, force bool) (int, error
 	}
 	// Also create any entries remaining in an unfinished batch
 	if len(batch) > 0 {
-		err = RetryingDbFunction(func() error {
+		err := RetryingDbFunction(func() error {
 			if err := db.Create(batch).Error; err != nil {
 				return fmt.Errorf("failed to import final batch of history entries: %w", err)
 			}
@@ -332,14 +661,79 @@ func ImportHistory(ctx context.Context, shouldReadStdin, force bool) (int, error
 			return 0, err
 		}
 	}
-	err = Reupload(ctx)
-	if err != nil {
-		return 0, fmt.Errorf("failed to upload hishtory import: %w", err)
+	// Trigger a checkpoint so that these bulk entries are added from the WAL to the main DB
+	db.Exec("PRAGMA wal_checkpoint")
+	return numEntriesImported, nil
+}
+
+// Imports history entries that were exported via `hishtory export --format jsonl`, reading one JSON entry per line
+// so that arbitrarily large exports can be imported without loading them into memory all at once. Entries that
+// already exist are skipped.
+func ImportJsonlHistory(ctx context.Context, r io.Reader) (int, error) {
+	config := hctx.GetConf(ctx)
+	db := hctx.GetDb(ctx)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	batchSize := 100
+	batch := make([]*data.HistoryEntry, 0, batchSize)
+	numEntriesImported := 0
+	flushBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var numCreated int64
+		err := RetryingDbFunction(func() error {
+			res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(batch)
+			if res.Error != nil {
+				return fmt.Errorf("failed to import batch of history entries: %w", res.Error)
+			}
+			numCreated = res.RowsAffected
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !config.IsOffline && config.SyncMode.CanUpload() {
+			jsonValue, err := EncryptAndMarshal(config, batch)
+			if err != nil {
+				return fmt.Errorf("failed to upload imported history entries due to failed encryption: %w", err)
+			}
+			_, err = ApiPost(ctx, "/api/v1/submit?source_device_id="+config.DeviceId, "application/json", jsonValue)
+			if err != nil {
+				return fmt.Errorf("failed to upload imported history entries: %w", err)
+			}
+		}
+		numEntriesImported += int(numCreated)
+		batch = make([]*data.HistoryEntry, 0, batchSize)
+		return nil
 	}
-	config.HaveCompletedInitialImport = true
-	err = hctx.SetConfig(config)
-	if err != nil {
-		return 0, fmt.Errorf("failed to mark initial import as completed, this may lead to duplicate history entries: %w", err)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry data.HistoryEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return numEntriesImported, fmt.Errorf("failed to parse line %d as a JSON history entry: %w", lineNum, err)
+		}
+		if entry.EntryId == "" {
+			entry.EntryId = uuid.Must(uuid.NewRandom()).String()
+		}
+		entry = normalizeEntryTimezone(entry)
+		batch = append(batch, &entry)
+		if len(batch) >= batchSize {
+			if err := flushBatch(); err != nil {
+				return numEntriesImported, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return numEntriesImported, fmt.Errorf("failed to read history entries: %w", err)
+	}
+	if err := flushBatch(); err != nil {
+		return numEntriesImported, err
 	}
 	// Trigger a checkpoint so that these bulk entries are added from the WAL to the main DB
 	db.Exec("PRAGMA wal_checkpoint")
@@ -376,15 +770,23 @@ func parseFishHistory(homedir string) Seq2[string, error] {
 			if err != nil {
 				return yield(line, err)
 			}
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, "- cmd: ") {
-				yield(strings.SplitN(line, ": ", 2)[1], nil)
+			if cmd, ok := parseFishHistoryLine(line); ok {
+				yield(cmd, nil)
 			}
 			return true
 		})
 	}
 }
 
+// Returns the command recorded in the given line of a fish history file, if the line contains one
+func parseFishHistoryLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "- cmd: ") {
+		return strings.SplitN(line, ": ", 2)[1], true
+	}
+	return "", false
+}
+
 type (
 	// Represents an iterator of (K,V). Equivalent of the future Go stdlib type iter.Seq2.
 	// TODO: Swap this to the stdlib function once it has been released, along with the below two functions
@@ -446,10 +848,15 @@ func readFileToIterator(path string) Seq2[string, error] {
 
 const DefaultServerHostname = "https://api.hishtory.dev"
 
-func GetServerHostname() string {
+func GetServerHostname(ctx context.Context) string {
 	if server := os.Getenv("HISHTORY_SERVER"); server != "" {
 		return server
 	}
+	if envName := hctx.GetServerEnvironmentName(); envName != "" {
+		if hostname := hctx.GetConf(ctx).ServerEnvironments[envName].Hostname; hostname != "" {
+			return hostname
+		}
+	}
 	return DefaultServerHostname
 }
 
@@ -458,68 +865,187 @@ func httpClient() *http.Client {
 }
 
 func ApiGet(ctx context.Context, path string) ([]byte, error) {
+	respBody, _, err := apiGet(ctx, path, "")
+	return respBody, err
+}
+
+// Retrieves a list of encrypted history entries from an endpoint that supports protobuf responses (i.e. query and
+// bootstrap). Protobuf is requested since it is significantly smaller and faster to decode for large syncs, but older
+// or self-hosted servers that only support JSON are handled via the response's Content-Type.
+func ApiGetEncHistoryEntries(ctx context.Context, path string) ([]*shared.EncHistoryEntry, error) {
+	respBody, contentType, err := apiGet(ctx, path, shared.ContentTypeProtobuf+", application/json;q=0.9")
+	if err != nil {
+		return nil, err
+	}
+	if mediaType, _, _ := strings.Cut(contentType, ";"); strings.TrimSpace(mediaType) == shared.ContentTypeProtobuf {
+		return shared.UnmarshalEncHistoryEntriesProto(respBody)
+	}
+	var entries []*shared.EncHistoryEntry
+	err = json.Unmarshal(respBody, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JSON response: %w", err)
+	}
+	return entries, nil
+}
+
+func apiGet(ctx context.Context, path, accept string) ([]byte, string, error) {
 	if os.Getenv("HISHTORY_SIMULATE_NETWORK_ERROR") != "" {
-		return nil, fmt.Errorf("simulated network error: dial tcp: lookup api.hishtory.dev")
+		return nil, "", fmt.Errorf("simulated network error: dial tcp: lookup api.hishtory.dev")
 	}
 	start := time.Now()
-	req, err := http.NewRequest("GET", GetServerHostname()+path, nil)
+	req, err := http.NewRequest("GET", GetServerHostname(ctx)+path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GET: %w", err)
+		return nil, "", fmt.Errorf("failed to create GET: %w", err)
 	}
 	req.Header.Set("X-Hishtory-Version", "v0."+Version)
 	req.Header.Set("X-Hishtory-Device-Id", hctx.GetConf(ctx).DeviceId)
 	req.Header.Set("X-Hishtory-User-Id", data.UserId(hctx.GetConf(ctx).UserSecret))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
 	resp, err := httpClient().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to GET %s%s: %w", GetServerHostname(), path, err)
+		return nil, "", fmt.Errorf("failed to GET %s%s: %w", GetServerHostname(ctx), path, err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to GET %s%s: status_code=%d", GetServerHostname(), path, resp.StatusCode)
+		return nil, "", makeApiError("GET", GetServerHostname(ctx)+path, resp)
 	}
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body from GET %s%s: %w", GetServerHostname(), path, err)
+		return nil, "", fmt.Errorf("failed to read response body from GET %s%s: %w", GetServerHostname(ctx), path, err)
 	}
 	duration := time.Since(start)
-	hctx.GetLogger().Infof("ApiGet(%#v): %d bytes - %s\n", GetServerHostname()+path, len(respBody), duration.String())
-	return respBody, nil
+	hctx.GetLogger().Infof("ApiGet(%#v): %d bytes - %s\n", GetServerHostname(ctx)+path, len(respBody), duration.String())
+	return respBody, resp.Header.Get("Content-Type"), nil
+}
+
+// Request bodies at least this large are gzipped when sent to endpoints that support compression
+const MIN_COMPRESSED_REQUEST_SIZE = 16 * 1024
+
+// The endpoints that accept gzipped request bodies. Note that responses don't need to be handled here since Go's HTTP
+// client already negotiates and decompresses gzipped responses.
+var COMPRESSED_API_PATHS = []string{"/api/v1/submit?", "/api/v1/submit-batch?", "/api/v1/reencrypt?"}
+
+func shouldCompressRequest(path string, reqBody []byte) bool {
+	if len(reqBody) < MIN_COMPRESSED_REQUEST_SIZE {
+		return false
+	}
+	for _, prefix := range COMPRESSED_API_PATHS {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(b); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 func ApiPost(ctx context.Context, path, contentType string, reqBody []byte) ([]byte, error) {
+	if shouldCompressRequest(path, reqBody) {
+		respBody, err := apiPost(ctx, path, contentType, reqBody, true)
+		var errResp *shared.ErrorResponse
+		if errors.As(err, &errResp) && (errResp.Code == shared.ErrorCodeUnsupportedEncoding || errResp.Code == shared.ErrorCodeBadRequest) {
+			// Self-hosted backends running an older version may not support compressed requests, so fall back to
+			// sending it uncompressed
+			hctx.GetLogger().Infof("ApiPost(%#v): retrying without compression after: %v\n", GetServerHostname(ctx)+path, err)
+			return apiPost(ctx, path, contentType, reqBody, false)
+		}
+		return respBody, err
+	}
+	return apiPost(ctx, path, contentType, reqBody, false)
+}
+
+func apiPost(ctx context.Context, path, contentType string, reqBody []byte, compress bool) ([]byte, error) {
 	if os.Getenv("HISHTORY_SIMULATE_NETWORK_ERROR") != "" {
 		return nil, fmt.Errorf("simulated network error: dial tcp: lookup api.hishtory.dev")
 	}
 	start := time.Now()
-	req, err := http.NewRequest("POST", GetServerHostname()+path, bytes.NewBuffer(reqBody))
+	if compress {
+		compressedBody, err := gzipBytes(reqBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress request body: %w", err)
+		}
+		reqBody = compressedBody
+	}
+	req, err := http.NewRequest("POST", GetServerHostname(ctx)+path, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create POST: %w", err)
 	}
 	req.Header.Set("Content-Type", contentType)
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	req.Header.Set("X-Hishtory-Version", "v0."+Version)
 	req.Header.Set("X-Hishtory-Device-Id", hctx.GetConf(ctx).DeviceId)
 	req.Header.Set("X-Hishtory-User-Id", data.UserId(hctx.GetConf(ctx).UserSecret))
 	resp, err := httpClient().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to POST %s: %w", GetServerHostname()+path, err)
+		return nil, fmt.Errorf("failed to POST %s: %w", GetServerHostname(ctx)+path, err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to POST %s: status_code=%d", GetServerHostname()+path, resp.StatusCode)
+		return nil, makeApiError("POST", GetServerHostname(ctx)+path, resp)
 	}
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body from POST %s: %w", GetServerHostname()+path, err)
+		return nil, fmt.Errorf("failed to read response body from POST %s: %w", GetServerHostname(ctx)+path, err)
 	}
 	duration := time.Since(start)
-	hctx.GetLogger().Infof("ApiPost(%#v): %d bytes - %s\n", GetServerHostname()+path, len(respBody), duration.String())
+	hctx.GetLogger().Infof("ApiPost(%#v): %d bytes - %s\n", GetServerHostname(ctx)+path, len(respBody), duration.String())
 	return respBody, nil
 }
 
+// User-facing explanations for errors returned by the backend
+var API_ERROR_EXPLANATIONS = map[shared.ErrorCode]string{
+	shared.ErrorCodeUnknownUser:     "this device isn't registered with the hishtory backend, if you uninstalled hishtory on another device you may need to re-run `hishtory init $YOUR_HISHTORY_SECRET`",
+	shared.ErrorCodeTooManyUsers:    "the hishtory backend has reached its maximum number of users",
+	shared.ErrorCodeUpstreamFailure: "the hishtory backend failed to reach one of its dependencies, please try again later",
+	shared.ErrorCodeClientTooOld:    "this version of hishtory is no longer supported by the hishtory backend, please run `hishtory update` to upgrade",
+	shared.ErrorCodeRequestTooLarge: "the request was larger than the hishtory backend allows",
+	shared.ErrorCodeQuotaExceeded:   "your account has reached the maximum number of history entries that the hishtory backend stores, consider deleting old entries",
+	shared.ErrorCodeNotFound:        "the requested data doesn't exist or has expired",
+}
+
+// Whether the error is due to the backend no longer supporting this version of hishtory
+func IsClientTooOldError(err error) bool {
+	var errResp *shared.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Code == shared.ErrorCodeClientTooOld
+}
+
+func makeApiError(method, url string, resp *http.Response) error {
+	var errResp shared.ErrorResponse
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil || json.Unmarshal(respBody, &errResp) != nil || errResp.Code == "" {
+		// Not a structured error (e.g. an error from a proxy in front of the backend, or an older backend)
+		return fmt.Errorf("failed to %s %s: status_code=%d", method, url, resp.StatusCode)
+	}
+	if explanation, ok := API_ERROR_EXPLANATIONS[errResp.Code]; ok {
+		return fmt.Errorf("failed to %s %s: status_code=%d: %s: %w", method, url, resp.StatusCode, explanation, &errResp)
+	}
+	return fmt.Errorf("failed to %s %s: status_code=%d: %w", method, url, resp.StatusCode, &errResp)
+}
+
 func IsOfflineError(ctx context.Context, err error) bool {
 	if err == nil {
 		return false
 	}
+	var errResp *shared.ErrorResponse
+	if errors.As(err, &errResp) {
+		// The backend told us whether this error is transient, so retryable errors are handled like offline errors
+		// (e.g. by queuing entries to be uploaded later) and all others are bubbled up
+		return errResp.Retryable
+	}
 	if strings.Contains(err.Error(), "dial tcp: lookup api.hishtory.dev") ||
 		strings.Contains(err.Error(), ": no such host") ||
 		strings.Contains(err.Error(), "connect: network is unreachable") ||
@@ -602,14 +1128,9 @@ func ReliableDbCreate(db *gorm.DB, entry data.HistoryEntry) error {
 }
 
 func EncryptAndMarshal(config *hctx.ClientConfig, entries []*data.HistoryEntry) ([]byte, error) {
-	var encEntries []shared.EncHistoryEntry
-	for _, entry := range entries {
-		encEntry, err := data.EncryptHistoryEntry(config.UserSecret, *entry)
-		if err != nil {
-			return nil, fmt.Errorf("failed to encrypt history entry: %w", err)
-		}
-		encEntry.DeviceId = config.DeviceId
-		encEntries = append(encEntries, encEntry)
+	encEntries, err := encryptEntries(config, entries)
+	if err != nil {
+		return nil, err
 	}
 	jsonValue, err := json.Marshal(encEntries)
 	if err != nil {
@@ -618,9 +1139,22 @@ func EncryptAndMarshal(config *hctx.ClientConfig, entries []*data.HistoryEntry)
 	return jsonValue, nil
 }
 
+func encryptEntries(config *hctx.ClientConfig, entries []*data.HistoryEntry) ([]*shared.EncHistoryEntry, error) {
+	var encEntries []*shared.EncHistoryEntry
+	for _, entry := range entries {
+		encEntry, err := data.EncryptHistoryEntryWithHashedFields(config.UserSecret, *entry, config.HashedFields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt history entry: %w", err)
+		}
+		encEntry.DeviceId = config.DeviceId
+		encEntries = append(encEntries, &encEntry)
+	}
+	return encEntries, nil
+}
+
 func Reupload(ctx context.Context) error {
 	config := hctx.GetConf(ctx)
-	if config.IsOffline {
+	if config.IsOffline || !config.SyncMode.CanUpload() {
 		return nil
 	}
 	entries, err := Search(ctx, hctx.GetDb(ctx), "", 0)
@@ -633,88 +1167,268 @@ func Reupload(ctx context.Context) error {
 		bar = progressbar.Default(int64(len(entries)))
 		defer bar.Finish()
 	}
-	chunkSize := 500
-	chunks := shared.Chunks(entries, chunkSize)
-	return shared.ForEach(chunks, 10, func(chunk []*data.HistoryEntry) error {
-		jsonValue, err := EncryptAndMarshal(config, chunk)
-		if err != nil {
-			return fmt.Errorf("failed to reupload due to failed encryption: %w", err)
+	// Upload in atomic batches so that an interrupted upload (e.g. of a large import) is never partially applied.
+	// Histories larger than a single batch are split across several batches, and the progress is persisted so that
+	// re-running an interrupted upload only uploads the remaining chunks.
+	progress := config.ReuploadProgress
+	if progress == nil {
+		progress = &hctx.ReuploadProgress{UploadId: uuid.Must(uuid.NewRandom()).String()}
+	} else {
+		fmt.Printf("Resuming an interrupted upload (%d chunks were already uploaded)\n", len(progress.CompletedChunks))
+	}
+	config.ReuploadProgress = progress
+	if err := hctx.SetConfig(config); err != nil {
+		return fmt.Errorf("failed to persist upload progress: %w", err)
+	}
+	completedChunks := make(map[string]bool)
+	for _, key := range progress.CompletedChunks {
+		completedChunks[key] = true
+	}
+	// Sort the entries so that the chunks (and thus their idempotency keys) are stable across attempts
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].StartTime.Equal(entries[j].StartTime) {
+			return entries[i].StartTime.Before(entries[j].StartTime)
 		}
-		_, err = ApiPost(ctx, "/api/v1/submit?source_device_id="+config.DeviceId, "application/json", jsonValue)
-		if err != nil {
-			return fmt.Errorf("failed to reupload due to failed POST: %w", err)
+		return entries[i].EntryId < entries[j].EntryId
+	})
+	var progressLock sync.Mutex
+	chunks := shared.Chunks(entries, MAX_SYNC_BATCH_SIZE)
+	err = shared.ForEach(chunks, 4, func(chunk []*data.HistoryEntry) error {
+		key := reuploadChunkKey(progress.UploadId, chunk)
+		if !completedChunks[key] {
+			err := SubmitBatch(ctx, chunk, nil, key)
+			if err != nil {
+				return fmt.Errorf("failed to reupload: %w", err)
+			}
+			progressLock.Lock()
+			defer progressLock.Unlock()
+			progress.CompletedChunks = append(progress.CompletedChunks, key)
+			if err := hctx.SetConfig(config); err != nil {
+				return fmt.Errorf("failed to persist upload progress: %w", err)
+			}
 		}
 		if bar != nil {
-			_ = bar.Add(chunkSize)
+			_ = bar.Add(len(chunk))
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	config.ReuploadProgress = nil
+	return hctx.SetConfig(config)
+}
+
+// Returns the idempotency key for a chunk of an upload, which is derived from the entries in it so that a resumed
+// upload whose chunks changed (e.g. due to new entries) doesn't skip entries that were never uploaded
+func reuploadChunkKey(uploadId string, chunk []*data.HistoryEntry) string {
+	h := sha256.New()
+	for _, entry := range chunk {
+		h.Write([]byte(entry.EntryId))
+		h.Write([]byte{0})
+	}
+	return uploadId + "-" + hex.EncodeToString(h.Sum(nil))
 }
 
 func RetrieveAdditionalEntriesFromRemote(ctx context.Context, queryReason string) error {
-	db := hctx.GetDb(ctx)
-	config := hctx.GetConf(ctx)
-	if config.IsOffline {
-		return nil
+	_, err := PullFromRemote(ctx, queryReason)
+	if err == nil {
+		_, err = PullChannels(ctx)
 	}
-	respBody, err := ApiGet(ctx, "/api/v1/query?device_id="+config.DeviceId+"&user_id="+data.UserId(config.UserSecret)+"&queryReason="+queryReason)
 	if IsOfflineError(ctx, err) {
 		return nil
 	}
-	if err != nil {
-		return err
+	return err
+}
+
+// The changes made to the local DB by PullFromRemote
+type PullResult struct {
+	// The number of new history entries that were added
+	NumPulled int
+	// The number of retrieved history entries that were skipped since an identical entry already existed locally
+	NumConflicts int
+	// The number of local history entries that were deleted due to deletion requests
+	NumDeleted int64
+}
+
+// Retrieves new history entries and deletion requests from the backend and applies them to the local DB. Unlike
+// RetrieveAdditionalEntriesFromRemote, offline errors are returned so that callers can report them.
+func PullFromRemote(ctx context.Context, queryReason string) (PullResult, error) {
+	db := hctx.GetDb(ctx)
+	config := hctx.GetConf(ctx)
+	result := PullResult{}
+	if config.IsOffline {
+		return result, nil
 	}
-	var retrievedEntries []*shared.EncHistoryEntry
-	err = json.Unmarshal(respBody, &retrievedEntries)
+	// Note that write-only devices never retrieve entries from other devices, but they should still apply deletion
+	// requests. Deletion requests are retrieved before entries so that if a batch (e.g. an edit) is applied by the
+	// backend in between, we at worst briefly show both the old and new entry rather than losing the new one.
+	deletionRequests, err := getDeletionRequests(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to load JSON response: %w", err)
+		return result, err
 	}
-	for _, entry := range retrievedEntries {
-		decEntry, err := data.DecryptHistoryEntry(config.UserSecret, *entry)
+	var retrievedEntries []data.HistoryEntry
+	syncCursor := config.SyncCursor
+	if config.SyncMode.CanDownload() {
+		encEntries, err := ApiGetEncHistoryEntries(ctx, "/api/v1/query?device_id="+config.DeviceId+"&user_id="+data.UserId(config.UserSecret)+"&queryReason="+queryReason+"&cursor="+strconv.FormatInt(config.SyncCursor, 10))
 		if err != nil {
-			return fmt.Errorf("failed to decrypt history entry from server: %w", err)
+			return result, err
+		}
+		for _, entry := range encEntries {
+			// Older backends ignore the cursor and don't assign sequence IDs, in which case the cursor stays at zero
+			syncCursor = max(syncCursor, entry.SequenceId)
+			decEntry, err := data.DecryptHistoryEntry(config.UserSecret, *entry)
+			if err != nil {
+				return result, fmt.Errorf("failed to decrypt history entry from server: %w", err)
+			}
+			retrievedEntries = append(retrievedEntries, decEntry)
 		}
-		AddToDbIfNew(db, decEntry)
 	}
-	return ProcessDeletionRequests(ctx)
+
+	// Apply everything in a single transaction so that an interrupted pull never leaves a batch partially applied.
+	// Deletions are applied first so that an edit's replacement entry is never deleted.
+	err = RetryingDbFunction(func() error {
+		result = PullResult{}
+		return db.Transaction(func(tx *gorm.DB) error {
+			numDeleted, err := deleteEntriesForDeletionRequests(tx, deletionRequests)
+			if err != nil {
+				return err
+			}
+			result.NumDeleted = numDeleted
+			for _, entry := range retrievedEntries {
+				if AddToDbIfNew(tx, entry) {
+					result.NumPulled += 1
+				} else {
+					result.NumConflicts += 1
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to apply changes pulled from the backend: %w", err)
+	}
+	err = ackDeletionRequests(ctx, deletionRequests)
+	if err != nil {
+		return result, err
+	}
+	// Only advance the cursor once the entries are stored, so that they're retrieved again if applying them failed
+	config.SyncCursor = syncCursor
+	return result, RecordSuccessfulSync(ctx)
 }
 
-func ProcessDeletionRequests(ctx context.Context) error {
+// Records that this device just successfully synced with the backend, for display in `hishtory status --sync`
+func RecordSuccessfulSync(ctx context.Context) error {
 	config := hctx.GetConf(ctx)
-	if config.IsOffline {
-		return nil
+	config.LastSuccessfulSyncTimestamp = time.Now().Unix()
+	return hctx.SetConfig(config)
+}
+
+// Returns the version of hishtory that the backend was built against
+func GetBackendVersion(ctx context.Context) (string, error) {
+	respBody, err := ApiGet(ctx, "/api/v1/server-version")
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve the backend version: %w", err)
 	}
-	resp, err := ApiGet(ctx, "/api/v1/get-deletion-requests?user_id="+data.UserId(config.UserSecret)+"&device_id="+config.DeviceId)
+	return string(respBody), nil
+}
+
+func ProcessDeletionRequests(ctx context.Context) error {
+	_, err := processDeletionRequests(ctx)
 	if IsOfflineError(ctx, err) {
 		return nil
 	}
+	return err
+}
+
+func processDeletionRequests(ctx context.Context) (int64, error) {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline {
+		return 0, nil
+	}
+	deletionRequests, err := getDeletionRequests(ctx)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	return handleDeletionRequests(ctx, deletionRequests)
+}
+
+func getDeletionRequests(ctx context.Context) ([]*shared.DeletionRequest, error) {
+	config := hctx.GetConf(ctx)
+	resp, err := ApiGet(ctx, "/api/v1/get-deletion-requests?user_id="+data.UserId(config.UserSecret)+"&device_id="+config.DeviceId)
+	if err != nil {
+		return nil, err
 	}
 	var deletionRequests []*shared.DeletionRequest
 	err = json.Unmarshal(resp, &deletionRequests)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return HandleDeletionRequests(ctx, deletionRequests)
+	return deletionRequests, nil
 }
 
 func HandleDeletionRequests(ctx context.Context, deletionRequests []*shared.DeletionRequest) error {
-	db := hctx.GetDb(ctx)
+	_, err := handleDeletionRequests(ctx, deletionRequests)
+	return err
+}
+
+// Applies the given deletion requests to the local DB, and returns the number of entries deleted
+func handleDeletionRequests(ctx context.Context, deletionRequests []*shared.DeletionRequest) (int64, error) {
+	var numDeleted int64
+	err := RetryingDbFunction(func() error {
+		var err error
+		numDeleted, err = deleteEntriesForDeletionRequests(hctx.GetDb(ctx), deletionRequests)
+		return err
+	})
+	if err != nil {
+		return numDeleted, err
+	}
+	return numDeleted, ackDeletionRequests(ctx, deletionRequests)
+}
+
+// Tells the backend that the given deletion requests were applied to the local DB, so that it stops sending them and
+// can clean them up once every device has applied them
+func ackDeletionRequests(ctx context.Context, deletionRequests []*shared.DeletionRequest) error {
+	config := hctx.GetConf(ctx)
+	requestIds := make([]string, 0)
+	for _, request := range deletionRequests {
+		// Older backends don't assign IDs to requests, and instead clean them up once they've been read many times
+		if request.RequestId != "" {
+			requestIds = append(requestIds, request.RequestId)
+		}
+	}
+	if config.IsOffline || len(requestIds) == 0 {
+		return nil
+	}
+	jsonValue, err := json.Marshal(requestIds)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deletion request acks: %w", err)
+	}
+	_, err = ApiPost(ctx, "/api/v1/ack-deletion-requests?user_id="+data.UserId(config.UserSecret)+"&device_id="+config.DeviceId, "application/json", jsonValue)
+	if IsOfflineError(ctx, err) {
+		// The requests will be sent again since they weren't acked, and then they'll be acked
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to ack deletion requests: %w", err)
+	}
+	return nil
+}
+
+func deleteEntriesForDeletionRequests(db *gorm.DB, deletionRequests []*shared.DeletionRequest) (int64, error) {
+	numDeleted := int64(0)
 	for _, request := range deletionRequests {
 		for _, entry := range request.Messages.Ids {
-			err := RetryingDbFunction(func() error {
-				// Note that entry.EndTime is not always present (for pre-saved entries). And likewise,
-				// entry.EntryId is not always present for older entries. So we just check that one of them matches.
-				tx := db.Where("device_id = ? AND (end_time = ? OR entry_id = ?)", entry.DeviceId, entry.EndTime, entry.EntryId)
-				return tx.Delete(&data.HistoryEntry{}).Error
-			})
-			if err != nil {
-				return fmt.Errorf("DB error when deleting entries: %w", err)
+			// Note that entry.EndTime is not always present (for pre-saved entries). And likewise,
+			// entry.EntryId is not always present for older entries. So we just check that one of them matches.
+			res := db.Where("device_id = ? AND (end_time = ? OR entry_id = ?)", entry.DeviceId, entry.EndTime, entry.EntryId).Delete(&data.HistoryEntry{})
+			if res.Error != nil {
+				return numDeleted, fmt.Errorf("DB error when deleting entries: %w", res.Error)
 			}
+			numDeleted += res.RowsAffected
 		}
 	}
-	return nil
+	return numDeleted, nil
 }
 
 func GetBanner(ctx context.Context) ([]byte, error) {
@@ -745,8 +1459,59 @@ func where(tx *gorm.DB, s string, v1 any, v2 any) *gorm.DB {
 	panic(fmt.Sprintf("Impossible state: v1=%#v, v2=%#v", v1, v2))
 }
 
+// The columns that free-text search terms can be restricted to via the scope: atom
+var SEARCH_SCOPE_COLUMNS = map[string]string{
+	"command":  "command",
+	"cmd":      "command",
+	"cwd":      "current_working_directory",
+	"hostname": "hostname",
+	"host":     "hostname",
+}
+
+// Extracts any scope: atoms from the given tokens, returning the remaining tokens and the column that free-text
+// search terms are restricted to (or an empty string if they aren't restricted)
+func extractSearchScope(tokens []string) ([]string, string, error) {
+	remainingTokens := make([]string, 0, len(tokens))
+	scopeColumn := ""
+	for _, token := range tokens {
+		splitToken := splitEscaped(strings.TrimPrefix(token, "-"), ':', 2)
+		if len(splitToken) != 2 || unescape(splitToken[0]) != "scope" {
+			remainingTokens = append(remainingTokens, token)
+			continue
+		}
+		if strings.HasPrefix(token, "-") {
+			return nil, "", fmt.Errorf("the scope: atom cannot be negated")
+		}
+		scope := unescape(splitToken[1])
+		if scope == "all" {
+			scopeColumn = ""
+			continue
+		}
+		column, ok := SEARCH_SCOPE_COLUMNS[scope]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown search scope %#v, must be one of: all, command, cwd, hostname", scope)
+		}
+		scopeColumn = column
+	}
+	return remainingTokens, scopeColumn, nil
+}
+
 func MakeWhereQueryFromSearch(ctx context.Context, db *gorm.DB, query string) (*gorm.DB, error) {
-	tokens := tokenize(query)
+	tokens, scopeColumn, err := extractSearchScope(tokenize(query))
+	if err != nil {
+		return nil, err
+	}
+	tokens, channel, err := extractChannel(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if channel != "" {
+		// Entries published to a channel are stored in a separate DB with the same schema, so search that instead
+		db, err = GetChannelDb(ctx, channel)
+		if err != nil {
+			return nil, err
+		}
+	}
 	tx := db.Model(&data.HistoryEntry{}).Where("true")
 	for _, token := range tokens {
 		if strings.HasPrefix(token, "-") {
@@ -762,11 +1527,11 @@ func MakeWhereQueryFromSearch(ctx context.Context, db *gorm.DB, query string) (*
 				}
 				tx = where(tx, "NOT "+query, v1, v2)
 			} else {
-				query, v1, v2, v3, err := parseNonAtomizedToken(token[1:])
+				query, args, err := parseNonAtomizedToken(token[1:], scopeColumn)
 				if err != nil {
 					return nil, err
 				}
-				tx = tx.Where("NOT "+query, v1, v2, v3)
+				tx = tx.Where("NOT "+query, args...)
 			}
 		} else if containsUnescaped(token, ":") {
 			query, v1, v2, err := parseAtomizedToken(ctx, token)
@@ -775,23 +1540,81 @@ func MakeWhereQueryFromSearch(ctx context.Context, db *gorm.DB, query string) (*
 			}
 			tx = where(tx, query, v1, v2)
 		} else {
-			query, v1, v2, v3, err := parseNonAtomizedToken(token)
+			query, args, err := parseNonAtomizedToken(token, scopeColumn)
 			if err != nil {
 				return nil, err
 			}
-			tx = tx.Where(query, v1, v2, v3)
+			tx = tx.Where(query, args...)
 		}
 	}
 	return tx, nil
 }
 
+const (
+	SORT_BY_TIME      = "time"
+	SORT_BY_RUNTIME   = "runtime"
+	SORT_BY_EXIT_CODE = "exit_code"
+	SORT_BY_COMMAND   = "command"
+)
+
+// The order that search results are returned in
+type SearchOrder struct {
+	// The column to sort by, one of the SORT_BY_* constants
+	Column string
+	// Whether to sort in ascending rather than descending order
+	Ascending bool
+}
+
+// The default order for search results, most recent first
+var DefaultSearchOrder = SearchOrder{Column: SORT_BY_TIME}
+
 func Search(ctx context.Context, db *gorm.DB, query string, limit int) ([]*data.HistoryEntry, error) {
-	return retryingSearch(ctx, db, query, limit, 0)
+	return SearchWithOrder(ctx, db, query, limit, DefaultSearchOrder)
+}
+
+func SearchWithOrder(ctx context.Context, db *gorm.DB, query string, limit int, order SearchOrder) ([]*data.HistoryEntry, error) {
+	return retryingSearch(ctx, db, query, limit, 0, order, 0)
+}
+
+// Search for a window of results, skipping the first offset results. Used for paging through very large histories
+// without loading all of the preceding results.
+func SearchWindow(ctx context.Context, db *gorm.DB, query string, limit, offset int, order SearchOrder) ([]*data.HistoryEntry, error) {
+	return retryingSearch(ctx, db, query, limit, offset, order, 0)
 }
 
 const SEARCH_RETRY_COUNT = 3
 
-func retryingSearch(ctx context.Context, db *gorm.DB, query string, limit int, currentRetryNum int) ([]*data.HistoryEntry, error) {
+// Ranks commands that have previously succeeded in the given cwd first, then commands that have never been run
+// there, and finally commands that have only ever failed there. Used by the success-weighted ranker.
+// Ranks commands by whether they've succeeded in the given directory, where %[1]s is the column that commands are
+// grouped by (see CommandGroupingColumn)
+const CWD_SUCCESS_RANK_CLAUSE = `(SELECT CASE WHEN MAX(prior.exit_code = 0) = 1 THEN 0 WHEN COUNT(*) > 0 THEN 2 ELSE 1 END FROM history_entries AS prior WHERE prior.%[1]s = history_entries.%[1]s AND prior.current_working_directory = ?) ASC`
+
+func makeOrderClause(ctx context.Context, order SearchOrder) (string, error) {
+	timeColumn := "end_time"
+	if hctx.GetConf(ctx).EnablePresaving {
+		// Sort by StartTime when presaving is enabled, since presaved entries may not have an end time
+		timeColumn = "start_time"
+	}
+	direction := "DESC"
+	if order.Ascending {
+		direction = "ASC"
+	}
+	switch order.Column {
+	case SORT_BY_TIME, "":
+		return timeColumn + " " + direction, nil
+	case SORT_BY_RUNTIME:
+		return "(julianday(end_time) - julianday(start_time)) " + direction + ", " + timeColumn + " DESC", nil
+	case SORT_BY_EXIT_CODE:
+		return "exit_code " + direction + ", " + timeColumn + " DESC", nil
+	case SORT_BY_COMMAND:
+		return "command " + direction + ", " + timeColumn + " DESC", nil
+	default:
+		return "", fmt.Errorf("unknown sort column %#v", order.Column)
+	}
+}
+
+func makeSearchQuery(ctx context.Context, db *gorm.DB, query string, order SearchOrder) (*gorm.DB, error) {
 	if ctx == nil && query != "" {
 		return nil, fmt.Errorf("lib.Search called with a nil context and a non-empty query (this should never happen)")
 	}
@@ -800,31 +1623,90 @@ func retryingSearch(ctx context.Context, db *gorm.DB, query string, limit int, c
 	if err != nil {
 		return nil, err
 	}
-	if hctx.GetConf(ctx).EnablePresaving {
-		// Sort by StartTime when presaving is enabled, since presaved entries may not have an end time
-		tx = tx.Order("start_time DESC")
-	} else {
-		tx = tx.Order("end_time DESC")
+	orderClause, err := makeOrderClause(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	var orderVars []any
+	if order == DefaultSearchOrder {
+		ranker := GetConfiguredRanker(ctx)
+		if ranker.Name() != RANKER_RECENCY && hctx.GetConf(ctx).NormalizeCommands {
+			// Backfill the DB that is actually being searched, which may be a channel's DB
+			if err := BackfillNormalizedCommands(tx.Session(&gorm.Session{NewDB: true})); err != nil {
+				hctx.GetLogger().Infof("Failed to backfill normalized commands for ranking: %v", err)
+			}
+		}
+		rankClause, rankVars, err := ranker.OrderClause(ctx)
+		if err != nil {
+			hctx.GetLogger().Infof("Skipping ranking via the %s ranker since it failed: %v", ranker.Name(), err)
+		} else if rankClause != "" {
+			orderClause = rankClause + ", " + orderClause
+			orderVars = append(orderVars, rankVars...)
+		}
+		if hctx.GetConf(ctx).RankFavoritesFirst {
+			// Note that favorite is NULL for entries that were saved before it was added
+			orderClause = "COALESCE(favorite, false) DESC, " + orderClause
+		}
+	}
+	// Break ties via the rowid so that the order is stable, which is required for windowed searches to neither skip nor
+	// repeat entries
+	return tx.Clauses(clause.OrderBy{Expression: clause.Expr{SQL: orderClause + ", rowid DESC", Vars: orderVars}}), nil
+}
+
+func retryingSearch(ctx context.Context, db *gorm.DB, query string, limit, offset int, order SearchOrder, currentRetryNum int) ([]*data.HistoryEntry, error) {
+	tx, err := makeSearchQuery(ctx, db, query, order)
+	if err != nil {
+		return nil, err
 	}
 	if limit > 0 {
 		tx = tx.Limit(limit)
 	}
+	if offset > 0 {
+		tx = tx.Offset(offset)
+	}
 	var historyEntries []*data.HistoryEntry
 	result := tx.Find(&historyEntries)
 	if result.Error != nil {
 		if strings.Contains(result.Error.Error(), SQLITE_LOCKED_ERR_MSG) && currentRetryNum < SEARCH_RETRY_COUNT {
 			hctx.GetLogger().Infof("Ignoring err=%v and retrying search query, cnt=%d", result.Error, currentRetryNum)
 			time.Sleep(time.Duration(currentRetryNum*rand.Intn(50)) * time.Millisecond)
-			return retryingSearch(ctx, db, query, limit, currentRetryNum+1)
+			return retryingSearch(ctx, db, query, limit, offset, order, currentRetryNum+1)
 		}
 		return nil, fmt.Errorf("DB query error: %w", result.Error)
 	}
 	return historyEntries, nil
 }
 
-func parseNonAtomizedToken(token string) (string, any, any, any, error) {
+// Calls f with each result of the given search, one at a time, so that the results never need to be loaded into
+// memory all at once. Used for exporting very large histories.
+func StreamSearch(ctx context.Context, db *gorm.DB, query string, order SearchOrder, f func(*data.HistoryEntry) error) error {
+	tx, err := makeSearchQuery(ctx, db, query, order)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Model(&data.HistoryEntry{}).Rows()
+	if err != nil {
+		return fmt.Errorf("DB query error: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry data.HistoryEntry
+		if err := tx.ScanRows(rows, &entry); err != nil {
+			return fmt.Errorf("failed to scan history entry: %w", err)
+		}
+		if err := f(&entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func parseNonAtomizedToken(token, scopeColumn string) (string, []any, error) {
 	wildcardedToken := "%" + unescape(token) + "%"
-	return "(command LIKE ? OR hostname LIKE ? OR current_working_directory LIKE ?)", wildcardedToken, wildcardedToken, wildcardedToken, nil
+	if scopeColumn != "" {
+		return "(" + scopeColumn + " LIKE ?)", []any{wildcardedToken}, nil
+	}
+	return "(command LIKE ? OR hostname LIKE ? OR current_working_directory LIKE ?)", []any{wildcardedToken, wildcardedToken, wildcardedToken}, nil
 }
 
 func parseAtomizedToken(ctx context.Context, token string) (string, any, any, error) {
@@ -842,6 +1724,20 @@ func parseAtomizedToken(ctx context.Context, token string) (string, any, any, er
 		return "(instr(current_working_directory, ?) > 0 OR instr(REPLACE(current_working_directory, '~/', home_directory), ?) > 0)", strings.TrimSuffix(val, "/"), strings.TrimSuffix(val, "/"), nil
 	case "exit_code":
 		return "(exit_code = ?)", val, nil, nil
+	case "repo":
+		return "(instr(git_repo, ?) > 0)", strings.TrimSuffix(val, "/"), nil, nil
+	case "branch":
+		return "(git_branch = ?)", val, nil, nil
+	case "tmux_session":
+		return "(tmux_session = ?)", val, nil, nil
+	case "tmux_window":
+		return "(tmux_window = ?)", val, nil, nil
+	case "tmux_pane":
+		return "(tmux_pane = ?)", val, nil, nil
+	case "remote_host":
+		return "(instr(remote_host, ?) > 0)", val, nil, nil
+	case "container":
+		return "(container_id = ?)", val, nil, nil
 	case "before":
 		t, err := parseTimeGenerously(val)
 		if err != nil {
@@ -872,6 +1768,22 @@ func parseAtomizedToken(ctx context.Context, token string) (string, any, any, er
 		return "(CAST(strftime(\"%s\",end_time) AS INTEGER) = ?)", strconv.FormatInt(t.Unix(), 10), nil, nil
 	case "command":
 		return "(instr(command, ?) > 0)", val, nil, nil
+	case "tag":
+		return "EXISTS (SELECT 1 FROM json_each(tags) WHERE json_each.value = ?)", val, nil, nil
+	case "favorite":
+		favorite, err := strconv.ParseBool(val)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("failed to parse favorite:%s as a boolean (expected favorite:true or favorite:false)", val)
+		}
+		return "(COALESCE(favorite, false) = ?)", favorite, nil, nil
+	case "session":
+		if val == "current" {
+			val = os.Getenv("HISHTORY_SESSION_ID")
+			if val == "" {
+				return "", nil, nil, fmt.Errorf("failed to find the current session ID, session:current is only supported when running from a shell with hishtory's shell integration enabled")
+			}
+		}
+		return "(session_id = ?)", val, nil, nil
 	default:
 		knownCustomColumns := make([]string, 0)
 		// Get custom columns that are defined on this machine
@@ -885,10 +1797,11 @@ func parseAtomizedToken(ctx context.Context, token string) (string, any, any, er
 			return "", nil, nil, fmt.Errorf("failed to get custom column names from the DB: %w", err)
 		}
 		knownCustomColumns = append(knownCustomColumns, names...)
-		// Check if the atom is for a custom column that exists and if it isn't, return an error
+		// Check if the atom is for a custom column that exists and if it isn't, return an error. Note that this is
+		// case-insensitive to match how custom columns are matched when they're displayed.
 		isCustomColumn := false
 		for _, ccName := range knownCustomColumns {
-			if ccName == field {
+			if strings.EqualFold(ccName, field) {
 				isCustomColumn = true
 			}
 		}
@@ -896,7 +1809,7 @@ func parseAtomizedToken(ctx context.Context, token string) (string, any, any, er
 			return "", nil, nil, fmt.Errorf("search query contains unknown search atom '%s' that doesn't match any column names", field)
 		}
 		// Build the where clause for the custom column
-		return "EXISTS (SELECT 1 FROM json_each(custom_columns) WHERE json_extract(value, '$.name') = ? and instr(json_extract(value, '$.value'), ?) > 0)", field, val, nil
+		return "EXISTS (SELECT 1 FROM json_each(custom_columns) WHERE lower(json_extract(value, '$.name')) = lower(?) and instr(json_extract(value, '$.value'), ?) > 0)", field, val, nil
 	}
 }
 
@@ -1023,3 +1936,35 @@ func SendDeletionRequest(ctx context.Context, deletionRequest shared.DeletionReq
 	}
 	return nil
 }
+
+// Returns the current working directory (with the home directory replaced by ~) and the home directory
+func GetCwd(ctx context.Context) (string, string, error) {
+	cwd, err := getCwdWithoutSubstitution()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get cwd: %w", err)
+	}
+	homedir := hctx.GetHome(ctx)
+	if cwd == homedir {
+		return "~/", homedir, nil
+	}
+	if strings.HasPrefix(cwd, homedir) {
+		return strings.Replace(cwd, homedir, "~", 1), homedir, nil
+	}
+	return cwd, homedir, nil
+}
+
+func getCwdWithoutSubstitution() (string, error) {
+	cwd, err := os.Getwd()
+	if err == nil {
+		return cwd, nil
+	}
+	// Fall back to the syscall to see if that works, as an attempt to
+	// fix github.com/ddworken/hishtory/issues/69
+	if syscall.ImplementsGetwd {
+		cwd, err = syscall.Getwd()
+		if err == nil {
+			return cwd, nil
+		}
+	}
+	return "", err
+}
@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"gorm.io/gorm"
+)
+
+// How often the local DB is automatically optimized
+const DB_MAINTENANCE_INTERVAL = 7 * 24 * time.Hour
+
+// The value of `PRAGMA auto_vacuum` for DBs that support incremental vacuuming
+const sqliteAutoVacuumIncremental = 2
+
+// Runs maintenance on the local DB if it hasn't run in the last DB_MAINTENANCE_INTERVAL. This is called after saving
+// history entries (which happens in the background) so that long-lived installs stay fast without manual intervention.
+func MaybeRunDbMaintenance(ctx context.Context) error {
+	config := hctx.GetConf(ctx)
+	if config.DbMaintenance == nil {
+		// Newly installed (or upgraded) clients have nothing to optimize yet, so just start the clock
+		config.DbMaintenance = &hctx.DbMaintenanceResult{Timestamp: time.Now().Unix()}
+		return hctx.SetConfig(config)
+	}
+	if time.Since(time.Unix(config.DbMaintenance.Timestamp, 0)) < DB_MAINTENANCE_INTERVAL {
+		return nil
+	}
+	// Record that maintenance started before running it, so that other shells don't concurrently run it too
+	config.DbMaintenance.Timestamp = time.Now().Unix()
+	err := hctx.SetConfig(config)
+	if err != nil {
+		return err
+	}
+	_, err = RunDbMaintenance(ctx)
+	return err
+}
+
+// Optimizes the local DB and checks its health. The results are recorded in the config so that they can be displayed
+// by `hishtory doctor`.
+func RunDbMaintenance(ctx context.Context) (*hctx.DbMaintenanceResult, error) {
+	config := hctx.GetConf(ctx)
+	start := time.Now()
+	result := &hctx.DbMaintenanceResult{Timestamp: start.Unix(), SizeBefore: GetDbSize(ctx)}
+	maintenanceErr := runDbMaintenance(hctx.GetDb(ctx), result)
+	if maintenanceErr != nil {
+		result.Error = maintenanceErr.Error()
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+	result.SizeAfter = GetDbSize(ctx)
+	config.DbMaintenance = result
+	err := hctx.SetConfig(config)
+	if err != nil {
+		return result, fmt.Errorf("failed to record the results of DB maintenance: %w", err)
+	}
+	return result, maintenanceErr
+}
+
+func runDbMaintenance(db *gorm.DB, result *hctx.DbMaintenanceResult) error {
+	// Refresh the statistics that the query planner uses to choose indexes
+	err := db.Exec("ANALYZE").Error
+	if err != nil {
+		return fmt.Errorf("failed to analyze the DB: %w", err)
+	}
+
+	// Reclaim the space used by deleted entries. Incremental vacuuming is only possible once the DB has been switched
+	// to it, which requires a single full VACUUM.
+	var autoVacuum int
+	err = db.Raw("PRAGMA auto_vacuum").Scan(&autoVacuum).Error
+	if err != nil {
+		return fmt.Errorf("failed to check the DB's auto_vacuum mode: %w", err)
+	}
+	if autoVacuum == sqliteAutoVacuumIncremental {
+		err = db.Exec("PRAGMA incremental_vacuum").Error
+	} else {
+		err = db.Exec("PRAGMA auto_vacuum = INCREMENTAL").Error
+		if err == nil {
+			err = db.Exec("VACUUM").Error
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to vacuum the DB: %w", err)
+	}
+
+	// Check the health of the DB, and rebuild the indexes if they don't match the tables
+	result.IntegrityCheck, err = checkDbIntegrity(db)
+	if err != nil {
+		return err
+	}
+	if result.IntegrityCheck != "ok" {
+		err = db.Exec("REINDEX").Error
+		if err != nil {
+			return fmt.Errorf("failed to rebuild the DB's indexes: %w", err)
+		}
+		result.Reindexed = true
+		result.IntegrityCheck, err = checkDbIntegrity(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	// And finally, move everything from the WAL into the main DB so that the WAL doesn't grow indefinitely
+	return db.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error
+}
+
+// Returns "ok" if the DB is healthy, and otherwise a description of the problems found
+func checkDbIntegrity(db *gorm.DB) (string, error) {
+	var problems []string
+	err := db.Raw("PRAGMA quick_check").Scan(&problems).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to check the DB's integrity: %w", err)
+	}
+	return strings.Join(problems, "; "), nil
+}
+
+// Returns the size of the local DB file in bytes, or zero if it can't be determined
+func GetDbSize(ctx context.Context) int64 {
+	fileInfo, err := os.Stat(hctx.GetDbPath(hctx.GetHome(ctx)))
+	if err != nil {
+		return 0
+	}
+	return fileInfo.Size()
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// The ways that hishtory can notify about long-running commands finishing
+const (
+	NotifyMethodDesktop = "desktop"
+	NotifyMethodBell    = "bell"
+	NotifyMethodWebhook = "webhook"
+)
+
+func NotifyMethods() []string {
+	return []string{NotifyMethodDesktop, NotifyMethodBell, NotifyMethodWebhook}
+}
+
+// The JSON body that is POSTed to the configured webhook when a long-running command finishes
+type CommandNotification struct {
+	Command                 string    `json:"command"`
+	ExitCode                int       `json:"exit_code"`
+	DurationSeconds         int64     `json:"duration_seconds"`
+	Hostname                string    `json:"hostname"`
+	CurrentWorkingDirectory string    `json:"current_working_directory"`
+	EndTime                 time.Time `json:"end_time"`
+}
+
+// Returns whether the given entry ran for long enough that a notification should be sent now that it has finished
+func ShouldNotify(config *hctx.ClientConfig, entry *data.HistoryEntry) bool {
+	if config.NotifyThresholdSeconds <= 0 || entry.EndTime.Before(entry.StartTime) {
+		return false
+	}
+	return entry.EndTime.Sub(entry.StartTime) >= time.Duration(config.NotifyThresholdSeconds)*time.Second
+}
+
+func buildNotificationMessage(entry *data.HistoryEntry) string {
+	duration := entry.EndTime.Sub(entry.StartTime).Round(time.Second)
+	if entry.ExitCode == 0 {
+		return fmt.Sprintf("`%s` finished after %s", entry.Command, duration)
+	}
+	return fmt.Sprintf("`%s` failed with exit code %d after %s", entry.Command, entry.ExitCode, duration)
+}
+
+// Notifies the user that the given long-running command finished via their configured notification method
+func SendCommandNotification(ctx context.Context, entry *data.HistoryEntry) error {
+	config := hctx.GetConf(ctx)
+	switch config.NotifyMethod {
+	case NotifyMethodBell:
+		return ringTerminalBell()
+	case NotifyMethodWebhook:
+		return sendWebhookNotification(config.NotifyWebhookUrl, entry)
+	case "", NotifyMethodDesktop:
+		return sendDesktopNotification(buildNotificationMessage(entry))
+	default:
+		return fmt.Errorf("unknown notify-method %#v, must be one of %v", config.NotifyMethod, NotifyMethods())
+	}
+}
+
+func sendDesktopNotification(message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// The message is passed as an argument rather than being interpolated so that it doesn't need to be escaped
+		cmd = exec.Command("osascript", "-e", "on run argv", "-e", `display notification (item 1 of argv) with title "hishtory"`, "-e", "end run", message)
+	case "linux", "freebsd", "netbsd", "openbsd":
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			// There is no notification daemon (e.g. over SSH), so fall back to the terminal bell
+			return ringTerminalBell()
+		}
+		cmd = exec.Command("notify-send", "--app-name=hishtory", "hishtory", message)
+	default:
+		return ringTerminalBell()
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to send desktop notification (output=%#v): %w", string(out), err)
+	}
+	return nil
+}
+
+func ringTerminalBell() error {
+	if runtime.GOOS == "windows" {
+		_, err := os.Stderr.WriteString("\a")
+		return err
+	}
+	// The shell runs saveHistoryEntry in the background, so write to the terminal directly
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open the terminal to ring the bell: %w", err)
+	}
+	defer tty.Close()
+	_, err = tty.WriteString("\a")
+	return err
+}
+
+func sendWebhookNotification(url string, entry *data.HistoryEntry) error {
+	if url == "" {
+		return fmt.Errorf("notify-method is webhook but notify-webhook-url isn't set")
+	}
+	body, err := json.Marshal(CommandNotification{
+		Command:                 entry.Command,
+		ExitCode:                entry.ExitCode,
+		DurationSeconds:         int64(entry.EndTime.Sub(entry.StartTime).Seconds()),
+		Hostname:                entry.Hostname,
+		CurrentWorkingDirectory: entry.CurrentWorkingDirectory,
+		EndTime:                 entry.EndTime,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook notification: %w", err)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send webhook notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook notification failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
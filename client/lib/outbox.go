@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The number of queued entries that are uploaded per request when flushing the outbox
+const OUTBOX_BATCH_SIZE = 100
+
+// The delay before the first retry of a failed upload, which doubles with every further failure up to MAX_OUTBOX_BACKOFF
+const INITIAL_OUTBOX_BACKOFF = 30 * time.Second
+
+const MAX_OUTBOX_BACKOFF = time.Hour
+
+// Queues the given entries, which just failed to upload, to be retried once the device is back online
+func EnqueueForUpload(ctx context.Context, entries []*data.HistoryEntry) error {
+	outboxEntries := make([]data.OutboxEntry, 0, len(entries))
+	for _, entry := range entries {
+		outboxEntries = append(outboxEntries, data.OutboxEntry{EntryId: entry.EntryId, Attempts: 1, NextAttemptTime: time.Now().Add(outboxBackoff(1))})
+	}
+	if len(outboxEntries) == 0 {
+		return nil
+	}
+	return RetryingDbFunction(func() error {
+		return hctx.GetDb(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&outboxEntries).Error
+	})
+}
+
+// Returns the number of entries that are queued to be uploaded
+func CountOutbox(ctx context.Context) (int64, error) {
+	var count int64
+	err := hctx.GetDb(ctx).Model(&data.OutboxEntry{}).Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count queued history entries: %w", err)
+	}
+	return count, nil
+}
+
+// Uploads the entries that are queued in the outbox, and returns the number of entries uploaded. Unless force is set,
+// entries are only retried once their backoff has elapsed. If the device is still offline, the remaining entries are
+// left queued with an increased backoff and no error is returned.
+func FlushOutbox(ctx context.Context, force bool) (int, error) {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline || !config.SyncMode.CanUpload() {
+		return 0, nil
+	}
+	db := hctx.GetDb(ctx)
+	query := db.Model(&data.OutboxEntry{})
+	if !force {
+		query = query.Where("next_attempt_time <= ?", time.Now())
+	}
+	var pending []*data.OutboxEntry
+	err := query.Order("next_attempt_time ASC").Find(&pending).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve queued history entries: %w", err)
+	}
+
+	numUploaded := 0
+	for i, chunk := range shared.Chunks(pending, OUTBOX_BATCH_SIZE) {
+		entryIds := make([]string, 0, len(chunk))
+		for _, outboxEntry := range chunk {
+			entryIds = append(entryIds, outboxEntry.EntryId)
+		}
+		var entries []*data.HistoryEntry
+		err := db.Where("entry_id IN ?", entryIds).Find(&entries).Error
+		if err != nil {
+			return numUploaded, fmt.Errorf("failed to retrieve queued history entries: %w", err)
+		}
+		// Entries that were deleted locally since they were queued (e.g. pre-saved entries that have since finished
+		// running) are simply dropped from the outbox
+		if len(entries) > 0 {
+			jsonValue, err := EncryptAndMarshal(config, entries)
+			if err != nil {
+				return numUploaded, err
+			}
+			_, err = ApiPost(ctx, "/api/v1/submit?source_device_id="+config.DeviceId, "application/json", jsonValue)
+			if err != nil {
+				backoffErr := backOffOutboxEntries(ctx, pending[i*OUTBOX_BATCH_SIZE:])
+				if backoffErr != nil {
+					return numUploaded, backoffErr
+				}
+				if IsOfflineError(ctx, err) {
+					hctx.GetLogger().Infof("Failed to upload %d queued history entries since the device is still offline: %v", len(pending)-numUploaded, err)
+					return numUploaded, nil
+				}
+				return numUploaded, fmt.Errorf("failed to upload queued history entries: %w", err)
+			}
+		}
+		err = RetryingDbFunction(func() error {
+			return db.Where("entry_id IN ?", entryIds).Delete(&data.OutboxEntry{}).Error
+		})
+		if err != nil {
+			return numUploaded, fmt.Errorf("failed to mark queued history entries as uploaded: %w", err)
+		}
+		numUploaded += len(entries)
+	}
+	return numUploaded, nil
+}
+
+// Records a failed upload attempt for the given entries, so that they are retried with exponential backoff
+func backOffOutboxEntries(ctx context.Context, outboxEntries []*data.OutboxEntry) error {
+	for _, outboxEntry := range outboxEntries {
+		outboxEntry.Attempts += 1
+		outboxEntry.NextAttemptTime = time.Now().Add(outboxBackoff(outboxEntry.Attempts))
+	}
+	err := RetryingDbFunction(func() error {
+		return hctx.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+			for _, outboxEntry := range outboxEntries {
+				if err := tx.Save(outboxEntry).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update backoff for queued history entries: %w", err)
+	}
+	return nil
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := INITIAL_OUTBOX_BACKOFF
+	for i := 1; i < attempts && backoff < MAX_OUTBOX_BACKOFF; i++ {
+		backoff *= 2
+	}
+	return min(backoff, MAX_OUTBOX_BACKOFF)
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxWhileOffline(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	defer testutils.BackupAndRestoreEnv("HISHTORY_SERVER")()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	db := hctx.GetDb(ctx)
+	// Nothing is listening on this port, so all uploads fail as if the device were offline
+	os.Setenv("HISHTORY_SERVER", "http://localhost:1")
+
+	entry1 := testutils.MakeFakeHistoryEntry("ls")
+	entry2 := testutils.MakeFakeHistoryEntry("pwd")
+	require.NoError(t, db.Create(&entry1).Error)
+	require.NoError(t, db.Create(&entry2).Error)
+	require.NoError(t, EnqueueForUpload(ctx, []*data.HistoryEntry{&entry1, &entry2}))
+	// Enqueueing is idempotent
+	require.NoError(t, EnqueueForUpload(ctx, []*data.HistoryEntry{&entry1}))
+	numQueued, err := CountOutbox(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), numQueued)
+
+	// The entries were just enqueued after a failed upload, so they aren't retried until their backoff elapses
+	numUploaded, err := FlushOutbox(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, 0, numUploaded)
+	var outboxEntries []*data.OutboxEntry
+	require.NoError(t, db.Find(&outboxEntries).Error)
+	require.Len(t, outboxEntries, 2)
+	require.Equal(t, 1, outboxEntries[0].Attempts)
+
+	// Forcing a flush while offline leaves the entries queued with an increased backoff
+	numUploaded, err = FlushOutbox(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, 0, numUploaded)
+	require.NoError(t, db.Find(&outboxEntries).Error)
+	require.Len(t, outboxEntries, 2)
+	for _, outboxEntry := range outboxEntries {
+		require.Equal(t, 2, outboxEntry.Attempts)
+		require.True(t, outboxEntry.NextAttemptTime.After(time.Now().Add(INITIAL_OUTBOX_BACKOFF)))
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	require.Equal(t, 30*time.Second, outboxBackoff(1))
+	require.Equal(t, time.Minute, outboxBackoff(2))
+	require.Equal(t, 2*time.Minute, outboxBackoff(3))
+	require.Equal(t, MAX_OUTBOX_BACKOFF, outboxBackoff(8))
+	require.Equal(t, MAX_OUTBOX_BACKOFF, outboxBackoff(1000))
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Returns the file name of the installed hishtory binary, which needs the .exe extension on Windows
+func GetHishtoryBinaryName() string {
+	if runtime.GOOS == "windows" {
+		return "hishtory.exe"
+	}
+	return "hishtory"
+}
+
+// Removes the binary at the given path so that a new version can be written in its place, even if the binary is
+// currently running. On Unix the binary can just be unlinked, but Windows doesn't allow deleting an executable that is
+// running, so it is renamed out of the way instead (and the renamed copy is cleaned up on the next update).
+func RemoveBinaryForReplacement(binaryPath string) error {
+	if runtime.GOOS != "windows" {
+		return os.Remove(binaryPath)
+	}
+	oldBinaryPath := binaryPath + ".old"
+	err := os.Remove(oldBinaryPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete the previous binary at %s: %w", oldBinaryPath, err)
+	}
+	return os.Rename(binaryPath, oldBinaryPath)
+}
+
+// Returns a command that runs the given script with the native shell, which is bash on Unix and PowerShell on Windows
+func MakeShellCommand(script string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("pwsh", "-NoProfile", "-NonInteractive", "-Command", script)
+	}
+	return exec.Command("bash", "-c", script)
+}
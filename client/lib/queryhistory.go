@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"gorm.io/gorm/clause"
+)
+
+// The maximum number of previous TUI search queries that are kept
+const MAX_SEARCH_QUERY_HISTORY = 100
+
+// Records that the given query was run in the TUI, so that it can be recalled in later TUI sessions. Only the most
+// recent MAX_SEARCH_QUERY_HISTORY queries are kept.
+func RecordSearchQuery(ctx context.Context, query string) error {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil
+	}
+	db := hctx.GetDb(ctx)
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "query"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_used"}),
+	}).Create(&data.SearchQuery{Query: query, LastUsed: time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record search query: %w", err)
+	}
+	mostRecent := db.Model(&data.SearchQuery{}).Select("query").Order("last_used DESC").Limit(MAX_SEARCH_QUERY_HISTORY)
+	err = db.Where("query NOT IN (?)", mostRecent).Delete(&data.SearchQuery{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to prune old search queries: %w", err)
+	}
+	return nil
+}
+
+// Returns the previously run TUI search queries, most recently used first
+func GetSearchQueryHistory(ctx context.Context) ([]string, error) {
+	var queries []string
+	err := hctx.GetDb(ctx).Model(&data.SearchQuery{}).Order("last_used DESC").Pluck("query", &queries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve search query history: %w", err)
+	}
+	return queries, nil
+}
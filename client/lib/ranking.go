@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// Ranks search results that are sorted in the default order. Rankers are expressed as SQL ORDER BY expressions (rather
+// than by sorting in Go) so that windowed and streamed searches can page through results without loading every match.
+// Ties are always broken by recency.
+type Ranker interface {
+	// The name used to select this ranker via `hishtory config-set ranker`
+	Name() string
+	// Returns an ORDER BY expression and the values for its placeholders, or an empty expression to rank purely by
+	// recency
+	OrderClause(ctx context.Context) (string, []any, error)
+}
+
+const (
+	RANKER_RECENCY          = "recency"
+	RANKER_FRECENCY         = "frecency"
+	RANKER_CWD_AFFINITY     = "cwd-affinity"
+	RANKER_SUCCESS_WEIGHTED = "success-weighted"
+)
+
+// All available rankers, in the order that they are cycled through in the TUI
+var RANKERS = []Ranker{recencyRanker{}, frecencyRanker{}, cwdAffinityRanker{}, successWeightedRanker{}}
+
+// Ranks the most recent commands first
+type recencyRanker struct{}
+
+func (recencyRanker) Name() string { return RANKER_RECENCY }
+
+func (recencyRanker) OrderClause(ctx context.Context) (string, []any, error) {
+	return "", nil, nil
+}
+
+// Ranks commands that are run both frequently and recently first. Each previous run of a command contributes a score
+// that decays with the number of days since it was run.
+type frecencyRanker struct{}
+
+func (frecencyRanker) Name() string { return RANKER_FRECENCY }
+
+func (frecencyRanker) OrderClause(ctx context.Context) (string, []any, error) {
+	return fmt.Sprintf(`(SELECT SUM(1.0 / (1.0 + julianday('now') - julianday(prior.start_time))) FROM history_entries AS prior WHERE prior.%[1]s = history_entries.%[1]s) DESC`, CommandGroupingColumn(ctx)), nil, nil
+}
+
+// Ranks commands that have been run the most often in the current directory first
+type cwdAffinityRanker struct{}
+
+func (cwdAffinityRanker) Name() string { return RANKER_CWD_AFFINITY }
+
+func (cwdAffinityRanker) OrderClause(ctx context.Context) (string, []any, error) {
+	cwd, _, err := GetCwd(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get the cwd: %w", err)
+	}
+	return fmt.Sprintf(`(SELECT COUNT(*) FROM history_entries AS prior WHERE prior.%[1]s = history_entries.%[1]s AND prior.current_working_directory = ?) DESC`, CommandGroupingColumn(ctx)), []any{cwd}, nil
+}
+
+// Ranks commands that have previously succeeded in the current directory first, then commands that have never been
+// run there, and finally commands that have only ever failed there. This surfaces the variant of a command that
+// actually works in the current directory.
+type successWeightedRanker struct{}
+
+func (successWeightedRanker) Name() string { return RANKER_SUCCESS_WEIGHTED }
+
+func (successWeightedRanker) OrderClause(ctx context.Context) (string, []any, error) {
+	cwd, _, err := GetCwd(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get the cwd: %w", err)
+	}
+	return fmt.Sprintf(CWD_SUCCESS_RANK_CLAUSE, CommandGroupingColumn(ctx)), []any{cwd}, nil
+}
+
+func RankerNames() []string {
+	names := make([]string, 0, len(RANKERS))
+	for _, ranker := range RANKERS {
+		names = append(names, ranker.Name())
+	}
+	return names
+}
+
+func GetRanker(name string) (Ranker, error) {
+	for _, ranker := range RANKERS {
+		if ranker.Name() == name {
+			return ranker, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown ranker %#v, must be one of: %s", name, strings.Join(RankerNames(), ", "))
+}
+
+// Returns the ranker selected in the config. Configs that predate rankers fall back to the legacy
+// RankBySuccessInCwd option.
+func GetConfiguredRanker(ctx context.Context) Ranker {
+	config := hctx.GetConf(ctx)
+	if config.Ranker != "" {
+		ranker, err := GetRanker(config.Ranker)
+		if err == nil {
+			return ranker
+		}
+		hctx.GetLogger().Infof("Falling back to ranking by recency: %v", err)
+		return recencyRanker{}
+	}
+	if config.RankBySuccessInCwd {
+		return successWeightedRanker{}
+	}
+	return recencyRanker{}
+}
+
+// Returns the ranker after the given one, wrapping around at the end. Used for cycling through rankers in the TUI.
+func NextRanker(current Ranker) Ranker {
+	for i, ranker := range RANKERS {
+		if ranker.Name() == current.Name() {
+			return RANKERS[(i+1)%len(RANKERS)]
+		}
+	}
+	return RANKERS[0]
+}
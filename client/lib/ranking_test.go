@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRankers(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	db := hctx.GetDb(ctx)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(wd)
+	require.NoError(t, os.Chdir("/"))
+
+	// Insert data, from oldest to newest
+	for _, e := range []struct {
+		command  string
+		cwd      string
+		exitCode int
+	}{
+		{"git status", "/", 2},
+		{"git status", "/", 2},
+		{"ls", "/tmp/", 0},
+		{"ls", "/tmp/", 0},
+		{"ls", "/tmp/", 0},
+		{"make", "/", 0},
+		{"go test", "/", 1},
+	} {
+		entry := testutils.MakeFakeHistoryEntry(e.command)
+		entry.CurrentWorkingDirectory = e.cwd
+		entry.ExitCode = e.exitCode
+		require.NoError(t, db.Create(entry).Error)
+	}
+
+	testcases := []struct {
+		ranker   string
+		expected []string
+	}{
+		{RANKER_RECENCY, []string{"go test", "make", "ls", "ls", "ls", "git status", "git status"}},
+		{RANKER_FRECENCY, []string{"ls", "ls", "ls", "git status", "git status", "go test", "make"}},
+		{RANKER_CWD_AFFINITY, []string{"git status", "git status", "go test", "make", "ls", "ls", "ls"}},
+		{RANKER_SUCCESS_WEIGHTED, []string{"make", "ls", "ls", "ls", "go test", "git status", "git status"}},
+	}
+	require.Len(t, testcases, len(RANKERS))
+	for _, tc := range testcases {
+		conf := hctx.GetConf(ctx)
+		conf.Ranker = tc.ranker
+		require.NoError(t, hctx.SetConfig(conf))
+		require.Equal(t, tc.ranker, GetConfiguredRanker(ctx).Name())
+
+		results, err := Search(ctx, db, "", 10)
+		require.NoError(t, err)
+		commands := make([]string, 0)
+		for _, result := range results {
+			commands = append(commands, result.Command)
+		}
+		require.Equal(t, tc.expected, commands, "ranker=%s", tc.ranker)
+
+		// Rankers don't apply to non-default sort orders
+		results, err = SearchWithOrder(ctx, db, "", 10, SearchOrder{Column: SORT_BY_TIME, Ascending: true})
+		require.NoError(t, err)
+		require.Equal(t, "git status", results[0].Command)
+		require.Equal(t, "go test", results[len(results)-1].Command)
+	}
+}
+
+func TestGetConfiguredRanker(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	conf := hctx.GetConf(ctx)
+
+	// Defaults to recency
+	require.Equal(t, RANKER_RECENCY, GetConfiguredRanker(ctx).Name())
+
+	// Configs that predate rankers fall back to the legacy option
+	conf.RankBySuccessInCwd = true
+	require.Equal(t, RANKER_SUCCESS_WEIGHTED, GetConfiguredRanker(ctx).Name())
+
+	// But an explicitly configured ranker takes precedence
+	conf.Ranker = RANKER_FRECENCY
+	require.Equal(t, RANKER_FRECENCY, GetConfiguredRanker(ctx).Name())
+
+	// Unknown rankers fall back to recency
+	conf.Ranker = "foo"
+	require.Equal(t, RANKER_RECENCY, GetConfiguredRanker(ctx).Name())
+	_, err := GetRanker("foo")
+	require.Error(t, err)
+
+	// Cycling wraps around
+	ranker := GetConfiguredRanker(ctx)
+	for range RANKERS {
+		ranker = NextRanker(ranker)
+	}
+	require.Equal(t, RANKER_RECENCY, ranker.Name())
+}
+
+func TestRankersWithNormalization(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	db := hctx.GetDb(ctx)
+	for _, command := range []string{"ls  -la", "ls -la;", "ls -la", "make", "make"} {
+		require.NoError(t, db.Create(testutils.MakeFakeHistoryEntry(command)).Error)
+	}
+	search := func() []string {
+		results, err := Search(ctx, db, "", 10)
+		require.NoError(t, err)
+		commands := make([]string, 0)
+		for _, result := range results {
+			commands = append(commands, result.Command)
+		}
+		return commands
+	}
+
+	// Without normalization, each variant of ls is ranked separately
+	conf := hctx.GetConf(ctx)
+	conf.Ranker = RANKER_FRECENCY
+	require.Equal(t, []string{"make", "make", "ls -la", "ls -la;", "ls  -la"}, search())
+
+	// With it, they're ranked together while still displaying the original commands
+	conf.NormalizeCommands = true
+	require.Equal(t, []string{"ls -la", "ls -la;", "ls  -la", "make", "make"}, search())
+
+	// Including for entries that were inserted after the normalized commands were backfilled
+	require.NoError(t, db.Create(testutils.MakeFakeHistoryEntry("make")).Error)
+	require.NoError(t, db.Create(testutils.MakeFakeHistoryEntry("make;")).Error)
+	require.Equal(t, []string{"make;", "make", "make", "make", "ls -la", "ls -la;", "ls  -la"}, search())
+}
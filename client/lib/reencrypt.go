@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// The number of entries re-encrypted and re-uploaded at a time after the payload format is upgraded
+const REENCRYPTION_BATCH_SIZE = 100
+
+// The minimum time between re-encrypting batches, so that upgrading the payload format of a large history is spread out
+// over many commands rather than being one disruptive migration
+const REENCRYPTION_INTERVAL = time.Minute
+
+// Re-encrypts the next batch of this device's entries in the backend with the current payload format (see
+// data.CurrentPayloadVersion), if the payload format was upgraded since they were uploaded. Progress is stored in the
+// config so that re-encryption resumes where it left off, and batches are throttled by REENCRYPTION_INTERVAL.
+func MaybeReencryptHistory(ctx context.Context) error {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline || !config.SyncMode.CanUpload() {
+		return nil
+	}
+	if config.Reencryption == nil || config.Reencryption.PayloadVersion < data.CurrentPayloadVersion {
+		// The payload format was upgraded, so start (or restart) re-encrypting from the oldest entry
+		config.Reencryption = &hctx.ReencryptionProgress{PayloadVersion: data.CurrentPayloadVersion}
+	}
+	progress := config.Reencryption
+	if progress.Done || time.Since(time.Unix(progress.LastBatchTimestamp, 0)) < REENCRYPTION_INTERVAL {
+		return nil
+	}
+	// Record the attempt before uploading so that a slow or failing backend doesn't get retried on every command
+	progress.LastBatchTimestamp = time.Now().Unix()
+	if err := hctx.SetConfig(config); err != nil {
+		return fmt.Errorf("failed to persist re-encryption progress: %w", err)
+	}
+	err := reencryptNextBatch(ctx, REENCRYPTION_BATCH_SIZE)
+	if IsOfflineError(ctx, err) {
+		return nil
+	}
+	return err
+}
+
+// Re-encrypts and re-uploads the next numEntries entries after the cursor in config.Reencryption. Only entries recorded
+// on this device are re-encrypted, so that each entry is only re-uploaded once rather than once per device.
+func reencryptNextBatch(ctx context.Context, numEntries int) error {
+	config := hctx.GetConf(ctx)
+	progress := config.Reencryption
+	var entries []*data.HistoryEntry
+	err := hctx.GetDb(ctx).
+		// Very old entries without IDs can't be matched up with their copies in the backend, so they're skipped
+		Where("device_id = ? AND entry_id != ''", config.DeviceId).
+		Where("end_time > ? OR (end_time = ? AND entry_id > ?)", progress.CursorTime, progress.CursorTime, progress.CursorEntryId).
+		Order("end_time ASC, entry_id ASC").
+		Limit(numEntries).
+		Find(&entries).Error
+	if err != nil {
+		return fmt.Errorf("failed to query entries to re-encrypt: %w", err)
+	}
+	if len(entries) == 0 {
+		progress.Done = true
+		return hctx.SetConfig(config)
+	}
+	encEntries, err := encryptEntries(config, entries)
+	if err != nil {
+		return err
+	}
+	jsonValue, err := json.Marshal(encEntries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal re-encrypted entries: %w", err)
+	}
+	_, err = ApiPost(ctx, "/api/v1/reencrypt?user_id="+data.UserId(config.UserSecret), "application/json", jsonValue)
+	if err != nil {
+		return fmt.Errorf("failed to upload re-encrypted entries: %w", err)
+	}
+	lastEntry := entries[len(entries)-1]
+	progress.CursorTime = lastEntry.EndTime
+	progress.CursorEntryId = lastEntry.EntryId
+	progress.NumReencrypted += len(entries)
+	return hctx.SetConfig(config)
+}
@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+func getRemoteConfigPath(homedir string) string {
+	return path.Join(homedir, data.GetHishtoryPath(), "remote-config.json")
+}
+
+// Fetches the remote config from the backend and caches it so that feature flags can be checked without a network
+// request. Backends that predate /api/v1/remote-config only support banners, so for them the banner is wrapped in an
+// otherwise empty config.
+func FetchRemoteConfig(ctx context.Context) (*shared.RemoteConfig, error) {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline {
+		return &shared.RemoteConfig{}, nil
+	}
+	url := "/api/v1/remote-config?commit_hash=" + GitCommit + "&user_id=" + data.UserId(config.UserSecret) + "&device_id=" + config.DeviceId + "&version=" + Version + "&forced_banner=" + os.Getenv("FORCED_BANNER")
+	respBody, err := ApiGet(ctx, url)
+	if isUnsupportedEndpointError(err) {
+		banner, err := GetBanner(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &shared.RemoteConfig{Banner: string(banner)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var remoteConfig shared.RemoteConfig
+	if err := json.Unmarshal(respBody, &remoteConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse remote config: %w", err)
+	}
+	if err := os.WriteFile(getRemoteConfigPath(hctx.GetHome(ctx)), respBody, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to cache remote config: %w", err)
+	}
+	remoteConfigCache.Lock()
+	defer remoteConfigCache.Unlock()
+	remoteConfigCache.config = &remoteConfig
+	remoteConfigCache.loaded = true
+	return &remoteConfig, nil
+}
+
+// The cached remote config, which is read from disk at most once per process
+var remoteConfigCache struct {
+	sync.Mutex
+	config *shared.RemoteConfig
+	loaded bool
+}
+
+// Returns the most recently fetched remote config, or nil if it has never been fetched
+func getCachedRemoteConfig(ctx context.Context) *shared.RemoteConfig {
+	remoteConfigCache.Lock()
+	defer remoteConfigCache.Unlock()
+	if remoteConfigCache.loaded {
+		return remoteConfigCache.config
+	}
+	remoteConfigCache.loaded = true
+	respBody, err := os.ReadFile(getRemoteConfigPath(hctx.GetHome(ctx)))
+	if err != nil {
+		return nil
+	}
+	var remoteConfig shared.RemoteConfig
+	if err := json.Unmarshal(respBody, &remoteConfig); err != nil {
+		hctx.GetLogger().Infof("Ignoring invalid cached remote config: %v", err)
+		return nil
+	}
+	remoteConfigCache.config = &remoteConfig
+	return remoteConfigCache.config
+}
+
+// Returns whether the given client feature is enabled for this user according to the cached remote config. Features
+// are enabled if the remote config hasn't been fetched yet (e.g. while offline).
+func IsFeatureEnabled(ctx context.Context, feature string) bool {
+	return getCachedRemoteConfig(ctx).IsFeatureEnabled(feature, data.UserId(hctx.GetConf(ctx).UserSecret))
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// Returns the entries matching the query in the order that they were run (oldest first) so that they can be replayed
+// or exported as a script, skipping any previous replays and exports
+func GetEntriesInRunOrder(ctx context.Context, query string, limit int) ([]*data.HistoryEntry, error) {
+	entries, err := Search(ctx, hctx.GetDb(ctx), query, limit)
+	if err != nil {
+		return nil, err
+	}
+	replayable := make([]*data.HistoryEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		cmd := strings.TrimSpace(entries[i].Command)
+		if strings.HasPrefix(cmd, "hishtory replay") || strings.HasPrefix(cmd, "hishtory export-script") {
+			continue
+		}
+		replayable = append(replayable, entries[i])
+	}
+	return replayable, nil
+}
+
+// Returns the directory that the given entry was run in, with ~ expanded to the given home directory so that entries
+// from other devices are replayed in the corresponding directory on this device
+func ResolveEntryCwd(entry *data.HistoryEntry, homedir string) string {
+	return expandHomeDirectory(entry.CurrentWorkingDirectory, homedir)
+}
+
+// Builds a shell script that runs the given commands in order, changing directories whenever a command was run in a
+// different directory than the previous one. If withComments is set, each command is preceded by a comment with when
+// it was originally run and its exit code.
+func BuildShellScript(entries []*data.HistoryEntry, withComments bool) string {
+	var sb strings.Builder
+	sb.WriteString("#!/usr/bin/env bash\n\n")
+	lastCwd := ""
+	for _, entry := range entries {
+		if entry.CurrentWorkingDirectory != "" && entry.CurrentWorkingDirectory != lastCwd {
+			sb.WriteString("cd " + quoteCwdForScript(entry.CurrentWorkingDirectory) + "\n")
+			lastCwd = entry.CurrentWorkingDirectory
+		}
+		if withComments {
+			sb.WriteString(fmt.Sprintf("# Run at %s (exit code %d)\n", entry.StartTime.Format(time.RFC3339), entry.ExitCode))
+		}
+		sb.WriteString(entry.Command + "\n")
+	}
+	return sb.String()
+}
+
+// Quotes the given directory for use in a shell script, leaving a leading ~ unquoted so that it is expanded to the home
+// directory of whoever runs the script
+func quoteCwdForScript(cwd string) string {
+	if cwd == "~" || cwd == "~/" {
+		return "~"
+	}
+	if strings.HasPrefix(cwd, "~/") {
+		return "~/" + ShellQuote(strings.TrimPrefix(cwd, "~/"))
+	}
+	return ShellQuote(cwd)
+}
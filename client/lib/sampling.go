@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ddworken/hishtory/client/data"
+	"gorm.io/gorm"
+)
+
+// The number of entries displayed when the results of a query are sampled
+const SEARCH_SAMPLE_SIZE = 200
+
+// Queries matching at least this many entries are sampled (if sampling is enabled), since paging through them is both
+// slow and rarely useful
+const SEARCH_SAMPLING_THRESHOLD = 10_000
+
+// A sample of the results of a search that matched too many entries to display them all
+type SearchSample struct {
+	// The sampled entries, most recent first
+	Entries []*data.HistoryEntry
+	// The total number of entries that matched the search
+	NumMatches int64
+}
+
+// Returns a time-stratified sample of up to sampleSize of the entries matching the query: the matches are ordered by
+// time and every Nth one is returned, so that the sample is spread across the full time range of the matches rather
+// than only containing the most recent ones. Returns nil if fewer than threshold entries match, in which case the
+// results should be displayed normally.
+func SampleSearch(ctx context.Context, db *gorm.DB, query string, sampleSize int, threshold int64) (*SearchSample, error) {
+	// First cheaply check whether there are enough matches for sampling to be worthwhile. The count is capped so that
+	// this only ever reads up to threshold matches, and thus is fast for the common case of queries with few matches.
+	tx, err := MakeWhereQueryFromSearch(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	var numCappedMatches int64
+	err = db.Table("(?) AS capped_matches", tx.Select("1").Limit(int(threshold))).Count(&numCappedMatches).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count matching entries: %w", err)
+	}
+	if numCappedMatches < threshold {
+		return nil, nil
+	}
+
+	// Then number every match by time in a single pass, and take evenly spaced matches from that
+	tx, err = MakeWhereQueryFromSearch(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	orderClause, err := makeOrderClause(ctx, DefaultSearchOrder)
+	if err != nil {
+		return nil, err
+	}
+	rankedMatches := tx.Select("history_entries.*, ROW_NUMBER() OVER (ORDER BY " + orderClause + ", rowid DESC) AS sample_rank, COUNT(*) OVER () AS sample_total")
+	var sampledRows []struct {
+		data.HistoryEntry `gorm:"embedded"`
+		SampleTotal       int64
+	}
+	err = db.Table("(?) AS ranked_matches", rankedMatches).
+		Where("(sample_rank - 1) % MAX(1, sample_total / ?) = 0", sampleSize).
+		Order("sample_rank").
+		Limit(sampleSize).
+		Find(&sampledRows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample matching entries: %w", err)
+	}
+	sample := &SearchSample{Entries: make([]*data.HistoryEntry, 0, len(sampledRows))}
+	for i := range sampledRows {
+		sample.Entries = append(sample.Entries, &sampledRows[i].HistoryEntry)
+		sample.NumMatches = sampledRows[i].SampleTotal
+	}
+	return sample, nil
+}
@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// The additional data for shared entries, so that they can't be confused with other ciphertexts
+var shareAdditionalData = []byte("hishtory-share")
+
+// Encrypts the given entries with a new random key and uploads them to the backend, and returns a link that can be used
+// to view them along with when the link expires. The key is only included in the fragment of the link (which isn't sent
+// to the backend), so anyone with the link can view the entries but the backend can't.
+func ShareEntries(ctx context.Context, entries []*data.HistoryEntry) (string, *shared.ShareResponse, error) {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline {
+		return "", nil, fmt.Errorf("sharing is not available for offline installs of hishtory since it requires the backend")
+	}
+	rawKey := make([]byte, 32)
+	if _, err := rand.Read(rawKey); err != nil {
+		return "", nil, fmt.Errorf("failed to generate a key for the shared entries: %w", err)
+	}
+	key := base64.RawURLEncoding.EncodeToString(rawKey)
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal the shared entries: %w", err)
+	}
+	ciphertext, nonce, err := data.Encrypt(key, plaintext, shareAdditionalData)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encrypt the shared entries: %w", err)
+	}
+	reqBody, err := json.Marshal(shared.SharedEntries{EncryptedData: ciphertext, Nonce: nonce})
+	if err != nil {
+		return "", nil, err
+	}
+	respBody, err := ApiPost(ctx, "/api/v1/share?user_id="+data.UserId(config.UserSecret), "application/json", reqBody)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to upload the shared entries: %w", err)
+	}
+	var resp shared.ShareResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return "", nil, fmt.Errorf("failed to parse the response from sharing entries: %w", err)
+	}
+	link := GetServerHostname(ctx) + "/api/v1/shared?share_id=" + url.QueryEscape(resp.ShareId) + "#" + key
+	return link, &resp, nil
+}
+
+// Splits a link created by ShareEntries into the URL of the encrypted entries and the key that they're encrypted with
+func ParseShareLink(link string) (string, string, error) {
+	entriesUrl, key, found := strings.Cut(strings.TrimSpace(link), "#")
+	if !found || key == "" {
+		return "", "", fmt.Errorf("the link %#v is missing the key after the '#', make sure that the full link was copied", link)
+	}
+	u, err := url.Parse(entriesUrl)
+	if err != nil || u.Query().Get("share_id") == "" {
+		return "", "", fmt.Errorf("the link %#v isn't a hishtory share link", link)
+	}
+	return entriesUrl, key, nil
+}
+
+// Downloads and decrypts the entries for a link created by ShareEntries. The entries are downloaded from the server
+// in the link (rather than the configured server) so that links can be viewed by users of any hishtory backend.
+func ViewSharedEntries(ctx context.Context, link string) ([]*data.HistoryEntry, error) {
+	entriesUrl, key, err := ParseShareLink(link)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient().Get(entriesUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to GET %s: %w", entriesUrl, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, makeApiError("GET", entriesUrl, resp)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body from GET %s: %w", entriesUrl, err)
+	}
+	var sharedEntries shared.SharedEntries
+	if err := json.Unmarshal(respBody, &sharedEntries); err != nil {
+		return nil, fmt.Errorf("failed to parse the shared entries: %w", err)
+	}
+	plaintext, err := data.Decrypt(key, sharedEntries.EncryptedData, shareAdditionalData, sharedEntries.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the shared entries (was the full link copied?): %w", err)
+	}
+	var entries []*data.HistoryEntry
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse the shared entries: %w", err)
+	}
+	hctx.GetLogger().Infof("ViewSharedEntries: loaded %d shared entries from %s\n", len(entries), entriesUrl)
+	return entries, nil
+}
@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Snippet names are restricted so that they are valid shell alias names when exported via `hishtory snippet export`
+var snippetNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func ValidateSnippetName(name string) error {
+	if !snippetNameRegex.MatchString(name) {
+		return fmt.Errorf("snippet names can only contain letters, numbers, '_', '.', and '-', got %#v", name)
+	}
+	return nil
+}
+
+// Saves the given command as a snippet under the given name, replacing any existing snippet with that name
+func SaveSnippet(ctx context.Context, name, command string) error {
+	if err := ValidateSnippetName(name); err != nil {
+		return err
+	}
+	err := hctx.GetDb(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"command", "created_at"}),
+	}).Create(&data.Snippet{Name: name, Command: command, CreatedAt: time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("failed to save snippet: %w", err)
+	}
+	return nil
+}
+
+// Returns the snippet with the given name, or an error if there is none
+func GetSnippet(ctx context.Context, name string) (*data.Snippet, error) {
+	var snippet data.Snippet
+	err := hctx.GetDb(ctx).Where("name = ?", name).First(&snippet).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("no snippet named %#v, run `hishtory snippet list` to see your snippets", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up snippet: %w", err)
+	}
+	return &snippet, nil
+}
+
+// Returns every snippet, sorted by name
+func ListSnippets(ctx context.Context) ([]*data.Snippet, error) {
+	var snippets []*data.Snippet
+	err := hctx.GetDb(ctx).Order("name ASC").Find(&snippets).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snippets: %w", err)
+	}
+	return snippets, nil
+}
+
+func DeleteSnippet(ctx context.Context, name string) error {
+	res := hctx.GetDb(ctx).Where("name = ?", name).Delete(&data.Snippet{})
+	if res.Error != nil {
+		return fmt.Errorf("failed to delete snippet: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("no snippet named %#v, run `hishtory snippet list` to see your snippets", name)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// Returns the host that the given command connects to if it is an ssh command, or an empty string otherwise. The host
+// is resolved via `ssh -G` (which applies any aliases from ~/.ssh/config without connecting) so that e.g. `ssh prod1`
+// is recorded as the host it actually connected to.
+func GetSshRemoteHost(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) < 2 || filepath.Base(fields[0]) != "ssh" {
+		return ""
+	}
+	out, err := exec.Command("ssh", append([]string{"-G"}, fields[1:]...)...).Output()
+	if err != nil {
+		hctx.GetLogger().Infof("failed to resolve the remote host for %#v: %v", command, err)
+		return ""
+	}
+	return parseSshConfigHostname(string(out))
+}
+
+// Parses the hostname from the output of `ssh -G`, which prints one lowercase option per line
+func parseSshConfigHostname(sshConfig string) string {
+	for _, line := range strings.Split(sshConfig, "\n") {
+		if hostname, found := strings.CutPrefix(line, "hostname "); found {
+			return strings.TrimSpace(hostname)
+		}
+	}
+	return ""
+}
+
+// Installs hishtory on the given SSH destination, configured with the same secret key so that it syncs history with
+// this device
+func RemoteInstall(ctx context.Context, destination string) error {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline {
+		return fmt.Errorf("remote-install isn't supported in offline mode since the remote host wouldn't be able to sync history")
+	}
+	// The secret is sent over stdin rather than as an argument so that it isn't visible in the remote process list
+	// while the install script runs. HISHTORY_SKIP_INIT_IMPORT avoids importing the existing history twice, since it
+	// is imported by `hishtory init`.
+	script := "set -e\n"
+	if server := os.Getenv("HISHTORY_SERVER"); server != "" {
+		script += "export HISHTORY_SERVER=" + ShellQuote(server) + "\n"
+	}
+	script += "curl -fsSL https://hishtory.dev/install.py | HISHTORY_SKIP_INIT_IMPORT=1 python3 -\n" +
+		"read -r secret\n" +
+		"\"$HOME/.hishtory/hishtory\" init --force \"$secret\"\n"
+	// ssh passes the command to the remote user's login shell, so run the script via sh in case that isn't POSIX
+	cmd := exec.Command("ssh", destination, "sh -c "+ShellQuote(script))
+	cmd.Stdin = strings.NewReader(config.UserSecret + "\n")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("failed to install hishtory on %s: %w", destination, err)
+	}
+	return nil
+}
+
+// Quotes the given string so that it is interpreted literally by POSIX shells
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
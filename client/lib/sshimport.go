@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// The history files that are read from remote hosts, relative to the remote user's home directory. Fish history is
+// handled specially since it isn't stored as one command per line.
+var sshHistoryFiles = []string{
+	".bash_history",
+	".zsh_history",
+	".zhistory",
+	".local/share/fish/fish_history",
+}
+
+// Information about the remote host that history was imported from
+type sshHostInfo struct {
+	Hostname string
+	Username string
+	Homedir  string
+}
+
+// Imports the shell history stored on a remote host by connecting to it via the local ssh binary, so that history from
+// hosts that don't have hishtory installed can be backfilled. The imported entries are attributed to the remote
+// hostname and user.
+func ImportHistoryOverSsh(ctx context.Context, target string) (int, error) {
+	marker := "hishtory-ssh-import-" + uuid.Must(uuid.NewRandom()).String()
+	cmd := exec.Command("ssh", target, "sh -c '"+buildSshHistoryScript(marker)+"'")
+	// Connect stdin and stderr so that password and host key prompts work
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create stdout pipe for ssh: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to run ssh (is it installed?): %w", err)
+	}
+	hostInfo, commands, parseErr := parseSshHistoryOutput(stdout, marker)
+	// Drain any remaining output so that ssh doesn't block on writing it if parsing stopped early
+	_, _ = io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return 0, fmt.Errorf("failed to read history from %s over ssh: %w", target, err)
+	}
+	if parseErr != nil {
+		return 0, fmt.Errorf("failed to parse history read from %s over ssh: %w", target, parseErr)
+	}
+	numEntriesImported, err := importCommands(ctx, Values(commands), len(commands), hostInfo.Username, hostInfo.Hostname, hostInfo.Homedir)
+	if err != nil {
+		return 0, err
+	}
+	err = Reupload(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload hishtory import: %w", err)
+	}
+	return numEntriesImported, nil
+}
+
+// Returns a POSIX shell script that prints the remote hostname, username, and home directory, followed by the contents
+// of each history file that exists. Each file is preceded by a line containing the marker and the file's path. Note that
+// the script must not contain single quotes since it is passed to `sh -c` in single quotes.
+func buildSshHistoryScript(marker string) string {
+	var sb strings.Builder
+	sb.WriteString(`uname -n; whoami; echo "$HOME"; `)
+	sb.WriteString(`for f in`)
+	for _, file := range sshHistoryFiles {
+		sb.WriteString(` "$HOME/` + file + `"`)
+	}
+	sb.WriteString(`; do if [ -r "$f" ]; then echo "` + marker + ` $f"; cat "$f"; echo; fi; done`)
+	return sb.String()
+}
+
+// Parses the output of the script built by buildSshHistoryScript into the remote host's info and the commands found
+// in its history files
+func parseSshHistoryOutput(r io.Reader, marker string) (sshHostInfo, []string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, maxSupportedLineLengthForImport), maxSupportedLineLengthForImport)
+	header := make([]string, 0, 3)
+	for len(header) < 3 && scanner.Scan() {
+		header = append(header, strings.TrimSpace(scanner.Text()))
+	}
+	if len(header) < 3 {
+		if err := scanner.Err(); err != nil {
+			return sshHostInfo{}, nil, err
+		}
+		return sshHostInfo{}, nil, fmt.Errorf("expected the remote hostname, username, and home directory but got %#v", header)
+	}
+	hostInfo := sshHostInfo{Hostname: header[0], Username: header[1], Homedir: header[2]}
+	commands := make([]string, 0)
+	isFishHistory := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, marker+" ") {
+			isFishHistory = strings.HasSuffix(line, "/fish_history")
+			continue
+		}
+		if isFishHistory {
+			if cmd, ok := parseFishHistoryLine(line); ok {
+				commands = append(commands, cmd)
+			}
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			commands = append(commands, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return sshHostInfo{}, nil, err
+	}
+	return hostInfo, commands, nil
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// The maximum number of entries that are uploaded in a single atomic batch. Larger uploads are split across several
+// batches.
+const MAX_SYNC_BATCH_SIZE = 10_000
+
+// Uploads the given entries and deletion request as a single batch that the backend applies atomically. Note that for
+// batches that also insert entries (e.g. an edit), the deletion request must identify entries only by their entry ID.
+// If idempotencyKey is set, the backend skips the batch if it already applied one with the same key.
+func SubmitBatch(ctx context.Context, entries []*data.HistoryEntry, deletionRequest *shared.DeletionRequest, idempotencyKey string) error {
+	config := hctx.GetConf(ctx)
+	encEntries, err := encryptEntries(config, entries)
+	if err != nil {
+		return err
+	}
+	batch := shared.SyncBatch{UserId: data.UserId(config.UserSecret), Entries: encEntries, DeletionRequest: deletionRequest, IdempotencyKey: idempotencyKey}
+	jsonValue, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync batch: %w", err)
+	}
+	_, err = ApiPost(ctx, "/api/v1/submit-batch?source_device_id="+config.DeviceId, "application/json", jsonValue)
+	if isUnsupportedEndpointError(err) {
+		// Self-hosted backends running an older version don't support batches, so fall back to uploading the
+		// changes individually
+		hctx.GetLogger().Infof("Backend doesn't support sync batches, falling back to non-atomic uploads: %v", err)
+		return submitUnbatched(ctx, entries, deletionRequest)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to submit sync batch: %w", err)
+	}
+	return nil
+}
+
+func submitUnbatched(ctx context.Context, entries []*data.HistoryEntry, deletionRequest *shared.DeletionRequest) error {
+	config := hctx.GetConf(ctx)
+	if deletionRequest != nil {
+		if err := SendDeletionRequest(ctx, *deletionRequest); err != nil {
+			return err
+		}
+	}
+	for _, chunk := range shared.Chunks(entries, 500) {
+		jsonValue, err := EncryptAndMarshal(config, chunk)
+		if err != nil {
+			return err
+		}
+		_, err = ApiPost(ctx, "/api/v1/submit?source_device_id="+config.DeviceId, "application/json", jsonValue)
+		if err != nil {
+			return fmt.Errorf("failed to submit history entries: %w", err)
+		}
+	}
+	return nil
+}
+
+// Whether the error is due to the backend not having the requested endpoint at all, as opposed to a structured error
+// returned by the endpoint (e.g. ErrorCodeUnknownUser, which also uses a 404)
+func isUnsupportedEndpointError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var errResp *shared.ErrorResponse
+	return !errors.As(err, &errResp) && strings.Contains(err.Error(), ": status_code=404")
+}
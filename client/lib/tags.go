@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/google/uuid"
+)
+
+// Returns the given tag with surrounding whitespace removed, or an error if it isn't a valid tag. Tags can't contain
+// whitespace so that they can be searched for via the tag: atom.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return "", fmt.Errorf("tags can't be empty")
+	}
+	if strings.ContainsAny(tag, " \t\n,") {
+		return "", fmt.Errorf("tags can't contain whitespace or commas, got %#v", tag)
+	}
+	return tag, nil
+}
+
+// Returns the given tags with the added tags appended (if they aren't already present) and the removed tags removed,
+// along with whether anything was changed
+func applyTagChanges(tags data.Tags, add, remove []string) (data.Tags, bool) {
+	updated := make(data.Tags, 0, len(tags)+len(add))
+	for _, tag := range tags {
+		if !slices.Contains(remove, tag) {
+			updated = append(updated, tag)
+		}
+	}
+	for _, tag := range add {
+		if !slices.Contains(updated, tag) {
+			updated = append(updated, tag)
+		}
+	}
+	return updated, !slices.Equal(tags, updated)
+}
+
+// Adds and removes the given tags on each of the given entries. Returns the entries in the same order, with the ones
+// that were changed replaced by the updated entries, along with the number of entries that were changed.
+func UpdateTags(ctx context.Context, entries []*data.HistoryEntry, add, remove []string) ([]*data.HistoryEntry, int, error) {
+	for _, tags := range [][]string{add, remove} {
+		for i, tag := range tags {
+			normalized, err := NormalizeTag(tag)
+			if err != nil {
+				return nil, 0, err
+			}
+			tags[i] = normalized
+		}
+	}
+	ret := make([]*data.HistoryEntry, 0, len(entries))
+	var oldEntries []*data.HistoryEntry
+	var newEntries []*data.HistoryEntry
+	for _, entry := range entries {
+		tags, changed := applyTagChanges(entry.Tags, add, remove)
+		if !changed {
+			ret = append(ret, entry)
+			continue
+		}
+		if entry.EntryId == "" {
+			// Entries recorded by very old versions of hishtory don't have an entry ID, so the original entry couldn't
+			// be deleted on other devices without also deleting the updated entry
+			hctx.GetLogger().Infof("Skipping tagging entry with no entry ID: %#v", entry)
+			ret = append(ret, entry)
+			continue
+		}
+		newEntry := *entry
+		newEntry.Tags = tags
+		newEntry.EntryId = uuid.Must(uuid.NewRandom()).String()
+		oldEntries = append(oldEntries, entry)
+		newEntries = append(newEntries, &newEntry)
+		ret = append(ret, &newEntry)
+	}
+	err := replaceHistoryEntries(ctx, oldEntries, newEntries)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ret, len(newEntries), nil
+}
+
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// Returns every tag that is on at least one entry along with the number of entries it is on, most used first
+func ListTags(ctx context.Context) ([]TagCount, error) {
+	var counts []TagCount
+	err := hctx.GetDb(ctx).Raw(`SELECT json_each.value AS tag, COUNT(*) AS count
+		FROM history_entries, json_each(history_entries.tags)
+		WHERE json_each.type = 'text'
+		GROUP BY json_each.value
+		ORDER BY count DESC, tag ASC`).Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	return counts, nil
+}
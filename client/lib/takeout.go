@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// Downloads a takeout archive of everything that the backend stores for the current user, and decrypts it
+func RequestTakeout(ctx context.Context) ([]*data.HistoryEntry, error) {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline {
+		return nil, fmt.Errorf("takeout is not available for offline installs of hishtory since nothing is stored in the backend")
+	}
+	respBody, err := ApiGet(ctx, "/api/v1/export?user_id="+data.UserId(config.UserSecret)+"&device_id="+config.DeviceId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download takeout archive: %w", err)
+	}
+	return DecryptTakeout(bytes.NewReader(respBody), config.UserSecret)
+}
+
+// Decrypts the entries in the given takeout archive (see shared.ContentTypeTakeout), and returns them ordered by when
+// they were run
+func DecryptTakeout(r io.Reader, userSecret string) ([]*data.HistoryEntry, error) {
+	entries := make([]*data.HistoryEntry, 0)
+	seenEntryIds := make(map[string]bool)
+	err := shared.ReadTakeout(r, func(encEntry *shared.EncHistoryEntry) error {
+		entry, err := data.DecryptHistoryEntry(userSecret, *encEntry)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt takeout entry: %w", err)
+		}
+		if entry.EntryId != "" {
+			if seenEntryIds[entry.EntryId] {
+				return nil
+			}
+			seenEntryIds[entry.EntryId] = true
+		}
+		entries = append(entries, &entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartTime.Before(entries[j].StartTime)
+	})
+	return entries, nil
+}
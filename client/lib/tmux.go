@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A tmux pane, as returned by GetActiveTmuxPane
+type TmuxPane struct {
+	// The unique ID of the pane (e.g. %3), which can be used as a tmux target
+	Id string
+	// The current working directory of the pane
+	Cwd string
+	// The name of the command running in the pane, which is the shell when the pane is at a prompt
+	Command string
+}
+
+// Runs `tmux display-message` to format the given tab-separated fields for the current pane
+func getTmuxFields(fields ...string) ([]string, error) {
+	args := []string{"display-message", "-p"}
+	if pane := os.Getenv("TMUX_PANE"); pane != "" {
+		// Target the pane the command is running in, rather than whichever pane is currently active
+		args = append(args, "-t", pane)
+	}
+	args = append(args, strings.Join(fields, "\t"))
+	out, err := exec.Command("tmux", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tmux: %w", err)
+	}
+	values := strings.Split(strings.TrimSuffix(string(out), "\n"), "\t")
+	if len(values) != len(fields) {
+		return nil, fmt.Errorf("unexpected output from tmux display-message: %#v", string(out))
+	}
+	return values, nil
+}
+
+// Returns the tmux session name, window index, and pane index that the current command is running in, or empty
+// strings if it isn't running in tmux
+func GetTmuxInfo() (string, string, string) {
+	if os.Getenv("TMUX") == "" {
+		return "", "", ""
+	}
+	values, err := getTmuxFields("#{session_name}", "#{window_index}", "#{pane_index}")
+	if err != nil {
+		return "", "", ""
+	}
+	return values[0], values[1], values[2]
+}
+
+// Returns the tmux pane that hishtory was run from, or the active pane if it was run from outside of a pane (e.g.
+// via a tmux key binding)
+func GetActiveTmuxPane() (*TmuxPane, error) {
+	if os.Getenv("TMUX") == "" {
+		return nil, fmt.Errorf("not running inside of tmux")
+	}
+	values, err := getTmuxFields("#{pane_id}", "#{pane_current_path}", "#{pane_current_command}")
+	if err != nil {
+		return nil, err
+	}
+	return &TmuxPane{Id: values[0], Cwd: values[1], Command: values[2]}, nil
+}
+
+// Types the given text into the given tmux pane, without pressing enter so that it can be edited before it is run
+func PasteIntoTmuxPane(paneId, text string) error {
+	out, err := exec.Command("tmux", "send-keys", "-t", paneId, "-l", text).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to paste into tmux pane %s (output=%#v): %w", paneId, string(out), err)
+	}
+	return nil
+}
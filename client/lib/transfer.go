@@ -0,0 +1,300 @@
+package lib
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The number of history entries sent per message during a transfer
+const transferBatchSize = 1000
+
+// The maximum size of a single message during a transfer, to bound the memory used for messages from a misbehaving peer
+const maxTransferMessageSize = 64 * 1024 * 1024
+
+// The alphabet for transfer codes, which omits characters that are easily confused with each other (0/O and 1/I/L)
+const transferCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// The number of characters in a transfer code, which is enough (~59 bits) that the code can't be brute forced from a
+// recorded handshake given the cost of deriving keys from it
+const transferCodeLength = 12
+
+type TransferStats struct {
+	// The number of entries sent to the other device
+	NumSent int
+	// The number of entries received from the other device that weren't already in the local DB
+	NumReceived int
+}
+
+// Returns a random code (e.g. "K7PM-XQ2D-9RTA") that must be entered on both devices to pair them for a transfer
+func NewTransferCode() (string, error) {
+	var sb strings.Builder
+	for i := 0; i < transferCodeLength; i++ {
+		if i > 0 && i%4 == 0 {
+			sb.WriteString("-")
+		}
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(transferCodeAlphabet))))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate transfer code: %w", err)
+		}
+		sb.WriteByte(transferCodeAlphabet[idx.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// Normalizes a transfer code so that it can be entered without dashes and in any case
+func normalizeTransferCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// Waits for the other device to connect on the given port, and then transfers history with it. onListening is called
+// with the address that is being listened on once the other device can connect.
+func ListenForTransfer(ctx context.Context, port int, code string, onListening func(addr net.Addr)) (*TransferStats, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for transfers: %w", err)
+	}
+	defer listener.Close()
+	onListening(listener.Addr())
+	// Only a single connection is accepted, so that a failed pairing can't be retried to guess the code
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept transfer connection: %w", err)
+	}
+	defer conn.Close()
+	return runTransfer(ctx, conn, code, true)
+}
+
+// Connects to a device that is listening for a transfer, and then transfers history with it
+func ConnectForTransfer(ctx context.Context, addr, code string) (*TransferStats, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	return runTransfer(ctx, conn, code, false)
+}
+
+// Sends every local history entry to the other device while concurrently receiving (and storing) its entries
+func runTransfer(ctx context.Context, conn io.ReadWriter, code string, isListener bool) (*TransferStats, error) {
+	channel, err := newTransferChannel(conn, code, isListener)
+	if err != nil {
+		return nil, err
+	}
+	stats := &TransferStats{}
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- sendTransferEntries(ctx, channel, stats)
+	}()
+	err = receiveTransferEntries(ctx, channel, stats)
+	if err != nil {
+		return nil, err
+	}
+	if err := <-sendErr; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func sendTransferEntries(ctx context.Context, channel *transferChannel, stats *TransferStats) error {
+	batch := make([]*data.HistoryEntry, 0, transferBatchSize)
+	sendBatch := func() error {
+		msg, err := json.Marshal(batch)
+		if err != nil {
+			return fmt.Errorf("failed to marshal history entries: %w", err)
+		}
+		stats.NumSent += len(batch)
+		batch = batch[:0]
+		return channel.send(msg)
+	}
+	err := StreamSearch(ctx, hctx.GetDb(ctx), "", DefaultSearchOrder, func(entry *data.HistoryEntry) error {
+		batch = append(batch, entry)
+		if len(batch) < transferBatchSize {
+			return nil
+		}
+		return sendBatch()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send history entries: %w", err)
+	}
+	if len(batch) > 0 {
+		if err := sendBatch(); err != nil {
+			return err
+		}
+	}
+	// An empty batch marks the end of the transfer
+	return sendBatch()
+}
+
+func receiveTransferEntries(ctx context.Context, channel *transferChannel, stats *TransferStats) error {
+	db := hctx.GetDb(ctx)
+	for {
+		msg, err := channel.receive()
+		if err != nil {
+			return fmt.Errorf("failed to receive history entries: %w", err)
+		}
+		var batch []*data.HistoryEntry
+		if err := json.Unmarshal(msg, &batch); err != nil {
+			return fmt.Errorf("failed to unmarshal received history entries: %w", err)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		for _, entry := range batch {
+			if AddToDbIfNew(db, *entry) {
+				stats.NumReceived += 1
+			}
+		}
+	}
+}
+
+// An encrypted and mutually authenticated channel between two devices that know the same transfer code
+type transferChannel struct {
+	conn        io.ReadWriter
+	sendCipher  cipher.AEAD
+	recvCipher  cipher.AEAD
+	sendCounter uint64
+	recvCounter uint64
+}
+
+// Pairs with the other device over conn. Both devices exchange random nonces and derive keys from the transfer code
+// and the nonces via scrypt (so that the code can't practically be brute forced from a recorded handshake). They then
+// prove to each other that they derived the same keys, which authenticates both of them since only devices that know
+// the code can do so. Note that the listener always writes first so that this works over unbuffered connections.
+func newTransferChannel(conn io.ReadWriter, code string, isListener bool) (*transferChannel, error) {
+	ownNonce := make([]byte, 32)
+	if _, err := rand.Read(ownNonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	peerNonce := make([]byte, 32)
+	var listenerNonce, connectorNonce []byte
+	if isListener {
+		if _, err := conn.Write(ownNonce); err != nil {
+			return nil, fmt.Errorf("failed to send nonce: %w", err)
+		}
+		if _, err := io.ReadFull(conn, peerNonce); err != nil {
+			return nil, fmt.Errorf("failed to receive nonce: %w", err)
+		}
+		listenerNonce, connectorNonce = ownNonce, peerNonce
+	} else {
+		if _, err := io.ReadFull(conn, peerNonce); err != nil {
+			return nil, fmt.Errorf("failed to receive nonce: %w", err)
+		}
+		if _, err := conn.Write(ownNonce); err != nil {
+			return nil, fmt.Errorf("failed to send nonce: %w", err)
+		}
+		listenerNonce, connectorNonce = peerNonce, ownNonce
+	}
+	salt := append(append([]byte{}, listenerNonce...), connectorNonce...)
+	keys, err := scrypt.Key([]byte(normalizeTransferCode(code)), salt, 1<<15, 8, 1, 96)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive transfer keys: %w", err)
+	}
+	confirmationKey, listenerKey, connectorKey := keys[:32], keys[32:64], keys[64:]
+
+	// Key confirmation. The connector only sends its proof after verifying the listener's, so that an impostor
+	// listener learns nothing that could be used to brute force the code.
+	listenerProof := transferKeyProof(confirmationKey, "listener")
+	connectorProof := transferKeyProof(confirmationKey, "connector")
+	ownProof, expectedPeerProof := connectorProof, listenerProof
+	if isListener {
+		ownProof, expectedPeerProof = listenerProof, connectorProof
+		if _, err := conn.Write(ownProof); err != nil {
+			return nil, fmt.Errorf("failed to send key confirmation: %w", err)
+		}
+	}
+	peerProof := make([]byte, len(expectedPeerProof))
+	if _, err := io.ReadFull(conn, peerProof); err != nil {
+		return nil, fmt.Errorf("failed to receive key confirmation (does the other device have the same code?): %w", err)
+	}
+	if !hmac.Equal(peerProof, expectedPeerProof) {
+		return nil, fmt.Errorf("failed to pair with the other device, check that both devices are using the same code")
+	}
+	if !isListener {
+		if _, err := conn.Write(ownProof); err != nil {
+			return nil, fmt.Errorf("failed to send key confirmation: %w", err)
+		}
+	}
+
+	listenerCipher, err := newTransferCipher(listenerKey)
+	if err != nil {
+		return nil, err
+	}
+	connectorCipher, err := newTransferCipher(connectorKey)
+	if err != nil {
+		return nil, err
+	}
+	if isListener {
+		return &transferChannel{conn: conn, sendCipher: listenerCipher, recvCipher: connectorCipher}, nil
+	}
+	return &transferChannel{conn: conn, sendCipher: connectorCipher, recvCipher: listenerCipher}, nil
+}
+
+func transferKeyProof(key []byte, role string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("hishtory-transfer-" + role))
+	return mac.Sum(nil)
+}
+
+func newTransferCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transfer cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the nonce for the nth message in one direction. Each direction has its own key, so nonces are never reused.
+func transferNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+func (c *transferChannel) send(msg []byte) error {
+	ciphertext := c.sendCipher.Seal(nil, transferNonce(c.sendCipher, c.sendCounter), msg, nil)
+	c.sendCounter += 1
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(ciphertext)))
+	if _, err := c.conn.Write(append(header, ciphertext...)); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+func (c *transferChannel) receive() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxTransferMessageSize {
+		return nil, fmt.Errorf("received a message of %d bytes, which exceeds the maximum of %d bytes", size, maxTransferMessageSize)
+	}
+	ciphertext := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, ciphertext); err != nil {
+		return nil, err
+	}
+	msg, err := c.recvCipher.Open(nil, transferNonce(c.recvCipher, c.recvCounter), ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	c.recvCounter += 1
+	return msg, nil
+}
@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Moves the given entry to the trash, removing it from the local history. The deletion is only sent to other devices
+// once the entry is permanently deleted from the trash (see EmptyTrash), so that it can be undone until then.
+func TrashHistoryEntry(ctx context.Context, entry data.HistoryEntry) error {
+	trashId := entry.EntryId
+	if trashId == "" {
+		// Very old entries don't have IDs
+		trashId = uuid.Must(uuid.NewRandom()).String()
+	}
+	return hctx.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("device_id = ? AND end_time = ?", entry.DeviceId, entry.EndTime).Delete(&data.HistoryEntry{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to delete entry: %w", err)
+		}
+		err = tx.Create(&data.TrashedEntry{TrashId: trashId, Entry: entry, TrashedAt: time.Now()}).Error
+		if err != nil {
+			return fmt.Errorf("failed to move entry to the trash: %w", err)
+		}
+		return nil
+	})
+}
+
+// Returns every entry in the trash, most recently trashed first
+func ListTrashedEntries(ctx context.Context) ([]*data.TrashedEntry, error) {
+	var trashed []*data.TrashedEntry
+	err := hctx.GetDb(ctx).Order("trashed_at DESC").Find(&trashed).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trashed entries: %w", err)
+	}
+	return trashed, nil
+}
+
+// Moves the trashed entries with the given IDs back into the local history
+func RestoreTrashedEntries(ctx context.Context, trashIds []string) ([]*data.TrashedEntry, error) {
+	var trashed []*data.TrashedEntry
+	err := hctx.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("trash_id IN ?", trashIds).Order("trashed_at DESC").Find(&trashed).Error
+		if err != nil {
+			return fmt.Errorf("failed to look up trashed entries: %w", err)
+		}
+		if len(trashed) != len(trashIds) {
+			return fmt.Errorf("some of the given IDs are not in the trash, run `hishtory trash list` to see the trashed entries")
+		}
+		for _, t := range trashed {
+			err = tx.Create(&t.Entry).Error
+			if err != nil {
+				return fmt.Errorf("failed to restore entry: %w", err)
+			}
+		}
+		return tx.Where("trash_id IN ?", trashIds).Delete(&data.TrashedEntry{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trashed, nil
+}
+
+// Moves the most recently trashed entry back into the local history. Returns nil if the trash is empty.
+func RestoreMostRecentlyTrashedEntry(ctx context.Context) (*data.TrashedEntry, error) {
+	var trashed []*data.TrashedEntry
+	err := hctx.GetDb(ctx).Order("trashed_at DESC").Limit(1).Find(&trashed).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up trashed entries: %w", err)
+	}
+	if len(trashed) == 0 {
+		return nil, nil
+	}
+	restored, err := RestoreTrashedEntries(ctx, []string{trashed[0].TrashId})
+	if err != nil {
+		return nil, err
+	}
+	return restored[0], nil
+}
+
+// Permanently deletes the entries that were trashed before the given time, and sends a deletion request so that they
+// are also deleted on all other devices. Returns the number of entries deleted.
+func EmptyTrash(ctx context.Context, trashedBefore time.Time) (int, error) {
+	db := hctx.GetDb(ctx)
+	var trashed []*data.TrashedEntry
+	err := db.Where("trashed_at < ?", trashedBefore).Find(&trashed).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up trashed entries: %w", err)
+	}
+	if len(trashed) == 0 {
+		return 0, nil
+	}
+	config := hctx.GetConf(ctx)
+	trashIds := make([]string, 0, len(trashed))
+	dr := shared.DeletionRequest{
+		UserId:   data.UserId(config.UserSecret),
+		SendTime: time.Now(),
+	}
+	for _, t := range trashed {
+		trashIds = append(trashIds, t.TrashId)
+		dr.Messages.Ids = append(dr.Messages.Ids,
+			shared.MessageIdentifier{DeviceId: t.Entry.DeviceId, EndTime: t.Entry.EndTime, EntryId: t.Entry.EntryId},
+		)
+	}
+	if !config.IsOffline {
+		// Note that if this fails, the entries are left in the trash so that this is retried later
+		err = SendDeletionRequest(ctx, dr)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = db.Where("trash_id IN ?", trashIds).Delete(&data.TrashedEntry{}).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to empty the trash: %w", err)
+	}
+	return len(trashed), nil
+}
+
+// Permanently deletes the entries that have been in the trash for longer than the configured retention period. This is
+// called after saving history entries (which happens in the background).
+func MaybeEmptyTrash(ctx context.Context) error {
+	retention := time.Duration(hctx.GetConf(ctx).TrashRetentionDays) * 24 * time.Hour
+	_, err := EmptyTrash(ctx, time.Now().Add(-retention))
+	if IsOfflineError(ctx, err) {
+		return nil
+	}
+	return err
+}
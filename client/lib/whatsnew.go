@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// Caches the release notes from the given update info so that they're displayed the next time the TUI is opened, if
+// they're for the installed version and haven't already been displayed
+func CacheReleaseNotes(ctx context.Context, updateInfo shared.UpdateInfo) error {
+	config := hctx.GetConf(ctx)
+	notes := updateInfo.ReleaseNotes
+	if notes == nil || notes.Version != "v0."+Version || notes.Version == config.SeenReleaseNotesVersion {
+		return nil
+	}
+	config.PendingReleaseNotes = notes
+	return hctx.SetConfig(config)
+}
+
+// Returns the release notes that should be displayed in the TUI, or nil if there are none
+func GetUnseenReleaseNotes(ctx context.Context) *shared.ReleaseNotes {
+	config := hctx.GetConf(ctx)
+	notes := config.PendingReleaseNotes
+	if !config.ShowWhatsNew || notes == nil || notes.Version != "v0."+Version || notes.Version == config.SeenReleaseNotesVersion {
+		return nil
+	}
+	return notes
+}
+
+// Records that the given release notes were displayed, so that they aren't displayed again
+func MarkReleaseNotesSeen(ctx context.Context, notes *shared.ReleaseNotes) error {
+	config := hctx.GetConf(ctx)
+	config.SeenReleaseNotesVersion = notes.Version
+	config.PendingReleaseNotes = nil
+	return hctx.SetConfig(config)
+}
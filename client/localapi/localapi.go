@@ -0,0 +1,173 @@
+package localapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const DEFAULT_SEARCH_LIMIT = 100
+
+type Stats struct {
+	// The total number of history entries
+	NumEntries int64 `json:"num_entries"`
+	// The number of distinct commands
+	NumUniqueCommands int64 `json:"num_unique_commands"`
+	// Stats for the command specified via the cmd query parameter, if any
+	Command *lib.CommandHistoryStats `json:"command,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		hctx.GetLogger().Infof("failed to write local API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJson(w, statusCode, errorResponse{Error: err.Error()})
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	limit := DEFAULT_SEARCH_LIMIT
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit=%#v must be a positive integer", limitStr))
+			return
+		}
+		limit = parsedLimit
+	}
+	results, err := lib.Search(ctx, hctx.GetDb(ctx), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJson(w, http.StatusOK, results)
+}
+
+func entryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	entryId := r.URL.Query().Get("entry_id")
+	if entryId == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing required query parameter entry_id"))
+		return
+	}
+	var entry data.HistoryEntry
+	err := lib.RetryingDbFunction(func() error {
+		return hctx.GetDb(ctx).Where("entry_id = ?", entryId).First(&entry).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no history entry with entry_id=%#v", entryId))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to query for history entry: %w", err))
+		return
+	}
+	writeJson(w, http.StatusOK, entry)
+}
+
+func getStats(ctx context.Context, cmd string) (Stats, error) {
+	var stats Stats
+	db := hctx.GetDb(ctx)
+	err := lib.RetryingDbFunction(func() error {
+		return db.Model(&data.HistoryEntry{}).Count(&stats.NumEntries).Error
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to count history entries: %w", err)
+	}
+	err = lib.RetryingDbFunction(func() error {
+		return db.Model(&data.HistoryEntry{}).Distinct("command").Count(&stats.NumUniqueCommands).Error
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to count unique commands: %w", err)
+	}
+	if strings.TrimSpace(cmd) != "" {
+		commandStats, err := lib.GetCommandHistoryStats(ctx, cmd)
+		if err != nil {
+			return stats, err
+		}
+		stats.Command = &commandStats
+	}
+	return stats, nil
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := getStats(r.Context(), r.URL.Query().Get("cmd"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, http.StatusOK, stats)
+}
+
+func withReadOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("only GET requests are supported"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func withTokenAuth(expectedToken string) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !hasToken || subtle.ConstantTimeCompare([]byte(token), []byte(expectedToken)) != 1 {
+				w.Header().Add("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid bearer token"))
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func makeHandler(token string) http.Handler {
+	auth := withTokenAuth(token)
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/search", auth(withReadOnly(http.HandlerFunc(searchHandler))))
+	mux.Handle("/api/v1/entry", auth(withReadOnly(http.HandlerFunc(entryHandler))))
+	mux.Handle("/api/v1/stats", auth(withReadOnly(http.HandlerFunc(statsHandler))))
+	return mux
+}
+
+func StartLocalApiServer(ctx context.Context, port int, overriddenToken string) error {
+	// Note that uuid.NewRandom() uses crypto/rand and returns a UUID with 122 bits of security
+	token := uuid.Must(uuid.NewRandom()).String()
+	if overriddenToken != "" {
+		token = overriddenToken
+	}
+	server := http.Server{
+		BaseContext: func(l net.Listener) context.Context { return ctx },
+		// Only listen on localhost since this exposes your decrypted history
+		Addr:    fmt.Sprintf("127.0.0.1:%d", port),
+		Handler: makeHandler(token),
+	}
+	fmt.Printf("Starting local API server on http://%s...\n", server.Addr)
+	fmt.Printf("Token: %s\n", token)
+	return server.ListenAndServe()
+}
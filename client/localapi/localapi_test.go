@@ -0,0 +1,90 @@
+package localapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	defer testutils.BackupAndRestoreEnv("HISHTORY_TEST")()
+	os.Setenv("HISHTORY_TEST", "1")
+	m.Run()
+}
+
+func makeRequest(t *testing.T, handler http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req = req.WithContext(hctx.MakeContext())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestLocalApi(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	db := hctx.GetDb(hctx.MakeContext())
+	entry1 := testutils.MakeFakeHistoryEntry("ls -la")
+	entry1.ExitCode = 0
+	require.NoError(t, db.Create(entry1).Error)
+	entry2 := testutils.MakeFakeHistoryEntry("echo foo")
+	require.NoError(t, db.Create(entry2).Error)
+	entry3 := testutils.MakeFakeHistoryEntry("ls -la")
+	require.NoError(t, db.Create(entry3).Error)
+	handler := makeHandler("secret-token")
+
+	// Requests without the correct token are rejected
+	w := makeRequest(t, handler, "GET", "/api/v1/search?q=ls", "")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	w = makeRequest(t, handler, "GET", "/api/v1/search?q=ls", "wrong-token")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Only reads are allowed
+	w = makeRequest(t, handler, "POST", "/api/v1/search?q=ls", "secret-token")
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// Search
+	w = makeRequest(t, handler, "GET", "/api/v1/search?q=ls", "secret-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var results []data.HistoryEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	require.Len(t, results, 2)
+	require.Equal(t, entry3.EntryId, results[0].EntryId)
+	require.Equal(t, entry1.EntryId, results[1].EntryId)
+	w = makeRequest(t, handler, "GET", "/api/v1/search?q=ls&limit=1", "secret-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	require.Len(t, results, 1)
+	w = makeRequest(t, handler, "GET", "/api/v1/search?q=ls&limit=foo", "secret-token")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Get a single entry
+	w = makeRequest(t, handler, "GET", "/api/v1/entry?entry_id="+entry2.EntryId, "secret-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var entry data.HistoryEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	require.Equal(t, "echo foo", entry.Command)
+	w = makeRequest(t, handler, "GET", "/api/v1/entry?entry_id=missing", "secret-token")
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// Stats
+	w = makeRequest(t, handler, "GET", "/api/v1/stats?cmd=ls+-la", "secret-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var stats Stats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	require.EqualValues(t, 3, stats.NumEntries)
+	require.EqualValues(t, 2, stats.NumUniqueCommands)
+	require.NotNil(t, stats.Command)
+	require.Equal(t, 2, stats.Command.NumRuns)
+	require.Equal(t, 1, stats.Command.NumSuccessful)
+}
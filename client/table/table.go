@@ -4,6 +4,7 @@
 package table
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/key"
@@ -30,13 +31,34 @@ type Model struct {
 	hcol    int
 	hstep   int
 	hcursor int
+
+	// How far the cells of the selected row are scrolled, in addition to hcursor. This is reset whenever a different
+	// row is selected.
+	selectedHcursor int
+
+	// The maximum number of lines that a row containing multi-line values is displayed across. If this is 1, rows are
+	// always a single line.
+	maxRowLines int
+	// The first row that is displayed when rows may span multiple lines, which is kept stable so that the table
+	// doesn't jump around when moving the cursor
+	firstVisibleRow int
 }
 
+// The ellipsis that is displayed in place of the parts of cells that are scrolled or truncated out of view
+const ELLIPSIS = "…"
+
 // CellPosition holds row and column indexes.
 type CellPosition struct {
 	RowID         int
 	Column        int
 	IsRowSelected bool
+
+	// The full value of the cell, and the byte range of it that is displayed. The rendered cell consists of an
+	// ellipsis if VisibleStart is non-zero, then Value[VisibleStart:VisibleEnd], then an ellipsis if VisibleEnd is
+	// less than len(Value), followed by padding.
+	Value        string
+	VisibleStart int
+	VisibleEnd   int
 }
 
 // Row represents one line in the table.
@@ -61,6 +83,9 @@ type KeyMap struct {
 	GotoBottom   key.Binding
 	MoveLeft     key.Binding
 	MoveRight    key.Binding
+
+	ScrollSelectedLeft  key.Binding
+	ScrollSelectedRight key.Binding
 }
 
 // DefaultKeyMap returns a default set of keybindings.
@@ -107,6 +132,14 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("shift+right"),
 			key.WithHelp("Shift+→", "move right"),
 		),
+		ScrollSelectedLeft: key.NewBinding(
+			key.WithKeys("ctrl+shift+left"),
+			key.WithHelp("Ctrl+Shift+←", "scroll the selected row left"),
+		),
+		ScrollSelectedRight: key.NewBinding(
+			key.WithKeys("ctrl+shift+right"),
+			key.WithHelp("Ctrl+Shift+→", "scroll the selected row right"),
+		),
 	}
 }
 
@@ -134,6 +167,10 @@ type Styles struct {
 	// 	}
 	// }
 	RenderCell func(model Model, value string, position CellPosition) string
+
+	// RowStyle is an optional hook for styling entire rows (e.g. based on the data in the row). It is only
+	// applied to rows that aren't selected, since those use Styles.Selected.
+	RowStyle func(model Model, rowID int) lipgloss.Style
 }
 
 func (s Styles) renderCell(model Model, value string, position CellPosition) string {
@@ -176,6 +213,8 @@ func New(opts ...Option) Model {
 		hcol:    -1,
 		hstep:   10,
 		hcursor: 0,
+
+		maxRowLines: 1,
 	}
 
 	for _, opt := range opts {
@@ -215,6 +254,14 @@ func WithWidth(w int) Option {
 	}
 }
 
+// WithMaxRowLines sets the maximum number of lines that a row is displayed across. Values containing newlines are
+// displayed across multiple lines rather than on a single line.
+func WithMaxRowLines(n int) Option {
+	return func(m *Model) {
+		m.maxRowLines = max(n, 1)
+	}
+}
+
 // WithFocused sets the focus state of the table.
 func WithFocused(f bool) Option {
 	return func(m *Model) {
@@ -269,6 +316,10 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			m.MoveLeft(m.hstep)
 		case key.Matches(msg, m.KeyMap.MoveRight):
 			m.MoveRight(m.hstep)
+		case key.Matches(msg, m.KeyMap.ScrollSelectedLeft):
+			m.ScrollSelectedLeft(m.hstep)
+		case key.Matches(msg, m.KeyMap.ScrollSelectedRight):
+			m.ScrollSelectedRight(m.hstep)
 		}
 	}
 
@@ -312,13 +363,41 @@ func (m *Model) UpdateViewport() {
 		m.start = 0
 	}
 	m.end = clamp(m.cursor+m.viewport.Height, m.cursor, len(m.rows))
+	rowLineOffsets := make([]int, 0, m.end-m.start)
+	numLines := 0
 	for i := m.start; i < m.end; i++ {
-		renderedRows = append(renderedRows, m.renderRow(i))
+		renderedRow := m.renderRow(i)
+		renderedRows = append(renderedRows, renderedRow)
+		rowLineOffsets = append(rowLineOffsets, numLines)
+		numLines += lipgloss.Height(renderedRow)
 	}
 
 	m.viewport.SetContent(
 		lipgloss.JoinVertical(lipgloss.Left, renderedRows...),
 	)
+	if m.maxRowLines > 1 {
+		m.scrollToCursor(rowLineOffsets, numLines)
+	}
+}
+
+// Scrolls the viewport so that all the lines of the selected row are visible, for when rows may span multiple lines.
+// rowLineOffsets contains the line that each rendered row starts on.
+func (m *Model) scrollToCursor(rowLineOffsets []int, numLines int) {
+	if len(rowLineOffsets) == 0 {
+		m.viewport.SetYOffset(0)
+		return
+	}
+	rowLine := func(rowID int) int {
+		if rowID-m.start >= len(rowLineOffsets) {
+			return numLines
+		}
+		return rowLineOffsets[rowID-m.start]
+	}
+	m.firstVisibleRow = clamp(m.firstVisibleRow, m.start, max(m.cursor, m.start))
+	for m.firstVisibleRow < m.cursor && rowLine(m.cursor+1)-rowLine(m.firstVisibleRow) > m.viewport.Height {
+		m.firstVisibleRow++
+	}
+	m.viewport.SetYOffset(rowLine(m.firstVisibleRow))
 }
 
 // SelectedRow returns the selected row.
@@ -359,12 +438,36 @@ func (m *Model) MaxHScroll() int {
 	index := m.ColIndex(m.hcol)
 	for _, row := range m.rows {
 		for _, value := range row {
-			maxWidth = max(runewidth.StringWidth(value), maxWidth)
+			maxWidth = max(m.valueWidth(value), maxWidth)
 		}
 	}
 	return max(maxWidth-m.cols[index].Width+2, 0)
 }
 
+// Gets the maximum useful horizontal scroll of the selected row, beyond the scroll of the whole table
+func (m *Model) MaxSelectedHScroll() int {
+	if m.cursor < 0 || m.cursor >= len(m.rows) {
+		return 0
+	}
+	maxScroll := 0
+	for i, value := range m.rows[m.cursor] {
+		if i >= len(m.cols) {
+			break
+		}
+		tableScroll := 0
+		if m.columnNeedsScrolling(i) {
+			tableScroll = m.hcursor
+		}
+		maxScroll = max(m.valueWidth(value)-m.cols[i].Width+2-tableScroll, maxScroll)
+	}
+	return maxScroll
+}
+
+// SelectedHScroll returns how far the selected row is scrolled, beyond the scroll of the whole table.
+func (m Model) SelectedHScroll() int {
+	return m.selectedHcursor
+}
+
 // SetWidth sets the width of the viewport of the table.
 func (m *Model) SetWidth(w int) {
 	m.viewport.Width = w
@@ -394,14 +497,27 @@ func (m Model) Cursor() int {
 
 // SetCursor sets the cursor position in the table.
 func (m *Model) SetCursor(n int) {
-	m.cursor = clamp(n, 0, len(m.rows)-1)
+	m.setCursor(clamp(n, 0, len(m.rows)-1))
 	m.UpdateViewport()
 }
 
+// Moves the cursor, resetting the scroll of the selected row if a different row is now selected
+func (m *Model) setCursor(n int) {
+	if n != m.cursor {
+		m.selectedHcursor = 0
+	}
+	m.cursor = n
+}
+
 // MoveUp moves the selection up by any number of row.
 // It can not go above the first row.
 func (m *Model) MoveUp(n int) {
-	m.cursor = clamp(m.cursor-n, 0, len(m.rows)-1)
+	m.setCursor(clamp(m.cursor-n, 0, len(m.rows)-1))
+	if m.maxRowLines > 1 {
+		// The line-based scrolling below assumes that every row is a single line
+		m.UpdateViewport()
+		return
+	}
 	switch {
 	case m.start == 0:
 		m.viewport.SetYOffset(clamp(m.viewport.YOffset, 0, m.cursor))
@@ -416,8 +532,12 @@ func (m *Model) MoveUp(n int) {
 // MoveDown moves the selection down by any number of row.
 // It can not go below the last row.
 func (m *Model) MoveDown(n int) {
-	m.cursor = clamp(m.cursor+n, 0, len(m.rows)-1)
+	m.setCursor(clamp(m.cursor+n, 0, len(m.rows)-1))
 	m.UpdateViewport()
+	if m.maxRowLines > 1 {
+		// The line-based scrolling below assumes that every row is a single line
+		return
+	}
 
 	switch {
 	case m.end == len(m.rows):
@@ -452,6 +572,19 @@ func (m *Model) MoveRight(n int) {
 	m.UpdateViewport()
 }
 
+// ScrollSelectedLeft scrolls the cells of the selected row left, without scrolling the rest of the table
+func (m *Model) ScrollSelectedLeft(n int) {
+	m.selectedHcursor = clamp(m.selectedHcursor-n, 0, m.MaxSelectedHScroll())
+	m.UpdateViewport()
+}
+
+// ScrollSelectedRight scrolls the cells of the selected row right, without scrolling the rest of the table, so that
+// long values can be read without selecting them
+func (m *Model) ScrollSelectedRight(n int) {
+	m.selectedHcursor = clamp(m.selectedHcursor+n, 0, m.MaxSelectedHScroll())
+	m.UpdateViewport()
+}
+
 // FromValues create the table rows from a simple string. It uses `\n` by
 // default for getting all the rows and the given separator for the fields on
 // each row.
@@ -481,7 +614,7 @@ func (m Model) headersView() string {
 func (m *Model) columnNeedsScrolling(columnIdxToCheck int) bool {
 	for rowIdx := m.start; rowIdx < m.end; rowIdx++ {
 		for columnIdx, value := range m.rows[rowIdx] {
-			if columnIdx == columnIdxToCheck && runewidth.StringWidth(value) > m.cols[columnIdx].Width {
+			if columnIdx == columnIdxToCheck && m.valueWidth(value) > m.cols[columnIdx].Width {
 				return true
 			}
 		}
@@ -489,26 +622,58 @@ func (m *Model) columnNeedsScrolling(columnIdxToCheck int) bool {
 	return false
 }
 
+// Returns the lines that the given value is displayed across
+func (m *Model) valueLines(value string) []string {
+	if m.maxRowLines <= 1 || !strings.Contains(value, "\n") {
+		return []string{value}
+	}
+	lines := strings.Split(value, "\n")
+	if len(lines) > m.maxRowLines {
+		numHidden := len(lines) - m.maxRowLines + 1
+		lines = append(lines[:m.maxRowLines-1], fmt.Sprintf("%s (%d more lines)", ELLIPSIS, numHidden))
+	}
+	return lines
+}
+
+// Returns the display width of the given value, which is the width of its longest line
+func (m *Model) valueWidth(value string) int {
+	width := 0
+	for _, line := range m.valueLines(value) {
+		width = max(runewidth.StringWidth(line), width)
+	}
+	return width
+}
+
 func (m *Model) renderRow(rowID int) string {
 	isRowSelected := rowID == m.cursor
 	var s = make([]string, 0, len(m.cols))
 	for i, value := range m.rows[rowID] {
 		style := lipgloss.NewStyle().Width(m.cols[i].Width).MaxWidth(m.cols[i].Width).Inline(true)
 
-		position := CellPosition{
-			RowID:         rowID,
-			Column:        i,
-			IsRowSelected: isRowSelected,
+		offset := 0
+		if m.columnNeedsScrolling(i) {
+			offset = m.hcursor
 		}
+		if isRowSelected && m.valueWidth(value) > m.cols[i].Width {
+			offset += m.selectedHcursor
+		}
+		lines := m.valueLines(value)
+		renderedLines := make([]string, 0, len(lines))
+		for _, line := range lines {
+			visible, visibleStart, visibleEnd := scrollCell(line, offset, m.cols[i].Width)
+
+			position := CellPosition{
+				RowID:         rowID,
+				Column:        i,
+				IsRowSelected: isRowSelected,
+				Value:         line,
+				VisibleStart:  visibleStart,
+				VisibleEnd:    visibleEnd,
+			}
 
-		var renderedCell string
-		if m.columnNeedsScrolling(i) && m.hcursor > 0 {
-			renderedCell = style.Render(runewidth.Truncate(runewidth.TruncateLeft(value, m.hcursor, "…"), m.cols[i].Width, "…"))
-		} else {
-			renderedCell = style.Render(runewidth.Truncate(value, m.cols[i].Width, "…"))
+			renderedLines = append(renderedLines, m.styles.renderCell(*m, style.Render(visible), position))
 		}
-		renderedCell = m.styles.renderCell(*m, renderedCell, position)
-		s = append(s, renderedCell)
+		s = append(s, strings.Join(renderedLines, "\n"))
 	}
 
 	row := lipgloss.JoinHorizontal(lipgloss.Left, s...)
@@ -517,9 +682,39 @@ func (m *Model) renderRow(rowID int) string {
 		return m.styles.Selected.Render(row)
 	}
 
+	if m.styles.RowStyle != nil {
+		return m.styles.RowStyle(*m, rowID).Render(row)
+	}
+
 	return row
 }
 
+// Returns the text to display for a cell containing value that is scrolled right by offset columns and truncated to
+// width columns, along with the byte range of value that is displayed
+func scrollCell(value string, offset, width int) (string, int, int) {
+	prefix := ""
+	visibleStart := 0
+	if offset > 0 {
+		prefix = ELLIPSIS
+		visibleStart = len(value)
+		scrolledWidth := 0
+		for i, r := range value {
+			if scrolledWidth >= offset {
+				visibleStart = i
+				break
+			}
+			scrolledWidth += runewidth.RuneWidth(r)
+		}
+	}
+	untruncated := prefix + value[visibleStart:]
+	truncated := runewidth.Truncate(untruncated, width, ELLIPSIS)
+	if truncated == untruncated {
+		return truncated, visibleStart, len(value)
+	}
+	visibleEnd := visibleStart + max(len(truncated)-len(prefix)-len(ELLIPSIS), 0)
+	return truncated, visibleStart, visibleEnd
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -3,6 +3,7 @@
 package table
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/ddworken/hishtory/shared/testutils"
@@ -65,6 +66,95 @@ func TestHScoll(t *testing.T) {
 	testutils.CompareGoldens(t, table.View(), "unittestTable-truncatedTable-right2")
 }
 
+func TestScrollSelected(t *testing.T) {
+	table := New(
+		WithColumns([]Column{{Title: "Column1", Width: 10}, {Title: "Column2", Width: 20}}),
+		WithRows([]Row{
+			{"a1", "a1234567890abcdefghijklmnopqrstuvwxyz"},
+			{"b1", "b23"},
+			{"c1", "c1234567890abcdefghijklmnopqrstuvwxyz"},
+		}),
+	)
+	table.SetCursor(2)
+	table.ScrollSelectedRight(5)
+	if table.SelectedHScroll() != 5 {
+		t.Fatalf("expected the selected row to be scrolled by 5, got %d", table.SelectedHScroll())
+	}
+	view := table.View()
+	if !strings.Contains(view, "…567890abcdefghijkl…") {
+		t.Fatalf("expected the selected row to be scrolled, got %q", view)
+	}
+	if !strings.Contains(view, "a1234567890abcdefgh…") {
+		t.Fatalf("expected the other rows to not be scrolled, got %q", view)
+	}
+
+	// Scrolling is clamped to the end of the longest value in the row
+	table.ScrollSelectedRight(100)
+	if table.SelectedHScroll() != table.MaxSelectedHScroll() {
+		t.Fatalf("expected the scroll to be clamped to %d, got %d", table.MaxSelectedHScroll(), table.SelectedHScroll())
+	}
+
+	// Selecting a different row resets the scroll
+	table.MoveUp(1)
+	if table.SelectedHScroll() != 0 {
+		t.Fatalf("expected the scroll to be reset after moving the cursor, got %d", table.SelectedHScroll())
+	}
+}
+
+func TestMultiLineRows(t *testing.T) {
+	table := New(
+		WithColumns([]Column{{Title: "Column1", Width: 10}, {Title: "Column2", Width: 20}}),
+		WithRows([]Row{
+			{"row-a", "first-a\nsecond-a"},
+			{"row-b", "first-b\nsecond-b"},
+			{"row-c", "first-c\nsecond-c\nthird-c\nfourth-c"},
+		}),
+		WithHeight(3),
+		WithMaxRowLines(3),
+	)
+	view := table.View()
+	if !strings.Contains(view, "first-a") || !strings.Contains(view, "second-a") {
+		t.Fatalf("expected the first row to be displayed across multiple lines, got %q", view)
+	}
+
+	// Moving the cursor scrolls so that every line of the selected row is visible
+	table.MoveDown(1)
+	view = table.View()
+	if strings.Contains(view, "first-a") || !strings.Contains(view, "first-b") || !strings.Contains(view, "second-b") {
+		t.Fatalf("expected the second row to be fully visible, got %q", view)
+	}
+
+	// Rows with too many lines are cut off
+	table.MoveDown(1)
+	view = table.View()
+	if !strings.Contains(view, "second-c") || strings.Contains(view, "third-c") || !strings.Contains(view, "… (2 more lines)") {
+		t.Fatalf("expected the third row to be cut off after two lines, got %q", view)
+	}
+}
+
+func TestScrollCell(t *testing.T) {
+	testcases := []struct {
+		value         string
+		offset        int
+		width         int
+		expected      string
+		expectedStart int
+		expectedEnd   int
+	}{
+		{"short", 0, 10, "short", 0, 5},
+		{"abcdefghij", 0, 5, "abcd…", 0, 4},
+		{"abcdefghij", 3, 5, "…def…", 3, 6},
+		{"abcdefghij", 6, 10, "…ghij", 6, 10},
+		{"abc", 5, 10, "…", 3, 3},
+	}
+	for _, tc := range testcases {
+		visible, start, end := scrollCell(tc.value, tc.offset, tc.width)
+		if visible != tc.expected || start != tc.expectedStart || end != tc.expectedEnd {
+			t.Fatalf("scrollCell(%q, %d, %d) = (%q, %d, %d), expected (%q, %d, %d)", tc.value, tc.offset, tc.width, visible, start, end, tc.expected, tc.expectedStart, tc.expectedEnd)
+		}
+	}
+}
+
 func deepEqual(a, b []Row) bool {
 	if len(a) != len(b) {
 		return false
@@ -0,0 +1,117 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ddworken/hishtory/client/hctx"
+	sharedai "github.com/ddworken/hishtory/shared/ai"
+)
+
+// The maximum number of lines of the conversation that are displayed in the AI chat panel at once
+const AI_CHAT_HEIGHT = 6
+
+type aiChatPanel struct {
+	// The input box for the next refinement
+	input textinput.Model
+	// The previous turns of the conversation, oldest first
+	history []sharedai.AiChatTurn
+	// The most recent query sent to the AI, whose suggestions are displayed in the table
+	query string
+}
+
+// Whether the table is currently displaying AI suggestions, which are what the AI chat refines
+func isAiQuery(m model) bool {
+	return isAiSuggestionQuery(m.ctx, m.lastQuery)
+}
+
+// Opens the AI chat panel to refine the AI suggestions for the current query
+func openAiChat(m model) (model, tea.Cmd) {
+	if !isAiQuery(m) {
+		m.notice = "The AI chat refines AI suggestions, so start your query with `?` to use it"
+		return m, nil
+	}
+	input := textinput.New()
+	input.Placeholder = "make it recursive"
+	input.Width = m.queryInput.Width
+	input.CharLimit = 200
+	input.Focus()
+	m.aiChat = &aiChatPanel{input: input, query: strings.TrimSpace(strings.TrimPrefix(m.lastQuery, "?"))}
+	return m, nil
+}
+
+// Closes the AI chat panel and goes back to the suggestions for the original query
+func closeAiChat(m model) (model, tea.Cmd) {
+	m.aiChat = nil
+	cmd := runQueryAndUpdateTable(m, true, false)
+	return m, cmd
+}
+
+func updateAiChat(m model, msg tea.KeyMsg) (model, tea.Cmd) {
+	c := m.aiChat
+	switch {
+	case msg.Type == tea.KeyEsc || msg.Type == tea.KeyCtrlC || key.Matches(msg, loadedKeyBindings.OpenAiChat):
+		return closeAiChat(m)
+	case key.Matches(msg, loadedKeyBindings.SelectEntry):
+		refinement := strings.TrimSpace(c.input.Value())
+		if refinement == "" {
+			// Nothing left to refine, so select the highlighted suggestion like any other entry
+			if len(m.tableEntries) != 0 && m.table != nil {
+				m.selected = Selected
+			}
+			return m, tea.Quit
+		}
+		if m.table == nil || len(m.tableEntries) == 0 || len(c.history) >= sharedai.MaxAiChatTurns {
+			return m, nil
+		}
+		// The refinement applies to the highlighted suggestion, so that is what the AI is told it previously suggested
+		c.history = append(c.history, sharedai.AiChatTurn{Query: c.query, Suggestion: m.tableEntries[m.table.Cursor()].Command})
+		c.query = refinement
+		c.input.SetValue("")
+		cmd := runQueryAndUpdateTable(m, true, false)
+		return m, cmd
+	case key.Matches(msg, loadedKeyBindings.Up), key.Matches(msg, loadedKeyBindings.Down), key.Matches(msg, loadedKeyBindings.PageUp), key.Matches(msg, loadedKeyBindings.PageDown):
+		if m.table == nil {
+			return m, nil
+		}
+		t, cmd := m.table.Update(msg)
+		m.table = &t
+		preventTableOverscrolling(m)
+		return m, cmd
+	default:
+		var cmd tea.Cmd
+		c.input, cmd = c.input.Update(msg)
+		return m, cmd
+	}
+}
+
+// Runs the query for the current state of the AI chat, returning the rows for the refined suggestions
+func runAiChatQuery(m model, queryId int, history []sharedai.AiChatTurn, query string, forceUpdateTable, maintainCursor bool) tea.Cmd {
+	return func() tea.Msg {
+		conf := hctx.GetConf(m.ctx)
+		rows, entries, err := getRowsFromAiChat(m.ctx, conf.DisplayedColumns, m.shellName, history, query)
+		return asyncQueryFinishedMsg{queryId, rows, entries, err, forceUpdateTable, maintainCursor, nil, rowWindow{}, nil, 0}
+	}
+}
+
+func renderAiChat(m model) string {
+	c := m.aiChat
+	config := hctx.GetConf(m.ctx)
+	lines := make([]string, 0)
+	for _, turn := range c.history {
+		lines = append(lines, "You: "+turn.Query, "AI:  "+turn.Suggestion)
+	}
+	lines = append(lines, "You: "+c.query)
+	if LAST_PROCESSED_QUERY_ID < LAST_DISPATCHED_QUERY_ID {
+		lines = append(lines, "AI:  thinking...")
+	} else {
+		lines = append(lines, "AI:  see the suggestions above")
+	}
+	if len(lines) > AI_CHAT_HEIGHT {
+		lines = lines[len(lines)-AI_CHAT_HEIGHT:]
+	}
+	lines = append(lines, "", "Refine: "+c.input.View(), "(enter with an empty input to select the highlighted suggestion, esc to close)")
+	return getBaseStyle(*config).Render(strings.Join(lines, "\n"))
+}
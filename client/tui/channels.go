@@ -0,0 +1,86 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+)
+
+type channelPrompt struct {
+	// The input box for the name of the channel to publish to
+	input textinput.Model
+	// The entry that is being published
+	entry *data.HistoryEntry
+}
+
+type channelPublishedMsg struct {
+	channel string
+	command string
+	err     error
+}
+
+// Opens a prompt for publishing the highlighted entry to a team channel, defaulting to the only subscribed channel
+func openChannelPrompt(m model) (model, tea.Cmd) {
+	if m.table == nil || len(m.tableEntries) == 0 {
+		return m, nil
+	}
+	channels := lib.ListChannels(m.ctx)
+	if len(channels) == 0 {
+		m.notice = "You aren't subscribed to any channels, run `hishtory channel create` or `hishtory channel join` to subscribe to one"
+		return m, nil
+	}
+	input := textinput.New()
+	input.Placeholder = strings.Join(channels, ", ")
+	input.Width = m.queryInput.Width
+	if len(channels) == 1 {
+		input.SetValue(channels[0])
+	}
+	input.Focus()
+	m.channelPrompt = &channelPrompt{input: input, entry: m.tableEntries[m.table.Cursor()]}
+	return m, nil
+}
+
+func updateChannelPrompt(m model, msg tea.KeyMsg) (model, tea.Cmd) {
+	p := m.channelPrompt
+	switch {
+	case msg.Type == tea.KeyEsc || msg.Type == tea.KeyCtrlC:
+		m.channelPrompt = nil
+		return m, nil
+	case key.Matches(msg, loadedKeyBindings.SelectEntry):
+		name := strings.TrimSpace(p.input.Value())
+		entry := p.entry
+		ctx := m.ctx
+		m.channelPrompt = nil
+		m.notice = fmt.Sprintf("Publishing to the channel %s...", name)
+		// Publishing requires a request to the backend, so it is done in the background
+		return m, func() tea.Msg {
+			err := lib.PublishToChannel(ctx, name, entry)
+			return channelPublishedMsg{channel: name, command: entry.Command, err: err}
+		}
+	default:
+		var cmd tea.Cmd
+		p.input, cmd = p.input.Update(msg)
+		return m, cmd
+	}
+}
+
+func handleChannelPublished(m model, msg channelPublishedMsg) model {
+	if msg.err != nil {
+		m.notice = fmt.Sprintf("Warning: failed to publish to the channel %s: %v", msg.channel, msg.err)
+	} else {
+		m.notice = fmt.Sprintf("Published %#v to the channel %s", msg.command, msg.channel)
+	}
+	return m
+}
+
+func renderChannelPrompt(m model) string {
+	config := hctx.GetConf(m.ctx)
+	lines := []string{"Publish to channel: " + m.channelPrompt.input.View(), "(enter to publish, esc to cancel)"}
+	return getBaseStyle(*config).Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,77 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// Renders a small sample of the TUI in the given color scheme, for previewing color scheme presets
+func RenderColorSchemePreview(scheme hctx.ColorScheme) string {
+	colored := func(style lipgloss.Style, color string) lipgloss.Style {
+		if color == "" {
+			return style
+		}
+		return style.Foreground(lipgloss.Color(color))
+	}
+	cell := lipgloss.NewStyle().Padding(0, 1).Width(16)
+	header := colored(lipgloss.NewStyle(), scheme.HeaderText).
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color(scheme.BorderColor)).
+		BorderBottom(true)
+	selected := lipgloss.NewStyle().Foreground(lipgloss.Color(scheme.SelectedText)).Background(lipgloss.Color(scheme.SelectedBackground))
+	match := matchHighlightStyle(lipgloss.NewStyle(), scheme)
+	rows := []string{
+		header.Render(cell.Render("CWD") + cell.Render("Command")),
+		selected.Render(cell.Render("~/code") + cell.Render("git status")),
+		cell.Render("~/code") + cell.Render(match.Render("git")+" log"),
+	}
+	table := lipgloss.NewStyle().
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color(scheme.BorderColor)).
+		Render(strings.Join(rows, "\n"))
+	warning := colored(lipgloss.NewStyle(), scheme.WarningText).Render("Warning: an example warning")
+	errorMessage := colored(lipgloss.NewStyle(), scheme.ErrorText).Render("An example error")
+	h := help.New()
+	configureHelpStyles(&h, scheme)
+	helpBar := h.Styles.ShortKey.Render("ctrl+h") + " " + h.Styles.ShortDesc.Render("help")
+	return table + "\n" + warning + "\n" + errorMessage + "\n" + helpBar
+}
+
+// Applies the given color scheme to the parts of a search result that match the query
+func matchHighlightStyle(style lipgloss.Style, scheme hctx.ColorScheme) lipgloss.Style {
+	switch scheme.MatchHighlightStyle {
+	case "underline":
+		style = style.Underline(true)
+	case "italic":
+		style = style.Italic(true)
+	case "reverse":
+		style = style.Reverse(true)
+	case "none":
+	default:
+		style = style.Bold(true)
+	}
+	if scheme.MatchHighlight != "" {
+		style = style.Foreground(lipgloss.Color(scheme.MatchHighlight))
+	}
+	return style
+}
+
+// Applies the help bar colors from the given color scheme. Empty colors keep the default help bar styles.
+func configureHelpStyles(h *help.Model, scheme hctx.ColorScheme) {
+	defaults := help.New().Styles
+	h.Styles.ShortKey = defaults.ShortKey
+	h.Styles.FullKey = defaults.FullKey
+	h.Styles.ShortDesc = defaults.ShortDesc
+	h.Styles.FullDesc = defaults.FullDesc
+	if scheme.HelpKey != "" {
+		h.Styles.ShortKey = h.Styles.ShortKey.Foreground(lipgloss.Color(scheme.HelpKey))
+		h.Styles.FullKey = h.Styles.FullKey.Foreground(lipgloss.Color(scheme.HelpKey))
+	}
+	if scheme.HelpDescription != "" {
+		h.Styles.ShortDesc = h.Styles.ShortDesc.Foreground(lipgloss.Color(scheme.HelpDescription))
+		h.Styles.FullDesc = h.Styles.FullDesc.Foreground(lipgloss.Color(scheme.HelpDescription))
+	}
+}
@@ -0,0 +1,42 @@
+package tui
+
+import (
+	"context"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// Returns the default filters that can be cycled between in the TUI. No filter is always one of the options (after the
+// configured filters, unless it is explicitly configured elsewhere in the list) so that filtering can be quickly
+// turned off.
+func getDefaultFilterOptions(ctx context.Context) []string {
+	filters := hctx.GetConf(ctx).DefaultFilters
+	for _, filter := range filters {
+		if strings.TrimSpace(filter) == "" {
+			return filters
+		}
+	}
+	return append(append([]string{}, filters...), "")
+}
+
+// Returns the default filter at the given index within getDefaultFilterOptions
+func getDefaultFilter(ctx context.Context, defaultFilterIndex int) string {
+	options := getDefaultFilterOptions(ctx)
+	return strings.TrimSpace(options[defaultFilterIndex%len(options)])
+}
+
+func cycleDefaultFilter(m model) (model, tea.Cmd) {
+	options := getDefaultFilterOptions(m.ctx)
+	if len(options) == 1 {
+		m.notice = "There are no default filters to cycle between, run `hishtory config-set default-filter` to configure them"
+		return m, nil
+	}
+	m.defaultFilterIndex = (m.defaultFilterIndex + 1) % len(options)
+	m.queryInput.Prompt = getDefaultFilterPrompt(m.ctx, m.defaultFilterIndex)
+	if m.table != nil {
+		m.table.SetCursor(0)
+	}
+	return m, runQueryAndUpdateTable(m, true, false)
+}
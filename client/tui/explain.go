@@ -0,0 +1,104 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ddworken/hishtory/client/ai"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// The maximum number of lines of an explanation that are displayed at once
+const EXPLANATION_HEIGHT = 12
+
+type explanationPanel struct {
+	// The command being explained
+	command string
+	// The AI's explanation of the command, once it has been received
+	explanation string
+	// An error from querying the AI, if one occurred
+	err error
+	// Whether the explanation is still being generated
+	loading bool
+	// The index of the first displayed line of the explanation, for scrolling through long explanations
+	offset int
+}
+
+type explanationMsg struct {
+	// The command that was explained, used to ignore explanations for a panel that has since been closed
+	command     string
+	explanation string
+	err         error
+}
+
+// Opens a panel with an AI explanation of the highlighted command, which is generated in the background
+func openExplanationPanel(m model) (model, tea.Cmd) {
+	if m.table == nil || len(m.tableEntries) == 0 {
+		return m, nil
+	}
+	config := hctx.GetConf(m.ctx)
+	if !config.AiCompletion {
+		m.notice = "Explaining commands requires AI completion, enable it via `hishtory config-set ai-completion true`"
+		return m, nil
+	}
+	if config.IsOffline {
+		m.notice = "Explaining commands isn't supported in offline mode"
+		return m, nil
+	}
+	if !lib.IsFeatureEnabled(m.ctx, shared.FeatureAiExplain) {
+		m.notice = "Explaining commands is currently disabled by the hishtory server"
+		return m, nil
+	}
+	command := m.tableEntries[m.table.Cursor()].Command
+	m.explanation = &explanationPanel{command: command, loading: true}
+	ctx := m.ctx
+	shellName := m.shellName
+	return m, func() tea.Msg {
+		explanation, err := ai.ExplainCommand(ctx, shellName, command)
+		return explanationMsg{command: command, explanation: explanation, err: err}
+	}
+}
+
+func updateExplanationPanel(m model, msg tea.KeyMsg) (model, tea.Cmd) {
+	e := m.explanation
+	switch {
+	case msg.Type == tea.KeyEsc || msg.Type == tea.KeyCtrlC || key.Matches(msg, loadedKeyBindings.ExplainCommand):
+		m.explanation = nil
+		return m, nil
+	case key.Matches(msg, loadedKeyBindings.Up):
+		e.offset = max(e.offset-1, 0)
+		return m, nil
+	case key.Matches(msg, loadedKeyBindings.Down):
+		e.offset++
+		return m, nil
+	default:
+		return m, nil
+	}
+}
+
+func renderExplanationPanel(m model) string {
+	e := m.explanation
+	config := hctx.GetConf(m.ctx)
+	// Leave room for the border
+	width := max(m.queryInput.Width-2, 20)
+	var body string
+	switch {
+	case e.loading:
+		body = m.spinner.View() + " Asking the AI to explain the command..."
+	case e.err != nil:
+		body = renderWarning(m, "Warning: failed to explain the command: "+e.err.Error())
+	default:
+		body = e.explanation
+	}
+	lines := strings.Split(lipgloss.NewStyle().Width(width).Render(body), "\n")
+	// Clamp the scroll position so that the end of the explanation stays visible
+	e.offset = min(e.offset, max(len(lines)-EXPLANATION_HEIGHT, 0))
+	lines = lines[e.offset:min(e.offset+EXPLANATION_HEIGHT, len(lines))]
+	header := lipgloss.NewStyle().Width(width).Render("Explanation of: " + strings.ReplaceAll(e.command, "\n", "\\n"))
+	footer := "(↑/↓ to scroll, esc to close)"
+	return getBaseStyle(*config).Render(strings.Join(append(append([]string{header, ""}, lines...), "", footer), "\n"))
+}
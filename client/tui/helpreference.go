@@ -0,0 +1,80 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// The separator between key bindings on the same line of the key binding reference
+const HELP_REFERENCE_SEPARATOR = "   "
+
+// Renders the full-screen key binding reference, which lists every key binding grouped by category. Unlike the help
+// bar, the bindings wrap to fit the width of the terminal rather than being truncated.
+func renderHelpReference(m model) string {
+	config := hctx.GetConf(m.ctx)
+	titleStyle := lipgloss.NewStyle().Bold(true)
+	// The border takes up two columns
+	width := m.queryInput.Width - 2
+	lines := []string{"Key Bindings (" + loadedKeyBindings.Help.Help().Key + " to close)"}
+	for _, section := range loadedKeyBindings.HelpSections() {
+		items := make([]helpReferenceItem, 0, len(section.Bindings))
+		for _, binding := range section.Bindings {
+			if !binding.Enabled() {
+				continue
+			}
+			help := binding.Help()
+			items = append(items, helpReferenceItem{strings.TrimSpace(help.Key), strings.TrimSpace(help.Desc)})
+		}
+		if len(items) == 0 {
+			continue
+		}
+		lines = append(lines, "", titleStyle.Render(section.Title+":"))
+		for _, line := range wrapHelpReferenceItems(items, width) {
+			rendered := make([]string, 0, len(line))
+			for _, item := range line {
+				rendered = append(rendered, m.help.Styles.FullKey.Render(item.key)+" "+m.help.Styles.FullDesc.Render(item.desc))
+			}
+			lines = append(lines, strings.Join(rendered, HELP_REFERENCE_SEPARATOR))
+		}
+	}
+	// Pad the reference to the height of the table so that the layout doesn't jump around
+	for len(lines) < TABLE_HEIGHT+3 {
+		lines = append(lines, "")
+	}
+	return getBaseStyle(*config).Render(strings.Join(lines, "\n"))
+}
+
+type helpReferenceItem struct {
+	key  string
+	desc string
+}
+
+func (i helpReferenceItem) width() int {
+	return lipgloss.Width(i.key) + 1 + lipgloss.Width(i.desc)
+}
+
+// Greedily groups the given items into lines that fit within the given width. Items that are wider than the width
+// on their own are put on a line by themselves.
+func wrapHelpReferenceItems(items []helpReferenceItem, width int) [][]helpReferenceItem {
+	lines := make([][]helpReferenceItem, 0)
+	var line []helpReferenceItem
+	lineWidth := 0
+	for _, item := range items {
+		if len(line) > 0 && lineWidth+len(HELP_REFERENCE_SEPARATOR)+item.width() > width {
+			lines = append(lines, line)
+			line = nil
+			lineWidth = 0
+		}
+		if len(line) > 0 {
+			lineWidth += len(HELP_REFERENCE_SEPARATOR)
+		}
+		line = append(line, item)
+		lineWidth += item.width()
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
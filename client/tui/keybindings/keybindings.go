@@ -18,6 +18,8 @@ type SerializableKeyMap struct {
 	Right                   []string
 	TableLeft               []string
 	TableRight              []string
+	ScrollSelectedLeft      []string
+	ScrollSelectedRight     []string
 	DeleteEntry             []string
 	Help                    []string
 	Quit                    []string
@@ -25,6 +27,84 @@ type SerializableKeyMap struct {
 	JumpEndOfInput          []string
 	WordLeft                []string
 	WordRight               []string
+	ToggleCurrentSession    []string
+	CycleSortOrder          []string
+	CycleSearchScope        []string
+	CycleRanker             []string
+	ClearQuery              []string
+	OpenCommandPalette      []string
+	OpenAiChat              []string
+	ToggleSampling          []string
+	UndoDelete              []string
+	PreviousQuery           []string
+	NextQuery               []string
+	CycleDefaultFilter      []string
+	ToggleFavorite          []string
+	EditTags                []string
+	SaveSnippet             []string
+	BrowseSnippets          []string
+	ExplainCommand          []string
+	ToggleMark              []string
+	PublishToChannel        []string
+}
+
+type keyBindingAction struct {
+	// The name of the action in `hishtory config-set key-bindings`
+	name string
+	keys *[]string
+}
+
+func (s *SerializableKeyMap) actions() []keyBindingAction {
+	return []keyBindingAction{
+		{"up", &s.Up},
+		{"down", &s.Down},
+		{"page-up", &s.PageUp},
+		{"page-down", &s.PageDown},
+		{"select-entry", &s.SelectEntry},
+		{"select-entry-and-cd", &s.SelectEntryAndChangeDir},
+		{"left", &s.Left},
+		{"right", &s.Right},
+		{"table-left", &s.TableLeft},
+		{"table-right", &s.TableRight},
+		{"scroll-selected-left", &s.ScrollSelectedLeft},
+		{"scroll-selected-right", &s.ScrollSelectedRight},
+		{"delete-entry", &s.DeleteEntry},
+		{"help", &s.Help},
+		{"quit", &s.Quit},
+		{"jump-start-of-input", &s.JumpStartOfInput},
+		{"jump-end-of-input", &s.JumpEndOfInput},
+		{"word-left", &s.WordLeft},
+		{"word-right", &s.WordRight},
+		{"toggle-current-session", &s.ToggleCurrentSession},
+		{"cycle-sort-order", &s.CycleSortOrder},
+		{"cycle-search-scope", &s.CycleSearchScope},
+		{"cycle-ranker", &s.CycleRanker},
+		{"clear-query", &s.ClearQuery},
+		{"command-palette", &s.OpenCommandPalette},
+		{"ai-chat", &s.OpenAiChat},
+		{"result-sampling", &s.ToggleSampling},
+		{"undo-delete", &s.UndoDelete},
+		{"previous-query", &s.PreviousQuery},
+		{"next-query", &s.NextQuery},
+		{"cycle-default-filter", &s.CycleDefaultFilter},
+		{"toggle-favorite", &s.ToggleFavorite},
+		{"edit-tags", &s.EditTags},
+		{"save-snippet", &s.SaveSnippet},
+		{"browse-snippets", &s.BrowseSnippets},
+		{"explain-command", &s.ExplainCommand},
+		{"toggle-mark", &s.ToggleMark},
+		{"publish-to-channel", &s.PublishToChannel},
+	}
+}
+
+// Returns the keys bound to the given action, as named in `hishtory config-set key-bindings`
+func (s *SerializableKeyMap) ActionKeys(action string) (*[]string, bool) {
+	for _, a := range s.actions() {
+		if a.name == action {
+			return a.keys, true
+		}
+	}
+	return nil, false
 }
 
 func prettifyKeyBinding(kb string) string {
@@ -98,6 +178,14 @@ func (s SerializableKeyMap) ToKeyMap() KeyMap {
 			key.WithKeys(s.TableRight...),
 			key.WithHelp(prettifyKeyBinding(s.TableRight[0]), "scroll the table right "),
 		),
+		ScrollSelectedLeft: key.NewBinding(
+			key.WithKeys(s.ScrollSelectedLeft...),
+			key.WithHelp(prettifyKeyBinding(s.ScrollSelectedLeft[0]), "scroll the highlighted entry left "),
+		),
+		ScrollSelectedRight: key.NewBinding(
+			key.WithKeys(s.ScrollSelectedRight...),
+			key.WithHelp(prettifyKeyBinding(s.ScrollSelectedRight[0]), "scroll the highlighted entry right "),
+		),
 		DeleteEntry: key.NewBinding(
 			key.WithKeys(s.DeleteEntry...),
 			key.WithHelp(prettifyKeyBinding(s.DeleteEntry[0]), "delete the highlighted entry "),
@@ -126,6 +214,82 @@ func (s SerializableKeyMap) ToKeyMap() KeyMap {
 			key.WithKeys(s.WordRight...),
 			key.WithHelp(prettifyKeyBinding(s.WordRight[0]), "jump right one word "),
 		),
+		ToggleCurrentSession: key.NewBinding(
+			key.WithKeys(s.ToggleCurrentSession...),
+			key.WithHelp(prettifyKeyBinding(s.ToggleCurrentSession[0]), "toggle current session filter "),
+		),
+		CycleSortOrder: key.NewBinding(
+			key.WithKeys(s.CycleSortOrder...),
+			key.WithHelp(prettifyKeyBinding(s.CycleSortOrder[0]), "cycle sort order "),
+		),
+		CycleSearchScope: key.NewBinding(
+			key.WithKeys(s.CycleSearchScope...),
+			key.WithHelp(prettifyKeyBinding(s.CycleSearchScope[0]), "cycle search scope "),
+		),
+		CycleRanker: key.NewBinding(
+			key.WithKeys(s.CycleRanker...),
+			key.WithHelp(prettifyKeyBinding(s.CycleRanker[0]), "cycle ranking strategy "),
+		),
+		ClearQuery: key.NewBinding(
+			key.WithKeys(s.ClearQuery...),
+			key.WithHelp(prettifyKeyBinding(s.ClearQuery[0]), "clear the query "),
+		),
+		OpenCommandPalette: key.NewBinding(
+			key.WithKeys(s.OpenCommandPalette...),
+			key.WithHelp(prettifyKeyBinding(s.OpenCommandPalette[0]), "open the command palette "),
+		),
+		OpenAiChat: key.NewBinding(
+			key.WithKeys(s.OpenAiChat...),
+			key.WithHelp(prettifyKeyBinding(s.OpenAiChat[0]), "open the AI chat "),
+		),
+		ToggleSampling: key.NewBinding(
+			key.WithKeys(s.ToggleSampling...),
+			key.WithHelp(prettifyKeyBinding(s.ToggleSampling[0]), "toggle result sampling "),
+		),
+		UndoDelete: key.NewBinding(
+			key.WithKeys(s.UndoDelete...),
+			key.WithHelp(prettifyKeyBinding(s.UndoDelete[0]), "undo the last deletion "),
+		),
+		PreviousQuery: key.NewBinding(
+			key.WithKeys(s.PreviousQuery...),
+			key.WithHelp(prettifyKeyBinding(s.PreviousQuery[0]), "recall the previous search query "),
+		),
+		NextQuery: key.NewBinding(
+			key.WithKeys(s.NextQuery...),
+			key.WithHelp(prettifyKeyBinding(s.NextQuery[0]), "recall the next search query "),
+		),
+		CycleDefaultFilter: key.NewBinding(
+			key.WithKeys(s.CycleDefaultFilter...),
+			key.WithHelp(prettifyKeyBinding(s.CycleDefaultFilter[0]), "cycle default filter "),
+		),
+		ToggleFavorite: key.NewBinding(
+			key.WithKeys(s.ToggleFavorite...),
+			key.WithHelp(prettifyKeyBinding(s.ToggleFavorite[0]), "toggle favorite "),
+		),
+		EditTags: key.NewBinding(
+			key.WithKeys(s.EditTags...),
+			key.WithHelp(prettifyKeyBinding(s.EditTags[0]), "edit tags "),
+		),
+		SaveSnippet: key.NewBinding(
+			key.WithKeys(s.SaveSnippet...),
+			key.WithHelp(prettifyKeyBinding(s.SaveSnippet[0]), "save as a snippet "),
+		),
+		BrowseSnippets: key.NewBinding(
+			key.WithKeys(s.BrowseSnippets...),
+			key.WithHelp(prettifyKeyBinding(s.BrowseSnippets[0]), "browse snippets "),
+		),
+		ExplainCommand: key.NewBinding(
+			key.WithKeys(s.ExplainCommand...),
+			key.WithHelp(prettifyKeyBinding(s.ExplainCommand[0]), "explain the highlighted command with AI "),
+		),
+		ToggleMark: key.NewBinding(
+			key.WithKeys(s.ToggleMark...),
+			key.WithHelp(prettifyKeyBinding(s.ToggleMark[0]), "mark the highlighted entry for export "),
+		),
+		PublishToChannel: key.NewBinding(
+			key.WithKeys(s.PublishToChannel...),
+			key.WithHelp(prettifyKeyBinding(s.PublishToChannel[0]), "publish to a team channel "),
+		),
 	}
 }
 
@@ -160,6 +324,12 @@ func (s SerializableKeyMap) WithDefaults() SerializableKeyMap {
 	if len(s.TableRight) == 0 {
 		s.TableRight = DefaultKeyMap.TableRight.Keys()
 	}
+	if len(s.ScrollSelectedLeft) == 0 {
+		s.ScrollSelectedLeft = DefaultKeyMap.ScrollSelectedLeft.Keys()
+	}
+	if len(s.ScrollSelectedRight) == 0 {
+		s.ScrollSelectedRight = DefaultKeyMap.ScrollSelectedRight.Keys()
+	}
 	if len(s.DeleteEntry) == 0 {
 		s.DeleteEntry = DefaultKeyMap.DeleteEntry.Keys()
 	}
@@ -181,6 +351,63 @@ func (s SerializableKeyMap) WithDefaults() SerializableKeyMap {
 	if len(s.WordRight) == 0 {
 		s.WordRight = DefaultKeyMap.WordRight.Keys()
 	}
+	if len(s.ToggleCurrentSession) == 0 {
+		s.ToggleCurrentSession = DefaultKeyMap.ToggleCurrentSession.Keys()
+	}
+	if len(s.CycleSortOrder) == 0 {
+		s.CycleSortOrder = DefaultKeyMap.CycleSortOrder.Keys()
+	}
+	if len(s.CycleSearchScope) == 0 {
+		s.CycleSearchScope = DefaultKeyMap.CycleSearchScope.Keys()
+	}
+	if len(s.CycleRanker) == 0 {
+		s.CycleRanker = DefaultKeyMap.CycleRanker.Keys()
+	}
+	if len(s.ClearQuery) == 0 {
+		s.ClearQuery = DefaultKeyMap.ClearQuery.Keys()
+	}
+	if len(s.OpenCommandPalette) == 0 {
+		s.OpenCommandPalette = DefaultKeyMap.OpenCommandPalette.Keys()
+	}
+	if len(s.OpenAiChat) == 0 {
+		s.OpenAiChat = DefaultKeyMap.OpenAiChat.Keys()
+	}
+	if len(s.ToggleSampling) == 0 {
+		s.ToggleSampling = DefaultKeyMap.ToggleSampling.Keys()
+	}
+	if len(s.UndoDelete) == 0 {
+		s.UndoDelete = DefaultKeyMap.UndoDelete.Keys()
+	}
+	if len(s.PreviousQuery) == 0 {
+		s.PreviousQuery = DefaultKeyMap.PreviousQuery.Keys()
+	}
+	if len(s.NextQuery) == 0 {
+		s.NextQuery = DefaultKeyMap.NextQuery.Keys()
+	}
+	if len(s.CycleDefaultFilter) == 0 {
+		s.CycleDefaultFilter = DefaultKeyMap.CycleDefaultFilter.Keys()
+	}
+	if len(s.ToggleFavorite) == 0 {
+		s.ToggleFavorite = DefaultKeyMap.ToggleFavorite.Keys()
+	}
+	if len(s.EditTags) == 0 {
+		s.EditTags = DefaultKeyMap.EditTags.Keys()
+	}
+	if len(s.SaveSnippet) == 0 {
+		s.SaveSnippet = DefaultKeyMap.SaveSnippet.Keys()
+	}
+	if len(s.BrowseSnippets) == 0 {
+		s.BrowseSnippets = DefaultKeyMap.BrowseSnippets.Keys()
+	}
+	if len(s.ExplainCommand) == 0 {
+		s.ExplainCommand = DefaultKeyMap.ExplainCommand.Keys()
+	}
+	if len(s.ToggleMark) == 0 {
+		s.ToggleMark = DefaultKeyMap.ToggleMark.Keys()
+	}
+	if len(s.PublishToChannel) == 0 {
+		s.PublishToChannel = DefaultKeyMap.PublishToChannel.Keys()
+	}
 	return s
 }
 
@@ -195,6 +422,8 @@ type KeyMap struct {
 	Right                   key.Binding
 	TableLeft               key.Binding
 	TableRight              key.Binding
+	ScrollSelectedLeft      key.Binding
+	ScrollSelectedRight     key.Binding
 	DeleteEntry             key.Binding
 	Help                    key.Binding
 	Quit                    key.Binding
@@ -202,6 +431,25 @@ type KeyMap struct {
 	JumpEndOfInput          key.Binding
 	WordLeft                key.Binding
 	WordRight               key.Binding
+	ToggleCurrentSession    key.Binding
+	CycleSortOrder          key.Binding
+	CycleSearchScope        key.Binding
+	CycleRanker             key.Binding
+	ClearQuery              key.Binding
+	OpenCommandPalette      key.Binding
+	OpenAiChat              key.Binding
+	ToggleSampling          key.Binding
+	UndoDelete              key.Binding
+	PreviousQuery           key.Binding
+	NextQuery               key.Binding
+	CycleDefaultFilter      key.Binding
+	ToggleFavorite          key.Binding
+	EditTags                key.Binding
+	SaveSnippet             key.Binding
+	BrowseSnippets          key.Binding
+	ExplainCommand          key.Binding
+	ToggleMark              key.Binding
+	PublishToChannel        key.Binding
 }
 
 func (k KeyMap) ToSerializable() SerializableKeyMap {
@@ -216,6 +464,8 @@ func (k KeyMap) ToSerializable() SerializableKeyMap {
 		Right:                   k.Right.Keys(),
 		TableLeft:               k.TableLeft.Keys(),
 		TableRight:              k.TableRight.Keys(),
+		ScrollSelectedLeft:      k.ScrollSelectedLeft.Keys(),
+		ScrollSelectedRight:     k.ScrollSelectedRight.Keys(),
 		DeleteEntry:             k.DeleteEntry.Keys(),
 		Help:                    k.Help.Keys(),
 		Quit:                    k.Quit.Keys(),
@@ -223,6 +473,25 @@ func (k KeyMap) ToSerializable() SerializableKeyMap {
 		JumpEndOfInput:          k.JumpEndOfInput.Keys(),
 		WordLeft:                k.WordLeft.Keys(),
 		WordRight:               k.WordRight.Keys(),
+		ToggleCurrentSession:    k.ToggleCurrentSession.Keys(),
+		CycleSortOrder:          k.CycleSortOrder.Keys(),
+		CycleSearchScope:        k.CycleSearchScope.Keys(),
+		CycleRanker:             k.CycleRanker.Keys(),
+		ClearQuery:              k.ClearQuery.Keys(),
+		OpenCommandPalette:      k.OpenCommandPalette.Keys(),
+		OpenAiChat:              k.OpenAiChat.Keys(),
+		ToggleSampling:          k.ToggleSampling.Keys(),
+		UndoDelete:              k.UndoDelete.Keys(),
+		PreviousQuery:           k.PreviousQuery.Keys(),
+		NextQuery:               k.NextQuery.Keys(),
+		CycleDefaultFilter:      k.CycleDefaultFilter.Keys(),
+		ToggleFavorite:          k.ToggleFavorite.Keys(),
+		EditTags:                k.EditTags.Keys(),
+		SaveSnippet:             k.SaveSnippet.Keys(),
+		BrowseSnippets:          k.BrowseSnippets.Keys(),
+		ExplainCommand:          k.ExplainCommand.Keys(),
+		ToggleMark:              k.ToggleMark.Keys(),
+		PublishToChannel:        k.PublishToChannel.Keys(),
 	}
 }
 
@@ -242,10 +511,28 @@ func (k KeyMap) ShortHelp() []key.Binding {
 
 func (k KeyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{fakeTitleKeyBinding, k.Up, k.Left, k.SelectEntry, k.SelectEntryAndChangeDir},
-		{fakeEmptyKeyBinding, k.Down, k.Right, k.DeleteEntry},
-		{fakeEmptyKeyBinding, k.PageUp, k.TableLeft, k.Quit},
-		{fakeEmptyKeyBinding, k.PageDown, k.TableRight, k.Help},
+		{fakeTitleKeyBinding, k.Up, k.Left, k.SelectEntry, k.SelectEntryAndChangeDir, k.ClearQuery},
+		{fakeEmptyKeyBinding, k.Down, k.Right, k.DeleteEntry, k.ToggleCurrentSession, k.OpenCommandPalette},
+		{fakeEmptyKeyBinding, k.PageUp, k.TableLeft, k.Quit, k.CycleSortOrder, k.OpenAiChat},
+		{fakeEmptyKeyBinding, k.PageDown, k.TableRight, k.Help, k.CycleSearchScope, k.CycleRanker},
+	}
+}
+
+// A category of key bindings in the full-screen key binding reference
+type HelpSection struct {
+	Title    string
+	Bindings []key.Binding
+}
+
+// Returns every key binding grouped by category, for the full-screen key binding reference. Unlike FullHelp, this
+// includes every binding since the reference isn't limited to a few rows.
+func (k KeyMap) HelpSections() []HelpSection {
+	return []HelpSection{
+		{"Navigation", []key.Binding{k.Up, k.Down, k.PageUp, k.PageDown, k.TableLeft, k.TableRight, k.ScrollSelectedLeft, k.ScrollSelectedRight}},
+		{"Editing the query", []key.Binding{k.Left, k.Right, k.WordLeft, k.WordRight, k.JumpStartOfInput, k.JumpEndOfInput, k.ClearQuery, k.PreviousQuery, k.NextQuery}},
+		{"Searching", []key.Binding{k.ToggleCurrentSession, k.CycleSortOrder, k.CycleSearchScope, k.CycleDefaultFilter, k.CycleRanker, k.ToggleSampling}},
+		{"Entries", []key.Binding{k.SelectEntry, k.SelectEntryAndChangeDir, k.DeleteEntry, k.UndoDelete, k.ToggleFavorite, k.ToggleMark, k.EditTags, k.SaveSnippet, k.BrowseSnippets, k.PublishToChannel}},
+		{"Other", []key.Binding{k.OpenCommandPalette, k.OpenAiChat, k.ExplainCommand, k.Help, k.Quit}},
 	}
 }
 
@@ -295,6 +582,14 @@ var DefaultKeyMap = KeyMap{
 		key.WithKeys("shift+right"),
 		key.WithHelp("shift+→ ", "scroll the table right "),
 	),
+	ScrollSelectedLeft: key.NewBinding(
+		key.WithKeys("ctrl+shift+left"),
+		key.WithHelp("ctrl+shift+← ", "scroll the highlighted entry left "),
+	),
+	ScrollSelectedRight: key.NewBinding(
+		key.WithKeys("ctrl+shift+right"),
+		key.WithHelp("ctrl+shift+→ ", "scroll the highlighted entry right "),
+	),
 	DeleteEntry: key.NewBinding(
 		key.WithKeys("ctrl+k"),
 		key.WithHelp("ctrl+k", "delete the highlighted entry "),
@@ -323,4 +618,80 @@ var DefaultKeyMap = KeyMap{
 		key.WithKeys("ctrl+right"),
 		key.WithHelp("ctrl+right", "jump right one word "),
 	),
+	ToggleCurrentSession: key.NewBinding(
+		key.WithKeys("ctrl+t"),
+		key.WithHelp("ctrl+t", "toggle current session filter "),
+	),
+	CycleSortOrder: key.NewBinding(
+		key.WithKeys("ctrl+o"),
+		key.WithHelp("ctrl+o", "cycle sort order "),
+	),
+	CycleSearchScope: key.NewBinding(
+		key.WithKeys("ctrl+s"),
+		key.WithHelp("ctrl+s", "cycle search scope "),
+	),
+	CycleRanker: key.NewBinding(
+		key.WithKeys("ctrl+y"),
+		key.WithHelp("ctrl+y", "cycle ranking strategy "),
+	),
+	ClearQuery: key.NewBinding(
+		key.WithKeys("ctrl+l"),
+		key.WithHelp("ctrl+l", "clear the query "),
+	),
+	OpenCommandPalette: key.NewBinding(
+		key.WithKeys("ctrl+g"),
+		key.WithHelp("ctrl+g", "open the command palette "),
+	),
+	OpenAiChat: key.NewBinding(
+		key.WithKeys("alt+a"),
+		key.WithHelp("alt+a", "open the AI chat "),
+	),
+	ToggleSampling: key.NewBinding(
+		key.WithKeys("ctrl+q"),
+		key.WithHelp("ctrl+q", "toggle result sampling "),
+	),
+	UndoDelete: key.NewBinding(
+		key.WithKeys("ctrl+z"),
+		key.WithHelp("ctrl+z", "undo the last deletion "),
+	),
+	PreviousQuery: key.NewBinding(
+		key.WithKeys("alt+up"),
+		key.WithHelp("alt+↑ ", "recall the previous search query "),
+	),
+	NextQuery: key.NewBinding(
+		key.WithKeys("alt+down"),
+		key.WithHelp("alt+↓ ", "recall the next search query "),
+	),
+	CycleDefaultFilter: key.NewBinding(
+		key.WithKeys("alt+c"),
+		key.WithHelp("alt+c", "cycle default filter "),
+	),
+	ToggleFavorite: key.NewBinding(
+		key.WithKeys("alt+s"),
+		key.WithHelp("alt+s", "toggle favorite "),
+	),
+	EditTags: key.NewBinding(
+		key.WithKeys("alt+t"),
+		key.WithHelp("alt+t", "edit tags "),
+	),
+	SaveSnippet: key.NewBinding(
+		key.WithKeys("alt+n"),
+		key.WithHelp("alt+n", "save as a snippet "),
+	),
+	BrowseSnippets: key.NewBinding(
+		key.WithKeys("alt+r"),
+		key.WithHelp("alt+r", "browse snippets "),
+	),
+	ExplainCommand: key.NewBinding(
+		key.WithKeys("alt+e"),
+		key.WithHelp("alt+e", "explain the highlighted command with AI "),
+	),
+	ToggleMark: key.NewBinding(
+		key.WithKeys("alt+m"),
+		key.WithHelp("alt+m", "mark the highlighted entry for export "),
+	),
+	PublishToChannel: key.NewBinding(
+		key.WithKeys("alt+p"),
+		key.WithHelp("alt+p", "publish to a team channel "),
+	),
 }
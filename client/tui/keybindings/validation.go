@@ -0,0 +1,236 @@
+package keybindings
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The names of every key that the TUI can receive, other than typed characters. These match the key names used by
+// bubbletea, which is what key bindings are compared against.
+var validKeyNames = makeValidKeyNames()
+
+func makeValidKeyNames() map[string]bool {
+	names := []string{
+		"enter", "tab", "shift+tab", "esc", "backspace", "delete", "insert",
+		"up", "down", "left", "right", "home", "end", "pgup", "pgdown",
+		"ctrl+up", "ctrl+down", "ctrl+left", "ctrl+right", "ctrl+home", "ctrl+end", "ctrl+pgup", "ctrl+pgdown",
+		"shift+up", "shift+down", "shift+left", "shift+right", "shift+home", "shift+end",
+		"ctrl+shift+up", "ctrl+shift+down", "ctrl+shift+left", "ctrl+shift+right", "ctrl+shift+home", "ctrl+shift+end",
+		"ctrl+@", "ctrl+\\", "ctrl+]", "ctrl+^", "ctrl+_",
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		if _, ok := indistinguishableKeys["ctrl+"+string(c)]; !ok {
+			names = append(names, "ctrl+"+string(c))
+		}
+	}
+	for i := 1; i <= 20; i++ {
+		names = append(names, fmt.Sprintf("f%d", i))
+	}
+	ret := make(map[string]bool)
+	for _, name := range names {
+		ret[name] = true
+	}
+	return ret
+}
+
+// Keys that terminals send as the same bytes as another key, so that they can never be told apart
+var indistinguishableKeys = map[string]string{
+	"ctrl+i": "tab",
+	"ctrl+m": "enter",
+	"ctrl+[": "esc",
+}
+
+// Keys that are needed to use the TUI, and the action that they're expected to be bound to
+var essentialKeys = []struct {
+	key    string
+	action string
+}{
+	{"enter", "select-entry"},
+	{"esc", "quit"},
+}
+
+// The keys that the search query's text input uses to edit the query (matching bubbles' textinput.DefaultKeyMap), along
+// with the action that performs the same edit, if any. Binding one of these to any other action means that it no longer
+// edits the query, which is surprising for users that rely on the readline key bindings.
+var textInputKeys = map[string]struct {
+	edit   string
+	action string
+}{
+	"right":         {"moving the cursor right", "right"},
+	"ctrl+f":        {"moving the cursor right", "right"},
+	"left":          {"moving the cursor left", "left"},
+	"ctrl+b":        {"moving the cursor left", "left"},
+	"alt+right":     {"jumping right one word", "word-right"},
+	"alt+f":         {"jumping right one word", "word-right"},
+	"alt+left":      {"jumping left one word", "word-left"},
+	"alt+b":         {"jumping left one word", "word-left"},
+	"alt+backspace": {"deleting the previous word", ""},
+	"ctrl+w":        {"deleting the previous word", ""},
+	"alt+d":         {"deleting the next word", ""},
+	"ctrl+k":        {"deleting to the end of the query", ""},
+	"ctrl+u":        {"deleting to the start of the query", ""},
+	"backspace":     {"deleting the previous character", ""},
+	"ctrl+h":        {"deleting the previous character", ""},
+	"delete":        {"deleting the next character", ""},
+	"ctrl+d":        {"deleting the next character", ""},
+	"home":          {"jumping to the start of the query", "jump-start-of-input"},
+	"ctrl+a":        {"jumping to the start of the query", "jump-start-of-input"},
+	"end":           {"jumping to the end of the query", "jump-end-of-input"},
+	"ctrl+e":        {"jumping to the end of the query", "jump-end-of-input"},
+	"ctrl+v":        {"pasting", ""},
+}
+
+// Text input keys that hishtory has always bound to other actions by default, so they aren't warned about
+var defaultShadowedTextInputKeys = map[string]string{
+	"ctrl+k": "delete-entry",
+	"ctrl+h": "help",
+	"ctrl+d": "quit",
+}
+
+// Returns the individual key presses that make up the given key binding. Bindings containing spaces (e.g.
+// "ctrl+x ctrl+k") are chords that are triggered by pressing each of the keys in order.
+func ChordKeys(binding string) []string {
+	if binding == " " {
+		// The space key itself
+		return []string{binding}
+	}
+	return strings.Fields(binding)
+}
+
+// Returns whether the given binding is a chord of multiple key presses
+func IsChord(binding string) bool {
+	return len(ChordKeys(binding)) > 1
+}
+
+func validateKey(k string) error {
+	if k == " " || validKeyNames[k] {
+		return nil
+	}
+	if alias, ok := indistinguishableKeys[k]; ok {
+		return fmt.Errorf("terminals send %q as %q so the TUI can't tell them apart, use %q instead", k, alias, alias)
+	}
+	if k == "space" {
+		return fmt.Errorf("the space key is written as \" \"")
+	}
+	if rest, ok := strings.CutPrefix(k, "alt+"); ok && rest != "" {
+		if !strings.Contains(rest, "+") {
+			// Alt followed by a typed character (e.g. "alt+b"), or by an escape sequence that isn't otherwise
+			// recognized (e.g. "alt+OA")
+			return nil
+		}
+		return validateKey(rest)
+	}
+	if r, size := utf8.DecodeRuneInString(k); size == len(k) && r != utf8.RuneError && unicode.IsPrint(r) {
+		// A single typed character
+		return nil
+	}
+	return fmt.Errorf("%q is not a valid key, keys are written like \"ctrl+k\", \"alt+b\", \"shift+left\", \"enter\", \"pgdown\", or \"f5\"", k)
+}
+
+// Returns the chord in k that is completed by the given key presses (or an empty string if there is none), and whether
+// the key presses are the start of a longer chord
+func (k KeyMap) MatchChord(keys []string) (string, bool) {
+	isPrefix := false
+	for _, section := range k.HelpSections() {
+		for _, binding := range section.Bindings {
+			for _, b := range binding.Keys() {
+				chord := ChordKeys(b)
+				if len(chord) < 2 || len(chord) < len(keys) {
+					continue
+				}
+				matches := true
+				for i := range keys {
+					if chord[i] != keys[i] {
+						matches = false
+						break
+					}
+				}
+				if !matches {
+					continue
+				}
+				if len(chord) == len(keys) {
+					return b, false
+				}
+				isPrefix = true
+			}
+		}
+	}
+	return "", isPrefix
+}
+
+// Validates every key binding. Returns an error if any binding can never be triggered (e.g. because it contains a
+// typo), and warnings for bindings that are valid but likely don't behave as intended (e.g. because the same key is
+// bound to multiple actions).
+func (s SerializableKeyMap) Validate() ([]string, error) {
+	actions := s.actions()
+	for _, action := range actions {
+		for _, binding := range *action.keys {
+			chord := ChordKeys(binding)
+			if len(chord) == 0 {
+				return nil, fmt.Errorf("invalid key binding for %s: key bindings can't be empty", action.name)
+			}
+			for _, k := range chord {
+				if err := validateKey(k); err != nil {
+					return nil, fmt.Errorf("invalid key binding %q for %s: %w", binding, action.name, err)
+				}
+			}
+		}
+	}
+
+	warnings := make([]string, 0)
+	bindingActions := make(map[string][]string)
+	bindingsInOrder := make([]string, 0)
+	for _, action := range actions {
+		for _, binding := range *action.keys {
+			binding = strings.Join(ChordKeys(binding), " ")
+			if _, ok := bindingActions[binding]; !ok {
+				bindingsInOrder = append(bindingsInOrder, binding)
+			}
+			bindingActions[binding] = append(bindingActions[binding], action.name)
+		}
+	}
+	for _, binding := range bindingsInOrder {
+		if names := bindingActions[binding]; len(names) > 1 {
+			warnings = append(warnings, fmt.Sprintf("%q is bound to multiple actions (%s), so it only triggers one of them", binding, strings.Join(names, ", ")))
+		}
+	}
+	for _, binding := range bindingsInOrder {
+		if !IsChord(binding) {
+			continue
+		}
+		firstKey := ChordKeys(binding)[0]
+		if names, ok := bindingActions[firstKey]; ok {
+			warnings = append(warnings, fmt.Sprintf("%q starts the chord %q for %s, so it no longer triggers %s", firstKey, binding, strings.Join(bindingActions[binding], ", "), strings.Join(names, ", ")))
+		}
+		if utf8.RuneCountInString(firstKey) == 1 && firstKey != " " {
+			warnings = append(warnings, fmt.Sprintf("%q starts the chord %q, so it can no longer be typed into the search query", firstKey, binding))
+		}
+	}
+	for _, binding := range bindingsInOrder {
+		firstKey := ChordKeys(binding)[0]
+		textInputKey, ok := textInputKeys[firstKey]
+		if !ok {
+			continue
+		}
+		for _, name := range bindingActions[binding] {
+			if name != textInputKey.action && name != defaultShadowedTextInputKeys[firstKey] {
+				warnings = append(warnings, fmt.Sprintf("%q is bound to %s, which shadows its use for %s in the search query", binding, name, textInputKey.edit))
+			}
+		}
+	}
+	for _, essential := range essentialKeys {
+		for _, binding := range bindingsInOrder {
+			if ChordKeys(binding)[0] != essential.key {
+				continue
+			}
+			for _, name := range bindingActions[binding] {
+				if name != essential.action {
+					warnings = append(warnings, fmt.Sprintf("%q is bound to %s, which shadows its default use for %s", binding, name, essential.action))
+				}
+			}
+		}
+	}
+	return warnings, nil
+}
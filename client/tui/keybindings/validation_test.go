@@ -0,0 +1,85 @@
+package keybindings
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateKey(t *testing.T) {
+	for _, k := range []string{"ctrl+k", "enter", "esc", "shift+left", "ctrl+shift+up", "pgdown", "f5", "?", "k", " ", "alt+b", "alt+OA", "alt+enter", "ctrl+h"} {
+		require.NoError(t, validateKey(k), k)
+	}
+	for _, k := range []string{"", "ctrl+shit+x", "control+k", "enterr", "ctrl+i", "ctrl+m", "space", "alt+ctrl+i", "ctrl+k+j"} {
+		require.Error(t, validateKey(k), k)
+	}
+	require.ErrorContains(t, validateKey("ctrl+i"), `use "tab" instead`)
+}
+
+func TestValidate(t *testing.T) {
+	// The default key bindings are all valid and don't conflict
+	warnings, err := DefaultKeyMap.ToSerializable().Validate()
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	// Invalid keys are rejected with the action they were bound to
+	bindings := DefaultKeyMap.ToSerializable()
+	bindings.DeleteEntry = []string{"ctrl+k", "ctrl+shit+x"}
+	_, err = bindings.Validate()
+	require.ErrorContains(t, err, `invalid key binding "ctrl+shit+x" for delete-entry`)
+	bindings.DeleteEntry = []string{"ctrl+x ctrl+i"}
+	_, err = bindings.Validate()
+	require.ErrorContains(t, err, `invalid key binding "ctrl+x ctrl+i" for delete-entry`)
+
+	// Conflicts, chords that shadow other bindings, and shadowed essential keys are warned about
+	bindings = DefaultKeyMap.ToSerializable()
+	bindings.DeleteEntry = []string{"ctrl+t"}
+	bindings.CycleRanker = []string{"ctrl+x y"}
+	bindings.Help = []string{"enter"}
+	bindings.ClearQuery = []string{"g g"}
+	warnings, err = bindings.Validate()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`"enter" is bound to multiple actions (select-entry, help), so it only triggers one of them`,
+		`"ctrl+t" is bound to multiple actions (delete-entry, toggle-current-session), so it only triggers one of them`,
+		`"ctrl+x" starts the chord "ctrl+x y" for cycle-ranker, so it no longer triggers select-entry-and-cd`,
+		`"g" starts the chord "g g", so it can no longer be typed into the search query`,
+		`"enter" is bound to help, which shadows its default use for select-entry`,
+	}, warnings)
+
+	// As are bindings that shadow the keys used to edit the search query, unless they perform the same edit
+	bindings = DefaultKeyMap.ToSerializable()
+	bindings.CycleDefaultFilter = []string{"ctrl+f"}
+	bindings.OpenAiChat = []string{"ctrl+w a"}
+	bindings.WordLeft = []string{"ctrl+left", "alt+b"}
+	warnings, err = bindings.Validate()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`"ctrl+f" is bound to cycle-default-filter, which shadows its use for moving the cursor right in the search query`,
+		`"ctrl+w a" is bound to ai-chat, which shadows its use for deleting the previous word in the search query`,
+	}, warnings)
+}
+
+func TestMatchChord(t *testing.T) {
+	bindings := DefaultKeyMap.ToSerializable()
+	bindings.DeleteEntry = []string{"ctrl+x ctrl+k"}
+	bindings.UndoDelete = []string{"ctrl+x u i"}
+	keyMap := bindings.ToKeyMap()
+
+	chord, isPrefix := keyMap.MatchChord([]string{"ctrl+x"})
+	require.Equal(t, "", chord)
+	require.True(t, isPrefix)
+	chord, isPrefix = keyMap.MatchChord([]string{"ctrl+x", "ctrl+k"})
+	require.Equal(t, "ctrl+x ctrl+k", chord)
+	require.False(t, isPrefix)
+	chord, isPrefix = keyMap.MatchChord([]string{"ctrl+x", "u"})
+	require.Equal(t, "", chord)
+	require.True(t, isPrefix)
+	chord, isPrefix = keyMap.MatchChord([]string{"ctrl+x", "j"})
+	require.Equal(t, "", chord)
+	require.False(t, isPrefix)
+	// Single keys aren't chords
+	chord, isPrefix = keyMap.MatchChord([]string{"ctrl+k"})
+	require.Equal(t, "", chord)
+	require.False(t, isPrefix)
+}
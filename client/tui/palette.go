@@ -0,0 +1,247 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+)
+
+// The maximum number of actions that are displayed in the command palette at once
+const PALETTE_HEIGHT = 10
+
+type paletteAction struct {
+	// The user-facing name of the action, which is what the palette query is matched against
+	name string
+	// Returns the key binding that also triggers this action, if any. A function so that reloaded key bindings are
+	// reflected.
+	binding func() *key.Binding
+	run     func(m model) (model, tea.Cmd)
+}
+
+// Every action that can be run from the command palette
+var PALETTE_ACTIONS = []paletteAction{
+	{"Toggle current session filter", func() *key.Binding { return &loadedKeyBindings.ToggleCurrentSession }, toggleCurrentSession},
+	{"Cycle sort order", func() *key.Binding { return &loadedKeyBindings.CycleSortOrder }, cycleSortOrder},
+	{"Cycle search scope", func() *key.Binding { return &loadedKeyBindings.CycleSearchScope }, cycleSearchScope},
+	{"Cycle default filter", func() *key.Binding { return &loadedKeyBindings.CycleDefaultFilter }, cycleDefaultFilter},
+	{"Cycle ranking strategy", func() *key.Binding { return &loadedKeyBindings.CycleRanker }, cycleRanker},
+	{"Clear the query", func() *key.Binding { return &loadedKeyBindings.ClearQuery }, clearQuery},
+	{"Recall the previous search query", func() *key.Binding { return &loadedKeyBindings.PreviousQuery }, recallPreviousQuery},
+	{"Delete the highlighted entry", func() *key.Binding { return &loadedKeyBindings.DeleteEntry }, deleteSelectedEntry},
+	{"Undo the last deletion", func() *key.Binding { return &loadedKeyBindings.UndoDelete }, undoDelete},
+	{"Toggle favorite on the highlighted entry", func() *key.Binding { return &loadedKeyBindings.ToggleFavorite }, toggleFavorite},
+	{"Toggle mark on the highlighted entry", func() *key.Binding { return &loadedKeyBindings.ToggleMark }, toggleMark},
+	{"Edit the highlighted entry's tags", func() *key.Binding { return &loadedKeyBindings.EditTags }, openTagPrompt},
+	{"Save the highlighted entry as a snippet", func() *key.Binding { return &loadedKeyBindings.SaveSnippet }, openSnippetPrompt},
+	{"Browse snippets", func() *key.Binding { return &loadedKeyBindings.BrowseSnippets }, openSnippetBrowser},
+	{"Publish the highlighted entry to a team channel", func() *key.Binding { return &loadedKeyBindings.PublishToChannel }, openChannelPrompt},
+	{"Toggle help", func() *key.Binding { return &loadedKeyBindings.Help }, toggleHelp},
+	{"Refine AI suggestions in a chat", func() *key.Binding { return &loadedKeyBindings.OpenAiChat }, openAiChat},
+	{"Explain the highlighted command with AI", func() *key.Binding { return &loadedKeyBindings.ExplainCommand }, openExplanationPanel},
+	{"Toggle result sampling", func() *key.Binding { return &loadedKeyBindings.ToggleSampling }, toggleResultSampling},
+	{"Toggle duplicate filtering", nil, toggleDuplicateFiltering},
+	{"Export results to a file", nil, exportResults},
+	{"Save marked entries as a shell script", nil, exportMarkedEntriesAsScript},
+}
+
+type commandPalette struct {
+	// The fuzzy search box for filtering actions
+	input textinput.Model
+	// The actions matching the current input, best match first
+	matches []paletteAction
+	// The index of the selected action within matches
+	cursor int
+}
+
+func newCommandPalette(width int) *commandPalette {
+	input := textinput.New()
+	input.Placeholder = "sort"
+	input.Width = width
+	input.Focus()
+	return &commandPalette{input: input, matches: filterPaletteActions(PALETTE_ACTIONS, "")}
+}
+
+func updateCommandPalette(m model, msg tea.KeyMsg) (model, tea.Cmd) {
+	p := m.palette
+	switch {
+	case msg.Type == tea.KeyEsc || msg.Type == tea.KeyCtrlC || key.Matches(msg, loadedKeyBindings.OpenCommandPalette):
+		m.palette = nil
+		return m, nil
+	case key.Matches(msg, loadedKeyBindings.SelectEntry):
+		m.palette = nil
+		if len(p.matches) == 0 {
+			return m, nil
+		}
+		m.notice = ""
+		return p.matches[p.cursor].run(m)
+	case key.Matches(msg, loadedKeyBindings.Up):
+		p.cursor = max(p.cursor-1, 0)
+		return m, nil
+	case key.Matches(msg, loadedKeyBindings.Down):
+		p.cursor = min(p.cursor+1, max(len(p.matches)-1, 0))
+		return m, nil
+	default:
+		var cmd tea.Cmd
+		p.input, cmd = p.input.Update(msg)
+		p.matches = filterPaletteActions(PALETTE_ACTIONS, p.input.Value())
+		p.cursor = 0
+		return m, cmd
+	}
+}
+
+func renderCommandPalette(m model) string {
+	p := m.palette
+	config := hctx.GetConf(m.ctx)
+	selectedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(config.ColorScheme.SelectedText)).Background(lipgloss.Color(config.ColorScheme.SelectedBackground))
+	lines := []string{"Command Palette: " + p.input.View(), ""}
+	for i, action := range p.matches {
+		if i >= PALETTE_HEIGHT {
+			break
+		}
+		line := action.name
+		if action.binding != nil {
+			line += " (" + action.binding().Help().Key + ")"
+		}
+		if i == p.cursor {
+			line = selectedStyle.Render("> " + line)
+		} else {
+			line = "  " + line
+		}
+		lines = append(lines, line)
+	}
+	if len(p.matches) == 0 {
+		lines = append(lines, "  No matching actions")
+	}
+	// Pad the palette to the height of the table so that the layout doesn't jump around
+	for len(lines) < TABLE_HEIGHT+3 {
+		lines = append(lines, "")
+	}
+	return getBaseStyle(*config).Render(strings.Join(lines, "\n"))
+}
+
+// Returns the actions that fuzzily match the given query, ordered from best to worst match
+func filterPaletteActions(actions []paletteAction, query string) []paletteAction {
+	type scoredAction struct {
+		action paletteAction
+		score  int
+	}
+	scored := make([]scoredAction, 0)
+	for _, action := range actions {
+		if score, ok := fuzzyMatchScore(query, action.name); ok {
+			scored = append(scored, scoredAction{action, score})
+		}
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].score > scored[j].score
+	})
+	matches := make([]paletteAction, 0, len(scored))
+	for _, s := range scored {
+		matches = append(matches, s.action)
+	}
+	return matches
+}
+
+// Returns whether every character of query appears in candidate in order (ignoring case), and if so a score where
+// higher is a better match. Consecutive characters and characters at the start of words score higher, so "sort" ranks
+// "Cycle sort order" above "Toggle current session filter".
+func fuzzyMatchScore(query, candidate string) (int, bool) {
+	queryRunes := []rune(strings.ToLower(strings.ReplaceAll(query, " ", "")))
+	candidateRunes := []rune(strings.ToLower(candidate))
+	score := 0
+	queryIdx := 0
+	prevMatchIdx := -2
+	for i, r := range candidateRunes {
+		if queryIdx >= len(queryRunes) {
+			break
+		}
+		if r != queryRunes[queryIdx] {
+			continue
+		}
+		score += 1
+		if prevMatchIdx == i-1 {
+			score += 3
+		}
+		if i == 0 || !unicode.IsLetter(candidateRunes[i-1]) {
+			score += 2
+		}
+		prevMatchIdx = i
+		queryIdx += 1
+	}
+	if queryIdx < len(queryRunes) {
+		return 0, false
+	}
+	return score, true
+}
+
+// Toggles whether duplicate commands are filtered out for the rest of this TUI session. Note that this isn't persisted,
+// `hishtory config-set filter-duplicate-commands` can be used for that.
+func toggleDuplicateFiltering(m model) (model, tea.Cmd) {
+	config := hctx.GetConf(m.ctx)
+	config.FilterDuplicateCommands = !config.FilterDuplicateCommands
+	cmd := runQueryAndUpdateTable(m, true, false)
+	return m, cmd
+}
+
+// Toggles whether queries matching a huge number of entries display a sample of them for the rest of this TUI session,
+// so that every match can be paged through when needed. Note that this isn't persisted, `hishtory config-set
+// result-sampling` can be used for that.
+func toggleResultSampling(m model) (model, tea.Cmd) {
+	config := hctx.GetConf(m.ctx)
+	config.ResultSampling = !config.ResultSampling
+	cmd := runQueryAndUpdateTable(m, true, false)
+	return m, cmd
+}
+
+// Writes the commands of the currently loaded results to a file in the hishtory directory
+func exportResults(m model) (model, tea.Cmd) {
+	exportPath := path.Join(hctx.GetHome(m.ctx), data.GetHishtoryPath(), "tui-export-"+time.Now().Format("2006-01-02T15-04-05")+".txt")
+	var sb strings.Builder
+	for _, entry := range m.tableEntries {
+		sb.WriteString(entry.Command)
+		sb.WriteString("\n")
+	}
+	err := os.WriteFile(exportPath, []byte(sb.String()), 0600)
+	if err != nil {
+		m.notice = fmt.Sprintf("Warning: failed to export results: %v", err)
+	} else {
+		m.notice = fmt.Sprintf("Exported %d results to %s", len(m.tableEntries), exportPath)
+	}
+	return m, nil
+}
+
+// Writes the marked entries to an executable shell script in the hishtory directory, in the order that they were run
+func exportMarkedEntriesAsScript(m model) (model, tea.Cmd) {
+	if len(MARKED_ENTRIES) == 0 {
+		m.notice = fmt.Sprintf("No entries are marked, press %s to mark the highlighted entry", loadedKeyBindings.ToggleMark.Help().Key)
+		return m, nil
+	}
+	entries := make([]*data.HistoryEntry, 0, len(MARKED_ENTRIES))
+	for _, entry := range MARKED_ENTRIES {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StartTime.Before(entries[j].StartTime)
+	})
+	exportPath := path.Join(hctx.GetHome(m.ctx), data.GetHishtoryPath(), "tui-export-"+time.Now().Format("2006-01-02T15-04-05")+".sh")
+	err := os.WriteFile(exportPath, []byte(lib.BuildShellScript(entries, true)), 0700)
+	if err != nil {
+		m.notice = fmt.Sprintf("Warning: failed to save the script: %v", err)
+	} else {
+		m.notice = fmt.Sprintf("Saved %d commands to %s", len(entries), exportPath)
+		MARKED_ENTRIES = make(map[string]*data.HistoryEntry)
+	}
+	cmd := runQueryAndUpdateTable(m, true, true)
+	return m, cmd
+}
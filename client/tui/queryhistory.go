@@ -0,0 +1,62 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ddworken/hishtory/client/lib"
+)
+
+func recallPreviousQuery(m model) (model, tea.Cmd) {
+	return recallQuery(m, 1)
+}
+
+func recallNextQuery(m model) (model, tea.Cmd) {
+	return recallQuery(m, -1)
+}
+
+// Replaces the search query with a previously run search query, similar to a browser's search history. delta is 1 to
+// recall an older query, or -1 to go back towards the query that was being typed before cycling through previous
+// queries.
+func recallQuery(m model, delta int) (model, tea.Cmd) {
+	if m.queryHistory == nil {
+		if delta < 0 {
+			// Not currently cycling through previous queries, so there is no newer query to go back to
+			return m, nil
+		}
+		history, err := lib.GetSearchQueryHistory(m.ctx)
+		if err != nil {
+			m.notice = fmt.Sprintf("Warning: failed to load previous search queries: %v", err)
+			return m, nil
+		}
+		m.queryHistory = make([]string, 0, len(history))
+		for _, query := range history {
+			if query != strings.TrimSpace(m.queryInput.Value()) {
+				m.queryHistory = append(m.queryHistory, query)
+			}
+		}
+		m.queryHistoryDraft = m.queryInput.Value()
+		m.queryHistoryIndex = -1
+	}
+	index := max(-1, min(m.queryHistoryIndex+delta, len(m.queryHistory)-1))
+	if index == m.queryHistoryIndex {
+		if len(m.queryHistory) == 0 {
+			m.notice = "There are no previous search queries"
+		}
+		return m, nil
+	}
+	m.queryHistoryIndex = index
+	query := m.queryHistoryDraft
+	if index >= 0 {
+		query = m.queryHistory[index]
+	}
+	m.queryInput.SetValue(query)
+	m.queryInput.SetCursor(len(query))
+	m.runQuery = &query
+	CURRENT_QUERY_FOR_HIGHLIGHTING = query
+	if m.table != nil {
+		m.table.SetCursor(0)
+	}
+	return m, runQueryAndUpdateTable(m, true, false)
+}
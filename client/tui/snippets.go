@@ -0,0 +1,172 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+)
+
+type snippetPrompt struct {
+	// The input box for the name of the snippet
+	input textinput.Model
+	// The command that is being saved as a snippet
+	command string
+}
+
+// Opens a prompt for saving the highlighted entry as a named snippet
+func openSnippetPrompt(m model) (model, tea.Cmd) {
+	if m.table == nil || len(m.tableEntries) == 0 {
+		return m, nil
+	}
+	input := textinput.New()
+	input.Placeholder = "deploy"
+	input.Width = m.queryInput.Width
+	input.Focus()
+	m.snippetPrompt = &snippetPrompt{input: input, command: m.tableEntries[m.table.Cursor()].Command}
+	return m, nil
+}
+
+func updateSnippetPrompt(m model, msg tea.KeyMsg) (model, tea.Cmd) {
+	p := m.snippetPrompt
+	switch {
+	case msg.Type == tea.KeyEsc || msg.Type == tea.KeyCtrlC:
+		m.snippetPrompt = nil
+		return m, nil
+	case key.Matches(msg, loadedKeyBindings.SelectEntry):
+		name := strings.TrimSpace(p.input.Value())
+		if err := lib.SaveSnippet(m.ctx, name, p.command); err != nil {
+			m.notice = fmt.Sprintf("Warning: failed to save the snippet: %v", err)
+			return m, nil
+		}
+		m.snippetPrompt = nil
+		m.notice = fmt.Sprintf("Saved the command as the snippet %s (run it via `hishtory snippet run %s`)", name, name)
+		return m, nil
+	default:
+		var cmd tea.Cmd
+		p.input, cmd = p.input.Update(msg)
+		return m, cmd
+	}
+}
+
+func renderSnippetPrompt(m model) string {
+	config := hctx.GetConf(m.ctx)
+	lines := []string{"Snippet name: " + m.snippetPrompt.input.View(), "(enter to save, esc to cancel)"}
+	return getBaseStyle(*config).Render(strings.Join(lines, "\n"))
+}
+
+type snippetBrowser struct {
+	// The fuzzy search box for filtering snippets
+	input textinput.Model
+	// Every snippet
+	snippets []*data.Snippet
+	// The snippets matching the current input, best match first
+	matches []*data.Snippet
+	// The index of the selected snippet within matches
+	cursor int
+}
+
+// Opens a list of every snippet, where selecting a snippet selects its command just like selecting a history entry
+func openSnippetBrowser(m model) (model, tea.Cmd) {
+	snippets, err := lib.ListSnippets(m.ctx)
+	if err != nil {
+		m.notice = fmt.Sprintf("Warning: failed to load snippets: %v", err)
+		return m, nil
+	}
+	if len(snippets) == 0 {
+		m.notice = fmt.Sprintf("You don't have any snippets, press %s to save the highlighted entry as one", loadedKeyBindings.SaveSnippet.Help().Key)
+		return m, nil
+	}
+	input := textinput.New()
+	input.Placeholder = "deploy"
+	input.Width = m.queryInput.Width
+	input.Focus()
+	m.snippetBrowser = &snippetBrowser{input: input, snippets: snippets, matches: snippets}
+	return m, nil
+}
+
+// Returns the snippets whose name or command fuzzily match the query, best match first
+func filterSnippets(snippets []*data.Snippet, query string) []*data.Snippet {
+	type scoredSnippet struct {
+		snippet *data.Snippet
+		score   int
+	}
+	scored := make([]scoredSnippet, 0)
+	for _, s := range snippets {
+		if score, ok := fuzzyMatchScore(query, s.Name+" "+s.Command); ok {
+			scored = append(scored, scoredSnippet{s, score})
+		}
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].score > scored[j].score
+	})
+	matches := make([]*data.Snippet, 0, len(scored))
+	for _, s := range scored {
+		matches = append(matches, s.snippet)
+	}
+	return matches
+}
+
+func updateSnippetBrowser(m model, msg tea.KeyMsg) (model, tea.Cmd) {
+	b := m.snippetBrowser
+	switch {
+	case msg.Type == tea.KeyEsc || msg.Type == tea.KeyCtrlC || key.Matches(msg, loadedKeyBindings.BrowseSnippets):
+		m.snippetBrowser = nil
+		return m, nil
+	case key.Matches(msg, loadedKeyBindings.SelectEntry):
+		if len(b.matches) == 0 {
+			return m, nil
+		}
+		SELECTED_COMMAND = b.matches[b.cursor].Command
+		m.quitting = true
+		return m, tea.Quit
+	case key.Matches(msg, loadedKeyBindings.Up):
+		b.cursor = max(b.cursor-1, 0)
+		return m, nil
+	case key.Matches(msg, loadedKeyBindings.Down):
+		b.cursor = min(b.cursor+1, max(len(b.matches)-1, 0))
+		return m, nil
+	default:
+		var cmd tea.Cmd
+		b.input, cmd = b.input.Update(msg)
+		b.matches = filterSnippets(b.snippets, b.input.Value())
+		b.cursor = 0
+		return m, cmd
+	}
+}
+
+func renderSnippetBrowser(m model) string {
+	b := m.snippetBrowser
+	config := hctx.GetConf(m.ctx)
+	selectedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(config.ColorScheme.SelectedText)).Background(lipgloss.Color(config.ColorScheme.SelectedBackground))
+	lines := []string{"Snippets: " + b.input.View(), ""}
+	// Keep the selected snippet visible when there are more matches than fit
+	start := max(0, b.cursor-TABLE_HEIGHT+1)
+	for i, s := range b.matches {
+		if i < start || i >= start+TABLE_HEIGHT {
+			continue
+		}
+		line := s.Name + ": " + strings.ReplaceAll(s.Command, "\n", "\\n")
+		if i == b.cursor {
+			line = selectedStyle.Render("> " + line)
+		} else {
+			line = "  " + line
+		}
+		lines = append(lines, line)
+	}
+	if len(b.matches) == 0 {
+		lines = append(lines, "  No matching snippets")
+	}
+	// Pad to the height of the table so that the layout doesn't jump around
+	for len(lines) < TABLE_HEIGHT+3 {
+		lines = append(lines, "")
+	}
+	return getBaseStyle(*config).Render(strings.Join(lines, "\n"))
+}
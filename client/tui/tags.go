@@ -0,0 +1,90 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+)
+
+type tagPrompt struct {
+	// The input box for the space-separated tags, pre-filled with the entry's current tags
+	input textinput.Model
+	// The entry whose tags are being edited
+	entry *data.HistoryEntry
+}
+
+// Opens a prompt for editing the tags of the highlighted entry
+func openTagPrompt(m model) (model, tea.Cmd) {
+	if m.table == nil || len(m.tableEntries) == 0 {
+		return m, nil
+	}
+	entry := m.tableEntries[m.table.Cursor()]
+	input := textinput.New()
+	input.Placeholder = "deploy incident-2024-05"
+	input.Width = m.queryInput.Width
+	input.SetValue(strings.Join(entry.Tags, " "))
+	input.CursorEnd()
+	input.Focus()
+	m.tagPrompt = &tagPrompt{input: input, entry: entry}
+	return m, nil
+}
+
+func updateTagPrompt(m model, msg tea.KeyMsg) (model, tea.Cmd) {
+	p := m.tagPrompt
+	switch {
+	case msg.Type == tea.KeyEsc || msg.Type == tea.KeyCtrlC:
+		m.tagPrompt = nil
+		return m, nil
+	case key.Matches(msg, loadedKeyBindings.SelectEntry):
+		m.tagPrompt = nil
+		return saveTags(m, p.entry, strings.Fields(p.input.Value()))
+	default:
+		var cmd tea.Cmd
+		p.input, cmd = p.input.Update(msg)
+		return m, cmd
+	}
+}
+
+// Updates the tags of the given entry so that they're exactly the given tags
+func saveTags(m model, entry *data.HistoryEntry, tags []string) (model, tea.Cmd) {
+	var add, remove []string
+	for _, tag := range tags {
+		if !slices.Contains(entry.Tags, tag) {
+			add = append(add, tag)
+		}
+	}
+	for _, tag := range entry.Tags {
+		if !slices.Contains(tags, tag) {
+			remove = append(remove, tag)
+		}
+	}
+	updated, numChanged, err := lib.UpdateTags(m.ctx, []*data.HistoryEntry{entry}, add, remove)
+	if err != nil {
+		m.notice = fmt.Sprintf("Warning: failed to update the tags: %v", err)
+		return m, nil
+	}
+	if numChanged == 0 {
+		return m, nil
+	}
+	for i, e := range m.tableEntries {
+		if e == entry {
+			m.tableEntries[i] = updated[0]
+		}
+	}
+	m.notice = "Updated the entry's tags"
+	cmd := runQueryAndUpdateTable(m, true, true)
+	return m, cmd
+}
+
+func renderTagPrompt(m model) string {
+	config := hctx.GetConf(m.ctx)
+	lines := []string{"Tags: " + m.tagPrompt.input.View(), "(space-separated, enter to save, esc to cancel)"}
+	return getBaseStyle(*config).Render(strings.Join(lines, "\n"))
+}
@@ -2,13 +2,17 @@ package tui
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	_ "embed" // for embedding config.sh
 
@@ -25,6 +29,7 @@ import (
 	"github.com/ddworken/hishtory/client/table"
 	"github.com/ddworken/hishtory/client/tui/keybindings"
 	"github.com/ddworken/hishtory/shared"
+	sharedai "github.com/ddworken/hishtory/shared/ai"
 	"github.com/muesli/termenv"
 	"golang.org/x/term"
 )
@@ -32,7 +37,23 @@ import (
 const TABLE_HEIGHT = 20
 const PADDED_NUM_ENTRIES = TABLE_HEIGHT * 5
 
+// The maximum number of lines that a multi-line command is displayed across when multi-line-commands is enabled
+const MAX_LINES_PER_ROW = 5
+
+// How often the TUI checks whether the config file was modified so that it can be reloaded
+const CONFIG_CHECK_INTERVAL = time.Second
+
 var CURRENT_QUERY_FOR_HIGHLIGHTING string = ""
+
+// The entries currently displayed in the table, used for dimming rows based on their age
+var CURRENT_TABLE_ENTRIES []*data.HistoryEntry
+
+// The entries that were marked (keyed by entry ID) to be saved as a shell script, which stay marked when the query
+// changes so that commands from different searches can be combined
+var MARKED_ENTRIES = make(map[string]*data.HistoryEntry)
+
+// The progressively dimmer colors used for entries that are older than each of the configured dimming thresholds
+var AGE_DIMMING_COLORS = []string{"248", "244", "240", "236"}
 var SELECTED_COMMAND string = ""
 
 // Globally shared monotonically increasing IDs used to prevent race conditions in handling async queries.
@@ -81,26 +102,95 @@ type model struct {
 	runQuery *string
 	// The previous query that was run.
 	lastQuery string
+	// Whether results are restricted to commands run in the current shell session.
+	onlyCurrentSession bool
+	// The order that search results are sorted in.
+	sortOrder lib.SearchOrder
+	// The column that free-text search terms are restricted to (one of SEARCH_SCOPES), or empty to match all columns.
+	searchScope string
+	// The window of search results that is currently loaded into the table. Note that the window is only ever shifted
+	// away from the top results in large history mode.
+	window rowWindow
+	// The total number of entries matching the query if the table contains a sample of them (see lib.SampleSearch),
+	// or zero if the table contains the top results
+	numSampledMatches int64
 
 	// Unrecoverable error.
 	fatalErr error
 	// An error while searching. Recoverable and displayed as a warning message.
 	searchErr error
+	// Set when the AI endpoint was unreachable for the displayed AI suggestions, which are then cached suggestions (if
+	// any). Displayed as a warning message.
+	aiUnavailableErr *ai.AiUnavailableError
 	// Whether the device is offline. If so, a warning will be displayed.
 	isOffline bool
+	// Whether the backend no longer supports this version of hishtory. If so, a warning with how to upgrade will be
+	// displayed since the history isn't syncing.
+	isClientTooOld bool
 
 	// A banner from the backend to be displayed. Generally an empty string.
 	banner string
 
 	// The currently executing shell. Defaults to bash if not specified. Used for more precise AI suggestions.
 	shellName string
+
+	// The modification time of the config file when it was last loaded. Used to reload the config when it is changed.
+	configModTime time.Time
+
+	// The command palette, if it is currently open
+	palette *commandPalette
+	// A message about the result of the last command palette action (e.g. where results were exported to)
+	notice string
+
+	// The chat panel for iteratively refining AI suggestions, if it is currently open
+	aiChat *aiChatPanel
+
+	// The prompt for editing the tags of an entry, if it is currently open
+	tagPrompt *tagPrompt
+	// The prompt for saving an entry as a named snippet, if it is currently open
+	snippetPrompt *snippetPrompt
+	// The prompt for publishing an entry to a team channel, if it is currently open
+	channelPrompt *channelPrompt
+	// The list of snippets, if it is currently open
+	snippetBrowser *snippetBrowser
+	// The AI explanation of the highlighted command, if it is currently open
+	explanation *explanationPanel
+
+	// Whether the full-screen key binding reference is open
+	showHelpReference bool
+	// The keys pressed so far of a multi-key chord (see keybindings.IsChord) that hasn't yet been completed
+	pendingChord []string
+
+	// The release notes to display in the what's new overlay, if it is currently open
+	whatsNew *shared.ReleaseNotes
+
+	// The previously run search queries (most recent first) while cycling through them, or nil if the user isn't
+	// currently cycling through them. queryHistoryIndex is the index of the displayed query, or -1 if the query that
+	// was being typed beforehand (queryHistoryDraft) is displayed.
+	queryHistory      []string
+	queryHistoryIndex int
+	queryHistoryDraft string
+
+	// The index of the active default filter within getDefaultFilterOptions
+	defaultFilterIndex int
 }
 
 type doneDownloadingMsg struct{}
+type configCheckMsg struct{}
 type offlineMsg struct{}
+type clientTooOldMsg struct{}
 type bannerMsg struct {
 	banner string
 }
+type rowWindow struct {
+	// The offset of the first loaded row within all of the search results
+	offset int
+	// The index of each entry within the search results for this window. This differs from the entry's index in the
+	// table if duplicates were filtered out.
+	resultIndexes []int
+	// Whether there may be additional search results after this window
+	hasMore bool
+}
 type asyncQueryFinishedMsg struct {
 	// The query ID finished running. Used to ensure that we only process this message if it is the latest query to finish.
 	queryId int
@@ -115,6 +205,21 @@ type asyncQueryFinishedMsg struct {
 	maintainCursor bool
 	// An updated search query. May be used for initial queries when they're invalid.
 	overriddenSearchQuery *string
+	// The window of search results that these rows came from
+	window rowWindow
+	// The index within the window's search results that the cursor should be moved to. Used when the window is shifted
+	// in large history mode so that the selected entry stays selected.
+	cursorResultIndex *int
+	// The total number of entries matching the query if these rows are a sample of them, otherwise zero
+	numSampledMatches int64
+}
+
+func getDefaultFilterPrompt(ctx context.Context, defaultFilterIndex int) string {
+	defaultFilter := getDefaultFilter(ctx, defaultFilterIndex)
+	if defaultFilter != "" {
+		return "[" + defaultFilter + "] "
+	}
+	return ""
 }
 
 func initialModel(ctx context.Context, shellName, initialQuery string) model {
@@ -122,10 +227,8 @@ func initialModel(ctx context.Context, shellName, initialQuery string) model {
 	s.Spinner = spinner.Dot
 	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
 	queryInput := textinput.New()
-	defaultFilter := hctx.GetConf(ctx).DefaultFilter
-	if defaultFilter != "" {
-		queryInput.Prompt = "[" + defaultFilter + "] "
-	}
+	defaultFilter := getDefaultFilter(ctx, 0)
+	queryInput.Prompt = getDefaultFilterPrompt(ctx, 0)
 	queryInput.PromptStyle = queryInput.PlaceholderStyle
 	if defaultFilter == "" {
 		queryInput.Placeholder = "ls"
@@ -143,28 +246,70 @@ func initialModel(ctx context.Context, shellName, initialQuery string) model {
 		queryInput.SetValue(initialQuery)
 	}
 	CURRENT_QUERY_FOR_HIGHLIGHTING = initialQuery
-	return model{ctx: ctx, spinner: s, isLoading: true, table: nil, tableEntries: []*data.HistoryEntry{}, runQuery: &initialQuery, queryInput: queryInput, help: help.New(), shellName: shellName}
+	configModTime, err := hctx.GetConfigModTime()
+	if err != nil {
+		hctx.GetLogger().Infof("GetConfigModTime() return err=%v, config changes won't be reloaded", err)
+	}
+	h := help.New()
+	configureHelpStyles(&h, hctx.GetConf(ctx).ColorScheme)
+	return model{ctx: ctx, spinner: s, isLoading: true, table: nil, tableEntries: []*data.HistoryEntry{}, runQuery: &initialQuery, queryInput: queryInput, sortOrder: lib.DefaultSearchOrder, help: h, shellName: shellName, configModTime: configModTime, whatsNew: lib.GetUnseenReleaseNotes(ctx)}
 }
 
 func (m model) Init() tea.Cmd {
-	return m.spinner.Tick
+	return tea.Batch(m.spinner.Tick, scheduleConfigCheck())
+}
+
+func scheduleConfigCheck() tea.Cmd {
+	return tea.Tick(CONFIG_CHECK_INTERVAL, func(time.Time) tea.Msg {
+		return configCheckMsg{}
+	})
+}
+
+// Reloads the config if it was modified since it was last loaded (e.g. via `hishtory config-set` in another shell), so
+// that changes to the color scheme, columns, and key bindings apply without restarting the TUI.
+func reloadConfigIfChanged(m model) (model, tea.Cmd) {
+	configModTime, err := hctx.GetConfigModTime()
+	if err != nil || configModTime.Equal(m.configModTime) {
+		return m, nil
+	}
+	m.configModTime = configModTime
+	config, err := hctx.GetConfig()
+	if err != nil {
+		// The config may be mid-edit, so keep using the previously loaded config
+		hctx.GetLogger().Infof("failed to reload config, keeping the previous config: %v", err)
+		return m, nil
+	}
+	m.ctx = hctx.WithConfig(m.ctx, &config)
+	loadedKeyBindings = config.KeyBindings.ToKeyMap()
+	configureColorProfile(m.ctx)
+	configureHelpStyles(&m.help, config.ColorScheme)
+	if m.queryInput.Prompt != "" {
+		m.queryInput.Prompt = getDefaultFilterPrompt(m.ctx, m.defaultFilterIndex)
+	}
+	return m, runQueryAndUpdateTable(m, true, true)
 }
 
 func updateTable(m model, rows []table.Row, entries []*data.HistoryEntry, searchErr error, forceUpdateTable, maintainCursor bool) model {
 	if m.runQuery == nil {
 		m.runQuery = &m.lastQuery
 	}
+	m.aiUnavailableErr = nil
+	if errors.As(searchErr, &m.aiUnavailableErr) {
+		// Still display any cached AI suggestions, with a warning explaining where they came from
+		searchErr = nil
+	}
 	m.searchErr = searchErr
 	if searchErr != nil {
 		return m
 	}
 	m.tableEntries = entries
+	CURRENT_TABLE_ENTRIES = entries
 	initialCursor := 0
 	if m.table != nil {
 		initialCursor = m.table.Cursor()
 	}
 	if forceUpdateTable || m.table == nil {
-		t, err := makeTable(m.ctx, m.shellName, rows)
+		t, err := makeTable(m.ctx, m.shellName, rows, m.sortOrder)
 		if err != nil {
 			m.fatalErr = err
 			return m
@@ -201,23 +346,129 @@ func runQueryAndUpdateTable(m model, forceUpdateTable, maintainCursor bool) tea.
 		LAST_DISPATCHED_QUERY_ID++
 		queryId := LAST_DISPATCHED_QUERY_ID
 		LAST_DISPATCHED_QUERY_TIMESTAMP = time.Now()
+		if m.aiChat != nil && len(m.aiChat.history) > 0 {
+			history := append([]sharedai.AiChatTurn{}, m.aiChat.history...)
+			return runAiChatQuery(m, queryId, history, m.aiChat.query, forceUpdateTable, maintainCursor)
+		}
 		return func() tea.Msg {
 			conf := hctx.GetConf(m.ctx)
-			defaultFilter := conf.DefaultFilter
-			if m.queryInput.Prompt == "" {
-				// The default filter was cleared for this session, so don't apply it
-				defaultFilter = ""
+			defaultFilter := getQueryDefaultFilter(m)
+			offset := 0
+			if maintainCursor {
+				// Stay within the current window so that the cursor is maintained
+				offset = m.window.offset
+			}
+			if shouldSampleResults(m, query) {
+				rows, entries, numMatches, searchErr := getRowsFromSample(m.ctx, conf.DisplayedColumns, defaultFilter, query)
+				if searchErr != nil || numMatches > 0 {
+					return asyncQueryFinishedMsg{queryId, rows, entries, searchErr, forceUpdateTable, maintainCursor, nil, rowWindow{}, nil, numMatches}
+				}
 			}
-			rows, entries, searchErr := getRows(m.ctx, conf.DisplayedColumns, m.shellName, defaultFilter, query, PADDED_NUM_ENTRIES)
-			return asyncQueryFinishedMsg{queryId, rows, entries, searchErr, forceUpdateTable, maintainCursor, nil}
+			rows, entries, window, searchErr := getRowsWindow(m.ctx, conf.DisplayedColumns, m.shellName, defaultFilter, query, m.sortOrder, PADDED_NUM_ENTRIES, offset)
+			return asyncQueryFinishedMsg{queryId, rows, entries, searchErr, forceUpdateTable, maintainCursor, nil, window, nil, 0}
 		}
 	}
 	return nil
 }
 
+func getQueryDefaultFilter(m model) string {
+	defaultFilter := getDefaultFilter(m.ctx, m.defaultFilterIndex)
+	if m.queryInput.Prompt == "" {
+		// The default filter was cleared for this session, so don't apply it
+		defaultFilter = ""
+	}
+	if m.onlyCurrentSession {
+		defaultFilter += " session:current"
+	}
+	if m.searchScope != "" {
+		defaultFilter += " scope:" + m.searchScope
+	}
+	return defaultFilter
+}
+
+// Calculates whether the loaded window of search results needs to be shifted for the cursor to be able to keep
+// scrolling. Returns the offset of the new window, and the index of the currently selected entry within that window.
+func calculateWindowShift(window rowWindow, cursor int) (int, int, bool) {
+	if cursor < 0 || cursor >= len(window.resultIndexes) {
+		return 0, 0, false
+	}
+	// The index of the selected entry within all of the search results
+	selectedIndex := window.offset + window.resultIndexes[cursor]
+	// Note that the window is always shifted by at least a page, so that scrolling makes progress even if most of the
+	// window was filtered out as duplicates.
+	if window.hasMore && cursor >= len(window.resultIndexes)-TABLE_HEIGHT {
+		// Near the end of the window, so shift it forward such that there is a page of results before the cursor
+		newOffset := max(selectedIndex-TABLE_HEIGHT, window.offset+TABLE_HEIGHT)
+		return newOffset, selectedIndex - newOffset, true
+	}
+	if window.offset > 0 && cursor < TABLE_HEIGHT {
+		// Near the start of the window, so shift it backwards such that there is a page of results after the cursor
+		newOffset := max(0, min(selectedIndex-(PADDED_NUM_ENTRIES-TABLE_HEIGHT), window.offset-TABLE_HEIGHT))
+		return newOffset, selectedIndex - newOffset, true
+	}
+	return 0, 0, false
+}
+
+// In large history mode, only a window of search results is loaded into the table. This shifts the window if the
+// cursor is approaching either end of it.
+func shiftWindowIfNeeded(m model) tea.Cmd {
+	if !hctx.GetConf(m.ctx).LargeHistoryMode || m.table == nil || (m.runQuery != nil && *m.runQuery != m.lastQuery) {
+		return nil
+	}
+	newOffset, cursorResultIndex, shouldShift := calculateWindowShift(m.window, m.table.Cursor())
+	if !shouldShift {
+		return nil
+	}
+	query := m.lastQuery
+	LAST_DISPATCHED_QUERY_ID++
+	queryId := LAST_DISPATCHED_QUERY_ID
+	LAST_DISPATCHED_QUERY_TIMESTAMP = time.Now()
+	return func() tea.Msg {
+		rows, entries, window, searchErr := getRowsWindow(m.ctx, hctx.GetConf(m.ctx).DisplayedColumns, m.shellName, getQueryDefaultFilter(m), query, m.sortOrder, PADDED_NUM_ENTRIES, newOffset)
+		return asyncQueryFinishedMsg{queryId, rows, entries, searchErr, false, false, nil, window, &cursorResultIndex, 0}
+	}
+}
+
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.whatsNew != nil {
+			var consumed bool
+			if m, consumed = dismissWhatsNew(m, msg); consumed {
+				return m, nil
+			}
+		}
+		var isChordPrefix bool
+		if m, msg, isChordPrefix = handleChord(m, msg); isChordPrefix {
+			return m, nil
+		}
+		if m.palette != nil {
+			return updateCommandPalette(m, msg)
+		}
+		if m.aiChat != nil {
+			return updateAiChat(m, msg)
+		}
+		if m.tagPrompt != nil {
+			return updateTagPrompt(m, msg)
+		}
+		if m.snippetPrompt != nil {
+			return updateSnippetPrompt(m, msg)
+		}
+		if m.channelPrompt != nil {
+			return updateChannelPrompt(m, msg)
+		}
+		if m.snippetBrowser != nil {
+			return updateSnippetBrowser(m, msg)
+		}
+		if m.explanation != nil {
+			return updateExplanationPanel(m, msg)
+		}
+		if m.showHelpReference && msg.Type == tea.KeyEsc {
+			// Close the key binding reference rather than exiting
+			m.help.ShowAll = false
+			m.showHelpReference = false
+			return m, nil
+		}
 		switch {
 		case key.Matches(msg, loadedKeyBindings.Quit):
 			m.quitting = true
@@ -233,20 +484,48 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return m, tea.Quit
 		case key.Matches(msg, loadedKeyBindings.DeleteEntry):
-			if m.table == nil {
-				return m, nil
-			}
-			err := deleteHistoryEntry(m.ctx, *m.tableEntries[m.table.Cursor()])
-			if err != nil {
-				m.fatalErr = err
-				return m, nil
-			}
-			cmd := runQueryAndUpdateTable(m, true, true)
-			preventTableOverscrolling(m)
-			return m, cmd
+			return deleteSelectedEntry(m)
+		case key.Matches(msg, loadedKeyBindings.UndoDelete):
+			return undoDelete(m)
+		case key.Matches(msg, loadedKeyBindings.ToggleCurrentSession):
+			return toggleCurrentSession(m)
+		case key.Matches(msg, loadedKeyBindings.ClearQuery):
+			return clearQuery(m)
+		case key.Matches(msg, loadedKeyBindings.PreviousQuery):
+			return recallPreviousQuery(m)
+		case key.Matches(msg, loadedKeyBindings.NextQuery):
+			return recallNextQuery(m)
+		case key.Matches(msg, loadedKeyBindings.CycleSearchScope):
+			return cycleSearchScope(m)
+		case key.Matches(msg, loadedKeyBindings.CycleDefaultFilter):
+			return cycleDefaultFilter(m)
+		case key.Matches(msg, loadedKeyBindings.ToggleFavorite):
+			return toggleFavorite(m)
+		case key.Matches(msg, loadedKeyBindings.ToggleMark):
+			return toggleMark(m)
+		case key.Matches(msg, loadedKeyBindings.EditTags):
+			return openTagPrompt(m)
+		case key.Matches(msg, loadedKeyBindings.SaveSnippet):
+			return openSnippetPrompt(m)
+		case key.Matches(msg, loadedKeyBindings.BrowseSnippets):
+			return openSnippetBrowser(m)
+		case key.Matches(msg, loadedKeyBindings.PublishToChannel):
+			return openChannelPrompt(m)
+		case key.Matches(msg, loadedKeyBindings.ExplainCommand):
+			return openExplanationPanel(m)
+		case key.Matches(msg, loadedKeyBindings.CycleSortOrder):
+			return cycleSortOrder(m)
+		case key.Matches(msg, loadedKeyBindings.CycleRanker):
+			return cycleRanker(m)
 		case key.Matches(msg, loadedKeyBindings.Help):
-			m.help.ShowAll = !m.help.ShowAll
+			return toggleHelp(m)
+		case key.Matches(msg, loadedKeyBindings.OpenCommandPalette):
+			m.palette = newCommandPalette(m.queryInput.Width)
 			return m, nil
+		case key.Matches(msg, loadedKeyBindings.OpenAiChat):
+			return openAiChat(m)
+		case key.Matches(msg, loadedKeyBindings.ToggleSampling):
+			return toggleResultSampling(m)
 		case key.Matches(msg, loadedKeyBindings.JumpStartOfInput):
 			m.queryInput.SetCursor(0)
 			return m, nil
@@ -278,7 +557,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if m.table != nil {
 				t, cmd1 := m.table.Update(msg)
 				m.table = &t
-				if strings.HasPrefix(msg.String(), "alt+") {
+				if strings.HasPrefix(msg.String(), "alt+") || isCompletedChord(msg) {
 					return m, tea.Batch(cmd1)
 				}
 				pendingCommands = tea.Batch(pendingCommands, cmd1)
@@ -290,11 +569,18 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				forceUpdateTable = true
 			}
 			i, cmd2 := m.queryInput.Update(msg)
+			if i.Value() != m.queryInput.Value() {
+				// Editing a recalled query stops cycling through previous queries
+				m.queryHistory = nil
+			}
 			m.queryInput = i
 			searchQuery := m.queryInput.Value()
 			m.runQuery = &searchQuery
 			CURRENT_QUERY_FOR_HIGHLIGHTING = searchQuery
 			cmd3 := runQueryAndUpdateTable(m, forceUpdateTable, false)
+			if cmd3 == nil {
+				cmd3 = shiftWindowIfNeeded(m)
+			}
 			preventTableOverscrolling(m)
 			return m, tea.Batch(pendingCommands, cmd2, cmd3)
 		}
@@ -306,16 +592,38 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case offlineMsg:
 		m.isOffline = true
 		return m, nil
+	case clientTooOldMsg:
+		m.isClientTooOld = true
+		return m, nil
 	case bannerMsg:
 		m.banner = msg.banner
 		return m, nil
 	case doneDownloadingMsg:
 		m.isLoading = false
 		return m, nil
+	case configCheckMsg:
+		m, cmd := reloadConfigIfChanged(m)
+		return m, tea.Batch(cmd, scheduleConfigCheck())
+	case explanationMsg:
+		if m.explanation != nil && m.explanation.command == msg.command {
+			m.explanation.loading = false
+			m.explanation.explanation = msg.explanation
+			m.explanation.err = msg.err
+		}
+		return m, nil
+	case channelPublishedMsg:
+		return handleChannelPublished(m, msg), nil
 	case asyncQueryFinishedMsg:
 		if msg.queryId > LAST_PROCESSED_QUERY_ID {
 			LAST_PROCESSED_QUERY_ID = msg.queryId
 			m = updateTable(m, msg.rows, msg.entries, msg.searchErr, msg.forceUpdateTable, msg.maintainCursor)
+			if msg.searchErr == nil {
+				m.window = msg.window
+				m.numSampledMatches = msg.numSampledMatches
+				if msg.cursorResultIndex != nil && m.table != nil {
+					m.table.SetCursor(cursorForResultIndex(m.window, *msg.cursorResultIndex))
+				}
+			}
 			if msg.overriddenSearchQuery != nil {
 				m.queryInput.SetValue(*msg.overriddenSearchQuery)
 			}
@@ -337,6 +645,174 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 }
 
+func deleteSelectedEntry(m model) (model, tea.Cmd) {
+	if m.table == nil {
+		return m, nil
+	}
+	err := lib.TrashHistoryEntry(m.ctx, *m.tableEntries[m.table.Cursor()])
+	if err != nil {
+		m.fatalErr = err
+		return m, nil
+	}
+	m.notice = fmt.Sprintf("Moved the entry to the trash (%s to undo)", loadedKeyBindings.UndoDelete.Help().Key)
+	cmd := runQueryAndUpdateTable(m, true, true)
+	preventTableOverscrolling(m)
+	return m, cmd
+}
+
+// Restores the most recently deleted entry from the trash
+func undoDelete(m model) (model, tea.Cmd) {
+	restored, err := lib.RestoreMostRecentlyTrashedEntry(m.ctx)
+	if err != nil {
+		m.notice = fmt.Sprintf("Warning: failed to restore the deleted entry: %v", err)
+		return m, nil
+	}
+	if restored == nil {
+		m.notice = "There are no deleted entries to restore"
+		return m, nil
+	}
+	m.notice = "Restored the deleted entry"
+	cmd := runQueryAndUpdateTable(m, true, true)
+	return m, cmd
+}
+
+// Marks the highlighted entry as a favorite, or unmarks it if it already is one
+func toggleFavorite(m model) (model, tea.Cmd) {
+	if m.table == nil || len(m.tableEntries) == 0 {
+		return m, nil
+	}
+	entry := m.tableEntries[m.table.Cursor()]
+	updated, err := lib.SetFavorite(m.ctx, *entry, !entry.Favorite)
+	if err != nil {
+		m.notice = fmt.Sprintf("Warning: failed to update the entry: %v", err)
+		return m, nil
+	}
+	m.tableEntries[m.table.Cursor()] = updated
+	if updated.Favorite {
+		m.notice = "Marked the entry as a favorite"
+	} else {
+		m.notice = "Removed the entry from favorites"
+	}
+	cmd := runQueryAndUpdateTable(m, true, true)
+	return m, cmd
+}
+
+// Marks the highlighted entry to be saved as a shell script, or unmarks it if it is already marked
+func toggleMark(m model) (model, tea.Cmd) {
+	if m.table == nil || len(m.tableEntries) == 0 {
+		return m, nil
+	}
+	entry := m.tableEntries[m.table.Cursor()]
+	if entry.EntryId == "" {
+		// AI suggestions aren't history entries, and can be selected directly instead
+		m.notice = "Warning: only history entries can be marked"
+		return m, nil
+	}
+	if _, ok := MARKED_ENTRIES[entry.EntryId]; ok {
+		delete(MARKED_ENTRIES, entry.EntryId)
+	} else {
+		MARKED_ENTRIES[entry.EntryId] = entry
+	}
+	m.notice = fmt.Sprintf("%d entries marked (save them as a shell script via the command palette)", len(MARKED_ENTRIES))
+	// Rebuild the table so that marked rows are styled
+	cmd := runQueryAndUpdateTable(m, true, true)
+	return m, cmd
+}
+
+func toggleCurrentSession(m model) (model, tea.Cmd) {
+	m.onlyCurrentSession = !m.onlyCurrentSession
+	cmd := runQueryAndUpdateTable(m, true, false)
+	return m, cmd
+}
+
+func clearQuery(m model) (model, tea.Cmd) {
+	// Start over: Clear the query and reset all of the state that was modified in this TUI session
+	ai.CancelDebouncedAiSuggestions()
+	m.aiChat = nil
+	m.queryHistory = nil
+	m.queryInput.SetValue("")
+	m.defaultFilterIndex = 0
+	m.queryInput.Prompt = getDefaultFilterPrompt(m.ctx, 0)
+	m.onlyCurrentSession = false
+	m.sortOrder = lib.DefaultSearchOrder
+	m.searchScope = ""
+	emptyQuery := ""
+	m.runQuery = &emptyQuery
+	CURRENT_QUERY_FOR_HIGHLIGHTING = ""
+	if m.table != nil {
+		m.table.SetCursor(0)
+	}
+	cmd := runQueryAndUpdateTable(m, true, false)
+	return m, cmd
+}
+
+func cycleSearchScope(m model) (model, tea.Cmd) {
+	m.searchScope = nextSearchScope(m.searchScope)
+	cmd := runQueryAndUpdateTable(m, true, false)
+	return m, cmd
+}
+
+func cycleSortOrder(m model) (model, tea.Cmd) {
+	m.sortOrder = nextSortOrder(m.sortOrder)
+	cmd := runQueryAndUpdateTable(m, true, false)
+	return m, cmd
+}
+
+// Switches to the next ranking strategy for the rest of this TUI session. Note that this isn't persisted, `hishtory
+// config-set ranker` can be used for that.
+func cycleRanker(m model) (model, tea.Cmd) {
+	config := hctx.GetConf(m.ctx)
+	config.Ranker = lib.NextRanker(lib.GetConfiguredRanker(m.ctx)).Name()
+	cmd := runQueryAndUpdateTable(m, true, false)
+	return m, cmd
+}
+
+// Tracks the keys pressed for multi-key chords (see keybindings.IsChord). Returns the key press to handle, which is a
+// synthetic key press matching the chord's binding once a chord is completed, and whether the key press was consumed
+// as the start of a chord.
+func handleChord(m model, msg tea.KeyMsg) (model, tea.KeyMsg, bool) {
+	keys := append(append([]string{}, m.pendingChord...), msg.String())
+	chord, isPrefix := loadedKeyBindings.MatchChord(keys)
+	switch {
+	case chord != "":
+		m.pendingChord = nil
+		return m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(chord)}, false
+	case isPrefix:
+		m.pendingChord = keys
+		return m, msg, true
+	case len(m.pendingChord) > 0:
+		// The key press didn't continue the pending chord, so handle it on its own (which may start a new chord)
+		m.pendingChord = nil
+		return handleChord(m, msg)
+	default:
+		return m, msg, false
+	}
+}
+
+// Returns whether the given key press is the synthetic key press for a completed chord, which shouldn't be typed into
+// the search query
+func isCompletedChord(msg tea.KeyMsg) bool {
+	if msg.Type != tea.KeyRunes || !keybindings.IsChord(msg.String()) {
+		return false
+	}
+	chord, _ := loadedKeyBindings.MatchChord(keybindings.ChordKeys(msg.String()))
+	return chord == msg.String()
+}
+
+// Cycles between the collapsed help bar, the expanded help bar, and the full-screen key binding reference
+func toggleHelp(m model) (model, tea.Cmd) {
+	switch {
+	case !m.help.ShowAll:
+		m.help.ShowAll = true
+	case !m.showHelpReference:
+		m.showHelpReference = true
+	default:
+		m.help.ShowAll = false
+		m.showHelpReference = false
+	}
+	return m, nil
+}
+
 func calculateWordBoundaries(input string) []int {
 	ret := make([]int, 0)
 	ret = append(ret, 0)
@@ -359,7 +835,7 @@ func calculateWordBoundaries(input string) []int {
 
 func (m model) View() string {
 	if m.fatalErr != nil {
-		return fmt.Sprintf("An unrecoverable error occured: %v\n", m.fatalErr)
+		return renderError(m, fmt.Sprintf("An unrecoverable error occured: %v", m.fatalErr)) + "\n"
 	}
 	if m.selected == Selected || m.selected == SelectedWithChangeDir {
 		SELECTED_COMMAND = m.tableEntries[m.table.Cursor()].Command
@@ -374,7 +850,7 @@ func (m model) View() string {
 					changeDir = filepath.Join(homedir, strippedChangeDir)
 				}
 			}
-			SELECTED_COMMAND = "cd \"" + changeDir + "\" && " + SELECTED_COMMAND
+			SELECTED_COMMAND = buildChangeDirCommand(m.shellName, changeDir, SELECTED_COMMAND)
 		}
 		return ""
 	}
@@ -386,10 +862,27 @@ func (m model) View() string {
 		additionalMessages = append(additionalMessages, fmt.Sprintf("%s Loading hishtory entries from other devices...", m.spinner.View()))
 	}
 	if m.isOffline {
-		additionalMessages = append(additionalMessages, "Warning: failed to contact the hishtory backend (are you offline?), so some results may be stale")
+		additionalMessages = append(additionalMessages, renderWarning(m, "Warning: failed to contact the hishtory backend (are you offline?), so some results may be stale"))
+	}
+	if m.isClientTooOld {
+		additionalMessages = append(additionalMessages, renderWarning(m, fmt.Sprintf("Warning: hishtory v0.%s is no longer supported by the hishtory backend, so your history isn't syncing. Run `hishtory update` to upgrade.", lib.Version)))
+	}
+	if m.aiUnavailableErr != nil {
+		additionalMessages = append(additionalMessages, renderWarning(m, "Warning: "+m.aiUnavailableErr.Error()))
 	}
 	if m.searchErr != nil {
-		additionalMessages = append(additionalMessages, fmt.Sprintf("Warning: failed to search: %v", m.searchErr))
+		additionalMessages = append(additionalMessages, renderWarning(m, fmt.Sprintf("Warning: failed to search: %v", m.searchErr)))
+	}
+	if m.numSampledMatches > 0 {
+		additionalMessages = append(additionalMessages, fmt.Sprintf("Showing a time-stratified sample of %d of ~%s matches (%s to show all matches)", len(m.tableEntries), formatApproximateCount(m.numSampledMatches), loadedKeyBindings.ToggleSampling.Help().Key))
+	}
+	if history := describeAiSuggestionHistory(m); history != "" {
+		additionalMessages = append(additionalMessages, history)
+	}
+	if strings.HasPrefix(m.notice, "Warning:") {
+		additionalMessages = append(additionalMessages, renderWarning(m, m.notice))
+	} else if m.notice != "" {
+		additionalMessages = append(additionalMessages, m.notice)
 	}
 	if LAST_PROCESSED_QUERY_ID < LAST_DISPATCHED_QUERY_ID && time.Since(LAST_DISPATCHED_QUERY_TIMESTAMP) > time.Second {
 		additionalMessages = append(additionalMessages, fmt.Sprintf("%s Executing search query...", m.spinner.View()))
@@ -406,7 +899,83 @@ func (m model) View() string {
 	if isCompactHeightMode() {
 		additionalSpacing = ""
 	}
-	return fmt.Sprintf("%s%s%s%sSearch Query: %s\n%s%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, m.queryInput.View(), additionalSpacing, renderNullableTable(m, helpView)) + helpView
+	var queryQualifiers []string
+	if m.onlyCurrentSession {
+		queryQualifiers = append(queryQualifiers, "current session")
+	}
+	if m.searchScope != "" {
+		queryQualifiers = append(queryQualifiers, "only matching "+m.searchScope)
+	}
+	if m.sortOrder != lib.DefaultSearchOrder {
+		queryQualifiers = append(queryQualifiers, "sorted by "+m.sortOrder.Column+" "+sortDirectionIndicator(m.sortOrder))
+	} else if ranker := lib.GetConfiguredRanker(m.ctx); ranker.Name() != lib.RANKER_RECENCY {
+		queryQualifiers = append(queryQualifiers, "ranked by "+ranker.Name())
+	}
+	queryLabel := "Search Query"
+	if len(queryQualifiers) > 0 {
+		queryLabel += " (" + strings.Join(queryQualifiers, ", ") + ")"
+	}
+	if m.showHelpReference {
+		return fmt.Sprintf("%s%s%s%s%s: %s\n%s%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, queryLabel, m.queryInput.View(), additionalSpacing, renderHelpReference(m))
+	}
+	if m.whatsNew != nil {
+		return fmt.Sprintf("%s%s%s%s%s: %s\n%s%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, queryLabel, m.queryInput.View(), additionalSpacing, renderWhatsNew(m)) + helpView
+	}
+	if m.palette != nil {
+		return fmt.Sprintf("%s%s%s%s%s: %s\n%s%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, queryLabel, m.queryInput.View(), additionalSpacing, renderCommandPalette(m)) + helpView
+	}
+	if m.snippetBrowser != nil {
+		return fmt.Sprintf("%s%s%s%s%s: %s\n%s%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, queryLabel, m.queryInput.View(), additionalSpacing, renderSnippetBrowser(m)) + helpView
+	}
+	if m.snippetPrompt != nil {
+		return fmt.Sprintf("%s%s%s%s%s: %s\n%s%s\n%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, queryLabel, m.queryInput.View(), additionalSpacing, renderNullableTable(m, helpView), renderSnippetPrompt(m)) + helpView
+	}
+	if m.channelPrompt != nil {
+		return fmt.Sprintf("%s%s%s%s%s: %s\n%s%s\n%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, queryLabel, m.queryInput.View(), additionalSpacing, renderNullableTable(m, helpView), renderChannelPrompt(m)) + helpView
+	}
+	if m.tagPrompt != nil {
+		return fmt.Sprintf("%s%s%s%s%s: %s\n%s%s\n%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, queryLabel, m.queryInput.View(), additionalSpacing, renderNullableTable(m, helpView), renderTagPrompt(m)) + helpView
+	}
+	if m.explanation != nil {
+		return fmt.Sprintf("%s%s%s%s%s: %s\n%s%s\n%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, queryLabel, m.queryInput.View(), additionalSpacing, renderNullableTable(m, helpView), renderExplanationPanel(m)) + helpView
+	}
+	if m.aiChat != nil {
+		return fmt.Sprintf("%s%s%s%s%s: %s\n%s%s\n%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, queryLabel, m.queryInput.View(), additionalSpacing, renderNullableTable(m, helpView), renderAiChat(m)) + helpView
+	}
+	return fmt.Sprintf("%s%s%s%s%s: %s\n%s%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, queryLabel, m.queryInput.View(), additionalSpacing, renderNullableTable(m, helpView)) + helpView
+}
+
+// Returns a command that runs the given command in the given directory, in the syntax of the given shell
+func buildChangeDirCommand(shellName, dir, command string) string {
+	switch shellName {
+	case "pwsh":
+		// PowerShell expands variables in double-quoted strings, so use a single-quoted string (where quotes are
+		// escaped by doubling them)
+		return "cd '" + strings.ReplaceAll(dir, "'", "''") + "' && " + command
+	case "nu":
+		// Nushell doesn't support &&, and its single-quoted strings can't contain quotes so raw strings are used then
+		if strings.Contains(dir, "'") {
+			return "cd r#'" + dir + "'#; " + command
+		}
+		return "cd '" + dir + "'; " + command
+	}
+	return "cd \"" + dir + "\" && " + command
+}
+
+func renderWarning(m model, warning string) string {
+	color := hctx.GetConf(m.ctx).ColorScheme.WarningText
+	if color == "" {
+		return warning
+	}
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(color)).Render(warning)
+}
+
+func renderError(m model, errorMessage string) string {
+	color := hctx.GetConf(m.ctx).ColorScheme.ErrorText
+	if color == "" {
+		return errorMessage
+	}
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(color)).Render(errorMessage)
 }
 
 func isExtraCompactHeightMode() bool {
@@ -450,13 +1019,123 @@ func renderNullableTable(m model, helpText string) string {
 
 func getRowsFromAiSuggestions(ctx context.Context, columnNames []string, shellName, query string) ([]table.Row, []*data.HistoryEntry, error) {
 	suggestions, err := ai.DebouncedGetAiSuggestions(ctx, shellName, strings.TrimPrefix(query, "?"), 5)
+	var unavailableErr *ai.AiUnavailableError
+	if errors.As(err, &unavailableErr) {
+		return buildAiSuggestionRowsWhenUnavailable(ctx, columnNames, suggestions, unavailableErr)
+	}
 	if err != nil {
 		hctx.GetLogger().Infof("failed to get AI query suggestions: %v", err)
 		return nil, nil, fmt.Errorf("failed to get AI query suggestions: %w", err)
 	}
+	hctx.GetLogger().Infof("getRowsFromAiSuggestions(%#v) ==> %#v", query, suggestions)
+	return buildAiSuggestionRows(ctx, columnNames, suggestions)
+}
+
+// Gets the rows for the AI suggestions for query, which refines the given previous turns of an AI chat
+func getRowsFromAiChat(ctx context.Context, columnNames []string, shellName string, history []sharedai.AiChatTurn, query string) ([]table.Row, []*data.HistoryEntry, error) {
+	suggestions, err := ai.GetAiChatSuggestions(ctx, shellName, history, query, 5)
+	var unavailableErr *ai.AiUnavailableError
+	if errors.As(err, &unavailableErr) {
+		return buildAiSuggestionRowsWhenUnavailable(ctx, columnNames, suggestions, unavailableErr)
+	}
+	if err != nil {
+		hctx.GetLogger().Infof("failed to get AI chat suggestions: %v", err)
+		return nil, nil, fmt.Errorf("failed to get AI chat suggestions: %w", err)
+	}
+	hctx.GetLogger().Infof("getRowsFromAiChat(%#v, %#v) ==> %#v", history, query, suggestions)
+	return buildAiSuggestionRows(ctx, columnNames, suggestions)
+}
+
+// Builds the rows for the cached suggestions that are returned when the AI endpoint is unreachable. The error is
+// returned along with the rows so that updateTable displays them with a notice explaining why they may be stale.
+func buildAiSuggestionRowsWhenUnavailable(ctx context.Context, columnNames []string, suggestions []string, unavailableErr *ai.AiUnavailableError) ([]table.Row, []*data.HistoryEntry, error) {
+	hctx.GetLogger().Infof("AI endpoint is unreachable, falling back to cached suggestions=%#v: %v", suggestions, unavailableErr.Err)
+	rows, entries, err := buildAiSuggestionRows(ctx, columnNames, suggestions)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rows, entries, unavailableErr
+}
+
+// Records whether the AI suggestions displayed when the TUI exited were accepted (i.e. one was selected) or rejected, if
+// enabled via `hishtory config-set ai-feedback`
+func recordAiFeedback(m model) error {
+	if !lib.IsAiFeedbackEnabled(m.ctx) || m.table == nil || len(m.tableEntries) == 0 || (m.aiChat == nil && !isAiQuery(m)) {
+		return nil
+	}
+	query := strings.TrimSpace(strings.TrimPrefix(m.lastQuery, "?"))
+	if m.aiChat != nil {
+		query = m.aiChat.query
+	}
+	suggestions := make([]string, 0, len(m.tableEntries))
+	for _, entry := range m.tableEntries {
+		suggestions = append(suggestions, entry.Command)
+	}
+	accepted := ""
+	if m.selected != NotSelected {
+		accepted = m.tableEntries[m.table.Cursor()].Command
+	}
+	provider := hctx.GetConf(m.ctx).AiProvider
+	if provider == "" {
+		provider = sharedai.ProviderOpenAi
+	}
+	return lib.RecordAiFeedback(m.ctx, provider, query, suggestions, accepted)
+}
+
+// Sorts suggestions that were previously accepted above ones that were previously rejected, if AI feedback is enabled.
+// Otherwise, suggestions keep the order that the AI returned them in.
+func rankAiSuggestions(ctx context.Context, suggestions []string) []string {
+	if !lib.IsAiFeedbackEnabled(ctx) || len(suggestions) == 0 {
+		return suggestions
+	}
+	scores, err := lib.GetAiSuggestionScores(ctx, suggestions)
+	if err != nil {
+		hctx.GetLogger().Infof("failed to rank AI suggestions: %v", err)
+		return suggestions
+	}
+	ranked := append([]string{}, suggestions...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i]] > scores[ranked[j]]
+	})
+	return ranked
+}
+
+// The stats for each AI suggestion that has effectively been run before, so that they can be displayed when the
+// suggestion is selected (see describeAiSuggestionHistory)
+var (
+	aiSuggestionHistoryLock sync.Mutex
+	aiSuggestionHistory     = make(map[string]lib.CommandHistoryStats)
+)
+
+// Describes how often the selected AI suggestion has effectively been run before, or returns an empty string if it
+// hasn't been or AI suggestions aren't being displayed
+func describeAiSuggestionHistory(m model) string {
+	if m.table == nil || len(m.tableEntries) == 0 || (m.aiChat == nil && !isAiQuery(m)) {
+		return ""
+	}
+	cursor := m.table.Cursor()
+	if cursor < 0 || cursor >= len(m.tableEntries) {
+		return ""
+	}
+	aiSuggestionHistoryLock.Lock()
+	stats, ok := aiSuggestionHistory[m.tableEntries[cursor].Command]
+	aiSuggestionHistoryLock.Unlock()
+	if !ok || stats.LastRun == nil {
+		return ""
+	}
+	return fmt.Sprintf("You've run this command %d times (%d%% succeeded), most recently at %s", stats.NumRuns, 100*stats.NumSuccessful/stats.NumRuns, stats.LastRun.StartTime.Local().Format(hctx.GetConf(m.ctx).TimestampFormat))
+}
+
+func buildAiSuggestionRows(ctx context.Context, columnNames []string, suggestions []string) ([]table.Row, []*data.HistoryEntry, error) {
+	suggestions = rankAiSuggestions(ctx, suggestions)
 	var rows []table.Row
 	var entries []*data.HistoryEntry
+	seenSuggestions := make(map[string]bool)
 	for _, suggestion := range suggestions {
+		if seenSuggestions[lib.NormalizeCommand(suggestion)] {
+			continue
+		}
+		seenSuggestions[lib.NormalizeCommand(suggestion)] = true
 		entry := data.HistoryEntry{
 			LocalUsername:           "OpenAI",
 			Hostname:                "OpenAI",
@@ -469,6 +1148,22 @@ func getRowsFromAiSuggestions(ctx context.Context, columnNames []string, shellNa
 			DeviceId:                "OpenAI",
 			EntryId:                 "OpenAI",
 		}
+		// If this suggestion has effectively been run before, merge in the metadata from the most recent run
+		stats, err := lib.GetCommandHistoryStats(ctx, suggestion)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stats.LastRun != nil {
+			entry = *stats.LastRun
+			entry.Command = suggestion
+			// Keep the synthetic IDs so that this row can't be used to delete the underlying history entry
+			entry.DeviceId = "OpenAI"
+			entry.EntryId = "OpenAI"
+			entry.Hostname = "OpenAI"
+			aiSuggestionHistoryLock.Lock()
+			aiSuggestionHistory[suggestion] = stats
+			aiSuggestionHistoryLock.Unlock()
+		}
 		entries = append(entries, &entry)
 		row, err := lib.BuildTableRow(ctx, columnNames, entry, func(s string) string { return s })
 		if err != nil {
@@ -476,47 +1171,117 @@ func getRowsFromAiSuggestions(ctx context.Context, columnNames []string, shellNa
 		}
 		rows = append(rows, row)
 	}
-	hctx.GetLogger().Infof("getRowsFromAiSuggestions(%#v) ==> %#v", query, suggestions)
 	return rows, entries, nil
 }
 
-func getRows(ctx context.Context, columnNames []string, shellName, defaultFilter, query string, numEntries int) ([]table.Row, []*data.HistoryEntry, error) {
+func getRows(ctx context.Context, columnNames []string, shellName, defaultFilter, query string, sortOrder lib.SearchOrder, numEntries int) ([]table.Row, []*data.HistoryEntry, error) {
+	rows, entries, _, err := getRowsWindow(ctx, columnNames, shellName, defaultFilter, query, sortOrder, numEntries, 0)
+	return rows, entries, err
+}
+
+// Get the rows for the window of numEntries search results starting at offset
+func getRowsWindow(ctx context.Context, columnNames []string, shellName, defaultFilter, query string, sortOrder lib.SearchOrder, numEntries, offset int) ([]table.Row, []*data.HistoryEntry, rowWindow, error) {
 	db := hctx.GetDb(ctx)
 	config := hctx.GetConf(ctx)
-	if config.AiCompletion && !config.IsOffline && strings.HasPrefix(query, "?") && len(query) > 1 {
-		return getRowsFromAiSuggestions(ctx, columnNames, shellName, query)
+	if isAiSuggestionQuery(ctx, query) {
+		rows, entries, err := getRowsFromAiSuggestions(ctx, columnNames, shellName, query)
+		return rows, entries, rowWindow{}, err
 	}
-	searchResults, err := lib.Search(ctx, db, defaultFilter+" "+query, numEntries)
+	searchResults, err := lib.SearchWindow(ctx, db, defaultFilter+" "+query, numEntries, offset, sortOrder)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, rowWindow{}, err
 	}
 	var rows []table.Row
 	var filteredData []*data.HistoryEntry
 	var seenCommands = make(map[string]bool)
+	window := rowWindow{offset: offset, hasMore: len(searchResults) == numEntries}
 
 	for i := 0; i < numEntries; i++ {
 		if i < len(searchResults) {
 			entry := searchResults[i]
 
 			if config.FilterDuplicateCommands && entry != nil {
-				cmd := strings.TrimSpace(entry.Command)
+				cmd := lib.DuplicateCommandKey(ctx, entry.Command)
 				if seenCommands[cmd] {
 					continue
 				}
 				seenCommands[cmd] = true
 			}
 
-			row, err := lib.BuildTableRow(ctx, columnNames, *entry, commandEscaper)
+			row, err := lib.BuildTableRow(ctx, columnNames, *entry, getCommandEscaper(ctx))
 			if err != nil {
-				return nil, nil, fmt.Errorf("failed to build row for entry=%#v: %w", entry, err)
+				return nil, nil, rowWindow{}, fmt.Errorf("failed to build row for entry=%#v: %w", entry, err)
 			}
 			rows = append(rows, row)
 			filteredData = append(filteredData, entry)
+			window.resultIndexes = append(window.resultIndexes, i)
 		} else {
 			rows = append(rows, table.Row{})
 		}
 	}
-	return rows, filteredData, nil
+	return rows, filteredData, window, nil
+}
+
+// Whether the results for the given query should be sampled rather than loading the top results. Sampling only applies
+// to the default sort order since the sample is ordered by time.
+func shouldSampleResults(m model, query string) bool {
+	config := hctx.GetConf(m.ctx)
+	return config.ResultSampling && m.sortOrder == lib.DefaultSearchOrder && !isAiSuggestionQuery(m.ctx, query)
+}
+
+// Whether the given query should be answered with AI suggestions rather than by searching the history, which requires
+// that AI completion is enabled and hasn't been disabled via the remote config
+func isAiSuggestionQuery(ctx context.Context, query string) bool {
+	config := hctx.GetConf(ctx)
+	return config.AiCompletion && !config.IsOffline && strings.HasPrefix(query, "?") && len(query) > 1 && lib.IsFeatureEnabled(ctx, shared.FeatureAiSuggestions)
+}
+
+// Get the rows for a sample of the search results if the query matches too many entries to page through, along with the
+// total number of matches. Returns zero matches if the query should instead be displayed normally.
+func getRowsFromSample(ctx context.Context, columnNames []string, defaultFilter, query string) ([]table.Row, []*data.HistoryEntry, int64, error) {
+	sample, err := lib.SampleSearch(ctx, hctx.GetDb(ctx), defaultFilter+" "+query, lib.SEARCH_SAMPLE_SIZE, lib.SEARCH_SAMPLING_THRESHOLD)
+	if err != nil || sample == nil {
+		return nil, nil, 0, err
+	}
+	var rows []table.Row
+	for _, entry := range sample.Entries {
+		row, err := lib.BuildTableRow(ctx, columnNames, *entry, getCommandEscaper(ctx))
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("failed to build row for entry=%#v: %w", entry, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, sample.Entries, sample.NumMatches, nil
+}
+
+// Formats a count with two significant figures and thousands separators (e.g. 48,213 as "48,000"), since the exact
+// number of matches isn't meaningful when sampling
+func formatApproximateCount(n int64) string {
+	rounding := int64(1)
+	for n/rounding >= 100 {
+		rounding *= 10
+	}
+	n = (n + rounding/2) / rounding * rounding
+	digits := strconv.FormatInt(n, 10)
+	var sb strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteRune(d)
+	}
+	return sb.String()
+}
+
+// The cursor position for the entry at the given index within the window's search results. If that entry was
+// filtered out as a duplicate, this is the position of the next entry.
+func cursorForResultIndex(window rowWindow, resultIndex int) int {
+	for cursor, idx := range window.resultIndexes {
+		if idx >= resultIndex {
+			return cursor
+		}
+	}
+	return max(0, len(window.resultIndexes)-1)
 }
 
 func commandEscaper(cmd string) string {
@@ -527,18 +1292,47 @@ func commandEscaper(cmd string) string {
 	return fmt.Sprintf("%#v", cmd)
 }
 
+// Prepares a command for being displayed across multiple lines of the table, by only escaping the characters that
+// can't be displayed within a line
+func multiLineCommandEscaper(cmd string) string {
+	cmd = strings.TrimRight(strings.ReplaceAll(cmd, "\r\n", "\n"), "\n")
+	lines := strings.Split(cmd, "\n")
+	for i, line := range lines {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		if strings.ContainsFunc(line, func(r rune) bool { return !unicode.IsPrint(r) }) {
+			// Escape any remaining control characters, without the surrounding quotes
+			quoted := fmt.Sprintf("%#v", line)
+			line = quoted[1 : len(quoted)-1]
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the function used to escape commands for displaying them in the table
+func getCommandEscaper(ctx context.Context) func(string) string {
+	if hctx.GetConf(ctx).MultiLineCommands {
+		return multiLineCommandEscaper
+	}
+	return commandEscaper
+}
+
 func calculateColumnWidths(rows []table.Row, numColumns int) []int {
 	neededColumnWidth := make([]int, numColumns)
 	for _, row := range rows {
 		for i, v := range row {
-			neededColumnWidth[i] = max(neededColumnWidth[i], len(v))
+			// Multi-line values only need to be as wide as their longest line
+			for _, line := range strings.Split(v, "\n") {
+				neededColumnWidth[i] = max(neededColumnWidth[i], len(line))
+			}
 		}
 	}
 	return neededColumnWidth
 }
 
 func getTerminalSize() (int, int, error) {
-	return term.GetSize(2)
+	// Use the file descriptor of stderr (rather than the constant 2) since on Windows this is a console handle
+	return term.GetSize(int(os.Stderr.Fd()))
 }
 
 var bigQueryResults []table.Row
@@ -546,7 +1340,7 @@ var bigQueryResults []table.Row
 func makeTableColumns(ctx context.Context, shellName string, columnNames []string, rows []table.Row) ([]table.Column, error) {
 	// Handle an initial query with no results
 	if len(rows) == 0 || len(rows[0]) == 0 {
-		allRows, _, err := getRows(ctx, columnNames, shellName, hctx.GetConf(ctx).DefaultFilter, "", 25)
+		allRows, _, err := getRows(ctx, columnNames, shellName, getDefaultFilter(ctx, 0), "", lib.DefaultSearchOrder, 25)
 		if err != nil {
 			return nil, err
 		}
@@ -572,7 +1366,7 @@ func makeTableColumns(ctx context.Context, shellName string, columnNames []strin
 
 	// Calculate the maximum column width that is useful for each column if we search for the empty string
 	if bigQueryResults == nil {
-		bigRows, _, err := getRows(ctx, columnNames, shellName, "", "", 1000)
+		bigRows, _, err := getRows(ctx, columnNames, shellName, "", "", lib.DefaultSearchOrder, 1000)
 		if err != nil {
 			return nil, err
 		}
@@ -633,12 +1427,82 @@ func min(a, b int) int {
 	return b
 }
 
-func makeTable(ctx context.Context, shellName string, rows []table.Row) (table.Model, error) {
+// The orders that the sort order keybinding cycles through
+var SORT_ORDERS = []lib.SearchOrder{
+	{Column: lib.SORT_BY_TIME},
+	{Column: lib.SORT_BY_TIME, Ascending: true},
+	{Column: lib.SORT_BY_RUNTIME},
+	{Column: lib.SORT_BY_RUNTIME, Ascending: true},
+	{Column: lib.SORT_BY_EXIT_CODE},
+	{Column: lib.SORT_BY_EXIT_CODE, Ascending: true},
+	{Column: lib.SORT_BY_COMMAND, Ascending: true},
+	{Column: lib.SORT_BY_COMMAND},
+}
+
+// The search scopes that the search scope keybinding cycles through
+var SEARCH_SCOPES = []string{"", "command", "cwd"}
+
+func nextSearchScope(current string) string {
+	for i, scope := range SEARCH_SCOPES {
+		if scope == current {
+			return SEARCH_SCOPES[(i+1)%len(SEARCH_SCOPES)]
+		}
+	}
+	return ""
+}
+
+func nextSortOrder(current lib.SearchOrder) lib.SearchOrder {
+	for i, order := range SORT_ORDERS {
+		if order == current {
+			return SORT_ORDERS[(i+1)%len(SORT_ORDERS)]
+		}
+	}
+	return lib.DefaultSearchOrder
+}
+
+func sortDirectionIndicator(order lib.SearchOrder) string {
+	if order.Ascending {
+		return "▲"
+	}
+	return "▼"
+}
+
+// Returns the sort column (one of the lib.SORT_BY_* constants) that corresponds to the given displayed column, if any
+func getSortColumnForHeader(header string) string {
+	switch header {
+	case "Timestamp", "timestamp":
+		return lib.SORT_BY_TIME
+	case "Runtime", "runtime":
+		return lib.SORT_BY_RUNTIME
+	case "Exit Code", "Exit_Code", "ExitCode", "exitcode":
+		return lib.SORT_BY_EXIT_CODE
+	case "Command", "command":
+		return lib.SORT_BY_COMMAND
+	default:
+		return ""
+	}
+}
+
+// Indicates which column the results are sorted by, unless they're in the default order
+func markSortColumn(columns []table.Column, sortOrder lib.SearchOrder) {
+	if sortOrder == lib.DefaultSearchOrder {
+		return
+	}
+	for i, column := range columns {
+		if getSortColumnForHeader(column.Title) == sortOrder.Column {
+			columns[i].Title = column.Title + " " + sortDirectionIndicator(sortOrder)
+			columns[i].Width = max(column.Width, len([]rune(columns[i].Title)))
+		}
+	}
+}
+
+func makeTable(ctx context.Context, shellName string, rows []table.Row, sortOrder lib.SearchOrder) (table.Model, error) {
 	config := hctx.GetConf(ctx)
 	columns, err := makeTableColumns(ctx, shellName, config.DisplayedColumns, rows)
 	if err != nil {
 		return table.Model{}, err
 	}
+	markSortColumn(columns, sortOrder)
 	km := table.KeyMap{
 		LineUp:   loadedKeyBindings.Up,
 		LineDown: loadedKeyBindings.Down,
@@ -652,8 +1516,10 @@ func makeTable(ctx context.Context, shellName string, rows []table.Row) (table.M
 			key.WithKeys("end"),
 			key.WithHelp("end", "go to end"),
 		),
-		MoveLeft:  loadedKeyBindings.TableLeft,
-		MoveRight: loadedKeyBindings.TableRight,
+		MoveLeft:            loadedKeyBindings.TableLeft,
+		MoveRight:           loadedKeyBindings.TableRight,
+		ScrollSelectedLeft:  loadedKeyBindings.ScrollSelectedLeft,
+		ScrollSelectedRight: loadedKeyBindings.ScrollSelectedRight,
 	}
 	_, terminalHeight, err := getTerminalSize()
 	if err != nil {
@@ -667,13 +1533,17 @@ func makeTable(ctx context.Context, shellName string, rows []table.Row) (table.M
 		tuiSize -= 3
 	}
 	tableHeight := min(TABLE_HEIGHT, terminalHeight-tuiSize)
-	t := table.New(
+	opts := []table.Option{
 		table.WithColumns(columns),
 		table.WithRows(rows),
 		table.WithFocused(true),
 		table.WithHeight(tableHeight),
 		table.WithKeyMap(km),
-	)
+	}
+	if config.MultiLineCommands {
+		opts = append(opts, table.WithMaxRowLines(MAX_LINES_PER_ROW))
+	}
+	t := table.New(opts...)
 
 	s := table.DefaultStyles()
 	s.Header = s.Header.
@@ -681,10 +1551,32 @@ func makeTable(ctx context.Context, shellName string, rows []table.Row) (table.M
 		BorderForeground(lipgloss.Color(config.ColorScheme.BorderColor)).
 		BorderBottom(true).
 		Bold(false)
+	if config.ColorScheme.HeaderText != "" {
+		s.Header = s.Header.Foreground(lipgloss.Color(config.ColorScheme.HeaderText))
+	}
 	s.Selected = s.Selected.
 		Foreground(lipgloss.Color(config.ColorScheme.SelectedText)).
 		Background(lipgloss.Color(config.ColorScheme.SelectedBackground)).
 		Bold(false)
+	getRowStyle := func(rowID int) lipgloss.Style {
+		return lipgloss.NewStyle()
+	}
+	if len(config.DimmingThresholdDays) > 0 || len(MARKED_ENTRIES) > 0 {
+		getRowStyle = func(rowID int) lipgloss.Style {
+			if rowID >= len(CURRENT_TABLE_ENTRIES) {
+				return lipgloss.NewStyle()
+			}
+			entry := CURRENT_TABLE_ENTRIES[rowID]
+			style := getAgeDimmingStyle(config.DimmingThresholdDays, entry, time.Now())
+			if entry != nil && MARKED_ENTRIES[entry.EntryId] != nil {
+				style = style.Bold(true).Underline(true)
+			}
+			return style
+		}
+		s.RowStyle = func(model table.Model, rowID int) lipgloss.Style {
+			return getRowStyle(rowID)
+		}
+	}
 	if config.HighlightMatches {
 		MATCH_NOTHING_REGEXP := regexp.MustCompile("a^")
 		s.RenderCell = func(model table.Model, value string, position table.CellPosition) string {
@@ -710,7 +1602,7 @@ func makeTable(ctx context.Context, shellName string, rows []table.Row) (table.M
 			// thus needs to be highlighted). `isLeftMost` and `isRightMost` determines whether additional
 			// padding is added (to reproduce the padding that `s.Cell` normally adds).
 			renderChunk := func(v string, isMatching, isLeftMost, isRightMost bool) string {
-				chunkStyle := lipgloss.NewStyle()
+				chunkStyle := getRowStyle(position.RowID)
 				if position.IsRowSelected {
 					// Apply the selected style as the base style if this is the highlighted row of the table
 					chunkStyle = s.Selected.Copy()
@@ -722,12 +1614,12 @@ func makeTable(ctx context.Context, shellName string, rows []table.Row) (table.M
 					chunkStyle = chunkStyle.PaddingRight(1)
 				}
 				if isMatching {
-					chunkStyle = chunkStyle.Bold(true)
+					chunkStyle = matchHighlightStyle(chunkStyle, config.ColorScheme)
 				}
 				return chunkStyle.Render(v)
 			}
 
-			matches := re.FindAllStringIndex(value, -1)
+			matches := findVisibleMatches(re, value, position)
 			if len(matches) == 0 {
 				// No matches, so render the entire value
 				return renderChunk(value /*isMatching = */, false /*isLeftMost = */, true /*isRightMost = */, true)
@@ -736,7 +1628,7 @@ func makeTable(ctx context.Context, shellName string, rows []table.Row) (table.M
 			// Iterate through the chunks of the value and highlight the relevant pieces
 			ret := ""
 			lastIncludedIdx := 0
-			for _, match := range re.FindAllStringIndex(value, -1) {
+			for _, match := range matches {
 				matchStartIdx := match[0]
 				matchEndIdx := match[1]
 				beforeMatch := value[lastIncludedIdx:matchStartIdx]
@@ -760,27 +1652,47 @@ func makeTable(ctx context.Context, shellName string, rows []table.Row) (table.M
 	return t, nil
 }
 
-func deleteHistoryEntry(ctx context.Context, entry data.HistoryEntry) error {
-	db := hctx.GetDb(ctx)
-	// Delete locally
-	r := db.Model(&data.HistoryEntry{}).Where("device_id = ? AND end_time = ?", entry.DeviceId, entry.EndTime).Delete(&data.HistoryEntry{})
-	if r.Error != nil {
-		return r.Error
+// Returns the indexes of the parts of the rendered cell value that match re. Matches are found in the full value of
+// the cell and then mapped onto the part of it that is visible, so that matches that are partially scrolled or
+// truncated out of view are still highlighted (and text that only matches due to the ellipses isn't).
+func findVisibleMatches(re *regexp.Regexp, value string, position table.CellPosition) [][]int {
+	if position.Value == "" {
+		return re.FindAllStringIndex(value, -1)
+	}
+	prefixLen := 0
+	if position.VisibleStart > 0 {
+		prefixLen = len(table.ELLIPSIS)
 	}
+	matches := make([][]int, 0)
+	for _, match := range re.FindAllStringIndex(position.Value, -1) {
+		start := max(match[0], position.VisibleStart)
+		end := min(match[1], position.VisibleEnd)
+		if start >= end {
+			continue
+		}
+		matches = append(matches, []int{start - position.VisibleStart + prefixLen, end - position.VisibleStart + prefixLen})
+	}
+	return matches
+}
 
-	// Delete remotely
-	config := hctx.GetConf(ctx)
-	if config.IsOffline {
-		return nil
+// Get the style for an entry based on how old it is, so that older entries are displayed in progressively dimmer colors
+func getAgeDimmingStyle(thresholdDays []int, entry *data.HistoryEntry, now time.Time) lipgloss.Style {
+	style := lipgloss.NewStyle()
+	if entry == nil || entry.StartTime.UnixMilli() == 0 {
+		// No timestamp (e.g. an AI suggestion), so nothing to dim
+		return style
 	}
-	dr := shared.DeletionRequest{
-		UserId:   data.UserId(hctx.GetConf(ctx).UserSecret),
-		SendTime: time.Now(),
+	age := now.Sub(entry.StartTime)
+	dimmingLevel := 0
+	for _, days := range thresholdDays {
+		if age > time.Duration(days)*24*time.Hour {
+			dimmingLevel += 1
+		}
+	}
+	if dimmingLevel == 0 {
+		return style
 	}
-	dr.Messages.Ids = append(dr.Messages.Ids,
-		shared.MessageIdentifier{DeviceId: entry.DeviceId, EndTime: entry.EndTime, EntryId: entry.EntryId},
-	)
-	return lib.SendDeletionRequest(ctx, dr)
+	return style.Foreground(lipgloss.Color(AGE_DIMMING_COLORS[min(dimmingLevel, len(AGE_DIMMING_COLORS))-1]))
 }
 
 func configureColorProfile(ctx context.Context) {
@@ -852,48 +1764,75 @@ func TuiQuery(ctx context.Context, shellName, initialQuery string) error {
 		queryId := LAST_DISPATCHED_QUERY_ID
 		LAST_DISPATCHED_QUERY_TIMESTAMP = time.Now()
 		conf := hctx.GetConf(ctx)
-		rows, entries, err := getRows(ctx, conf.DisplayedColumns, shellName, conf.DefaultFilter, initialQuery, PADDED_NUM_ENTRIES)
+		rows, entries, window, err := getRowsWindow(ctx, conf.DisplayedColumns, shellName, getDefaultFilter(ctx, 0), initialQuery, lib.DefaultSearchOrder, PADDED_NUM_ENTRIES, 0)
 		if err == nil || initialQuery == "" {
-			p.Send(asyncQueryFinishedMsg{queryId: queryId, rows: rows, entries: entries, searchErr: err, forceUpdateTable: true, maintainCursor: false, overriddenSearchQuery: nil})
+			p.Send(asyncQueryFinishedMsg{queryId: queryId, rows: rows, entries: entries, searchErr: err, forceUpdateTable: true, maintainCursor: false, overriddenSearchQuery: nil, window: window})
 		} else {
 			// initialQuery is likely invalid in some way, let's just drop it
 			emptyQuery := ""
-			rows, entries, err := getRows(ctx, hctx.GetConf(ctx).DisplayedColumns, shellName, conf.DefaultFilter, emptyQuery, PADDED_NUM_ENTRIES)
-			p.Send(asyncQueryFinishedMsg{queryId: queryId, rows: rows, entries: entries, searchErr: err, forceUpdateTable: true, maintainCursor: false, overriddenSearchQuery: &emptyQuery})
+			rows, entries, window, err := getRowsWindow(ctx, hctx.GetConf(ctx).DisplayedColumns, shellName, getDefaultFilter(ctx, 0), emptyQuery, lib.DefaultSearchOrder, PADDED_NUM_ENTRIES, 0)
+			p.Send(asyncQueryFinishedMsg{queryId: queryId, rows: rows, entries: entries, searchErr: err, forceUpdateTable: true, maintainCursor: false, overriddenSearchQuery: &emptyQuery, window: window})
 		}
 	}()
-	// Async: Retrieve additional entries from the backend
+	// Async: Retrieve additional entries from the backend and process deletion requests, unless the daemon is already
+	// keeping the local DB in sync
+	daemonIsRunning := lib.IsDaemonRunning(ctx)
 	go func() {
-		err := lib.RetrieveAdditionalEntriesFromRemote(ctx, "tui")
-		if err != nil {
-			p.Send(err)
+		if !daemonIsRunning {
+			err := lib.RetrieveAdditionalEntriesFromRemote(ctx, "tui")
+			if lib.IsClientTooOldError(err) {
+				p.Send(clientTooOldMsg{})
+			} else if err != nil {
+				p.Send(err)
+			}
 		}
 		p.Send(doneDownloadingMsg{})
 	}()
-	// Async: Process deletion requests
 	go func() {
+		if daemonIsRunning {
+			return
+		}
 		err := lib.ProcessDeletionRequests(ctx)
-		if err != nil {
+		if lib.IsClientTooOldError(err) {
+			p.Send(clientTooOldMsg{})
+		} else if err != nil {
 			p.Send(err)
 		}
 	}()
-	// Async: Check for any banner from the server
+	// Async: Check for any banner from the server, and refresh the cached feature flags
 	go func() {
-		banner, err := lib.GetBanner(ctx)
+		remoteConfig, err := lib.FetchRemoteConfig(ctx)
 		if err != nil {
 			if lib.IsOfflineError(ctx, err) {
 				p.Send(offlineMsg{})
 			} else {
 				p.Send(err)
 			}
+			return
+		}
+		if !remoteConfig.IsClientVersionSupported("v0." + lib.Version) {
+			// The backend's banner would also ask the user to upgrade, so only display the structured warning
+			p.Send(clientTooOldMsg{})
+			return
 		}
-		p.Send(bannerMsg{banner: string(banner)})
+		p.Send(bannerMsg{banner: remoteConfig.Banner})
 	}()
 	// Blocking: Start the TUI
-	_, err := p.Run()
+	finalModel, err := p.Run()
 	if err != nil {
 		return err
 	}
+	if fm, ok := finalModel.(model); ok && fm.selected != NotSelected {
+		// Remember the query so that it can be recalled in later TUI sessions
+		if err := lib.RecordSearchQuery(ctx, fm.queryInput.Value()); err != nil {
+			hctx.GetLogger().Infof("failed to record the search query: %v", err)
+		}
+	}
+	if fm, ok := finalModel.(model); ok {
+		if err := recordAiFeedback(fm); err != nil {
+			hctx.GetLogger().Infof("failed to record AI feedback: %v", err)
+		}
+	}
 	if SELECTED_COMMAND == "" && os.Getenv("HISHTORY_TERM_INTEGRATION") != "" {
 		// Print out the initialQuery instead so that we don't clear the terminal
 		SELECTED_COMMAND = initialQuery
@@ -903,4 +1842,3 @@ func TuiQuery(ctx context.Context, shellName, initialQuery string) error {
 }
 
 // TODO: support custom key bindings
-// TODO: make the help page wrap
@@ -1,8 +1,22 @@
 package tui
 
 import (
+	"os"
+	"path"
+	"regexp"
 	"testing"
+	"time"
 
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/client/table"
+	"github.com/ddworken/hishtory/client/tui/keybindings"
+	sharedai "github.com/ddworken/hishtory/shared/ai"
+	"github.com/ddworken/hishtory/shared/testutils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -14,3 +28,449 @@ func TestCalculateWordBoundaries(t *testing.T) {
 	require.Equal(t, []int{0, 3, 10, 16}, calculateWordBoundaries("foo-- -bar - baz"))
 	require.Equal(t, []int{0, 3}, calculateWordBoundaries("foo    "))
 }
+
+func TestFilterPaletteActions(t *testing.T) {
+	names := func(actions []paletteAction) []string {
+		ret := make([]string, 0)
+		for _, action := range actions {
+			ret = append(ret, action.name)
+		}
+		return ret
+	}
+
+	// All actions are listed when there is no query
+	require.Equal(t, names(PALETTE_ACTIONS), names(filterPaletteActions(PALETTE_ACTIONS, "")))
+
+	// Queries are fuzzily matched
+	require.Equal(t, []string{"Cycle sort order"}, names(filterPaletteActions(PALETTE_ACTIONS, "sort")))
+	require.Equal(t, []string{"Toggle duplicate filtering"}, names(filterPaletteActions(PALETTE_ACTIONS, "dup")))
+	require.Equal(t, []string{"Export results to a file"}, names(filterPaletteActions(PALETTE_ACTIONS, "EXP RES")))
+	require.Empty(t, filterPaletteActions(PALETTE_ACTIONS, "zzz"))
+
+	// And better matches are ranked first
+	matches := names(filterPaletteActions(PALETTE_ACTIONS, "to"))
+	require.Equal(t, []string{"Toggle current session filter", "Toggle favorite on the highlighted entry", "Toggle mark on the highlighted entry", "Toggle help", "Toggle result sampling", "Toggle duplicate filtering", "Cycle sort order", "Recall the previous search query", "Undo the last deletion", "Explain the highlighted command with AI", "Refine AI suggestions in a chat", "Export results to a file"}, matches)
+}
+
+func TestFilterSnippets(t *testing.T) {
+	snippets := []*data.Snippet{
+		{Name: "deploy", Command: "kubectl apply -f prod.yaml"},
+		{Name: "logs", Command: "kubectl logs -f deployment/web"},
+		{Name: "serve", Command: "python3 -m http.server"},
+	}
+	names := func(snippets []*data.Snippet) []string {
+		ret := make([]string, 0)
+		for _, s := range snippets {
+			ret = append(ret, s.Name)
+		}
+		return ret
+	}
+	require.Equal(t, []string{"deploy", "logs", "serve"}, names(filterSnippets(snippets, "")))
+	// Both the name and the command are matched against
+	require.Equal(t, []string{"serve"}, names(filterSnippets(snippets, "http")))
+	require.Equal(t, []string{"deploy", "logs"}, names(filterSnippets(snippets, "kubectl")))
+	require.Equal(t, []string{"logs"}, names(filterSnippets(snippets, "logs")))
+	require.Empty(t, filterSnippets(snippets, "zzz"))
+}
+
+func TestBuildChangeDirCommand(t *testing.T) {
+	require.Equal(t, `cd "/tmp/foo" && ls`, buildChangeDirCommand("bash", "/tmp/foo", "ls"))
+	require.Equal(t, `cd "/tmp/foo" && ls`, buildChangeDirCommand("zsh", "/tmp/foo", "ls"))
+	require.Equal(t, `cd '/tmp/$foo''s' && ls`, buildChangeDirCommand("pwsh", "/tmp/$foo's", "ls"))
+	require.Equal(t, `cd '/tmp/foo'; ls`, buildChangeDirCommand("nu", "/tmp/foo", "ls"))
+	require.Equal(t, `cd r#'/tmp/foo's'#; ls`, buildChangeDirCommand("nu", "/tmp/foo's", "ls"))
+}
+
+func TestFormatApproximateCount(t *testing.T) {
+	require.Equal(t, "7", formatApproximateCount(7))
+	require.Equal(t, "99", formatApproximateCount(99))
+	require.Equal(t, "1,200", formatApproximateCount(1234))
+	require.Equal(t, "48,000", formatApproximateCount(48213))
+	require.Equal(t, "3,500,000", formatApproximateCount(3456789))
+}
+
+func TestWrapHelpReferenceItems(t *testing.T) {
+	items := []helpReferenceItem{{"ctrl+a", "jump"}, {"ctrl+e", "end"}, {"ctrl+l", "clear the query"}}
+	// Each item is on its own line if the width is too small to fit two of them
+	require.Equal(t, [][]helpReferenceItem{{items[0]}, {items[1]}, {items[2]}}, wrapHelpReferenceItems(items, 5))
+	// "ctrl+a jump   ctrl+e end" is 24 characters wide
+	require.Equal(t, [][]helpReferenceItem{{items[0], items[1]}, {items[2]}}, wrapHelpReferenceItems(items, 24))
+	require.Equal(t, [][]helpReferenceItem{{items[0]}, {items[1]}, {items[2]}}, wrapHelpReferenceItems(items, 23))
+	require.Equal(t, [][]helpReferenceItem{items}, wrapHelpReferenceItems(items, 100))
+}
+
+func TestAiChat(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	hctx.GetConf(ctx).AiCompletion = true
+	m := initialModel(ctx, "bash", "")
+
+	// The chat is only available for AI queries
+	m, _ = openAiChat(m)
+	require.Nil(t, m.aiChat)
+	require.NotEmpty(t, m.notice)
+
+	// Opening it for an AI query starts a conversation about that query
+	m.lastQuery = "? find large files"
+	m, _ = openAiChat(m)
+	require.NotNil(t, m.aiChat)
+	require.Equal(t, "find large files", m.aiChat.query)
+	require.Empty(t, m.aiChat.history)
+
+	// Refinements apply to the highlighted suggestion
+	m.tableEntries = []*data.HistoryEntry{{Command: "find . -size +1M"}, {Command: "du -a | sort -n"}}
+	tbl := table.New(table.WithColumns([]table.Column{{Title: "Command", Width: 20}}), table.WithRows([]table.Row{{"find . -size +1M"}, {"du -a | sort -n"}}))
+	tbl.SetCursor(1)
+	m.table = &tbl
+	m.aiChat.input.SetValue("make it recursive")
+	m, cmd := updateAiChat(m, tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	require.Equal(t, []sharedai.AiChatTurn{{Query: "find large files", Suggestion: "du -a | sort -n"}}, m.aiChat.history)
+	require.Equal(t, "make it recursive", m.aiChat.query)
+	require.Equal(t, "", m.aiChat.input.Value())
+	require.Equal(t, NotSelected, m.selected)
+
+	// Hitting enter without a refinement selects the highlighted suggestion
+	m, _ = updateAiChat(m, tea.KeyMsg{Type: tea.KeyEnter})
+	require.Equal(t, Selected, m.selected)
+
+	// And closing the chat goes back to the original query
+	m, cmd = updateAiChat(m, tea.KeyMsg{Type: tea.KeyEsc})
+	require.NotNil(t, cmd)
+	require.Nil(t, m.aiChat)
+}
+
+func TestGetAgeDimmingStyle(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	thresholds := []int{7, 30, 365}
+	testcases := []struct {
+		age           time.Duration
+		expectedColor lipgloss.TerminalColor
+	}{
+		{time.Hour, lipgloss.NoColor{}},
+		{8 * day, lipgloss.Color("248")},
+		{31 * day, lipgloss.Color("244")},
+		{400 * day, lipgloss.Color("240")},
+	}
+	for _, tc := range testcases {
+		entry := data.HistoryEntry{StartTime: now.Add(-tc.age)}
+		require.Equal(t, tc.expectedColor, getAgeDimmingStyle(thresholds, &entry, now).GetForeground(), tc.age)
+	}
+
+	// Entries without a timestamp are never dimmed
+	entry := data.HistoryEntry{StartTime: time.Unix(0, 0)}
+	require.Equal(t, lipgloss.NoColor{}, getAgeDimmingStyle(thresholds, &entry, now).GetForeground())
+}
+
+func makeWindow(offset, numResults int, hasMore bool) rowWindow {
+	window := rowWindow{offset: offset, hasMore: hasMore}
+	for i := 0; i < numResults; i++ {
+		window.resultIndexes = append(window.resultIndexes, i)
+	}
+	return window
+}
+
+func TestCalculateWindowShift(t *testing.T) {
+	// No shift needed in the middle of the window
+	_, _, shouldShift := calculateWindowShift(makeWindow(0, PADDED_NUM_ENTRIES, true), 50)
+	require.False(t, shouldShift)
+
+	// Near the end of the window, it shifts forward
+	newOffset, cursorResultIndex, shouldShift := calculateWindowShift(makeWindow(0, PADDED_NUM_ENTRIES, true), 85)
+	require.True(t, shouldShift)
+	require.Equal(t, 65, newOffset)
+	require.Equal(t, TABLE_HEIGHT, cursorResultIndex)
+
+	// But not if there are no more results
+	_, _, shouldShift = calculateWindowShift(makeWindow(0, PADDED_NUM_ENTRIES, false), 85)
+	require.False(t, shouldShift)
+
+	// Near the start of the window, it shifts backwards
+	newOffset, cursorResultIndex, shouldShift = calculateWindowShift(makeWindow(500, PADDED_NUM_ENTRIES, true), 10)
+	require.True(t, shouldShift)
+	require.Equal(t, 430, newOffset)
+	require.Equal(t, 80, cursorResultIndex)
+
+	// But never before the first result
+	newOffset, cursorResultIndex, shouldShift = calculateWindowShift(makeWindow(30, PADDED_NUM_ENTRIES, true), 10)
+	require.True(t, shouldShift)
+	require.Equal(t, 0, newOffset)
+	require.Equal(t, 40, cursorResultIndex)
+	_, _, shouldShift = calculateWindowShift(makeWindow(0, PADDED_NUM_ENTRIES, true), 10)
+	require.False(t, shouldShift)
+
+	// Filtered duplicates are accounted for
+	window := rowWindow{offset: 100, hasMore: true}
+	for i := 0; i < PADDED_NUM_ENTRIES; i++ {
+		window.resultIndexes = append(window.resultIndexes, 2*i)
+	}
+	newOffset, cursorResultIndex, shouldShift = calculateWindowShift(window, 90)
+	require.True(t, shouldShift)
+	require.Equal(t, 100+180-TABLE_HEIGHT, newOffset)
+	require.Equal(t, TABLE_HEIGHT, cursorResultIndex)
+
+	// And it always shifts by at least a page, even if nearly everything was filtered out as duplicates
+	window = rowWindow{offset: 100, resultIndexes: []int{0}, hasMore: true}
+	newOffset, cursorResultIndex, shouldShift = calculateWindowShift(window, 0)
+	require.True(t, shouldShift)
+	require.Equal(t, 100+TABLE_HEIGHT, newOffset)
+	require.Equal(t, -TABLE_HEIGHT, cursorResultIndex)
+	require.Equal(t, 0, cursorForResultIndex(rowWindow{resultIndexes: []int{0, 1}}, cursorResultIndex))
+}
+
+func TestCursorForResultIndex(t *testing.T) {
+	window := rowWindow{resultIndexes: []int{0, 2, 3, 5, 8}}
+	require.Equal(t, 0, cursorForResultIndex(window, 0))
+	require.Equal(t, 1, cursorForResultIndex(window, 2))
+	require.Equal(t, 3, cursorForResultIndex(window, 4))
+	require.Equal(t, 4, cursorForResultIndex(window, 100))
+	require.Equal(t, 0, cursorForResultIndex(rowWindow{}, 5))
+}
+
+func TestHandleChord(t *testing.T) {
+	defer func() { loadedKeyBindings = keybindings.DefaultKeyMap }()
+	bindings := keybindings.DefaultKeyMap.ToSerializable()
+	bindings.DeleteEntry = []string{"ctrl+x k"}
+	loadedKeyBindings = bindings.ToKeyMap()
+	ctrlX := tea.KeyMsg{Type: tea.KeyCtrlX}
+	k := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("k")}
+	j := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("j")}
+
+	// Keys that aren't part of a chord are handled as usual
+	m, msg, isPrefix := handleChord(model{}, k)
+	require.False(t, isPrefix)
+	require.Equal(t, k, msg)
+	require.False(t, isCompletedChord(msg))
+
+	// Starting a chord consumes the key press
+	m, _, isPrefix = handleChord(m, ctrlX)
+	require.True(t, isPrefix)
+	require.Equal(t, []string{"ctrl+x"}, m.pendingChord)
+
+	// And completing it triggers the binding
+	m, msg, isPrefix = handleChord(m, k)
+	require.False(t, isPrefix)
+	require.True(t, isCompletedChord(msg))
+	require.True(t, key.Matches(msg, loadedKeyBindings.DeleteEntry))
+	require.Empty(t, m.pendingChord)
+
+	// Other keys cancel the chord and are handled as usual
+	m, _, _ = handleChord(m, ctrlX)
+	m, msg, isPrefix = handleChord(m, j)
+	require.False(t, isPrefix)
+	require.Equal(t, j, msg)
+	require.Empty(t, m.pendingChord)
+}
+
+func TestColorSchemePresets(t *testing.T) {
+	require.Equal(t, []string{"default", "dracula", "gruvbox", "high-contrast", "nord", "solarized-dark"}, hctx.ColorSchemePresetNames())
+	hexColor := regexp.MustCompile("^#[0-9a-f]{6}$")
+	for _, name := range hctx.ColorSchemePresetNames() {
+		scheme := hctx.ColorSchemePresets[name]
+		colors := []string{scheme.SelectedText, scheme.SelectedBackground, scheme.BorderColor}
+		if name != "default" {
+			// The default color scheme uses the terminal's colors for everything else
+			colors = append(colors, scheme.HeaderText, scheme.MatchHighlight, scheme.WarningText, scheme.ErrorText, scheme.HelpKey, scheme.HelpDescription)
+		}
+		require.Contains(t, hctx.MATCH_HIGHLIGHT_STYLES, scheme.MatchHighlightStyle, name)
+		for _, color := range colors {
+			require.Regexp(t, hexColor, color, name)
+		}
+		preview := RenderColorSchemePreview(scheme)
+		require.Contains(t, preview, "git status")
+		require.Contains(t, preview, "Warning: an example warning")
+	}
+}
+
+func TestFindVisibleMatches(t *testing.T) {
+	re := regexp.MustCompile("mit -m")
+
+	// Without the full value, matches are found in the rendered value
+	require.Equal(t, [][]int{{7, 13}}, findVisibleMatches(re, "git commit -m foo", table.CellPosition{}))
+
+	// Matches that are partially truncated out of view are still highlighted
+	position := table.CellPosition{Value: "git commit -m foo", VisibleStart: 4, VisibleEnd: 10}
+	rendered := "…commit…  "
+	matches := findVisibleMatches(re, rendered, position)
+	require.Equal(t, [][]int{{6, 9}}, matches)
+	require.Equal(t, "mit", rendered[matches[0][0]:matches[0][1]])
+
+	// Matches that are entirely out of view aren't highlighted
+	position = table.CellPosition{Value: "git commit -m foo", VisibleStart: 14, VisibleEnd: 17}
+	require.Empty(t, findVisibleMatches(re, "…foo", position))
+}
+
+func TestMultiLineCommandEscaper(t *testing.T) {
+	require.Equal(t, "ls ~/", multiLineCommandEscaper("ls ~/"))
+	require.Equal(t, "cat <<EOF\nfoo\nEOF", multiLineCommandEscaper("cat <<EOF\r\nfoo\r\nEOF\n"))
+	require.Equal(t, "if true; then\n    echo \\x1b\nfi", multiLineCommandEscaper("if true; then\n\techo \x1b\nfi"))
+	require.Equal(t, []int{5, 13}, calculateColumnWidths([]table.Row{{"short", "two\nlines of text"}}, 2))
+}
+
+func TestMatchHighlightStyle(t *testing.T) {
+	scheme := hctx.GetDefaultColorScheme()
+	style := matchHighlightStyle(lipgloss.NewStyle(), scheme)
+	require.True(t, style.GetBold())
+	require.False(t, style.GetUnderline())
+
+	scheme.MatchHighlightStyle = "underline"
+	scheme.MatchHighlight = "#ff0000"
+	style = matchHighlightStyle(lipgloss.NewStyle(), scheme)
+	require.False(t, style.GetBold())
+	require.True(t, style.GetUnderline())
+	require.Equal(t, lipgloss.Color("#ff0000"), style.GetForeground())
+
+	scheme.MatchHighlightStyle = "none"
+	style = matchHighlightStyle(lipgloss.NewStyle(), scheme)
+	require.False(t, style.GetBold())
+	require.False(t, style.GetUnderline())
+	require.False(t, style.GetReverse())
+}
+
+func TestRecallQuery(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	require.NoError(t, lib.RecordSearchQuery(ctx, "ls"))
+	require.NoError(t, lib.RecordSearchQuery(ctx, "git status"))
+	m := initialModel(ctx, "bash", "foo")
+
+	// Cycling backwards recalls older queries, and stops at the oldest one
+	m, _ = recallPreviousQuery(m)
+	require.Equal(t, "git status", m.queryInput.Value())
+	m, _ = recallPreviousQuery(m)
+	require.Equal(t, "ls", m.queryInput.Value())
+	m, _ = recallPreviousQuery(m)
+	require.Equal(t, "ls", m.queryInput.Value())
+
+	// Cycling forwards goes back to the query that was typed beforehand
+	m, _ = recallNextQuery(m)
+	require.Equal(t, "git status", m.queryInput.Value())
+	m, _ = recallNextQuery(m)
+	require.Equal(t, "foo", m.queryInput.Value())
+	m, _ = recallNextQuery(m)
+	require.Equal(t, "foo", m.queryInput.Value())
+}
+
+func TestCycleDefaultFilter(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+
+	// With no default filters, there is nothing to cycle between
+	m := initialModel(ctx, "bash", "")
+	m, _ = cycleDefaultFilter(m)
+	require.Equal(t, "", m.queryInput.Prompt)
+	require.Contains(t, m.notice, "no default filters")
+
+	// The first filter is applied by default, and no filter is added to the end of the cycle
+	hctx.GetConf(ctx).DefaultFilters = []string{"exit_code:0", "hostname:foo"}
+	m = initialModel(ctx, "bash", "")
+	require.Equal(t, "[exit_code:0] ", m.queryInput.Prompt)
+	require.Equal(t, "exit_code:0", getQueryDefaultFilter(m))
+	m, _ = cycleDefaultFilter(m)
+	require.Equal(t, "[hostname:foo] ", m.queryInput.Prompt)
+	require.Equal(t, "hostname:foo", getQueryDefaultFilter(m))
+	m, _ = cycleDefaultFilter(m)
+	require.Equal(t, "", m.queryInput.Prompt)
+	require.Equal(t, "", getQueryDefaultFilter(m))
+	m, _ = cycleDefaultFilter(m)
+	require.Equal(t, "[exit_code:0] ", m.queryInput.Prompt)
+
+	// Unless no filter is explicitly one of the options
+	hctx.GetConf(ctx).DefaultFilters = []string{"", "exit_code:0"}
+	require.Equal(t, []string{"", "exit_code:0"}, getDefaultFilterOptions(ctx))
+}
+
+func TestReloadConfigIfChanged(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	m := initialModel(ctx, "bash", "")
+
+	// Nothing is reloaded if the config is unchanged
+	m, cmd := reloadConfigIfChanged(m)
+	require.Nil(t, cmd)
+
+	// Changes to the config are picked up
+	config := *hctx.GetConf(ctx)
+	config.DisplayedColumns = []string{"Exit Code", "Command"}
+	config.KeyBindings.Quit = []string{"ctrl+q"}
+	require.NoError(t, hctx.SetConfig(&config))
+	homedir, err := os.UserHomeDir()
+	require.NoError(t, err)
+	futureTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path.Join(homedir, data.GetHishtoryPath(), data.CONFIG_PATH), futureTime, futureTime))
+	m, cmd = reloadConfigIfChanged(m)
+	require.NotNil(t, cmd)
+	require.Equal(t, []string{"Exit Code", "Command"}, hctx.GetConf(m.ctx).DisplayedColumns)
+	require.Equal(t, []string{"ctrl+q"}, loadedKeyBindings.Quit.Keys())
+
+	// The previously loaded config isn't modified
+	require.Equal(t, []string{"Hostname", "CWD", "Timestamp", "Runtime", "Exit Code", "Command"}, hctx.GetConf(ctx).DisplayedColumns)
+}
+
+func TestInitialSortOrder(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+
+	// The TUI starts out in the default order, so the query label and the headers don't mention sorting
+	m := initialModel(ctx, "bash", "")
+	require.Equal(t, lib.DefaultSearchOrder, m.sortOrder)
+	require.Contains(t, m.View(), "Search Query: ")
+	require.NotContains(t, m.View(), "sorted by")
+	columns := []table.Column{{Title: "Hostname", Width: 8}, {Title: "Timestamp", Width: 9}, {Title: "Command", Width: 7}}
+	markSortColumn(columns, m.sortOrder)
+	require.Equal(t, []string{"Hostname", "Timestamp", "Command"}, []string{columns[0].Title, columns[1].Title, columns[2].Title})
+
+	// The configured ranker is displayed
+	hctx.GetConf(ctx).Ranker = lib.RANKER_FRECENCY
+	m = initialModel(ctx, "bash", "")
+	require.Contains(t, m.View(), "Search Query (ranked by frecency): ")
+
+	// And the first press of the sort key binding changes the order
+	m.sortOrder = nextSortOrder(m.sortOrder)
+	require.Equal(t, lib.SearchOrder{Column: lib.SORT_BY_TIME, Ascending: true}, m.sortOrder)
+	require.Contains(t, m.View(), "sorted by")
+	markSortColumn(columns, m.sortOrder)
+	require.Equal(t, []string{"Hostname", "Timestamp ▲", "Command"}, []string{columns[0].Title, columns[1].Title, columns[2].Title})
+}
+
+func TestDescribeAiSuggestionHistory(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	hctx.GetConf(ctx).AiCompletion = true
+	db := hctx.GetDb(ctx)
+	for _, exitCode := range []int{0, 1, 0, 0} {
+		entry := testutils.MakeFakeHistoryEntry("git  log --oneline")
+		entry.ExitCode = exitCode
+		require.NoError(t, db.Create(entry).Error)
+	}
+
+	// Suggestions that were effectively run before are merged with their most recent run
+	_, entries, err := buildAiSuggestionRows(ctx, []string{"Hostname", "Command"}, []string{"git log --oneline", "git log --graph"})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "OpenAI", entries[0].Hostname)
+	require.NotEqual(t, int64(0), entries[0].StartTime.Unix())
+	require.Equal(t, int64(0), entries[1].StartTime.Unix())
+
+	// And their history is described when they're selected, regardless of which columns are displayed
+	tbl := table.New(table.WithColumns([]table.Column{{Title: "Command", Width: 20}}), table.WithRows([]table.Row{{"git log --oneline"}, {"git log --graph"}}))
+	m := initialModel(ctx, "bash", "")
+	m.lastQuery = "?show the git log"
+	m.table = &tbl
+	m.tableEntries = entries
+	require.Contains(t, describeAiSuggestionHistory(m), "You've run this command 4 times (75% succeeded)")
+	m.table.SetCursor(1)
+	require.Equal(t, "", describeAiSuggestionHistory(m))
+
+	// But not for normal search results
+	m.table.SetCursor(0)
+	m.lastQuery = "git log"
+	require.Equal(t, "", describeAiSuggestionHistory(m))
+}
@@ -0,0 +1,44 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+)
+
+// Dismisses the what's new overlay so that it is never displayed again. Returns whether the key press was only used
+// to dismiss the overlay (esc or enter), as opposed to also being handled as usual so that e.g. typing immediately
+// starts a search.
+func dismissWhatsNew(m model, msg tea.KeyMsg) (model, bool) {
+	if err := lib.MarkReleaseNotesSeen(m.ctx, m.whatsNew); err != nil {
+		hctx.GetLogger().Infof("failed to mark the release notes as seen: %v", err)
+	}
+	m.whatsNew = nil
+	return m, msg.Type == tea.KeyEsc || key.Matches(msg, loadedKeyBindings.SelectEntry)
+}
+
+func renderWhatsNew(m model) string {
+	notes := m.whatsNew
+	config := hctx.GetConf(m.ctx)
+	lines := []string{"What's new in hiSHtory " + notes.Version + " (press esc to dismiss)"}
+	appendSection := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		lines = append(lines, "", title+":")
+		for _, item := range items {
+			lines = append(lines, "  • "+item)
+		}
+	}
+	appendSection("New features", notes.NewFeatures)
+	appendSection("Changed defaults", notes.ChangedDefaults)
+	lines = append(lines, "", "To stop showing what's new after updates, run `hishtory config-set show-whats-new false`")
+	// Pad the overlay to the height of the table so that the layout doesn't jump around when it is dismissed
+	for len(lines) < TABLE_HEIGHT+3 {
+		lines = append(lines, "")
+	}
+	return getBaseStyle(*config).Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,70 @@
+//go:build js && wasm
+
+// A WASM module used by the web UI in backend/web/app/ so that history entries can be decrypted in the browser,
+// without the user secret ever being sent to the server. Build it via `make web-app`.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"syscall/js"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// hishtoryUserId(userSecret) returns the user ID derived from the given user secret
+func userId(this js.Value, args []js.Value) any {
+	if len(args) != 1 {
+		return makeError(fmt.Errorf("hishtoryUserId expects 1 argument, got %d", len(args)))
+	}
+	return data.UserId(args[0].String())
+}
+
+// hishtoryDecryptTakeout(userSecret, takeoutArchive) returns a JSON array of the decrypted history entries in the given
+// takeout archive (a Uint8Array, see shared.ContentTypeTakeout). Each entry is only returned once, even if it was
+// stored once per device.
+func decryptTakeout(this js.Value, args []js.Value) any {
+	if len(args) != 2 {
+		return makeError(fmt.Errorf("hishtoryDecryptTakeout expects 2 arguments, got %d", len(args)))
+	}
+	userSecret := args[0].String()
+	archive := make([]byte, args[1].Get("length").Int())
+	js.CopyBytesToGo(archive, args[1])
+	entries := make([]data.HistoryEntry, 0)
+	seenEntryIds := make(map[string]bool)
+	err := shared.ReadTakeout(bytes.NewReader(archive), func(encEntry *shared.EncHistoryEntry) error {
+		entry, err := data.DecryptHistoryEntry(userSecret, *encEntry)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt history entry: %w", err)
+		}
+		if entry.EntryId != "" {
+			if seenEntryIds[entry.EntryId] {
+				return nil
+			}
+			seenEntryIds[entry.EntryId] = true
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return makeError(err)
+	}
+	serializedEntries, err := json.Marshal(entries)
+	if err != nil {
+		return makeError(fmt.Errorf("failed to serialize decrypted history entries: %w", err))
+	}
+	return string(serializedEntries)
+}
+
+func makeError(err error) any {
+	return map[string]any{"error": err.Error()}
+}
+
+func main() {
+	js.Global().Set("hishtoryUserId", js.FuncOf(userId))
+	js.Global().Set("hishtoryDecryptTakeout", js.FuncOf(decryptTakeout))
+	// Block forever so that the exported functions remain callable
+	select {}
+}
@@ -4,7 +4,7 @@ import shutil
 import sys 
 import os 
 
-ALL_FILES = ['hishtory-linux-amd64', 'hishtory-linux-arm64', 'hishtory-darwin-amd64', 'hishtory-darwin-arm64']
+ALL_FILES = ['hishtory-linux-amd64', 'hishtory-linux-arm64', 'hishtory-freebsd-amd64', 'hishtory-darwin-amd64', 'hishtory-darwin-arm64', 'hishtory-windows-amd64.exe', 'hishtory-windows-arm64.exe']
 
 def validate_slsa(hishtory_binary: str) -> None:
     assert os.path.exists(hishtory_binary)
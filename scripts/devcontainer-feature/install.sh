@@ -0,0 +1,15 @@
+#!/bin/sh
+# Installs hiSHtory for the container's user. This happens while the image is built where the user's secret isn't
+# available, so hiSHtory is installed offline here and is then initialized with the secret from the HISHTORY_SECRET
+# environment variable by the feature's postCreateCommand.
+set -e
+
+USERNAME="${_REMOTE_USER:-root}"
+
+if ! command -v curl > /dev/null || ! command -v python3 > /dev/null; then
+    echo "hiSHtory requires curl and python3 to be installed"
+    exit 1
+fi
+
+# The DB path is stored in hiSHtory's config, so it is kept when hiSHtory is re-initialized with the user's secret
+su "$USERNAME" -c "curl -fsSL https://hishtory.dev/install.py | HISHTORY_OFFLINE=true HISHTORY_EPHEMERAL=true HISHTORY_DB_PATH='${DBPATH:-}' HISHTORY_SKIP_INIT_IMPORT=true python3 -"
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/ddworken/hishtory/client/hctx"
 	"golang.org/x/exp/slices"
@@ -14,6 +15,11 @@ import (
 
 const DefaultOpenAiEndpoint = "https://api.openai.com/v1/chat/completions"
 
+const DefaultOpenAiModel = "gpt-3.5-turbo"
+
+// The maximum number of previous turns that a conversation can refine, to bound the size of requests
+const MaxAiChatTurns = 20
+
 type openAiRequest struct {
 	Model             string          `json:"model"`
 	Messages          []openAiMessage `json:"messages"`
@@ -54,31 +60,41 @@ type TestOnlyOverrideAiSuggestionRequest struct {
 var TestOnlyOverrideAiSuggestions map[string][]string = make(map[string][]string)
 
 func GetAiSuggestionsViaOpenAiApi(apiEndpoint, query, shellName, osName string, numberCompletions int) ([]string, OpenAiUsage, error) {
+	return GetAiChatSuggestionsViaOpenAiApi(apiEndpoint, nil, query, shellName, osName, nil, numberCompletions)
+}
+
+// Like GetAiSuggestionsViaOpenAiApi, but query refines the suggestions from the previous turns of a conversation (e.g.
+// "make it recursive"), optionally with additional context about the user's environment
+func GetAiChatSuggestionsViaOpenAiApi(apiEndpoint string, history []AiChatTurn, query, shellName, osName string, aiContext *AiContext, numberCompletions int) ([]string, OpenAiUsage, error) {
 	if results := TestOnlyOverrideAiSuggestions[query]; len(results) > 0 {
 		return results, OpenAiUsage{}, nil
 	}
-	hctx.GetLogger().Infof("Running OpenAI query for %#v", query)
-	if osName == "" {
-		osName = "Linux"
-	}
-	if shellName == "" {
-		shellName = "bash"
+	return getOpenAiCompatibleCompletions(apiEndpoint, DefaultOpenAiModel, getSuggestionMessages(history, query, shellName, osName, aiContext), numberCompletions)
+}
+
+// Gets an explanation of what the given command does (including its flags and any risks of running it)
+func GetExplanationViaOpenAiApi(apiEndpoint, command, shellName, osName string) (string, OpenAiUsage, error) {
+	explanations, usage, err := getOpenAiCompatibleCompletions(apiEndpoint, DefaultOpenAiModel, getExplanationMessages(command, shellName, osName), 1)
+	if err != nil {
+		return "", usage, err
 	}
+	return explanations[0], usage, nil
+}
+
+// Queries an OpenAI-compatible chat completions API (e.g. OpenAI itself, or a self-hosted server that implements the
+// same API). The first message is the system prompt, and the last message is the user's query.
+func getOpenAiCompatibleCompletions(apiEndpoint, model string, messages []openAiMessage, numberCompletions int) ([]string, OpenAiUsage, error) {
+	query := messages[len(messages)-1].Content
+	hctx.GetLogger().Infof("Running OpenAI query for %#v", query)
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" && apiEndpoint == DefaultOpenAiEndpoint {
 		return nil, OpenAiUsage{}, fmt.Errorf("OPENAI_API_KEY environment variable is not set")
 	}
 	client := &http.Client{}
 	apiReq := openAiRequest{
-		Model:             "gpt-3.5-turbo",
+		Model:             model,
 		NumberCompletions: numberCompletions,
-		Messages: []openAiMessage{
-			{Role: "system", Content: "You are an expert programmer that loves to help people with writing shell commands. " +
-				"You always reply with just a shell command and no additional context, information, or formatting. " +
-				"Your replies will be directly executed in " + shellName + " on " + osName +
-				", so ensure that they are correct and do not contain anything other than a shell command."},
-			{Role: "user", Content: query},
-		},
+		Messages:          messages,
 	}
 	apiReqStr, err := json.Marshal(apiReq)
 	if err != nil {
@@ -122,6 +138,106 @@ func GetAiSuggestionsViaOpenAiApi(apiEndpoint, query, shellName, osName string,
 	return ret, apiResp.Usage, nil
 }
 
+// Returns the name of the given shell to use in prompts. Defaults to bash if no shell is specified.
+func describeShell(shellName string) string {
+	switch shellName {
+	case "":
+		return "bash"
+	case "pwsh":
+		// The binary name alone is ambiguous, so make it clear that commands should use PowerShell's syntax
+		return "PowerShell (pwsh)"
+	case "nu":
+		// Nushell's syntax differs significantly from POSIX shells (e.g. structured pipelines and no &&)
+		return "Nushell (nu)"
+	default:
+		return shellName
+	}
+}
+
+// Returns the instructions sent to the AI alongside every query
+func getSystemPrompt(shellName, osName string, aiContext *AiContext) string {
+	if osName == "" {
+		osName = "Linux"
+	}
+	shellName = describeShell(shellName)
+	prompt := "You are an expert programmer that loves to help people with writing shell commands. " +
+		"You always reply with just a shell command and no additional context, information, or formatting. " +
+		"Your replies will be directly executed in " + shellName + " on " + osName +
+		", so ensure that they are correct and do not contain anything other than a shell command."
+	if aiContext != nil {
+		prompt += "\n\n" + aiContext.describe()
+	}
+	return prompt
+}
+
+// Returns the system prompt followed by the user and assistant messages for a conversation that ends with the given
+// query
+func getSuggestionMessages(history []AiChatTurn, query, shellName, osName string, aiContext *AiContext) []openAiMessage {
+	messages := make([]openAiMessage, 0, 2*len(history)+2)
+	messages = append(messages, openAiMessage{Role: "system", Content: getSystemPrompt(shellName, osName, aiContext)})
+	for _, turn := range history {
+		messages = append(messages, openAiMessage{Role: "user", Content: turn.Query}, openAiMessage{Role: "assistant", Content: turn.Suggestion})
+	}
+	return append(messages, openAiMessage{Role: "user", Content: query})
+}
+
+// Returns the messages asking for an explanation of the given command
+func getExplanationMessages(command, shellName, osName string) []openAiMessage {
+	if osName == "" {
+		osName = "Linux"
+	}
+	shellName = describeShell(shellName)
+	systemPrompt := "You are an expert programmer that loves to help people understand shell commands. " +
+		"When given a " + shellName + " command that will be run on " + osName + ", you explain what it does, what each of its flags and arguments do, " +
+		"and any risks of running it (e.g. if it deletes data or is hard to undo). " +
+		"Be concise: reply in plain text with no more than 10 short lines and without any markdown formatting."
+	return []openAiMessage{{Role: "system", Content: systemPrompt}, {Role: "user", Content: command}}
+}
+
+// A previous exchange in a conversation with the AI, used to refine suggestions iteratively
+type AiChatTurn struct {
+	// What the user asked for (e.g. "find large files" or "make it recursive")
+	Query string `json:"query"`
+	// The suggested command that the user then refined
+	Suggestion string `json:"suggestion"`
+}
+
+// The maximum number of recent commands that can be included in an AiContext, to bound the size of requests
+const MaxAiContextCommands = 10
+
+// Optional additional context about the user's environment that is included in the prompt for more accurate
+// suggestions. Only sent if the user enables `hishtory config-set ai-include-context true`.
+type AiContext struct {
+	// The current working directory
+	Cwd string `json:"cwd"`
+	// The CPU architecture (e.g. amd64)
+	Arch string `json:"arch"`
+	// The most recently run commands, most recent first
+	RecentCommands []string `json:"recent_commands"`
+	// The versions of relevant installed tools (e.g. "git version 2.43.0"), as printed by the tools themselves
+	ToolVersions []string `json:"tool_versions"`
+}
+
+func (c *AiContext) describe() string {
+	lines := []string{"Context about the user's environment:"}
+	if c.Cwd != "" {
+		lines = append(lines, "- Current working directory: "+c.Cwd)
+	}
+	if c.Arch != "" {
+		lines = append(lines, "- CPU architecture: "+c.Arch)
+	}
+	if len(c.ToolVersions) > 0 {
+		lines = append(lines, "- Installed tools: "+strings.Join(c.ToolVersions, "; "))
+	}
+	if len(c.RecentCommands) > 0 {
+		lines = append(lines, "- Recently run commands (most recent first):")
+		for _, command := range c.RecentCommands {
+			lines = append(lines, "    "+command)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 type AiSuggestionRequest struct {
 	DeviceId          string `json:"device_id"`
 	UserId            string `json:"user_id"`
@@ -129,8 +245,40 @@ type AiSuggestionRequest struct {
 	NumberCompletions int    `json:"number_completions"`
 	ShellName         string `json:"shell_name"`
 	OsName            string `json:"os_name"`
+	// The previous turns of the conversation that Query refines, oldest first. Empty for standalone queries.
+	History []AiChatTurn `json:"history"`
+	// Additional context about the user's environment, if enabled. Nil otherwise.
+	Context *AiContext `json:"context"`
 }
 
 type AiSuggestionResponse struct {
 	Suggestions []string `json:"suggestions"`
 }
+
+// The maximum number of accepted or rejected suggestions in a single feedback report
+const MaxAiFeedbackCount = 10_000
+
+// Aggregate counts of how often AI suggestions from a provider were accepted or rejected, reported when enabled via
+// `hishtory config-set ai-feedback report`. The queries and suggestions themselves are never reported.
+type AiFeedbackReport struct {
+	DeviceId    string `json:"device_id"`
+	UserId      string `json:"user_id"`
+	Provider    string `json:"provider"`
+	NumAccepted int    `json:"num_accepted"`
+	NumRejected int    `json:"num_rejected"`
+}
+
+// The maximum length of a command that can be explained, to bound the size of requests
+const MaxAiExplanationCommandLength = 10_000
+
+type AiExplanationRequest struct {
+	DeviceId  string `json:"device_id"`
+	UserId    string `json:"user_id"`
+	Command   string `json:"command"`
+	ShellName string `json:"shell_name"`
+	OsName    string `json:"os_name"`
+}
+
+type AiExplanationResponse struct {
+	Explanation string `json:"explanation"`
+}
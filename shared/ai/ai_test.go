@@ -1,6 +1,9 @@
 package ai
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -23,3 +26,108 @@ func TestLiveOpenAiApi(t *testing.T) {
 	}
 	require.Truef(t, resultsContainsLs, "expected results=%#v to contain ls", results)
 }
+
+func TestGetProvider(t *testing.T) {
+	p, err := GetProvider("", "", "")
+	require.NoError(t, err)
+	require.Equal(t, openAiProvider{endpoint: DefaultOpenAiEndpoint, model: DefaultOpenAiModel}, p)
+	p, err = GetProvider(ProviderOllama, "", "")
+	require.NoError(t, err)
+	require.Equal(t, ollamaProvider{endpoint: DefaultOllamaEndpoint, model: DefaultOllamaModel}, p)
+	p, err = GetProvider(ProviderAnthropic, "claude-custom", "http://localhost:1234")
+	require.NoError(t, err)
+	require.Equal(t, anthropicProvider{endpoint: "http://localhost:1234", model: "claude-custom"}, p)
+	_, err = GetProvider("unknown", "", "")
+	require.Error(t, err)
+}
+
+func TestOllamaProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, "codellama", req.Model)
+		require.False(t, req.Stream)
+		require.Equal(t, "system", req.Messages[0].Role)
+		require.Contains(t, req.Messages[0].Content, "zsh on MacOS")
+		require.Equal(t, []openAiMessage{{Role: "user", Content: "find large files"}, {Role: "assistant", Content: "find . -size +1M"}, {Role: "user", Content: "only in /tmp"}}, req.Messages[1:])
+		w.Write([]byte(`{"model": "codellama", "message": {"role": "assistant", "content": " find /tmp -size +1M \n"}, "done": true}`))
+	}))
+	defer server.Close()
+	p, err := GetProvider(ProviderOllama, "codellama", server.URL)
+	require.NoError(t, err)
+	suggestions, err := GetChatSuggestionsViaProvider(p, []AiChatTurn{{Query: "find large files", Suggestion: "find . -size +1M"}}, "only in /tmp", "zsh", "MacOS", nil, 5)
+	require.NoError(t, err)
+	require.Equal(t, []string{"find /tmp -size +1M"}, suggestions)
+}
+
+func TestAnthropicProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "2023-06-01", r.Header.Get("anthropic-version"))
+		var req anthropicRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, DefaultAnthropicModel, req.Model)
+		require.Contains(t, req.System, "bash on Linux")
+		require.Equal(t, []openAiMessage{{Role: "user", Content: "list files"}}, req.Messages)
+		if req.MaxTokens == 0 {
+			w.WriteHeader(400)
+		}
+		w.Write([]byte(`{"type": "message", "role": "assistant", "content": [{"type": "text", "text": "ls -la"}]}`))
+	}))
+	defer server.Close()
+	p, err := GetProvider(ProviderAnthropic, "", server.URL)
+	require.NoError(t, err)
+	suggestions, err := GetChatSuggestionsViaProvider(p, nil, "list files", "", "", nil, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ls -la"}, suggestions)
+
+	// Errors from the API are surfaced
+	errorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+		w.Write([]byte(`{"type": "error", "error": {"type": "authentication_error"}}`))
+	}))
+	defer errorServer.Close()
+	p, err = GetProvider(ProviderAnthropic, "", errorServer.URL)
+	require.NoError(t, err)
+	_, err = GetChatSuggestionsViaProvider(p, nil, "list files", "", "", nil, 3)
+	require.ErrorContains(t, err, "401")
+}
+
+func TestSystemPromptWithContext(t *testing.T) {
+	require.NotContains(t, getSystemPrompt("zsh", "MacOS", nil), "Context")
+	prompt := getSystemPrompt("zsh", "MacOS", &AiContext{
+		Cwd:            "/home/david/code/hishtory",
+		Arch:           "arm64",
+		RecentCommands: []string{"go test ./...", "git status"},
+		ToolVersions:   []string{"git version 2.43.0", "go version go1.21.5 darwin/arm64"},
+	})
+	require.Contains(t, prompt, "executed in zsh on MacOS")
+	require.Contains(t, getSystemPrompt("pwsh", "Windows", nil), "executed in PowerShell (pwsh) on Windows")
+	require.Contains(t, getSystemPrompt("nu", "MacOS", nil), "executed in Nushell (nu) on MacOS")
+	require.Contains(t, prompt, "\n\nContext about the user's environment:\n"+
+		"- Current working directory: /home/david/code/hishtory\n"+
+		"- CPU architecture: arm64\n"+
+		"- Installed tools: git version 2.43.0; go version go1.21.5 darwin/arm64\n"+
+		"- Recently run commands (most recent first):\n"+
+		"    go test ./...\n"+
+		"    git status")
+	// Empty fields are omitted
+	require.True(t, strings.HasSuffix(getSystemPrompt("bash", "Linux", &AiContext{Cwd: "/tmp"}), "Context about the user's environment:\n- Current working directory: /tmp"))
+}
+
+func TestExplainCommandViaProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Len(t, req.Messages, 2)
+		require.Equal(t, "system", req.Messages[0].Role)
+		require.Contains(t, req.Messages[0].Content, "zsh command that will be run on MacOS")
+		require.Equal(t, openAiMessage{Role: "user", Content: "rm -rf build/"}, req.Messages[1])
+		w.Write([]byte(`{"message": {"role": "assistant", "content": "Recursively deletes the build directory.\n"}, "done": true}`))
+	}))
+	defer server.Close()
+	p, err := GetProvider(ProviderOllama, "", server.URL)
+	require.NoError(t, err)
+	explanation, err := ExplainCommandViaProvider(p, "rm -rf build/", "zsh", "MacOS")
+	require.NoError(t, err)
+	require.Equal(t, "Recursively deletes the build directory.", explanation)
+}
@@ -0,0 +1,232 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+const (
+	// OpenAI, or any other server that implements OpenAI's chat completions API
+	ProviderOpenAi = "openai"
+	// A local model served by Ollama
+	ProviderOllama = "ollama"
+	// Anthropic's messages API
+	ProviderAnthropic = "anthropic"
+)
+
+const (
+	DefaultOllamaEndpoint    = "http://localhost:11434/api/chat"
+	DefaultOllamaModel       = "llama3"
+	DefaultAnthropicEndpoint = "https://api.anthropic.com/v1/messages"
+	DefaultAnthropicModel    = "claude-3-5-haiku-latest"
+)
+
+// A source of AI suggestions for shell commands. See GetChatSuggestionsViaProvider and ExplainCommandViaProvider.
+type Provider interface {
+	// The name of the provider in `hishtory config-set ai-provider`
+	Name() string
+	// Returns up to numberCompletions responses to the given messages, the first of which is the system prompt
+	complete(messages []openAiMessage, numberCompletions int) ([]string, error)
+}
+
+// Returns the names of every provider, for `hishtory config-set ai-provider`
+func ProviderNames() []string {
+	return []string{ProviderOpenAi, ProviderOllama, ProviderAnthropic}
+}
+
+// Returns the provider with the given name. An empty name is equivalent to ProviderOpenAi, and an empty model or
+// endpoint uses the provider's default.
+func GetProvider(name, model, endpoint string) (Provider, error) {
+	switch name {
+	case ProviderOpenAi, "":
+		return openAiProvider{endpoint: withDefault(endpoint, DefaultOpenAiEndpoint), model: withDefault(model, DefaultOpenAiModel)}, nil
+	case ProviderOllama:
+		return ollamaProvider{endpoint: withDefault(endpoint, DefaultOllamaEndpoint), model: withDefault(model, DefaultOllamaModel)}, nil
+	case ProviderAnthropic:
+		return anthropicProvider{endpoint: withDefault(endpoint, DefaultAnthropicEndpoint), model: withDefault(model, DefaultAnthropicModel)}, nil
+	default:
+		return nil, fmt.Errorf("unknown AI provider %#v, must be one of: %s", name, strings.Join(ProviderNames(), ", "))
+	}
+}
+
+func withDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// Gets suggestions from the given provider. aiContext is optional additional context to include in the prompt.
+func GetChatSuggestionsViaProvider(provider Provider, history []AiChatTurn, query, shellName, osName string, aiContext *AiContext, numberCompletions int) ([]string, error) {
+	if results := TestOnlyOverrideAiSuggestions[query]; len(results) > 0 {
+		return results, nil
+	}
+	suggestions, err := provider.complete(getSuggestionMessages(history, query, shellName, osName, aiContext), numberCompletions)
+	if err != nil {
+		return nil, err
+	}
+	hctx.GetLogger().Infof("For %s query=%#v ==> %#v", provider.Name(), query, suggestions)
+	return suggestions, nil
+}
+
+// Gets an explanation of what the given command does (including its flags and any risks of running it) from the given
+// provider
+func ExplainCommandViaProvider(provider Provider, command, shellName, osName string) (string, error) {
+	explanations, err := provider.complete(getExplanationMessages(command, shellName, osName), 1)
+	if err != nil {
+		return "", err
+	}
+	return explanations[0], nil
+}
+
+// POSTs the given request as JSON and parses the JSON response into resp
+func postJson(providerName, endpoint string, headers map[string]string, req, resp any) error {
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to serialize JSON for %s API: %w", providerName, err)
+	}
+	httpReq, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create %s API request: %w", providerName, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+	httpResp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to query %s API: %w", providerName, err)
+	}
+	defer httpResp.Body.Close()
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s API response: %w", providerName, err)
+	}
+	if httpResp.StatusCode != 200 {
+		return fmt.Errorf("received %d error code from %s API: %#v", httpResp.StatusCode, providerName, string(respBody))
+	}
+	err = json.Unmarshal(respBody, resp)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s API response=%#v: %w", providerName, string(respBody), err)
+	}
+	return nil
+}
+
+type openAiProvider struct {
+	endpoint string
+	model    string
+}
+
+func (p openAiProvider) Name() string {
+	return ProviderOpenAi
+}
+
+func (p openAiProvider) complete(messages []openAiMessage, numberCompletions int) ([]string, error) {
+	completions, _, err := getOpenAiCompatibleCompletions(p.endpoint, p.model, messages, numberCompletions)
+	return completions, err
+}
+
+type ollamaProvider struct {
+	endpoint string
+	model    string
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAiMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+}
+
+type ollamaResponse struct {
+	Message openAiMessage `json:"message"`
+}
+
+func (p ollamaProvider) Name() string {
+	return ProviderOllama
+}
+
+// Note that Ollama's API only returns a single completion per request, so this always returns a single completion to
+// keep local models responsive
+func (p ollamaProvider) complete(messages []openAiMessage, numberCompletions int) ([]string, error) {
+	hctx.GetLogger().Infof("Running Ollama query for %#v", messages[len(messages)-1].Content)
+	req := ollamaRequest{
+		Model:    p.model,
+		Messages: messages,
+		Stream:   false,
+	}
+	var resp ollamaResponse
+	err := postJson("Ollama", p.endpoint, nil, req, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w (is Ollama running, and has the model been pulled via `ollama pull %s`?)", err, p.model)
+	}
+	suggestion := strings.TrimSpace(resp.Message.Content)
+	if suggestion == "" {
+		return nil, fmt.Errorf("Ollama API returned an empty response")
+	}
+	return []string{suggestion}, nil
+}
+
+type anthropicProvider struct {
+	endpoint string
+	model    string
+}
+
+type anthropicRequest struct {
+	Model     string          `json:"model"`
+	MaxTokens int             `json:"max_tokens"`
+	System    string          `json:"system"`
+	Messages  []openAiMessage `json:"messages"`
+}
+
+type anthropicResponse struct {
+	Content []anthropicContent `json:"content"`
+}
+
+type anthropicContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (p anthropicProvider) Name() string {
+	return ProviderAnthropic
+}
+
+// Note that Anthropic's API only returns a single completion per request, so this always returns a single completion
+// rather than sending numberCompletions requests
+func (p anthropicProvider) complete(messages []openAiMessage, numberCompletions int) ([]string, error) {
+	hctx.GetLogger().Infof("Running Anthropic query for %#v", messages[len(messages)-1].Content)
+	apiKey := os.Getenv("ANTHROPIC_API_KEY")
+	if apiKey == "" && p.endpoint == DefaultAnthropicEndpoint {
+		return nil, fmt.Errorf("ANTHROPIC_API_KEY environment variable is not set")
+	}
+	req := anthropicRequest{
+		Model:     p.model,
+		MaxTokens: 512,
+		// Anthropic's API takes the system prompt separately from the messages
+		System:   messages[0].Content,
+		Messages: messages[1:],
+	}
+	headers := map[string]string{"anthropic-version": "2023-06-01"}
+	if apiKey != "" {
+		headers["x-api-key"] = apiKey
+	}
+	var resp anthropicResponse
+	err := postJson("Anthropic", p.endpoint, headers, req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	for _, content := range resp.Content {
+		suggestion := strings.TrimSpace(content.Text)
+		if content.Type == "text" && suggestion != "" {
+			return []string{suggestion}, nil
+		}
+	}
+	return nil, fmt.Errorf("Anthropic API returned an empty response")
+}
@@ -23,6 +23,43 @@ type EncHistoryEntry struct {
 	// then this won't be sent back by the query endpoint. We do still purposefully store
 	// these since they're useful for initializing new devices.
 	IsFromSameDevice bool `json:"is_from_same_device"`
+	// The version of the format of this entry, see data.CurrentPayloadVersion. Zero for entries uploaded by clients that
+	// predate payload versioning.
+	PayloadVersion int `json:"payload_version"`
+	// Keyed hashes of fields that the user opted into exposing to the backend (see data.HashableFields). Empty by
+	// default, in which case these fields are only stored encrypted within EncryptedData.
+	HashedCommand  string `json:"hashed_command"`
+	HashedCwd      string `json:"hashed_cwd"`
+	HashedHostname string `json:"hashed_hostname"`
+	// The position of this entry in DeviceId's sync stream. Assigned by the backend when the entry is stored so that it
+	// increases monotonically for each device, which lets devices track what they've received via a sync cursor. Zero
+	// for entries stored before sync cursors were introduced.
+	SequenceId int64 `json:"sequence_id" gorm:"not null; default:0"`
+}
+
+// Returns a key identifying the history entry contained in this encrypted entry, so that copies of it (e.g. from a
+// retried submission, or the same entry stored for each of a user's devices) can be detected without decrypting it.
+// Entries uploaded by clients that predate pre-saving support have no EncryptedId, so they're identified by their nonce.
+func (e *EncHistoryEntry) DedupKey() string {
+	if e.EncryptedId != "" {
+		return "id:" + e.EncryptedId
+	}
+	return "nonce:" + string(e.Nonce)
+}
+
+// Returns the given entries with any duplicates (as identified by DedupKey) removed, keeping the first copy of each
+func DedupEncHistoryEntries(entries []*EncHistoryEntry) []*EncHistoryEntry {
+	seen := make(map[string]bool)
+	deduped := make([]*EncHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		key := entry.DedupKey()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, entry)
+	}
+	return deduped
 }
 
 // Represents a request to get all history entries from a given device. Used as part of bootstrapping
@@ -33,21 +70,45 @@ type DumpRequest struct {
 	RequestTime        time.Time `json:"request_time"`
 }
 
+// The release channels that `hishtory update` can install from
+const (
+	UpdateChannelStable = "stable"
+	// Pre-releases that are newer than the latest stable release, if there are any
+	UpdateChannelBeta = "beta"
+)
+
 // Identifies where updates can be downloaded from
 type UpdateInfo struct {
-	LinuxAmd64Url             string `json:"linux_amd_64_url"`
-	LinuxAmd64AttestationUrl  string `json:"linux_amd_64_attestation_url"`
-	LinuxArm64Url             string `json:"linux_arm_64_url"`
-	LinuxArm64AttestationUrl  string `json:"linux_arm_64_attestation_url"`
-	LinuxArm7Url              string `json:"linux_arm_7_url"`
-	LinuxArm7AttestationUrl   string `json:"linux_arm_7_attestation_url"`
-	DarwinAmd64Url            string `json:"darwin_amd_64_url"`
-	DarwinAmd64UnsignedUrl    string `json:"darwin_amd_64_unsigned_url"`
-	DarwinAmd64AttestationUrl string `json:"darwin_amd_64_attestation_url"`
-	DarwinArm64Url            string `json:"darwin_arm_64_url"`
-	DarwinArm64UnsignedUrl    string `json:"darwin_arm_64_unsigned_url"`
-	DarwinArm64AttestationUrl string `json:"darwin_arm_64_attestation_url"`
-	Version                   string `json:"version"`
+	LinuxAmd64Url              string `json:"linux_amd_64_url"`
+	LinuxAmd64AttestationUrl   string `json:"linux_amd_64_attestation_url"`
+	LinuxArm64Url              string `json:"linux_arm_64_url"`
+	LinuxArm64AttestationUrl   string `json:"linux_arm_64_attestation_url"`
+	LinuxArm7Url               string `json:"linux_arm_7_url"`
+	LinuxArm7AttestationUrl    string `json:"linux_arm_7_attestation_url"`
+	FreebsdAmd64Url            string `json:"freebsd_amd_64_url"`
+	FreebsdAmd64AttestationUrl string `json:"freebsd_amd_64_attestation_url"`
+	DarwinAmd64Url             string `json:"darwin_amd_64_url"`
+	DarwinAmd64UnsignedUrl     string `json:"darwin_amd_64_unsigned_url"`
+	DarwinAmd64AttestationUrl  string `json:"darwin_amd_64_attestation_url"`
+	DarwinArm64Url             string `json:"darwin_arm_64_url"`
+	DarwinArm64UnsignedUrl     string `json:"darwin_arm_64_unsigned_url"`
+	DarwinArm64AttestationUrl  string `json:"darwin_arm_64_attestation_url"`
+	WindowsAmd64Url            string `json:"windows_amd_64_url"`
+	WindowsAmd64AttestationUrl string `json:"windows_amd_64_attestation_url"`
+	WindowsArm64Url            string `json:"windows_arm_64_url"`
+	WindowsArm64AttestationUrl string `json:"windows_arm_64_attestation_url"`
+	Version                    string `json:"version"`
+	// The release notes for Version, if the release has structured release notes
+	ReleaseNotes *ReleaseNotes `json:"release_notes"`
+}
+
+// Structured release notes for a version, which are displayed once in the TUI after updating to that version
+type ReleaseNotes struct {
+	Version string `json:"version"`
+	// Short descriptions of the features added in this version
+	NewFeatures []string `json:"new_features"`
+	// Short descriptions of the default behaviors that changed in this version
+	ChangedDefaults []string `json:"changed_defaults"`
 }
 
 // Represents a request to delete history entries
@@ -63,6 +124,11 @@ type DeletionRequest struct {
 	Messages MessageIdentifiers `json:"messages"`
 	// How many times this request has been processed
 	ReadCount int `json:"read_count"`
+	// A random ID assigned by the backend and shared by every copy of this request, which devices use to acknowledge
+	// that they applied it. Empty for requests created before acknowledgements were supported.
+	RequestId string `json:"request_id" gorm:"not null; default:''"`
+	// Whether DestinationDeviceId acknowledged that it applied this request
+	Acked bool `json:"acked" gorm:"not null; default:false"`
 }
 
 // Identifies a list of history entries that should be deleted
@@ -108,6 +174,18 @@ type Feedback struct {
 	Feedback string    `json:"feedback"`
 }
 
+// A set of related changes that the backend applies atomically, so that an interrupted sync never leaves only some of
+// them applied (e.g. an import of many entries, or an edit represented as deleting the old entry and inserting its
+// replacement). The deletion request is applied before the entries are inserted.
+type SyncBatch struct {
+	UserId          string             `json:"user_id"`
+	Entries         []*EncHistoryEntry `json:"entries"`
+	DeletionRequest *DeletionRequest   `json:"deletion_request"`
+	// An optional client-generated key identifying this batch. If a batch with the same key was already applied for
+	// this user, the backend skips it so that retrying a partially failed upload doesn't duplicate entries.
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
 // Response from submitting new history entries. Contains deletion requests and dump requests to avoid
 // extra round-trip requests to the hishtory backend.
 type SubmitResponse struct {
@@ -115,6 +193,102 @@ type SubmitResponse struct {
 	DeletionRequests []*DeletionRequest `json:"deletion_requests"`
 }
 
+// Represents a device that is registered to a user
+type DeviceInfo struct {
+	DeviceId         string    `json:"device_id"`
+	RegistrationDate time.Time `json:"registration_date"`
+	// The friendly name of the device, encrypted with the user's secret so that it isn't visible to the backend.
+	// Empty if the device hasn't been named.
+	EncryptedName []byte `json:"enc_name"`
+	NameNonce     []byte `json:"name_nonce"`
+	// Which direction history entries are synced in for this device
+	SyncMode SyncMode `json:"sync_mode"`
+}
+
+// A report of what the backend stores about a user, so that users can audit it
+type AccountInfo struct {
+	// The total number of encrypted history entries stored for the user. Note that entries are stored once for every
+	// device that downloads them.
+	NumEntries int64 `json:"num_entries"`
+	// The total size of the encrypted history entries stored for the user
+	StorageBytes int64 `json:"storage_bytes"`
+	// The number of deletion requests that haven't yet been retrieved by the devices they are queued for
+	NumPendingDeletionRequests int64               `json:"num_pending_deletion_requests"`
+	Devices                    []DeviceAccountInfo `json:"devices"`
+}
+
+// The per-device portion of an AccountInfo report
+type DeviceAccountInfo struct {
+	DeviceInfo
+	// When the device last made a request to the backend, if known
+	LastSeen time.Time `json:"last_seen"`
+	// The hishtory version that the device last made a request with, if known
+	Version string `json:"version"`
+	// The number of encrypted history entries stored for the device to download
+	NumEntries int64 `json:"num_entries"`
+	// The size of the encrypted history entries stored for the device
+	StorageBytes               int64 `json:"storage_bytes"`
+	NumPendingDeletionRequests int64 `json:"num_pending_deletion_requests"`
+}
+
+// Controls whether a device uploads its history entries, downloads the history entries of other devices, or both
+type SyncMode string
+
+const (
+	// The default sync mode, where a device both uploads and downloads history entries. The empty string is treated
+	// as equivalent to this for devices that haven't configured a sync mode.
+	SyncModeReadWrite SyncMode = "read-write"
+	// The device downloads history entries from other devices, but never uploads its own (e.g. for shared servers)
+	SyncModeReadOnly SyncMode = "read-only"
+	// The device uploads its history entries, but never downloads the history entries of other devices
+	SyncModeWriteOnly SyncMode = "write-only"
+)
+
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch SyncMode(s) {
+	case SyncModeReadWrite, SyncModeReadOnly, SyncModeWriteOnly:
+		return SyncMode(s), nil
+	default:
+		return "", fmt.Errorf("unknown sync mode %#v, expected one of %s, %s, or %s", s, SyncModeReadWrite, SyncModeReadOnly, SyncModeWriteOnly)
+	}
+}
+
+// Whether a device with this sync mode uploads its history entries
+func (m SyncMode) CanUpload() bool {
+	return m != SyncModeReadOnly
+}
+
+// Whether a device with this sync mode downloads the history entries of other devices
+func (m SyncMode) CanDownload() bool {
+	return m != SyncModeWriteOnly
+}
+
+// A set of history entries shared via `hishtory share`. The entries are encrypted with a random key that is only
+// included in the fragment of the share link, so the backend can't read them.
+type SharedEntries struct {
+	ShareId string `json:"share_id" gorm:"primaryKey"`
+	// The user that shared the entries, so that they're deleted along with the user's other data. Not returned to
+	// viewers of the link.
+	UserId         string    `json:"-" gorm:"index"`
+	EncryptedData  []byte    `json:"enc_data" gorm:"not null"`
+	Nonce          []byte    `json:"nonce" gorm:"not null"`
+	ExpirationDate time.Time `json:"expiration_date" gorm:"index"`
+}
+
+// Response from sharing history entries
+type ShareResponse struct {
+	ShareId        string    `json:"share_id"`
+	ExpirationDate time.Time `json:"expiration_date"`
+}
+
+// Represents a request to set the friendly name of a device
+type RenameDeviceRequest struct {
+	UserId        string `json:"user_id"`
+	DeviceId      string `json:"device_id"`
+	EncryptedName []byte `json:"enc_name"`
+	NameNonce     []byte `json:"name_nonce"`
+}
+
 func Chunks[k any](slice []k, chunkSize int) [][]k {
 	var chunks [][]k
 	for i := 0; i < len(slice); i += chunkSize {
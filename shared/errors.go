@@ -0,0 +1,90 @@
+package shared
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Identifies the category of an error returned by the backend
+type ErrorCode string
+
+const (
+	// The request was malformed (e.g. a missing query parameter or an invalid body)
+	ErrorCodeBadRequest ErrorCode = "bad_request"
+	// The user or device referenced by the request is not registered with the backend
+	ErrorCodeUnknownUser ErrorCode = "unknown_user"
+	// The backend has reached its configured maximum number of users
+	ErrorCodeTooManyUsers ErrorCode = "too_many_users"
+	// An upstream dependency of the backend (e.g. the OpenAI API) failed
+	ErrorCodeUpstreamFailure ErrorCode = "upstream_failure"
+	// An unexpected error in the backend (e.g. a DB error)
+	ErrorCodeInternal ErrorCode = "internal_error"
+	// The client is older than the minimum version supported by the backend, and must be updated
+	ErrorCodeClientTooOld ErrorCode = "client_too_old"
+	// The request body was compressed with a Content-Encoding that the endpoint doesn't support
+	ErrorCodeUnsupportedEncoding ErrorCode = "unsupported_encoding"
+	// The request to an admin endpoint was missing the admin token, or the admin API is disabled
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+	// The request body or the number of entries in it exceeded the backend's configured limits
+	ErrorCodeRequestTooLarge ErrorCode = "request_too_large"
+	// The user has reached the maximum number of entries that the backend is configured to store for them
+	ErrorCodeQuotaExceeded ErrorCode = "quota_exceeded"
+	// The requested resource (e.g. a shared link) doesn't exist or has expired
+	ErrorCodeNotFound ErrorCode = "not_found"
+)
+
+// Whether a request that failed with this error code may succeed if it is retried later
+func (c ErrorCode) IsRetryable() bool {
+	switch c {
+	case ErrorCodeUpstreamFailure, ErrorCodeInternal:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c ErrorCode) HttpStatusCode() int {
+	switch c {
+	case ErrorCodeBadRequest:
+		return http.StatusBadRequest
+	case ErrorCodeUnknownUser, ErrorCodeNotFound:
+		return http.StatusNotFound
+	case ErrorCodeTooManyUsers:
+		return http.StatusForbidden
+	case ErrorCodeUpstreamFailure:
+		return http.StatusBadGateway
+	case ErrorCodeClientTooOld:
+		return http.StatusUpgradeRequired
+	case ErrorCodeUnsupportedEncoding:
+		return http.StatusUnsupportedMediaType
+	case ErrorCodeUnauthorized:
+		return http.StatusUnauthorized
+	case ErrorCodeRequestTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrorCodeQuotaExceeded:
+		return http.StatusTooManyRequests
+	default:
+		// Note that older clients treat 503 errors as offline errors (see lib.IsOfflineError), so internal errors
+		// must continue to use a 503
+		return http.StatusServiceUnavailable
+	}
+}
+
+// The body of an error response returned by the backend
+type ErrorResponse struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	Retryable bool      `json:"retryable"`
+}
+
+func (e *ErrorResponse) Error() string {
+	return fmt.Sprintf("%s (code=%s)", e.Message, e.Code)
+}
+
+func NewErrorResponse(code ErrorCode, format string, args ...any) *ErrorResponse {
+	return &ErrorResponse{
+		Code:      code,
+		Message:   fmt.Sprintf(format, args...),
+		Retryable: code.IsRetryable(),
+	}
+}
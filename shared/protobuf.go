@@ -0,0 +1,226 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The content type of a protobuf-encoded list of EncHistoryEntry objects. Clients opt into it via the Accept header,
+// and servers that don't support it fall back to JSON.
+const ContentTypeProtobuf = "application/x-protobuf"
+
+// The protobuf schema used for lists of EncHistoryEntry objects. This is encoded by hand via protowire rather than via
+// generated code since the schema is small and this avoids needing protoc as part of the build:
+//
+//	message EncHistoryEntries {
+//	  repeated EncHistoryEntry entries = 1;
+//	}
+//
+//	message EncHistoryEntry {
+//	  bytes enc_data = 1;
+//	  bytes nonce = 2;
+//	  string device_id = 3;
+//	  string user_id = 4;
+//	  // RFC 3339 with nanoseconds, so that the time zone offset round-trips exactly like it does with JSON
+//	  string time = 5;
+//	  string encrypted_id = 6;
+//	  int64 read_count = 7;
+//	  bool is_from_same_device = 8;
+//	  int64 payload_version = 9;
+//	  string hashed_command = 10;
+//	  string hashed_cwd = 11;
+//	  string hashed_hostname = 12;
+//	  int64 sequence_id = 13;
+//	}
+//
+// New fields must only ever be added with new field numbers so that old clients can ignore them.
+const (
+	protoFieldEntries protowire.Number = 1
+
+	protoFieldEncryptedData    protowire.Number = 1
+	protoFieldNonce            protowire.Number = 2
+	protoFieldDeviceId         protowire.Number = 3
+	protoFieldUserId           protowire.Number = 4
+	protoFieldDate             protowire.Number = 5
+	protoFieldEncryptedId      protowire.Number = 6
+	protoFieldReadCount        protowire.Number = 7
+	protoFieldIsFromSameDevice protowire.Number = 8
+	protoFieldPayloadVersion   protowire.Number = 9
+	protoFieldHashedCommand    protowire.Number = 10
+	protoFieldHashedCwd        protowire.Number = 11
+	protoFieldHashedHostname   protowire.Number = 12
+	protoFieldSequenceId       protowire.Number = 13
+)
+
+// Encodes the given entries as a protobuf EncHistoryEntries message
+func MarshalEncHistoryEntriesProto(entries []*EncHistoryEntry) ([]byte, error) {
+	var b []byte
+	for _, entry := range entries {
+		encodedEntry, err := marshalEncHistoryEntryProto(entry)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, protoFieldEntries, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodedEntry)
+	}
+	return b, nil
+}
+
+func marshalEncHistoryEntryProto(entry *EncHistoryEntry) ([]byte, error) {
+	date, err := entry.Date.MarshalText()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode date of entry %#v: %w", entry.EncryptedId, err)
+	}
+	var b []byte
+	b = appendProtoBytes(b, protoFieldEncryptedData, entry.EncryptedData)
+	b = appendProtoBytes(b, protoFieldNonce, entry.Nonce)
+	b = appendProtoBytes(b, protoFieldDeviceId, []byte(entry.DeviceId))
+	b = appendProtoBytes(b, protoFieldUserId, []byte(entry.UserId))
+	b = appendProtoBytes(b, protoFieldDate, date)
+	b = appendProtoBytes(b, protoFieldEncryptedId, []byte(entry.EncryptedId))
+	b = appendProtoVarint(b, protoFieldReadCount, uint64(int64(entry.ReadCount)))
+	b = appendProtoVarint(b, protoFieldIsFromSameDevice, protowire.EncodeBool(entry.IsFromSameDevice))
+	b = appendProtoVarint(b, protoFieldPayloadVersion, uint64(int64(entry.PayloadVersion)))
+	b = appendProtoBytes(b, protoFieldHashedCommand, []byte(entry.HashedCommand))
+	b = appendProtoBytes(b, protoFieldHashedCwd, []byte(entry.HashedCwd))
+	b = appendProtoBytes(b, protoFieldHashedHostname, []byte(entry.HashedHostname))
+	b = appendProtoVarint(b, protoFieldSequenceId, uint64(entry.SequenceId))
+	return b, nil
+}
+
+// Like proto3, default values are omitted from the encoding
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// Decodes a protobuf EncHistoryEntries message as encoded by MarshalEncHistoryEntriesProto
+func UnmarshalEncHistoryEntriesProto(b []byte) ([]*EncHistoryEntry, error) {
+	entries := make([]*EncHistoryEntry, 0)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("failed to decode protobuf entries: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if num != protoFieldEntries || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, fmt.Errorf("failed to skip unknown protobuf field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, fmt.Errorf("failed to decode protobuf entry: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		entry, err := unmarshalEncHistoryEntryProto(v)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func unmarshalEncHistoryEntryProto(b []byte) (*EncHistoryEntry, error) {
+	entry := EncHistoryEntry{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("failed to decode protobuf entry field: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, fmt.Errorf("failed to decode protobuf entry field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+			if err := setProtoBytesField(&entry, num, v); err != nil {
+				return nil, err
+			}
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, fmt.Errorf("failed to decode protobuf entry field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+			setProtoVarintField(&entry, num, v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, fmt.Errorf("failed to skip unknown protobuf field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	return &entry, nil
+}
+
+// Unknown field numbers (e.g. from a newer server) are ignored
+func setProtoBytesField(entry *EncHistoryEntry, num protowire.Number, v []byte) error {
+	switch num {
+	case protoFieldEncryptedData:
+		entry.EncryptedData = append([]byte(nil), v...)
+	case protoFieldNonce:
+		entry.Nonce = append([]byte(nil), v...)
+	case protoFieldDeviceId:
+		entry.DeviceId = string(v)
+	case protoFieldUserId:
+		entry.UserId = string(v)
+	case protoFieldDate:
+		if err := entry.Date.UnmarshalText(v); err != nil {
+			return fmt.Errorf("failed to decode date %#v: %w", string(v), err)
+		}
+	case protoFieldEncryptedId:
+		entry.EncryptedId = string(v)
+	case protoFieldHashedCommand:
+		entry.HashedCommand = string(v)
+	case protoFieldHashedCwd:
+		entry.HashedCwd = string(v)
+	case protoFieldHashedHostname:
+		entry.HashedHostname = string(v)
+	}
+	return nil
+}
+
+func setProtoVarintField(entry *EncHistoryEntry, num protowire.Number, v uint64) {
+	switch num {
+	case protoFieldReadCount:
+		entry.ReadCount = int(int64(v))
+	case protoFieldIsFromSameDevice:
+		entry.IsFromSameDevice = protowire.DecodeBool(v)
+	case protoFieldPayloadVersion:
+		entry.PayloadVersion = int(int64(v))
+	case protoFieldSequenceId:
+		entry.SequenceId = int64(v)
+	}
+}
+
+// Returns whether the given Accept header value allows a protobuf response
+func AcceptsProtobuf(acceptHeader string) bool {
+	for _, mediaRange := range strings.Split(acceptHeader, ",") {
+		mediaType, _, _ := strings.Cut(mediaRange, ";")
+		if strings.TrimSpace(mediaType) == ContentTypeProtobuf {
+			return true
+		}
+	}
+	return false
+}
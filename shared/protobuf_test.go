@@ -0,0 +1,72 @@
+package shared
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestEncHistoryEntriesProtoRoundTrip(t *testing.T) {
+	entries := []*EncHistoryEntry{
+		{
+			EncryptedData:    []byte("encrypted"),
+			Nonce:            []byte("nonce"),
+			DeviceId:         "device",
+			UserId:           "user",
+			Date:             time.Date(2022, 10, 16, 1, 2, 3, 456, time.FixedZone("PDT", -7*60*60)),
+			EncryptedId:      "id",
+			ReadCount:        3,
+			IsFromSameDevice: true,
+			PayloadVersion:   2,
+			HashedCommand:    "hashed-command",
+			HashedCwd:        "hashed-cwd",
+			HashedHostname:   "hashed-hostname",
+			SequenceId:       42,
+		},
+		{DeviceId: "device", Date: time.Unix(0, 0).UTC()},
+	}
+	encoded, err := MarshalEncHistoryEntriesProto(entries)
+	require.NoError(t, err)
+	decoded, err := UnmarshalEncHistoryEntriesProto(encoded)
+	require.NoError(t, err)
+
+	// The decoded entries should be identical to what a JSON round trip produces
+	jsonEncoded, err := json.Marshal(entries)
+	require.NoError(t, err)
+	var jsonDecoded []*EncHistoryEntry
+	require.NoError(t, json.Unmarshal(jsonEncoded, &jsonDecoded))
+	require.Equal(t, jsonDecoded, decoded)
+	require.Less(t, len(encoded), len(jsonEncoded))
+
+	// Empty lists round trip too
+	encoded, err = MarshalEncHistoryEntriesProto(nil)
+	require.NoError(t, err)
+	decoded, err = UnmarshalEncHistoryEntriesProto(encoded)
+	require.NoError(t, err)
+	require.Empty(t, decoded)
+
+	// Unknown fields are skipped so that new fields can be added in the future
+	encoded, err = MarshalEncHistoryEntriesProto(entries[:1])
+	require.NoError(t, err)
+	encoded = protowire.AppendTag(encoded, 2, protowire.VarintType)
+	encoded = protowire.AppendVarint(encoded, 5)
+	decoded, err = UnmarshalEncHistoryEntriesProto(encoded)
+	require.NoError(t, err)
+	require.Len(t, decoded, 1)
+
+	// And truncated messages are rejected
+	_, err = UnmarshalEncHistoryEntriesProto(encoded[:10])
+	require.Error(t, err)
+}
+
+func TestAcceptsProtobuf(t *testing.T) {
+	require.True(t, AcceptsProtobuf(ContentTypeProtobuf))
+	require.True(t, AcceptsProtobuf("application/x-protobuf, application/json;q=0.9"))
+	require.True(t, AcceptsProtobuf("application/json; q=0.9,  application/x-protobuf;q=1"))
+	require.False(t, AcceptsProtobuf(""))
+	require.False(t, AcceptsProtobuf("*/*"))
+	require.False(t, AcceptsProtobuf("application/json"))
+}
@@ -0,0 +1,68 @@
+package shared
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"slices"
+)
+
+// Client features that can be disabled or gradually rolled out via the RemoteConfig
+const (
+	// Suggesting commands via AI for queries that start with `?`
+	FeatureAiSuggestions = "ai-suggestions"
+	// Explaining the highlighted command via AI in the TUI
+	FeatureAiExplain = "ai-explain"
+)
+
+// The config that clients fetch from /api/v1/remote-config (and cache locally) so that risky client features can be
+// rolled out in a controlled way
+type RemoteConfig struct {
+	// A message to display to the user, which replaces /api/v1/banner
+	Banner string `json:"banner"`
+	// The minimum client version (e.g. "v0.300") that the backend supports, or empty if all versions are supported
+	MinClientVersion string `json:"min_client_version,omitempty"`
+	// Features that are disabled for all clients
+	DisabledFeatures []string `json:"disabled_features,omitempty"`
+	// Features that are only enabled for the given percentage (0-100) of users
+	Rollouts map[string]int `json:"rollouts,omitempty"`
+}
+
+// Returns whether the given feature is enabled for the given user. Features are enabled unless they are disabled or
+// have a rollout that doesn't yet include the user.
+func (c *RemoteConfig) IsFeatureEnabled(feature, userId string) bool {
+	if c == nil {
+		return true
+	}
+	if slices.Contains(c.DisabledFeatures, feature) {
+		return false
+	}
+	if percent, ok := c.Rollouts[feature]; ok {
+		return RolloutBucket(feature, userId) < percent
+	}
+	return true
+}
+
+// Returns whether the given client version (e.g. "v0.300") is at least the minimum supported client version. Versions
+// that can't be parsed (e.g. dev builds) are assumed to be supported.
+func (c *RemoteConfig) IsClientVersionSupported(version string) bool {
+	if c == nil || c.MinClientVersion == "" {
+		return true
+	}
+	minVersion, err := ParseVersionString(c.MinClientVersion)
+	if err != nil {
+		return true
+	}
+	pv, err := ParseVersionString(version)
+	if err != nil {
+		return true
+	}
+	return !pv.LessThan(minVersion)
+}
+
+// Deterministically assigns the user to a bucket in [0, 100) for the given feature, so that a rollout includes the
+// same users each time it is checked and increasing the percentage only adds users. The feature is included in the
+// hash so that each rollout includes a different set of users.
+func RolloutBucket(feature, userId string) int {
+	h := sha256.Sum256([]byte(feature + "/" + userId))
+	return int(binary.BigEndian.Uint64(h[:8]) % 100)
+}
@@ -0,0 +1,57 @@
+package shared
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteConfigIsFeatureEnabled(t *testing.T) {
+	var nilConfig *RemoteConfig
+	require.True(t, nilConfig.IsFeatureEnabled(FeatureAiSuggestions, "user"))
+	require.True(t, (&RemoteConfig{}).IsFeatureEnabled(FeatureAiSuggestions, "user"))
+
+	c := RemoteConfig{DisabledFeatures: []string{FeatureAiSuggestions}, Rollouts: map[string]int{FeatureAiExplain: 0, "other": 100}}
+	require.False(t, c.IsFeatureEnabled(FeatureAiSuggestions, "user"))
+	require.False(t, c.IsFeatureEnabled(FeatureAiExplain, "user"))
+	require.True(t, c.IsFeatureEnabled("other", "user"))
+	require.True(t, c.IsFeatureEnabled("unknown", "user"))
+}
+
+func TestRemoteConfigIsClientVersionSupported(t *testing.T) {
+	require.True(t, (&RemoteConfig{}).IsClientVersionSupported("v0.100"))
+	c := RemoteConfig{MinClientVersion: "v0.300"}
+	require.True(t, c.IsClientVersionSupported("v0.300"))
+	require.True(t, c.IsClientVersionSupported("v0.301"))
+	require.True(t, c.IsClientVersionSupported("v1.0"))
+	require.False(t, c.IsClientVersionSupported("v0.299"))
+	require.True(t, c.IsClientVersionSupported("v0.Unknown"))
+}
+
+func TestRolloutBucket(t *testing.T) {
+	// Buckets are stable and roughly uniform, so a 30% rollout includes roughly 30% of users
+	numEnabled := 0
+	c := RemoteConfig{Rollouts: map[string]int{"feature": 30}}
+	for i := 0; i < 1000; i++ {
+		userId := fmt.Sprintf("user-%d", i)
+		bucket := RolloutBucket("feature", userId)
+		require.GreaterOrEqual(t, bucket, 0)
+		require.Less(t, bucket, 100)
+		require.Equal(t, bucket, RolloutBucket("feature", userId))
+		if c.IsFeatureEnabled("feature", userId) {
+			numEnabled += 1
+		}
+	}
+	require.InDelta(t, 300, numEnabled, 60)
+
+	// Different features roll out to different users
+	numDifferent := 0
+	for i := 0; i < 100; i++ {
+		userId := fmt.Sprintf("user-%d", i)
+		if RolloutBucket("feature", userId) != RolloutBucket("other-feature", userId) {
+			numDifferent += 1
+		}
+	}
+	require.Greater(t, numDifferent, 50)
+}
@@ -0,0 +1,59 @@
+package shared
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The content type of a takeout archive, which is a gzipped file containing one JSON-encoded EncHistoryEntry per line.
+// The entries are still encrypted, so the archive can only be read by someone with the user's secret.
+const ContentTypeTakeout = "application/gzip"
+
+// Writes EncHistoryEntry objects to a takeout archive
+type TakeoutWriter struct {
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func NewTakeoutWriter(w io.Writer) *TakeoutWriter {
+	gz := gzip.NewWriter(w)
+	return &TakeoutWriter{gz: gz, enc: json.NewEncoder(gz)}
+}
+
+func (tw *TakeoutWriter) Write(entry *EncHistoryEntry) error {
+	if err := tw.enc.Encode(entry); err != nil {
+		return fmt.Errorf("failed to write entry to takeout archive: %w", err)
+	}
+	return nil
+}
+
+// Flushes the archive, which must be called after the last entry is written
+func (tw *TakeoutWriter) Close() error {
+	return tw.gz.Close()
+}
+
+// Calls fn with each of the entries in the given takeout archive
+func ReadTakeout(r io.Reader, fn func(*EncHistoryEntry) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to decompress takeout archive: %w", err)
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+	for {
+		var entry EncHistoryEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse takeout archive: %w", err)
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+}
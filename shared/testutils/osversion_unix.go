@@ -0,0 +1,19 @@
+//go:build !windows
+
+package testutils
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+func getKernelMajorVersion() string {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		panic(err)
+	}
+
+	version := unix.ByteSliceToString(uts.Release[:])
+	return strings.Split(version, ".")[0]
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package testutils
+
+func getKernelMajorVersion() string {
+	// Windows doesn't have uname, and goldens aren't specific to a Windows version
+	return "windows"
+}
@@ -19,7 +19,6 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
-	"golang.org/x/sys/unix"
 )
 
 const (
@@ -84,6 +83,8 @@ func BackupAndRestoreWithId(t testing.TB, id string) func() {
 		path.Join(homedir, data.GetHishtoryPath(), "config.sh"),
 		path.Join(homedir, data.GetHishtoryPath(), "config.zsh"),
 		path.Join(homedir, data.GetHishtoryPath(), "config.fish"),
+		path.Join(homedir, data.GetHishtoryPath(), "config.ps1"),
+		path.Join(homedir, data.GetHishtoryPath(), "config.nu"),
 		path.Join(homedir, data.GetHishtoryPath(), "hishtory"),
 		path.Join(homedir, ".bash_history"),
 		path.Join(homedir, ".zsh_history"),
@@ -411,11 +412,5 @@ func GetOsVersion(t *testing.T) string {
 	if runtime.GOOS == "linux" {
 		return "actions"
 	}
-	var uts unix.Utsname
-	if err := unix.Uname(&uts); err != nil {
-		panic(err)
-	}
-
-	version := unix.ByteSliceToString(uts.Release[:])
-	return strings.Split(version, ".")[0]
+	return getKernelMajorVersion()
 }